@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/logger"
+	"k8s-log-etl/internal/report"
+)
+
+// cmdBench runs the pipeline over generated records and prints throughput,
+// write latency, queue depth, and allocations, optionally for a grid of
+// worker counts and batch sizes.
+func cmdBench(args []string) error {
+	fs := newFlagSet("bench", "[flags]",
+		"Generate synthetic records in memory, run the full pipeline over them\nwith the configured sink, and print a results table. The stdout sink\nwrites to "+os.DevNull+" so the table stays readable.")
+	loadConfig := configFlags(fs)
+	flagRecords := fs.Int("records", 100000, "number of records to generate")
+	flagMessageBytes := fs.Int("message-bytes", 80, "length of each generated message")
+	flagFields := fs.Int("fields", 5, "extra fields per generated record")
+	flagErrorRate := fs.Float64("error-rate", 0, "fraction of sink writes that fail, retried per the sink retry settings (0-1)")
+	flagSeed := fs.Int64("seed", 1, "generator seed; the same seed produces the same records and failures")
+	flagMatrix := fs.Bool("matrix", false, "sweep --matrix-workers x --matrix-batch-sizes and print a comparison grid")
+	flagMatrixWorkers := fs.String("matrix-workers", "1,2,4,8", "comma-separated worker counts for --matrix")
+	flagMatrixBatches := fs.String("matrix-batch-sizes", "1,100,1000", "comma-separated batch sizes for --matrix")
+	fs.Parse(args)
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if err := config.Validate(cfg); err != nil {
+		return fmt.Errorf("configuration validation failed: %w", err)
+	}
+	gen := genOptions{Records: *flagRecords, MessageBytes: *flagMessageBytes, Fields: *flagFields, Seed: *flagSeed}
+	if gen.Records <= 0 || gen.MessageBytes < 0 || gen.Fields < 0 {
+		return errors.New("--records must be positive and --message-bytes and --fields non-negative")
+	}
+	if *flagErrorRate < 0 || *flagErrorRate > 1 {
+		return fmt.Errorf("--error-rate must be between 0 and 1, got %v", *flagErrorRate)
+	}
+
+	initLogger(cfg)
+	// Per-run info logs would bury the table.
+	if !strings.EqualFold(cfg.LogLevel, "debug") {
+		logger.SetLevel(slog.LevelWarn)
+	}
+	sinkName := cfg.OutputType
+	if cfg.OutputType == "" || cfg.OutputType == "stdout" {
+		sinkName = "stdout (discarded)"
+		cfg.OutputType, cfg.OutputPath = "file", os.DevNull
+	}
+	cfg.ReportPath = os.DevNull
+
+	runs := []benchRun{{Workers: cfg.MaxWorkers, BatchSize: cfg.BatchSize}}
+	if *flagMatrix {
+		workers, err := parseInts(*flagMatrixWorkers)
+		if err != nil {
+			return fmt.Errorf("--matrix-workers: %w", err)
+		}
+		batches, err := parseInts(*flagMatrixBatches)
+		if err != nil {
+			return fmt.Errorf("--matrix-batch-sizes: %w", err)
+		}
+		runs = runs[:0]
+		for _, w := range workers {
+			for _, b := range batches {
+				runs = append(runs, benchRun{Workers: w, BatchSize: b})
+			}
+		}
+	}
+
+	input := generateRecords(gen)
+	ctx := context.Background()
+	results := make([]benchResult, 0, len(runs))
+	for _, run := range runs {
+		res, err := runBench(ctx, input, gen.Records, cfg, run, *flagErrorRate, gen.Seed)
+		if err != nil {
+			return fmt.Errorf("bench workers=%d batch=%d: %w", run.Workers, run.BatchSize, err)
+		}
+		results = append(results, res)
+	}
+
+	fmt.Printf("records: %d, message bytes: %d, fields: %d, error rate: %g, seed: %d, sink: %s\n\n",
+		gen.Records, gen.MessageBytes, gen.Fields, *flagErrorRate, gen.Seed, sinkName)
+	writeBenchTable(os.Stdout, results)
+	if *flagMatrix {
+		fmt.Println()
+		writeBenchGrid(os.Stdout, results)
+	}
+	return nil
+}
+
+// genOptions controls generateRecords.
+type genOptions struct {
+	Records      int
+	MessageBytes int
+	Fields       int
+	Seed         int64
+}
+
+var (
+	benchLevels   = []string{"ERROR", "WARN"}
+	benchServices = []string{"payments", "orders", "checkout", "inventory", "auth"}
+	benchWords    = []string{"request", "failed", "timeout", "user", "retry", "upstream", "db", "cache", "miss", "latency", "exceeded", "connection", "reset"}
+)
+
+// generateRecords returns opts.Records JSONL lines built from opts.Seed
+// alone, so equal options give equal bytes. Levels are WARN or ERROR so
+// the default level filter keeps every record, and timestamps advance by
+// one millisecond per record.
+func generateRecords(opts genOptions) []byte {
+	rng := rand.New(rand.NewSource(opts.Seed))
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var buf bytes.Buffer
+	var msg strings.Builder
+	rec := make(map[string]any, 8+opts.Fields)
+	for i := 0; i < opts.Records; i++ {
+		msg.Reset()
+		for msg.Len() < opts.MessageBytes {
+			if msg.Len() > 0 {
+				msg.WriteByte(' ')
+			}
+			msg.WriteString(benchWords[rng.Intn(len(benchWords))])
+		}
+		service := benchServices[rng.Intn(len(benchServices))]
+		clear(rec)
+		rec["ts"] = base.Add(time.Duration(i) * time.Millisecond).Format(time.RFC3339Nano)
+		rec["level"] = benchLevels[rng.Intn(len(benchLevels))]
+		rec["msg"] = msg.String()[:opts.MessageBytes]
+		rec["service"] = service
+		rec["kubernetes"] = map[string]any{
+			"namespace_name": "bench",
+			"pod_name":       fmt.Sprintf("%s-7d9f8b6c4-%05d", service, rng.Intn(100000)),
+		}
+		rec["trace_id"] = fmt.Sprintf("%016x", rng.Uint64())
+		for f := 0; f < opts.Fields; f++ {
+			rec["field_"+strconv.Itoa(f)] = rng.Intn(1000)
+		}
+		line, _ := json.Marshal(rec) // only strings, ints, and maps
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// benchRun is one pipeline configuration to measure.
+type benchRun struct {
+	Workers   int
+	BatchSize int
+}
+
+// benchResult is what one run measured.
+type benchResult struct {
+	benchRun
+	LinesPerSec     float64
+	P95Write        time.Duration
+	PeakQueue       int
+	AllocsPerRecord float64
+	Written         int
+	Failed          int
+}
+
+// runBench runs the pipeline once over input with run's workers and batch
+// size. errorRate of the sink writes fail, through the faulty sink.
+func runBench(ctx context.Context, input []byte, records int, cfg config.Config, run benchRun, errorRate float64, seed int64) (benchResult, error) {
+	cfg.MaxWorkers, cfg.BatchSize = run.Workers, run.BatchSize
+	if errorRate > 0 && cfg.OutputType != "faulty" {
+		cfg.FaultyInner, cfg.OutputType = cfg.OutputType, "faulty"
+		cfg.FaultyFailRate, cfg.FaultySeed = errorRate, seed
+	}
+	probe := &benchProbe{}
+	rep := report.NewReport()
+	rep.RunID = newRunID()
+
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	err := runPipeline(withBenchProbe(ctx, probe), bytes.NewReader(input), cfg, rep)
+	runtime.ReadMemStats(&after)
+	if err != nil {
+		return benchResult{}, err
+	}
+	return benchResult{
+		benchRun:        run,
+		LinesPerSec:     rep.Throughput,
+		P95Write:        probe.percentile(0.95),
+		PeakQueue:       int(probe.peakQueue.Load()),
+		AllocsPerRecord: float64(after.Mallocs-before.Mallocs) / float64(records),
+		Written:         rep.WrittenOK,
+		Failed:          rep.WriteFailed,
+	}, nil
+}
+
+func writeBenchTable(w io.Writer, results []benchResult) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "workers\tbatch\tlines/sec\tp95 write\tpeak queue\tallocs/record\twritten\tfailed\t")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%d\t%d\t%.0f\t%s\t%d\t%.1f\t%d\t%d\t\n",
+			r.Workers, r.BatchSize, r.LinesPerSec, r.P95Write, r.PeakQueue, r.AllocsPerRecord, r.Written, r.Failed)
+	}
+	tw.Flush()
+}
+
+// writeBenchGrid prints lines/sec with a row per worker count and a column
+// per batch size, in the order they were given.
+func writeBenchGrid(w io.Writer, results []benchResult) {
+	var workers, batches []int
+	cell := make(map[benchRun]float64, len(results))
+	for _, r := range results {
+		if !slices.Contains(workers, r.Workers) {
+			workers = append(workers, r.Workers)
+		}
+		if !slices.Contains(batches, r.BatchSize) {
+			batches = append(batches, r.BatchSize)
+		}
+		cell[r.benchRun] = r.LinesPerSec
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprint(tw, "lines/sec\t")
+	for _, b := range batches {
+		fmt.Fprintf(tw, "batch %d\t", b)
+	}
+	fmt.Fprintln(tw)
+	for _, wk := range workers {
+		fmt.Fprintf(tw, "workers %d\t", wk)
+		for _, b := range batches {
+			fmt.Fprintf(tw, "%.0f\t", cell[benchRun{Workers: wk, BatchSize: b}])
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+}
+
+// parseInts parses a comma-separated list of non-negative integers.
+func parseInts(s string) ([]int, error) {
+	var out []int
+	for _, p := range config.ParseList(s) {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid count %q", p)
+		}
+		out = append(out, n)
+	}
+	if len(out) == 0 {
+		return nil, errors.New("empty list")
+	}
+	return out, nil
+}
+
+// benchProbe collects what the bench command measures from inside
+// runPipeline. A nil *benchProbe is valid and ignores every call, so the
+// pipeline can report unconditionally.
+type benchProbe struct {
+	peakQueue atomic.Int64
+	mu        sync.Mutex
+	writes    []time.Duration
+}
+
+// WriteStart starts a write timing; it is the zero time when p is nil.
+func (p *benchProbe) WriteStart() time.Time {
+	if p == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// WriteDone records the latency of a write that began at start, retries
+// included.
+func (p *benchProbe) WriteDone(start time.Time) {
+	if p == nil {
+		return
+	}
+	d := time.Since(start)
+	p.mu.Lock()
+	p.writes = append(p.writes, d)
+	p.mu.Unlock()
+}
+
+// QueueDepth records the queue length after an enqueue.
+func (p *benchProbe) QueueDepth(n int) {
+	if p == nil {
+		return
+	}
+	for {
+		peak := p.peakQueue.Load()
+		if int64(n) <= peak || p.peakQueue.CompareAndSwap(peak, int64(n)) {
+			return
+		}
+	}
+}
+
+// percentile returns the q-th quantile of the recorded write latencies.
+func (p *benchProbe) percentile(q float64) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.writes) == 0 {
+		return 0
+	}
+	slices.Sort(p.writes)
+	return p.writes[int(q*float64(len(p.writes)-1))]
+}
+
+type benchProbeKey struct{}
+
+// withBenchProbe attaches p to ctx for runPipeline.
+func withBenchProbe(ctx context.Context, p *benchProbe) context.Context {
+	return context.WithValue(ctx, benchProbeKey{}, p)
+}
+
+// benchProbeFrom returns the bench probe attached to ctx, or nil.
+func benchProbeFrom(ctx context.Context) *benchProbe {
+	p, _ := ctx.Value(benchProbeKey{}).(*benchProbe)
+	return p
+}
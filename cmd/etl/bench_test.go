@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"k8s-log-etl/internal/config"
+)
+
+func TestGenerateRecords(t *testing.T) {
+	opts := genOptions{Records: 50, MessageBytes: 40, Fields: 3, Seed: 7}
+	a, b := generateRecords(opts), generateRecords(opts)
+	if !bytes.Equal(a, b) {
+		t.Fatal("the same seed produced different records")
+	}
+	opts.Seed = 8
+	if bytes.Equal(a, generateRecords(opts)) {
+		t.Error("different seeds produced the same records")
+	}
+
+	sc := bufio.NewScanner(bytes.NewReader(a))
+	lines := 0
+	for sc.Scan() {
+		lines++
+		var rec map[string]any
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatalf("line %d: %v", lines, err)
+		}
+		if msg, _ := rec["msg"].(string); len(msg) != 40 {
+			t.Errorf("line %d: msg length %d, want 40", lines, len(msg))
+		}
+		if _, ok := rec["field_2"]; !ok {
+			t.Errorf("line %d: missing field_2: %v", lines, rec)
+		}
+	}
+	if lines != 50 {
+		t.Errorf("got %d lines, want 50", lines)
+	}
+}
+
+func TestRunBench(t *testing.T) {
+	cfg := config.Default()
+	cfg.OutputType, cfg.OutputPath = "file", filepath.Join(t.TempDir(), "out.jsonl")
+	cfg.ReportPath = filepath.Join(t.TempDir(), "report.json")
+	cfg.SinkMaxRetries, cfg.SinkBackoffBaseMS = 0, 1
+	input := generateRecords(genOptions{Records: 200, MessageBytes: 20, Seed: 1})
+
+	res, err := runBench(context.Background(), input, 200, cfg, benchRun{Workers: 2, BatchSize: 10}, 0.5, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Written+res.Failed != 200 || res.Failed == 0 || res.Written == 0 {
+		t.Errorf("written %d, failed %d; want both non-zero, 200 in total", res.Written, res.Failed)
+	}
+	if res.LinesPerSec <= 0 || res.PeakQueue == 0 || res.AllocsPerRecord <= 0 {
+		t.Errorf("unexpected result %+v", res)
+	}
+}
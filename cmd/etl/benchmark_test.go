@@ -2,11 +2,13 @@ package main
 
 import (
 	"context"
+	"os"
 	"strings"
 	"testing"
 
 	"k8s-log-etl/internal/config"
 	"k8s-log-etl/internal/report"
+	"k8s-log-etl/internal/sink"
 )
 
 func BenchmarkPipeline_NoBatching(b *testing.B) {
@@ -71,3 +73,33 @@ func BenchmarkPipeline_MultipleWorkers(b *testing.B) {
 	}
 }
 
+// benchmarkExampleFixture runs the example fixture, repeated, through the
+// pipeline, with json_schema_file set to schema unless it is empty, to
+// show what validation adds to a run.
+func benchmarkExampleFixture(b *testing.B, schema string) {
+	fixture, err := os.ReadFile("../../examples/k8s_logs.jsonl")
+	if err != nil {
+		b.Fatal(err)
+	}
+	input := strings.Repeat(string(fixture), 200)
+
+	cfg := config.Default()
+	cfg.JSONSchemaFile = schema
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rep := report.NewReport()
+		ctx := withBaseSink(context.Background(), sink.NewMemorySink())
+		if err := runPipeline(ctx, strings.NewReader(input), cfg, rep); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPipeline_ExampleFixture(b *testing.B) {
+	benchmarkExampleFixture(b, "")
+}
+
+func BenchmarkPipeline_ExampleFixtureWithSchema(b *testing.B) {
+	benchmarkExampleFixture(b, "../../examples/k8s_logs.schema.json")
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// command is a CLI subcommand.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+// commands lists the subcommands in help order. "run" is the default when the
+// first argument is a flag or absent.
+var commands = []command{
+	{"run", "run the ETL pipeline (default)", cmdRun},
+	{"validate", "validate the effective configuration and exit", cmdValidate},
+	{"replay", "re-send dead-lettered records to the configured sink", cmdReplay},
+	{"inspect", "print the normalized form of the first records of a file", cmdInspect},
+	{"test", "compare the records an input produces with an expected file", cmdTest},
+	{"lookup", "print the records of a trace ID through an output's trace index", cmdLookup},
+	{"bench", "benchmark the pipeline on generated records", cmdBench},
+	{"transforms", "list the registered transforms and the config they read", cmdTransforms},
+	{"sinks", "list the output types and the config they read", cmdSinks},
+}
+
+// dispatch routes args to a subcommand.
+func dispatch(args []string) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return cmdRun(args)
+	}
+	if args[0] == "help" {
+		if len(args) > 1 {
+			for _, c := range commands {
+				if c.name == args[1] {
+					return c.run([]string{"-h"})
+				}
+			}
+			return fmt.Errorf("unknown command %q", args[1])
+		}
+		printUsage()
+		return nil
+	}
+	for _, c := range commands {
+		if c.name == args[0] {
+			return c.run(args[1:])
+		}
+	}
+	printUsage()
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+// printUsage writes top-level help listing the subcommands.
+func printUsage() {
+	w := os.Stderr
+	fmt.Fprintln(w, "Usage: etl <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-11s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run 'etl <command> -h' for command flags. Without a command, 'run' is assumed.")
+}
+
+// newFlagSet builds a subcommand flag set with help text.
+func newFlagSet(name, argsUsage, description string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		w := fs.Output()
+		fmt.Fprintf(w, "Usage: etl %s %s\n\n%s\n\nFlags:\n", name, argsUsage, description)
+		fs.PrintDefaults()
+	}
+	return fs
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"k8s-log-etl/internal/plugins"
+	"k8s-log-etl/internal/sink"
+)
+
+// cmdTransforms lists the registered transforms.
+func cmdTransforms(args []string) error {
+	fs := newFlagSet("transforms", "[flags]",
+		"List the transforms that can be named in --transforms, what each does,\nand the config keys it reads.")
+	flagJSON := fs.Bool("json", false, "print a JSON array instead of a table")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return errors.New("transforms takes no arguments")
+	}
+	infos := plugins.Registered()
+	if *flagJSON {
+		return printJSON(os.Stdout, infos)
+	}
+	rows := make([][3]string, len(infos))
+	for i, info := range infos {
+		rows[i] = [3]string{info.Name, info.Description, strings.Join(info.ConfigKeys, ", ")}
+	}
+	return printTable(os.Stdout, rows)
+}
+
+// cmdSinks lists the output types.
+func cmdSinks(args []string) error {
+	fs := newFlagSet("sinks", "[flags]",
+		"List the output types that can be given to --output-type, what each\ndoes, and the config keys it reads.")
+	flagJSON := fs.Bool("json", false, "print a JSON array instead of a table")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return errors.New("sinks takes no arguments")
+	}
+	if *flagJSON {
+		return printJSON(os.Stdout, sink.Types)
+	}
+	rows := make([][3]string, len(sink.Types))
+	for i, t := range sink.Types {
+		name := t.Name
+		if len(t.Aliases) > 0 {
+			name += " (" + strings.Join(t.Aliases, ", ") + ")"
+		}
+		rows[i] = [3]string{name, t.Description, strings.Join(t.ConfigKeys, ", ")}
+	}
+	return printTable(os.Stdout, rows)
+}
+
+// printTable writes rows of name, description, and config keys under a
+// header.
+func printTable(w io.Writer, rows [][3]string) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tDESCRIPTION\tCONFIG KEYS")
+	for _, r := range rows {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", r[0], r[1], r[2])
+	}
+	return tw.Flush()
+}
+
+func printJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/plugins"
+	"k8s-log-etl/internal/sink"
+)
+
+// TestDescribedConfigKeysExist keeps the keys listed by `etl transforms`
+// and `etl sinks` in step with the config.
+func TestDescribedConfigKeysExist(t *testing.T) {
+	known := make(map[string]bool)
+	typ := reflect.TypeOf(config.Config{})
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		for _, tag := range []string{f.Tag.Get("json"), f.Tag.Get("secret")} {
+			if key, _, _ := strings.Cut(tag, ","); key != "" && key != "-" {
+				known[key] = true
+			}
+		}
+	}
+	for _, info := range plugins.Registered() {
+		if strings.HasPrefix(info.Name, "test_") {
+			continue
+		}
+		if info.Description == "" {
+			t.Errorf("transform %s has no description", info.Name)
+		}
+		for _, key := range info.ConfigKeys {
+			if !known[key] {
+				t.Errorf("transform %s lists unknown config key %q", info.Name, key)
+			}
+		}
+	}
+	for _, st := range sink.Types {
+		for _, key := range st.ConfigKeys {
+			if !known[key] {
+				t.Errorf("sink %s lists unknown config key %q", st.Name, key)
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/logger"
+	"k8s-log-etl/internal/sink"
+)
+
+// errDLQOverflow stops a run that reached dlq_max_records or dlq_max_bytes
+// with dlq_overflow_policy abort.
+var errDLQOverflow = errors.New("dlq overflow")
+
+// deadLetters is the DLQ shared by the pipeline's goroutines. It counts the
+// entries and bytes written so a long sink outage cannot turn the DLQ into
+// a copy of the traffic: once dlq_max_records or dlq_max_bytes is reached
+// no further entry is written.
+type deadLetters struct {
+	lockedWriter
+	maxRecords int
+	maxBytes   int64
+	abort      bool
+	enc        sink.JSONEncoding // the sink's, for sizing entries
+
+	mu      sync.Mutex
+	records int
+	bytes   int64
+	full    bool
+}
+
+func newDeadLetters(w sink.Writer, cfg config.Config) *deadLetters {
+	return &deadLetters{
+		lockedWriter: lockedWriter{w: w},
+		maxRecords:   cfg.DLQMaxRecords,
+		maxBytes:     cfg.DLQMaxBytes,
+		abort:        strings.EqualFold(cfg.DLQOverflowPolicy, config.DLQOverflowAbort),
+		enc:          dlqEncoding(cfg),
+	}
+}
+
+// admit reports whether rec still fits under the caps, counting it if so.
+// The first entry that does not fit is logged; later ones are not.
+func (d *deadLetters) admit(ctx context.Context, rec dlqRecord) bool {
+	size := int64(0)
+	if d.maxBytes > 0 {
+		// The sink writes the same encoding plus a newline.
+		data, _ := d.enc.Marshal(rec)
+		size = int64(len(data)) + 1
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.full {
+		return false
+	}
+	if d.maxRecords > 0 && d.records >= d.maxRecords || d.maxBytes > 0 && d.bytes+size > d.maxBytes {
+		d.full = true
+		policy := config.DLQOverflowDrop
+		if d.abort {
+			policy = config.DLQOverflowAbort
+		}
+		logger.WarnContext(ctx, "dlq limit reached, no further entries are written", "records", d.records, "bytes", d.bytes,
+			"max_records", d.maxRecords, "max_bytes", d.maxBytes, "policy", policy)
+		return false
+	}
+	d.records++
+	d.bytes += size
+	return true
+}
+
+// overflowErr is what a caller returns for an entry admit refused: nil
+// under the drop policy, an errDLQOverflow error under abort.
+func (d *deadLetters) overflowErr() error {
+	if !d.abort {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return fmt.Errorf("%w: %d entries, %d bytes written (dlq_overflow_policy=abort)", errDLQOverflow, d.records, d.bytes)
+}
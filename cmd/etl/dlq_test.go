@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/report"
+	"k8s-log-etl/internal/sink"
+)
+
+func dlqLimitConfig(t *testing.T) config.Config {
+	cfg := config.Default()
+	cfg.MaxWorkers = 1
+	cfg.BatchSize = 0
+	cfg.SinkMaxRetries = 0
+	cfg.DLQPath = filepath.Join(t.TempDir(), "dlq.jsonl")
+	cfg.ReportPath = filepath.Join(t.TempDir(), "report.json")
+	return cfg
+}
+
+func dlqLimitInput(n int) string {
+	return strings.Repeat(`{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"boom","service":"api"}`+"\n", n)
+}
+
+func TestRunPipeline_DLQMaxRecordsDrops(t *testing.T) {
+	cfg := dlqLimitConfig(t)
+	cfg.DLQMaxRecords = 3
+	rep := report.NewReport()
+	ctx := withBaseSink(context.Background(), &failingWriter{})
+	if err := runPipeline(ctx, strings.NewReader(dlqLimitInput(10)), cfg, rep); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+	if rep.WriteFailed != 10 || rep.DLQWritten != 3 || rep.DLQOverflow != 7 {
+		t.Errorf("failed %d, dlq_written %d, dlq_overflow %d; want 10, 3, 7", rep.WriteFailed, rep.DLQWritten, rep.DLQOverflow)
+	}
+	data, err := os.ReadFile(cfg.DLQPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(data, []byte("\n")); n != 3 {
+		t.Errorf("DLQ has %d entries, want 3", n)
+	}
+}
+
+func TestRunPipeline_DLQMaxBytesCountsEncodedEntries(t *testing.T) {
+	cfg := dlqLimitConfig(t)
+	cfg.DLQMaxBytes = 1000
+	rep := report.NewReport()
+	ctx := withBaseSink(context.Background(), &failingWriter{})
+	if err := runPipeline(ctx, strings.NewReader(dlqLimitInput(50)), cfg, rep); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+	info, err := os.Stat(cfg.DLQPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > cfg.DLQMaxBytes || rep.DLQWritten == 0 || rep.DLQWritten+rep.DLQOverflow != 50 {
+		t.Errorf("DLQ %d bytes, dlq_written %d, dlq_overflow %d", info.Size(), rep.DLQWritten, rep.DLQOverflow)
+	}
+}
+
+func TestRunPipeline_DLQOutputEncoding(t *testing.T) {
+	cfg := dlqLimitConfig(t)
+	off := false
+	cfg.OutputEscapeHTML = &off
+	cfg.OutputSortKeys = true
+	cfg.OutputIndent = 2
+	rep := report.NewReport()
+	ctx := withBaseSink(context.Background(), &failingWriter{})
+	in := `{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"<b> & </b>","service":"api"}` + "\n"
+	if err := runPipeline(ctx, strings.NewReader(in+in), cfg, rep); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+	data, err := os.ReadFile(cfg.DLQPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The indent is not applied: replay reads one entry per line.
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("DLQ has %d lines, want 2:\n%s", len(lines), data)
+	}
+	if !strings.Contains(lines[0], `"Message":"<b> & </b>"`) || strings.Contains(lines[0], `\u003c`) {
+		t.Errorf("DLQ entry %s, want <, >, and & unescaped", lines[0])
+	}
+	if !strings.HasPrefix(lines[0], `{"attempts":1,"error":`) {
+		t.Errorf("DLQ entry %s, want sorted keys", lines[0])
+	}
+}
+
+func TestRunPipeline_DLQOverflowAbort(t *testing.T) {
+	cfg := dlqLimitConfig(t)
+	cfg.DLQMaxRecords = 2
+	cfg.DLQOverflowPolicy = config.DLQOverflowAbort
+	cfg.QueueSize = 4
+	rep := report.NewReport()
+	ctx := withBaseSink(context.Background(), &failingWriter{})
+	err := runPipeline(ctx, strings.NewReader(dlqLimitInput(1000)), cfg, rep)
+	if !errors.Is(err, errDLQOverflow) {
+		t.Fatalf("err = %v, want errDLQOverflow", err)
+	}
+	if rep.DLQWritten != 2 || rep.DLQOverflow == 0 || rep.Shutdown.Reason != report.StopError || rep.Shutdown.LinesNotEnqueued == 0 {
+		t.Errorf("dlq_written %d, dlq_overflow %d, shutdown %+v", rep.DLQWritten, rep.DLQOverflow, rep.Shutdown)
+	}
+}
+
+// payloadTestLine is wrapped by a log shipper and carries a key that only
+// the replay run redacts.
+const payloadTestLine = `{"log":"{\"ts\":\"2024-01-01T12:00:00Z\",\"level\":\"ERROR\",\"msg\":\"boom\",\"service\":\"api\",\"token\":\"secret\"}\n","stream":"stderr"}`
+
+// runPayloadPipeline dead-letters payloadTestLine under payload and returns
+// the DLQ file and its one entry.
+func runPayloadPipeline(t *testing.T, payload string) (string, map[string]json.RawMessage) {
+	t.Helper()
+	cfg := dlqLimitConfig(t)
+	cfg.DLQPayload = payload
+	cfg.UnwrapKeys = []string{"log"}
+	cfg.RedactKeys = nil
+	rep := report.NewReport()
+	ctx := withBaseSink(context.Background(), &failingWriter{})
+	if err := runPipeline(ctx, strings.NewReader(payloadTestLine+"\n"), cfg, rep); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+	data, err := os.ReadFile(cfg.DLQPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry map[string]json.RawMessage
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatalf("DLQ entry %s: %v", data, err)
+	}
+	return cfg.DLQPath, entry
+}
+
+func TestRunPipeline_DLQPayload(t *testing.T) {
+	for _, tc := range []struct {
+		payload     string
+		record, raw bool
+	}{
+		{"", true, false},
+		{config.DLQPayloadNormalized, true, false},
+		{config.DLQPayloadRaw, false, true},
+		{config.DLQPayloadBoth, true, true},
+	} {
+		_, entry := runPayloadPipeline(t, tc.payload)
+		if _, ok := entry["record"]; ok != tc.record {
+			t.Errorf("payload %q: has record %v, want %v", tc.payload, ok, tc.record)
+		}
+		if raw, ok := entry["raw"]; ok != tc.raw {
+			t.Errorf("payload %q: has raw %v, want %v", tc.payload, ok, tc.raw)
+		} else if ok && string(raw) != payloadTestLine {
+			t.Errorf("payload %q: raw %s, want the input line before unwrap", tc.payload, raw)
+		}
+		for key, want := range map[string]string{"stage": `"sink"`, "reason": `"write_error"`, "attempts": "1", "line": "1"} {
+			if got := string(entry[key]); got != want {
+				t.Errorf("payload %q: %s = %s, want %s", tc.payload, key, got, want)
+			}
+		}
+		var failedAt string
+		if err := json.Unmarshal(entry["failed_at"], &failedAt); err != nil || failedAt == "" || entry["run_id"] == nil || entry["error"] == nil {
+			t.Errorf("payload %q: envelope incomplete: %v", tc.payload, entry)
+		}
+	}
+}
+
+func TestRunPipeline_DLQPayloadNormalizeFailureKeepsRaw(t *testing.T) {
+	cfg := dlqLimitConfig(t)
+	cfg.DLQPayload = config.DLQPayloadBoth
+	cfg.DLQNormalizeFailures = true
+	line := `{"level":"ERROR","msg":"no timestamp"}`
+	ctx := withBaseSink(context.Background(), sink.NewMemorySink())
+	if err := runPipeline(ctx, strings.NewReader(line+"\n"), cfg, report.NewReport()); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+	data, err := os.ReadFile(cfg.DLQPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry map[string]json.RawMessage
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatal(err)
+	}
+	if string(entry["raw"]) != line || entry["record"] != nil || string(entry["stage"]) != `"normalize"` {
+		t.Errorf("normalize failure entry %s", data)
+	}
+}
+
+func TestReplayDLQ_PicksEntryPointByPayload(t *testing.T) {
+	for _, tc := range []struct {
+		payload      string
+		renormalized bool
+	}{
+		// Raw input runs the pipeline again, so the replay's redaction
+		// applies; the normalized record of a write failure is written
+		// as it failed.
+		{config.DLQPayloadRaw, true},
+		{config.DLQPayloadBoth, false},
+		{config.DLQPayloadNormalized, false},
+	} {
+		path, _ := runPayloadPipeline(t, tc.payload)
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg := config.Default()
+		cfg.UnwrapKeys = []string{"log"}
+		cfg.RedactKeys = []string{"token"}
+		mem := sink.NewMemorySink()
+		rep := report.NewReport()
+		err = replayDLQ(withBaseSink(context.Background(), mem), f, cfg, rep)
+		f.Close()
+		if err != nil {
+			t.Fatalf("payload %q: replayDLQ: %v", tc.payload, err)
+		}
+		records := mem.Records()
+		if len(records) != 1 || !bytes.Contains(records[0], []byte(`"boom"`)) {
+			t.Fatalf("payload %q: replayed %q", tc.payload, records)
+		}
+		if redacted := !bytes.Contains(records[0], []byte(`"secret"`)); redacted != tc.renormalized {
+			t.Errorf("payload %q: replayed %s, want normalized again %v", tc.payload, records[0], tc.renormalized)
+		}
+	}
+}
+
+func TestRunPipeline_StrictJSONDuplicateKeys(t *testing.T) {
+	input := strings.Join([]string{
+		`{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"clean","service":"api"}`,
+		`{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"twice","service":"api","level":"WARN"}`,
+		`{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"nested","service":"api","kubernetes":{"pod":"a","pod":"b"}}`,
+	}, "\n") + "\n"
+	for _, tt := range []struct {
+		name             string
+		dlq              bool
+		written, entries int
+	}{
+		{name: "count", written: 3},
+		{name: "dead-letter", dlq: true, written: 1, entries: 2},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := dlqLimitConfig(t)
+			cfg.FilterLevels = []string{"ERROR", "WARN"}
+			cfg.StrictJSON = true
+			cfg.DLQDuplicateKeys = tt.dlq
+			w := &flakyWriter{}
+			rep := report.NewReport()
+			if err := runPipeline(withBaseSink(context.Background(), w), strings.NewReader(input), cfg, rep); err != nil {
+				t.Fatalf("runPipeline: %v", err)
+			}
+			if len(w.messages()) != tt.written {
+				t.Errorf("wrote %v, want %d records", w.messages(), tt.written)
+			}
+			d := rep.DuplicateKeys
+			if d == nil || d.Lines != 2 || d.ByKey["level"] != 1 || d.ByKey["kubernetes.pod"] != 1 || d.DeadLettered != tt.entries {
+				t.Fatalf("duplicate keys %+v", d)
+			}
+			data, _ := os.ReadFile(cfg.DLQPath)
+			lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+			if tt.entries == 0 {
+				if len(data) != 0 {
+					t.Errorf("DLQ has %q, want nothing", data)
+				}
+				return
+			}
+			if len(lines) != tt.entries {
+				t.Fatalf("DLQ has %d entries, want %d", len(lines), tt.entries)
+			}
+			var entry struct {
+				Reason string          `json:"reason"`
+				Stage  string          `json:"stage"`
+				Raw    json.RawMessage `json:"raw"`
+			}
+			if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+				t.Fatal(err)
+			}
+			// The raw line keeps both values of the key.
+			if entry.Reason != "duplicate_key:level" || entry.Stage != dlqStageParse || !bytes.Contains(entry.Raw, []byte(`"level":"WARN"`)) {
+				t.Errorf("entry %+v, raw %s", entry, entry.Raw)
+			}
+			if rep.DLQReasons["duplicate_key:kubernetes.pod"] != 1 {
+				t.Errorf("dlq reasons %v", rep.DLQReasons)
+			}
+		})
+	}
+
+	// Without strict_json there is no scan and no report section.
+	cfg := dlqLimitConfig(t)
+	rep := report.NewReport()
+	if err := runPipeline(withBaseSink(context.Background(), &flakyWriter{}), strings.NewReader(input), cfg, rep); err != nil {
+		t.Fatal(err)
+	}
+	if rep.DuplicateKeys != nil {
+		t.Errorf("duplicate keys %+v without strict_json", rep.DuplicateKeys)
+	}
+}
+
+func TestRunPipeline_JSONSchema(t *testing.T) {
+	input := strings.Join([]string{
+		`{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"valid","service":"api","status":500}`,
+		`{"ts":"2024-01-01T12:00:00Z","level":"ERROR","service":"api"}`,
+		`{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"bad status","service":"api","status":"500"}`,
+		`{"ts":"2024-01-01T12:00:00Z","level":"LOUD","msg":"bad level","service":"api","status":"500"}`,
+	}, "\n") + "\n"
+	for _, tt := range []struct {
+		action                    string
+		written, dropped, entries int
+	}{
+		{action: "", written: 3}, // the record without a message fails normalization
+		{action: config.SchemaDrop, written: 1, dropped: 3},
+		{action: config.SchemaDLQ, written: 1, entries: 3},
+	} {
+		t.Run("action="+tt.action, func(t *testing.T) {
+			cfg := dlqLimitConfig(t)
+			cfg.FilterLevels = nil
+			cfg.JSONSchemaFile = "../../examples/k8s_logs.schema.json"
+			cfg.SchemaAction = tt.action
+			if err := config.Validate(cfg); err != nil {
+				t.Fatal(err)
+			}
+			w := &flakyWriter{}
+			rep := report.NewReport()
+			if err := runPipeline(withBaseSink(context.Background(), w), strings.NewReader(input), cfg, rep); err != nil {
+				t.Fatalf("runPipeline: %v", err)
+			}
+			if len(w.messages()) != tt.written {
+				t.Errorf("wrote %v, want %d records", w.messages(), tt.written)
+			}
+			s := rep.SchemaValidation
+			want := map[string]int{"required at /msg": 1, "required at /message": 1, "type at /status": 2, "enum at /level": 1}
+			if s == nil || s.Checked != 4 || s.Invalid != 3 || s.Dropped != tt.dropped || s.DeadLettered != tt.entries || !maps.Equal(s.ByViolation, want) {
+				t.Fatalf("schema validation %+v", s)
+			}
+			data, _ := os.ReadFile(cfg.DLQPath)
+			if tt.entries == 0 {
+				if len(data) != 0 {
+					t.Errorf("DLQ has %q, want nothing", data)
+				}
+				return
+			}
+			lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+			if len(lines) != tt.entries {
+				t.Fatalf("DLQ has %d entries, want %d", len(lines), tt.entries)
+			}
+			var entry struct {
+				Reason string          `json:"reason"`
+				Stage  string          `json:"stage"`
+				Error  string          `json:"error"`
+				Raw    json.RawMessage `json:"raw"`
+			}
+			if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+				t.Fatal(err)
+			}
+			if entry.Reason != "schema" || entry.Stage != dlqStageSchema || !strings.Contains(entry.Error, "missing propert") || len(entry.Raw) == 0 {
+				t.Errorf("entry %+v, raw %s", entry, entry.Raw)
+			}
+			if rep.DLQReasons["schema"] != 3 {
+				t.Errorf("dlq reasons %v", rep.DLQReasons)
+			}
+		})
+	}
+}
+
+func TestRunPipeline_JSONSchemaCapsViolations(t *testing.T) {
+	schema := filepath.Join(t.TempDir(), "schema.json")
+	if err := os.WriteFile(schema, []byte(`{"additionalProperties":{"type":"string"}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var input strings.Builder
+	for i := range report.MaxSchemaViolations + 20 {
+		fmt.Fprintf(&input, `{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"m","f%d":1}`+"\n", i)
+	}
+	cfg := dlqLimitConfig(t)
+	cfg.JSONSchemaFile = schema
+	rep := report.NewReport()
+	if err := runPipeline(withBaseSink(context.Background(), sink.NewMemorySink()), strings.NewReader(input.String()), cfg, rep); err != nil {
+		t.Fatal(err)
+	}
+	s := rep.SchemaValidation
+	if len(s.ByViolation) != report.MaxSchemaViolations || s.OtherViolations != 20 || s.Invalid != report.MaxSchemaViolations+20 {
+		t.Errorf("%d violations kept, %d other, %d invalid", len(s.ByViolation), s.OtherViolations, s.Invalid)
+	}
+}
+
+func TestRunPipeline_JSONSchemaCompileErrorFailsStartup(t *testing.T) {
+	schema := filepath.Join(t.TempDir(), "schema.json")
+	if err := os.WriteFile(schema, []byte(`{"type":"nonsense"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := dlqLimitConfig(t)
+	cfg.JSONSchemaFile = schema
+	mem := sink.NewMemorySink()
+	err := runPipeline(withBaseSink(context.Background(), mem), strings.NewReader(dlqLimitInput(1)), cfg, report.NewReport())
+	if err == nil || !strings.Contains(err.Error(), schema) {
+		t.Fatalf("err = %v, want a compile error naming %s", err, schema)
+	}
+	if len(mem.Records()) != 0 {
+		t.Errorf("wrote %d records with a broken schema", len(mem.Records()))
+	}
+	if err := preflight(context.Background(), cfg); err == nil || !strings.Contains(err.Error(), schema) {
+		t.Errorf("preflight = %v, want the compile error", err)
+	}
+}
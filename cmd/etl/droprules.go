@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+
+	"k8s-log-etl/internal/logger"
+	"k8s-log-etl/internal/report"
+	"k8s-log-etl/internal/stages"
+)
+
+// dropRuleSet holds the compiled drop_rules_file. A reload swaps in the new
+// rules while the pipeline keeps matching, so a file that fails to load
+// leaves the previous rules in use.
+type dropRuleSet struct {
+	path    string
+	rules   atomic.Pointer[stages.DropRules]
+	reloads atomic.Int64
+	rep     *report.Report
+}
+
+// loadDropRuleSet reads the rules at path. A missing or invalid file is an
+// error; an empty one drops nothing.
+func loadDropRuleSet(path string, rep *report.Report) (*dropRuleSet, error) {
+	rules, err := stages.LoadDropRules(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &dropRuleSet{path: path, rep: rep}
+	s.rules.Store(rules)
+	s.publish()
+	return s, nil
+}
+
+// match reports whether a rule matches msg, counting the hit.
+func (s *dropRuleSet) match(msg string) bool {
+	_, ok := s.rules.Load().Match(msg)
+	return ok
+}
+
+// reload reads the file again, keeping the hits of unchanged rules.
+func (s *dropRuleSet) reload(ctx context.Context) {
+	rules, err := stages.LoadDropRules(s.path)
+	if err != nil {
+		logger.ErrorContext(ctx, "drop rules reload failed, keeping the current rules", "error", err)
+		return
+	}
+	rules.Inherit(s.rules.Swap(rules))
+	s.reloads.Add(1)
+	s.publish()
+	logger.InfoContext(ctx, "drop rules reloaded", "path", s.path, "rules", rules.Len())
+}
+
+// watch reloads on every signal from sig until ctx is done.
+func (s *dropRuleSet) watch(ctx context.Context, sig <-chan os.Signal) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sig:
+			s.reload(ctx)
+		}
+	}
+}
+
+// publish copies the rules and their hits into the report.
+func (s *dropRuleSet) publish() {
+	rules := s.rules.Load().Stats()
+	stats := report.DropRuleStats{File: s.path, Reloads: int(s.reloads.Load()), Rules: make([]report.DropRuleHit, 0, len(rules))}
+	for _, r := range rules {
+		stats.Rules = append(stats.Rules, report.DropRuleHit{Line: r.Line, Pattern: r.Pattern, Hits: r.Hits})
+	}
+	s.rep.SetDropRules(stats)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/report"
+	"k8s-log-etl/internal/sink"
+)
+
+func TestRunPipeline_DropRules(t *testing.T) {
+	dir := t.TempDir()
+	rules := filepath.Join(dir, "noise.txt")
+	os.WriteFile(rules, []byte("# health checks\n^GET /healthz\nconnection reset\nnever matches\n"), 0o644)
+	input := `{"ts":"2024-01-01T12:00:00Z","level":"INFO","msg":"GET /healthz 200","service":"api"}
+{"ts":"2024-01-01T12:00:01Z","level":"ERROR","msg":"upstream connection reset by peer","service":"api"}
+{"ts":"2024-01-01T12:00:02Z","level":"ERROR","msg":"payment failed","service":"api"}
+{"ts":"2024-01-01T12:00:03Z","level":"INFO","msg":"GET /healthz 200","service":"api"}
+`
+	cfg := config.Default()
+	cfg.DropRulesFile = rules
+	cfg.ReportPath = filepath.Join(dir, "report.json")
+	mem := sink.NewMemorySink()
+	rep := report.NewReport()
+	if err := runPipeline(withBaseSink(context.Background(), mem), strings.NewReader(input), cfg, rep); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+	if n := len(mem.Records()); n != 1 {
+		t.Errorf("%d records written, want 1", n)
+	}
+	if rep.Filtered.ByReason["drop_rule"] != 3 {
+		t.Errorf("filtered = %+v, want 3 drop_rule", rep.Filtered)
+	}
+	want := []report.DropRuleHit{{Line: 2, Pattern: "^GET /healthz", Hits: 2}, {Line: 3, Pattern: "connection reset", Hits: 1}, {Line: 4, Pattern: "never matches"}}
+	if rep.DropRules == nil || rep.DropRules.File != rules || !slices.Equal(rep.DropRules.Rules, want) {
+		t.Errorf("drop_rules = %+v, want %v", rep.DropRules, want)
+	}
+	if !strings.Contains(rep.Prometheus(), `etl_drop_rule_hits_total{line="2"} 2`) {
+		t.Error("etl_drop_rule_hits_total missing from the metrics")
+	}
+}
+
+func TestRunPipeline_DropRulesMissingFile(t *testing.T) {
+	cfg := config.Default()
+	cfg.DropRulesFile = filepath.Join(t.TempDir(), "missing.txt")
+	mem := sink.NewMemorySink()
+	err := runPipeline(withBaseSink(context.Background(), mem), strings.NewReader(""), cfg, report.NewReport())
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("err = %v, want a missing file error", err)
+	}
+}
+
+func TestDropRuleSet_ReloadKeepsRulesOnError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "noise.txt")
+	os.WriteFile(path, []byte("alpha\n"), 0o644)
+	rep := report.NewReport()
+	s, err := loadDropRuleSet(path, rep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.match("alpha")
+
+	os.WriteFile(path, []byte("^\n"), 0o644)
+	s.reload(context.Background())
+	if !s.match("alpha") || rep.DropRules.Reloads != 0 {
+		t.Errorf("a bad file replaced the rules: %+v", rep.DropRules)
+	}
+
+	os.WriteFile(path, []byte("beta\nalpha\n"), 0o644)
+	s.reload(context.Background())
+	if s.match("gamma") || !s.match("beta") {
+		t.Error("reloaded rules not in use")
+	}
+	s.publish()
+	want := []report.DropRuleHit{{Line: 1, Pattern: "beta", Hits: 1}, {Line: 2, Pattern: "alpha", Hits: 2}}
+	if rep.DropRules.Reloads != 1 || !slices.Equal(rep.DropRules.Rules, want) {
+		t.Errorf("drop_rules = %+v, want %v after one reload", rep.DropRules, want)
+	}
+}
+
+func TestInspect_ShowsDropRule(t *testing.T) {
+	rules := filepath.Join(t.TempDir(), "noise.txt")
+	os.WriteFile(rules, []byte("# noise\n^GET /healthz\n"), 0o644)
+	cfg := config.Default()
+	cfg.DropRulesFile = rules
+	var out strings.Builder
+	input := `{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"GET /healthz 200","service":"api"}`
+	if err := inspect(&out, strings.NewReader(input), cfg, 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); !strings.Contains(got, "drop_rules: drop (line 2 of") || !strings.Contains(got, "result: dropped by drop rule on line 2") {
+		t.Errorf("inspect output:\n%s", got)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"k8s-log-etl/internal/logger"
+)
+
+// isFIFO reports whether path is a named pipe.
+func isFIFO(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode()&os.ModeNamedPipe != 0
+}
+
+// fifoReader reads a named pipe and, when its writer closes it, opens it
+// again and waits for the next writer instead of ending the input. It
+// reports EOF only once ctx is done, interrupting a read or an open
+// waiting for a writer.
+type fifoReader struct {
+	ctx  context.Context
+	path string
+	stop func() bool
+
+	mu      sync.Mutex
+	f       *os.File // nil while opening
+	opening bool
+}
+
+// openFIFOReader opens the named pipe at path, waiting for a writer.
+func openFIFOReader(ctx context.Context, path string) (*fifoReader, error) {
+	r := &fifoReader{ctx: ctx, path: path}
+	r.stop = context.AfterFunc(ctx, r.interrupt)
+	if err := r.open(); err != nil {
+		r.stop()
+		if err == io.EOF {
+			err = context.Cause(ctx)
+		}
+		return nil, err
+	}
+	return r, nil
+}
+
+// open opens the pipe, which blocks until a writer opens it too.
+func (r *fifoReader) open() error {
+	r.mu.Lock()
+	if r.ctx.Err() != nil {
+		r.mu.Unlock()
+		return io.EOF
+	}
+	r.opening = true
+	r.mu.Unlock()
+
+	f, err := os.Open(r.path)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.opening = false
+	if err != nil {
+		return err
+	}
+	if r.ctx.Err() != nil {
+		f.Close()
+		return io.EOF
+	}
+	r.f = f
+	return nil
+}
+
+// Read reads from the current writer, waiting for the next one when it
+// closes the pipe.
+func (r *fifoReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.f.Read(p)
+		if r.ctx.Err() != nil && err != nil {
+			return n, io.EOF
+		}
+		if err != io.EOF || n > 0 {
+			return n, err
+		}
+		logger.Info("input pipe closed by its writer, waiting for the next one", "path", r.path)
+		r.mu.Lock()
+		r.f.Close()
+		r.f = nil
+		r.mu.Unlock()
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// interrupt wakes a read or open in progress once ctx is done: a read
+// through its deadline, an open by briefly opening the pipe for writing,
+// retried until the open has returned.
+func (r *fifoReader) interrupt() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f != nil {
+		r.f.SetReadDeadline(time.Now())
+		return
+	}
+	go func() {
+		for {
+			r.mu.Lock()
+			opening := r.opening
+			r.mu.Unlock()
+			if !opening {
+				return
+			}
+			wakeFIFOReader(r.path)
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+}
+
+// Close closes the pipe.
+func (r *fifoReader) Close() error {
+	r.stop()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	if errors.Is(err, os.ErrClosed) {
+		err = nil
+	}
+	return err
+}
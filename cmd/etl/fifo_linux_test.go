@@ -0,0 +1,98 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"k8s-log-etl/internal/report"
+	"k8s-log-etl/internal/sink"
+)
+
+func TestRunPipeline_FIFOInputOutlastsWriters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "in.pipe")
+	if err := syscall.Mkfifo(path, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := idleTestConfig(t, "", "")
+	cfg.InputPath = path
+	cfg.InputReopenOnEOF = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mem := sink.NewMemorySink()
+	rep := report.NewReport()
+	done := make(chan error, 1)
+	go func() {
+		open, closeFn, err := openInput(ctx, cfg)
+		if err != nil {
+			done <- err
+			return
+		}
+		defer closeFn()
+		done <- runPipelineFrom(withBaseSink(ctx, mem), open, cfg, rep)
+	}()
+
+	// Two writers in turn, each closing the pipe when done.
+	for _, lines := range []string{idleTestLine + idleTestLine, idleTestLine} {
+		f, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.WriteString(lines); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for len(mem.Records()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("run ended at the first writer's EOF: %v", err)
+	default:
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil && !errors.Is(err, context.Canceled) {
+			t.Fatalf("runPipelineFrom: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("cancelling did not end the wait for the next writer")
+	}
+	if n := len(mem.Records()); n != 3 {
+		t.Errorf("%d records written, want 3 from both writers", n)
+	}
+}
+
+func TestOpenFIFOReaderCancelledWhileWaiting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "in.pipe")
+	if err := syscall.Mkfifo(path, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := openFIFOReader(ctx, path)
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("openFIFOReader = %v, want context.Canceled", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("cancelling did not end the wait for a writer")
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// wakeFIFOReader returns a reader's open of the named pipe at path that
+// is waiting for a writer, by opening it for writing without blocking and
+// closing it again at once.
+func wakeFIFOReader(path string) {
+	if f, err := os.OpenFile(path, os.O_WRONLY|syscall.O_NONBLOCK, 0); err == nil {
+		f.Close()
+	}
+}
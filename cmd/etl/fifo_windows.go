@@ -0,0 +1,7 @@
+//go:build windows
+
+package main
+
+// wakeFIFOReader does nothing: Windows has no named pipes in the file
+// system, so isFIFO is never true.
+func wakeFIFOReader(path string) {}
@@ -0,0 +1,749 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/sink"
+)
+
+// configFlags registers the config override flags shared by every subcommand
+// and returns a loader that resolves the effective config once fs has been
+// parsed. Precedence: defaults, config file, env, flags (highest).
+func configFlags(fs *flag.FlagSet) func() (config.Config, error) {
+	flagConfig := fs.String("config", "", "path to YAML or JSON config file")
+	flagInput := fs.String("input", "", "input JSONL path (use '-' for stdin)")
+	flagInputFormat := fs.String("input-format", "", "input format: auto|jsonl|json_array (default auto)")
+	flagLinePrune := fs.Int("line-prune-bytes", 0, "JSONL lines longer than this are scanned as read, keeping only the keys normalization reads (default 1048576; negative disables)")
+	flagMaxLine := fs.Int("max-line-bytes", 0, "skip JSONL lines longer than this many bytes (0 = no limit)")
+	flagInputIdleTimeout := fs.String("input-idle-timeout", "", "warn when no complete input line arrives for this long (e.g. 5m)")
+	flagInputReopen := fs.Bool("input-reopen-on-eof", false, "when --input is a named pipe, wait for the next writer after one closes it instead of ending the run")
+	flagInputIdleAction := fs.String("input-idle-action", "", "what to do when --input-idle-timeout passes: warn|exit (default warn; exit ends the run with code 75)")
+	flagOutput := fs.String("output", "", "output path (use '-' for stdout)")
+	flagInputType := fs.String("input-type", "", "input type: file|nats|journald (default file; nats consumes a JetStream stream through the servers in --input; journald reads journalctl -o json)")
+	flagNATSInputStream := fs.String("nats-input-stream", "", "JetStream stream --input-type nats reads")
+	flagNATSInputConsumer := fs.String("nats-input-consumer", "", "durable consumer --input-type nats reads the stream with, created if missing")
+	flagNATSInputFilter := fs.String("nats-input-filter-subject", "", "only read the stream's messages on this subject, e.g. logs.prod.>")
+	flagNATSInputMaxAckPending := fs.Int("nats-input-max-ack-pending", 0, "messages delivered and not yet acknowledged before the server waits (default 1000)")
+	flagNATSInputAckWait := fs.String("nats-input-ack-wait", "", "redeliver a message not acknowledged within this long (default 30s)")
+	flagJournaldCommand := fs.String("journald-command", "", "command line --input-type journald runs in place of journalctl (space-separated), e.g. 'journalctl --directory /var/log/journal/remote'")
+	flagJournaldUnits := fs.String("journald-units", "", "comma-separated systemd units --input-type journald reads, e.g. nginx.service (default all)")
+	flagJournaldFollow := fs.Bool("journald-follow", false, "keep reading new journal entries instead of ending at the end of the journal")
+	flagJournaldCursor := fs.String("journald-cursor-file", "", "file holding the cursor of the last journal entry written, to resume after it")
+	flagInputs := fs.String("inputs", "", "comma-separated input files or glob patterns, read in place of --input")
+	flagMergeSorted := fs.Bool("input-merge-sorted", false, "k-way merge --inputs by timestamp; each file must be JSONL in time order")
+	flagOutputType := fs.String("output-type", "", "sink type: "+outputTypeNames()+" (default stdout)")
+	flagOutputMaxBytes := fs.Int64("output-max-bytes", 0, "max bytes before rotation when using rotate sink")
+	flagOutputMaxFiles := fs.Int("output-max-files", 0, "max rotated files to keep when using rotate sink")
+	flagOutputPathDateLayout := fs.String("output-path-date-layout", "", "Go time layout of {date} in an output path with placeholders (default 2006-01-02)")
+	flagOutputPathMaxOpen := fs.Int("output-path-max-open", 0, "most files an output path with placeholders keeps open at once (default 64)")
+	flagOutputAtomic := fs.Bool("output-atomic", false, "write the file sink to <output>.tmp and rename it into place when the run succeeds")
+	flagOutputManifest := fs.Bool("output-manifest", false, "write <output>.manifest.json with record counts, sizes, and SHA-256 of every output file")
+	flagOutputIndex := fs.String("output-index", "", "write <output>.idx placing each record by trace ID for 'etl lookup': trace_id")
+	flagOutputResume := fs.Bool("output-resume", false, "continue the file sink's output where an interrupted run over the same input left it")
+	flagOutputDone := fs.Bool("output-done-marker", false, "write <output>.done with a run summary once the output file is complete")
+	flagOutputFields := fs.String("output-fields", "", "comma-separated fields to emit per record (e.g. ts,level,service,message,fields.http.status)")
+	flagOutputFormat := fs.String("output-format", "", "record format: json, template, pretty, or avro (default json)")
+	flagOutputSchema := fs.String("output-schema", "", "JSON keys of written records: legacy (TS, Level, Service, Namespace, Pod, Node, Message, TraceID, Error, Stacktrace, Caller, Fields) or v1 (ts, level, service, namespace, pod, node, message, trace_id, error, stacktrace, caller, fields) (default legacy)")
+	flagAvroRegistry := fs.String("avro-registry-url", "", "Confluent Schema Registry URL; frames --output-format avro records with the schema ID")
+	flagAvroSubject := fs.String("avro-subject", "", "schema registry subject for avro output (default "+config.DefaultAvroSubject+")")
+	flagOutputTemplate := fs.String("output-template", "", "Go text/template rendering each record when --output-format is template")
+	flagPrettyFields := fs.String("pretty-fields", "", "comma-separated fields shown as key=value with --output-format pretty (default all)")
+	flagNoEscapeHTML := fs.Bool("no-output-escape-html", false, "write <, >, and & as is in JSON output and the DLQ instead of as \\u003c, \\u003e, and \\u0026")
+	flagSortKeys := fs.Bool("output-sort-keys", false, "write the keys of every JSON object in sorted order, for diffing output")
+	flagIndent := fs.Int("output-indent", 0, "pretty-print each JSON record indented by this many spaces (0 = one record per line)")
+	flagFileMode := fs.String("output-file-mode", "", "octal mode for output, DLQ, and report files, e.g. 0600, applied whatever the umask")
+	flagDirMode := fs.String("output-dir-mode", "", "octal mode for directories created for output and DLQ files, e.g. 0700")
+	flagOwner := fs.String("output-owner", "", "numeric uid:gid to chown output, DLQ, and report files to; only when running as root")
+	flagBaseDir := fs.String("output-base-dir", "", "refuse to write any file outside this directory or through a symbolic link below it")
+	flagNoValidatePaths := fs.Bool("no-validate-paths", false, "skip checking that the output, report, and DLQ directories exist and are writable before the run")
+	flagStrictConfig := fs.Bool("strict-config", false, "fail on config file keys no option uses instead of warning about them")
+	flagPreflight := fs.Bool("preflight", false, "before the run, also send a HEAD request to an http or clickhouse sink, connect to a grpc or nats server, and open an existing output file for writing")
+	flagReport := fs.String("report", "", "report output path")
+	flagReportFormat := fs.String("report-format", "", "report format: json or markdown")
+	flagReportMD := fs.String("report-md", "", "also write a Markdown summary of the report to this path")
+	flagReportSchema := fs.String("report-schema", "", "JSON report shape: legacy (flat) or v2 (operational and data_quality sections)")
+	flagReportOps := fs.String("report-ops", "", "also write the report's operational section to this path")
+	flagReportQuality := fs.String("report-quality", "", "also write the report's data-quality section to this path")
+	flagMaxWorkers := fs.Int("max-workers", 0, "number of sink workers")
+	flagQueueSize := fs.Int("queue-size", 0, "bounded queue size between normalize and sink")
+	flagQueuePriority := fs.Bool("queue-priority-by-level", false, "hand queued ERROR/FATAL records to the sink first, then WARN, then the rest")
+	flagMaxInflight := fs.Int64("max-inflight-bytes", 0, "cap on estimated bytes of records queued for the sink (0 = no cap)")
+	flagSinkRetries := fs.Int("sink-max-retries", 0, "max retries for sink writes")
+	flagBackoffBase := fs.Int("sink-backoff-base-ms", 0, "base backoff in ms for sink retries")
+	flagBackoffMax := fs.Int("sink-backoff-max-ms", 0, "max backoff in ms for sink retries")
+	flagBackoffJitter := fs.Float64("sink-backoff-jitter-pct", 0, "jitter pct (0.2 = 20%) for sink retries")
+	flagStallWarnAfter := fs.String("stall-warn-after", "", "warn when a sink worker holds one record for this long (e.g. 2m)")
+	flagStallAction := fs.String("stall-action", "", "what to do when --stall-warn-after passes: warn|abort (default warn)")
+	flagWriteTimeout := fs.Int("sink-write-timeout-ms", 0, "timeout in ms for each sink write attempt (0 = no timeout)")
+	flagHTTPMaxIdle := fs.Int("http-max-idle-conns", 0, "idle connections the http sink keeps for reuse (default 100)")
+	flagHTTPMaxConns := fs.Int("http-max-conns-per-host", 0, "cap on the http sink's open connections (default no cap)")
+	flagHTTPIdleTimeout := fs.String("http-idle-conn-timeout", "", "close http sink connections idle this long (default 90s)")
+	flagHTTPCompress := fs.String("http-compress", "", "compress http sink request bodies: none|gzip (default none)")
+	flagHTTPAuthTokenFile := fs.String("http-auth-token-file", "", "file holding the bearer token the http sink sends, e.g. a mounted Secret")
+	flagClickHousePasswordFile := fs.String("clickhouse-password-file", "", "file holding the ClickHouse password, in place of ETL_CLICKHOUSE_PASSWORD")
+	flagRetryBudget := fs.String("sink-retry-budget", "", "total backoff time all sink writes may spend retrying per --sink-retry-budget-window (e.g. 10m); once spent, failed writes are not retried until the next window")
+	flagRetryBudgetWindow := fs.String("sink-retry-budget-window", "", "how often --sink-retry-budget starts over (default 1h)")
+	flagClickHouseDB := fs.String("clickhouse-database", "", "ClickHouse database for --output-type clickhouse (default: the user's default)")
+	flagClickHouseTable := fs.String("clickhouse-table", "", "ClickHouse table for --output-type clickhouse")
+	flagClickHouseGzip := fs.Bool("clickhouse-gzip", false, "gzip ClickHouse insert bodies")
+	flagGRPCTLS := fs.Bool("grpc-tls", false, "connect to the --output-type grpc server with TLS")
+	flagGRPCCAFile := fs.String("grpc-ca-file", "", "PEM certificates to verify the grpc server with, in place of the system roots")
+	flagGRPCAuthTokenFile := fs.String("grpc-auth-token-file", "", "file holding the bearer token the grpc sink sends, e.g. a mounted Secret")
+	flagNATSSubject := fs.String("nats-subject", "", "subject --output-type nats publishes to, e.g. logs.{namespace}.{service}")
+	flagNATSCredsFile := fs.String("nats-creds-file", "", "NATS user credentials file (JWT and NKey seed)")
+	flagNATSMaxInFlight := fs.Int("nats-max-in-flight", 0, "publishes awaiting a JetStream ack before writes wait (default 256)")
+	flagNATSAckTimeout := fs.String("nats-ack-timeout", "", "publish again when no JetStream ack came within this long (default 5s)")
+	flagObjectMaxAge := fs.Int("object-max-age-seconds", 0, "store an object once its first record is this old with --output-type object (default 300; negative disables)")
+	flagFaultyInner := fs.String("faulty-inner", "", "sink wrapped by --output-type faulty (default stdout)")
+	flagFaultyFailRate := fs.Float64("faulty-fail-rate", 0, "faulty sink: probability (0-1) that a write fails")
+	flagFaultyFailEvery := fs.Int("faulty-fail-every", 0, "faulty sink: fail every Nth write attempt")
+	flagFaultyFailAfter := fs.Int("faulty-fail-after", 0, "faulty sink: fail every write once this many records are written")
+	flagFaultyLatency := fs.Int("faulty-latency-ms", 0, "faulty sink: delay each write attempt by this many ms")
+	flagJitterSeed := fs.Int64("retry-jitter-seed", 0, "seed for sink retry backoff jitter, for repeatable runs (default from the clock)")
+	flagRunManifest := fs.String("run-manifest", "", "write a run manifest with the effective config, build info, inputs, and outcome to this path")
+	flagRunManifestChecksums := fs.Bool("run-manifest-checksums", false, "add each input file's size and SHA-256 to the run manifest")
+	flagFaultySeed := fs.Int64("faulty-seed", 0, "faulty sink: seed for random failures")
+	flagRouterRoutes := fs.String("router-routes", "", "router sink: comma- or semicolon-separated routes, name=output_type:output")
+	flagRouterRules := fs.String("router-rules", "", "router sink: comma- or semicolon-separated rules, tried in order, 'field=value ... -> route'")
+	flagRouterDefault := fs.String("router-default", "", "router sink: route for records no rule matches (default: dead-letter them)")
+	flagRouteByLevel := fs.String("route-by-level", "", "router sink: comma- or semicolon-separated level routes, tried after the rules, 'LEVEL|LEVEL -> route'")
+	flagRouterRouteOptions := fs.String("router-route-options", "", "router sink: comma- or semicolon-separated per-route batching and retry settings, 'route key=value ...'")
+	flagDLQ := fs.String("dlq", "", "dead-letter path for failed records (jsonl). 's3://...' not supported.")
+	flagFilterLevels := listFlags(fs, "filter-levels", "filter-level", "comma-separated levels to emit (e.g. WARN,ERROR)")
+	flagFilterServices := listFlags(fs, "filter-services", "filter-service", "comma-separated services to emit (case-insensitive)")
+	flagRedactKeys := listFlags(fs, "redact-keys", "redact-key", "comma-separated field keys to redact from extra fields")
+	flagBatchSize := fs.Int("batch-size", 0, "batch size for sink writes (0 = no batching)")
+	flagBatchFlushInterval := fs.Int("batch-flush-interval-ms", 0, "batch flush interval in milliseconds")
+	flagAggWindow := fs.Int("aggregate-window-seconds", 0, "write per-window counts instead of records (0 = off)")
+	flagAggGroupBy := fs.String("aggregate-group-by", "", "comma-separated fields to group aggregates by (default service,level)")
+	flagAggField := fs.String("aggregate-field", "", "numeric field to sum/min/max in aggregates")
+	flagAggLateness := fs.Int("aggregate-lateness-seconds", 0, "seconds a window stays open after it ends")
+	flagAggLate := fs.String("aggregate-late", "", "late records for flushed windows: drop or amend")
+	flagAggMaxBuckets := fs.Int("aggregate-max-buckets", 0, "maximum open windows before the oldest is flushed")
+	flagShutdownTimeout := fs.Int("shutdown-timeout-seconds", 0, "graceful shutdown timeout in seconds")
+	flagHealthListen := fs.String("health-listen", "", "serve /healthz and /readyz on this address (e.g. :8081)")
+	flagHealthStale := fs.Int("health-stale-seconds", 0, "fail /readyz when no write has succeeded for this many seconds (0 = never)")
+	flagHealthQueueFull := fs.Int("health-queue-full-seconds", 0, "fail /readyz when the queue has been full this long (default 30, negative disables)")
+	flagPprofListen := fs.String("pprof-listen", "", "serve net/http/pprof on this address (e.g. :6060)")
+	flagCPUProfile := fs.String("cpuprofile", "", "write a CPU profile of the run to this file")
+	flagLogLevel := fs.String("log-level", "", "log level: debug, info, warn, error")
+	flagLogFormat := fs.String("log-format", "", "log format: json, text")
+	flagTransforms := listFlags(fs, "transforms", "transform", "comma-separated transform chain (e.g. filter_redact,exec)")
+	flagOnError := fs.String("transform-on-error", "", "comma-separated per-transform error policies (e.g. exec=pass,wasm=dlq); policies: drop, pass, dlq, abort")
+	flagTopMessages := fs.Int("top-messages", 0, "number of most frequent message templates in the report (negative disables)")
+	flagReportMaxLabels := fs.Int("report-max-label-values", 0, "levels, services, and namespaces the report counts apiece before counting the rest as __other__ (default 1000; negative removes the cap)")
+	flagMetricsTextfile := fs.String("metrics-textfile", "", "write the Prometheus report to this .prom file at the end of every run")
+	flagMetricsRules := fs.String("metrics-rules", "", "path to the JSON rules file for the metrics_extract transform")
+	flagNamespaceQuota := fs.String("namespace-quota", "", "comma-separated namespace=limit quotas for the namespace_quota transform, a record count or a size such as 500MB (e.g. payments=100000,*=1GB)")
+	flagQuotaInterval := fs.String("namespace-quota-interval", "", "start every namespace quota afresh each interval, e.g. 1h (default: quotas last the run)")
+	flagQuotaOverflow := fs.String("quota-overflow", "", "records past a namespace quota: drop or sample")
+	flagQuotaSampleEvery := fs.Int("quota-sample-every", 0, "with --quota-overflow sample, keep one in this many records past the quota (default 100)")
+	flagTraceRecord := fs.String("trace-record", "", "log every stage decision at info for records with this field=value, e.g. trace_id=abc123")
+	flagDropRules := fs.String("drop-rules-file", "", "file of message patterns to drop, one per line (^ for a prefix, # for comments)")
+	flagDropRulesReload := fs.Bool("drop-rules-reload", false, "reload --drop-rules-file on SIGHUP")
+	flagExecCommand := fs.String("exec-command", "", "command line for the exec transform (space-separated)")
+	flagExecTimeout := fs.Int("exec-timeout-ms", 0, "per-record timeout in ms for the exec transform")
+	flagWasmModule := fs.String("wasm-module", "", "path to the WASM module for the wasm transform")
+	flagWasmTimeout := fs.Int("wasm-timeout-ms", 0, "per-record execution timeout in ms for the wasm transform")
+	flagWasmMemory := fs.Int("wasm-memory-limit-mb", 0, "guest memory limit in MiB for the wasm transform")
+	flagDLQMaxRecord := fs.Int("dlq-max-record-bytes", 0, "max encoded size of a DLQ entry before extra fields are dropped (negative disables)")
+	flagDLQNormalize := fs.Bool("dlq-normalize-failures", false, "dead-letter records that fail normalization (requires --dlq)")
+	flagStrictJSON := fs.Bool("strict-json", false, "count input lines that repeat a key within one object")
+	flagDLQDuplicateKeys := fs.Bool("dlq-duplicate-keys", false, "dead-letter the records of lines with a duplicate key (requires --strict-json and --dlq)")
+	flagJSONSchema := fs.String("json-schema-file", "", "JSON Schema to validate every parsed record against before normalization")
+	flagSchemaAction := fs.String("schema-action", "", "for records failing --json-schema-file: warn|drop|dlq (default warn)")
+	flagDLQMaxRecords := fs.Int("dlq-max-records", 0, "max DLQ entries written in a run (0 = unlimited)")
+	flagDLQMaxBytes := fs.Int64("dlq-max-bytes", 0, "max bytes written to the DLQ in a run (0 = unlimited)")
+	flagDLQOverflow := fs.String("dlq-overflow-policy", "", "past --dlq-max-records or --dlq-max-bytes: drop|abort (default drop)")
+	flagDLQPayload := fs.String("dlq-payload", "", "what DLQ entries carry of the failed record: normalized|raw|both (default normalized)")
+	flagSpillDir := fs.String("spill-dir", "", "spool records that failed every retry to this directory and write them once the sink recovers")
+	flagSpillMaxBytes := fs.Int64("spill-max-bytes", 0, "max bytes of spooled records in --spill-dir before the DLQ takes the rest (default 1 GiB)")
+	flagDeriveService := fs.Bool("derive-service-from-pod", false, "derive a missing service from the pod name (payments-api-7d9f8b6c4-xk2lp -> payments-api)")
+	flagUnwrapKeys := fs.String("unwrap-keys", "", "comma-separated keys whose string value wraps the original record, e.g. log")
+	flagUnwrapConflict := fs.String("unwrap-conflict", "", "on keys in both the record and its unwrapped payload, keep: inner|outer (default inner)")
+	flagSortWindow := fs.String("sort-window", "", "reorder records by timestamp within this window (e.g. 10s)")
+	flagSortMax := fs.Int("sort-max-records", 0, "most records held by --sort-window before the oldest is written early (default 100000, negative disables)")
+	flagReplaySpeed := fs.Float64("replay-speed", 0, "pace writes at the records' original rate times this factor (e.g. 1.0; needs --max-workers 1)")
+	flagReplayMaxSleep := fs.String("replay-max-sleep", "", "longest --replay-speed waits between two records (default 10s)")
+	flagTimestampShift := fs.String("timestamp-shift", "", "move every record's timestamp by a duration such as +72h, or rebase-now to make the input's latest record current")
+	flagSkip := fs.Int("skip", 0, "ignore the first N non-empty input lines")
+	flagHead := fs.Int("head", 0, "stop after N records have been queued for the sink")
+	flagMaxDuration := fs.String("max-duration", "", "stop reading once the pipeline has run this long (e.g. 14m), finish in-flight records, and exit with code 124")
+	flagNoStageTimings := fs.Bool("no-stage-timings", false, "skip per-stage timings in the report to save two clock reads per stage and line")
+	flagStampRun := fs.Bool("stamp-run-metadata", false, "add _etl_run_id and _etl_host to each record's fields")
+	flagSanitize := fs.Bool("sanitize-messages", false, "escape newlines and tabs, drop other control characters, and replace invalid UTF-8 in messages and string fields")
+	flagStampProvenance := fs.Bool("stamp-provenance", false, "add _src_file and _src_line, where each record was read, to its fields")
+	flagRedactionAudit := fs.Bool("redaction-audit", false, "add _redacted_keys, the names of the keys filter_redact removed, to each redacted record")
+	flagTransformAudit := fs.Bool("transform-audit", false, "add _etl_transforms, the transforms that evaluated each record and their outcomes, to its fields, and count short-circuited records in the report")
+
+	return func() (config.Config, error) {
+		cfg := config.Default()
+
+		// Load config file if provided by flag or env.
+		cfgPath := *flagConfig
+		if cfgPath == "" {
+			cfgPath = os.Getenv("ETL_CONFIG")
+		}
+		if cfgPath != "" {
+			fileCfg, err := config.Load(cfgPath)
+			if err != nil {
+				return config.Config{}, fmt.Errorf("load config: %w", err)
+			}
+			cfg = config.Merge(cfg, fileCfg)
+		}
+
+		// Env overrides.
+		cfg = config.FromEnv(cfg)
+
+		// Flag overrides (highest precedence).
+		override := config.Config{}
+		if *flagInput != "" {
+			override.InputPath = *flagInput
+		}
+		if *flagInputs != "" {
+			override.Inputs = config.ParseList(*flagInputs)
+		}
+		if *flagMergeSorted {
+			override.InputMergeSorted = true
+		}
+		if *flagInputFormat != "" {
+			override.InputFormat = *flagInputFormat
+		}
+		if *flagLinePrune != 0 {
+			override.LinePruneBytes = *flagLinePrune
+		}
+		if *flagMaxLine != 0 {
+			override.MaxLineBytes = *flagMaxLine
+		}
+		if *flagInputType != "" {
+			override.InputType = *flagInputType
+		}
+		if *flagNATSInputStream != "" {
+			override.NATSInputStream = *flagNATSInputStream
+		}
+		if *flagNATSInputConsumer != "" {
+			override.NATSInputConsumer = *flagNATSInputConsumer
+		}
+		if *flagNATSInputFilter != "" {
+			override.NATSInputFilterSubject = *flagNATSInputFilter
+		}
+		if *flagNATSInputMaxAckPending != 0 {
+			override.NATSInputMaxAckPending = *flagNATSInputMaxAckPending
+		}
+		if *flagNATSInputAckWait != "" {
+			override.NATSInputAckWait = *flagNATSInputAckWait
+		}
+		if *flagJournaldCommand != "" {
+			override.JournaldCommand = strings.Fields(*flagJournaldCommand)
+		}
+		if *flagJournaldUnits != "" {
+			override.JournaldUnits = config.ParseList(*flagJournaldUnits)
+		}
+		if *flagJournaldFollow {
+			override.JournaldFollow = true
+		}
+		if *flagJournaldCursor != "" {
+			override.JournaldCursorFile = *flagJournaldCursor
+		}
+		if *flagInputIdleTimeout != "" {
+			override.InputIdleTimeout = *flagInputIdleTimeout
+		}
+		if *flagInputIdleAction != "" {
+			override.InputIdleAction = *flagInputIdleAction
+		}
+		if *flagInputReopen {
+			override.InputReopenOnEOF = true
+		}
+		if *flagOutput != "" {
+			override.OutputPath = *flagOutput
+		}
+		if *flagOutputType != "" {
+			override.OutputType = *flagOutputType
+		}
+		if *flagOutputMaxBytes != 0 {
+			override.OutputMaxB = *flagOutputMaxBytes
+		}
+		if *flagOutputMaxFiles != 0 {
+			override.OutputMaxFiles = *flagOutputMaxFiles
+		}
+		if *flagOutputPathDateLayout != "" {
+			override.OutputPathDateLayout = *flagOutputPathDateLayout
+		}
+		if *flagOutputPathMaxOpen != 0 {
+			override.OutputPathMaxOpen = *flagOutputPathMaxOpen
+		}
+		if *flagOutputAtomic {
+			override.OutputAtomic = true
+		}
+		if *flagOutputDone {
+			override.OutputDoneMarker = true
+		}
+		if *flagOutputResume {
+			override.OutputResume = true
+		}
+		if *flagOutputIndex != "" {
+			override.OutputIndex = *flagOutputIndex
+		}
+		if *flagOutputManifest {
+			override.OutputManifest = true
+		}
+		if *flagOutputFields != "" {
+			override.OutputFields = config.ParseList(*flagOutputFields)
+		}
+		if *flagOutputFormat != "" {
+			override.OutputFormat = *flagOutputFormat
+		}
+		if *flagOutputSchema != "" {
+			override.OutputSchema = *flagOutputSchema
+		}
+		if *flagAvroRegistry != "" {
+			override.AvroRegistryURL = *flagAvroRegistry
+		}
+		if *flagAvroSubject != "" {
+			override.AvroSubject = *flagAvroSubject
+		}
+		if *flagOutputTemplate != "" {
+			override.OutputTemplate = *flagOutputTemplate
+		}
+		if *flagPrettyFields != "" {
+			override.PrettyFields = config.ParseList(*flagPrettyFields)
+		}
+		if *flagNoEscapeHTML {
+			off := false
+			override.OutputEscapeHTML = &off
+		}
+		if *flagSortKeys {
+			override.OutputSortKeys = true
+		}
+		if *flagIndent != 0 {
+			override.OutputIndent = *flagIndent
+		}
+		if *flagFileMode != "" {
+			override.OutputFileMode = *flagFileMode
+		}
+		if *flagDirMode != "" {
+			override.OutputDirMode = *flagDirMode
+		}
+		if *flagOwner != "" {
+			override.OutputOwner = *flagOwner
+		}
+		if *flagBaseDir != "" {
+			override.OutputBaseDir = *flagBaseDir
+		}
+		if *flagReport != "" {
+			override.ReportPath = *flagReport
+		}
+		if *flagReportFormat != "" {
+			override.ReportFormat = *flagReportFormat
+		}
+		if *flagReportMD != "" {
+			override.ReportMarkdownPath = *flagReportMD
+		}
+		if *flagReportSchema != "" {
+			override.ReportSchema = *flagReportSchema
+		}
+		if *flagReportOps != "" {
+			override.ReportOpsPath = *flagReportOps
+		}
+		if *flagReportQuality != "" {
+			override.ReportQualityPath = *flagReportQuality
+		}
+		if *flagNoValidatePaths {
+			off := false
+			override.ValidatePaths = &off
+		}
+		if *flagPreflight {
+			override.Preflight = true
+		}
+		if *flagStrictConfig {
+			override.StrictConfig = true
+		}
+		if *flagMaxWorkers != 0 {
+			override.MaxWorkers = *flagMaxWorkers
+		}
+		if *flagQueueSize != 0 {
+			override.QueueSize = *flagQueueSize
+		}
+		if *flagQueuePriority {
+			override.QueuePriorityByLevel = true
+		}
+		if *flagMaxInflight != 0 {
+			override.MaxInflightBytes = *flagMaxInflight
+		}
+		if *flagSinkRetries != 0 {
+			override.SinkMaxRetries = *flagSinkRetries
+		}
+		if *flagBackoffBase != 0 {
+			override.SinkBackoffBaseMS = *flagBackoffBase
+		}
+		if *flagBackoffMax != 0 {
+			override.SinkBackoffMaxMS = *flagBackoffMax
+		}
+		if *flagBackoffJitter != 0 {
+			override.SinkBackoffJitter = *flagBackoffJitter
+		}
+		if *flagWriteTimeout != 0 {
+			override.SinkWriteTimeoutMS = *flagWriteTimeout
+		}
+		if *flagStallWarnAfter != "" {
+			override.StallWarnAfter = *flagStallWarnAfter
+		}
+		if *flagStallAction != "" {
+			override.StallAction = *flagStallAction
+		}
+		if *flagRetryBudget != "" {
+			override.SinkRetryBudget = *flagRetryBudget
+		}
+		if *flagRetryBudgetWindow != "" {
+			override.SinkRetryBudgetWindow = *flagRetryBudgetWindow
+		}
+		if *flagHTTPMaxIdle != 0 {
+			override.HTTPMaxIdleConns = *flagHTTPMaxIdle
+		}
+		if *flagHTTPMaxConns != 0 {
+			override.HTTPMaxConnsPerHost = *flagHTTPMaxConns
+		}
+		if *flagHTTPIdleTimeout != "" {
+			override.HTTPIdleConnTimeout = *flagHTTPIdleTimeout
+		}
+		if *flagHTTPCompress != "" {
+			override.HTTPCompress = *flagHTTPCompress
+		}
+		if *flagHTTPAuthTokenFile != "" {
+			override.HTTPAuthTokenFile = *flagHTTPAuthTokenFile
+		}
+		if *flagClickHousePasswordFile != "" {
+			override.ClickHousePasswordFile = *flagClickHousePasswordFile
+		}
+		if *flagClickHouseDB != "" {
+			override.ClickHouseDatabase = *flagClickHouseDB
+		}
+		if *flagClickHouseTable != "" {
+			override.ClickHouseTable = *flagClickHouseTable
+		}
+		if *flagClickHouseGzip {
+			override.ClickHouseGzip = true
+		}
+		if *flagGRPCTLS {
+			override.GRPCTLS = true
+		}
+		if *flagGRPCCAFile != "" {
+			override.GRPCCAFile = *flagGRPCCAFile
+		}
+		if *flagGRPCAuthTokenFile != "" {
+			override.GRPCAuthTokenFile = *flagGRPCAuthTokenFile
+		}
+		if *flagNATSSubject != "" {
+			override.NATSSubject = *flagNATSSubject
+		}
+		if *flagNATSCredsFile != "" {
+			override.NATSCredsFile = *flagNATSCredsFile
+		}
+		if *flagNATSMaxInFlight != 0 {
+			override.NATSMaxInFlight = *flagNATSMaxInFlight
+		}
+		if *flagNATSAckTimeout != "" {
+			override.NATSAckTimeout = *flagNATSAckTimeout
+		}
+		if *flagObjectMaxAge != 0 {
+			override.ObjectMaxAgeSeconds = *flagObjectMaxAge
+		}
+		if *flagFaultyInner != "" {
+			override.FaultyInner = *flagFaultyInner
+		}
+		if *flagFaultyFailRate != 0 {
+			override.FaultyFailRate = *flagFaultyFailRate
+		}
+		if *flagFaultyFailEvery != 0 {
+			override.FaultyFailEvery = *flagFaultyFailEvery
+		}
+		if *flagFaultyFailAfter != 0 {
+			override.FaultyFailAfter = *flagFaultyFailAfter
+		}
+		if *flagFaultyLatency != 0 {
+			override.FaultyLatencyMS = *flagFaultyLatency
+		}
+		if *flagJitterSeed != 0 {
+			override.RetryJitterSeed = *flagJitterSeed
+		}
+		if *flagRunManifest != "" {
+			override.RunManifestPath = *flagRunManifest
+		}
+		if *flagRunManifestChecksums {
+			override.RunManifestChecksums = true
+		}
+		if *flagFaultySeed != 0 {
+			override.FaultySeed = *flagFaultySeed
+		}
+		if *flagRouterRoutes != "" {
+			override.RouterRoutes = config.ParseList(*flagRouterRoutes)
+		}
+		if *flagRouterRules != "" {
+			override.RouterRules = config.ParseList(*flagRouterRules)
+		}
+		if *flagRouterDefault != "" {
+			override.RouterDefault = *flagRouterDefault
+		}
+		if *flagRouteByLevel != "" {
+			override.RouteByLevel = config.ParseList(*flagRouteByLevel)
+		}
+		if *flagRouterRouteOptions != "" {
+			override.RouterRouteOptions = config.ParseList(*flagRouterRouteOptions)
+		}
+		if *flagDLQ != "" {
+			override.DLQPath = *flagDLQ
+		}
+		if len(*flagFilterLevels) > 0 {
+			override.FilterLevels = *flagFilterLevels
+		}
+		if len(*flagFilterServices) > 0 {
+			override.FilterSvcs = *flagFilterServices
+		}
+		if len(*flagRedactKeys) > 0 {
+			override.RedactKeys = *flagRedactKeys
+		}
+		if *flagBatchSize != 0 {
+			override.BatchSize = *flagBatchSize
+		}
+		if *flagBatchFlushInterval != 0 {
+			override.BatchFlushInterval = *flagBatchFlushInterval
+		}
+		if *flagAggWindow != 0 {
+			override.AggregateWindowSeconds = *flagAggWindow
+		}
+		if *flagAggGroupBy != "" {
+			override.AggregateGroupBy = config.ParseList(*flagAggGroupBy)
+		}
+		if *flagAggField != "" {
+			override.AggregateField = *flagAggField
+		}
+		if *flagAggLateness != 0 {
+			override.AggregateLatenessSeconds = *flagAggLateness
+		}
+		if *flagAggLate != "" {
+			override.AggregateLate = *flagAggLate
+		}
+		if *flagAggMaxBuckets != 0 {
+			override.AggregateMaxBuckets = *flagAggMaxBuckets
+		}
+		if *flagShutdownTimeout != 0 {
+			override.ShutdownTimeoutSeconds = *flagShutdownTimeout
+		}
+		if *flagHealthListen != "" {
+			override.HealthListen = *flagHealthListen
+		}
+		if *flagHealthStale != 0 {
+			override.HealthStaleSeconds = *flagHealthStale
+		}
+		if *flagHealthQueueFull != 0 {
+			override.HealthQueueFullSeconds = *flagHealthQueueFull
+		}
+		if *flagPprofListen != "" {
+			override.PprofListen = *flagPprofListen
+		}
+		if *flagCPUProfile != "" {
+			override.CPUProfile = *flagCPUProfile
+		}
+		if *flagLogLevel != "" {
+			override.LogLevel = *flagLogLevel
+		}
+		if *flagLogFormat != "" {
+			override.LogFormat = *flagLogFormat
+		}
+		if len(*flagTransforms) > 0 {
+			override.Transforms = *flagTransforms
+		}
+		if *flagOnError != "" {
+			override.TransformOnError = config.ParseList(*flagOnError)
+		}
+		if *flagTopMessages != 0 {
+			override.TopMessages = *flagTopMessages
+		}
+		if *flagReportMaxLabels != 0 {
+			override.ReportMaxLabelValues = *flagReportMaxLabels
+		}
+		if *flagMetricsRules != "" {
+			override.MetricsRules = *flagMetricsRules
+		}
+		if *flagNamespaceQuota != "" {
+			override.NamespaceQuota = config.ParseList(*flagNamespaceQuota)
+		}
+		if *flagQuotaInterval != "" {
+			override.NamespaceQuotaInterval = *flagQuotaInterval
+		}
+		if *flagQuotaOverflow != "" {
+			override.QuotaOverflow = *flagQuotaOverflow
+		}
+		if *flagQuotaSampleEvery != 0 {
+			override.QuotaSampleEvery = *flagQuotaSampleEvery
+		}
+		if *flagTraceRecord != "" {
+			override.TraceRecord = *flagTraceRecord
+		}
+		if *flagDropRules != "" {
+			override.DropRulesFile = *flagDropRules
+		}
+		if *flagDropRulesReload {
+			override.DropRulesReload = true
+		}
+		if *flagMetricsTextfile != "" {
+			override.MetricsTextfilePath = *flagMetricsTextfile
+		}
+		if *flagExecCommand != "" {
+			override.ExecCommand = strings.Fields(*flagExecCommand)
+		}
+		if *flagExecTimeout != 0 {
+			override.ExecTimeoutMS = *flagExecTimeout
+		}
+		if *flagWasmModule != "" {
+			override.WasmModule = *flagWasmModule
+		}
+		if *flagWasmTimeout != 0 {
+			override.WasmTimeoutMS = *flagWasmTimeout
+		}
+		if *flagWasmMemory != 0 {
+			override.WasmMemoryLimitMB = *flagWasmMemory
+		}
+		if *flagStampRun {
+			override.StampRunMetadata = true
+		}
+		if *flagSanitize {
+			override.SanitizeMessages = true
+		}
+		if *flagStampProvenance {
+			override.StampProvenance = true
+		}
+		if *flagTransformAudit {
+			override.TransformAudit = true
+		}
+		if *flagRedactionAudit {
+			override.RedactionAudit = true
+		}
+		if *flagDeriveService {
+			override.DeriveServiceFromPod = true
+		}
+		if *flagUnwrapKeys != "" {
+			override.UnwrapKeys = config.ParseList(*flagUnwrapKeys)
+		}
+		if *flagUnwrapConflict != "" {
+			override.UnwrapConflict = *flagUnwrapConflict
+		}
+		if *flagSortWindow != "" {
+			override.SortWindow = *flagSortWindow
+		}
+		if *flagSortMax != 0 {
+			override.SortMaxRecords = *flagSortMax
+		}
+		if *flagTimestampShift != "" {
+			override.TimestampShift = *flagTimestampShift
+		}
+		if *flagReplaySpeed != 0 {
+			override.ReplaySpeed = *flagReplaySpeed
+		}
+		if *flagReplayMaxSleep != "" {
+			override.ReplayMaxSleep = *flagReplayMaxSleep
+		}
+		if *flagSkip != 0 {
+			override.Skip = *flagSkip
+		}
+		if *flagHead != 0 {
+			override.Head = *flagHead
+		}
+		if *flagMaxDuration != "" {
+			override.MaxDuration = *flagMaxDuration
+		}
+		if *flagNoStageTimings {
+			off := false
+			override.StageTimings = &off
+		}
+		if *flagDLQMaxRecord != 0 {
+			override.DLQMaxRecordBytes = *flagDLQMaxRecord
+		}
+		if *flagDLQNormalize {
+			override.DLQNormalizeFailures = true
+		}
+		if *flagStrictJSON {
+			override.StrictJSON = true
+		}
+		if *flagDLQDuplicateKeys {
+			override.DLQDuplicateKeys = true
+		}
+		if *flagJSONSchema != "" {
+			override.JSONSchemaFile = *flagJSONSchema
+		}
+		if *flagSchemaAction != "" {
+			override.SchemaAction = *flagSchemaAction
+		}
+		if *flagDLQMaxRecords != 0 {
+			override.DLQMaxRecords = *flagDLQMaxRecords
+		}
+		if *flagDLQMaxBytes != 0 {
+			override.DLQMaxBytes = *flagDLQMaxBytes
+		}
+		if *flagDLQOverflow != "" {
+			override.DLQOverflowPolicy = *flagDLQOverflow
+		}
+		if *flagDLQPayload != "" {
+			override.DLQPayload = *flagDLQPayload
+		}
+		if *flagSpillDir != "" {
+			override.SpillDir = *flagSpillDir
+		}
+		if *flagSpillMaxBytes != 0 {
+			override.SpillMaxBytes = *flagSpillMaxBytes
+		}
+		cfg = config.Merge(cfg, override)
+		return config.ReadSecretFiles(cfg), nil
+	}
+}
+
+// outputTypeNames lists the output types Build opens, as --output-type's
+// help shows them.
+func outputTypeNames() string {
+	names := make([]string, len(sink.Types))
+	for i, t := range sink.Types {
+		names[i] = t.Name
+	}
+	return strings.Join(names, "|")
+}
+
+// listFlag collects a list flag's items. Each value of the list form,
+// such as --redact-keys, is split like config.ParseList; each value of
+// its repeatable item form, such as --redact-key, is one item, commas
+// included. Repeats of either add to the list.
+type listFlag []string
+
+// listFlags registers the list form name and the item form item of one
+// list flag.
+func listFlags(fs *flag.FlagSet, name, item, usage string) *listFlag {
+	l := new(listFlag)
+	fs.Var(l, name, usage)
+	fs.Var(itemFlag{l}, item, "one item of --"+name+", taken as is; may be repeated")
+	return l
+}
+
+func (l *listFlag) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(s string) error {
+	*l = append(*l, config.ParseList(s)...)
+	return nil
+}
+
+// itemFlag is the item form of a listFlag.
+type itemFlag struct{ *listFlag }
+
+func (f itemFlag) Set(s string) error {
+	if s = strings.TrimSpace(s); s != "" {
+		*f.listFlag = append(*f.listFlag, s)
+	}
+	return nil
+}
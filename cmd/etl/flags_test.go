@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s-log-etl/internal/sink"
+)
+
+func TestConfigFlags_RepeatableLists(t *testing.T) {
+	t.Setenv("ETL_CONFIG", "")
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	load := configFlags(fs)
+	err := fs.Parse([]string{
+		"--redact-key", "user_email",
+		"--redact-key", "a,b",
+		"--redact-keys", `token,c\,d`,
+		"--filter-level", "ERROR",
+		"--filter-levels", "WARN",
+		"--filter-service", "orders",
+		"--transform", "filter_redact",
+		"--transform", "exec",
+	})
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	cfg, err := load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if want := []string{"user_email", "a,b", "token", "c,d"}; !reflect.DeepEqual(cfg.RedactKeys, want) {
+		t.Errorf("RedactKeys = %q, want %q", cfg.RedactKeys, want)
+	}
+	if want := []string{"ERROR", "WARN"}; !reflect.DeepEqual(cfg.FilterLevels, want) {
+		t.Errorf("FilterLevels = %q, want %q", cfg.FilterLevels, want)
+	}
+	if want := []string{"orders"}; !reflect.DeepEqual(cfg.FilterSvcs, want) {
+		t.Errorf("FilterSvcs = %q, want %q", cfg.FilterSvcs, want)
+	}
+	if want := []string{"filter_redact", "exec"}; !reflect.DeepEqual(cfg.Transforms, want) {
+		t.Errorf("Transforms = %q, want %q", cfg.Transforms, want)
+	}
+}
+
+func TestConfigFlags_ListsKeepDefaultsWhenUnset(t *testing.T) {
+	t.Setenv("ETL_CONFIG", "")
+	t.Setenv("ETL_FILTER_LEVELS", "")
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	load := configFlags(fs)
+	if err := fs.Parse(nil); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	cfg, err := load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if want := []string{"WARN", "ERROR"}; !reflect.DeepEqual(cfg.FilterLevels, want) {
+		t.Errorf("FilterLevels = %q, want the default %q", cfg.FilterLevels, want)
+	}
+}
+
+func TestConfigFlags_OutputTypeHelpNamesEverySink(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	configFlags(fs)
+	usage := fs.Lookup("output-type").Usage
+	for _, st := range sink.Types {
+		if !strings.Contains(usage, st.Name) {
+			t.Errorf("--output-type help %q does not name %s", usage, st.Name)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/logger"
+	"k8s-log-etl/internal/report"
+	"k8s-log-etl/internal/sink"
+)
+
+// errGoldenMismatch is returned when the output differs from the expected
+// file.
+var errGoldenMismatch = errors.New("output differs from expected")
+
+// cmdTest runs the pipeline over a corpus and compares the records it
+// would write with an expected file.
+func cmdTest(args []string) error {
+	fs := newFlagSet("test", "--expected <file> [flags]",
+		"Run the pipeline over the input with one worker, keep the records it\nwould write in memory, and compare them in order, field by field, with\nthe expected JSONL file. Exits non-zero on any difference. The sink,\nDLQ, and output marker settings are ignored.")
+	loadConfig := configFlags(fs)
+	flagExpected := fs.String("expected", "", "JSONL file of the records the input should produce")
+	flagUpdate := fs.Bool("update", false, "write the produced records to --expected instead of comparing")
+	flagMaxDiffs := fs.Int("max-diffs", 5, "number of differing records to print")
+	fs.Parse(args)
+
+	if *flagExpected == "" {
+		return errors.New("--expected is required")
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if err := config.Validate(cfg); err != nil {
+		return fmt.Errorf("configuration validation failed: %w", err)
+	}
+	initLogger(cfg)
+	if !strings.EqualFold(cfg.LogLevel, "debug") {
+		logger.SetLevel(slog.LevelWarn)
+	}
+
+	got, err := runGolden(context.Background(), cfg)
+	if err != nil {
+		return err
+	}
+	if *flagUpdate {
+		if err := writeGolden(*flagExpected, got); err != nil {
+			return fmt.Errorf("write expected: %w", err)
+		}
+		fmt.Printf("wrote %d records to %s\n", len(got), *flagExpected)
+		return nil
+	}
+	want, err := readGolden(*flagExpected)
+	if err != nil {
+		return fmt.Errorf("read expected: %w", err)
+	}
+	if err := compareGolden(os.Stdout, got, want, *flagMaxDiffs); err != nil {
+		return err
+	}
+	fmt.Printf("%d records match %s\n", len(got), *flagExpected)
+	return nil
+}
+
+// runGolden runs the pipeline over cfg's input with a single worker, so
+// records keep their order, and returns what it would have written.
+func runGolden(ctx context.Context, cfg config.Config) ([][]byte, error) {
+	cfg.MaxWorkers = 1
+	cfg.DLQPath = ""
+	cfg.OutputAtomic, cfg.OutputDoneMarker, cfg.OutputManifest = false, false, false
+	open, closeInput, err := openInput(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer closeInput()
+
+	mem := sink.NewMemorySink()
+	rep := report.NewReport()
+	rep.RunID = newRunID()
+	if err := runPipelineFrom(withBaseSink(ctx, mem), open, cfg, rep); err != nil {
+		return nil, fmt.Errorf("pipeline failed: %w", err)
+	}
+	return mem.Records(), nil
+}
+
+func writeGolden(path string, records [][]byte) error {
+	var buf bytes.Buffer
+	for _, r := range records {
+		buf.Write(r)
+		buf.WriteByte('\n')
+	}
+	return os.WriteFile(path, buf.Bytes(), 0o644)
+}
+
+// readGolden returns the non-blank lines of a JSONL file.
+func readGolden(path string) ([][]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var records [][]byte
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, len(data)+1)
+	for sc.Scan() {
+		if line := bytes.TrimSpace(sc.Bytes()); len(line) > 0 {
+			records = append(records, line)
+		}
+	}
+	return records, sc.Err()
+}
+
+// compareGolden compares got with want record by record and prints the
+// first maxDiffs records that differ to w.
+func compareGolden(w io.Writer, got, want [][]byte, maxDiffs int) error {
+	differing := 0
+	for i := 0; i < max(len(got), len(want)); i++ {
+		var lines []string
+		switch {
+		case i >= len(got):
+			lines = []string{"missing from output, want " + string(want[i])}
+		case i >= len(want):
+			lines = []string{"not expected: " + string(got[i])}
+		default:
+			g, err := decodeGolden(got[i])
+			if err != nil {
+				return fmt.Errorf("output record %d: %w", i+1, err)
+			}
+			e, err := decodeGolden(want[i])
+			if err != nil {
+				return fmt.Errorf("expected record %d: %w", i+1, err)
+			}
+			for _, d := range diffJSON("", g, e, nil) {
+				lines = append(lines, d.String())
+			}
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		differing++
+		if differing <= maxDiffs {
+			fmt.Fprintf(w, "record %d:\n", i+1)
+			for _, l := range lines {
+				fmt.Fprintf(w, "  %s\n", l)
+			}
+		}
+	}
+	if differing == 0 {
+		return nil
+	}
+	if differing > maxDiffs {
+		fmt.Fprintf(w, "... and %d more\n", differing-maxDiffs)
+	}
+	return fmt.Errorf("%w: %d records differ (output %d, expected %d)", errGoldenMismatch, differing, len(got), len(want))
+}
+
+func decodeGolden(b []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// jsonDiff is one differing value. Missing sides have ok false.
+type jsonDiff struct {
+	Path          string
+	Got, Want     any
+	GotOK, WantOK bool
+}
+
+func (d jsonDiff) String() string {
+	path := d.Path
+	if path == "" {
+		path = "record"
+	}
+	switch {
+	case !d.GotOK:
+		return fmt.Sprintf("%s: missing, want %s", path, jsonText(d.Want))
+	case !d.WantOK:
+		return fmt.Sprintf("%s: got %s, not expected", path, jsonText(d.Got))
+	}
+	return fmt.Sprintf("%s: got %s, want %s", path, jsonText(d.Got), jsonText(d.Want))
+}
+
+func jsonText(v any) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// diffJSON appends the differences between two decoded JSON values to out.
+// Objects are compared key by key and arrays element by element, so a
+// difference is reported at the deepest path where the values part.
+// Numbers are equal when their values are.
+func diffJSON(path string, got, want any, out []jsonDiff) []jsonDiff {
+	switch g := got.(type) {
+	case map[string]any:
+		if w, ok := want.(map[string]any); ok {
+			keys := make([]string, 0, len(g)+len(w))
+			for k := range g {
+				keys = append(keys, k)
+			}
+			for k := range w {
+				if _, ok := g[k]; !ok {
+					keys = append(keys, k)
+				}
+			}
+			slices.Sort(keys)
+			for _, k := range keys {
+				gv, gok := g[k]
+				wv, wok := w[k]
+				p := joinPath(path, k)
+				if !gok || !wok {
+					out = append(out, jsonDiff{Path: p, Got: gv, Want: wv, GotOK: gok, WantOK: wok})
+					continue
+				}
+				out = diffJSON(p, gv, wv, out)
+			}
+			return out
+		}
+	case []any:
+		if w, ok := want.([]any); ok {
+			for i := 0; i < max(len(g), len(w)); i++ {
+				p := path + "[" + strconv.Itoa(i) + "]"
+				switch {
+				case i >= len(g):
+					out = append(out, jsonDiff{Path: p, Want: w[i], WantOK: true})
+				case i >= len(w):
+					out = append(out, jsonDiff{Path: p, Got: g[i], GotOK: true})
+				default:
+					out = diffJSON(p, g[i], w[i], out)
+				}
+			}
+			return out
+		}
+	case json.Number:
+		if w, ok := want.(json.Number); ok && numbersEqual(g, w) {
+			return out
+		}
+	default:
+		if got == want {
+			return out
+		}
+	}
+	return append(out, jsonDiff{Path: path, Got: got, Want: want, GotOK: true, WantOK: true})
+}
+
+func numbersEqual(a, b json.Number) bool {
+	if a == b {
+		return true
+	}
+	x, errX := a.Float64()
+	y, errY := b.Float64()
+	return errX == nil && errY == nil && x == y
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
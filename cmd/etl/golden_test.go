@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s-log-etl/internal/config"
+)
+
+func TestDiffJSON(t *testing.T) {
+	tests := []struct {
+		name      string
+		got, want string
+		diffs     []string
+	}{
+		{"equal", `{"a":1,"b":[1,{"c":"x"}]}`, `{"b":[1,{"c":"x"}],"a":1.0}`, nil},
+		{"changed value", `{"a":{"b":"x"}}`, `{"a":{"b":"y"}}`, []string{`a.b: got "x", want "y"`}},
+		{"missing and extra keys", `{"a":1,"b":2}`, `{"b":2,"c":3}`, []string{`a: got 1, not expected`, `c: missing, want 3`}},
+		{"array elements", `{"a":[1,2]}`, `{"a":[1,3,4]}`, []string{`a[1]: got 2, want 3`, `a[2]: missing, want 4`}},
+		{"type change", `{"a":"1"}`, `{"a":1}`, []string{`a: got "1", want 1`}},
+		{"null versus object", `{"a":null}`, `{"a":{}}`, []string{`a: got null, want {}`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := decodeGolden([]byte(tt.got))
+			if err != nil {
+				t.Fatal(err)
+			}
+			w, err := decodeGolden([]byte(tt.want))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var diffs []string
+			for _, d := range diffJSON("", g, w, nil) {
+				diffs = append(diffs, d.String())
+			}
+			if strings.Join(diffs, "\n") != strings.Join(tt.diffs, "\n") {
+				t.Errorf("diffs:\n%s\nwant:\n%s", strings.Join(diffs, "\n"), strings.Join(tt.diffs, "\n"))
+			}
+		})
+	}
+}
+
+func TestGolden_UpdateThenCompare(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.InputPath = filepath.Join("..", "..", "examples", "k8s_logs.jsonl")
+	cfg.ReportPath = filepath.Join(dir, "report.json")
+	cfg.MaxWorkers = 4
+	cfg.RedactKeys = []string{"token"}
+	expected := filepath.Join(dir, "expected.jsonl")
+
+	got, err := runGolden(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) == 0 {
+		t.Fatal("no records produced")
+	}
+	if err := writeGolden(expected, got); err != nil {
+		t.Fatal(err)
+	}
+	want, err := readGolden(expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := compareGolden(&out, got, want, 5); err != nil {
+		t.Fatalf("fresh expected file should match: %v\n%s", err, out.String())
+	}
+
+	// Changing the config changes the output.
+	cfg.RedactKeys = nil
+	got, err = runGolden(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	err = compareGolden(&out, got, want, 5)
+	if !errors.Is(err, errGoldenMismatch) {
+		t.Fatalf("err = %v, want errGoldenMismatch", err)
+	}
+	if !strings.Contains(out.String(), "Fields.token: got ") {
+		t.Errorf("mismatch output should name the field:\n%s", out.String())
+	}
+}
+
+func TestCompareGolden_CountsAndLimit(t *testing.T) {
+	got := [][]byte{[]byte(`{"a":1}`), []byte(`{"a":2}`), []byte(`{"a":3}`)}
+	want := [][]byte{[]byte(`{"a":0}`), []byte(`{"a":0}`)}
+	var out bytes.Buffer
+	err := compareGolden(&out, got, want, 1)
+	if !errors.Is(err, errGoldenMismatch) || !strings.Contains(err.Error(), "3 records differ") {
+		t.Fatalf("err = %v", err)
+	}
+	if s := out.String(); !strings.Contains(s, "record 1:") || strings.Contains(s, "record 2:") || !strings.Contains(s, "... and 2 more") {
+		t.Errorf("unexpected output:\n%s", s)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// healthState tracks what /readyz reports. A nil *healthState is valid and
+// ignores every update, so the pipeline can report unconditionally.
+type healthState struct {
+	mu             sync.Mutex
+	now            func() time.Time
+	staleAfter     time.Duration // 0 disables the last-write check
+	queueFullAfter time.Duration // 0 disables the queue-full check
+	sinkOpen       bool
+	sinkOpenedAt   time.Time
+	lastWrite      time.Time
+	queueFullSince time.Time
+}
+
+func newHealthState(staleAfter, queueFullAfter time.Duration) *healthState {
+	return &healthState{now: time.Now, staleAfter: staleAfter, queueFullAfter: queueFullAfter}
+}
+
+// SinkOpened marks the sink as ready to accept records.
+func (h *healthState) SinkOpened() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sinkOpen = true
+	h.sinkOpenedAt = h.now()
+}
+
+// SinkClosed marks the sink as gone, e.g. during shutdown.
+func (h *healthState) SinkClosed() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sinkOpen = false
+}
+
+// WriteOK records a successful sink write.
+func (h *healthState) WriteOK() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastWrite = h.now()
+}
+
+// QueueFull records whether the producer is blocked on a full queue.
+func (h *healthState) QueueFull(full bool) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch {
+	case !full:
+		h.queueFullSince = time.Time{}
+	case h.queueFullSince.IsZero():
+		h.queueFullSince = h.now()
+	}
+}
+
+// Ready reports readiness and, when not ready, why.
+func (h *healthState) Ready() (bool, string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.sinkOpen {
+		return false, "sink not open"
+	}
+	now := h.now()
+	if h.staleAfter > 0 {
+		last := h.lastWrite
+		if last.IsZero() {
+			last = h.sinkOpenedAt
+		}
+		if since := now.Sub(last); since > h.staleAfter {
+			return false, fmt.Sprintf("no successful write for %s", since.Round(time.Second))
+		}
+	}
+	if h.queueFullAfter > 0 && !h.queueFullSince.IsZero() {
+		if since := now.Sub(h.queueFullSince); since > h.queueFullAfter {
+			return false, fmt.Sprintf("queue full for %s", since.Round(time.Second))
+		}
+	}
+	return true, ""
+}
+
+// Register adds /healthz (the process is up) and /readyz to mux.
+func (h *healthState) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if ok, reason := h.Ready(); !ok {
+			http.Error(w, reason, http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+}
+
+type healthKey struct{}
+
+// withHealth attaches h to ctx for runPipeline.
+func withHealth(ctx context.Context, h *healthState) context.Context {
+	return context.WithValue(ctx, healthKey{}, h)
+}
+
+// healthFrom returns the health state attached to ctx, or nil.
+func healthFrom(ctx context.Context) *healthState {
+	h, _ := ctx.Value(healthKey{}).(*healthState)
+	return h
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s-log-etl/internal/config"
+)
+
+func TestHealthState_Ready(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	h := newHealthState(10*time.Second, 5*time.Second)
+	h.now = func() time.Time { return now }
+
+	check := func(wantReady bool, wantReason string) {
+		t.Helper()
+		ok, reason := h.Ready()
+		if ok != wantReady || !strings.Contains(reason, wantReason) {
+			t.Errorf("Ready() = %v, %q; want %v, %q", ok, reason, wantReady, wantReason)
+		}
+	}
+
+	check(false, "sink not open")
+	h.SinkOpened()
+	check(true, "")
+
+	// Staleness counts from the sink opening until the first write.
+	now = now.Add(11 * time.Second)
+	check(false, "no successful write for 11s")
+	h.WriteOK()
+	check(true, "")
+
+	h.QueueFull(true)
+	now = now.Add(4 * time.Second)
+	h.QueueFull(true) // still full; the original start time is kept
+	check(true, "")
+	now = now.Add(2 * time.Second)
+	check(false, "queue full for 6s")
+	h.QueueFull(false)
+	check(true, "")
+
+	h.SinkClosed()
+	check(false, "sink not open")
+}
+
+func TestHealthState_NilIsNoop(t *testing.T) {
+	var h *healthState
+	h.SinkOpened()
+	h.WriteOK()
+	h.QueueFull(true)
+	h.SinkClosed()
+}
+
+func TestHealthHandler(t *testing.T) {
+	h := newHealthState(0, 0)
+	mux := http.NewServeMux()
+	h.Register(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	get := func(path string) (int, string) {
+		t.Helper()
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if code, _ := get("/healthz"); code != http.StatusOK {
+		t.Errorf("/healthz = %d, want 200", code)
+	}
+	if code, body := get("/readyz"); code != http.StatusServiceUnavailable || !strings.Contains(body, "sink not open") {
+		t.Errorf("/readyz before open = %d %q, want 503", code, body)
+	}
+	h.SinkOpened()
+	if code, _ := get("/readyz"); code != http.StatusOK {
+		t.Errorf("/readyz after open = %d, want 200", code)
+	}
+}
+
+func TestStartHTTPServer_StopsPromptly(t *testing.T) {
+	mux := http.NewServeMux()
+	newHealthState(0, 0).Register(mux)
+	stop, addr, err := startHTTPServer("127.0.0.1:0", mux)
+	if err != nil {
+		t.Fatalf("startHTTPServer: %v", err)
+	}
+	resp, err := http.Get("http://" + addr + "/healthz")
+	if err != nil {
+		t.Fatalf("GET /healthz: %v", err)
+	}
+	resp.Body.Close()
+
+	start := time.Now()
+	stop()
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("stop took %v", elapsed)
+	}
+	if _, err := http.Get("http://" + addr + "/healthz"); err == nil {
+		t.Error("expected the server to be closed after stop")
+	}
+}
+
+func TestStartServers_SharesAddress(t *testing.T) {
+	// Reserve a free port, then give it to both endpoints.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	cfg := config.Default()
+	cfg.HealthListen = addr
+	cfg.PprofListen = addr
+	ctx, stop, err := startServers(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("startServers: %v", err)
+	}
+	defer stop()
+	if healthFrom(ctx) == nil {
+		t.Error("expected the health state on the returned context")
+	}
+	for _, path := range []string{"/healthz", "/debug/pprof/"} {
+		resp, err := http.Get("http://" + addr + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("GET %s = %d, want 200", path, resp.StatusCode)
+		}
+	}
+}
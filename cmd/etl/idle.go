@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s-log-etl/internal/clock"
+	"k8s-log-etl/internal/logger"
+	"k8s-log-etl/internal/report"
+	"k8s-log-etl/internal/source"
+)
+
+// errInputIdle ends a run whose input stayed silent for input_idle_timeout
+// with input_idle_action exit. main exits with exitInputIdle for it.
+var errInputIdle = errors.New("input idle")
+
+// exitInputIdle is EX_TEMPFAIL from sysexits.h, telling a supervisor the
+// run may succeed once restarted with its producer.
+const exitInputIdle = 75
+
+// idleSource moves the blocking reads of a source onto their own
+// goroutine so that Next can give up waiting: when its ctx is done, or,
+// with exit set, when no record has arrived for timeout. Without exit it
+// logs a warning each time timeout passes and keeps waiting. Idleness is
+// counted from when Next asks for a record, so time the pipeline spends
+// on the previous one, e.g. blocked on a slow sink, is not. With no
+// timeout Next only gives up when ctx is done, which max_duration uses to
+// stop a read blocked on a silent pipe.
+//
+// The two goroutines take turns: the reader only reads after Next asks it
+// to and Next only returns once the reader is done, so a record's Data
+// stays valid until the next Next as with any source. When Next gives up,
+// the reader is left blocked in its read; it exits once the read returns,
+// which for a source that waits on ctx is right away.
+type idleSource struct {
+	open    func() source.Source
+	timeout time.Duration
+	exit    bool
+	rep     *report.Report
+	clock   clock.Clock
+
+	inner   source.Source        // set by the reader before its first result
+	next    chan context.Context // asks the reader for a record
+	results chan idleResult      // the reader's results
+	pending bool                 // the reader is reading
+	err     error                // returned by every Next once set
+
+	// mu guards shared, the inner source again for Report, which may run
+	// while the reader is reading.
+	mu     sync.Mutex
+	shared source.Source
+
+	since time.Time // when Next asked the reader for the record it reads
+	stats report.InputIdleStats
+}
+
+type idleResult struct {
+	rec source.Record
+	err error
+}
+
+// newIdleSource returns an idleSource over the source open builds. open
+// runs on the reader goroutine too, since format detection already reads.
+// Silences are timed on c.
+func newIdleSource(open func() source.Source, timeout time.Duration, exit bool, rep *report.Report, c clock.Clock) *idleSource {
+	s := &idleSource{
+		open:    open,
+		timeout: timeout,
+		exit:    exit,
+		rep:     rep,
+		clock:   c,
+		next:    make(chan context.Context),
+		results: make(chan idleResult, 1),
+	}
+	if timeout > 0 {
+		s.stats.TimeoutSeconds = timeout.Seconds()
+		rep.SetInputIdle(s.stats)
+	}
+	go s.read()
+	return s
+}
+
+func (s *idleSource) read() {
+	for ctx := range s.next {
+		if s.inner == nil {
+			s.inner = s.open()
+			s.mu.Lock()
+			s.shared = s.inner
+			s.mu.Unlock()
+		}
+		rec, err := s.inner.Next(ctx)
+		s.results <- idleResult{rec, err}
+	}
+}
+
+func (s *idleSource) Next(ctx context.Context) (source.Record, error) {
+	if s.err != nil {
+		return source.Record{}, s.err
+	}
+	if !s.pending {
+		s.since = s.clock.Now()
+		s.next <- ctx
+		s.pending = true
+	}
+	wait := s.timeout - s.clock.Now().Sub(s.since)
+	for {
+		var timer <-chan time.Time
+		if s.timeout > 0 {
+			timer = s.clock.After(wait)
+		}
+		select {
+		case r := <-s.results:
+			s.pending = false
+			s.arrived(ctx)
+			if r.err != nil {
+				s.finish(r.err)
+			}
+			return r.rec, r.err
+		case <-ctx.Done():
+			s.finish(ctx.Err())
+			return source.Record{}, s.err
+		case <-timer:
+			idle := s.clock.Now().Sub(s.since)
+			s.stats.Warnings++
+			s.stats.IdleSeconds = idle.Seconds()
+			s.stats.MaxIdleSeconds = max(s.stats.MaxIdleSeconds, idle.Seconds())
+			if s.exit {
+				s.stats.Exited = true
+				logger.ErrorContext(ctx, "no input received, ending run", "idle_seconds", idle.Seconds(), "timeout", s.timeout.String())
+				s.finish(fmt.Errorf("%w: no complete record for %s", errInputIdle, idle.Round(time.Second)))
+				return source.Record{}, s.err
+			}
+			logger.WarnContext(ctx, "no input received", "idle_seconds", idle.Seconds(), "timeout", s.timeout.String())
+			s.rep.SetInputIdle(s.stats)
+			wait = s.timeout
+		}
+	}
+}
+
+// arrived records that a record, or the end of the input, came in.
+func (s *idleSource) arrived(ctx context.Context) {
+	idle := s.clock.Now().Sub(s.since)
+	if s.stats.IdleSeconds > 0 {
+		logger.InfoContext(ctx, "input resumed", "idle_seconds", idle.Seconds())
+	}
+	s.stats.IdleSeconds = 0
+	s.stats.MaxIdleSeconds = max(s.stats.MaxIdleSeconds, idle.Seconds())
+}
+
+// finish stops the source with err, letting the reader exit once it is
+// no longer blocked.
+func (s *idleSource) finish(err error) {
+	s.err = err
+	close(s.next)
+	if s.pending {
+		s.stats.IdleSeconds = s.clock.Now().Sub(s.since).Seconds()
+	}
+	if s.timeout > 0 {
+		s.rep.SetInputIdle(s.stats)
+	}
+}
+
+// Report implements source.Reporter for the source it wraps.
+func (s *idleSource) Report(rep *report.Report) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r, ok := s.shared.(source.Reporter); ok {
+		r.Report(rep)
+	}
+}
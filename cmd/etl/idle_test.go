@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s-log-etl/internal/clock"
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/report"
+	"k8s-log-etl/internal/sink"
+	"k8s-log-etl/internal/source"
+)
+
+const idleTestLine = `{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"boom","service":"api"}` + "\n"
+
+func idleTestConfig(t *testing.T, timeout, action string) config.Config {
+	cfg := config.Default()
+	cfg.ReportPath = filepath.Join(t.TempDir(), "report.json")
+	cfg.InputIdleTimeout = timeout
+	cfg.InputIdleAction = action
+	cfg.BatchSize = 0
+	if err := config.Validate(cfg); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestRunPipeline_InputIdleExit(t *testing.T) {
+	// The producer writes two records, then hangs without closing the pipe.
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go io.WriteString(pw, idleTestLine+idleTestLine)
+
+	cfg := idleTestConfig(t, "50ms", config.IdleExit)
+	mem := sink.NewMemorySink()
+	rep := report.NewReport()
+	done := make(chan error, 1)
+	go func() { done <- runPipeline(withBaseSink(context.Background(), mem), pr, cfg, rep) }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("run did not end on a silent input")
+	}
+	if !errors.Is(err, errInputIdle) {
+		t.Fatalf("err = %v, want errInputIdle", err)
+	}
+	if n := len(mem.Records()); n != 2 {
+		t.Errorf("%d records written, want the 2 read before the silence", n)
+	}
+	idle := rep.InputIdle
+	if rep.Shutdown.Reason != report.StopIdle || !idle.Exited || idle.Warnings != 1 || idle.IdleSeconds < 0.05 {
+		t.Errorf("reason %q, idle %+v", rep.Shutdown.Reason, idle)
+	}
+	if !strings.Contains(rep.Prometheus(), "etl_input_idle_seconds ") {
+		t.Error("etl_input_idle_seconds missing from the metrics")
+	}
+}
+
+func TestRunPipeline_InputIdleWarnKeepsWaiting(t *testing.T) {
+	pr, pw := io.Pipe()
+	go func() {
+		io.WriteString(pw, idleTestLine)
+		time.Sleep(150 * time.Millisecond)
+		io.WriteString(pw, idleTestLine)
+		pw.Close()
+	}()
+
+	cfg := idleTestConfig(t, "40ms", "")
+	mem := sink.NewMemorySink()
+	rep := report.NewReport()
+	if err := runPipeline(withBaseSink(context.Background(), mem), pr, cfg, rep); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+	if n := len(mem.Records()); n != 2 {
+		t.Errorf("%d records written, want 2", n)
+	}
+	idle := rep.InputIdle
+	if rep.Shutdown.Reason != report.StopEOF || idle.Exited || idle.Warnings < 2 || idle.MaxIdleSeconds < 0.1 || idle.IdleSeconds != 0 {
+		t.Errorf("reason %q, idle %+v; want a warning per timeout and a clean EOF", rep.Shutdown.Reason, idle)
+	}
+}
+
+func TestRunPipeline_InputIdleCancelUnblocksRead(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+
+	cfg := idleTestConfig(t, "1h", "")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- runPipeline(withBaseSink(ctx, sink.NewMemorySink()), pr, cfg, report.NewReport()) }()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("err = %v, want context.Canceled", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("cancellation did not end a run blocked on its input")
+	}
+}
+
+// chanSource returns the lines sent on it as records, waiting for each.
+type chanSource chan string
+
+func (c chanSource) Next(ctx context.Context) (source.Record, error) {
+	select {
+	case line := <-c:
+		return source.Record{Data: []byte(line)}, nil
+	case <-ctx.Done():
+		return source.Record{}, ctx.Err()
+	}
+}
+
+func TestIdleSourceSlowConsumerIsNotIdle(t *testing.T) {
+	fake := clock.NewFake(time.Unix(1_700_000_000, 0))
+
+	in := make(chanSource, 1)
+	rep := report.NewReport()
+	s := newIdleSource(func() source.Source { return in }, 10*time.Second, true, rep, fake)
+	ctx := context.Background()
+	in <- "first"
+	if rec, err := s.Next(ctx); err != nil || string(rec.Data) != "first" {
+		t.Fatalf("Next = %q, %v", rec.Data, err)
+	}
+	// The pipeline holds the first record for longer than the timeout, as
+	// with a sink that is slow to take it; the input was not idle then.
+	fake.Advance(time.Minute)
+	got := make(chan error, 1)
+	go func() {
+		rec, err := s.Next(ctx)
+		if err == nil && string(rec.Data) != "second" {
+			err = fmt.Errorf("record %q", rec.Data)
+		}
+		got <- err
+	}()
+	fake.BlockUntil(1)
+	fake.Advance(5 * time.Second)
+	in <- "second"
+	if err := <-got; err != nil {
+		t.Fatalf("Next after a slow write = %v, want the second record", err)
+	}
+	if s.stats.Warnings != 0 || s.stats.MaxIdleSeconds != 5 {
+		t.Errorf("idle %+v, want only the 5s waited counted", s.stats)
+	}
+
+	// Silence once Next waits does end the run, counted from then. The
+	// timer of the wait for the second record is still pending.
+	go func() {
+		_, err := s.Next(ctx)
+		got <- err
+	}()
+	fake.BlockUntil(2)
+	fake.Advance(10 * time.Second)
+	if err := <-got; !errors.Is(err, errInputIdle) {
+		t.Fatalf("Next on a silent input = %v, want errInputIdle", err)
+	}
+	if idle := rep.InputIdle; !idle.Exited || idle.IdleSeconds != 10 {
+		t.Errorf("idle %+v", idle)
+	}
+}
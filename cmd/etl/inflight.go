@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"k8s-log-etl/internal/report"
+)
+
+// inflightLimiter caps the estimated bytes of records that have been
+// queued but not yet written. A nil *inflightLimiter imposes no cap.
+type inflightLimiter struct {
+	rep   *report.Report
+	mu    sync.Mutex
+	stats report.InFlightStats
+	wake  chan struct{} // closed and replaced on every release
+}
+
+// newInflightLimiter returns a limiter for maxBytes, or nil when maxBytes
+// is not positive.
+func newInflightLimiter(maxBytes int64, rep *report.Report) *inflightLimiter {
+	if maxBytes <= 0 {
+		return nil
+	}
+	l := &inflightLimiter{rep: rep, wake: make(chan struct{})}
+	l.stats.MaxBytes = maxBytes
+	rep.SetInFlight(l.stats)
+	return l
+}
+
+// acquire blocks until n more bytes fit under the cap, then counts them.
+// A record larger than the cap is let through once nothing else is in
+// flight, so it cannot stall the run. It returns false, counting nothing,
+// if ctx is done first.
+func (l *inflightLimiter) acquire(ctx context.Context, n int64) bool {
+	if l == nil {
+		return true
+	}
+	waited := false
+	for {
+		l.mu.Lock()
+		if l.stats.CurrentBytes == 0 || l.stats.CurrentBytes+n <= l.stats.MaxBytes {
+			l.stats.CurrentBytes += n
+			l.stats.PeakBytes = max(l.stats.PeakBytes, l.stats.CurrentBytes)
+			l.rep.SetInFlight(l.stats)
+			l.mu.Unlock()
+			return true
+		}
+		if !waited {
+			waited = true
+			l.stats.Waits++
+		}
+		wake := l.wake
+		l.mu.Unlock()
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// release uncounts n bytes once their record has been written or failed.
+func (l *inflightLimiter) release(n int64) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.stats.CurrentBytes -= n
+	l.rep.SetInFlight(l.stats)
+	close(l.wake)
+	l.wake = make(chan struct{})
+	l.mu.Unlock()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"k8s-log-etl/internal/report"
+)
+
+func TestInflightLimiter_BlocksUntilRelease(t *testing.T) {
+	rep := report.NewReport()
+	l := newInflightLimiter(100, rep)
+	if !l.acquire(context.Background(), 60) {
+		t.Fatal("first acquire failed")
+	}
+
+	acquired := make(chan bool)
+	go func() { acquired <- l.acquire(context.Background(), 60) }()
+	select {
+	case <-acquired:
+		t.Fatal("acquire over the cap did not block")
+	case <-time.After(20 * time.Millisecond):
+	}
+	l.release(60)
+	if !<-acquired {
+		t.Fatal("acquire failed after release")
+	}
+
+	want := report.InFlightStats{MaxBytes: 100, CurrentBytes: 60, PeakBytes: 60, Waits: 1}
+	if rep.InFlight != want {
+		t.Errorf("stats = %+v, want %+v", rep.InFlight, want)
+	}
+}
+
+func TestInflightLimiter_OversizedRecordPassesAlone(t *testing.T) {
+	l := newInflightLimiter(100, report.NewReport())
+	if !l.acquire(context.Background(), 500) {
+		t.Fatal("oversized record should pass when nothing is in flight")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if l.acquire(ctx, 1) {
+		t.Fatal("acquire should wait while the oversized record is in flight")
+	}
+	l.release(500)
+	if !l.acquire(context.Background(), 1) {
+		t.Fatal("acquire failed after release")
+	}
+}
+
+func TestInflightLimiter_Disabled(t *testing.T) {
+	var l *inflightLimiter = newInflightLimiter(0, report.NewReport())
+	if l != nil {
+		t.Fatal("expected no limiter for a zero cap")
+	}
+	if !l.acquire(context.Background(), 1<<40) {
+		t.Fatal("nil limiter should never block")
+	}
+	l.release(1 << 40)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+
+	"k8s-log-etl/internal/sink"
+)
+
+// inputAck settles one input record, calling its source.Record Ack once
+// every record read from it is done with. The reader holds a reference
+// until it moves on to the next input record, and each queued record holds
+// one until its worker has written or dead-lettered it; settle runs when
+// the last is released, reporting whether every holder succeeded. A nil
+// *inputAck does nothing, so inputs without acknowledgements pay nothing
+// for them.
+type inputAck struct {
+	refs   atomic.Int32
+	failed atomic.Bool
+	settle func(ok bool)
+}
+
+// newInputAck returns an inputAck holding the reader's reference, or nil
+// when settle is nil.
+func newInputAck(settle func(ok bool)) *inputAck {
+	if settle == nil {
+		return nil
+	}
+	a := &inputAck{settle: settle}
+	a.refs.Store(1)
+	return a
+}
+
+// hold takes a reference for a queued record.
+func (a *inputAck) hold() {
+	if a != nil {
+		a.refs.Add(1)
+	}
+}
+
+// fail marks the input record as not done with, so it is not
+// acknowledged.
+func (a *inputAck) fail() {
+	if a != nil {
+		a.failed.Store(true)
+	}
+}
+
+// release drops a reference, failing the input record unless ok.
+func (a *inputAck) release(ok bool) {
+	if a == nil {
+		return
+	}
+	if !ok {
+		a.failed.Store(true)
+	}
+	if a.refs.Add(-1) == 0 {
+		a.settle(!a.failed.Load())
+	}
+}
+
+// forWrite returns the context to write a queued record holding a with,
+// and settle, which the worker calls with the write's outcome in place of
+// release. A buffering sink may take the record for a later flush: then
+// the flush's outcome releases the reference instead, calling failed when
+// the flush fails. Either way the reference is released once.
+func (a *inputAck) forWrite(ctx context.Context, failed func(err error)) (writeCtx context.Context, settle func(ok bool)) {
+	if a == nil {
+		return ctx, func(bool) {}
+	}
+	var released atomic.Bool
+	release := func(ok bool) {
+		if released.CompareAndSwap(false, true) {
+			a.release(ok)
+		}
+	}
+	writeCtx, pending := sink.WithPending(ctx, func(err error) {
+		if err != nil {
+			failed(err)
+		}
+		release(err == nil)
+	})
+	return writeCtx, func(ok bool) {
+		if ok && pending.Deferred() {
+			return
+		}
+		release(ok)
+	}
+}
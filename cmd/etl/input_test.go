@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/report"
+	"k8s-log-etl/internal/sink"
+	"k8s-log-etl/internal/source"
+)
+
+func TestRunPipeline_JSONArrayInput(t *testing.T) {
+	input := `[
+  {"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"one","service":"api"},
+  {"ts":"2024-01-01T12:00:01Z","level":"INFO","msg":"two","service":"api"},
+  "not an object",
+  {"ts":"2024-01-01T12:00:02Z","level":"WARN","msg":"three","service":"api"}
+]`
+	cfg := config.Default()
+	cfg.OutputType = "stdout"
+	cfg.ReportPath = t.TempDir() + "/report.json"
+	rep := report.NewReport()
+	if err := runPipeline(context.Background(), strings.NewReader(input), cfg, rep); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+	if rep.TotalLines != 4 || rep.JSONParsed != 3 || rep.JSONFailed != 1 || rep.WrittenOK != 2 {
+		t.Errorf("unexpected counts: lines=%d parsed=%d failed=%d written=%d", rep.TotalLines, rep.JSONParsed, rep.JSONFailed, rep.WrittenOK)
+	}
+}
+
+func TestRunPipeline_LargeLines(t *testing.T) {
+	pad := strings.Repeat("x", 4096)
+	lines := []string{
+		`{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"small","service":"api"}`,
+		`{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"heap dump","service":"api","heap":"` + pad + `","team":"core"}`,
+		`{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"huge","service":"api","heap":"` + pad + pad + pad + `"}`,
+	}
+	input := strings.Join(lines, "\n")
+	cfg := config.Default()
+	cfg.ReportPath = filepath.Join(t.TempDir(), "report.json")
+	cfg.LinePruneBytes, cfg.MaxLineBytes = 1024, 8192
+	if err := config.Validate(cfg); err != nil {
+		t.Fatal(err)
+	}
+	mem := sink.NewMemorySink()
+	rep := report.NewReport()
+	if err := runPipeline(withBaseSink(context.Background(), mem), strings.NewReader(input), cfg, rep); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+	records := mem.Records()
+	if len(records) != 2 {
+		t.Fatalf("wrote %d records, want 2", len(records))
+	}
+	var pruned struct {
+		Message string         `json:"message"`
+		Fields  map[string]any `json:"fields"`
+	}
+	if err := json.Unmarshal(records[1], &pruned); err != nil {
+		t.Fatal(err)
+	}
+	if pruned.Message != "heap dump" || pruned.Fields[source.TruncatedKey] != true || pruned.Fields["heap"] != nil || pruned.Fields["team"] != nil {
+		t.Errorf("pruned record = %s", records[1])
+	}
+	if rep.TotalLines != 3 || rep.JSONFailed != 1 {
+		t.Errorf("lines=%d failed=%d", rep.TotalLines, rep.JSONFailed)
+	}
+	want := report.LargeLineStats{PruneBytes: 1024, MaxBytes: 8192, Pruned: 1, TooLong: 1, DroppedKeys: 2, LargestBytes: int64(len(lines[2]))}
+	if rep.LargeLines == nil || *rep.LargeLines != want {
+		t.Errorf("large lines = %+v, want %+v", rep.LargeLines, want)
+	}
+	if !strings.Contains(rep.Prometheus(), "etl_lines_pruned_total 1") {
+		t.Error("pruned lines missing from the metrics")
+	}
+
+	cfg.MaxLineBytes = 512
+	if err := config.Validate(cfg); err == nil || !strings.Contains(err.Error(), "must be larger than line_prune_bytes") {
+		t.Errorf("Validate = %v", err)
+	}
+}
+
+func TestRunPipeline_PrunedLineKeepsUnwrapKeys(t *testing.T) {
+	// A fluentd line wrapping a long record: pruning keeps the log key
+	// whole, so the record it holds is still normalized.
+	inner, _ := json.Marshal(map[string]string{"ts": "2024-01-01T12:00:00Z", "level": "ERROR", "msg": "dump " + strings.Repeat("x", 3072), "service": "api"})
+	line, _ := json.Marshal(map[string]string{"log": string(inner), "stream": "stderr", "tag": strings.Repeat("t", 2048)})
+	cfg := config.Default()
+	cfg.ReportPath = filepath.Join(t.TempDir(), "report.json")
+	cfg.LinePruneBytes = 1024
+	cfg.UnwrapKeys = []string{"log"}
+	if err := config.Validate(cfg); err != nil {
+		t.Fatal(err)
+	}
+	mem := sink.NewMemorySink()
+	rep := report.NewReport()
+	if err := runPipeline(withBaseSink(context.Background(), mem), strings.NewReader(string(line)+"\n"), cfg, rep); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+	if rep.NormalizedFailed != 0 || rep.LargeLines == nil || rep.LargeLines.Pruned != 1 || rep.LargeLines.DroppedKeys != 2 {
+		t.Fatalf("normalize failures %d (%v), large lines %+v", rep.NormalizedFailed, rep.NormalizeFailuresByReason, rep.LargeLines)
+	}
+	records := mem.Records()
+	if len(records) != 1 || !strings.Contains(string(records[0]), `"dump xxx`) {
+		t.Errorf("wrote %d records: %.200s", len(records), records)
+	}
+}
+
+// ackSource is an input that waits for records, as a NATS consumer does,
+// and delivers a record again when it is acked as failed.
+type ackSource struct {
+	w *flakyWriter // checked for the record when it is acked
+
+	mu      sync.Mutex
+	queue   []string
+	acked   []string
+	written []bool // whether each ack found its record written
+	failed  int
+}
+
+func (s *ackSource) Next(ctx context.Context) (source.Record, error) {
+	for {
+		s.mu.Lock()
+		if len(s.queue) > 0 {
+			line := s.queue[0]
+			s.queue = s.queue[1:]
+			s.mu.Unlock()
+			return source.Record{Data: []byte(line), Ack: func(ok bool) { s.ack(line, ok) }}, nil
+		}
+		s.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return source.Record{}, ctx.Err()
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+}
+
+func (s *ackSource) ack(line string, ok bool) {
+	var rec struct{ Msg string }
+	json.Unmarshal([]byte(line), &rec)
+	written := slices.Contains(s.w.messages(), rec.Msg)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !ok {
+		s.failed++
+		s.queue = append(s.queue, line)
+		return
+	}
+	s.acked = append(s.acked, rec.Msg)
+	s.written = append(s.written, written)
+}
+
+func (s *ackSource) ackedAll(n int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.acked) == n
+}
+
+func TestRunPipeline_AcksAfterWrite(t *testing.T) {
+	// The first write fails; without a DLQ its record is acked as failed,
+	// and written once the input delivers it again.
+	w := &flakyWriter{fails: 1}
+	src := &ackSource{w: w}
+	for i := range 5 {
+		src.queue = append(src.queue, fmt.Sprintf(`{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"m%d","service":"api"}`, i))
+	}
+	cfg := idleTestConfig(t, "", "")
+	cfg.SinkMaxRetries = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- runPipelineFrom(withBaseSink(ctx, w), func() source.Source { return src }, cfg, report.NewReport())
+	}()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !src.ackedAll(5) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil && !errors.Is(err, context.Canceled) {
+			t.Fatalf("runPipelineFrom: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("cancelling did not end the wait for the next record")
+	}
+
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	got := slices.Sorted(slices.Values(src.acked))
+	if want := []string{"m0", "m1", "m2", "m3", "m4"}; !slices.Equal(got, want) || src.failed != 1 {
+		t.Errorf("acked %v and failed %d, want %v and 1", src.acked, src.failed, want)
+	}
+	if slices.Contains(src.written, false) {
+		t.Errorf("a record was acked before it was written: %v", src.written)
+	}
+}
+
+// runAckSource runs the pipeline from src into w until until reports
+// true, then cancels it.
+func runAckSource(t *testing.T, w *flakyWriter, src *ackSource, cfg config.Config, until func() bool) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- runPipelineFrom(withBaseSink(ctx, w), func() source.Source { return src }, cfg, report.NewReport())
+	}()
+	deadline := time.Now().Add(10 * time.Second)
+	for !until() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("cancelling did not end the wait for the next record")
+	}
+}
+
+func TestRunPipeline_AcksAfterBatchFlush(t *testing.T) {
+	// With the default batch_size the records wait in the batch; the first
+	// flush fails, so none is acked until a later flush writes them.
+	w := &flakyWriter{fails: 1}
+	src := &ackSource{w: w}
+	for i := range 5 {
+		src.queue = append(src.queue, fmt.Sprintf(`{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"m%d","service":"api"}`, i))
+	}
+	cfg := config.Default()
+	cfg.ReportPath = filepath.Join(t.TempDir(), "report.json")
+	cfg.BatchFlushInterval = 50
+	cfg.SinkMaxRetries = 0
+	runAckSource(t, w, src, cfg, func() bool { return src.ackedAll(5) })
+
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	got := slices.Sorted(slices.Values(src.acked))
+	if want := []string{"m0", "m1", "m2", "m3", "m4"}; !slices.Equal(got, want) || src.failed == 0 {
+		t.Errorf("acked %v and failed %d, want %v after a failed flush", src.acked, src.failed, want)
+	}
+	if slices.Contains(src.written, false) {
+		t.Errorf("a record was acked before its batch was flushed: %v", src.written)
+	}
+}
+
+func TestRunPipeline_FailedFlushLeavesRecordsUnacked(t *testing.T) {
+	w := &flakyWriter{fails: 1 << 30}
+	src := &ackSource{w: w}
+	for i := range 3 {
+		src.queue = append(src.queue, fmt.Sprintf(`{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"m%d","service":"api"}`, i))
+	}
+	cfg := config.Default()
+	cfg.ReportPath = filepath.Join(t.TempDir(), "report.json")
+	cfg.BatchFlushInterval = 50
+	cfg.SinkMaxRetries = 0
+	runAckSource(t, w, src, cfg, func() bool {
+		src.mu.Lock()
+		defer src.mu.Unlock()
+		return src.failed >= 3
+	})
+
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	if len(src.acked) != 0 || src.failed < 3 {
+		t.Errorf("acked %v and failed %d, want every record failed while the sink's flushes fail", src.acked, src.failed)
+	}
+}
+
+// TestJournalctlHelper stands in for journalctl in the journald tests,
+// printing three entries. It is a no-op unless ETL_JOURNALCTL_HELPER is
+// set.
+func TestJournalctlHelper(t *testing.T) {
+	if os.Getenv("ETL_JOURNALCTL_HELPER") == "" {
+		return
+	}
+	for i := range 3 {
+		fmt.Printf(`{"__CURSOR":"c%d","__REALTIME_TIMESTAMP":"1704110400000000","PRIORITY":"3","_SYSTEMD_UNIT":"api.service","MESSAGE":"m%d"}`+"\n", i, i)
+	}
+	os.Exit(0)
+}
+
+func TestRunPipeline_JournaldCursorFollowsFlushes(t *testing.T) {
+	t.Setenv("ETL_JOURNALCTL_HELPER", "1")
+	cfg := config.Default()
+	cfg.ReportPath = filepath.Join(t.TempDir(), "report.json")
+	cfg.InputType = config.InputJournald
+	cfg.JournaldCommand = []string{os.Args[0], "-test.run=^TestJournalctlHelper$", "--"}
+	cfg.JournaldCursorFile = filepath.Join(t.TempDir(), "journal.cursor")
+	cfg.SinkMaxRetries = 0
+	// Only the final flush writes the batch, however slow the run.
+	cfg.BatchFlushInterval = 3_600_000
+	run := func(w *flakyWriter) (*report.Report, error) {
+		t.Helper()
+		open, closeInput, err := openInput(context.Background(), cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer closeInput()
+		rep := report.NewReport()
+		return rep, runPipelineFrom(withBaseSink(context.Background(), w), open, cfg, rep)
+	}
+
+	// The records wait in the batch for the flush, which fails: none is
+	// acked and the cursor stays where it was.
+	rep, err := run(&flakyWriter{fails: 1 << 30})
+	if err == nil {
+		t.Fatal("runPipelineFrom succeeded with every write failing")
+	}
+	if in := rep.JournaldInput; in == nil || in.Acked != 0 || in.Unacked != 3 {
+		t.Fatalf("journald_input = %+v after a failed flush, want 3 records unacked", in)
+	}
+	if data, err := os.ReadFile(cfg.JournaldCursorFile); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("cursor file = %q, %v after a failed flush, want none", data, err)
+	}
+
+	w := &flakyWriter{}
+	if _, err := run(w); err != nil {
+		t.Fatalf("runPipelineFrom: %v", err)
+	}
+	if data, err := os.ReadFile(cfg.JournaldCursorFile); err != nil || string(data) != "c2\n" || len(w.messages()) != 3 {
+		t.Errorf("cursor file = %q, %v after writing %v", data, err, w.messages())
+	}
+}
+
+func TestRunPipeline_WaitingInputMaxDuration(t *testing.T) {
+	w := &flakyWriter{}
+	src := &ackSource{w: w, queue: []string{idleTestLine[:len(idleTestLine)-1]}}
+	cfg := idleTestConfig(t, "", "")
+	cfg.InputType = config.InputNATS
+	cfg.MaxDuration = "300ms"
+	rep := report.NewReport()
+	start := time.Now()
+	err := runPipelineFrom(withBaseSink(context.Background(), w), func() source.Source { return src }, cfg, rep)
+	if !errors.Is(err, errMaxDuration) || strings.Contains(err.Error(), "--skip") {
+		t.Fatalf("runPipelineFrom = %v, want max duration without a --skip hint", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("run took %s, want it to stop waiting for records at max_duration", elapsed)
+	}
+	if rep.Shutdown.Reason != report.StopDuration || len(w.messages()) != 1 || !src.ackedAll(1) {
+		t.Errorf("shutdown %+v after writing %v", rep.Shutdown, w.messages())
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/natsconn"
+	"k8s-log-etl/internal/source"
+	"k8s-log-etl/internal/stages"
+)
+
+// openInput opens the configured input: a NATS consumer with input_type
+// nats, journalctl with input_type journald, cfg.Inputs when set,
+// otherwise cfg.InputPath or stdin. It returns
+// a func that builds the source, which may read to detect the format, and
+// one that closes the input. ctx ends a named pipe input read with
+// input_reopen_on_eof.
+func openInput(ctx context.Context, cfg config.Config) (func() source.Source, func(), error) {
+	if strings.EqualFold(cfg.InputType, config.InputNATS) {
+		s, err := source.NewNATS(source.NATSOptions{
+			Servers: natsconn.Servers(cfg.InputPath), User: cfg.NATSUser, Password: cfg.NATSPassword, Token: cfg.NATSToken,
+			CredsFile: cfg.NATSCredsFile, Stream: cfg.NATSInputStream, Consumer: cfg.NATSInputConsumer,
+			FilterSubject: cfg.NATSInputFilterSubject, MaxAckPending: cfg.NATSInputMaxAckPending, AckWait: cfg.NATSInputAckWaitDuration(),
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("open input: %w", err)
+		}
+		return func() source.Source { return s }, s.Close, nil
+	}
+	if strings.EqualFold(cfg.InputType, config.InputJournald) {
+		s, err := source.NewJournald(source.JournaldOptions{
+			Command: cfg.JournaldCommand, Units: cfg.JournaldUnits, Follow: cfg.JournaldFollow,
+			CursorFile: cfg.JournaldCursorFile, Perm: cfg.FilePerm(), MaxLine: cfg.MaxLineBytes,
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("open input: %w", err)
+		}
+		return func() source.Source { return s }, s.Close, nil
+	}
+	if len(cfg.Inputs) > 0 {
+		open, closeFn, err := openInputs(cfg)
+		if err != nil {
+			return nil, nil, fmt.Errorf("open input: %w", err)
+		}
+		return open, closeFn, nil
+	}
+	in, closeFn, err := inputReader(ctx, cfg.InputPath, cfg.InputReopenOnEOF)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open input: %w", err)
+	}
+	if closeFn == nil {
+		closeFn = func() {}
+	}
+	name := cfg.InputPath
+	if name == "" {
+		name = "-"
+	}
+	return func() source.Source { return source.NewReader(in, cfg.InputFormat, name, lineLimits(cfg)) }, closeFn, nil
+}
+
+// openInputs opens cfg.Inputs, expanding glob patterns, and returns a
+// func that builds one source over them: concatenated in order, or
+// merged by timestamp when cfg.InputMergeSorted is set. The second func
+// closes every file.
+func openInputs(cfg config.Config) (func() source.Source, func(), error) {
+	paths, err := expandInputs(cfg.Inputs)
+	if err != nil {
+		return nil, nil, err
+	}
+	readers := make([]io.Reader, 0, len(paths))
+	var closers []func()
+	closeAll := func() {
+		for _, c := range closers {
+			c()
+		}
+	}
+	for _, p := range paths {
+		in, closeFn, err := inputReader(context.Background(), p, false)
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		readers = append(readers, in)
+		if closeFn != nil {
+			closers = append(closers, closeFn)
+		}
+	}
+	open := func() source.Source {
+		if cfg.InputMergeSorted {
+			return source.Merge(paths, readers, lineLimits(cfg))
+		}
+		return source.Concat(paths, readers, cfg.InputFormat, lineLimits(cfg))
+	}
+	return open, closeAll, nil
+}
+
+// lineLimits returns the limits JSONL lines are read with: cfg's
+// line_prune_bytes, unless negative, and max_line_bytes. Pruning keeps the
+// unwrap_keys, whose values hold the records to normalize.
+func lineLimits(cfg config.Config) source.LineLimits {
+	return source.LineLimits{Prune: max(cfg.LinePruneBytes, 0), Max: cfg.MaxLineBytes, Keep: cfg.UnwrapKeys}
+}
+
+// expandInputs resolves glob patterns, keeping their matches in sorted
+// order. Other entries are kept as they are. A pattern that matches
+// nothing is an error, since it is most likely a typo.
+func expandInputs(patterns []string) ([]string, error) {
+	var paths []string
+	for _, p := range patterns {
+		if !strings.ContainsAny(p, "*?[") {
+			paths = append(paths, p)
+			continue
+		}
+		matches, err := filepath.Glob(p)
+		if err != nil {
+			return nil, fmt.Errorf("input pattern %q: %w", p, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("input pattern %q matches no files", p)
+		}
+		paths = append(paths, matches...)
+	}
+	return paths, nil
+}
+
+// resolveTimestampShift sets cfg.TimestampShiftLatest for timestamp_shift
+// rebase-now: the latest event time in the input files, read once before
+// the run. Records are unwrapped as the run does, and lines that do not
+// parse or have no timestamp are passed over. Other configs are returned
+// as they are.
+func resolveTimestampShift(cfg config.Config) (config.Config, error) {
+	if !cfg.TimestampRebase() {
+		return cfg, nil
+	}
+	paths := []string{cfg.InputPath}
+	if len(cfg.Inputs) > 0 {
+		var err error
+		if paths, err = expandInputs(cfg.Inputs); err != nil {
+			return cfg, err
+		}
+	}
+	var parser stages.Parser
+	unwrap := unwrapOptions(cfg)
+	var latest time.Time
+	for _, p := range paths {
+		err := func() error {
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if st, err := f.Stat(); err != nil {
+				return err
+			} else if !st.Mode().IsRegular() {
+				return fmt.Errorf("%s is not a regular file, which rebase-now needs to read it twice", p)
+			}
+			src := source.NewReader(f, cfg.InputFormat, p, lineLimits(cfg))
+			for {
+				rec, err := src.Next(context.Background())
+				if err == io.EOF {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				records, err := parser.Parse(rec.Data)
+				if err != nil {
+					continue
+				}
+				for _, r := range records {
+					if len(unwrap.Keys) > 0 {
+						stages.Unwrap(r, unwrap)
+					}
+					if ts, err := stages.Timestamp(r); err == nil && ts.After(latest) {
+						latest = ts
+					}
+				}
+			}
+		}()
+		if err != nil {
+			return cfg, fmt.Errorf("timestamp_shift rebase-now: %w", err)
+		}
+	}
+	if latest.IsZero() {
+		return cfg, fmt.Errorf("timestamp_shift rebase-now: no record in the input has a timestamp")
+	}
+	cfg.TimestampShiftLatest = latest
+	return cfg, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/model"
+	"k8s-log-etl/internal/report"
+	"k8s-log-etl/internal/source"
+)
+
+func jsonlAt(secs ...int) string {
+	var b strings.Builder
+	for _, s := range secs {
+		fmt.Fprintf(&b, `{"ts":"2024-01-01T12:00:%02dZ","level":"ERROR","msg":"m%d"}`+"\n", s, s)
+	}
+	return b.String()
+}
+
+func TestExpandInputs(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b.log", "a.log", "c.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := expandInputs([]string{filepath.Join(dir, "*.log"), filepath.Join(dir, "c.txt")})
+	if err != nil {
+		t.Fatalf("expandInputs: %v", err)
+	}
+	want := []string{filepath.Join(dir, "a.log"), filepath.Join(dir, "b.log"), filepath.Join(dir, "c.txt")}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("paths = %v, want %v", got, want)
+	}
+	if _, err := expandInputs([]string{filepath.Join(dir, "*.gz")}); err == nil {
+		t.Error("expected an error for a pattern that matches nothing")
+	}
+}
+
+func TestRunPipeline_Inputs(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"1.jsonl": jsonlAt(1, 3),
+		"2.jsonl": jsonlAt(2, 4),
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tt := range []struct {
+		merge    bool
+		wantMode string
+		want     string
+	}{
+		{merge: false, wantMode: report.InputConcat, want: "m1,m3,m2,m4"},
+		{merge: true, wantMode: report.InputMergeSorted, want: "m1,m2,m3,m4"},
+	} {
+		t.Run(tt.wantMode, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.Inputs = []string{filepath.Join(dir, "*.jsonl")}
+			cfg.InputMergeSorted = tt.merge
+			cfg.OutputType = "file"
+			cfg.OutputPath = filepath.Join(t.TempDir(), "out.jsonl")
+			cfg.ReportPath = filepath.Join(t.TempDir(), "report.json")
+			cfg.MaxWorkers = 1 // keep write order
+			open, closeFn, err := openInputs(cfg)
+			if err != nil {
+				t.Fatalf("openInputs: %v", err)
+			}
+			defer closeFn()
+
+			rep := report.NewReport()
+			if err := runPipelineFrom(context.Background(), open, cfg, rep); err != nil {
+				t.Fatalf("runPipelineFrom: %v", err)
+			}
+			data, err := os.ReadFile(cfg.OutputPath)
+			if err != nil {
+				t.Fatalf("read output: %v", err)
+			}
+			var got []string
+			for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+				var rec model.Normalized
+				if err := json.Unmarshal([]byte(line), &rec); err != nil {
+					t.Fatalf("unmarshal: %v", err)
+				}
+				got = append(got, rec.Message)
+			}
+			if strings.Join(got, ",") != tt.want {
+				t.Errorf("output order = %s, want %s", strings.Join(got, ","), tt.want)
+			}
+			if rep.InputMode != tt.wantMode || len(rep.InputFiles) != 2 || rep.InputFiles[0].Lines != 2 || rep.InputFiles[1].Lines != 2 {
+				t.Errorf("unexpected inputs in report: %s %+v", rep.InputMode, rep.InputFiles)
+			}
+		})
+	}
+}
+
+func TestRunPipeline_InputsProvenance(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.jsonl"), filepath.Join(dir, "b.jsonl")
+	noTS := `{"level":"ERROR","msg":"no time"}` + "\n"
+	if err := os.WriteFile(a, []byte(jsonlAt(1)+noTS), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(b, []byte("\n"+jsonlAt(2)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := dlqLimitConfig(t)
+	cfg.Inputs = []string{a, b}
+	cfg.OutputType = "file"
+	cfg.OutputPath = filepath.Join(t.TempDir(), "out.jsonl")
+	cfg.StampProvenance = true
+	cfg.DLQNormalizeFailures = true
+	open, closeFn, err := openInputs(cfg)
+	if err != nil {
+		t.Fatalf("openInputs: %v", err)
+	}
+	defer closeFn()
+	if err := runPipelineFrom(context.Background(), open, cfg, report.NewReport()); err != nil {
+		t.Fatalf("runPipelineFrom: %v", err)
+	}
+
+	data, err := os.ReadFile(cfg.OutputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var rec model.Normalized
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%s %v:%v", rec.Message, rec.Fields["_src_file"], rec.Fields["_src_line"]))
+	}
+	// b's record is on its second line, after an empty one.
+	if want := fmt.Sprintf("m1 %s:1,m2 %s:2", a, b); strings.Join(got, ",") != want {
+		t.Errorf("output %s, want %s", strings.Join(got, ","), want)
+	}
+
+	data, err = os.ReadFile(cfg.DLQPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry dlqRecord
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatal(err)
+	}
+	want := source.Origin{File: a, Line: 2, Offset: int64(len(jsonlAt(1)))}
+	if entry.Source == nil || *entry.Source != want || entry.Line != 2 {
+		t.Errorf("dlq line %d, source %+v; want line 2, %+v", entry.Line, entry.Source, want)
+	}
+}
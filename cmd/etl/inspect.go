@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/model"
+	"k8s-log-etl/internal/plugins"
+	"k8s-log-etl/internal/source"
+	"k8s-log-etl/internal/stages"
+)
+
+// cmdInspect shows how the first records of a file move through parsing,
+// normalization, and the configured transforms. No sink is opened and no
+// report is written.
+func cmdInspect(args []string) error {
+	fs := newFlagSet("inspect", "[flags] [file]",
+		"Show, for the first records of an input file, the raw line, the parsed\nmap, the normalized record, and which transforms would drop it and why.\nNothing is written. The file defaults to --input; use '-' for stdin.")
+	loadConfig := configFlags(fs)
+	flagLines := fs.Int("lines", 10, "number of records to inspect")
+	fs.Parse(args)
+
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return errors.New("inspect accepts at most one file")
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if fs.NArg() == 1 {
+		cfg.InputPath = fs.Arg(0)
+	}
+	if cfg, err = resolveTimestampShift(cfg); err != nil {
+		return err
+	}
+
+	in, closeFn, err := inputReader(context.Background(), cfg.InputPath, false)
+	if err != nil {
+		return fmt.Errorf("open input: %w", err)
+	}
+	if closeFn != nil {
+		defer closeFn()
+	}
+	return inspect(os.Stdout, in, cfg, *flagLines)
+}
+
+// inspect writes a stage-by-stage breakdown of up to limit non-empty lines
+// from in, and of each record parsed from a line holding several. A
+// failure at one stage is reported and ends that record's breakdown; it
+// never aborts the inspection.
+func inspect(w io.Writer, in io.Reader, cfg config.Config, limit int) error {
+	transforms, transformCloser, err := plugins.BuildTransforms(cfg)
+	if err != nil {
+		return fmt.Errorf("load transforms: %w", err)
+	}
+	defer transformCloser.Close()
+	var dropRules *stages.DropRules
+	if cfg.DropRulesFile != "" {
+		if dropRules, err = stages.LoadDropRules(cfg.DropRulesFile); err != nil {
+			return err
+		}
+	}
+
+	src := source.NewReader(in, cfg.InputFormat, "", lineLimits(cfg))
+	var parser stages.Parser
+	lineNum := 0
+	for lineNum < limit {
+		rec, err := src.Next(context.Background())
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		line := string(rec.Data)
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+		lineNum++
+		if lineNum > 1 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "=== record %d ===\n", lineNum)
+		fmt.Fprintln(w, "raw:")
+		fmt.Fprintf(w, "  %s\n", line)
+
+		// Parse as run does, so a BOM, trailing commas, and objects
+		// concatenated on one line are handled the same way.
+		records, err := parser.Parse(rec.Data)
+		if err != nil {
+			fmt.Fprintf(w, "parsed: ERROR %v\n", err)
+			fmt.Fprintln(w, "result: rejected at parsing")
+			continue
+		}
+		for i, js := range records {
+			if len(records) > 1 {
+				fmt.Fprintf(w, "--- object %d of %d ---\n", i+1, len(records))
+			}
+			inspectRecord(w, js, cfg, transforms, dropRules)
+		}
+	}
+	return nil
+}
+
+// inspectRecord writes the breakdown of one record parsed from a line,
+// from the parsed map to the result.
+func inspectRecord(w io.Writer, js map[string]any, cfg config.Config, transforms []plugins.Named, dropRules *stages.DropRules) {
+	writeSection(w, "parsed", js)
+	if opts := unwrapOptions(cfg); len(opts.Keys) > 0 {
+		switch stages.Unwrap(js, opts) {
+		case stages.UnwrapJSON, stages.UnwrapText:
+			writeSection(w, "unwrapped", js)
+		case stages.UnwrapFailed:
+			fmt.Fprintln(w, "unwrapped: payload is not valid JSON; record left as parsed")
+		}
+	}
+
+	normalized, err := stages.NormalizeWith(js, normalizeOptions(cfg))
+	if err != nil {
+		fmt.Fprintf(w, "normalized: ERROR %v\n", err)
+		fmt.Fprintln(w, "result: rejected at normalization")
+		return
+	}
+	writeSection(w, "normalized", schemaRecord(cfg, normalized))
+
+	result := "emitted"
+	if dropRules != nil {
+		if ruleLine, ok := dropRules.Match(normalized.Message); ok {
+			fmt.Fprintf(w, "drop_rules: drop (line %d of %s)\n", ruleLine, cfg.DropRulesFile)
+			result = fmt.Sprintf("dropped by drop rule on line %d", ruleLine)
+		} else {
+			fmt.Fprintln(w, "drop_rules: pass")
+		}
+	}
+
+	// Evaluate every transform so the user sees all reasons a record
+	// would be dropped, not just the first one the pipeline hits.
+	fmt.Fprintln(w, "transforms:")
+	for _, tf := range transforms {
+		nn, drop, reason, err := tf.Apply(normalized)
+		switch {
+		case err != nil:
+			fmt.Fprintf(w, "  %s: ERROR %v (on_error: %s)\n", tf.Name, err, tf.OnError)
+			if tf.OnError != config.OnErrorPass && result == "emitted" {
+				result = fmt.Sprintf("failed in transform %s", tf.Name)
+			}
+		case drop:
+			fmt.Fprintf(w, "  %s: drop (reason: %s)\n", tf.Name, reason)
+			if result == "emitted" {
+				result = fmt.Sprintf("dropped by %s (%s)", tf.Name, reason)
+			}
+		default:
+			fmt.Fprintf(w, "  %s: pass\n", tf.Name)
+			normalized = nn
+		}
+	}
+	if result == "emitted" && len(transforms) > 0 {
+		writeSection(w, "output", schemaRecord(cfg, normalized))
+	}
+	fmt.Fprintf(w, "result: %s\n", result)
+}
+
+// schemaRecord returns n as cfg's output schema encodes it.
+func schemaRecord(cfg config.Config, n model.Normalized) any {
+	if cfg.LegacySchema() {
+		return model.Legacy(n)
+	}
+	return n
+}
+
+// writeSection prints a labeled, indented JSON rendering of v.
+func writeSection(w io.Writer, label string, v any) {
+	out, err := json.MarshalIndent(v, "  ", "  ")
+	if err != nil {
+		fmt.Fprintf(w, "%s: ERROR format: %v\n", label, err)
+		return
+	}
+	fmt.Fprintf(w, "%s:\n  %s\n", label, out)
+}
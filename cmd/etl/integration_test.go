@@ -4,11 +4,16 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"k8s-log-etl/internal/report"
 )
@@ -80,14 +85,28 @@ func TestCLIProcessesExampleFixture(t *testing.T) {
 	}
 
 	if rep.TotalLines != 6 || rep.JSONParsed != 6 || rep.JSONFailed != 0 {
-		t.Fatalf("unexpected totals: %+v", rep)
+		t.Fatalf("unexpected totals: %+v", &rep)
 	}
 	if rep.WrittenOK != 3 || rep.WriteFailed != 0 {
-		t.Fatalf("unexpected write stats: %+v", rep)
+		t.Fatalf("unexpected write stats: %+v", &rep)
 	}
 	if rep.Filtered.Level != 3 || rep.Filtered.Service != 0 {
 		t.Fatalf("unexpected filter stats: %+v", rep.Filtered)
 	}
+	if rep.RunID == "" {
+		t.Fatalf("expected run_id in report")
+	}
+	var raw map[string]any
+	if err := json.Unmarshal(reportBytes, &raw); err != nil {
+		t.Fatalf("unmarshal raw report: %v", err)
+	}
+	buildInfo, ok := raw["build_info"].(map[string]any)
+	if !ok {
+		t.Fatalf("expected build_info block in report, got: %s", reportBytes)
+	}
+	if v, _ := buildInfo["version"].(string); v == "" {
+		t.Fatalf("expected build_info.version to be set: %v", buildInfo)
+	}
 	if rep.DurationSeconds <= 0 || rep.Throughput <= 0 {
 		t.Fatalf("expected duration/throughput to be set: duration=%f throughput=%f", rep.DurationSeconds, rep.Throughput)
 	}
@@ -97,3 +116,578 @@ func TestCLIProcessesExampleFixture(t *testing.T) {
 		t.Fatalf("expected stdout summary, got: %q", summary)
 	}
 }
+
+func TestCLIOutputSchema(t *testing.T) {
+	tests := []struct {
+		schema      string
+		keys        []string
+		missingKeys []string
+	}{
+		{"", []string{"TS", "Level", "Service", "Namespace", "Pod", "Node", "Message", "TraceID", "Fields"}, []string{"ts", "trace_id", "Error"}},
+		{"legacy", []string{"TS", "Level", "Service", "Namespace", "Pod", "Node", "Message", "TraceID", "Fields"}, []string{"ts", "trace_id", "Error"}},
+		// The first ERROR example has no node, so v1 leaves it out.
+		{"v1", []string{"ts", "level", "service", "namespace", "pod", "message", "trace_id", "fields"}, []string{"TS", "node", "error"}},
+	}
+	for _, tc := range tests {
+		t.Run("schema="+tc.schema, func(t *testing.T) {
+			dir := t.TempDir()
+			outPath := filepath.Join(dir, "out.jsonl")
+			args := []string{
+				"--input", "examples/k8s_logs.jsonl",
+				"--output-type", "file",
+				"--output", outPath,
+				"--report", filepath.Join(dir, "report.json"),
+				"--filter-levels", "ERROR",
+			}
+			if tc.schema != "" {
+				args = append(args, "--output-schema", tc.schema)
+			}
+			stdout, stderr, err := runCLI(t, args...)
+			if err != nil {
+				t.Fatalf("run failed: %v\nstdout: %s\nstderr: %s", err, stdout, stderr)
+			}
+			out, err := os.ReadFile(outPath)
+			if err != nil {
+				t.Fatalf("read output: %v", err)
+			}
+			line, _, _ := strings.Cut(string(out), "\n")
+			var m map[string]any
+			if err := json.Unmarshal([]byte(line), &m); err != nil {
+				t.Fatalf("unmarshal output: %v", err)
+			}
+			for _, k := range tc.keys {
+				if _, ok := m[k]; !ok {
+					t.Errorf("expected key %q, got %s", k, line)
+				}
+			}
+			for _, k := range tc.missingKeys {
+				if _, ok := m[k]; ok {
+					t.Errorf("unexpected key %q, got %s", k, line)
+				}
+			}
+		})
+	}
+
+	_, stderr, err := runCLI(t, "validate", "--output-schema", "v2")
+	if err == nil || !strings.Contains(stderr, "invalid output_schema") {
+		t.Fatalf("expected validate to reject output_schema v2, got err=%v stderr=%q", err, stderr)
+	}
+}
+
+func TestCLITolerantParsing(t *testing.T) {
+	tmp := t.TempDir()
+	outPath := filepath.Join(tmp, "out.jsonl")
+	reportPath := filepath.Join(tmp, "report.json")
+	stdout, stderr, err := runCLI(t,
+		"--input", "examples/messy_logs.jsonl",
+		"--output-type", "file",
+		"--output", outPath,
+		"--report", reportPath,
+	)
+	if err != nil {
+		t.Fatalf("run failed: %v\nstdout: %s\nstderr: %s", err, stdout, stderr)
+	}
+
+	reportBytes, err := os.ReadFile(reportPath)
+	if err != nil {
+		t.Fatalf("read report: %v", err)
+	}
+	var rep report.Report
+	if err := json.Unmarshal(reportBytes, &rep); err != nil {
+		t.Fatalf("unmarshal report: %v", err)
+	}
+	// BOM line, two concatenated objects, trailing commas, one truncated line.
+	if rep.TotalLines != 4 || rep.JSONParsed != 3 || rep.JSONFailed != 1 || rep.RecordsExtracted != 4 {
+		t.Fatalf("unexpected parse counts: lines=%d parsed=%d failed=%d records=%d",
+			rep.TotalLines, rep.JSONParsed, rep.JSONFailed, rep.RecordsExtracted)
+	}
+	out, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	for _, msg := range []string{"bom first line", "first of two", "second of two", "trailing comma"} {
+		if !strings.Contains(string(out), msg) {
+			t.Errorf("expected %q in output:\n%s", msg, out)
+		}
+	}
+}
+
+func TestCLIErrorFields(t *testing.T) {
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.jsonl")
+	outPath := filepath.Join(tmp, "out.jsonl")
+	reportPath := filepath.Join(tmp, "report.json")
+	input := `{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"failed","service":"api","err":"timeout","stack":"at handler()","caller":"api.go:42"}
+{"ts":"2024-01-01T12:00:01Z","level":"ERROR","msg":"plain","service":"api"}
+`
+	if err := os.WriteFile(inPath, []byte(input), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, err := runCLI(t, "--input", inPath, "--output-type", "file", "--output", outPath, "--report", reportPath)
+	if err != nil {
+		t.Fatalf("run failed: %v\nstdout: %s\nstderr: %s", err, stdout, stderr)
+	}
+
+	out, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 records, got %q", out)
+	}
+	var withErr, plain map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &withErr); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &plain); err != nil {
+		t.Fatal(err)
+	}
+	if withErr["Error"] != "timeout" || withErr["Stacktrace"] != "at handler()" || withErr["Caller"] != "api.go:42" {
+		t.Errorf("expected promoted error fields, got %s", lines[0])
+	}
+	if fields, _ := withErr["Fields"].(map[string]any); fields["err"] != nil || fields["stack"] != nil {
+		t.Errorf("expected promoted keys to leave Fields, got %s", lines[0])
+	}
+	// Records without error details keep the previous output shape.
+	for _, k := range []string{"Error", "Stacktrace", "Caller"} {
+		if _, ok := plain[k]; ok {
+			t.Errorf("expected %s to be omitted when empty, got %s", k, lines[1])
+		}
+	}
+
+	reportBytes, err := os.ReadFile(reportPath)
+	if err != nil {
+		t.Fatalf("read report: %v", err)
+	}
+	var rep report.Report
+	if err := json.Unmarshal(reportBytes, &rep); err != nil {
+		t.Fatalf("unmarshal report: %v", err)
+	}
+	if rep.WithError != 1 || rep.WithStacktrace != 1 {
+		t.Errorf("expected 1 record with error and stack trace, got %d and %d", rep.WithError, rep.WithStacktrace)
+	}
+}
+
+func TestCLIOutputFields(t *testing.T) {
+	tmp := t.TempDir()
+	outPath := filepath.Join(tmp, "out.jsonl")
+	stdout, stderr, err := runCLI(t,
+		"--output-type", "file",
+		"--output", outPath,
+		"--report", filepath.Join(tmp, "report.json"),
+		"--output-fields", "ts,level,service,message,fields.status,latency_ms",
+	)
+	if err != nil {
+		t.Fatalf("run failed: %v\nstdout: %s\nstderr: %s", err, stdout, stderr)
+	}
+	// latency_ms is not a normalized field; it still works but is flagged.
+	if !strings.Contains(stderr, `output_fields entry \"latency_ms\" is not a normalized field`) {
+		t.Errorf("expected a warning for latency_ms, got stderr: %s", stderr)
+	}
+
+	out, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	allowed := map[string]bool{"ts": true, "level": true, "service": true, "message": true, "fields.status": true, "latency_ms": true}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	for _, line := range lines {
+		var m map[string]any
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("unmarshal output: %v", err)
+		}
+		for k := range m {
+			if !allowed[k] {
+				t.Errorf("unexpected key %q in %s", k, line)
+			}
+		}
+		for _, k := range []string{"ts", "level", "message"} {
+			if _, ok := m[k]; !ok {
+				t.Errorf("expected key %q in %s", k, line)
+			}
+		}
+	}
+}
+
+// runCLI runs the CLI from the repo root via `go run` and returns its output.
+func runCLI(t *testing.T, args ...string) (string, string, error) {
+	t.Helper()
+	repoRoot, err := filepath.Abs("../..")
+	if err != nil {
+		t.Fatalf("abs repo root: %v", err)
+	}
+	cmd := exec.Command("go", append([]string{"run", "./cmd/etl"}, args...)...)
+	cmd.Dir = repoRoot
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), "ETL_CONFIG=")
+	err = cmd.Run()
+	return stdout.String(), stderr.String(), err
+}
+
+func TestCLIRunSubcommand(t *testing.T) {
+	tmp := t.TempDir()
+	outPath := filepath.Join(tmp, "out.jsonl")
+	stdout, stderr, err := runCLI(t, "run",
+		"--output-type", "file",
+		"--output", outPath,
+		"--report", filepath.Join(tmp, "report.json"),
+	)
+	if err != nil {
+		t.Fatalf("run failed: %v\nstdout: %s\nstderr: %s", err, stdout, stderr)
+	}
+	if !strings.Contains(stdout, "Total Lines: 6") {
+		t.Fatalf("expected run summary, got: %q", stdout)
+	}
+}
+
+func TestCLIHTTPOutput(t *testing.T) {
+	var mu sync.Mutex
+	var received int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var record map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&record); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		received++
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	tmp := t.TempDir()
+	reportPath := filepath.Join(tmp, "report.json")
+	stdout, stderr, err := runCLI(t, "run",
+		"--output-type", "http",
+		"--output", server.URL,
+		"--report", reportPath,
+	)
+	if err != nil {
+		t.Fatalf("run failed: %v\nstdout: %s\nstderr: %s", err, stdout, stderr)
+	}
+	data, err := os.ReadFile(reportPath)
+	if err != nil {
+		t.Fatalf("read report: %v", err)
+	}
+	var rep report.Report
+	if err := json.Unmarshal(data, &rep); err != nil {
+		t.Fatalf("unmarshal report: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if rep.WrittenOK == 0 || received != rep.WrittenOK {
+		t.Errorf("server received %d records, report says %d written", received, rep.WrittenOK)
+	}
+}
+
+func TestCLIMarkdownReport(t *testing.T) {
+	tmp := t.TempDir()
+	reportPath := filepath.Join(tmp, "report.json")
+	mdPath := filepath.Join(tmp, "report.md")
+	stdout, stderr, err := runCLI(t, "run",
+		"--output-type", "file",
+		"--output", filepath.Join(tmp, "out.jsonl"),
+		"--report", reportPath,
+		"--report-md", mdPath,
+	)
+	if err != nil {
+		t.Fatalf("run failed: %v\nstdout: %s\nstderr: %s", err, stdout, stderr)
+	}
+	var rep map[string]any
+	readJSON(t, reportPath, &rep)
+	md, err := os.ReadFile(mdPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(md), "# ETL run report") || !strings.Contains(string(md), "| Lines read | 6 |") {
+		t.Errorf("markdown report:\n%s", md)
+	}
+
+	stdout, stderr, err = runCLI(t, "run",
+		"--output-type", "file",
+		"--output", filepath.Join(tmp, "out2.jsonl"),
+		"--report", "-",
+		"--report-format", "markdown",
+	)
+	if err != nil {
+		t.Fatalf("run failed: %v\nstdout: %s\nstderr: %s", err, stdout, stderr)
+	}
+	if !strings.Contains(stdout, "## Levels") {
+		t.Errorf("stdout lacks the markdown report: %q", stdout)
+	}
+}
+
+func TestCLIReportSections(t *testing.T) {
+	tmp := t.TempDir()
+	reportPath := filepath.Join(tmp, "report.json")
+	opsPath := filepath.Join(tmp, "ops.json")
+	qualityPath := filepath.Join(tmp, "quality.json")
+	stdout, stderr, err := runCLI(t, "run",
+		"--output-type", "file",
+		"--output", filepath.Join(tmp, "out.jsonl"),
+		"--report", reportPath,
+		"--report-schema", "v2",
+		"--report-ops", opsPath,
+		"--report-quality", qualityPath,
+	)
+	if err != nil {
+		t.Fatalf("run failed: %v\nstdout: %s\nstderr: %s", err, stdout, stderr)
+	}
+	var v2 report.ReportV2
+	readJSON(t, reportPath, &v2)
+	if v2.Schema != report.SchemaV2 || v2.Operational.TotalLines != 6 || v2.DataQuality.JSONParsed == 0 {
+		t.Errorf("v2 report = %+v", v2)
+	}
+	var ops, quality map[string]any
+	readJSON(t, opsPath, &ops)
+	readJSON(t, qualityPath, &quality)
+	if _, ok := ops["retry_stats"]; !ok {
+		t.Errorf("operational report lacks retry_stats: %v", ops)
+	}
+	if _, ok := quality["by_level"]; !ok {
+		t.Errorf("data-quality report lacks by_level: %v", quality)
+	}
+	if _, ok := ops["by_level"]; ok {
+		t.Errorf("operational report has data-quality key by_level")
+	}
+}
+
+func TestCLIAtomicOutput(t *testing.T) {
+	repoRoot, err := filepath.Abs("../..")
+	if err != nil {
+		t.Fatalf("abs repo root: %v", err)
+	}
+	tmp := t.TempDir()
+	outPath := filepath.Join(tmp, "out.jsonl")
+	cmd := exec.Command("go", "run", "./cmd/etl", "run",
+		"--input", "-",
+		"--output-type", "file",
+		"--output", outPath,
+		"--output-atomic",
+		"--output-done-marker",
+		"--report", filepath.Join(tmp, "report.json"),
+	)
+	cmd.Dir = repoRoot
+	cmd.Env = append(os.Environ(), "ETL_CONFIG=")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatalf("stdin pipe: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer cmd.Process.Kill()
+
+	fmt.Fprintln(stdin, `{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"boom","service":"orders"}`)
+
+	// While the process is running, output goes only to the temp file.
+	deadline := time.Now().Add(2 * time.Minute)
+	for {
+		if _, err := os.Stat(outPath + ".tmp"); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("temp output never appeared; stderr: %s", stderr.String())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if _, err := os.Stat(outPath); !os.IsNotExist(err) {
+		t.Fatalf("expected no final output while running, stat err=%v", err)
+	}
+
+	stdin.Close()
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("run failed: %v\nstderr: %s", err, stderr.String())
+	}
+	out, err := os.ReadFile(outPath)
+	if err != nil || !strings.Contains(string(out), "boom") {
+		t.Fatalf("expected final output after exit, got %q (err=%v)", out, err)
+	}
+	if _, err := os.Stat(outPath + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("expected temp file to be gone, stat err=%v", err)
+	}
+	if _, err := os.Stat(outPath + ".done"); err != nil {
+		t.Fatalf("expected done marker: %v", err)
+	}
+}
+
+func TestCLIOutputResumeAfterKill(t *testing.T) {
+	repoRoot, err := filepath.Abs("../..")
+	if err != nil {
+		t.Fatalf("abs repo root: %v", err)
+	}
+	tmp := t.TempDir()
+	// The first run is killed, so it runs the binary itself: killing `go
+	// run` would leave its child running.
+	bin := filepath.Join(tmp, "etl")
+	build := exec.Command("go", "build", "-o", bin, "./cmd/etl")
+	build.Dir = repoRoot
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("build: %v\n%s", err, out)
+	}
+	var input bytes.Buffer
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&input, `{"ts":"2024-01-01T12:00:%02dZ","level":"ERROR","msg":"record %03d","service":"orders"}`+"\n", i%60, i)
+	}
+	inputPath := filepath.Join(tmp, "in.jsonl")
+	if err := os.WriteFile(inputPath, input.Bytes(), 0o644); err != nil {
+		t.Fatalf("write input: %v", err)
+	}
+	run := func(input, out string, extra ...string) *exec.Cmd {
+		args := append([]string{"run",
+			"--input", input,
+			"--output-type", "file",
+			"--output", out,
+			"--max-workers", "1",
+			"--batch-size", "1",
+			"--report", filepath.Join(tmp, "report.json"),
+		}, extra...)
+		cmd := exec.Command(bin, args...)
+		cmd.Env = append(os.Environ(), "ETL_CONFIG=")
+		return cmd
+	}
+
+	want := filepath.Join(tmp, "want.jsonl")
+	if out, err := run(inputPath, want).CombinedOutput(); err != nil {
+		t.Fatalf("uninterrupted run: %v\n%s", err, out)
+	}
+
+	// The first run gets half the input and is killed once part of it is
+	// written.
+	outPath := filepath.Join(tmp, "out.jsonl")
+	first := run("-", outPath, "--output-resume")
+	var stderr bytes.Buffer
+	first.Stderr = &stderr
+	stdin, err := first.StdinPipe()
+	if err != nil {
+		t.Fatalf("stdin pipe: %v", err)
+	}
+	if err := first.Start(); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer first.Process.Kill()
+	lines := bytes.SplitAfter(input.Bytes(), []byte("\n"))
+	for _, line := range lines[:100] {
+		stdin.Write(line)
+	}
+	deadline := time.Now().Add(time.Minute)
+	for {
+		if data, _ := os.ReadFile(outPath); bytes.Count(data, []byte("\n")) >= 50 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("output never reached 50 records; stderr: %s", stderr.String())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err := first.Process.Kill(); err != nil {
+		t.Fatalf("kill: %v", err)
+	}
+	first.Wait()
+	partial, _ := os.ReadFile(outPath)
+	if bytes.Count(partial, []byte("\n")) >= 200 {
+		t.Fatalf("first run was not interrupted")
+	}
+
+	if out, err := run(inputPath, outPath, "--output-resume").CombinedOutput(); err != nil {
+		t.Fatalf("resumed run: %v\n%s", err, out)
+	}
+	got, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	wantData, err := os.ReadFile(want)
+	if err != nil {
+		t.Fatalf("read uninterrupted output: %v", err)
+	}
+	if !bytes.Equal(got, wantData) {
+		t.Fatalf("resumed output differs from an uninterrupted run:\n got %d bytes\nwant %d bytes", len(got), len(wantData))
+	}
+}
+
+func TestCLIValidate(t *testing.T) {
+	stdout, stderr, err := runCLI(t, "validate", "--output-type", "file", "--output", "out.jsonl")
+	if err != nil {
+		t.Fatalf("validate failed: %v\nstdout: %s\nstderr: %s", err, stdout, stderr)
+	}
+	if !strings.Contains(stdout, "configuration OK") {
+		t.Fatalf("expected OK message, got: %q", stdout)
+	}
+
+	_, stderr, err = runCLI(t, "validate", "--output-type", "bogus")
+	if err == nil {
+		t.Fatalf("expected validate to fail for bogus output type")
+	}
+	if !strings.Contains(stderr, "invalid output_type") {
+		t.Fatalf("expected validation error on stderr, got: %q", stderr)
+	}
+}
+
+func TestCLIReplay(t *testing.T) {
+	tmp := t.TempDir()
+	dlqPath := filepath.Join(tmp, "dlq.jsonl")
+	outPath := filepath.Join(tmp, "out.jsonl")
+	dlq := `{"record":{"TS":"2024-01-01T12:00:00Z","Level":"ERROR","Message":"boom","Service":"orders"},"reason":"write sink","run_id":"r1"}
+{"record":{"TS":"2024-01-01T12:00:01Z","Level":"WARN","Message":"slow","Service":"orders","TraceID":"legacy-trace"},"reason":"write_timeout","run_id":"r1"}
+{"record":{"ts":"2024-01-01T12:00:02Z","level":"ERROR","message":"v1","service":"orders","trace_id":"v1-trace"},"reason":"write sink","run_id":"r2"}
+`
+	if err := os.WriteFile(dlqPath, []byte(dlq), 0o644); err != nil {
+		t.Fatalf("write dlq: %v", err)
+	}
+
+	// Entries in either schema decode alike.
+	stdout, stderr, err := runCLI(t, "replay", "--output-type", "file", "--output", outPath, "--output-schema", "v1", dlqPath)
+	if err != nil {
+		t.Fatalf("replay failed: %v\nstdout: %s\nstderr: %s", err, stdout, stderr)
+	}
+	if !strings.Contains(stdout, "Written OK: 3") {
+		t.Fatalf("expected 3 replayed records, got: %q", stdout)
+	}
+	out, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	if got := strings.Count(string(out), "\n"); got != 3 {
+		t.Fatalf("expected 3 output lines, got %d: %s", got, out)
+	}
+	for _, want := range []string{`"message":"boom"`, `"trace_id":"legacy-trace"`, `"trace_id":"v1-trace"`} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("expected %s in output:\n%s", want, out)
+		}
+	}
+}
+
+func TestCLIInspect(t *testing.T) {
+	repoRoot, err := filepath.Abs("../..")
+	if err != nil {
+		t.Fatalf("abs repo root: %v", err)
+	}
+	inputPath := filepath.Join(repoRoot, "examples", "k8s_logs.jsonl")
+	stdout, stderr, err := runCLI(t, "inspect", "--lines", "2", inputPath)
+	if err != nil {
+		t.Fatalf("inspect failed: %v\nstdout: %s\nstderr: %s", err, stdout, stderr)
+	}
+	if got := strings.Count(stdout, "=== record"); got != 2 {
+		t.Fatalf("expected 2 inspected records, got %d: %s", got, stdout)
+	}
+	if !strings.Contains(stdout, `"Message": "request started"`) {
+		t.Fatalf("expected normalized record in output, got: %s", stdout)
+	}
+}
+
+func TestCLIHelp(t *testing.T) {
+	_, stderr, err := runCLI(t, "help")
+	if err != nil {
+		t.Fatalf("help failed: %v\nstderr: %s", err, stderr)
+	}
+	for _, name := range []string{"run", "validate", "replay", "inspect"} {
+		if !strings.Contains(stderr, name) {
+			t.Fatalf("expected %s in help, got: %s", name, stderr)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"k8s-log-etl/internal/fsutil"
+	"k8s-log-etl/internal/logger"
+	"k8s-log-etl/internal/sink"
+)
+
+// cmdLookup prints the records of one trace from the output of a run with
+// output_index trace_id, reading only those records, or rebuilds the
+// index from the output.
+func cmdLookup(args []string) error {
+	fs := newFlagSet("lookup", "--index <file> --trace <id> | --index <file> --rebuild <output files>",
+		"Print the records of a trace ID, seeking to each through the trace index\nthat output_index trace_id writes next to the output (<output>.idx).\nWith --rebuild, write the index again from the given JSON output files,\noldest first, e.g. after a crash cut it short.")
+	flagIndex := fs.String("index", "", "trace index file, e.g. out.jsonl.idx")
+	flagTrace := fs.String("trace", "", "trace ID to print the records of")
+	flagRebuild := fs.Bool("rebuild", false, "rebuild --index from the output files given as arguments")
+	fs.Parse(args)
+
+	if *flagIndex == "" {
+		fs.Usage()
+		return errors.New("lookup requires --index")
+	}
+	if *flagRebuild {
+		if fs.NArg() == 0 {
+			fs.Usage()
+			return errors.New("lookup --rebuild requires the output files to index")
+		}
+		n, err := sink.RebuildTraceIndex(*flagIndex, fs.Args(), fsutil.Perm{})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("indexed %d records into %s\n", n, *flagIndex)
+		return nil
+	}
+	if *flagTrace == "" || fs.NArg() > 0 {
+		fs.Usage()
+		return errors.New("lookup requires --trace and no arguments")
+	}
+	n, err := lookupTrace(os.Stdout, *flagIndex, *flagTrace)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("no records of trace %s in %s", *flagTrace, *flagIndex)
+	}
+	return nil
+}
+
+// lookupTrace writes the records of trace id, as the index at path places
+// them, to w and returns how many it wrote. A location that no longer
+// holds the trace ID, because its file was deleted or written again since,
+// is logged and skipped.
+func lookupTrace(w io.Writer, path, id string) (int, error) {
+	locs, err := sink.LookupTrace(path, id)
+	if err != nil {
+		return 0, err
+	}
+	files := make(map[string]*os.File)
+	defer func() {
+		for _, f := range files {
+			if f != nil {
+				f.Close()
+			}
+		}
+	}()
+	written := 0
+	for _, loc := range locs {
+		f, ok := files[loc.Path]
+		if !ok {
+			if f, err = os.Open(loc.Path); err != nil {
+				logger.Warn("output file of the index cannot be read", "path", loc.Path, "error", err)
+			}
+			files[loc.Path] = f
+		}
+		if f == nil {
+			continue
+		}
+		rec := make([]byte, loc.Length)
+		if _, err := f.ReadAt(rec, loc.Offset); err != nil || !bytes.Contains(rec, []byte(id)) {
+			logger.Warn("stale trace index entry skipped; rebuild the index with --rebuild", "path", loc.Path, "offset", loc.Offset)
+			continue
+		}
+		if !bytes.HasSuffix(rec, []byte("\n")) {
+			rec = append(rec, '\n')
+		}
+		if _, err := w.Write(rec); err != nil {
+			return written, err
+		}
+		written++
+	}
+	return written, nil
+}
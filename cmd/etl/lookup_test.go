@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/report"
+	"k8s-log-etl/internal/sink"
+)
+
+func TestLookupTraceAfterRun(t *testing.T) {
+	input := strings.Join([]string{
+		`{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"start","service":"api","trace_id":"abc"}`,
+		`{"ts":"2024-01-01T12:00:01Z","level":"ERROR","msg":"other","service":"api","trace_id":"def"}`,
+		`{"ts":"2024-01-01T12:00:02Z","level":"ERROR","msg":"end","service":"api","trace_id":"abc"}`,
+	}, "\n")
+	cfg := config.Default()
+	cfg.OutputType = "file"
+	cfg.OutputPath = filepath.Join(t.TempDir(), "out.jsonl")
+	cfg.ReportPath = filepath.Join(t.TempDir(), "report.json")
+	cfg.OutputIndex = config.IndexTraceID
+	cfg.MaxWorkers = 1
+	if err := config.Validate(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := runPipeline(context.Background(), strings.NewReader(input), cfg, report.NewReport()); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+
+	index := sink.TraceIndexPath(cfg.OutputPath)
+	var out bytes.Buffer
+	n, err := lookupTrace(&out, index, "abc")
+	if err != nil || n != 2 {
+		t.Fatalf("lookupTrace = %d, %v; want 2 records", n, err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"start"`) || !strings.Contains(lines[1], `"end"`) {
+		t.Errorf("lookup printed:\n%s", out.String())
+	}
+
+	// Output written again by hand no longer matches the index.
+	if err := os.WriteFile(cfg.OutputPath, []byte(strings.Repeat("x", 500)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if n, err := lookupTrace(&out, index, "abc"); err != nil || n != 0 || out.Len() != 0 {
+		t.Errorf("stale lookup = %d, %v, %q; want nothing", n, err, out.String())
+	}
+}
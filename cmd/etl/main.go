@@ -1,166 +1,170 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
-	"flag"
+	"errors"
 	"fmt"
 	"io"
+	"k8s-log-etl/internal/clock"
 	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/fsutil"
 	"k8s-log-etl/internal/logger"
 	"k8s-log-etl/internal/model"
 	"k8s-log-etl/internal/plugins"
 	"k8s-log-etl/internal/report"
 	"k8s-log-etl/internal/sink"
+	"k8s-log-etl/internal/source"
 	"k8s-log-etl/internal/stages"
+	"log"
 	"log/slog"
 	"math/rand"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"runtime/pprof"
+	"strconv"
 	"strings"
 	"sync"
-	"syscall"
+	"sync/atomic"
 	"time"
 )
 
-func main() {
-	// Flags with env + config file override support.
-	flagConfig := flag.String("config", "", "path to YAML or JSON config file")
-	flagInput := flag.String("input", "", "input JSONL path (use '-' for stdin)")
-	flagOutput := flag.String("output", "", "output path (use '-' for stdout)")
-	flagOutputType := flag.String("output-type", "", "sink type: stdout|file|rotate (default stdout)")
-	flagOutputMaxBytes := flag.Int64("output-max-bytes", 0, "max bytes before rotation when using rotate sink")
-	flagOutputMaxFiles := flag.Int("output-max-files", 0, "max rotated files to keep when using rotate sink")
-	flagReport := flag.String("report", "", "report output path")
-	flagMaxWorkers := flag.Int("max-workers", 0, "number of sink workers")
-	flagQueueSize := flag.Int("queue-size", 0, "bounded queue size between normalize and sink")
-	flagSinkRetries := flag.Int("sink-max-retries", 0, "max retries for sink writes")
-	flagBackoffBase := flag.Int("sink-backoff-base-ms", 0, "base backoff in ms for sink retries")
-	flagBackoffMax := flag.Int("sink-backoff-max-ms", 0, "max backoff in ms for sink retries")
-	flagBackoffJitter := flag.Float64("sink-backoff-jitter-pct", 0, "jitter pct (0.2 = 20%) for sink retries")
-	flagDLQ := flag.String("dlq", "", "dead-letter path for failed records (jsonl). 's3://...' not supported.")
-	flagFilterLevels := flag.String("filter-levels", "", "comma-separated levels to emit (e.g. WARN,ERROR)")
-	flagFilterServices := flag.String("filter-services", "", "comma-separated services to emit (case-insensitive)")
-	flagRedactKeys := flag.String("redact-keys", "", "comma-separated field keys to redact from extra fields")
-	flagBatchSize := flag.Int("batch-size", 0, "batch size for sink writes (0 = no batching)")
-	flagBatchFlushInterval := flag.Int("batch-flush-interval-ms", 0, "batch flush interval in milliseconds")
-	flagShutdownTimeout := flag.Int("shutdown-timeout-seconds", 0, "graceful shutdown timeout in seconds")
-	flagLogLevel := flag.String("log-level", "", "log level: debug, info, warn, error")
-	flagLogFormat := flag.String("log-format", "", "log format: json, text")
-	flag.Parse()
-
-	cfg := config.Default()
-
-	// Load config file if provided by flag or env.
-	cfgPath := *flagConfig
-	if cfgPath == "" {
-		cfgPath = os.Getenv("ETL_CONFIG")
-	}
-	if cfgPath != "" {
-		fileCfg, err := config.Load(cfgPath)
-		if err != nil {
-			log.Fatalf("load config: %v", err)
-		}
-		cfg = config.Merge(cfg, fileCfg)
-	}
+type clockKey struct{}
 
-	// Env overrides.
-	cfg = config.FromEnv(cfg)
+// withClock attaches c to ctx as the clock driving a run's timers, retry
+// backoff sleeps, and stall watchdog. Tests attach a clock.Fake; the run's
+// goroutines read it from their context, never from a global a test
+// could swap back while one is still running.
+func withClock(ctx context.Context, c clock.Clock) context.Context {
+	return context.WithValue(ctx, clockKey{}, c)
+}
 
-	// Flag overrides (highest precedence).
-	override := config.Config{}
-	if *flagInput != "" {
-		override.InputPath = *flagInput
-	}
-	if *flagOutput != "" {
-		override.OutputPath = *flagOutput
-	}
-	if *flagOutputType != "" {
-		override.OutputType = *flagOutputType
-	}
-	if *flagOutputMaxBytes != 0 {
-		override.OutputMaxB = *flagOutputMaxBytes
-	}
-	if *flagOutputMaxFiles != 0 {
-		override.OutputMaxFiles = *flagOutputMaxFiles
-	}
-	if *flagReport != "" {
-		override.ReportPath = *flagReport
-	}
-	if *flagMaxWorkers != 0 {
-		override.MaxWorkers = *flagMaxWorkers
-	}
-	if *flagQueueSize != 0 {
-		override.QueueSize = *flagQueueSize
-	}
-	if *flagSinkRetries != 0 {
-		override.SinkMaxRetries = *flagSinkRetries
+// clockFrom returns the clock attached to ctx, or clock.Real.
+func clockFrom(ctx context.Context) clock.Clock {
+	if c, ok := ctx.Value(clockKey{}).(clock.Clock); ok {
+		return c
 	}
-	if *flagBackoffBase != 0 {
-		override.SinkBackoffBaseMS = *flagBackoffBase
-	}
-	if *flagBackoffMax != 0 {
-		override.SinkBackoffMaxMS = *flagBackoffMax
-	}
-	if *flagBackoffJitter != 0 {
-		override.SinkBackoffJitter = *flagBackoffJitter
-	}
-	if *flagDLQ != "" {
-		override.DLQPath = *flagDLQ
-	}
-	if *flagFilterLevels != "" {
-		override.FilterLevels = parseList(*flagFilterLevels)
-	}
-	if *flagFilterServices != "" {
-		override.FilterSvcs = parseList(*flagFilterServices)
-	}
-	if *flagRedactKeys != "" {
-		override.RedactKeys = parseList(*flagRedactKeys)
-	}
-	if *flagBatchSize != 0 {
-		override.BatchSize = *flagBatchSize
-	}
-	if *flagBatchFlushInterval != 0 {
-		override.BatchFlushInterval = *flagBatchFlushInterval
+	return clock.Real
+}
+
+// exitDiskFull is EX_IOERR from sysexits.h, for a run stopped because its
+// output disk filled up.
+const exitDiskFull = 74
+
+// errMaxDuration ends a run that stopped reading at max_duration. main
+// exits with exitMaxDuration for it.
+var errMaxDuration = errors.New("max duration reached")
+
+// exitMaxDuration is the status timeout(1) exits with, for a run that
+// covered only part of its input because it ran out of time.
+const exitMaxDuration = 124
+
+func main() {
+	if err := dispatch(os.Args[1:]); err != nil {
+		log.Print(err)
+		os.Exit(exitCode(err))
 	}
-	if *flagShutdownTimeout != 0 {
-		override.ShutdownTimeoutSeconds = *flagShutdownTimeout
+}
+
+// exitCode is the process exit status for a command's error.
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errInputIdle):
+		return exitInputIdle
+	case errors.Is(err, sink.ErrDiskFull):
+		return exitDiskFull
+	case errors.Is(err, errMaxDuration):
+		return exitMaxDuration
+	default:
+		return 1
 	}
-	if *flagLogLevel != "" {
-		override.LogLevel = *flagLogLevel
+}
+
+// cmdRun runs the ETL pipeline. It is also the default when no subcommand is
+// given, so bare invocations with flags keep working.
+func cmdRun(args []string) (err error) {
+	fs := newFlagSet("run", "[flags]", "Run the ETL pipeline over the configured input.")
+	loadConfig := configFlags(fs)
+	flagVersion := fs.Bool("version", false, "print version and build info, then exit")
+	fs.Parse(args)
+
+	if *flagVersion {
+		fmt.Println(versionString(buildInfo()))
+		return nil
 	}
-	if *flagLogFormat != "" {
-		override.LogFormat = *flagLogFormat
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
 	}
-	cfg = config.Merge(cfg, override)
 
 	// Validate configuration before proceeding
 	if err := config.Validate(cfg); err != nil {
-		log.Fatalf("configuration validation failed: %v", err)
+		return fmt.Errorf("configuration validation failed: %w", err)
 	}
 
 	// Initialize structured logging
 	initLogger(cfg)
+	logConfigWarnings(cfg)
 
 	// Create context with signal handling for graceful shutdown
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	ctx, cancel := signal.NotifyContext(context.Background(), shutdownSignals...)
 	defer cancel()
+	if cfg, err = resolveTimestampShift(cfg); err != nil {
+		return err
+	}
+	if err := preflight(ctx, cfg); err != nil {
+		return err
+	}
 
-	rep := report.NewReport()
-	in, closeFn, err := inputReader(cfg.InputPath)
+	ctx, stopServers, err := startServers(ctx, cfg)
 	if err != nil {
-		log.Fatalf("open input: %v", err)
+		return err
+	}
+	defer stopServers()
+	notifier := newSDNotifier(ctx)
+	defer notifier.Close(ctx)
+	ctx = withNotifier(ctx, notifier)
+
+	if cfg.CPUProfile != "" {
+		f, err := os.Create(cfg.CPUProfile)
+		if err != nil {
+			return fmt.Errorf("create cpu profile: %w", err)
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return fmt.Errorf("start cpu profile: %w", err)
+		}
+		defer func() {
+			pprof.StopCPUProfile()
+			f.Close()
+		}()
+	}
+
+	rep := report.NewReport()
+	rep.RunID = newRunID()
+	rep.Hostname, _ = os.Hostname()
+	rep.BuildInfo = buildInfo()
+	// Fix the jitter seed now so the run manifest records it.
+	if cfg.RetryJitterSeed == 0 {
+		cfg.RetryJitterSeed = time.Now().UnixNano()
 	}
-	if closeFn != nil {
-		defer closeFn()
+	finishManifest := startRunManifest(cfg, rep)
+	defer func() { finishManifest(err) }()
+	openScanner, closeInput, err := openInput(ctx, cfg)
+	if err != nil {
+		return err
 	}
+	defer closeInput()
 
 	// Run pipeline with context for graceful shutdown
-	if err := runPipeline(ctx, in, cfg, rep); err != nil {
+	if err := runPipelineFrom(ctx, openScanner, cfg, rep); err != nil {
 		logger.ErrorContext(ctx, "pipeline failed", "error", err)
-		os.Exit(1)
+		return fmt.Errorf("pipeline failed: %w", err)
 	}
 
 	fmt.Printf(
@@ -183,13 +187,26 @@ func main() {
 			rep.StageTimings.WritingSeconds,
 		)
 	}
+	if len(rep.TopMessages) > 0 {
+		fmt.Println("Top Messages:")
+		for _, mc := range rep.TopMessages {
+			fmt.Printf("  %6d  %s\n", mc.Count, mc.Template)
+		}
+	}
+	if len(rep.LabelsTruncated) > 0 {
+		fmt.Printf("Labels Truncated: %s (over %d values, the rest counted as %s)\n", strings.Join(rep.LabelsTruncated, ", "), rep.MaxLabelValues, report.OtherLabel)
+	}
+	for _, ts := range rep.Transforms {
+		fmt.Printf("Transform %s: In: %d, Dropped: %d, Errors: %d, Time: %.3fs\n", ts.Name, ts.RecordsIn, ts.Dropped, ts.Errors, ts.Seconds)
+	}
 
 	if rep.RetryStats.TotalRetries > 0 {
 		fmt.Printf(
-			"Retry Stats: Total Retries: %d, Writes with Retries: %d, Max Retries per Write: %d\n",
+			"Retry Stats: Total Retries: %d, Writes with Retries: %d, Max Retries per Write: %d, Write Timeouts: %d\n",
 			rep.RetryStats.TotalRetries,
 			rep.RetryStats.WritesWithRetries,
 			rep.RetryStats.MaxRetriesPerWrite,
+			rep.RetryStats.WriteTimeouts,
 		)
 	}
 
@@ -206,6 +223,7 @@ func main() {
 		}
 		fmt.Println()
 	}
+	return nil
 }
 
 func initLogger(cfg config.Config) {
@@ -232,47 +250,133 @@ func initLogger(cfg config.Config) {
 }
 
 func runPipeline(ctx context.Context, in io.Reader, cfg config.Config, rep *report.Report) error {
-	logger.InfoContext(ctx, "starting pipeline", "workers", cfg.MaxWorkers, "queue_size", cfg.QueueSize)
-	transforms, err := plugins.BuildTransforms(cfg)
+	return runPipelineFrom(ctx, func() source.Source { return source.NewReader(in, cfg.InputFormat, "", lineLimits(cfg)) }, cfg, rep)
+}
+
+// runPipelineFrom runs the pipeline over the records of the source built
+// by open. It is called once the sink is open, so a slow input does not
+// delay sink errors.
+func runPipelineFrom(ctx context.Context, open func() source.Source, cfg config.Config, rep *report.Report) (err error) {
+	runClk := clockFrom(ctx)
+	// The report may already be read, e.g. by a metrics endpoint, so
+	// what the run sets on it goes through its lock.
+	if rep.RunID == "" {
+		rep.SetRunID(newRunID())
+	}
+	if cfg.MetricsTextfilePath != "" {
+		// Registered first so it runs last, after the sink is closed and
+		// err holds the run's final outcome.
+		runStart := time.Now()
+		defer func() {
+			if rep.DurationSeconds == 0 {
+				rep.SetDuration(time.Since(runStart))
+			}
+			if writeErr := rep.WritePrometheusFile(cfg.MetricsTextfilePath, cfg.FilePerm(), err == nil, time.Now()); writeErr != nil {
+				logger.ErrorContext(ctx, "failed to write metrics textfile", "error", writeErr)
+				if err == nil {
+					err = fmt.Errorf("write metrics textfile: %w", writeErr)
+				}
+			}
+		}()
+	}
+	if cfg.OutputManifest {
+		rep.SetManifestPath(manifestPath(cfg.OutputPath))
+	}
+	logger.InfoContext(ctx, "starting pipeline", "run_id", rep.RunID, "workers", cfg.MaxWorkers, "queue_size", cfg.QueueSize)
+	var dropRules *dropRuleSet
+	if cfg.DropRulesFile != "" {
+		if dropRules, err = loadDropRuleSet(cfg.DropRulesFile, rep); err != nil {
+			return err
+		}
+		if cfg.DropRulesReload && len(reloadSignals) > 0 {
+			sig := make(chan os.Signal, 1)
+			signal.Notify(sig, reloadSignals...)
+			defer signal.Stop(sig)
+			watchCtx, stopWatch := context.WithCancel(ctx)
+			defer stopWatch()
+			go dropRules.watch(watchCtx, sig)
+		}
+	}
+	var schema *stages.SchemaValidator
+	if cfg.JSONSchemaFile != "" {
+		if schema, err = stages.LoadSchema(cfg.JSONSchemaFile); err != nil {
+			return err
+		}
+	}
+	budget := newRetryBudget(cfg.SinkRetryBudgetDuration(), cfg.SinkRetryBudgetWindowDuration(), runClk)
+	ctx = withRetryBudget(ctx, budget)
+	transforms, transformCloser, err := plugins.BuildTransforms(cfg)
 	if err != nil {
 		return fmt.Errorf("load transforms: %w", err)
 	}
-	
-	// Build sink with batching support
-	sinkWriter, err := sink.Build(ctx, cfg)
+	defer func() {
+		if err := transformCloser.Close(); err != nil {
+			logger.ErrorContext(ctx, "error closing transforms", "error", err)
+		}
+	}()
+	transformNames := make([]string, len(transforms))
+	for i, tf := range transforms {
+		transformNames[i] = tf.Name
+		if tf.Collector != nil {
+			rep.AddCollector(tf.Collector)
+		}
+	}
+	rep.InitTransforms(transformNames)
+	if cfg.TransformAudit {
+		rep.EnableTransformAudit()
+	}
+	rep.EnableTopMessages(cfg.TopMessages)
+	rep.LimitLabelValues(cfg.ReportMaxLabelValues, func(label string) {
+		logger.WarnContext(ctx, "report label reached report_max_label_values, further values are counted as "+report.OtherLabel, "label", label, "max", cfg.ReportMaxLabelValues)
+	})
+
+	finalSink, err := openSink(sink.WithRunID(ctx, rep.RunID), cfg)
 	if err != nil {
-		return fmt.Errorf("open sink: %w", err)
+		return err
 	}
+	if cfg.OutputResume {
+		if fi, err := os.Stat(cfg.OutputPath); err == nil && fi.Size() > 0 {
+			logger.InfoContext(ctx, "resuming output left by an earlier run", "output", cfg.OutputPath, "bytes", fi.Size())
+		}
+	}
+	health := healthFrom(ctx)
+	health.SinkOpened()
+	notifier := notifierFrom(ctx)
 	defer func() {
-		if err := sinkWriter.Close(); err != nil {
-			logger.ErrorContext(ctx, "error closing sink", "error", err)
+		health.SinkClosed()
+		closeErr := closeSink(ctx, rep, finalSink)
+		if closeErr != nil {
+			logger.ErrorContext(ctx, "error closing sink", "error", closeErr)
 		}
-	}()
-	
-	// Wrap sink with batching if configured
-	var finalSink sink.Writer = sinkWriter
-	if cfg.BatchSize > 1 {
-		batchedSink, err := sink.NewBatchedSink(sinkWriter, cfg.BatchSize, time.Duration(cfg.BatchFlushInterval)*time.Millisecond)
-		if err != nil {
-			return fmt.Errorf("create batched sink: %w", err)
+		// Batched records reach their paths only as the sink closes.
+		if pt, ok := sink.PathTemplate(finalSink); ok {
+			rep.SetOutputPaths(report.OutputPathStats{Template: pt.Template, MaxOpen: pt.MaxOpen, Created: pt.Created, Opens: pt.Opens, Evictions: pt.Evictions})
 		}
-		finalSink = batchedSink
-		defer func() {
-			if err := batchedSink.Close(); err != nil {
-				logger.ErrorContext(ctx, "error closing batched sink", "error", err)
+		if err == nil {
+			err = finishOutput(cfg, rep, finalSink, closeErr)
+			if err == nil && errors.Is(closeErr, errPipelinePanic) {
+				err = closeErr
 			}
-		}()
+		} else if cfg.OutputAtomic {
+			if abortErr := sink.AbortAtomic(cfg.OutputPath); abortErr != nil {
+				logger.ErrorContext(ctx, "error removing temp output", "error", abortErr)
+			}
+		}
+	}()
+
+	if c, ok := finalSink.(report.Collector); ok {
+		rep.AddCollector(c)
 	}
-	
+	probe := benchProbeFrom(ctx)
 	lockedSink := &lockedWriter{w: finalSink}
 
-	var dlqWriter *lockedWriter
+	var dlqWriter *deadLetters
 	if cfg.DLQPath != "" {
-		dlq, err := openDLQ(cfg.DLQPath)
+		dlq, err := openDLQ(cfg)
 		if err != nil {
 			return fmt.Errorf("open dlq: %w", err)
 		}
-		dlqWriter = &lockedWriter{w: dlq}
+		dlqWriter = newDeadLetters(dlq, cfg)
 		defer func() {
 			if err := dlqWriter.Close(); err != nil {
 				logger.ErrorContext(ctx, "error closing DLQ", "error", err)
@@ -280,8 +384,64 @@ func runPipeline(ctx context.Context, in io.Reader, cfg config.Config, rep *repo
 		}()
 	}
 
+	var spill *spillQueue
+	if cfg.SpillDir != "" {
+		if err := cfg.FilePerm().Check(cfg.SpillDir); err != nil {
+			return fmt.Errorf("spill dir: %w", err)
+		}
+		if spill, err = openSpillQueue(cfg.SpillDir, cfg.SpillMaxBytes); err != nil {
+			return err
+		}
+		if n := spill.pending(); n > 0 {
+			logger.InfoContext(ctx, "resuming spill queue left by an earlier run", "dir", cfg.SpillDir, "records", n)
+		}
+	}
+
 	start := time.Now()
-	scanner := bufio.NewScanner(in)
+	stopSampler := startRuntimeSampler(rep, runtimeSampleInterval, runClk)
+	defer stopSampler()
+	var durationReached atomic.Bool
+	// A full output disk, like resumed output that differs from the file,
+	// fails every later write as well, so the first worker to hit it
+	// cancels readCtx with the error: reading stops and the workers stop
+	// taking records instead of failing each in turn. A worker overflowing
+	// the DLQ under dlq_overflow_policy abort, or panicking, does the same.
+	readCtx, stopReading := context.WithCancelCause(ctx)
+	defer stopReading(nil)
+	sinkFatal := func() error {
+		if err := context.Cause(readCtx); errors.Is(err, sink.ErrDiskFull) || errors.Is(err, sink.ErrResumeMismatch) || errors.Is(err, errDLQOverflow) || errors.Is(err, errPipelinePanic) || errors.Is(err, errWorkerStalled) {
+			return err
+		}
+		return nil
+	}
+	// With max_duration reads run on their own goroutine as well, so the
+	// timer can end a read blocked on a silent pipe.
+	var input source.Source
+	if timeout := cfg.InputIdleTimeoutDuration(); timeout > 0 || cfg.MaxDurationDuration() > 0 {
+		input = newIdleSource(open, timeout, strings.EqualFold(cfg.InputIdleAction, config.IdleExit), rep, runClk)
+	} else {
+		input = open()
+	}
+	// The max_duration timer raises a flag: the read loop stops at the next
+	// record, as for head, and what was read is still written. It also
+	// ends nextCtx with errMaxDuration, ending the wait for the next record,
+	// as does reading stopping on a fatal error. readCtx itself stays up,
+	// so the workers still drain the queue.
+	nextCtx, stopNext := context.WithCancelCause(readCtx)
+	defer stopNext(nil)
+	if d := cfg.MaxDurationDuration(); d > 0 {
+		timerDone := make(chan struct{})
+		defer close(timerDone)
+		timer := runClk.After(d)
+		go func() {
+			select {
+			case <-timer:
+				durationReached.Store(true)
+				stopNext(errMaxDuration)
+			case <-timerDone:
+			}
+		}()
+	}
 
 	workerCount := cfg.MaxWorkers
 	if workerCount <= 0 {
@@ -292,189 +452,681 @@ func runPipeline(ctx context.Context, in io.Reader, cfg config.Config, rep *repo
 		queueSize = 128
 	}
 
-	queue := make(chan workItem, queueSize)
+	queue := newWorkQueue(queueSize, cfg.QueuePriorityByLevel)
+	inflight := newInflightLimiter(cfg.MaxInflightBytes, rep)
+	pacing := newPacer(cfg)
+	routes := newRouteWrites(finalSink, cfg)
+	timer := stageTimer{rep: rep, enabled: cfg.StageTimingsEnabled()}
+	progress := newWorkerProgress(workerCount, runClk)
 	var wg sync.WaitGroup
 	wg.Add(workerCount)
-	rand.Seed(time.Now().UnixNano())
+	seedJitter(cfg.RetryJitterSeed)
 
-	// Start workers with context-aware shutdown
+	if len(statusSignals) > 0 {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, statusSignals...)
+		defer signal.Stop(sig)
+		watchCtx, stopWatch := context.WithCancel(ctx)
+		defer stopWatch()
+		go watchStatus(watchCtx, sig, func() { logStatus(ctx, start, rep, queue, progress) })
+	}
+	// The workers write under writesCtx. stall_action abort cancels it
+	// with errWorkerStalled, abandoning the writes in flight, even those
+	// writeOnce lets outlive a shutdown, so no worker outlives the run.
+	writesCtx, abandonWrites := context.WithCancelCause(ctx)
+	defer abandonWrites(nil)
+	if after := cfg.StallWarnAfterDuration(); after > 0 {
+		var abort func(error)
+		if strings.EqualFold(cfg.StallAction, config.StallAbort) {
+			abort = func(err error) {
+				stopReading(err)
+				abandonWrites(err)
+			}
+		}
+		watchCtx, stopWatch := context.WithCancel(ctx)
+		defer stopWatch()
+		go watchStalls(watchCtx, progress, after, abort)
+	}
+	if notifier != nil {
+		watchCtx, stopWatch := context.WithCancel(ctx)
+		defer stopWatch()
+		go notifier.Watch(watchCtx, progress, cfg.StallWarnAfterDuration())
+	}
+
+	// The write stage: workers drain the queue into the sink.
+	writes := &writeStage{
+		cfg: cfg, rep: rep, queue: queue, inflight: inflight, pacing: pacing, routes: routes, timer: timer, progress: progress,
+		sink: lockedSink, dlq: dlqWriter, spill: spill, budget: budget, health: health, notifier: notifier, probe: probe,
+		readCtx: readCtx, writesCtx: writesCtx, stopReading: stopReading,
+	}
 	for i := 0; i < workerCount; i++ {
 		go func(workerID int) {
 			defer wg.Done()
-			for {
-				select {
-				case <-ctx.Done():
-					logger.DebugContext(ctx, "worker shutting down", "worker_id", workerID)
-					return
-				case item, ok := <-queue:
-					if !ok {
-						return
-					}
-					writeStart := time.Now()
-					retries, err := writeWithRetry(ctx, lockedSink, item.record, cfg, rep)
-					rep.AddStageTiming("writing", time.Since(writeStart))
-					if err != nil {
-						rep.AddWriteFailed()
-						logger.WarnContext(ctx, "write failed", "error", err, "retries", retries)
-						if dlqWriter != nil {
-							reason := err.Error()
-							if writeErr := dlqWriter.Write(dlqRecord{Record: item.record, Reason: reason}); writeErr != nil {
-								logger.ErrorContext(ctx, "failed to write to DLQ", "error", writeErr)
-							}
-							rep.AddDLQWithReason(reason)
-						}
-						continue
-					}
-					rep.AddWriteOK()
-					if retries > 0 {
-						logger.DebugContext(ctx, "write succeeded after retries", "retries", retries)
-					}
-				}
-			}
+			writes.work(ctx, workerID)
 		}(i)
 	}
 
-	// Main processing loop with context cancellation
-	lineNum := 0
-	shutdownRequested := false
-	for scanner.Scan() {
-		// Check for shutdown signal
-		select {
-		case <-ctx.Done():
-			logger.InfoContext(ctx, "shutdown signal received, finishing in-flight records")
-			shutdownRequested = true
-		default:
-		}
-		
-		if shutdownRequested {
-			break
-		}
-
-		line := scanner.Text()
-		if len(strings.TrimSpace(line)) == 0 {
-			continue
-		}
-
-		lineNum++
-		rep.TotalLines++
-		
-		// Create context with trace ID for this record
-		recordCtx := context.WithValue(ctx, "trace_id", fmt.Sprintf("line-%d", lineNum))
-
-		// Track parsing time
-		parseStart := time.Now()
-		var js map[string]interface{}
-		if err := json.Unmarshal([]byte(line), &js); err != nil {
-			rep.JSONFailed++
-			rep.AddStageTiming("parsing", time.Since(parseStart))
-			logger.DebugContext(recordCtx, "JSON parse failed", "error", err, "line", lineNum)
-			continue
-		}
-		rep.AddStageTiming("parsing", time.Since(parseStart))
-		rep.JSONParsed++
-
-		// Track normalization time
-		normStart := time.Now()
-		normalized, normerr := stages.Normalize(js)
-		rep.AddStageTiming("normalization", time.Since(normStart))
-		if normerr != nil {
-			rep.NormalizedFailed++
-			logger.WarnContext(recordCtx, "normalization failed", "error", normerr, "line", lineNum)
-			continue
-		}
-
-		rep.NormalizedOK++
-		rep.AddLevel(normalized.Level)
-		rep.AddService(normalized.Service)
-
-		// Track filtering time
-		filterStart := time.Now()
-		skipped := false
-		for _, tf := range transforms {
-			nn, drop, reason, err := tf(normalized)
-			if err != nil {
-				rep.NormalizedFailed++
-				logger.WarnContext(recordCtx, "transform error", "error", err, "line", lineNum)
-				skipped = true
-				break
-			}
-			if drop {
-				rep.AddFiltered(reason)
-				skipped = true
-				break
-			}
-			normalized = nn
-		}
-		rep.AddStageTiming("filtering", time.Since(filterStart))
-		if skipped {
-			continue
+	// The drainer writes spooled records alongside the workers, one at a
+	// time; a failed write leaves the rest for its next pass.
+	stopDrainer := func() {}
+	if spill != nil {
+		drainCtx, cancelDrain := context.WithCancel(ctx)
+		drained := make(chan struct{})
+		go func() {
+			defer close(drained)
+			spill.run(drainCtx, drainSpill(lockedSink, dlqWriter, cfg, rep))
+		}()
+		stopDrainer = func() {
+			cancelDrain()
+			<-drained
 		}
-
-		queue <- workItem{record: normalized}
+		defer stopDrainer()
 	}
 
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("scanner error: %w", err)
+	// The read and process stages run on this goroutine, feeding the workers.
+	reads := &readStage{
+		cfg: cfg, rep: rep, clock: runClk, timer: timer, queue: queue, inflight: inflight, health: health, probe: probe,
+		dlq: dlqWriter, schema: schema, dropRules: dropRules, transforms: transforms,
+		readCtx: readCtx, sinkFatal: sinkFatal, durationReached: &durationReached,
 	}
+	reads.read(ctx, nextCtx, input)
+	reads.finish(ctx)
+	abortErr, scanErr := reads.abortErr, reads.scanErr
 
 	// Close queue and wait for workers with timeout
 	logger.InfoContext(ctx, "input exhausted, waiting for workers to finish")
-	close(queue)
-	
+	notifier.Stopping(ctx)
+	queue.close()
+
 	// Wait for workers with timeout
 	done := make(chan struct{})
 	go func() {
 		wg.Wait()
 		close(done)
 	}()
-	
+
 	shutdownTimeout := time.Duration(cfg.ShutdownTimeoutSeconds) * time.Second
 	if shutdownTimeout <= 0 {
 		shutdownTimeout = 30 * time.Second
 	}
-	
+
+	timedOut := false
 	select {
 	case <-done:
 		logger.InfoContext(ctx, "all workers finished")
 	case <-time.After(shutdownTimeout):
 		logger.WarnContext(ctx, "shutdown timeout exceeded, some records may not have been processed", "timeout", shutdownTimeout)
-		return fmt.Errorf("shutdown timeout exceeded after %v", shutdownTimeout)
-	case <-ctx.Done():
-		logger.WarnContext(ctx, "context cancelled during shutdown wait")
-		return ctx.Err()
+		timedOut = true
+	}
+	if err := sinkFatal(); err != nil {
+		// Workers may hit it after the input ended, draining the queue.
+		abortErr = err
+	}
+	if errors.Is(abortErr, sink.ErrDiskFull) {
+		logger.ErrorContext(ctx, "output disk full, stopping the run", "error", abortErr)
+	}
+	if spill != nil {
+		stopDrainer()
+		// A last pass once the input is done; what the sink still refuses
+		// stays in spill_dir for the next run.
+		if !timedOut && abortErr == nil && ctx.Err() == nil && spill.pending() > 0 {
+			if err := spill.drain(ctx, drainSpill(lockedSink, dlqWriter, cfg, rep)); err != nil {
+				logger.WarnContext(ctx, "records left in spill_dir for the next run", "dir", cfg.SpillDir, "records", spill.pending(), "error", err)
+			}
+		}
+		spill.close()
+		rep.SetSpill(spill.snapshot())
+	}
+	if pq, ok := queue.(*priorityQueue); ok {
+		rep.SetQueueWait(pq.stats())
+	}
+	// Records a batching sink still buffers are acked by the flush that
+	// writes them, so flush before the input's counts are taken; a failed
+	// flush leaves them unacked for redelivery.
+	if err := flushSink(context.WithoutCancel(ctx), rep, finalSink); err != nil {
+		if !errors.Is(err, errPipelinePanic) {
+			logger.ErrorContext(ctx, "final flush failed, buffered records not acknowledged", "error", err)
+			err = fmt.Errorf("flush sink: %w", err)
+		}
+		if abortErr == nil {
+			abortErr = err
+		}
+	}
+
+	stop := report.ShutdownStats{
+		Reason:           stopReason(ctx, reads, abortErr, timedOut),
+		LinesNotEnqueued: reads.notEnqueued,
+		QueueRemaining:   queue.len(),
+		Workers:          make([]report.WorkerStatus, len(progress)),
+	}
+	for i := range progress {
+		stop.Workers[i] = progress[i].status(i)
+	}
+	rep.SetShutdown(stop)
+	// Acks are sent as workers finish records, so an input's counts are
+	// only final once they have stopped.
+	if r, ok := input.(source.Reporter); ok {
+		r.Report(rep)
+	}
+	if dropRules != nil {
+		dropRules.publish()
+	}
+	if budget != nil {
+		rep.SetRetryBudget(budget.stats())
+	}
+	if cfg.Skip > 0 || cfg.Head > 0 || cfg.MaxDuration != "" {
+		rep.SetLimits(report.LimitStats{
+			Skip: cfg.Skip, Head: cfg.Head, SkippedLines: reads.skippedLines, HeadReached: reads.headReached,
+			MaxDurationSeconds: cfg.MaxDurationDuration().Seconds(), MaxDurationReached: reads.durationStopped,
+			LastLine: reads.lineNum,
+		})
+	}
+	reportComponents(rep, finalSink, routes, transforms, pacing)
+	stopSampler()
+	if stop.Reason != report.StopEOF && stop.Reason != report.StopHead && stop.Reason != report.StopDuration {
+		logger.InfoContext(ctx, "pipeline stopped early", "reason", stop.Reason, "lines_not_enqueued", stop.LinesNotEnqueued, "queue_remaining", stop.QueueRemaining)
 	}
 
 	rep.SetDuration(time.Since(start))
 	logger.InfoContext(ctx, "pipeline completed", "duration_seconds", rep.DurationSeconds, "throughput", rep.Throughput)
-	
-	if err := rep.WriteJSON(cfg.ReportPath); err != nil {
-		return fmt.Errorf("write report: %w", err)
+
+	reportErr := writeReport(ctx, cfg, rep)
+	if errors.Is(abortErr, sink.ErrDiskFull) {
+		return abortErr
+	}
+	if reportErr != nil {
+		return fmt.Errorf("write report: %w", reportErr)
 	}
 
+	if errors.Is(scanErr, errInputIdle) {
+		return scanErr
+	}
+	if scanErr != nil {
+		return fmt.Errorf("scanner error: %w", scanErr)
+	}
+	if timedOut && !errors.Is(abortErr, errWorkerStalled) {
+		return fmt.Errorf("shutdown timeout exceeded after %v", shutdownTimeout)
+	}
+	if abortErr != nil {
+		return abortErr
+	}
+	// The report is still written on cancellation, but callers need to know
+	// the input was not fully processed.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if reads.durationStopped && (strings.EqualFold(cfg.InputType, config.InputNATS) || strings.EqualFold(cfg.InputType, config.InputJournald)) {
+		return fmt.Errorf("%w: stopped reading after %s", errMaxDuration, cfg.MaxDuration)
+	}
+	if reads.durationStopped {
+		return fmt.Errorf("%w: stopped reading after %s at line %d; run again with --skip %d to continue", errMaxDuration, cfg.MaxDuration, reads.lineNum, reads.lineNum)
+	}
 	return nil
 }
 
-// parseList is a small helper for comma/semicolon-separated values.
-func parseList(s string) []string {
-	parts := strings.FieldsFunc(s, func(r rune) bool {
-		return r == ',' || r == ';'
-	})
-	out := make([]string, 0, len(parts))
-	for _, p := range parts {
-		if trimmed := strings.TrimSpace(p); trimmed != "" {
-			out = append(out, trimmed)
+// stopReason says why a run stopped, for ShutdownStats.Reason. abortErr
+// is what stopped it short of the input's end, if anything did.
+func stopReason(ctx context.Context, reads *readStage, abortErr error, timedOut bool) string {
+	switch {
+	case errors.Is(abortErr, errWorkerStalled):
+		// The stalled worker is also why the shutdown timed out.
+		return report.StopStalled
+	case timedOut:
+		return report.StopTimeout
+	case errors.Is(reads.scanErr, errInputIdle):
+		return report.StopIdle
+	case errors.Is(abortErr, sink.ErrDiskFull):
+		return report.StopDiskFull
+	case errors.Is(abortErr, errPipelinePanic):
+		return report.StopPanic
+	case reads.scanErr != nil || abortErr != nil:
+		return report.StopError
+	case ctx.Err() != nil:
+		return report.StopSignal
+	case reads.headReached:
+		return report.StopHead
+	case reads.durationStopped:
+		return report.StopDuration
+	}
+	return report.StopEOF
+}
+
+// reportComponents adds to rep what the sink, the transforms, and replay
+// pacing counted over a run.
+func reportComponents(rep *report.Report, w sink.Writer, routes *routeWrites, transforms []plugins.Named, pacing *pacer) {
+	if h, ok := sink.HTTPConnections(w); ok {
+		rep.SetHTTPConnections(report.HTTPConnStats{
+			Requests: h.Requests, New: h.New, Reused: h.Reused, DNSLookups: h.DNSLookups, TLSHandshakes: h.TLSHandshakes,
+		})
+	}
+	if rt, ok := sink.Routing(w); ok {
+		stats := report.RouterStats{Default: rt.Default, Unroutable: int(rt.Unroutable), Routes: make([]report.RouteStats, len(rt.Routes))}
+		for i, r := range rt.Routes {
+			stats.Routes[i] = report.RouteStats{Name: r.Name, Output: r.Output, Records: int(r.Records), Failed: int(r.Failed)}
 		}
+		routes.report(&stats)
+		rep.SetRouter(stats)
+	}
+	if f, ok := sink.Faults(w); ok {
+		rep.SetFaults(report.FaultStats{
+			Attempts: f.Attempts, Injected: f.Injected, Random: f.Random,
+			EveryNth: f.EveryNth, AfterLimit: f.AfterLimit, Delayed: f.Delayed,
+		})
+	}
+	if q, ok := plugins.QuotaUsage(transforms); ok {
+		rep.SetQuotas(q)
+	}
+	if ts, ok := plugins.TimestampShift(transforms); ok {
+		rep.SetTimestampShift(ts)
+	}
+	if pacing != nil {
+		rep.SetReplayPacing(pacing.stats())
+	}
+	if counts, ok := plugins.Redactions(transforms); ok && len(counts) > 0 {
+		rep.SetRedactions(counts)
+	}
+}
+
+// normalizeOptions maps the config onto stages.NormalizeOptions.
+func normalizeOptions(cfg config.Config) stages.NormalizeOptions {
+	return stages.NormalizeOptions{DeriveServiceFromPod: cfg.DeriveServiceFromPod, Redact: cfg.RedactKeys}
+}
+
+// unwrapOptions maps the config onto stages.UnwrapOptions.
+func unwrapOptions(cfg config.Config) stages.UnwrapOptions {
+	return stages.UnwrapOptions{
+		Keys:      cfg.UnwrapKeys,
+		OuterWins: strings.EqualFold(cfg.UnwrapConflict, config.UnwrapOuter),
+	}
+}
+
+// lineContext tags ctx with the input line as the log trace ID. It
+// allocates, so the read loop only calls it on paths that log.
+func lineContext(ctx context.Context, line int) context.Context {
+	return context.WithValue(ctx, "trace_id", "line-"+strconv.Itoa(line))
+}
+
+// stageTimer records stage timings into the report, or a worker's shard
+// of it. When disabled it skips the clock reads entirely.
+type stageTimer struct {
+	rep     interface{ AddStageTiming(string, time.Duration) }
+	enabled bool
+}
+
+func (t stageTimer) start() time.Time {
+	if !t.enabled {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+func (t stageTimer) record(stage string, start time.Time) {
+	if t.enabled {
+		t.rep.AddStageTiming(stage, time.Since(start))
 	}
-	return out
 }
 
 type workItem struct {
 	record model.Normalized
+	line   int
+	src    source.Origin
+	raw    json.RawMessage // input record, when dlq_payload asks for it
+	size   int64           // estimated bytes, when max_inflight_bytes is set
+	trace  bool            // selected by trace_record
+	queued time.Time       // when it was queued, with queue_priority_by_level
+	ack    *inputAck       // released once the record is written or dead-lettered
+}
+
+// workerProgress is what a sink worker publishes for the shutdown and
+// status snapshots and the stall watchdog, which read it while the worker
+// is still running.
+type workerProgress struct {
+	inFlightLine atomic.Int64 // 0 when idle
+	processed    atomic.Int64
+	lastActive   atomic.Int64 // unix nanoseconds on clock, 0 before the first record
+	stalls       atomic.Int64 // set by the stall watchdog
+	clock        clock.Clock
+
+	mu      sync.Mutex // guards the identifiers of the record in flight
+	service string
+	pod     string
+	traceID string
 }
 
+// newWorkerProgress returns the progress of n workers, timed on c.
+func newWorkerProgress(n int, c clock.Clock) []workerProgress {
+	progress := make([]workerProgress, n)
+	for i := range progress {
+		progress[i].clock = c
+	}
+	return progress
+}
+
+// begin publishes that the worker took item.
+func (p *workerProgress) begin(item *workItem) {
+	p.mu.Lock()
+	p.service, p.pod, p.traceID = item.record.Service, item.record.Pod, item.record.TraceID
+	p.mu.Unlock()
+	p.lastActive.Store(p.clock.Now().UnixNano())
+	p.inFlightLine.Store(int64(item.line))
+}
+
+// done publishes that the worker finished its record.
+func (p *workerProgress) done() {
+	p.inFlightLine.Store(0)
+	p.processed.Add(1)
+	p.lastActive.Store(p.clock.Now().UnixNano())
+}
+
+func (p *workerProgress) status(id int) report.WorkerStatus {
+	line := p.inFlightLine.Load()
+	s := report.WorkerStatus{
+		ID:           id,
+		Processed:    int(p.processed.Load()),
+		InFlight:     line != 0,
+		InFlightLine: int(line),
+		Stalls:       int(p.stalls.Load()),
+	}
+	if s.InFlight {
+		p.mu.Lock()
+		s.InFlightService, s.InFlightPod, s.InFlightTraceID = p.service, p.pod, p.traceID
+		p.mu.Unlock()
+	}
+	if ns := p.lastActive.Load(); ns != 0 {
+		s.LastActive = time.Unix(0, ns).UTC().Format(time.RFC3339Nano)
+	}
+	return s
+}
+
+// dlqRecord is one dead-letter entry. Write and transform failures carry the
+// normalized Record, the input record in Raw, or both, as dlq_payload asks;
+// normalization failures always carry Raw and never Record. Entries written
+// before dlq_payload existed have no Stage, FailedAt or Attempts. Truncated
+// entries had their extra fields dropped to fit dlq_max_record_bytes.
 type dlqRecord struct {
-	Record model.Normalized `json:"record"`
-	Reason string           `json:"reason"`
+	Record        *model.Normalized `json:"record,omitempty"`
+	Raw           map[string]any    `json:"raw,omitempty"`
+	FailedAt      string            `json:"failed_at,omitempty"`
+	Stage         string            `json:"stage,omitempty"`
+	Line          int               `json:"line,omitempty"`
+	Source        *source.Origin    `json:"source,omitempty"`
+	Reason        string            `json:"reason"`
+	Error         string            `json:"error,omitempty"`
+	Attempts      int               `json:"attempts,omitempty"`
+	RunID         string            `json:"run_id"`
+	Truncated     bool              `json:"truncated,omitempty"`
+	OriginalBytes int               `json:"original_bytes,omitempty"`
+	// rawJSON is the input record as read, encoded as raw in place of Raw
+	// so the pipeline need not decode it again.
+	rawJSON json.RawMessage
+	// legacy encodes Record with the legacy output schema.
+	legacy bool
+	// ack is the acknowledgement of the input record, failed when the
+	// entry is not written.
+	ack *inputAck
+}
+
+// Stages a DLQ entry can record as where the record failed.
+const (
+	dlqStageParse     = "parse"
+	dlqStageSchema    = "schema"
+	dlqStageNormalize = "normalize"
+	dlqStageTransform = "transform"
+	dlqStageSink      = "sink"
+)
+
+// MarshalJSON implements json.Marshaler.
+func (rec dlqRecord) MarshalJSON() ([]byte, error) {
+	type plain dlqRecord
+	if rec.rawJSON == nil && (!rec.legacy || rec.Record == nil) {
+		return json.Marshal(plain(rec))
+	}
+	out := struct {
+		plain
+		Record any `json:"record,omitempty"`
+		Raw    any `json:"raw,omitempty"`
+	}{plain: plain(rec)}
+	switch {
+	case rec.Record != nil && rec.legacy:
+		out.Record = (*model.Legacy)(rec.Record)
+	case rec.Record != nil:
+		out.Record = rec.Record
+	}
+	switch {
+	case rec.rawJSON != nil:
+		out.Raw = rec.rawJSON
+	case rec.Raw != nil:
+		out.Raw = rec.Raw
+	}
+	return json.Marshal(out)
+}
+
+// hasRaw reports whether rec carries the input record.
+func (rec dlqRecord) hasRaw() bool {
+	return rec.Raw != nil || rec.rawJSON != nil
+}
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// rawInput is the input record for a raw DLQ payload, taken before unwrap:
+// the line itself when it holds only this record, else the record encoded
+// again.
+func rawInput(line []byte, records int, js map[string]any) json.RawMessage {
+	line = bytes.TrimPrefix(bytes.TrimSpace(line), utf8BOM)
+	if records == 1 && json.Valid(line) {
+		return bytes.Clone(line)
+	}
+	data, err := json.Marshal(js)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// sinkDLQReason is the DLQ reason for a failed write. Reasons are kept few;
+// the error itself goes in the entry's error. A write a router route failed
+// has the route's name appended, e.g. write_error:alerts.
+func sinkDLQReason(err error) string {
+	reason := sinkFailure(err)
+	var re *sink.RouteError
+	if errors.As(err, &re) {
+		reason += ":" + re.Route
+	}
+	return reason
+}
+
+// sinkFailure is the kind of failure of a failed write.
+func sinkFailure(err error) string {
+	switch {
+	case errors.Is(err, sink.ErrWriteTimeout):
+		return "write_timeout"
+	case errors.Is(err, sink.ErrInjected):
+		return "injected_failure"
+	case errors.Is(err, sink.ErrUnroutable):
+		return "unroutable"
+	case errors.Is(err, sink.ErrRejected):
+		return "rejected"
+	case errors.Is(err, sink.ErrDiskFull):
+		return "disk_full"
+	case errors.Is(err, sink.ErrFormat):
+		return "format_error"
+	}
+	return "write_error"
+}
+
+// truncatedKey replaces the dropped fields of a truncated DLQ entry.
+const truncatedKey = "_etl_truncated"
+
+// limit returns rec with its extra fields replaced by a size note when its
+// encoding exceeds maxBytes. The core fields are kept so the entry can still
+// be identified and replayed. maxBytes <= 0 disables the limit.
+func (rec dlqRecord) limit(maxBytes int) dlqRecord {
+	if maxBytes <= 0 {
+		return rec
+	}
+	data, err := json.Marshal(rec)
+	if err != nil || len(data) <= maxBytes {
+		return rec
+	}
+	note := fmt.Sprintf("fields dropped: entry was %d bytes, limit %d", len(data), maxBytes)
+	if rec.rawJSON != nil {
+		var raw map[string]any
+		if json.Unmarshal(rec.rawJSON, &raw) == nil {
+			rec.Raw, rec.rawJSON = raw, nil
+		}
+	}
+	if rec.Record != nil {
+		r := *rec.Record
+		r.Fields = map[string]any{truncatedKey: note}
+		rec.Record = &r
+	}
+	if rec.Raw != nil {
+		raw := make(map[string]any, len(stages.InputKeys)+1)
+		for _, k := range stages.InputKeys {
+			if v, ok := rec.Raw[k]; ok {
+				raw[k] = v
+			}
+		}
+		raw[truncatedKey] = note
+		rec.Raw = raw
+	}
+	if !rec.Truncated {
+		rec.OriginalBytes = len(data)
+	}
+	rec.Truncated = true
+	return rec
+}
+
+// writeDLQ dead-letters rec through w in cfg's output schema, applying the
+// record size limit, and counts it in rep. Write errors are logged rather
+// than returned so a broken DLQ never stops the pipeline. An entry past the
+// DLQ caps is counted as overflow instead; the error is only non-nil when
+// dlq_overflow_policy abort asks for the run to stop.
+func writeDLQ(ctx context.Context, w *deadLetters, rec dlqRecord, cfg config.Config, rep *report.Report) error {
+	if rec.Record != nil && rec.hasRaw() {
+		switch {
+		case !cfg.DLQNormalized():
+			rec.Record = nil
+		case !cfg.DLQRaw():
+			rec.Raw, rec.rawJSON = nil, nil
+		}
+	}
+	rec.FailedAt = clockFrom(ctx).Now().UTC().Format(time.RFC3339Nano)
+	rec.legacy = cfg.LegacySchema()
+	rec = rec.limit(cfg.DLQMaxRecordBytes)
+	if !w.admit(ctx, rec) {
+		rec.ack.fail()
+		rep.AddDLQOverflow()
+		return w.overflowErr()
+	}
+	if err := w.Write(rec); err != nil {
+		rec.ack.fail()
+		logger.ErrorContext(ctx, "failed to write to DLQ", "error", err)
+	}
+	rep.AddDLQWithReason(rec.Reason)
+	if rec.Truncated {
+		rep.AddDLQTruncated()
+	}
+	return nil
+}
+
+// writeReport writes the run report to cfg.ReportPath. If that fails, as it
+// will when the same disk is full, the report is written to stdout instead
+// so the run's counts are not lost. The error for the report path is still
+// returned. report_format and report_schema pick the report's shape, and
+// report_md, report_ops_path, and report_quality_path write a Markdown
+// copy and the v2 sections as well.
+func writeReport(ctx context.Context, cfg config.Config, rep *report.Report) error {
+	schema := strings.ToLower(cfg.ReportSchema)
+	write := func(path string, perm fsutil.Perm) error { return rep.WriteJSONSchema(path, schema, perm) }
+	if strings.EqualFold(cfg.ReportFormat, config.ReportMarkdown) {
+		write = rep.WriteMarkdownFile
+	}
+	err := write(cfg.ReportPath, cfg.FilePerm())
+	if err != nil && cfg.ReportPath != "" && cfg.ReportPath != "-" {
+		logger.ErrorContext(ctx, "failed to write report, writing it to stdout", "path", cfg.ReportPath, "error", err)
+		if stdoutErr := write("-", fsutil.Perm{}); stdoutErr != nil {
+			logger.ErrorContext(ctx, "failed to write report to stdout", "error", stdoutErr)
+		}
+	}
+	extra := []struct {
+		name  string
+		path  string
+		write func(string, fsutil.Perm) error
+	}{
+		{"markdown", cfg.ReportMarkdownPath, rep.WriteMarkdownFile},
+		{"operational", cfg.ReportOpsPath, rep.WriteOperationalJSON},
+		{"data quality", cfg.ReportQualityPath, rep.WriteDataQualityJSON},
+	}
+	for _, x := range extra {
+		if x.path == "" {
+			continue
+		}
+		if xErr := x.write(x.path, cfg.FilePerm()); xErr != nil {
+			logger.ErrorContext(ctx, "failed to write "+x.name+" report", "path", x.path, "error", xErr)
+			if err == nil {
+				err = xErr
+			}
+		}
+	}
+	return err
+}
+
+// transformErrorKind is how the report counts a transform error: "timeout",
+// "panic", or "error".
+func transformErrorKind(err error) string {
+	var perr *plugins.PanicError
+	switch {
+	case errors.Is(err, plugins.ErrTransformTimeout):
+		return "timeout"
+	case errors.As(err, &perr):
+		return "panic"
+	}
+	return "error"
+}
+
+// stampTransformAudit adds transform_audit's list of transform outcomes
+// to the record's fields as _etl_transforms.
+func stampTransformAudit(rec *model.Normalized, audit []string) {
+	if rec.Fields == nil {
+		rec.Fields = make(map[string]any)
+	}
+	outcomes := make([]any, len(audit))
+	for i, o := range audit {
+		outcomes[i] = o
+	}
+	rec.Fields["_etl_transforms"] = outcomes
+}
+
+// normalizeDLQReason is the DLQ reason for a stages.NormalizeError code.
+func normalizeDLQReason(code string) string {
+	if code == "" {
+		code = "unknown"
+	}
+	return "normalize:" + code
+}
+
+// jitterRand is the source of retry backoff jitter, shared by the workers.
+var jitterRand = struct {
+	sync.Mutex
+	rng *rand.Rand
+}{rng: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+// seedJitter reseeds retry jitter for a run: from retry_jitter_seed when
+// set, so a rerun backs off the same way, and from the clock otherwise.
+func seedJitter(seed int64) {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	jitterRand.Lock()
+	jitterRand.rng = rand.New(rand.NewSource(seed))
+	jitterRand.Unlock()
+}
+
+func jitterFloat64() float64 {
+	jitterRand.Lock()
+	defer jitterRand.Unlock()
+	return jitterRand.rng.Float64()
 }
 
 func writeWithRetry(ctx context.Context, w sink.Writer, record any, cfg config.Config, rep *report.Report) (int, error) {
@@ -494,6 +1146,7 @@ func writeWithRetry(ctx context.Context, w sink.Writer, record any, cfg config.C
 	if jitterPct <= 0 {
 		jitterPct = 0.2
 	}
+	timeout := time.Duration(cfg.SinkWriteTimeoutMS) * time.Millisecond
 
 	var err error
 	retries := 0
@@ -504,30 +1157,67 @@ func writeWithRetry(ctx context.Context, w sink.Writer, record any, cfg config.C
 			return retries, ctx.Err()
 		default:
 		}
-		
-		if err = w.Write(record); err == nil {
+
+		if err = writeOnce(ctx, w, record, timeout); err == nil {
 			if retries > 0 && rep != nil {
 				rep.AddRetry(retries)
 			}
 			return retries, nil
 		}
+		if errors.Is(err, sink.ErrWriteTimeout) && rep != nil {
+			rep.AddWriteTimeout()
+		}
+		// A sink that cannot be interrupted goes on writing the record after
+		// the timeout; retrying or dead-lettering it before that write ends
+		// could store it twice, so its outcome decides. It gets the timeout
+		// once more to end: a write still hung after that is given up on,
+		// and the record dead-lettered as write_timeout, though the write
+		// may yet store it.
+		var abandoned *sink.AbandonedWrite
+		if errors.As(err, &abandoned) {
+			waitCtx, cancel := context.WithTimeout(ctx, timeout)
+			returned, werr := abandoned.Wait(waitCtx)
+			cancel()
+			if returned && werr == nil {
+				if retries > 0 && rep != nil {
+					rep.AddRetry(retries)
+				}
+				return retries, nil
+			}
+			if returned {
+				err = fmt.Errorf("%w: %w", err, werr)
+			} else if ctx.Err() == nil {
+				break
+			}
+		}
+		if errors.Is(err, sink.ErrFormat) || errors.Is(err, sink.ErrRejected) || errors.Is(err, sink.ErrDiskFull) {
+			break // retrying cannot change the outcome
+		}
+		if ctx.Err() != nil {
+			if retries > 0 && rep != nil {
+				rep.AddRetry(retries)
+			}
+			return retries, ctx.Err()
+		}
 
 		if attempt == maxRetries {
 			break
 		}
 
-		retries++
 		sleep := base << attempt
 		if sleep > max {
 			sleep = max
 		}
-		jitter := time.Duration(rand.Float64() * float64(sleep) * jitterPct)
-		
+		jitter := time.Duration(jitterFloat64() * float64(sleep) * jitterPct)
+		if !retryBudgetFrom(ctx).take(sleep + jitter) {
+			err = fmt.Errorf("%w: %w", errRetryBudget, err)
+			break
+		}
+		retries++
+
 		// Sleep with context cancellation support
-		select {
-		case <-ctx.Done():
-			return retries, ctx.Err()
-		case <-time.After(sleep + jitter):
+		if err := clockFrom(ctx).Sleep(ctx, sleep+jitter); err != nil {
+			return retries, err
 		}
 	}
 	if retries > 0 && rep != nil {
@@ -536,6 +1226,35 @@ func writeWithRetry(ctx context.Context, w sink.Writer, record any, cfg config.C
 	return retries, err
 }
 
+// writeOnce performs a single write attempt, bounded by timeout when it is
+// positive. An expired deadline is reported as sink.ErrWriteTimeout so the
+// retry loop treats it like any other retryable failure. Unbounded, the
+// write still carries ctx's values, such as the record's sink.Pending, and
+// goes on when ctx is canceled, unless by a stalled worker's abort.
+func writeOnce(ctx context.Context, w sink.Writer, record any, timeout time.Duration) error {
+	if timeout <= 0 {
+		wctx, cancel := context.WithCancelCause(context.WithoutCancel(ctx))
+		defer cancel(nil)
+		defer context.AfterFunc(ctx, func() {
+			if cause := context.Cause(ctx); errors.Is(cause, errWorkerStalled) {
+				cancel(cause)
+			}
+		})()
+		return sink.WriteContext(wctx, w, record)
+	}
+	wctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := sink.WriteContext(wctx, w, record)
+	if err != nil && ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+		var abandoned *sink.AbandonedWrite
+		if errors.As(err, &abandoned) {
+			return fmt.Errorf("%w after %v: %w", sink.ErrWriteTimeout, timeout, abandoned)
+		}
+		return fmt.Errorf("%w after %v", sink.ErrWriteTimeout, timeout)
+	}
+	return err
+}
+
 type lockedWriter struct {
 	mu sync.Mutex
 	w  sink.Writer
@@ -547,30 +1266,308 @@ func (l *lockedWriter) Write(record any) error {
 	return l.w.Write(record)
 }
 
+// WriteContext writes under the lock, honoring ctx. Plain sinks cannot be
+// interrupted, so their write runs in the background while holding the lock;
+// an abandoned write therefore never overlaps the next one.
+func (l *lockedWriter) WriteContext(ctx context.Context, record any) error {
+	if _, ok := l.w.(sink.ContextWriter); ok {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return sink.WriteContext(ctx, l.w, record)
+	}
+	return sink.WriteContext(ctx, plainWriter{l}, record)
+}
+
+// plainWriter hides lockedWriter's WriteContext, so sink.WriteContext runs
+// its Write, lock included, in the background.
+type plainWriter struct{ sink.Writer }
+
 func (l *lockedWriter) Close() error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	return l.w.Close()
 }
 
-func openDLQ(path string) (sink.Writer, error) {
+// logConfigWarnings logs each config.Warnings finding.
+func logConfigWarnings(cfg config.Config) {
+	for _, w := range config.Warnings(cfg) {
+		logger.Warn("configuration warning", "warning", w)
+	}
+}
+
+// openSink builds the configured sink, wrapped with batching, projection,
+// and aggregation when enabled.
+// Closing the returned writer flushes and closes the underlying sink.
+func openSink(ctx context.Context, cfg config.Config) (sink.Writer, error) {
+	var sinkWriter sink.Writer
+	var err error
+	if strings.EqualFold(cfg.OutputType, "router") {
+		sinkWriter, err = openRouter(ctx, cfg)
+	} else if sinkWriter, err = baseSink(ctx, cfg); err != nil {
+		return nil, fmt.Errorf("open sink: %w", err)
+	} else {
+		sinkWriter, err = wrapOutput(sinkWriter, cfg)
+	}
+	if err != nil {
+		if cfg.OutputAtomic {
+			sink.AbortAtomic(cfg.OutputPath)
+		}
+		return nil, err
+	}
+	fail := func(err error) (sink.Writer, error) {
+		sinkWriter.Close()
+		if cfg.OutputAtomic {
+			sink.AbortAtomic(cfg.OutputPath)
+		}
+		return nil, err
+	}
+	if cfg.AggregateWindowSeconds > 0 {
+		groupBy := cfg.AggregateGroupBy
+		if len(groupBy) == 0 {
+			groupBy = []string{"service", "level"}
+		}
+		aggSink, err := sink.NewAggregateSink(sinkWriter, sink.AggregateOptions{
+			Window:     time.Duration(cfg.AggregateWindowSeconds) * time.Second,
+			GroupBy:    groupBy,
+			ValueField: cfg.AggregateField,
+			Lateness:   time.Duration(cfg.AggregateLatenessSeconds) * time.Second,
+			Amend:      strings.EqualFold(cfg.AggregateLate, "amend"),
+			MaxBuckets: cfg.AggregateMaxBuckets,
+		})
+		if err != nil {
+			return fail(fmt.Errorf("create aggregate sink: %w", err))
+		}
+		sinkWriter = aggSink
+	}
+	return sinkWriter, nil
+}
+
+// wrapOutput adds the legacy schema, batching, and projection wrappers cfg
+// asks for to w. On error w is closed.
+func wrapOutput(w sink.Writer, cfg config.Config) (sink.Writer, error) {
+	jsonOutput := cfg.OutputFormat == "" || strings.EqualFold(cfg.OutputFormat, config.FormatJSON)
+	if cfg.LegacySchema() && jsonOutput {
+		w = sink.NewLegacySink(w)
+	}
+	// Text and Avro output only go to local writers, where batching saves
+	// nothing, and a flush would fail the batch on one unrenderable record.
+	if cfg.BatchSize > 1 && jsonOutput {
+		batchedSink, err := sink.NewBatchedSink(w, cfg.BatchSize, time.Duration(cfg.BatchFlushInterval)*time.Millisecond)
+		if err != nil {
+			w.Close()
+			return nil, fmt.Errorf("create batched sink: %w", err)
+		}
+		w = batchedSink
+	}
+	if len(cfg.OutputFields) > 0 {
+		w = sink.NewProjectSink(w, cfg.OutputFields)
+	}
+	return w, nil
+}
+
+// openRouter opens every route of output type router as its own sink, from
+// cfg with the route's output_type and output, and wraps each like a
+// single output. The router sits above them, so it sees records before
+// they are projected or batched, and an unroutable record fails its own
+// write.
+func openRouter(ctx context.Context, cfg config.Config) (sink.Writer, error) {
+	var routes []sink.Route
+	closeRoutes := func() {
+		for _, r := range routes {
+			r.Sink.Close()
+		}
+	}
+	for _, entry := range cfg.RouterRoutes {
+		r, err := config.ParseRouterRoute(entry)
+		if err != nil {
+			closeRoutes()
+			return nil, err
+		}
+		child := routeConfig(cfg, r.Name)
+		child.OutputType, child.OutputPath = r.OutputType, r.Output
+		w, err := sink.Build(ctx, child)
+		if err == nil {
+			w, err = wrapOutput(w, child)
+		}
+		if err != nil {
+			closeRoutes()
+			return nil, fmt.Errorf("open route %s: %w", r.Name, err)
+		}
+		routes = append(routes, sink.Route{Name: r.Name, Output: r.OutputType + ":" + r.Output, Sink: w})
+	}
+	rules := make([]config.RouterRule, 0, len(cfg.RouterRules)+len(cfg.RouteByLevel))
+	for _, entry := range cfg.RouterRules {
+		rule, err := config.ParseRouterRule(entry)
+		if err != nil {
+			closeRoutes()
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	for _, entry := range cfg.RouteByLevel {
+		rule, err := config.ParseRouteByLevel(entry)
+		if err != nil {
+			closeRoutes()
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	router, err := sink.NewRouterSink(routes, rules, cfg.RouterDefault)
+	if err != nil {
+		closeRoutes()
+		return nil, err
+	}
+	return router, nil
+}
+
+type baseSinkKey struct{}
+
+// withBaseSink makes runPipeline write to w in place of the sink cfg
+// describes. The batching, projection, and aggregation wrappers still apply.
+func withBaseSink(ctx context.Context, w sink.Writer) context.Context {
+	return context.WithValue(ctx, baseSinkKey{}, w)
+}
+
+// baseSink returns the sink attached with withBaseSink, or builds the one
+// cfg describes.
+func baseSink(ctx context.Context, cfg config.Config) (sink.Writer, error) {
+	if w, ok := ctx.Value(baseSinkKey{}).(sink.Writer); ok {
+		return w, nil
+	}
+	return sink.Build(ctx, cfg)
+}
+
+// finishOutput publishes atomic output, then writes the done marker and the
+// manifest, once the run has succeeded. A sink close error fails atomic
+// runs, since the temp file may be incomplete, and suppresses both files.
+func finishOutput(cfg config.Config, rep *report.Report, w sink.Writer, closeErr error) error {
+	if cfg.OutputAtomic {
+		if closeErr != nil {
+			if err := sink.AbortAtomic(cfg.OutputPath); err != nil {
+				logger.Error("error removing temp output", "error", err)
+			}
+			return fmt.Errorf("close sink: %w", closeErr)
+		}
+		if err := sink.CommitAtomic(cfg.OutputPath); err != nil {
+			return fmt.Errorf("commit output: %w", err)
+		}
+	}
+	if closeErr != nil {
+		return nil
+	}
+	perm := cfg.FilePerm()
+	if cfg.OutputDoneMarker {
+		if err := writeDoneMarker(perm, cfg.OutputPath+".done", rep); err != nil {
+			return fmt.Errorf("write done marker: %w", err)
+		}
+	}
+	// The manifest is always written last so its presence means every file
+	// it lists is complete.
+	if cfg.OutputManifest {
+		files, _ := sink.Files(w)
+		if err := writeManifest(perm, manifestPath(cfg.OutputPath), rep.RunID, files); err != nil {
+			return fmt.Errorf("write manifest: %w", err)
+		}
+	}
+	return nil
+}
+
+func manifestPath(output string) string {
+	return output + ".manifest.json"
+}
+
+// outputManifest is the content of <output>.manifest.json.
+type outputManifest struct {
+	RunID     string          `json:"run_id"`
+	CreatedAt string          `json:"created_at"`
+	Records   int             `json:"records"`
+	Files     []sink.FileInfo `json:"files"`
+}
+
+// writeManifest writes the manifest to a temp file and renames it into
+// place, so readers never see a partial manifest.
+func writeManifest(perm fsutil.Perm, path, runID string, files []sink.FileInfo) error {
+	m := outputManifest{RunID: runID, CreatedAt: time.Now().UTC().Format(time.RFC3339), Files: files}
+	if m.Files == nil {
+		m.Files = []sink.FileInfo{}
+	}
+	for _, f := range files {
+		m.Records += f.Records
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return perm.WriteFile(path, append(data, '\n'))
+}
+
+// doneMarker is the content of the <output>.done file.
+type doneMarker struct {
+	RunID           string  `json:"run_id"`
+	TotalLines      int     `json:"total_lines"`
+	WrittenOK       int     `json:"written_ok"`
+	WriteFailed     int     `json:"written_failed"`
+	DLQWritten      int     `json:"dlq_written"`
+	DurationSeconds float64 `json:"duration_seconds"`
+}
+
+func writeDoneMarker(perm fsutil.Perm, path string, rep *report.Report) error {
+	data, err := json.MarshalIndent(doneMarker{
+		RunID:           rep.RunID,
+		TotalLines:      rep.TotalLines,
+		WrittenOK:       rep.WrittenOK,
+		WriteFailed:     rep.WriteFailed,
+		DLQWritten:      rep.DLQWritten,
+		DurationSeconds: rep.DurationSeconds,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return perm.WriteFile(path, append(data, '\n'))
+}
+
+// openDLQ creates cfg's DLQ file, encoding entries as dlqEncoding says.
+func openDLQ(cfg config.Config) (sink.Writer, error) {
+	path := cfg.DLQPath
 	if strings.HasPrefix(path, "s3://") {
 		return nil, fmt.Errorf("DLQ s3 target not supported in this build: %s", path)
 	}
-	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+	perm := cfg.FilePerm()
+	if err := perm.MkdirAll(filepath.Dir(path)); err != nil {
 		return nil, err
 	}
-	f, err := os.Create(path)
+	f, err := perm.Create(path)
 	if err != nil {
 		return nil, err
 	}
-	return sink.NewJSONLSink(f), nil
+	return sink.NewEncodedJSONLSink(f, dlqEncoding(cfg)), nil
 }
 
-func inputReader(path string) (io.Reader, func(), error) {
+// dlqEncoding is the output encoding of cfg without its indent: replay
+// reads the DLQ one entry per line.
+func dlqEncoding(cfg config.Config) sink.JSONEncoding {
+	enc := sink.JSONEncodingOf(cfg)
+	enc.Indent = 0
+	return enc
+}
+
+// inputReader opens the input at path, or stdin for "" or "-". With
+// reopen, a named pipe is read with a fifoReader, which outlasts its
+// writers until ctx is done.
+func inputReader(ctx context.Context, path string, reopen bool) (io.Reader, func(), error) {
 	if path == "" || path == "-" {
 		return os.Stdin, nil, nil
 	}
+	if reopen {
+		if isFIFO(path) {
+			r, err := openFIFOReader(ctx, path)
+			if err != nil {
+				return nil, nil, err
+			}
+			return r, func() { r.Close() }, nil
+		}
+		logger.WarnContext(ctx, "input_reopen_on_eof is ignored: input is not a named pipe", "path", path)
+	}
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, nil, err
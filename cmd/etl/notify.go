@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s-log-etl/internal/logger"
+)
+
+// sdNotifier tells systemd, through the socket in NOTIFY_SOCKET, that the
+// run is ready, alive, and stopping, for a unit with Type=notify and
+// WatchdogSec. A nil *sdNotifier, the one without NOTIFY_SOCKET, ignores
+// every call, so the pipeline can notify unconditionally.
+type sdNotifier struct {
+	conn     net.Conn
+	watchdog time.Duration // WATCHDOG_USEC, 0 when systemd does not watch this process
+	ready    atomic.Bool
+	stopping atomic.Bool
+	mu       sync.Mutex // serializes writes
+}
+
+// newSDNotifier connects to NOTIFY_SOCKET, or returns nil when it is unset
+// or cannot be reached, which is logged.
+func newSDNotifier(ctx context.Context) *sdNotifier {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return nil
+	}
+	// An address starting with @ is in the abstract namespace, which net
+	// handles by the same convention.
+	conn, err := net.Dial("unixgram", addr)
+	if err != nil {
+		logger.WarnContext(ctx, "cannot reach NOTIFY_SOCKET, not notifying systemd", "socket", addr, "error", err)
+		return nil
+	}
+	n := &sdNotifier{conn: conn}
+	// WATCHDOG_PID names the process systemd watches; another process's
+	// watchdog, inherited through the environment, is not ours to feed.
+	if pid := os.Getenv("WATCHDOG_PID"); pid == "" || pid == strconv.Itoa(os.Getpid()) {
+		if us, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64); err == nil && us > 0 {
+			n.watchdog = time.Duration(us) * time.Microsecond
+		}
+	}
+	logger.InfoContext(ctx, "notifying systemd", "socket", addr, "watchdog", n.watchdog.String())
+	return n
+}
+
+// send writes one notification, logging a failure: systemd not hearing
+// from the run is no reason to stop it.
+func (n *sdNotifier) send(ctx context.Context, state string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, err := n.conn.Write([]byte(state)); err != nil {
+		logger.WarnContext(ctx, "systemd notification failed", "state", state, "error", err)
+	}
+}
+
+// RecordWritten sends READY=1 the first time a record reaches the sink,
+// which is open by then, unless the run is already stopping.
+func (n *sdNotifier) RecordWritten(ctx context.Context) {
+	if n == nil || n.ready.Load() || n.stopping.Load() || !n.ready.CompareAndSwap(false, true) {
+		return
+	}
+	n.send(ctx, "READY=1")
+}
+
+// Stopping sends STOPPING=1, once, as the run starts shutting down.
+func (n *sdNotifier) Stopping(ctx context.Context) {
+	if n == nil || !n.stopping.CompareAndSwap(false, true) {
+		return
+	}
+	n.send(ctx, "STOPPING=1")
+}
+
+// Close sends STOPPING=1 unless the run already did, and closes the socket.
+func (n *sdNotifier) Close(ctx context.Context) {
+	if n == nil {
+		return
+	}
+	n.Stopping(ctx)
+	n.conn.Close()
+}
+
+// Watch sends WATCHDOG=1 every half WATCHDOG_USEC until ctx is done, as
+// long as no sink worker has held one record for stallAfter, or for
+// WATCHDOG_USEC when stallAfter is 0. A stalled pipeline thus stops
+// feeding the watchdog and systemd restarts it. It returns at once when
+// systemd does not watch this process.
+func (n *sdNotifier) Watch(ctx context.Context, progress []workerProgress, stallAfter time.Duration) {
+	if n == nil || n.watchdog <= 0 {
+		return
+	}
+	if stallAfter <= 0 {
+		stallAfter = n.watchdog
+	}
+	c := clockFrom(ctx)
+	ticker := c.NewTicker(max(n.watchdog/2, time.Millisecond))
+	defer ticker.Stop()
+	withheld := false
+	for {
+		if id, held := longestHeld(progress, c.Now()); held < stallAfter {
+			if withheld {
+				logger.InfoContext(ctx, "pipeline making progress again, feeding the systemd watchdog")
+				withheld = false
+			}
+			n.send(ctx, "WATCHDOG=1")
+		} else if !withheld {
+			logger.ErrorContext(ctx, "sink worker stalled, withholding the systemd watchdog", "worker_id", id, "stuck_seconds", held.Seconds(), "watchdog", n.watchdog.String())
+			withheld = true
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+type notifierKey struct{}
+
+// withNotifier attaches n to ctx for runPipeline.
+func withNotifier(ctx context.Context, n *sdNotifier) context.Context {
+	return context.WithValue(ctx, notifierKey{}, n)
+}
+
+// notifierFrom returns the notifier attached to ctx, or nil.
+func notifierFrom(ctx context.Context) *sdNotifier {
+	n, _ := ctx.Value(notifierKey{}).(*sdNotifier)
+	return n
+}
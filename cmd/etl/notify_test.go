@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s-log-etl/internal/clock"
+	"k8s-log-etl/internal/report"
+)
+
+// listenNotifySocket stands in for systemd: it sets NOTIFY_SOCKET to a
+// unix datagram socket it returns, with no watchdog.
+func listenNotifySocket(t *testing.T) *net.UnixConn {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("unix datagram sockets are not supported")
+	}
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+	t.Setenv("WATCHDOG_USEC", "")
+	t.Setenv("WATCHDOG_PID", "")
+	return conn
+}
+
+// readNotification returns the next notification sent to conn within
+// wait, or "" when none is.
+func readNotification(t *testing.T, conn *net.UnixConn, wait time.Duration) string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(wait))
+	buf := make([]byte, 256)
+	n, err := conn.Read(buf)
+	if err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return ""
+		}
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func TestSDNotifierInertWithoutSocket(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	ctx := context.Background()
+	n := newSDNotifier(ctx)
+	if n != nil {
+		t.Fatalf("newSDNotifier = %+v without NOTIFY_SOCKET", n)
+	}
+	n.RecordWritten(ctx)
+	n.Stopping(ctx)
+	n.Watch(ctx, make([]workerProgress, 1), time.Second) // returns at once
+	n.Close(ctx)
+}
+
+func TestRunPipeline_NotifiesSystemd(t *testing.T) {
+	conn := listenNotifySocket(t)
+	// Another process's watchdog is not fed.
+	t.Setenv("WATCHDOG_USEC", "1000")
+	t.Setenv("WATCHDOG_PID", "1")
+	cfg := idleTestConfig(t, "", "")
+	cfg.OutputType = "file"
+	cfg.OutputPath = filepath.Join(t.TempDir(), "out.jsonl")
+
+	ctx := context.Background()
+	n := newSDNotifier(ctx)
+	if n == nil {
+		t.Fatal("newSDNotifier = nil with NOTIFY_SOCKET set")
+	}
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- runPipeline(withNotifier(ctx, n), pr, cfg, report.NewReport()) }()
+	io.WriteString(pw, idleTestLine)
+	if s := readNotification(t, conn, 5*time.Second); s != "READY=1" {
+		t.Fatalf("notification %q after the first record, want READY=1", s)
+	}
+	io.WriteString(pw, idleTestLine+idleTestLine)
+	pw.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+	n.Close(ctx)
+	if s := readNotification(t, conn, time.Second); s != "STOPPING=1" {
+		t.Errorf("notification %q at the end of the input, want STOPPING=1", s)
+	}
+	if s := readNotification(t, conn, 100*time.Millisecond); s != "" {
+		t.Errorf("unexpected notification %q", s)
+	}
+}
+
+func TestSDNotifierNotReadyBeforeARecordIsWritten(t *testing.T) {
+	conn := listenNotifySocket(t)
+	cfg := idleTestConfig(t, "", "")
+	cfg.OutputType = "file"
+	cfg.OutputPath = filepath.Join(t.TempDir(), "out.jsonl")
+	cfg.FilterLevels = []string{"DEBUG"}
+
+	ctx := context.Background()
+	n := newSDNotifier(ctx)
+	if err := runPipeline(withNotifier(ctx, n), strings.NewReader(idleTestLine), cfg, report.NewReport()); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+	n.Close(ctx)
+	if s := readNotification(t, conn, time.Second); s != "STOPPING=1" {
+		t.Errorf("first notification %q, want STOPPING=1 with every record filtered out", s)
+	}
+	if s := readNotification(t, conn, 100*time.Millisecond); s != "" {
+		t.Errorf("unexpected notification %q", s)
+	}
+}
+
+func TestSDNotifierWithholdsWatchdogWhileStalled(t *testing.T) {
+	conn := listenNotifySocket(t)
+	t.Setenv("WATCHDOG_USEC", "2000000")
+	fake := clock.NewFake(time.Unix(1_700_000_000, 0))
+
+	ctx, cancel := context.WithCancel(withClock(context.Background(), fake))
+	defer cancel()
+	n := newSDNotifier(ctx)
+	defer n.Close(ctx)
+	progress := newWorkerProgress(2, fake)
+	watching := make(chan struct{})
+	go func() {
+		defer close(watching)
+		n.Watch(ctx, progress, 0)
+	}()
+	defer func() { cancel(); <-watching }()
+	if s := readNotification(t, conn, 5*time.Second); s != "WATCHDOG=1" {
+		t.Fatalf("first notification %q, want WATCHDOG=1", s)
+	}
+	fake.BlockUntil(1)
+
+	// A record held for less than WATCHDOG_USEC still feeds the watchdog.
+	progress[1].begin(&workItem{line: 3})
+	fake.Advance(time.Second)
+	if s := readNotification(t, conn, 5*time.Second); s != "WATCHDOG=1" {
+		t.Fatalf("notification %q after 1s in flight, want WATCHDOG=1", s)
+	}
+	// Held for WATCHDOG_USEC, it is a stall: systemd hears nothing more.
+	fake.Advance(time.Second)
+	fake.Advance(time.Second)
+	if s := readNotification(t, conn, 200*time.Millisecond); s != "" {
+		t.Fatalf("notification %q while stalled, want none", s)
+	}
+	// Once the worker moves on the watchdog is fed again.
+	progress[1].done()
+	fake.Advance(time.Second)
+	if s := readNotification(t, conn, 5*time.Second); s != "WATCHDOG=1" {
+		t.Fatalf("notification %q after the stall, want WATCHDOG=1", s)
+	}
+}
+
+func TestSDNotifierWatchdogUsesStallWarnAfter(t *testing.T) {
+	conn := listenNotifySocket(t)
+	t.Setenv("WATCHDOG_USEC", "2000000")
+	fake := clock.NewFake(time.Unix(1_700_000_000, 0))
+
+	ctx, cancel := context.WithCancel(withClock(context.Background(), fake))
+	defer cancel()
+	n := newSDNotifier(ctx)
+	defer n.Close(ctx)
+	progress := newWorkerProgress(1, fake)
+	progress[0].begin(&workItem{line: 1})
+	watching := make(chan struct{})
+	go func() {
+		defer close(watching)
+		n.Watch(ctx, progress, 500*time.Millisecond)
+	}()
+	defer func() { cancel(); <-watching }()
+	if s := readNotification(t, conn, 5*time.Second); s != "WATCHDOG=1" {
+		t.Fatalf("first notification %q, want WATCHDOG=1", s)
+	}
+	fake.BlockUntil(1)
+	fake.Advance(time.Second)
+	if s := readNotification(t, conn, 200*time.Millisecond); s != "" {
+		t.Errorf("notification %q past stall_warn_after, want none", s)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/report"
+)
+
+// pacer spaces sink writes under replay_speed so a replayed archive
+// reaches the sink at its original rate, or a multiple of it: each record
+// waits for the gap between its timestamp and the latest one before it,
+// divided by the speed and capped at max_sleep, counted from when that
+// record was let through. Time spent writing counts toward the wait, so a
+// slow sink does not add to it. Validate allows a single worker only, so
+// records arrive in order; a record older than the latest does not wait.
+type pacer struct {
+	speed    float64
+	maxSleep time.Duration
+
+	mu        sync.Mutex
+	firstTS   time.Time // timestamps of the first and latest records paced
+	latestTS  time.Time
+	firstAt   time.Time // on the run's clock, when they were let through
+	latestAt  time.Time
+	slept     time.Duration
+	records   int64
+	unpaced   int64
+	capped    int64
+	lastWrite time.Time // on the run's clock, when any record was last let through
+}
+
+// newPacer returns the pacer for cfg, or nil when replay_speed is unset; a
+// nil pacer never waits.
+func newPacer(cfg config.Config) *pacer {
+	if cfg.ReplaySpeed <= 0 {
+		return nil
+	}
+	return &pacer{speed: cfg.ReplaySpeed, maxSleep: cfg.ReplayMaxSleepDuration()}
+}
+
+// wait blocks until the record with timestamp ts is due, ok false meaning
+// it has none and is due at once. It returns early when ctx is done, so a
+// shutdown is not held up by a long gap.
+func (p *pacer) wait(ctx context.Context, ts time.Time, ok bool) {
+	if p == nil {
+		return
+	}
+	c := clockFrom(ctx)
+	p.mu.Lock()
+	if !ok {
+		p.unpaced++
+		p.lastWrite = c.Now()
+		p.mu.Unlock()
+		return
+	}
+	var d time.Duration
+	if p.records > 0 && ts.After(p.latestTS) {
+		d = time.Duration(float64(ts.Sub(p.latestTS)) / p.speed)
+		if d > p.maxSleep {
+			d = p.maxSleep
+			p.capped++
+		}
+		d -= c.Now().Sub(p.latestAt)
+	}
+	p.mu.Unlock()
+
+	if d > 0 {
+		start := c.Now()
+		c.Sleep(ctx, d)
+		d = c.Now().Sub(start)
+	}
+
+	now := c.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if d > 0 {
+		p.slept += d
+	}
+	if p.records == 0 {
+		p.firstTS, p.latestTS, p.firstAt = ts, ts, now
+	} else if ts.After(p.latestTS) {
+		p.latestTS = ts
+	}
+	p.records++
+	p.latestAt, p.lastWrite = now, now
+}
+
+// stats returns the pacing so far.
+func (p *pacer) stats() report.ReplayPacingStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := report.ReplayPacingStats{
+		Speed:           p.speed,
+		MaxSleepSeconds: p.maxSleep.Seconds(),
+		Records:         p.records,
+		Unpaced:         p.unpaced,
+		SpanSeconds:     p.latestTS.Sub(p.firstTS).Seconds(),
+		SleptSeconds:    p.slept.Seconds(),
+		Capped:          p.capped,
+	}
+	if p.records > 0 {
+		s.DurationSeconds = p.lastWrite.Sub(p.firstAt).Seconds()
+	}
+	if s.DurationSeconds > 0 {
+		s.EffectiveSpeed = s.SpanSeconds / s.DurationSeconds
+	}
+	return s
+}
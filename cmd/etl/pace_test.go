@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s-log-etl/internal/clock"
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/report"
+	"k8s-log-etl/internal/sink"
+)
+
+func TestPacer(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	p := newPacer(config.Config{ReplaySpeed: 2, ReplayMaxSleep: "1m"})
+	ctx := withClock(context.Background(), fake)
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// wait runs one record's wait, advancing the clock by each step once
+	// the pacer sleeps, and checks it was let through.
+	wait := func(ts time.Time, ok bool, steps ...time.Duration) {
+		t.Helper()
+		done := make(chan struct{})
+		go func() {
+			p.wait(ctx, ts, ok)
+			close(done)
+		}()
+		for _, d := range steps {
+			fake.BlockUntil(1)
+			fake.Advance(d)
+		}
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("record at %s still waiting", ts)
+		}
+	}
+	wait(t0, true)
+	wait(t0.Add(10*time.Second), true, 5*time.Second) // half the gap
+	wait(t0.Add(10*time.Second), true)                // no gap
+	wait(t0.Add(5*time.Second), true)                 // older than the latest
+	fake.Advance(3 * time.Second)                     // a slow write
+	wait(t0.Add(20*time.Second), true, 2*time.Second) // counts toward the 5s
+	wait(t0.Add(3*time.Hour), true, time.Minute)      // capped
+	wait(time.Time{}, false)
+
+	want := report.ReplayPacingStats{Speed: 2, MaxSleepSeconds: 60, Records: 6, Unpaced: 1, SpanSeconds: 3 * 3600, DurationSeconds: 70, SleptSeconds: 67, Capped: 1}
+	want.EffectiveSpeed = want.SpanSeconds / want.DurationSeconds
+	if got := p.stats(); got != want {
+		t.Errorf("stats = %+v\nwant %+v", got, want)
+	}
+}
+
+func TestPacer_ShutdownEndsWait(t *testing.T) {
+	p := newPacer(config.Config{ReplaySpeed: 1})
+	t0 := time.Now()
+	p.wait(context.Background(), t0, true)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	p.wait(ctx, t0.Add(time.Hour), true)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("wait took %s after the context was cancelled", elapsed)
+	}
+	if s := p.stats(); s.Capped != 1 || s.MaxSleepSeconds != config.DefaultReplayMaxSleep.Seconds() {
+		t.Errorf("stats = %+v, want the hour capped at the default max sleep", s)
+	}
+}
+
+func TestRunPipeline_ReplaySpeed(t *testing.T) {
+	input := `{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"one","service":"api"}
+{"ts":"2024-01-01T12:00:00.2Z","level":"ERROR","msg":"two","service":"api"}
+{"ts":"2024-01-01T12:00:00.4Z","level":"ERROR","msg":"three","service":"api"}
+`
+	cfg := config.Default()
+	cfg.ReportPath = filepath.Join(t.TempDir(), "report.json")
+	cfg.ReplaySpeed = 2
+	if err := config.Validate(cfg); err == nil || !strings.Contains(err.Error(), "replay_speed needs max_workers 1") {
+		t.Fatalf("Validate with 4 workers = %v", err)
+	}
+	cfg.MaxWorkers = 1
+	cfg.BatchSize = 0
+	if err := config.Validate(cfg); err != nil {
+		t.Fatal(err)
+	}
+	mem := sink.NewMemorySink()
+	rep := report.NewReport()
+	start := time.Now()
+	if err := runPipeline(withBaseSink(context.Background(), mem), strings.NewReader(input), cfg, rep); err != nil {
+		t.Fatal(err)
+	}
+	// 0.4s of records at twice the speed take at least 0.2s.
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("run took %s, want the writes paced over 200ms", elapsed)
+	}
+	s := rep.ReplayPacing
+	if s == nil || s.Records != 3 || s.SpanSeconds != 0.4 || s.DurationSeconds < 0.2 || s.EffectiveSpeed > 2 || len(mem.Records()) != 3 {
+		t.Errorf("replay_pacing = %+v after writing %d", s, len(mem.Records()))
+	}
+}
+
+func TestValidate_ReplaySpeed(t *testing.T) {
+	cfg := config.Default()
+	cfg.MaxWorkers = 1
+	cfg.ReplaySpeed = 1
+	cfg.QueuePriorityByLevel = true
+	cfg.ReplayMaxSleep = "0s"
+	err := config.Validate(cfg)
+	for _, want := range []string{"queue_priority_by_level", "invalid replay_max_sleep"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate = %v, want it to mention %s", err, want)
+		}
+	}
+	cfg = config.Default()
+	cfg.ReplaySpeed = -1
+	if err := config.Validate(cfg); err == nil || !strings.Contains(err.Error(), "replay_speed cannot be negative") {
+		t.Errorf("Validate with a negative speed = %v", err)
+	}
+}
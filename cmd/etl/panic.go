@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+
+	"k8s-log-etl/internal/logger"
+	"k8s-log-etl/internal/report"
+	"k8s-log-etl/internal/sink"
+)
+
+// errPipelinePanic stops a run after the reader or a sink worker panicked.
+// The run still drains the queue, closes its sinks and writes its report
+// before failing, like a run stopped by a full disk.
+var errPipelinePanic = errors.New("pipeline panic")
+
+// pipelinePanic logs and records v, a panic recovered in where, and returns
+// the error that stops the run. Call it from the deferred function that
+// recovered v so the stack still shows where the panic happened; a panic
+// carried over from a sink write keeps the stack of the write.
+func pipelinePanic(ctx context.Context, rep *report.Report, where string, v any) error {
+	var stack string
+	if wp, ok := v.(*sink.WritePanic); ok {
+		v, stack = wp.Value, string(wp.Stack)
+	} else {
+		stack = string(debug.Stack())
+	}
+	logger.ErrorContext(ctx, "pipeline panic", "where", where, "panic", v, "stack", stack)
+	rep.SetPanic(report.PanicInfo{Where: where, Message: fmt.Sprint(v), Stack: stack})
+	return fmt.Errorf("%w in %s: %v", errPipelinePanic, where, v)
+}
+
+// closeSink closes w, returning a panic from it, e.g. from the last flush
+// of a batched sink, as an errPipelinePanic error.
+func closeSink(ctx context.Context, rep *report.Report, w sink.Writer) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = pipelinePanic(ctx, rep, "sink close", v)
+		}
+	}()
+	return w.Close()
+}
+
+// flushSink flushes what w buffers like sink.Flush, returning a panic
+// from it as an errPipelinePanic error.
+func flushSink(ctx context.Context, rep *report.Report, w sink.Writer) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = pipelinePanic(ctx, rep, "sink flush", v)
+		}
+	}()
+	return sink.Flush(ctx, w)
+}
@@ -1,12 +1,33 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"k8s-log-etl/internal/clock"
 	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/logger"
+	"k8s-log-etl/internal/model"
+	"k8s-log-etl/internal/plugins"
 	"k8s-log-etl/internal/report"
 	"k8s-log-etl/internal/sink"
 )
@@ -16,6 +37,7 @@ func TestRunPipeline_Basic(t *testing.T) {
 {"ts":"2024-01-01T12:00:01Z","level":"INFO","msg":"info message","service":"test-service"}
 `
 	cfg := config.Default()
+	cfg.ReportPath = filepath.Join(t.TempDir(), "report.json")
 	cfg.OutputType = "stdout"
 	cfg.FilterLevels = []string{"ERROR"}
 
@@ -39,6 +61,12 @@ func TestRunPipeline_Basic(t *testing.T) {
 	if rep.WrittenOK != 1 {
 		t.Errorf("expected 1 written (filtered 1), got %d", rep.WrittenOK)
 	}
+	if rep.Shutdown.Reason != report.StopEOF || rep.Shutdown.QueueRemaining != 0 || rep.Shutdown.LinesNotEnqueued != 0 {
+		t.Errorf("expected a clean eof shutdown, got %+v", rep.Shutdown)
+	}
+	if rep.RuntimeStats.Samples == 0 || rep.RuntimeStats.PeakHeapBytes == 0 {
+		t.Errorf("expected a final runtime sample, got %+v", rep.RuntimeStats)
+	}
 }
 
 func TestRunPipeline_ContextCancellation(t *testing.T) {
@@ -50,24 +78,99 @@ func TestRunPipeline_ContextCancellation(t *testing.T) {
 	}
 
 	cfg := config.Default()
+	cfg.ReportPath = filepath.Join(t.TempDir(), "report.json")
 	cfg.OutputType = "stdout"
 
 	rep := report.NewReport()
 	ctx, cancel := context.WithCancel(context.Background())
 
-	// Cancel after a short delay
-	go func() {
-		time.Sleep(50 * time.Millisecond)
-		cancel()
-	}()
+	// Cancel once part of the input has been consumed so the pipeline is
+	// guaranteed to be mid-stream.
+	in := &cancelAfterReader{r: strings.NewReader(input.String()), limit: 4096, cancel: cancel}
 
-	err := runPipeline(ctx, strings.NewReader(input.String()), cfg, rep)
+	err := runPipeline(ctx, in, cfg, rep)
 	if err == nil {
 		t.Error("expected error due to context cancellation")
 	}
 	if err != context.Canceled {
 		t.Logf("got error (may be timeout): %v", err)
 	}
+
+	stop := rep.Shutdown
+	if stop.Reason != report.StopSignal && stop.Reason != report.StopTimeout {
+		t.Errorf("expected signal or timeout stop reason, got %q", stop.Reason)
+	}
+	if len(stop.Workers) != cfg.MaxWorkers {
+		t.Fatalf("expected %d worker statuses, got %d", cfg.MaxWorkers, len(stop.Workers))
+	}
+	processed := 0
+	for i, w := range stop.Workers {
+		if w.ID != i {
+			t.Errorf("worker %d reported id %d", i, w.ID)
+		}
+		if stop.Reason == report.StopSignal && w.InFlight {
+			t.Errorf("worker %d still in flight after all workers finished: %+v", i, w)
+		}
+		processed += w.Processed
+	}
+	if processed != rep.WrittenOK+rep.WriteFailed {
+		t.Errorf("workers processed %d, but report has %d written and %d failed", processed, rep.WrittenOK, rep.WriteFailed)
+	}
+	if enqueued := processed + stop.QueueRemaining; enqueued > rep.NormalizedOK || enqueued+stop.LinesNotEnqueued > 1000 {
+		t.Errorf("inconsistent snapshot: %+v (processed %d, normalized %d)", stop, processed, rep.NormalizedOK)
+	}
+	if rep.TotalLines >= 1000 {
+		t.Errorf("expected the run to stop before the end of input, read %d lines", rep.TotalLines)
+	}
+}
+
+func TestRunPipeline_StageTimingsDisabled(t *testing.T) {
+	input := `{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"first","service":"api","extra":"only-here"}
+not json
+{"ts":"2024-01-01T12:00:01Z","level":"ERROR","msg":"second","service":"api"}
+{"ts":"2024-01-01T12:00:02Z","msg":"no level"}
+`
+	run := func(t *testing.T, timings bool) (*report.Report, string) {
+		dir := t.TempDir()
+		cfg := config.Default()
+		cfg.OutputType = "file"
+		cfg.OutputPath = filepath.Join(dir, "out.jsonl")
+		cfg.ReportPath = filepath.Join(dir, "report.json")
+		cfg.StageTimings = &timings
+		rep := report.NewReport()
+		if err := runPipeline(context.Background(), strings.NewReader(input), cfg, rep); err != nil {
+			t.Fatalf("runPipeline: %v", err)
+		}
+		out, err := os.ReadFile(cfg.OutputPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rep, string(out)
+	}
+
+	on, onOut := run(t, true)
+	off, offOut := run(t, false)
+	if off.StageTimings != (report.StageTimings{}) {
+		t.Errorf("expected no stage timings when disabled, got %+v", off.StageTimings)
+	}
+	if on.StageTimings.ParsingSeconds == 0 {
+		t.Error("expected parsing time when enabled")
+	}
+	counts := func(r *report.Report) [6]int {
+		return [6]int{r.TotalLines, r.JSONParsed, r.JSONFailed, r.NormalizedOK, r.NormalizedFailed, r.WrittenOK}
+	}
+	if counts(on) != counts(off) || counts(on) != [6]int{4, 3, 1, 2, 1, 2} {
+		t.Errorf("expected identical counts, got %v (on) and %v (off)", counts(on), counts(off))
+	}
+
+	// The parse map is reused between lines; fields must not leak forward.
+	if onOut != offOut {
+		t.Errorf("output differs:\n%s\nvs\n%s", onOut, offOut)
+	}
+	lines := strings.Split(strings.TrimSpace(onOut), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], "only-here") || strings.Contains(lines[1], "only-here") {
+		t.Errorf("unexpected output:\n%s", onOut)
+	}
 }
 
 func TestRunPipeline_WithBatching(t *testing.T) {
@@ -78,6 +181,7 @@ func TestRunPipeline_WithBatching(t *testing.T) {
 	}
 
 	cfg := config.Default()
+	cfg.ReportPath = filepath.Join(t.TempDir(), "report.json")
 	cfg.OutputType = "stdout"
 	cfg.BatchSize = 5
 	cfg.BatchFlushInterval = 100
@@ -95,29 +199,2095 @@ func TestRunPipeline_WithBatching(t *testing.T) {
 	}
 }
 
-func TestWriteWithRetry_ContextCancellation(t *testing.T) {
+func TestRunPipeline_StampRunMetadata(t *testing.T) {
+	input := `{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"test message","service":"test-service"}
+`
+	dir := t.TempDir()
 	cfg := config.Default()
+	cfg.OutputType = "file"
+	cfg.OutputPath = filepath.Join(dir, "out.jsonl")
+	cfg.ReportPath = filepath.Join(dir, "report.json")
+	cfg.StampRunMetadata = true
+
 	rep := report.NewReport()
+	rep.RunID = "run-123"
+	rep.Hostname = "host-a"
+	if err := runPipeline(context.Background(), strings.NewReader(input), cfg, rep); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
 
-	// Create a sink that always fails
-	failingSink := &failingWriter{}
+	data, err := os.ReadFile(cfg.OutputPath)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	var rec struct {
+		Fields map[string]any
+	}
+	if err := json.Unmarshal(data, &rec); err != nil {
+		t.Fatalf("unmarshal output: %v", err)
+	}
+	if rec.Fields["_etl_run_id"] != "run-123" || rec.Fields["_etl_host"] != "host-a" {
+		t.Errorf("expected run metadata in fields, got %v", rec.Fields)
+	}
+}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	cancel() // Cancel immediately
+func init() {
+	plugins.RegisterTransform(plugins.Info{Name: "test_drop_noise"}, func(config.Config) plugins.Transform {
+		return func(n model.Normalized) (model.Normalized, bool, string, error) {
+			if strings.Contains(n.Message, "noise") {
+				return n, true, "noise", nil
+			}
+			return n, false, "", nil
+		}
+	})
+	plugins.RegisterTransform(plugins.Info{Name: "test_fail_bad"}, func(config.Config) plugins.Transform {
+		return func(n model.Normalized) (model.Normalized, bool, string, error) {
+			if strings.Contains(n.Message, "bad") {
+				n.Message = "mutated"
+				return n, false, "", errors.New("enrichment unavailable")
+			}
+			return n, false, "", nil
+		}
+	})
+	plugins.RegisterTransform(plugins.Info{Name: "test_panic"}, func(config.Config) plugins.Transform {
+		return func(n model.Normalized) (model.Normalized, bool, string, error) {
+			if strings.Contains(n.Message, "boom") {
+				var m map[string]any
+				m["x"] = 1 // assignment to entry in nil map
+			}
+			return n, false, "", nil
+		}
+	})
+}
 
-	_, err := writeWithRetry(ctx, failingSink, "test", cfg, rep)
-	if err != context.Canceled {
-		t.Errorf("expected context.Canceled, got %v", err)
+func TestRunPipeline_TransformOnError(t *testing.T) {
+	input := `{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"bad record","service":"api"}
+{"ts":"2024-01-01T12:00:01Z","level":"ERROR","msg":"good record","service":"api"}
+`
+	run := func(t *testing.T, policy string) (*report.Report, config.Config, error) {
+		dir := t.TempDir()
+		cfg := config.Default()
+		cfg.OutputType = "file"
+		cfg.OutputPath = filepath.Join(dir, "out.jsonl")
+		cfg.ReportPath = filepath.Join(dir, "report.json")
+		cfg.DLQPath = filepath.Join(dir, "dlq.jsonl")
+		cfg.Transforms = []string{"test_fail_bad"}
+		if policy != "" {
+			cfg.TransformOnError = []string{"test_fail_bad=" + policy}
+		}
+		rep := report.NewReport()
+		err := runPipeline(context.Background(), strings.NewReader(input), cfg, rep)
+		return rep, cfg, err
 	}
+
+	t.Run("drop", func(t *testing.T) {
+		rep, _, err := run(t, "")
+		if err != nil {
+			t.Fatalf("runPipeline: %v", err)
+		}
+		if rep.WrittenOK != 1 || rep.NormalizedFailed != 1 || rep.Transforms[0].ErrorsByPolicy["drop"] != 1 {
+			t.Errorf("unexpected counts: written=%d failed=%d stats=%+v", rep.WrittenOK, rep.NormalizedFailed, rep.Transforms[0])
+		}
+	})
+
+	t.Run("pass", func(t *testing.T) {
+		rep, cfg, err := run(t, "pass")
+		if err != nil {
+			t.Fatalf("runPipeline: %v", err)
+		}
+		if rep.WrittenOK != 2 || rep.NormalizedFailed != 0 || rep.Transforms[0].ErrorsByPolicy["pass"] != 1 {
+			t.Errorf("unexpected counts: written=%d failed=%d stats=%+v", rep.WrittenOK, rep.NormalizedFailed, rep.Transforms[0])
+		}
+		out, _ := os.ReadFile(cfg.OutputPath)
+		if !strings.Contains(string(out), "bad record") || strings.Contains(string(out), "mutated") {
+			t.Errorf("expected the pre-transform record in output, got %s", out)
+		}
+	})
+
+	t.Run("dlq", func(t *testing.T) {
+		rep, cfg, err := run(t, "dlq")
+		if err != nil {
+			t.Fatalf("runPipeline: %v", err)
+		}
+		if rep.WrittenOK != 1 || rep.DLQReasons["transform_error:test_fail_bad"] != 1 {
+			t.Errorf("unexpected counts: written=%d dlq=%v", rep.WrittenOK, rep.DLQReasons)
+		}
+		data, err := os.ReadFile(cfg.DLQPath)
+		if err != nil {
+			t.Fatalf("read dlq: %v", err)
+		}
+		var rec dlqRecord
+		if err := json.Unmarshal(data, &rec); err != nil {
+			t.Fatalf("unmarshal dlq: %v", err)
+		}
+		if rec.Reason != "transform_error:test_fail_bad" || rec.Error != "enrichment unavailable" || rec.Record.Message != "bad record" {
+			t.Errorf("unexpected dlq record: %+v", rec)
+		}
+	})
+
+	t.Run("abort", func(t *testing.T) {
+		rep, cfg, err := run(t, "abort")
+		if err == nil || !strings.Contains(err.Error(), "on_error=abort") {
+			t.Fatalf("expected abort error, got %v", err)
+		}
+		if rep.WrittenOK != 0 || rep.Transforms[0].ErrorsByPolicy["abort"] != 1 {
+			t.Errorf("unexpected counts: written=%d stats=%+v", rep.WrittenOK, rep.Transforms[0])
+		}
+		if _, err := os.Stat(cfg.ReportPath); err != nil {
+			t.Errorf("expected report to be written on abort: %v", err)
+		}
+	})
 }
 
-type failingWriter struct{}
+func TestRunPipeline_TransformAudit(t *testing.T) {
+	input := `{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"bad record","service":"api"}
+{"ts":"2024-01-01T12:00:01Z","level":"ERROR","msg":"good record","service":"api"}
+{"ts":"2024-01-01T12:00:02Z","level":"ERROR","msg":"noise record","service":"api"}
+`
+	run := func(t *testing.T, audit bool) (*report.Report, []map[string]any) {
+		cfg := config.Default()
+		cfg.ReportPath = filepath.Join(t.TempDir(), "report.json")
+		cfg.Transforms = []string{"test_fail_bad", "test_drop_noise"}
+		cfg.TransformOnError = []string{"test_fail_bad=pass"}
+		cfg.TransformAudit = audit
+		cfg.MaxWorkers = 1
+		mem := sink.NewMemorySink()
+		rep := report.NewReport()
+		if err := runPipeline(withBaseSink(context.Background(), mem), strings.NewReader(input), cfg, rep); err != nil {
+			t.Fatal(err)
+		}
+		var fields []map[string]any
+		for _, r := range mem.Records() {
+			var rec struct {
+				Fields map[string]any `json:"fields"`
+			}
+			if err := json.Unmarshal(r, &rec); err != nil {
+				t.Fatal(err)
+			}
+			fields = append(fields, rec.Fields)
+		}
+		return rep, fields
+	}
 
-func (fw *failingWriter) Write(interface{}) error {
-	return sink.ErrWriteSink
+	rep, fields := run(t, true)
+	if len(fields) != 2 {
+		t.Fatalf("%d records written, want 2", len(fields))
+	}
+	want := [][]any{
+		{"test_fail_bad:error", "test_drop_noise:keep"},
+		{"test_fail_bad:keep", "test_drop_noise:keep"},
+	}
+	for i, f := range fields {
+		if got := f["_etl_transforms"]; !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("record %d _etl_transforms = %v, want %v", i, got, want[i])
+		}
+	}
+	a := rep.TransformAudit
+	if a == nil || a.FullyEvaluated != 1 || a.PassedErrors != 1 || a.ShortCircuited != 1 || a.ShortCircuitedBy["test_drop_noise"] != 1 {
+		t.Errorf("transform audit = %+v", a)
+	}
+
+	rep, fields = run(t, false)
+	if rep.TransformAudit != nil {
+		t.Errorf("transform audit = %+v with transform_audit off", rep.TransformAudit)
+	}
+	for _, f := range fields {
+		if _, ok := f["_etl_transforms"]; ok {
+			t.Errorf("record stamped with transform_audit off: %v", f)
+		}
+	}
 }
 
-func (fw *failingWriter) Close() error {
-	return nil
+func TestRunPipeline_RedactionAudit(t *testing.T) {
+	secrets := []string{"alice@example.com", "Bearer abc.def.ghi", "+1-212-555-0199"}
+	input := fmt.Sprintf(`{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"login","service":"auth","user_email":%q,"token":%q,"phone":%q}
+{"ts":"2024-01-01T12:00:01Z","level":"ERROR","msg":"logout","service":"auth","token":%q}
+{"ts":"2024-01-01T12:00:02Z","level":"ERROR","msg":"clean","service":"auth"}
+`, secrets[0], secrets[1], secrets[2], secrets[1])
+	cfg := config.Default()
+	cfg.ReportPath = filepath.Join(t.TempDir(), "report.json")
+	cfg.RedactKeys = []string{"user_email", "token", "phone"}
+	cfg.RedactionAudit = true
+	cfg.MaxWorkers = 1
+	mem := sink.NewMemorySink()
+	rep := report.NewReport()
+	if err := runPipeline(withBaseSink(context.Background(), mem), strings.NewReader(input), cfg, rep); err != nil {
+		t.Fatal(err)
+	}
+	records := mem.Records()
+	if len(records) != 3 {
+		t.Fatalf("%d records written, want 3", len(records))
+	}
+	want := [][]any{{"phone", "token", "user_email"}, {"token"}, nil}
+	for i, r := range records {
+		// Names only: no value redacted from any record appears anywhere in
+		// the output, the audit field included.
+		for _, secret := range secrets {
+			if strings.Contains(string(r), secret) {
+				t.Errorf("record %d leaks %q: %s", i, secret, r)
+			}
+		}
+		var rec struct {
+			Fields map[string]any `json:"fields"`
+		}
+		if err := json.Unmarshal(r, &rec); err != nil {
+			t.Fatal(err)
+		}
+		if got, ok := rec.Fields["_redacted_keys"]; ok != (want[i] != nil) || ok && !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("record %d _redacted_keys = %v, want %v", i, got, want[i])
+		}
+	}
+	if got := rep.RedactionsByKey; !reflect.DeepEqual(got, map[string]int{"phone": 1, "token": 2, "user_email": 1}) {
+		t.Errorf("redactions_by_key = %v", got)
+	}
+	if prom := rep.Prometheus(); !strings.Contains(prom, `etl_redactions_total{key="token"} 2`) {
+		t.Errorf("Prometheus output lacks the token redactions:\n%s", prom)
+	}
+}
+
+func TestRunPipeline_RedactsPromotedErrorDetails(t *testing.T) {
+	input := `{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"login failed","service":"auth","error":"password=hunter2 rejected","stack":"at login(token=s3cr3t)","caller":"auth.go:42"}
+`
+	cfg := config.Default()
+	cfg.ReportPath = filepath.Join(t.TempDir(), "report.json")
+	cfg.RedactKeys = []string{"error", "stack"}
+	mem := sink.NewMemorySink()
+	rep := report.NewReport()
+	if err := runPipeline(withBaseSink(context.Background(), mem), strings.NewReader(input), cfg, rep); err != nil {
+		t.Fatal(err)
+	}
+	records := mem.Records()
+	if len(records) != 1 {
+		t.Fatalf("%d records written, want 1", len(records))
+	}
+	for _, secret := range []string{"hunter2", "s3cr3t"} {
+		if strings.Contains(string(records[0]), secret) {
+			t.Errorf("record leaks %q: %s", secret, records[0])
+		}
+	}
+	// A detail key not listed is still promoted.
+	if !strings.Contains(string(records[0]), `"auth.go:42"`) {
+		t.Errorf("caller not promoted: %s", records[0])
+	}
+	if got := rep.RedactionsByKey; !reflect.DeepEqual(got, map[string]int{"error": 1, "stack": 1}) {
+		t.Errorf("redactions_by_key = %v", got)
+	}
+}
+
+func TestRunPipeline_NamespaceQuota(t *testing.T) {
+	var input strings.Builder
+	for i := range 5 {
+		fmt.Fprintf(&input, `{"ts":"2024-01-01T12:00:0%dZ","level":"ERROR","msg":"m","service":"api","namespace":"payments"}`+"\n", i)
+	}
+	cfg := config.Default()
+	cfg.ReportPath = filepath.Join(t.TempDir(), "report.json")
+	cfg.Transforms = []string{"namespace_quota"}
+	cfg.NamespaceQuota = []string{"payments=2"}
+	if err := config.Validate(cfg); err != nil {
+		t.Fatal(err)
+	}
+	mem := sink.NewMemorySink()
+	rep := report.NewReport()
+	if err := runPipeline(withBaseSink(context.Background(), mem), strings.NewReader(input.String()), cfg, rep); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(mem.Records()); n != 2 || rep.Filtered.ByReason[plugins.ReasonQuota] != 3 {
+		t.Errorf("written %d, filtered %v; want 2 written and 3 over quota", n, rep.Filtered.ByReason)
+	}
+	if q := rep.Quotas; q == nil || len(q.Namespaces) != 1 || q.Namespaces[0].Dropped != 3 || q.Namespaces[0].FirstCappedAt == "" {
+		t.Errorf("quotas = %+v", q)
+	}
+}
+
+func TestRunPipeline_TimestampShift(t *testing.T) {
+	// The INFO record is dropped by filter_redact before the shift, which
+	// is added after it; the sort window orders the shifted times.
+	input := `{"ts":"2024-01-01T12:00:02Z","level":"ERROR","msg":"second","service":"api"}
+{"ts":"2024-01-01T12:00:01Z","level":"INFO","msg":"filtered","service":"api"}
+{"ts":"2024-01-01T12:00:00.5Z","level":"WARN","msg":"first","service":"api"}
+`
+	cfg := config.Default()
+	cfg.ReportPath = filepath.Join(t.TempDir(), "report.json")
+	cfg.TimestampShift = "+72h"
+	cfg.SortWindow = "1m"
+	if err := config.Validate(cfg); err != nil {
+		t.Fatal(err)
+	}
+	mem := sink.NewMemorySink()
+	rep := report.NewReport()
+	if err := runPipeline(withBaseSink(context.Background(), mem), strings.NewReader(input), cfg, rep); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, rec := range mem.Records() {
+		var n model.Normalized
+		if err := json.Unmarshal(rec, &n); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%s %s %v", n.Message, n.TS, n.Fields[plugins.FieldTimestampShifted]))
+	}
+	want := []string{"first 2024-01-04T12:00:00.5Z true", "second 2024-01-04T12:00:02Z true"}
+	if !slices.Equal(got, want) {
+		t.Errorf("written %q, want %q", got, want)
+	}
+	if s := rep.TimestampShift; s == nil || s.Mode != "fixed" || s.ShiftSeconds != 72*3600 || s.Shifted != 2 || s.Skipped != 0 {
+		t.Errorf("timestamp_shift = %+v, want 2 records shifted by 72h", s)
+	}
+}
+
+func TestResolveTimestampShift(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.jsonl"), filepath.Join(dir, "b.jsonl")
+	os.WriteFile(a, []byte(jsonlAt(1, 7)+"not json\n"+`{"msg":"no time"}`+"\n"), 0o644)
+	os.WriteFile(b, []byte(`[{"log":"{\"ts\":\"2024-01-01T12:00:09Z\"}"},{"ts":"2024-01-01T12:00:03Z"}]`), 0o644)
+
+	cfg := config.Default()
+	cfg.Inputs = []string{filepath.Join(dir, "*.jsonl")}
+	cfg.TimestampShift = config.TimestampShiftRebaseNow
+	got, err := resolveTimestampShift(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2024, 1, 1, 12, 0, 7, 0, time.UTC); !got.TimestampShiftLatest.Equal(want) {
+		t.Errorf("latest = %s, want %s", got.TimestampShiftLatest, want)
+	}
+	// The run unwraps b's first record, so its timestamp counts.
+	cfg.UnwrapKeys = []string{"log"}
+	if got, err = resolveTimestampShift(cfg); err != nil || got.TimestampShiftLatest.Second() != 9 {
+		t.Errorf("latest with unwrap_keys = %s, %v; want 12:00:09", got.TimestampShiftLatest, err)
+	}
+
+	cfg.Inputs = []string{dir}
+	if _, err := resolveTimestampShift(cfg); err == nil || !strings.Contains(err.Error(), "not a regular file") {
+		t.Errorf("directory input: %v", err)
+	}
+	cfg.TimestampShift = "+1h"
+	if got, err := resolveTimestampShift(cfg); err != nil || !got.TimestampShiftLatest.IsZero() {
+		t.Errorf("fixed shift read the input: %v", err)
+	}
+}
+
+func TestRunPipeline_TransformPanic(t *testing.T) {
+	input := `{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"boom one","service":"api"}
+{"ts":"2024-01-01T12:00:01Z","level":"ERROR","msg":"fine","service":"api"}
+{"ts":"2024-01-01T12:00:02Z","level":"ERROR","msg":"boom two","service":"api"}
+`
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.OutputType = "file"
+	cfg.OutputPath = filepath.Join(dir, "out.jsonl")
+	cfg.ReportPath = filepath.Join(dir, "report.json")
+	cfg.DLQPath = filepath.Join(dir, "dlq.jsonl")
+	cfg.Transforms = []string{"test_panic"}
+	cfg.TransformOnError = []string{"test_panic=dlq"}
+	rep := report.NewReport()
+	if err := runPipeline(context.Background(), strings.NewReader(input), cfg, rep); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+
+	ts := rep.Transforms[0]
+	if rep.WrittenOK != 1 || ts.Errors != 2 || ts.ErrorsByKind["panic"] != 2 || ts.ErrorsByPolicy["dlq"] != 2 {
+		t.Errorf("unexpected counts: written=%d stats=%+v", rep.WrittenOK, ts)
+	}
+	if rep.DLQReasons["transform_error:test_panic"] != 2 {
+		t.Errorf("dlq reasons = %v, want 2 transform_error:test_panic", rep.DLQReasons)
+	}
+	if rep.Panic != nil || rep.Shutdown.Reason != report.StopEOF {
+		t.Errorf("a transform panic should not stop the run: panic=%+v reason=%q", rep.Panic, rep.Shutdown.Reason)
+	}
+	data, err := os.ReadFile(cfg.DLQPath)
+	if err != nil {
+		t.Fatalf("read dlq: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("dlq has %d entries, want 2:\n%s", len(lines), data)
+	}
+	var rec dlqRecord
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatalf("unmarshal dlq: %v", err)
+	}
+	if rec.Record.Message != "boom one" || !strings.Contains(rec.Error, "transform panic") || !strings.Contains(rec.Error, "nil map") {
+		t.Errorf("unexpected dlq record: %+v", rec)
+	}
+}
+
+// panickingWriter panics on every write, standing in for a sink bug.
+type panickingWriter struct{}
+
+func (panickingWriter) Write(any) error { panic("sink exploded") }
+
+func (panickingWriter) Close() error { return nil }
+
+func TestRunPipeline_WorkerPanicStillWritesReport(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(&input, `{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"m%d","service":"api"}`+"\n", i)
+	}
+	cfg := config.Default()
+	cfg.ReportPath = filepath.Join(t.TempDir(), "report.json")
+	cfg.MaxWorkers = 2
+	cfg.BatchSize = 1 // flush, and so panic, in the worker
+	rep := report.NewReport()
+	err := runPipeline(withBaseSink(context.Background(), panickingWriter{}), strings.NewReader(input.String()), cfg, rep)
+	if !errors.Is(err, errPipelinePanic) {
+		t.Fatalf("runPipeline error = %v, want errPipelinePanic", err)
+	}
+
+	data, err := os.ReadFile(cfg.ReportPath)
+	if err != nil {
+		t.Fatalf("the report should be written after a panic: %v", err)
+	}
+	var got report.Report
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("unmarshal report: %v", err)
+	}
+	if got.Panic == nil || got.Panic.Message != "sink exploded" || !strings.HasPrefix(got.Panic.Where, "worker ") || !strings.Contains(got.Panic.Stack, "panickingWriter") {
+		t.Errorf("unexpected panic in report: %+v", got.Panic)
+	}
+	if got.Shutdown.Reason != report.StopPanic || got.WrittenOK != 0 {
+		t.Errorf("reason=%q written=%d, want panic and 0", got.Shutdown.Reason, got.WrittenOK)
+	}
+
+	// With the default batch size the panic comes from the final flush, when
+	// the sink is closed; the run still fails.
+	cfg.BatchSize = config.Default().BatchSize
+	err = runPipeline(withBaseSink(context.Background(), panickingWriter{}), strings.NewReader(input.String()), cfg, report.NewReport())
+	if !errors.Is(err, errPipelinePanic) {
+		t.Errorf("runPipeline error = %v after a panic closing the sink, want errPipelinePanic", err)
+	}
+}
+
+func TestRunPipeline_FileModes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows file modes only carry the read-only attribute")
+	}
+	var input strings.Builder
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(&input, `{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"record %d","service":"api"}`+"\n", i)
+	}
+	input.WriteString(`{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"bad record","service":"api"}` + "\n")
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.OutputType = "rotate"
+	cfg.OutputPath = filepath.Join(dir, "out", "app.jsonl")
+	cfg.OutputMaxB = 300
+	cfg.OutputMaxFiles = 10
+	cfg.DLQPath = filepath.Join(dir, "dlq", "dlq.jsonl")
+	cfg.ReportPath = filepath.Join(dir, "report.json")
+	cfg.Transforms = []string{"test_fail_bad"}
+	cfg.TransformOnError = []string{"test_fail_bad=dlq"}
+	// Group write survives only if the mode is set whatever the umask.
+	cfg.OutputFileMode = "0660"
+	cfg.OutputDirMode = "0o770"
+	if err := config.Validate(cfg); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if err := runPipeline(context.Background(), strings.NewReader(input.String()), cfg, report.NewReport()); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+
+	rotated, _ := filepath.Glob(cfg.OutputPath + ".*")
+	if len(rotated) == 0 {
+		t.Fatal("expected the output to rotate")
+	}
+	files := append([]string{cfg.OutputPath, cfg.DLQPath, cfg.ReportPath}, rotated...)
+	for _, path := range files {
+		if got := fileMode(t, path); got != 0o660 {
+			t.Errorf("%s: mode %o, want 660", path, got)
+		}
+	}
+	for _, d := range []string{filepath.Dir(cfg.OutputPath), filepath.Dir(cfg.DLQPath)} {
+		if got := fileMode(t, d); got != 0o770 {
+			t.Errorf("%s: mode %o, want 770", d, got)
+		}
+	}
+
+	for _, tc := range []struct{ file, dir, owner string }{
+		{file: "rw-------"},
+		{file: "0800"},
+		{file: "01777"},
+		{dir: "0"},
+		{owner: "root"},
+		{owner: "1000:-1"},
+	} {
+		bad := cfg
+		bad.OutputFileMode, bad.OutputDirMode, bad.OutputOwner = tc.file, tc.dir, tc.owner
+		if err := config.Validate(bad); err == nil {
+			t.Errorf("Validate accepted %+v", tc)
+		}
+	}
+}
+
+func fileMode(t *testing.T, path string) os.FileMode {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Mode().Perm()
+}
+
+func TestRunPipeline_OutputBaseDir(t *testing.T) {
+	input := `{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"climb","service":"../../etc","namespace":"/etc"}` + "\n"
+	base, outside := t.TempDir(), t.TempDir()
+	cfg := config.Default()
+	cfg.OutputType = "file"
+	cfg.OutputPath = filepath.Join(base, "out", "{namespace}", "{service}.jsonl")
+	cfg.ReportPath = filepath.Join(base, "report.json")
+	cfg.OutputBaseDir = base
+	if err := config.Validate(cfg); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if err := runPipeline(context.Background(), strings.NewReader(input), cfg, report.NewReport()); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(base, "out", "_etc", "____etc.jsonl")); err != nil {
+		t.Errorf("placeholder values must stay one path element under the base: %v", err)
+	}
+
+	bad := cfg
+	bad.OutputPath = filepath.Join(base, "..", "out.jsonl")
+	bad.DLQPath = filepath.Join(outside, "dlq.jsonl")
+	bad.ReportPath = "report.json"
+	err := config.Validate(bad)
+	for _, want := range []string{"output: path outside the base directory", "dlq: path outside", "report: path outside"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate = %v, want it to mention %s", err, want)
+		}
+	}
+
+	// A link planted after validation is refused when the file is opened.
+	link := cfg
+	link.OutputPath = filepath.Join(base, "out.jsonl")
+	if err := config.Validate(link); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "stolen"), link.OutputPath); err != nil {
+		t.Skipf("symlinks unavailable: %v", err)
+	}
+	if err := runPipeline(context.Background(), strings.NewReader(input), link, report.NewReport()); err == nil || !strings.Contains(err.Error(), "symbolic link") {
+		t.Errorf("runPipeline through a link = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "stolen")); !os.IsNotExist(err) {
+		t.Errorf("output written outside the base: %v", err)
+	}
+}
+
+func TestRunPipeline_TransformStats(t *testing.T) {
+	input := `{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"real failure","service":"api"}
+{"ts":"2024-01-01T12:00:01Z","level":"INFO","msg":"info message","service":"api"}
+{"ts":"2024-01-01T12:00:02Z","level":"ERROR","msg":"noise again","service":"api"}
+`
+	cfg := config.Default()
+	cfg.OutputType = "stdout"
+	cfg.ReportPath = filepath.Join(t.TempDir(), "report.json")
+	cfg.FilterLevels = []string{"ERROR"}
+	cfg.Transforms = []string{"filter_redact", "test_drop_noise"}
+
+	rep := report.NewReport()
+	if err := runPipeline(context.Background(), strings.NewReader(input), cfg, rep); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+
+	if len(rep.Transforms) != 2 {
+		t.Fatalf("expected 2 transform entries, got %+v", rep.Transforms)
+	}
+	first, second := rep.Transforms[0], rep.Transforms[1]
+	if first.Name != "filter_redact" || first.RecordsIn != 3 || first.Dropped != 1 || first.DroppedByReason["level"] != 1 {
+		t.Errorf("unexpected filter_redact stats: %+v", first)
+	}
+	if second.Name != "test_drop_noise" || second.RecordsIn != 2 || second.Dropped != 1 || second.DroppedByReason["noise"] != 1 {
+		t.Errorf("unexpected test_drop_noise stats: %+v", second)
+	}
+	if rep.Filtered.Level != 1 || rep.Filtered.Other != 1 || rep.Filtered.ByReason["level"] != 1 || rep.Filtered.ByReason["noise"] != 1 {
+		t.Errorf("unexpected filter stats: %+v", rep.Filtered)
+	}
+	if !strings.Contains(rep.Prometheus(), `etl_filtered_total{reason="noise"} 1`) {
+		t.Errorf("missing filter reason prometheus line:\n%s", rep.Prometheus())
+	}
+	if !strings.Contains(rep.Prometheus(), `etl_transform_dropped_total{transform="test_drop_noise"} 1`) {
+		t.Errorf("missing per-transform prometheus line:\n%s", rep.Prometheus())
+	}
+}
+
+func TestRunPipeline_NormalizeFailuresByReason(t *testing.T) {
+	input := `{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"ok","service":"api"}
+{"level":"ERROR","msg":"no timestamp"}
+{"ts":"not-a-time","level":"ERROR","msg":"bad timestamp"}
+{"ts":"2024-01-01T12:00:01Z","level":"ERROR"}
+{"ts":"2024-01-01T12:00:02Z","msg":"no level"}
+{"ts":"2024-01-01T12:00:03Z","msg":"no level either"}
+`
+	cfg := config.Default()
+	cfg.OutputType = "stdout"
+	cfg.ReportPath = filepath.Join(t.TempDir(), "report.json")
+
+	rep := report.NewReport()
+	if err := runPipeline(context.Background(), strings.NewReader(input), cfg, rep); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+
+	if rep.NormalizedFailed != 5 {
+		t.Fatalf("expected 5 normalize failures, got %d", rep.NormalizedFailed)
+	}
+	want := map[string]int{"missing_ts": 1, "invalid_ts": 1, "missing_message": 1, "missing_level": 2}
+	for code, count := range want {
+		if rep.NormalizeFailuresByReason[code] != count {
+			t.Errorf("expected %d %s failures, got %+v", count, code, rep.NormalizeFailuresByReason)
+		}
+	}
+	if !strings.Contains(rep.Prometheus(), `etl_normalize_failures_total{reason="missing_level"} 2`) {
+		t.Errorf("missing normalize failure prometheus line:\n%s", rep.Prometheus())
+	}
+}
+
+func TestRunPipeline_DLQNormalizeFailures(t *testing.T) {
+	input := `{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"ok","service":"api"}
+{"ts":"01/01/2024 12:00:01","level":"ERROR","msg":"new format","service":"api"}
+`
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.OutputType = "stdout"
+	cfg.ReportPath = filepath.Join(dir, "report.json")
+	cfg.DLQPath = filepath.Join(dir, "dlq.jsonl")
+	cfg.DLQNormalizeFailures = true
+
+	rep := report.NewReport()
+	rep.RunID = "run-1"
+	if err := runPipeline(context.Background(), strings.NewReader(input), cfg, rep); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+	if rep.DLQWritten != 1 || rep.DLQReasons["normalize:invalid_ts"] != 1 {
+		t.Fatalf("expected one normalize:invalid_ts DLQ entry, got written=%d reasons=%v", rep.DLQWritten, rep.DLQReasons)
+	}
+
+	data, err := os.ReadFile(cfg.DLQPath)
+	if err != nil {
+		t.Fatalf("read dlq: %v", err)
+	}
+	var rec dlqRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		t.Fatalf("unmarshal dlq: %v", err)
+	}
+	if rec.Record != nil || rec.Raw["msg"] != "new format" || rec.Line != 2 || rec.RunID != "run-1" {
+		t.Errorf("unexpected dlq record: %+v", rec)
+	}
+	if rec.Reason != "normalize:invalid_ts" || !strings.Contains(rec.Error, `invalid timestamp "01/01/2024 12:00:01"`) {
+		t.Errorf("unexpected dlq reason/error: %q / %q", rec.Reason, rec.Error)
+	}
+}
+
+func TestReplayDLQ_RenormalizesRawEntries(t *testing.T) {
+	// The first entry has been fixed upstream; the second still fails.
+	dlq := `{"raw":{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"fixed","service":"api","token":"secret"},"line":2,"reason":"normalize:invalid_ts","run_id":"r1"}
+{"raw":{"level":"ERROR","msg":"still broken"},"line":3,"reason":"normalize:missing_ts","run_id":"r1"}
+{"raw":{"ts":"2024-01-01T12:00:01Z","level":"DEBUG","msg":"filtered out"},"line":4,"reason":"normalize:invalid_ts","run_id":"r1"}
+`
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.OutputType = "file"
+	cfg.OutputPath = filepath.Join(dir, "out.jsonl")
+	cfg.DLQPath = filepath.Join(dir, "dlq-again.jsonl")
+	cfg.RedactKeys = []string{"token"}
+
+	rep := report.NewReport()
+	if err := replayDLQ(context.Background(), strings.NewReader(dlq), cfg, rep); err != nil {
+		t.Fatalf("replayDLQ: %v", err)
+	}
+	if rep.WrittenOK != 1 || rep.NormalizedFailed != 1 || rep.Filtered.Level != 1 {
+		t.Errorf("unexpected counts: written=%d normalize_failed=%d filtered=%+v", rep.WrittenOK, rep.NormalizedFailed, rep.Filtered)
+	}
+	out, err := os.ReadFile(cfg.OutputPath)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	if !strings.Contains(string(out), "fixed") || strings.Contains(string(out), "secret") {
+		t.Errorf("expected the fixed record with redaction applied, got %s", out)
+	}
+	again, err := os.ReadFile(cfg.DLQPath)
+	if err != nil {
+		t.Fatalf("read dlq: %v", err)
+	}
+	if !strings.Contains(string(again), `"reason":"normalize:missing_ts"`) || !strings.Contains(string(again), "still broken") {
+		t.Errorf("expected the still-broken entry to be dead-lettered again, got %s", again)
+	}
+}
+
+func TestReplayDLQ_CountsTransformErrorsPerTransform(t *testing.T) {
+	dlq := `{"raw":{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"bad record","service":"api"},"line":1,"reason":"normalize:invalid_ts"}
+{"raw":{"ts":"2024-01-01T12:00:01Z","level":"ERROR","msg":"good record","service":"api"},"line":2,"reason":"normalize:invalid_ts"}
+`
+	for _, policy := range []string{"drop", "pass"} {
+		t.Run(policy, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.OutputType = "file"
+			cfg.OutputPath = filepath.Join(t.TempDir(), "out.jsonl")
+			cfg.Transforms = []string{"test_fail_bad"}
+			cfg.TransformOnError = []string{"test_fail_bad=" + policy}
+			rep := report.NewReport()
+			if err := replayDLQ(context.Background(), strings.NewReader(dlq), cfg, rep); err != nil {
+				t.Fatalf("replayDLQ: %v", err)
+			}
+			if len(rep.Transforms) != 1 {
+				t.Fatalf("transform stats %+v", rep.Transforms)
+			}
+			tf := rep.Transforms[0]
+			if tf.RecordsIn != 2 || tf.Errors != 1 || tf.ErrorsByKind["error"] != 1 || tf.ErrorsByPolicy[policy] != 1 {
+				t.Errorf("transform stats %+v", tf)
+			}
+			// As in run, a dropped record also counts as failed normalization.
+			wantWritten, wantFailed := 1, 1
+			if policy == "pass" {
+				wantWritten, wantFailed = 2, 0
+			}
+			if rep.WrittenOK != wantWritten || rep.NormalizedFailed != wantFailed {
+				t.Errorf("written %d, normalize failed %d; want %d, %d", rep.WrittenOK, rep.NormalizedFailed, wantWritten, wantFailed)
+			}
+		})
+	}
+}
+
+func TestRunPipeline_AtomicOutput(t *testing.T) {
+	input := `{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"bad record","service":"api"}
+{"ts":"2024-01-01T12:00:01Z","level":"ERROR","msg":"good record","service":"api"}
+`
+	setup := func(t *testing.T) config.Config {
+		dir := t.TempDir()
+		cfg := config.Default()
+		cfg.OutputType = "file"
+		cfg.OutputPath = filepath.Join(dir, "out.jsonl")
+		cfg.ReportPath = filepath.Join(dir, "report.json")
+		cfg.OutputAtomic = true
+		cfg.OutputDoneMarker = true
+		return cfg
+	}
+
+	t.Run("success", func(t *testing.T) {
+		cfg := setup(t)
+		rep := report.NewReport()
+		if err := runPipeline(context.Background(), strings.NewReader(input), cfg, rep); err != nil {
+			t.Fatalf("runPipeline: %v", err)
+		}
+		out, err := os.ReadFile(cfg.OutputPath)
+		if err != nil || strings.Count(string(out), "\n") != 2 {
+			t.Fatalf("expected 2 committed records, got %q (err=%v)", out, err)
+		}
+		if _, err := os.Stat(sink.AtomicTempPath(cfg.OutputPath)); !os.IsNotExist(err) {
+			t.Errorf("expected temp file to be renamed away, stat err=%v", err)
+		}
+		data, err := os.ReadFile(cfg.OutputPath + ".done")
+		if err != nil {
+			t.Fatalf("read done marker: %v", err)
+		}
+		var marker doneMarker
+		if err := json.Unmarshal(data, &marker); err != nil {
+			t.Fatalf("unmarshal done marker: %v", err)
+		}
+		if marker.RunID != rep.RunID || marker.WrittenOK != 2 || marker.TotalLines != 2 {
+			t.Errorf("unexpected done marker: %+v", marker)
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		cfg := setup(t)
+		cfg.Transforms = []string{"test_fail_bad"}
+		cfg.TransformOnError = []string{"test_fail_bad=abort"}
+		rep := report.NewReport()
+		if err := runPipeline(context.Background(), strings.NewReader(input), cfg, rep); err == nil {
+			t.Fatal("expected the run to abort")
+		}
+		for _, path := range []string{cfg.OutputPath, sink.AtomicTempPath(cfg.OutputPath), cfg.OutputPath + ".done"} {
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Errorf("expected %s to be absent after a failed run, stat err=%v", filepath.Base(path), err)
+			}
+		}
+	})
 }
 
+func TestRunPipeline_Manifest(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 6; i++ {
+		input.WriteString(`{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"record","service":"api"}` + "\n")
+	}
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.OutputType = "rotate"
+	cfg.OutputPath = filepath.Join(dir, "out.jsonl")
+	cfg.OutputMaxB = 250
+	cfg.OutputMaxFiles = 10
+	cfg.ReportPath = filepath.Join(dir, "report.json")
+	cfg.OutputManifest = true
+
+	rep := report.NewReport()
+	if err := runPipeline(context.Background(), strings.NewReader(input.String()), cfg, rep); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+	if rep.ManifestPath != cfg.OutputPath+".manifest.json" {
+		t.Errorf("expected report to reference the manifest, got %q", rep.ManifestPath)
+	}
+
+	data, err := os.ReadFile(rep.ManifestPath)
+	if err != nil {
+		t.Fatalf("read manifest: %v", err)
+	}
+	var m outputManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatalf("unmarshal manifest: %v", err)
+	}
+	if m.RunID != rep.RunID || m.Records != 6 || len(m.Files) < 2 {
+		t.Fatalf("expected 6 records across rotated files, got %+v", m)
+	}
+	for _, f := range m.Files {
+		content, err := os.ReadFile(f.Path)
+		if err != nil {
+			t.Fatalf("read %s: %v", f.Path, err)
+		}
+		sum := sha256.Sum256(content)
+		if f.SHA256 != hex.EncodeToString(sum[:]) || f.Bytes != int64(len(content)) || f.Records != strings.Count(string(content), "\n") {
+			t.Errorf("manifest entry does not match %s: %+v", f.Path, f)
+		}
+	}
+	if _, err := os.Stat(rep.ManifestPath + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("expected manifest temp file to be renamed away, stat err=%v", err)
+	}
+}
+
+func TestRunPipeline_OutputMarkersReplacePlantedLinks(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.OutputType = "rotate"
+	cfg.OutputPath = filepath.Join(dir, "out.jsonl")
+	cfg.ReportPath = filepath.Join(dir, "report.json")
+	cfg.OutputDoneMarker = true
+	cfg.OutputManifest = true
+	// A link planted at a temp path must not redirect the write.
+	victim := filepath.Join(t.TempDir(), "victim")
+	if err := os.WriteFile(victim, []byte("keep\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tmp := range []string{cfg.OutputPath + ".done.tmp", manifestPath(cfg.OutputPath) + ".tmp"} {
+		if err := os.Symlink(victim, tmp); err != nil {
+			t.Skipf("symlinks unavailable: %v", err)
+		}
+	}
+
+	input := `{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"record","service":"api"}` + "\n"
+	if err := runPipeline(context.Background(), strings.NewReader(input), cfg, report.NewReport()); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+	if data, _ := os.ReadFile(victim); string(data) != "keep\n" {
+		t.Errorf("the link's target was written: %q", data)
+	}
+	for _, path := range []string{cfg.OutputPath + ".done", manifestPath(cfg.OutputPath)} {
+		if fi, err := os.Lstat(path); err != nil || !fi.Mode().IsRegular() {
+			t.Errorf("%s: %v, %v", filepath.Base(path), fi, err)
+		}
+		if _, err := os.Lstat(path + ".tmp"); !os.IsNotExist(err) {
+			t.Errorf("%s.tmp left behind: %v", filepath.Base(path), err)
+		}
+	}
+}
+
+func TestRunPipeline_MetricsTextfile(t *testing.T) {
+	input := `{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"bad record","service":"api"}
+{"ts":"2024-01-01T12:00:01Z","level":"ERROR","msg":"good record","service":"api"}
+`
+	run := func(t *testing.T, policy string) (string, error) {
+		dir := t.TempDir()
+		cfg := config.Default()
+		cfg.OutputType = "file"
+		cfg.OutputPath = filepath.Join(dir, "out.jsonl")
+		cfg.ReportPath = filepath.Join(dir, "report.json")
+		cfg.MetricsTextfilePath = filepath.Join(dir, "etl.prom")
+		cfg.Transforms = []string{"test_fail_bad"}
+		cfg.TransformOnError = []string{"test_fail_bad=" + policy}
+		err := runPipeline(context.Background(), strings.NewReader(input), cfg, report.NewReport())
+		data, readErr := os.ReadFile(cfg.MetricsTextfilePath)
+		if readErr != nil {
+			t.Fatalf("read metrics textfile: %v", readErr)
+		}
+		if _, statErr := os.Stat(cfg.MetricsTextfilePath + ".tmp"); !os.IsNotExist(statErr) {
+			t.Errorf("expected textfile temp file to be renamed away, stat err=%v", statErr)
+		}
+		return string(data), err
+	}
+
+	t.Run("success", func(t *testing.T) {
+		out, err := run(t, "pass")
+		if err != nil {
+			t.Fatalf("runPipeline: %v", err)
+		}
+		for _, want := range []string{"etl_last_run_success 1\n", "# TYPE etl_last_run_timestamp_seconds gauge\n", "etl_written_ok 2\n"} {
+			if !strings.Contains(out, want) {
+				t.Errorf("expected textfile to contain %q, got:\n%s", want, out)
+			}
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		out, err := run(t, "abort")
+		if err == nil {
+			t.Fatal("expected the run to abort")
+		}
+		if !strings.Contains(out, "etl_last_run_success 0\n") || !strings.Contains(out, "etl_last_run_timestamp_seconds ") {
+			t.Errorf("expected failed run to be recorded, got:\n%s", out)
+		}
+	})
+}
+
+func TestRunPipeline_Aggregate(t *testing.T) {
+	input := `{"ts":"2024-01-01T12:00:05Z","level":"ERROR","msg":"a","service":"api"}
+{"ts":"2024-01-01T12:00:10Z","level":"ERROR","msg":"b","service":"api"}
+{"ts":"2024-01-01T12:01:10Z","level":"WARN","msg":"c","service":"db"}
+`
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.OutputType = "file"
+	cfg.OutputPath = filepath.Join(dir, "out.jsonl")
+	cfg.ReportPath = filepath.Join(dir, "report.json")
+	cfg.MaxWorkers = 1
+	cfg.AggregateWindowSeconds = 60
+
+	rep := report.NewReport()
+	if err := runPipeline(context.Background(), strings.NewReader(input), cfg, rep); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+
+	data, err := os.ReadFile(cfg.OutputPath)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 aggregate rows, got %d:\n%s", len(lines), data)
+	}
+	var row sink.AggregateRow
+	if err := json.Unmarshal([]byte(lines[0]), &row); err != nil {
+		t.Fatalf("unmarshal row: %v", err)
+	}
+	if row.BucketStart != "2024-01-01T12:00:00Z" || row.Count != 2 || row.Group["service"] != "api" || row.Group["level"] != "ERROR" {
+		t.Errorf("unexpected first row: %+v", row)
+	}
+	if !strings.Contains(rep.Prometheus(), "etl_aggregate_rows_total") {
+		t.Errorf("expected aggregate metrics in prometheus output")
+	}
+}
+
+func TestWriteWithRetry_ContextCancellation(t *testing.T) {
+	cfg := config.Default()
+	rep := report.NewReport()
+
+	// Create a sink that always fails
+	failingSink := &failingWriter{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // Cancel immediately
+
+	_, err := writeWithRetry(ctx, failingSink, "test", cfg, rep)
+	if err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestWriteWithRetry_BackoffUsesClock(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+
+	cfg := config.Default()
+	cfg.SinkMaxRetries = 2
+	cfg.SinkBackoffBaseMS = 60_000
+	cfg.SinkBackoffMaxMS = 600_000
+	rep := report.NewReport()
+
+	type result struct {
+		retries int
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		retries, err := writeWithRetry(withClock(context.Background(), fake), &failingWriter{}, "test", cfg, rep)
+		done <- result{retries, err}
+	}()
+	// Minute-long backoffs (plus up to 20% jitter) elapse on the fake clock.
+	for _, wait := range []time.Duration{72 * time.Second, 144 * time.Second} {
+		fake.BlockUntil(1)
+		fake.Advance(wait)
+	}
+	res := <-done
+	if !errors.Is(res.err, sink.ErrWriteSink) || res.retries != 2 {
+		t.Fatalf("expected 2 retries ending in ErrWriteSink, got %d, %v", res.retries, res.err)
+	}
+	if rep.RetryStats.TotalRetries != 2 {
+		t.Errorf("expected 2 retries in report, got %d", rep.RetryStats.TotalRetries)
+	}
+}
+
+func TestWriteWithRetry_Timeout(t *testing.T) {
+	cfg := config.Default()
+	cfg.SinkMaxRetries = 2
+	cfg.SinkBackoffBaseMS = 1
+	cfg.SinkBackoffMaxMS = 1
+	cfg.SinkWriteTimeoutMS = 100
+	rep := report.NewReport()
+
+	// Each abandoned write is retried only once it has failed, which it
+	// does within a second timeout.
+	w := &slowWriter{delay: 150 * time.Millisecond, err: sink.ErrWriteSink}
+	retries, err := writeWithRetry(context.Background(), w, "test", cfg, rep)
+	if !errors.Is(err, sink.ErrWriteTimeout) || !errors.Is(err, sink.ErrWriteSink) {
+		t.Fatalf("expected ErrWriteTimeout and the write's error, got %v", err)
+	}
+	if retries != 2 {
+		t.Errorf("expected 2 retries, got %d", retries)
+	}
+	if rep.RetryStats.WriteTimeouts != 3 {
+		t.Errorf("expected 3 write timeouts, got %d", rep.RetryStats.WriteTimeouts)
+	}
+	if w.max.Load() != 1 {
+		t.Errorf("%d writes ran at once, want 1", w.max.Load())
+	}
+}
+
+func TestWriteWithRetry_TimedOutWriteThatSucceedsIsNotRetried(t *testing.T) {
+	cfg := config.Default()
+	cfg.SinkMaxRetries = 2
+	cfg.SinkBackoffBaseMS = 1
+	cfg.SinkWriteTimeoutMS = 100
+	rep := report.NewReport()
+
+	w := &slowWriter{delay: 150 * time.Millisecond}
+	retries, err := writeWithRetry(context.Background(), w, "test", cfg, rep)
+	if err != nil || retries != 0 {
+		t.Fatalf("writeWithRetry = %d, %v; want the late write's success", retries, err)
+	}
+	if w.writes.Load() != 1 {
+		t.Errorf("record written %d times, want once", w.writes.Load())
+	}
+	if rep.RetryStats.WriteTimeouts != 1 {
+		t.Errorf("expected 1 write timeout, got %d", rep.RetryStats.WriteTimeouts)
+	}
+}
+
+func TestRunPipeline_TimedOutPlainWriteIsNotDuplicated(t *testing.T) {
+	cfg := config.Default()
+	cfg.BatchSize = 0
+	cfg.SinkMaxRetries = 2
+	cfg.SinkBackoffBaseMS = 1
+	cfg.SinkWriteTimeoutMS = 100
+	cfg.DLQPath = filepath.Join(t.TempDir(), "dlq.jsonl")
+	cfg.ReportPath = filepath.Join(t.TempDir(), "report.json")
+
+	w := &slowWriter{delay: 150 * time.Millisecond}
+	rep := report.NewReport()
+	input := `{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"slow","service":"api"}` + "\n"
+	if err := runPipeline(withBaseSink(context.Background(), w), strings.NewReader(input), cfg, rep); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+	if w.writes.Load() != 1 || rep.WriteFailed != 0 || rep.DLQWritten != 0 {
+		t.Errorf("writes %d, failed %d, dead-lettered %d; want the record written once", w.writes.Load(), rep.WriteFailed, rep.DLQWritten)
+	}
+	if data, _ := os.ReadFile(cfg.DLQPath); len(data) != 0 {
+		t.Errorf("dlq = %s, want empty", data)
+	}
+}
+
+func TestWriteWithRetry_HungWriteIsGivenUp(t *testing.T) {
+	cfg := config.Default()
+	cfg.SinkMaxRetries = 2
+	cfg.SinkBackoffBaseMS = 1
+	cfg.SinkWriteTimeoutMS = 10
+	rep := report.NewReport()
+
+	// A write still running a second timeout after the first is not
+	// waited for any longer, nor retried while it may yet store the record.
+	w := &stalledWriter{stalled: make(chan struct{}), release: make(chan struct{})}
+	defer close(w.release)
+	retries, err := writeWithRetry(context.Background(), w, "test", cfg, rep)
+	if !errors.Is(err, sink.ErrWriteTimeout) || retries != 0 {
+		t.Fatalf("writeWithRetry = %d, %v; want ErrWriteTimeout without retries", retries, err)
+	}
+	if reason := sinkDLQReason(err); reason != "write_timeout" {
+		t.Errorf("DLQ reason %q, want write_timeout", reason)
+	}
+	if rep.RetryStats.WriteTimeouts != 1 {
+		t.Errorf("expected 1 write timeout, got %d", rep.RetryStats.WriteTimeouts)
+	}
+}
+
+func TestRunPipeline_WriteTimeoutDeadLetters(t *testing.T) {
+	input := `{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"test message","service":"test-service"}
+`
+	cfg := config.Default()
+	cfg.OutputType = "http"
+	cfg.BatchSize = 1
+	cfg.SinkMaxRetries = 1
+	cfg.SinkBackoffBaseMS = 1
+	cfg.SinkBackoffMaxMS = 1
+	cfg.SinkWriteTimeoutMS = 20
+	cfg.DLQPath = filepath.Join(t.TempDir(), "dlq.jsonl")
+	cfg.ReportPath = filepath.Join(t.TempDir(), "report.json")
+
+	stall := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-stall:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(stall)
+	cfg.OutputPath = server.URL
+
+	rep := report.NewReport()
+	if err := runPipeline(context.Background(), strings.NewReader(input), cfg, rep); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+	if rep.WriteFailed != 1 {
+		t.Errorf("expected 1 failed write, got %d", rep.WriteFailed)
+	}
+	if rep.DLQReasons["write_timeout"] != 1 {
+		t.Errorf("expected write_timeout DLQ reason, got %v", rep.DLQReasons)
+	}
+
+	// DLQ records always carry the run ID.
+	data, err := os.ReadFile(cfg.DLQPath)
+	if err != nil {
+		t.Fatalf("read dlq: %v", err)
+	}
+	var dlq dlqRecord
+	if err := json.Unmarshal(data, &dlq); err != nil {
+		t.Fatalf("unmarshal dlq: %v", err)
+	}
+	if dlq.RunID == "" || dlq.RunID != rep.RunID {
+		t.Errorf("expected dlq run_id %q, got %q", rep.RunID, dlq.RunID)
+	}
+}
+
+func TestWriteWithRetry_InjectedFailureRetried(t *testing.T) {
+	cfg := config.Default()
+	cfg.SinkMaxRetries = 2
+	cfg.SinkBackoffBaseMS = 1
+	rep := report.NewReport()
+	fs := sink.NewFaultySink(sink.NewMemorySink(), sink.FaultyOptions{FailEvery: 2})
+
+	if retries, err := writeWithRetry(context.Background(), fs, "a", cfg, rep); err != nil || retries != 0 {
+		t.Fatalf("first write: %d retries, %v", retries, err)
+	}
+	// Attempt 2 fails and attempt 3 succeeds.
+	if retries, err := writeWithRetry(context.Background(), fs, "b", cfg, rep); err != nil || retries != 1 {
+		t.Fatalf("second write: %d retries, %v; want 1 retry", retries, err)
+	}
+	if rep.RetryStats.TotalRetries != 1 || rep.RetryStats.WritesWithRetries != 1 {
+		t.Errorf("retry stats = %+v", rep.RetryStats)
+	}
+}
+
+func TestRunPipeline_FaultySinkDeadLetters(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 5; i++ {
+		fmt.Fprintf(&input, `{"ts":"2024-01-01T12:00:0%dZ","level":"ERROR","msg":"m%d","service":"svc"}`+"\n", i, i)
+	}
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.OutputType = "faulty"
+	cfg.FaultyInner = "file"
+	cfg.FaultyFailAfter = 3
+	cfg.BatchSize = 1
+	cfg.OutputPath = filepath.Join(dir, "out.jsonl")
+	cfg.MaxWorkers = 1
+	cfg.SinkMaxRetries = 1
+	cfg.SinkBackoffBaseMS = 1
+	cfg.DLQPath = filepath.Join(dir, "dlq.jsonl")
+	cfg.ReportPath = filepath.Join(dir, "report.json")
+
+	rep := report.NewReport()
+	if err := runPipeline(context.Background(), strings.NewReader(input.String()), cfg, rep); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+	if rep.WrittenOK != 3 || rep.WriteFailed != 2 || rep.DLQWritten != 2 {
+		t.Errorf("written %d, failed %d, dead-lettered %d; want 3, 2, 2", rep.WrittenOK, rep.WriteFailed, rep.DLQWritten)
+	}
+	if rep.DLQReasons["injected_failure"] != 2 {
+		t.Errorf("DLQ reasons = %v", rep.DLQReasons)
+	}
+	want := report.FaultStats{Attempts: 7, Injected: 4, AfterLimit: 4}
+	if rep.Faults == nil || *rep.Faults != want {
+		t.Errorf("faults = %+v, want %+v", rep.Faults, want)
+	}
+	if !strings.Contains(rep.Prometheus(), `etl_injected_faults_total{fault="after_limit"} 4`) {
+		t.Error("expected injected faults in prometheus output")
+	}
+	out, err := os.ReadFile(cfg.OutputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(out), "\n"); n != 3 {
+		t.Errorf("output has %d records, want 3", n)
+	}
+}
+
+func TestRunPipeline_ClickHouseRejectedDeadLetters(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, "Code: 60. DB::Exception: Table default.logs does not exist. (UNKNOWN_TABLE)")
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.OutputType = "clickhouse"
+	cfg.OutputPath = server.URL
+	cfg.ClickHouseTable = "logs"
+	cfg.BatchSize = 1
+	cfg.SinkMaxRetries = 3
+	cfg.SinkBackoffBaseMS = 1
+	cfg.DLQPath = filepath.Join(dir, "dlq.jsonl")
+	cfg.ReportPath = filepath.Join(dir, "report.json")
+	if err := config.Validate(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	input := `{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"boom","service":"api"}` + "\n"
+	rep := report.NewReport()
+	if err := runPipeline(context.Background(), strings.NewReader(input), cfg, rep); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("%d inserts, want 1: rejected writes are not retried", attempts)
+	}
+	if rep.WriteFailed != 1 || rep.DLQReasons["rejected"] != 1 {
+		t.Errorf("failed %d, DLQ reasons %v; want 1 rejected", rep.WriteFailed, rep.DLQReasons)
+	}
+}
+
+func TestRunPipeline_TemplateOutput(t *testing.T) {
+	input := `{"ts":"2024-01-02T15:04:05Z","level":"ERROR","msg":"boom","service":"payments","pod":"xyz","tags":["a","b"]}
+{"ts":"2024-01-02T15:04:06Z","level":"ERROR","msg":"no tags","service":"payments","pod":"xyz"}
+`
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.OutputType = "file"
+	cfg.OutputPath = filepath.Join(dir, "out.log")
+	cfg.OutputFormat = config.FormatTemplate
+	cfg.OutputTemplate = `{{.TS}} {{.Level}} {{.Service}} pod={{.Pod}} msg={{quote .Message}} tag={{index .Fields.tags 1}}`
+	cfg.DLQPath = filepath.Join(dir, "dlq.jsonl")
+	cfg.ReportPath = filepath.Join(dir, "report.json")
+
+	rep := report.NewReport()
+	if err := runPipeline(context.Background(), strings.NewReader(input), cfg, rep); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+	out, err := os.ReadFile(cfg.OutputPath)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	if want := "2024-01-02T15:04:05Z ERROR payments pod=xyz msg=\"boom\" tag=b\n"; string(out) != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+
+	// The second record has no tags to index: a write failure, dead-lettered
+	// without retries.
+	if rep.WriteFailed != 1 || rep.RetryStats.TotalRetries != 0 {
+		t.Errorf("expected 1 failed write and no retries, got %d and %d", rep.WriteFailed, rep.RetryStats.TotalRetries)
+	}
+	if rep.DLQReasons["format_error"] != 1 {
+		t.Errorf("expected format_error DLQ reason, got %v", rep.DLQReasons)
+	}
+	data, err := os.ReadFile(cfg.DLQPath)
+	if err != nil {
+		t.Fatalf("read dlq: %v", err)
+	}
+	var dlq dlqRecord
+	if err := json.Unmarshal(data, &dlq); err != nil {
+		t.Fatalf("unmarshal dlq: %v", err)
+	}
+	if dlq.Record == nil || dlq.Record.Message != "no tags" || !strings.Contains(dlq.Error, "index") {
+		t.Errorf("unexpected dlq entry: %s", data)
+	}
+}
+
+func TestRunPipeline_TemplateParseError(t *testing.T) {
+	cfg := config.Default()
+	cfg.OutputFormat = config.FormatTemplate
+	cfg.OutputTemplate = "{{.TS"
+	err := runPipeline(context.Background(), strings.NewReader(""), cfg, report.NewReport())
+	if !errors.Is(err, sink.ErrOpenSink) {
+		t.Fatalf("expected an open sink error, got %v", err)
+	}
+}
+
+func TestRunPipeline_SortWindow(t *testing.T) {
+	var input strings.Builder
+	for _, rec := range []struct{ ts, msg string }{
+		{"12:00:03", "c"}, {"12:00:01", "a"}, {"12:00:02", "b"},
+		{"12:00:30", "e"}, {"12:00:05", "late"}, {"12:00:25", "d"},
+	} {
+		fmt.Fprintf(&input, `{"ts":"2024-01-01T%sZ","level":"ERROR","msg":%q,"service":"api"}`+"\n", rec.ts, rec.msg)
+	}
+	cfg := config.Default()
+	cfg.OutputType = "file"
+	cfg.OutputPath = filepath.Join(t.TempDir(), "out.jsonl")
+	cfg.ReportPath = filepath.Join(t.TempDir(), "report.json")
+	cfg.MaxWorkers = 1 // keep write order
+	cfg.SortWindow = "10s"
+
+	rep := report.NewReport()
+	if err := runPipeline(context.Background(), strings.NewReader(input.String()), cfg, rep); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+	data, err := os.ReadFile(cfg.OutputPath)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	var got []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var rec model.Normalized
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+		got = append(got, rec.Message)
+	}
+	// a-c are released when e arrives; "late" is 25s behind e and passes
+	// straight through; d and e are flushed at EOF.
+	if want := "a,b,c,late,d,e"; strings.Join(got, ",") != want {
+		t.Errorf("output order = %s, want %s", strings.Join(got, ","), want)
+	}
+	if rep.Sort.LateRecords != 1 || rep.Sort.WindowSeconds != 10 || rep.WrittenOK != 6 {
+		t.Errorf("unexpected sort stats %+v (written %d)", rep.Sort, rep.WrittenOK)
+	}
+}
+
+func TestRunPipeline_SkipAndHead(t *testing.T) {
+	var input strings.Builder
+	for i := 1; i <= 10; i++ {
+		fmt.Fprintf(&input, `{"ts":"2024-01-01T12:00:%02dZ","level":"ERROR","msg":"m%d","service":"api"}`+"\n", 10-i, i)
+		if i == 2 {
+			input.WriteString("\n")
+		}
+	}
+	tests := []struct {
+		name       string
+		skip, head int
+		sortWindow string
+		want       string
+		wantReason string
+		lastLine   int
+	}{
+		{name: "skip", skip: 7, want: "m8,m9,m10", wantReason: report.StopEOF, lastLine: 10},
+		{name: "head", head: 3, want: "m1,m2,m3", wantReason: report.StopHead, lastLine: 3},
+		{name: "skip and head", skip: 2, head: 2, want: "m3,m4", wantReason: report.StopHead, lastLine: 4},
+		{name: "head past end", skip: 8, head: 5, want: "m9,m10", wantReason: report.StopEOF, lastLine: 10},
+		// Every record is buffered until the flush, which stops at the head.
+		{name: "head with sort", head: 2, sortWindow: "1m", want: "m10,m9", wantReason: report.StopHead, lastLine: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.OutputType = "file"
+			cfg.OutputPath = filepath.Join(t.TempDir(), "out.jsonl")
+			cfg.ReportPath = filepath.Join(t.TempDir(), "report.json")
+			cfg.MaxWorkers = 1 // keep write order
+			cfg.Skip, cfg.Head, cfg.SortWindow = tt.skip, tt.head, tt.sortWindow
+
+			rep := report.NewReport()
+			if err := runPipeline(context.Background(), strings.NewReader(input.String()), cfg, rep); err != nil {
+				t.Fatalf("runPipeline: %v", err)
+			}
+			data, err := os.ReadFile(cfg.OutputPath)
+			if err != nil {
+				t.Fatalf("read output: %v", err)
+			}
+			var got []string
+			for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+				var rec model.Normalized
+				if err := json.Unmarshal([]byte(line), &rec); err != nil {
+					t.Fatalf("unmarshal: %v", err)
+				}
+				got = append(got, rec.Message)
+			}
+			if strings.Join(got, ",") != tt.want {
+				t.Errorf("output = %s, want %s", strings.Join(got, ","), tt.want)
+			}
+			if rep.Shutdown.Reason != tt.wantReason {
+				t.Errorf("stop reason = %q, want %q", rep.Shutdown.Reason, tt.wantReason)
+			}
+			wantLimits := report.LimitStats{Skip: tt.skip, Head: tt.head, SkippedLines: min(tt.skip, 10), HeadReached: tt.wantReason == report.StopHead, LastLine: tt.lastLine}
+			if rep.Limits != wantLimits {
+				t.Errorf("limits = %+v, want %+v", rep.Limits, wantLimits)
+			}
+			if rep.TotalLines+rep.Limits.SkippedLines > 10 {
+				t.Errorf("total lines %d includes skipped lines", rep.TotalLines)
+			}
+		})
+	}
+}
+
+func TestRunPipeline_MaxDuration(t *testing.T) {
+	fake := clock.NewFake(time.Unix(1_700_000_000, 0))
+	line := func(i int) string {
+		return fmt.Sprintf(`{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"m%d","service":"api"}`+"\n", i)
+	}
+	// The producer sends two records and then goes silent without closing
+	// the pipe: the time running out must end the blocked read.
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go io.WriteString(pw, line(1)+"\n"+line(2))
+	cfg := config.Default()
+	cfg.ReportPath = filepath.Join(t.TempDir(), "report.json")
+	cfg.BatchSize = 0
+	cfg.MaxDuration = "30s"
+	mem := sink.NewMemorySink()
+	rep := report.NewReport()
+	done := make(chan error, 1)
+	go func() { done <- runPipeline(withBaseSink(withClock(context.Background(), fake), mem), pr, cfg, rep) }()
+	for deadline := time.Now().Add(10 * time.Second); len(mem.Records()) < 2 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	fake.BlockUntil(1)
+	fake.Advance(30 * time.Second)
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("max_duration did not end a read blocked on a silent pipe")
+	}
+	if !errors.Is(err, errMaxDuration) || exitCode(err) != exitMaxDuration {
+		t.Fatalf("err = %v (exit code %d), want errMaxDuration", err, exitCode(err))
+	}
+	if n := len(mem.Records()); n != 2 {
+		t.Errorf("%d records written, want the 2 read in time", n)
+	}
+	if rep.Shutdown.Reason != report.StopDuration || rep.Shutdown.LinesNotEnqueued != 0 {
+		t.Errorf("shutdown = %+v, want reason max_duration", rep.Shutdown)
+	}
+	want := report.LimitStats{MaxDurationSeconds: 30, MaxDurationReached: true, LastLine: 2}
+	if rep.Limits != want {
+		t.Errorf("limits = %+v, want %+v", rep.Limits, want)
+	}
+	if rep.InputIdle != (report.InputIdleStats{}) {
+		t.Errorf("input_idle = %+v without input_idle_timeout", rep.InputIdle)
+	}
+
+	// The next run skips what the first one processed.
+	cfg.MaxDuration = ""
+	cfg.Skip = rep.Limits.LastLine
+	mem = sink.NewMemorySink()
+	rep = report.NewReport()
+	input := line(1) + line(2) + line(3) + line(4)
+	if err := runPipeline(withBaseSink(context.Background(), mem), strings.NewReader(input), cfg, rep); err != nil {
+		t.Fatalf("resumed run: %v", err)
+	}
+	var got []string
+	for _, data := range mem.Records() {
+		var rec model.Normalized
+		if err := json.Unmarshal(data, &rec); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, rec.Message)
+	}
+	if strings.Join(got, ",") != "m3,m4" {
+		t.Errorf("resumed run wrote %v, want m3,m4", got)
+	}
+}
+
+func TestRunPipeline_UnwrapKeys(t *testing.T) {
+	input, err := os.ReadFile(filepath.Join("..", "..", "internal", "stages", "testdata", "fluentd.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Default()
+	cfg.OutputType = "file"
+	cfg.OutputPath = filepath.Join(t.TempDir(), "out.jsonl")
+	cfg.ReportPath = filepath.Join(t.TempDir(), "report.json")
+	cfg.UnwrapKeys = []string{"log"}
+
+	rep := report.NewReport()
+	if err := runPipeline(context.Background(), bytes.NewReader(input), cfg, rep); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+	if want := (report.UnwrapStats{JSON: 2, Text: 1, Failed: 1}); rep.Unwrap != want {
+		t.Errorf("unwrap stats = %+v, want %+v", rep.Unwrap, want)
+	}
+	// The plain text line has no level and the broken one no timestamp.
+	if rep.NormalizedOK != 2 || rep.WrittenOK != 2 {
+		t.Errorf("normalized %d, written %d; want 2 and 2", rep.NormalizedOK, rep.WrittenOK)
+	}
+}
+
+// Run with -race: snapshots are taken while the read loop and workers
+// update the report.
+func TestRunPipeline_SnapshotWhileRunning(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 5000; i++ {
+		fmt.Fprintf(&input, `{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"m%d","service":"api","error":"boom"}`+"\n", i)
+		if i%10 == 0 {
+			input.WriteString("not json\n")
+		}
+	}
+	cfg := config.Default()
+	cfg.OutputType = "file"
+	cfg.OutputPath = filepath.Join(t.TempDir(), "out.jsonl")
+	cfg.ReportPath = filepath.Join(t.TempDir(), "report.json")
+	cfg.MaxWorkers = 4
+
+	rep := report.NewReport()
+	rep.RunID = "run-1" // set up front, as cmdRun does
+	done := make(chan error)
+	go func() { done <- runPipeline(context.Background(), strings.NewReader(input.String()), cfg, rep) }()
+	snapshots := 0
+	last := 0
+	for running := true; running; {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("runPipeline: %v", err)
+			}
+			running = false
+		default:
+			snap := rep.Snapshot()
+			if snap.TotalLines < last {
+				t.Fatalf("total lines went back from %d to %d", last, snap.TotalLines)
+			}
+			last = snap.TotalLines
+			if _, err := json.Marshal(snap); err != nil {
+				t.Fatalf("marshal snapshot: %v", err)
+			}
+			snapshots++
+		}
+	}
+	final := rep.Snapshot()
+	if final.TotalLines != 5500 || final.JSONFailed != 500 || final.WrittenOK != 5000 || final.WithError != 5000 || final.ByLevel["ERROR"] != 5000 {
+		t.Errorf("unexpected final counts: lines=%d json_failed=%d written=%d with_error=%d by_level=%v",
+			final.TotalLines, final.JSONFailed, final.WrittenOK, final.WithError, final.ByLevel)
+	}
+	if snapshots == 0 {
+		t.Log("pipeline finished before the first snapshot")
+	}
+}
+
+func TestRunPipeline_DLQTruncatesHugeRecords(t *testing.T) {
+	blob := strings.Repeat("x", 10000)
+	input := `{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"upload failed","service":"api","payload":"` + blob + `"}
+`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.OutputType = "http"
+	cfg.OutputPath = server.URL
+	cfg.BatchSize = 0
+	cfg.SinkMaxRetries = 0
+	cfg.DLQPath = filepath.Join(dir, "dlq.jsonl")
+	cfg.ReportPath = filepath.Join(dir, "report.json")
+	cfg.DLQMaxRecordBytes = 1024
+
+	var logs bytes.Buffer
+	prev := logger.Logger()
+	logger.SetLogger(slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	defer logger.SetLogger(prev)
+
+	rep := report.NewReport()
+	if err := runPipeline(context.Background(), strings.NewReader(input), cfg, rep); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+	if rep.DLQWritten != 1 || rep.DLQTruncated != 1 {
+		t.Fatalf("expected 1 truncated DLQ entry, got written=%d truncated=%d", rep.DLQWritten, rep.DLQTruncated)
+	}
+
+	data, err := os.ReadFile(cfg.DLQPath)
+	if err != nil {
+		t.Fatalf("read dlq: %v", err)
+	}
+	if len(data) > cfg.DLQMaxRecordBytes {
+		t.Errorf("dlq entry is %d bytes, limit %d", len(data), cfg.DLQMaxRecordBytes)
+	}
+	var rec dlqRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		t.Fatalf("unmarshal dlq: %v", err)
+	}
+	if !rec.Truncated || rec.OriginalBytes < len(blob) || rec.Line != 1 {
+		t.Errorf("expected a flagged truncated entry, got %+v", rec)
+	}
+	if rec.Record.Message != "upload failed" || rec.Record.Service != "api" || rec.Record.TS == "" {
+		t.Errorf("expected core fields to be kept, got %+v", rec.Record)
+	}
+	if _, ok := rec.Record.Fields["payload"]; ok || rec.Record.Fields[truncatedKey] == nil {
+		t.Errorf("expected payload to be replaced by a size note, got %v", rec.Record.Fields)
+	}
+
+	if strings.Contains(logs.String(), blob) {
+		t.Errorf("logs contain the record payload")
+	}
+	if !strings.Contains(logs.String(), `"trace_id":"line-1"`) || !strings.Contains(logs.String(), `"service":"api"`) {
+		t.Errorf("expected write failure log to carry identifiers, got %s", logs.String())
+	}
+}
+
+func TestDLQRecordLimit(t *testing.T) {
+	raw := map[string]any{"ts": "bad", "msg": "hello", "service": "api", "blob": strings.Repeat("y", 500)}
+	rec := dlqRecord{Raw: raw, Line: 7, Reason: "normalize:invalid_ts"}
+
+	if got := rec.limit(0); got.Truncated {
+		t.Errorf("limit 0 should disable truncation")
+	}
+	if got := rec.limit(10000); got.Truncated {
+		t.Errorf("small entry should not be truncated")
+	}
+	got := rec.limit(200)
+	if !got.Truncated || got.Raw["msg"] != "hello" || got.Raw["ts"] != "bad" || got.Raw["blob"] != nil || got.Raw[truncatedKey] == nil {
+		t.Errorf("unexpected truncated raw entry: %+v", got)
+	}
+	if raw["blob"] == nil {
+		t.Errorf("limit must not modify the caller's map")
+	}
+}
+
+func TestDLQRecordSchema(t *testing.T) {
+	n := model.Normalized{TS: "2024-01-01T12:00:00Z", Level: "ERROR", Message: "boom", Service: "api", TraceID: "t1"}
+	for _, tc := range []struct {
+		legacy bool
+		want   string
+	}{
+		{true, `"record":{"TS":"2024-01-01T12:00:00Z","Level":"ERROR","Service":"api","Namespace":"","Pod":"","Node":"","Message":"boom","TraceID":"t1","Fields":null}`},
+		{false, `"record":{"ts":"2024-01-01T12:00:00Z","level":"ERROR","service":"api","message":"boom","trace_id":"t1"}`},
+	} {
+		data, err := json.Marshal(dlqRecord{Record: &n, Reason: "write sink", RunID: "r1", legacy: tc.legacy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), tc.want) || !strings.Contains(string(data), `"run_id":"r1"`) {
+			t.Errorf("legacy=%v: got %s, want %s", tc.legacy, data, tc.want)
+		}
+		var back dlqRecord
+		if err := json.Unmarshal(data, &back); err != nil {
+			t.Fatal(err)
+		}
+		if back.Record == nil || back.Record.TraceID != "t1" || back.Record.Message != "boom" {
+			t.Errorf("legacy=%v: decoded %+v", tc.legacy, back.Record)
+		}
+	}
+}
+
+type cancelAfterReader struct {
+	r      io.Reader
+	read   int
+	limit  int
+	cancel context.CancelFunc
+}
+
+func (c *cancelAfterReader) Read(p []byte) (int, error) {
+	if c.read >= c.limit {
+		c.cancel()
+	}
+	n, err := c.r.Read(p[:min(len(p), 512)])
+	c.read += n
+	return n, err
+}
+
+// slowWriter takes delay over each write, which then ends with err.
+type slowWriter struct {
+	delay   time.Duration
+	err     error
+	running atomic.Int32
+	max     atomic.Int32 // most writes running at once
+	writes  atomic.Int32 // successful writes
+}
+
+func (sw *slowWriter) Write(interface{}) error {
+	n := sw.running.Add(1)
+	defer sw.running.Add(-1)
+	for m := sw.max.Load(); n > m && !sw.max.CompareAndSwap(m, n); m = sw.max.Load() {
+	}
+	time.Sleep(sw.delay)
+	if sw.err != nil {
+		return sw.err
+	}
+	sw.writes.Add(1)
+	return nil
+}
+
+func (sw *slowWriter) Close() error {
+	return nil
+}
+
+type failingWriter struct{}
+
+func (fw *failingWriter) Write(interface{}) error {
+	return sink.ErrWriteSink
+}
+
+func (fw *failingWriter) Close() error {
+	return nil
+}
+
+func TestInspect_ReportsEachStage(t *testing.T) {
+	input := `not json
+{"level":"ERROR","msg":"no timestamp"}
+{"ts":"2024-01-01T12:00:00Z","level":"INFO","msg":"dropped","service":"svc"}
+{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"kept","service":"svc","token":"secret"}
+{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"beyond limit"}
+`
+	cfg := config.Default()
+	cfg.FilterLevels = []string{"ERROR"}
+	cfg.RedactKeys = []string{"token"}
+
+	var out strings.Builder
+	if err := inspect(&out, strings.NewReader(input), cfg, 4); err != nil {
+		t.Fatalf("inspect: %v", err)
+	}
+	got := out.String()
+	for _, want := range []string{
+		"result: rejected at parsing",
+		"normalized: ERROR missing timestamp",
+		"filter_redact: drop (reason: level)",
+		"result: dropped by filter_redact (level)",
+		"filter_redact: pass",
+		"result: emitted",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in output:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "beyond limit") {
+		t.Errorf("expected inspection to stop after 4 records")
+	}
+	// raw, parsed, and normalized show the token; the redacted output does not.
+	if strings.Count(got, `"token"`) != 3 {
+		t.Errorf("expected token to be redacted from output section:\n%s", got)
+	}
+}
+
+func TestInspect_ParsesLikeRun(t *testing.T) {
+	input := "\ufeff" + `{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"bom","service":"svc"}
+{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"first","service":"svc"}{"ts":"2024-01-01T12:00:01Z","level":"WARN","msg":"second","service":"svc"}
+{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"comma","service":"svc","tags":["a",],}
+`
+	var out strings.Builder
+	if err := inspect(&out, strings.NewReader(input), config.Default(), 3); err != nil {
+		t.Fatalf("inspect: %v", err)
+	}
+	got := out.String()
+	if strings.Contains(got, "rejected at parsing") {
+		t.Errorf("a line was rejected:\n%s", got)
+	}
+	if n := strings.Count(got, "result: emitted"); n != 4 {
+		t.Errorf("%d records emitted, want 4:\n%s", n, got)
+	}
+	for _, want := range []string{"--- object 1 of 2 ---", "--- object 2 of 2 ---", `"msg": "second"`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in output:\n%s", want, got)
+		}
+	}
+}
+
+// heapSink is a slow sink that samples the live heap on every write.
+type heapSink struct {
+	mu       sync.Mutex
+	writes   int
+	peakHeap uint64
+}
+
+func (h *heapSink) Write(record any) error {
+	time.Sleep(time.Millisecond)
+	var ms runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&ms)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writes++
+	h.peakHeap = max(h.peakHeap, ms.HeapAlloc)
+	return nil
+}
+
+func (h *heapSink) Close() error { return nil }
+
+func TestRunPipeline_MaxInflightBytesBoundsMemory(t *testing.T) {
+	const records, payload = 400, 48 << 10
+	run := func(maxInflight int64) (*report.Report, *heapSink) {
+		t.Helper()
+		pr, pw := io.Pipe()
+		go func() {
+			blob := strings.Repeat("x", payload)
+			for i := 0; i < records; i++ {
+				fmt.Fprintf(pw, `{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"m%d","service":"svc","payload":"%s"}`+"\n", i, blob)
+			}
+			pw.Close()
+		}()
+		cfg := config.Default()
+		cfg.MaxWorkers = 1
+		cfg.QueueSize = records
+		cfg.BatchSize = 0
+		cfg.MaxInflightBytes = maxInflight
+		cfg.ReportPath = filepath.Join(t.TempDir(), "report.json")
+		h := &heapSink{}
+		rep := report.NewReport()
+		if err := runPipeline(withBaseSink(context.Background(), h), pr, cfg, rep); err != nil {
+			t.Fatalf("runPipeline: %v", err)
+		}
+		if h.writes != records {
+			t.Fatalf("wrote %d records, want %d", h.writes, records)
+		}
+		return rep, h
+	}
+
+	const limit = 512 << 10
+	capped, cappedSink := run(limit)
+	in := capped.InFlight
+	if in.MaxBytes != limit || in.CurrentBytes != 0 || in.Waits == 0 {
+		t.Errorf("inflight = %+v, want cap %d, nothing left, and some waits", in, limit)
+	}
+	if in.PeakBytes > limit+2*payload {
+		t.Errorf("peak in-flight bytes %d, want at most about %d", in.PeakBytes, limit)
+	}
+	if !strings.Contains(capped.Prometheus(), "etl_inflight_peak_bytes ") {
+		t.Error("expected in-flight bytes in prometheus output")
+	}
+
+	_, uncappedSink := run(0)
+	if cappedSink.peakHeap*4 > uncappedSink.peakHeap {
+		t.Errorf("peak heap with cap %d, without %d; want the cap to keep it well below", cappedSink.peakHeap, uncappedSink.peakHeap)
+	}
+}
+
+// fullDiskWriter accepts room records and then fails each write the way a
+// file sink does on a full disk.
+type fullDiskWriter struct {
+	mu       sync.Mutex
+	room     int
+	attempts int
+}
+
+func (w *fullDiskWriter) Write(interface{}) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.attempts++
+	if w.room == 0 {
+		return fmt.Errorf("%w: %w: write out.jsonl: no space left on device", sink.ErrWriteSink, sink.ErrDiskFull)
+	}
+	w.room--
+	return nil
+}
+
+func (w *fullDiskWriter) Close() error { return nil }
+
+func TestRunPipeline_DiskFullAbortsWithoutRetries(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 1000; i++ {
+		input.WriteString(`{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"boom","service":"api"}` + "\n")
+	}
+	cfg := config.Default()
+	cfg.MaxWorkers = 1
+	cfg.QueueSize = 4
+	cfg.BatchSize = 0
+	cfg.SinkMaxRetries = 5
+	cfg.SinkBackoffBaseMS = 1000
+	// The report cannot be written either, so it goes to stdout.
+	cfg.ReportPath = filepath.Join(t.TempDir(), "missing", "report.json")
+
+	stdout := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = w
+	printed := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(r)
+		printed <- data
+	}()
+
+	out := &fullDiskWriter{room: 3}
+	rep := report.NewReport()
+	start := time.Now()
+	err = runPipeline(withBaseSink(context.Background(), out), strings.NewReader(input.String()), cfg, rep)
+	os.Stdout = stdout
+	w.Close()
+	stdoutReport := <-printed
+
+	if !errors.Is(err, sink.ErrDiskFull) {
+		t.Fatalf("err = %v, want ErrDiskFull", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("run took %v; a full disk should not be retried", elapsed)
+	}
+	if rep.WrittenOK != 3 || rep.RetryStats.TotalRetries != 0 || out.attempts > 3+cfg.QueueSize+2 {
+		t.Errorf("ok %d, retries %d, attempts %d", rep.WrittenOK, rep.RetryStats.TotalRetries, out.attempts)
+	}
+	if rep.Shutdown.Reason != "disk_full" || rep.Shutdown.LinesNotEnqueued == 0 {
+		t.Errorf("shutdown = %+v, want disk_full with the rest of the input left", rep.Shutdown)
+	}
+	if !bytes.Contains(stdoutReport, []byte(`"reason": "disk_full"`)) {
+		t.Errorf("report not written to stdout:\n%s", stdoutReport)
+	}
+}
+
+func TestRunPipeline_RouterSendsRecordsByNamespace(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.OutputType = "router"
+	cfg.RouterRoutes = []string{
+		"payments=file:" + filepath.Join(dir, "payments.jsonl"),
+		"search=file:" + filepath.Join(dir, "search.jsonl"),
+	}
+	cfg.RouterRules = []string{"namespace=payments -> payments", "namespace=search service=indexer -> search"}
+	cfg.DLQPath = filepath.Join(dir, "dlq.jsonl")
+	cfg.ReportPath = filepath.Join(dir, "report.json")
+	if err := config.Validate(cfg); err != nil {
+		t.Fatal(err)
+	}
+	input := strings.Join([]string{
+		`{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"card declined","service":"api","namespace":"payments"}`,
+		`{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"shard down","service":"indexer","namespace":"search"}`,
+		`{"ts":"2024-01-01T12:00:00Z","level":"WARN","msg":"slow query","service":"api","namespace":"search"}`,
+		`{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"refund failed","service":"api","namespace":"payments"}`,
+	}, "\n")
+	rep := report.NewReport()
+	if err := runPipeline(context.Background(), strings.NewReader(input), cfg, rep); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+	for name, want := range map[string]int{"payments.jsonl": 2, "search.jsonl": 1, "dlq.jsonl": 1} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := bytes.Count(data, []byte("\n")); n != want {
+			t.Errorf("%s has %d records, want %d", name, n, want)
+		}
+	}
+	dlq, _ := os.ReadFile(cfg.DLQPath)
+	if !bytes.Contains(dlq, []byte(`"reason":"unroutable"`)) || !bytes.Contains(dlq, []byte("slow query")) {
+		t.Errorf("DLQ entry %s", dlq)
+	}
+	rt := rep.Router
+	if rt == nil || len(rt.Routes) != 2 || rt.Routes[0].Records != 2 || rt.Routes[1].Records != 1 || rt.Unroutable != 1 {
+		t.Fatalf("router stats %+v", rt)
+	}
+	if !strings.Contains(rep.Prometheus(), `etl_router_records_total{route="payments"} 2`) {
+		t.Error("routed records missing from the metrics")
+	}
+}
+
+func TestRunPipeline_OutputPathTemplate(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.ReportPath = filepath.Join(t.TempDir(), "report.json")
+	cfg.OutputType = "file"
+	cfg.OutputPath = filepath.Join(dir, "out", "{namespace}", "{service}", "{date}.jsonl")
+	cfg.OutputPathMaxOpen = 1
+	if err := config.Validate(cfg); err != nil {
+		t.Fatal(err)
+	}
+	input := strings.Join([]string{
+		`{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"one","service":"api","namespace":"payments"}`,
+		`{"ts":"2024-01-02T12:00:00Z","level":"ERROR","msg":"two","service":"api","namespace":"payments"}`,
+		`{"ts":"2024-01-01T13:00:00Z","level":"ERROR","msg":"three","service":"api","namespace":"payments"}`,
+		`{"ts":"2024-01-01T12:00:00Z","level":"WARN","msg":"four","service":"../indexer","namespace":"search"}`,
+	}, "\n")
+	rep := report.NewReport()
+	if err := runPipeline(context.Background(), strings.NewReader(input), cfg, rep); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+	want := map[string]int{
+		"out/payments/api/2024-01-01.jsonl":     2,
+		"out/payments/api/2024-01-02.jsonl":     1,
+		"out/search/__indexer/2024-01-01.jsonl": 1,
+	}
+	for name, n := range want {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := bytes.Count(data, []byte("\n")); got != n {
+			t.Errorf("%s has %d records, want %d", name, got, n)
+		}
+	}
+	o := rep.OutputPaths
+	if o == nil || len(o.Created) != 3 || o.Created[0] != filepath.Join(dir, "out/payments/api/2024-01-01.jsonl") || o.Template != cfg.OutputPath {
+		t.Fatalf("output_paths = %+v", o)
+	}
+	if !strings.Contains(rep.Prometheus(), "etl_output_paths 3") {
+		t.Error("resolved paths missing from the metrics")
+	}
+
+	cfg.OutputAtomic = true
+	cfg.OutputFields = []string{"message"}
+	err := config.Validate(cfg)
+	for _, msg := range []string{"need an output path without placeholders", "cannot be used with output_fields"} {
+		if err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("Validate = %v, want it to mention %s", err, msg)
+		}
+	}
+}
+
+func TestRunPipeline_SanitizeMessages(t *testing.T) {
+	cfg := dlqLimitConfig(t)
+	cfg.SanitizeMessages = true
+	input := `{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"clean","service":"api"}` + "\n" +
+		`{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"two\nlines\u001b[0m","service":"api"}` + "\n" +
+		`{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"ok","service":"api","path":"a\u0000b"}` + "\n"
+	w := &flakyWriter{}
+	rep := report.NewReport()
+	if err := runPipeline(withBaseSink(context.Background(), w), strings.NewReader(input), cfg, rep); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+	if got := strings.Join(w.messages(), "|"); got != `clean|two\nlines[0m|ok` {
+		t.Errorf("messages %q", got)
+	}
+	if rep.Sanitized != 2 {
+		t.Errorf("sanitized %d, want 2", rep.Sanitized)
+	}
+}
+
+func TestRunPipeline_RecordLag(t *testing.T) {
+	cfg := dlqLimitConfig(t)
+	now := time.Now().UTC()
+	var input strings.Builder
+	for _, ts := range []time.Time{now.Add(-time.Hour), now.Add(-2 * time.Hour), now.Add(24 * time.Hour)} {
+		fmt.Fprintf(&input, `{"ts":%q,"level":"ERROR","msg":"m","service":"api"}`+"\n", ts.Format(time.RFC3339Nano))
+	}
+	rep := report.NewReport()
+	if err := runPipeline(withBaseSink(context.Background(), &flakyWriter{}), strings.NewReader(input.String()), cfg, rep); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+	lag := rep.RecordLag
+	if lag.Records != 2 || lag.Future != 1 {
+		t.Fatalf("record lag %+v, want 2 records and 1 in the future", lag)
+	}
+	if lag.MinSeconds < 3600 || lag.MaxSeconds < 7200 || lag.MaxSeconds > 7200+60 || lag.LastSeconds != lag.MaxSeconds {
+		t.Errorf("record lag %+v, want an hour to two", lag)
+	}
+}
+
+func TestRunPipeline_ReportMaxLabelValues(t *testing.T) {
+	var input strings.Builder
+	const lines = 3000
+	for i := 0; i < lines; i++ {
+		fmt.Fprintf(&input, `{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"done","service":"job-%08x","kubernetes":{"namespace_name":"ns-%d"}}`+"\n", i*2654435761, i%3)
+	}
+	for _, tc := range []struct {
+		max, services int
+		truncated     string
+	}{
+		{max: 50, services: 51, truncated: "service"},
+		{max: -1, services: lines},
+	} {
+		cfg := config.Default()
+		cfg.ReportPath = filepath.Join(t.TempDir(), "report.json")
+		cfg.ReportMaxLabelValues = tc.max
+		rep := report.NewReport()
+		if err := runPipeline(withBaseSink(context.Background(), sink.NewMemorySink()), strings.NewReader(input.String()), cfg, rep); err != nil {
+			t.Fatalf("runPipeline: %v", err)
+		}
+		if len(rep.ByService) != tc.services || len(rep.ByNamespace) != 3 || strings.Join(rep.LabelsTruncated, ",") != tc.truncated {
+			t.Errorf("max %d: %d services, namespaces %v, truncated %v", tc.max, len(rep.ByService), rep.ByNamespace, rep.LabelsTruncated)
+		}
+		if tc.truncated != "" && rep.ByService[report.OtherLabel] != lines-50 {
+			t.Errorf("max %d: %s = %d", tc.max, report.OtherLabel, rep.ByService[report.OtherLabel])
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/fsutil"
+	"k8s-log-etl/internal/plugins"
+	"k8s-log-etl/internal/sink"
+	"k8s-log-etl/internal/stages"
+)
+
+// preflight checks, before any input is read, what would otherwise only
+// fail once the run is under way: that the transforms build, that the JSON
+// schema compiles, that the sink can be opened, and that the output,
+// report, and DLQ files can be written. With cfg.Preflight the sink is probed too. Every problem is
+// reported at once, in the format of config.Validate.
+func preflight(ctx context.Context, cfg config.Config) error {
+	problems := pathProblems(cfg)
+	problems = append(problems, errorLines("", plugins.CheckTransforms(cfg))...)
+	if cfg.JSONSchemaFile != "" {
+		if _, err := stages.LoadSchema(cfg.JSONSchemaFile); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	problems = append(problems, errorLines("sink: ", sink.Check(ctx, cfg, cfg.Preflight))...)
+	if len(problems) > 0 {
+		return fmt.Errorf("preflight check failed:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
+
+// errorLines lists the errors joined in err, each behind prefix.
+func errorLines(prefix string, err error) []string {
+	if err == nil {
+		return nil
+	}
+	errs := []error{err}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = joined.Unwrap()
+	}
+	lines := make([]string, len(errs))
+	for i, e := range errs {
+		lines[i] = prefix + e.Error()
+	}
+	return lines
+}
+
+// pathProblems checks that the output, report, and DLQ files can be
+// written. Missing directories are created, and a probe file is written
+// to each directory and removed. Standard output and remote targets are
+// skipped, as is everything when validate_paths is false.
+func pathProblems(cfg config.Config) []string {
+	if !cfg.ValidatePathsEnabled() {
+		return nil
+	}
+	perm := cfg.FilePerm()
+	checked := make(map[string]error)
+	var problems []string
+	checkDir := func(key, path, dir string) {
+		err, ok := checked[dir]
+		if !ok {
+			err = probeDir(dir, perm)
+			checked[dir] = err
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s %s: %v", key, path, err))
+		}
+	}
+	check := func(key, path string) {
+		if path != "" && path != "-" {
+			checkDir(key, path, filepath.Dir(path))
+		}
+	}
+	switch strings.ToLower(cfg.OutputType) {
+	case "file", "rotate", "rotating":
+		if cfg.OutputPathTemplated() {
+			// The directory the placeholders start in, e.g. out for
+			// out/{namespace}/{date}.jsonl.
+			prefix := cfg.OutputPath[:strings.IndexByte(cfg.OutputPath, '{')]
+			checkDir("output", cfg.OutputPath, filepath.Dir(prefix+"_"))
+		} else {
+			check("output", cfg.OutputPath)
+		}
+	}
+	check("report", cfg.ReportPath)
+	check("report_md", cfg.ReportMarkdownPath)
+	check("report_ops_path", cfg.ReportOpsPath)
+	check("report_quality_path", cfg.ReportQualityPath)
+	if !strings.HasPrefix(cfg.DLQPath, "s3://") {
+		check("dlq", cfg.DLQPath)
+	}
+	return problems
+}
+
+// probeDir creates dir if it is missing and checks that a file can be
+// created in it.
+func probeDir(dir string, perm fsutil.Perm) error {
+	if err := perm.MkdirAll(dir); err != nil {
+		return fmt.Errorf("cannot create directory: %w", pathErr(err))
+	}
+	f, err := os.CreateTemp(dir, ".etl-preflight-*")
+	if err != nil {
+		return fmt.Errorf("directory %s is not writable: %w", dir, pathErr(err))
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
+
+// pathErr drops the operation and path from a *fs.PathError, which the
+// caller already names.
+func pathErr(err error) error {
+	var pe *os.PathError
+	if errors.As(err, &pe) {
+		return pe.Err
+	}
+	return err
+}
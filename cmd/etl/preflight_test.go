@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"k8s-log-etl/internal/config"
+)
+
+func TestPathProblemsCreatesDirectories(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.OutputType = "file"
+	cfg.OutputPath = filepath.Join(dir, "out", "records.jsonl")
+	cfg.ReportPath = filepath.Join(dir, "reports", "nested", "report.json")
+	cfg.DLQPath = filepath.Join(dir, "out", "dlq.jsonl")
+	if problems := pathProblems(cfg); len(problems) > 0 {
+		t.Fatalf("path problems: %q", problems)
+	}
+	for _, sub := range []string{"out", "reports/nested"} {
+		entries, err := os.ReadDir(filepath.Join(dir, sub))
+		if err != nil {
+			t.Fatalf("expected %s to be created: %v", sub, err)
+		}
+		if len(entries) != 0 {
+			t.Errorf("probe files left in %s: %v", sub, entries)
+		}
+	}
+}
+
+func TestPathProblemsReportsEveryProblem(t *testing.T) {
+	dir := t.TempDir()
+	// A regular file where a directory should be cannot be worked around,
+	// even by root.
+	blocker := filepath.Join(dir, "blocker")
+	if err := os.WriteFile(blocker, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Default()
+	cfg.OutputType = "file"
+	cfg.OutputPath = filepath.Join(blocker, "out.jsonl")
+	cfg.ReportPath = filepath.Join(blocker, "sub", "report.json")
+	cfg.DLQPath = filepath.Join(dir, "ok", "dlq.jsonl")
+	msg := strings.Join(pathProblems(cfg), "\n")
+	for _, want := range []string{"output " + cfg.OutputPath, "report " + cfg.ReportPath} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("error %q does not mention %q", msg, want)
+		}
+	}
+	if strings.Contains(msg, "dlq") {
+		t.Errorf("error %q mentions the writable DLQ path", msg)
+	}
+}
+
+func TestPathProblemsReadOnlyDirectory(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows file modes only carry the read-only attribute")
+	}
+	if os.Geteuid() == 0 {
+		t.Skip("root can write to read-only directories")
+	}
+	dir := filepath.Join(t.TempDir(), "ro")
+	if err := os.Mkdir(dir, 0o555); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chmod(dir, 0o755) })
+	cfg := config.Default()
+	cfg.ReportPath = filepath.Join(dir, "report.json")
+	cfg.DLQPath = filepath.Join(dir, "new", "dlq.jsonl")
+	if msg := strings.Join(pathProblems(cfg), "\n"); !strings.Contains(msg, "not writable") || !strings.Contains(msg, "cannot create directory") {
+		t.Errorf("unexpected error %q", msg)
+	}
+}
+
+func TestPathProblemsSkips(t *testing.T) {
+	blocked := filepath.Join(t.TempDir(), "blocker")
+	if err := os.WriteFile(blocked, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Default()
+	cfg.OutputType = "http"
+	cfg.OutputPath = "http://collector:8080/logs"
+	cfg.ReportPath = "-"
+	cfg.DLQPath = "s3://bucket/dlq.jsonl"
+	if problems := pathProblems(cfg); len(problems) > 0 {
+		t.Fatalf("path problems: %q", problems)
+	}
+
+	cfg = config.Default()
+	cfg.ReportPath = filepath.Join(blocked, "report.json")
+	off := false
+	cfg.ValidatePaths = &off
+	if problems := pathProblems(cfg); len(problems) > 0 {
+		t.Fatalf("validate_paths false still checked: %q", problems)
+	}
+}
+
+func TestPreflightAggregatesProblems(t *testing.T) {
+	blocker := filepath.Join(t.TempDir(), "blocker")
+	if err := os.WriteFile(blocker, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Default()
+	cfg.Transforms = []string{"filter_redcat"}
+	cfg.OutputType = "http"
+	cfg.OutputPath = "collector:8080"
+	cfg.ReportPath = filepath.Join(blocker, "report.json")
+	err := preflight(context.Background(), cfg)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	msg := err.Error()
+	for _, want := range []string{
+		"preflight check failed:\n  - ",
+		"\n  - report " + cfg.ReportPath,
+		"\n  - unknown transform \"filter_redcat\" (registered: ",
+		"\n  - sink: open sink: output \"collector:8080\" is not an http or https URL",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("error %q does not contain %q", msg, want)
+		}
+	}
+
+	if err := preflight(context.Background(), config.Default()); err != nil {
+		t.Fatalf("default config: %v", err)
+	}
+}
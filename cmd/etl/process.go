@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/logger"
+	"k8s-log-etl/internal/model"
+	"k8s-log-etl/internal/plugins"
+	"k8s-log-etl/internal/report"
+	"k8s-log-etl/internal/source"
+	"k8s-log-etl/internal/stages"
+)
+
+// process is the process stage of one input line: it parses the line and
+// takes each record through unwrapping, schema validation, normalization,
+// drop rules, and the transforms, queueing the ones that pass. It reports
+// whether reading should stop.
+func (r *readStage) process(ctx context.Context, rec source.Record, lineAck *inputAck) (stop bool) {
+	line, src := rec.Data, rec.Origin
+	if large := rec.Large; large != nil {
+		r.largeLines.LargestBytes = max(r.largeLines.LargestBytes, large.Size)
+		if large.TooLong {
+			r.largeLines.TooLong++
+			r.rep.AddJSONFailed()
+			logger.WarnContext(lineContext(ctx, r.lineNum), "line longer than max_line_bytes skipped", "bytes", large.Size, "max_line_bytes", r.cfg.MaxLineBytes, "line", r.lineNum)
+			return false
+		}
+		r.largeLines.Pruned++
+		r.largeLines.DroppedKeys += large.Dropped
+		logger.DebugContext(lineContext(ctx, r.lineNum), "line longer than line_prune_bytes pruned", "bytes", large.Size, "dropped_keys", large.Dropped, "line", r.lineNum)
+	}
+
+	// Track parsing time. The parser reuses its maps across lines:
+	// Normalize copies what it keeps and DLQ writes encode synchronously.
+	parseStart := r.timer.start()
+	records, err := r.parser.Parse(line)
+	r.timer.record("parsing", parseStart)
+	if err != nil {
+		r.rep.AddJSONFailed()
+		logger.DebugContext(lineContext(ctx, r.lineNum), "JSON parse failed", "error", err, "line", r.lineNum)
+		return false
+	}
+	r.rep.AddJSONParsed(len(records))
+	if r.cfg.StrictJSON {
+		if key, found := stages.DuplicateKey(line); found {
+			r.dupKeys.Lines++
+			r.dupKeys.ByKey[key]++
+			lineCtx := lineContext(ctx, r.lineNum)
+			logger.WarnContext(lineCtx, "duplicate key in input line", "key", key, "line", r.lineNum)
+			if r.cfg.DLQDuplicateKeys && r.dlq != nil {
+				// The raw line is the only copy that still has both values.
+				for _, js := range records {
+					rec := dlqRecord{Raw: js, rawJSON: rawInput(line, len(records), js), Line: r.lineNum, Source: &src, Stage: dlqStageParse,
+						Reason: "duplicate_key:" + key, Error: fmt.Sprintf("duplicate key %q", key), Attempts: 1, RunID: r.rep.RunID, ack: lineAck}
+					if r.abortErr = writeDLQ(lineCtx, r.dlq, rec, r.cfg, r.rep); r.abortErr != nil {
+						break
+					}
+					r.dupKeys.DeadLettered++
+				}
+				if r.abortErr != nil {
+					logger.ErrorContext(lineCtx, "aborting pipeline", "error", r.abortErr, "line", r.lineNum)
+					return true
+				}
+				return false
+			}
+		}
+	}
+
+	for _, js := range records {
+		var raw json.RawMessage
+		if r.captureRaw {
+			raw = rawInput(line, len(records), js)
+		}
+		if r.processRecord(ctx, js, raw, src, lineAck) {
+			break
+		}
+	}
+	return r.abortErr != nil || r.shutdownRequested || r.limitReached()
+}
+
+// processRecord takes one record parsed from the current line through the
+// process stage and queues it if it passes. raw is the record as read,
+// when the DLQ keeps it. It reports whether the rest of the line's records
+// should be left.
+func (r *readStage) processRecord(ctx context.Context, js map[string]any, raw json.RawMessage, src source.Origin, lineAck *inputAck) (stop bool) {
+	// Track normalization time
+	normStart := r.timer.start()
+	if len(r.unwrapOpts.Keys) > 0 {
+		switch stages.Unwrap(js, r.unwrapOpts) {
+		case stages.UnwrapJSON:
+			r.rep.AddUnwrap(report.UnwrapStats{JSON: 1})
+		case stages.UnwrapText:
+			r.rep.AddUnwrap(report.UnwrapStats{Text: 1})
+		case stages.UnwrapFailed:
+			r.rep.AddUnwrap(report.UnwrapStats{Failed: 1})
+		}
+	}
+	traced := r.tracer.matchParsed(js)
+	if traced {
+		traceStage(ctx, r.lineNum, "parsed", "record", js)
+	}
+	if r.schema != nil {
+		r.schemaStats.Checked++
+		if verr := r.schema.Validate(js); verr != nil {
+			r.schemaStats.Invalid++
+			var serr *stages.SchemaError
+			if errors.As(verr, &serr) {
+				for _, v := range serr.Violations {
+					r.schemaStats.AddViolation(v.Key())
+				}
+			}
+			recordCtx := lineContext(ctx, r.lineNum)
+			logger.DebugContext(recordCtx, "record failed json schema", "error", verr, "line", r.lineNum)
+			if traced {
+				decision := "keep"
+				if r.schemaAction != config.SchemaWarn {
+					decision = r.schemaAction
+				}
+				traceStage(ctx, r.lineNum, "schema", "decision", decision, "error", verr.Error())
+			}
+			if r.schemaAction == config.SchemaDrop {
+				r.timer.record("normalization", normStart)
+				r.schemaStats.Dropped++
+				return false
+			}
+			if r.schemaAction == config.SchemaDLQ {
+				r.timer.record("normalization", normStart)
+				rec := dlqRecord{Raw: js, Line: r.lineNum, Source: &src, Stage: dlqStageSchema, Reason: stages.ReasonSchema, Error: verr.Error(), Attempts: 1, RunID: r.rep.RunID, ack: lineAck}
+				if raw != nil {
+					rec.Raw, rec.rawJSON = nil, raw
+				}
+				if err := writeDLQ(recordCtx, r.dlq, rec, r.cfg, r.rep); err != nil {
+					r.abortErr = err
+					logger.ErrorContext(recordCtx, "aborting pipeline", "error", r.abortErr, "line", r.lineNum)
+					return true
+				}
+				r.schemaStats.DeadLettered++
+				return false
+			}
+		}
+	}
+	normalized, normerr := stages.NormalizeWith(js, r.normOpts)
+	if normerr == nil && r.cfg.SanitizeMessages && stages.SanitizeRecord(&normalized) {
+		r.rep.AddSanitized()
+	}
+	r.timer.record("normalization", normStart)
+	if normerr != nil {
+		if traced {
+			traceStage(ctx, r.lineNum, "normalized", "decision", "drop", "error", normerr.Error())
+		}
+		code := ""
+		var nerr *stages.NormalizeError
+		if errors.As(normerr, &nerr) {
+			code = nerr.Code()
+		}
+		r.rep.AddNormalizeFailure(code)
+		recordCtx := lineContext(ctx, r.lineNum)
+		logger.WarnContext(recordCtx, "normalization failed", "error", normerr, "line", r.lineNum)
+		if r.cfg.DLQNormalizeFailures && r.dlq != nil {
+			reason := normalizeDLQReason(code)
+			rec := dlqRecord{Raw: js, Line: r.lineNum, Source: &src, Stage: dlqStageNormalize, Reason: reason, Error: normerr.Error(), Attempts: 1, RunID: r.rep.RunID, ack: lineAck}
+			if raw != nil {
+				rec.Raw, rec.rawJSON = nil, raw
+			}
+			if err := writeDLQ(recordCtx, r.dlq, rec, r.cfg, r.rep); err != nil {
+				r.abortErr = err
+				logger.ErrorContext(recordCtx, "aborting pipeline", "error", r.abortErr, "line", r.lineNum)
+				return true
+			}
+		}
+		return false
+	}
+
+	r.rep.AddNormalizedOK()
+	r.rep.AddLevel(normalized.Level)
+	r.rep.AddService(normalized.Service)
+	r.rep.AddNamespace(normalized.Namespace)
+	r.rep.AddDistinct(normalized.Service, normalized.Namespace, normalized.Pod, normalized.TraceID)
+	r.rep.AddRecordLag(r.clock.Now().Sub(normalized.Time))
+	r.rep.AddMessage(normalized.Message)
+	r.rep.AddErrorDetails(normalized.Error != "", normalized.Stacktrace != "")
+	if !traced && r.tracer.matchNormalized(normalized) {
+		traced = true
+		traceStage(ctx, r.lineNum, "parsed", "record", js)
+	}
+	if traced {
+		traceStage(ctx, r.lineNum, "normalized", "decision", "keep", "record", normalized)
+	}
+	if r.dropRules != nil && r.dropRules.match(normalized.Message) {
+		r.rep.AddFiltered(stages.ReasonDropRule)
+		if r.cfg.TransformAudit {
+			r.rep.AddTransformAudit(stages.ReasonDropRule, false)
+		}
+		if traced {
+			traceStage(ctx, r.lineNum, "drop_rules", "decision", "drop", "reason", stages.ReasonDropRule)
+		}
+		return false
+	}
+
+	normalized, skipped := r.transform(ctx, normalized, traced, raw, src, lineAck)
+	if r.abortErr != nil {
+		logger.ErrorContext(lineContext(ctx, r.lineNum), "aborting pipeline", "error", r.abortErr, "line", r.lineNum)
+		return true
+	}
+	if skipped {
+		return false
+	}
+
+	if r.cfg.StampRunMetadata {
+		if normalized.Fields == nil {
+			normalized.Fields = make(map[string]any)
+		}
+		normalized.Fields["_etl_run_id"] = r.rep.RunID
+		normalized.Fields["_etl_host"] = r.rep.Hostname
+	}
+	if r.cfg.StampProvenance {
+		if normalized.Fields == nil {
+			normalized.Fields = make(map[string]any)
+		}
+		if src.File != "" {
+			normalized.Fields["_src_file"] = src.File
+		}
+		normalized.Fields["_src_line"] = src.Line
+	}
+
+	item := workItem{record: normalized, raw: raw, line: r.lineNum, src: src, trace: traced, ack: lineAck}
+	lineAck.hold()
+	if r.inflight != nil {
+		item.size = normalized.ApproxSize() + int64(len(raw))
+	}
+	if r.reorder == nil {
+		return !r.enqueue(ctx, item) || r.limitReached()
+	}
+	ts, _ := normalized.EventTime()
+	ready := r.reorder.Push(ts, item)
+	for i, it := range ready {
+		if !r.enqueue(ctx, it) {
+			r.notEnqueued += len(ready) - i - 1
+			break
+		}
+		if r.headReached {
+			break
+		}
+	}
+	return r.shutdownRequested || r.limitReached()
+}
+
+// transform runs normalized through the transforms, applying each one's
+// on_error policy, and returns the record they leave. skipped reports
+// that a transform dropped the record or failed on it; a failure that
+// stops the run sets abortErr.
+func (r *readStage) transform(ctx context.Context, normalized model.Normalized, traced bool, raw json.RawMessage, src source.Origin, lineAck *inputAck) (out model.Normalized, skipped bool) {
+	// Track filtering time
+	filterStart := r.timer.start()
+	// audit lists "name:outcome" for each transform that evaluated the
+	// record; nil unless transform_audit is set.
+	var audit []string
+	if r.cfg.TransformAudit {
+		audit = make([]string, 0, len(r.transforms))
+	}
+	auditStop, auditPassed := "", false
+	for _, tf := range r.transforms {
+		tfStart := time.Now()
+		var before model.Normalized
+		if traced {
+			before = cloneRecord(normalized)
+		}
+		nn, drop, reason, err := tf.Apply(normalized)
+		if traced {
+			switch {
+			case err != nil:
+				traceStage(ctx, r.lineNum, "transform", "transform", tf.Name, "decision", "error", "error", err.Error(), "policy", tf.OnError)
+			case drop:
+				traceStage(ctx, r.lineNum, "transform", "transform", tf.Name, "decision", "drop", "reason", reason)
+			default:
+				traceStage(ctx, r.lineNum, "transform", "transform", tf.Name, "decision", "keep", "changes", recordChanges(before, nn))
+			}
+		}
+		if err != nil {
+			recordCtx := lineContext(ctx, r.lineNum)
+			kind := transformErrorKind(err)
+			var perr *plugins.PanicError
+			if kind == "panic" && errors.As(err, &perr) {
+				// A bad record tends to recur, so each distinct panic
+				// logs its stack once rather than on every record.
+				if key := tf.Name + "\x00" + fmt.Sprint(perr.Value); !r.panicsLogged[key] {
+					r.panicsLogged[key] = true
+					logger.ErrorContext(recordCtx, "transform panicked", "transform", tf.Name, "panic", perr.Value, "line", r.lineNum, "stack", string(perr.Stack))
+				}
+			}
+			r.rep.AddTransformResult(tf.Name, time.Since(tfStart), false, "", kind)
+			r.rep.AddTransformErrorOutcome(tf.Name, tf.OnError)
+			logger.WarnContext(recordCtx, "transform error", "transform", tf.Name, "error", err, "policy", tf.OnError, "line", r.lineNum)
+			if audit != nil {
+				audit = append(audit, tf.Name+":"+kind)
+			}
+			switch tf.OnError {
+			case config.OnErrorPass:
+				// Keep the pre-transform record and run the rest of the chain.
+				auditPassed = true
+				continue
+			case config.OnErrorDLQ:
+				// Validate requires a DLQ for this policy; without one the
+				// record is dropped like the default policy.
+				if audit != nil {
+					stampTransformAudit(&normalized, audit)
+				}
+				if r.dlq == nil {
+					r.rep.AddNormalizedFailed()
+				} else {
+					reason := "transform_error:" + tf.Name
+					r.abortErr = writeDLQ(recordCtx, r.dlq, dlqRecord{Record: &normalized, rawJSON: raw, Line: r.lineNum, Source: &src, Stage: dlqStageTransform,
+						Reason: reason, Error: err.Error(), Attempts: 1, RunID: r.rep.RunID, ack: lineAck}, r.cfg, r.rep)
+				}
+			case config.OnErrorAbort:
+				r.abortErr = fmt.Errorf("transform %s failed on line %d (on_error=abort): %w", tf.Name, r.lineNum, err)
+			default:
+				r.rep.AddNormalizedFailed()
+			}
+			skipped, auditStop = true, tf.Name
+			break
+		}
+		r.rep.AddTransformResult(tf.Name, time.Since(tfStart), drop, reason, "")
+		if drop {
+			r.rep.AddFiltered(reason)
+			if audit != nil {
+				audit = append(audit, tf.Name+":drop")
+			}
+			skipped, auditStop = true, tf.Name
+			break
+		}
+		if audit != nil {
+			audit = append(audit, tf.Name+":keep")
+		}
+		normalized = nn
+	}
+	r.timer.record("filtering", filterStart)
+	if audit != nil {
+		r.rep.AddTransformAudit(auditStop, auditPassed)
+		if !skipped {
+			stampTransformAudit(&normalized, audit)
+		}
+	}
+	return normalized, skipped
+}
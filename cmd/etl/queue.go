@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"k8s-log-etl/internal/report"
+)
+
+// workQueue hands records from the reader to the sink workers, holding up
+// to queue_size of them.
+type workQueue interface {
+	// offer queues item if there is room, without blocking.
+	offer(item workItem) bool
+	// put queues item, blocking while the queue is full. It returns false
+	// if ctx is done first.
+	put(ctx context.Context, item workItem) bool
+	// take returns the next item, blocking while the queue is empty. It
+	// returns false once the queue is closed and empty, or when ctx is
+	// done.
+	take(ctx context.Context) (workItem, bool)
+	// close ends the input; workers take what is queued, then stop.
+	close()
+	len() int
+}
+
+// newWorkQueue returns the queue cfg asks for: FIFO, or by level with
+// queue_priority_by_level.
+func newWorkQueue(size int, byLevel bool) workQueue {
+	if byLevel {
+		return newPriorityQueue(size)
+	}
+	return make(chanQueue, size)
+}
+
+// chanQueue is the FIFO queue, a buffered channel.
+type chanQueue chan workItem
+
+func (q chanQueue) offer(item workItem) bool {
+	select {
+	case q <- item:
+		return true
+	default:
+		return false
+	}
+}
+
+func (q chanQueue) put(ctx context.Context, item workItem) bool {
+	select {
+	case q <- item:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (q chanQueue) take(ctx context.Context) (workItem, bool) {
+	select {
+	case <-ctx.Done():
+		return workItem{}, false
+	case item, ok := <-q:
+		return item, ok
+	}
+}
+
+func (q chanQueue) close()   { close(q) }
+func (q chanQueue) len() int { return len(q) }
+
+// Priority classes of queue_priority_by_level, served in this order.
+const (
+	priorityError = iota // ERROR and FATAL
+	priorityWarn         // WARN and WARNING
+	priorityOther
+	priorityClasses
+)
+
+var priorityClassNames = [priorityClasses]string{"error", "warn", "other"}
+
+func priorityClass(level string) int {
+	switch level {
+	case "ERROR", "FATAL":
+		return priorityError
+	case "WARN", "WARNING":
+		return priorityWarn
+	}
+	return priorityOther
+}
+
+// priorityQueue serves the queued records of the highest class first and
+// each class in arrival order. Like the channel it replaces it holds at
+// most size records, with put blocking while it is full and take while it
+// is empty. Under a steady stream of errors, lower classes wait until it
+// lets up.
+type priorityQueue struct {
+	mu       sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	classes  [priorityClasses][]workItem
+	n, size  int
+	closed   bool
+	waits    [priorityClasses]classWait
+}
+
+// classWait sums the time records of a class spent queued.
+type classWait struct {
+	records int
+	total   time.Duration
+	max     time.Duration
+}
+
+func newPriorityQueue(size int) *priorityQueue {
+	q := &priorityQueue{size: size}
+	q.notEmpty = sync.NewCond(&q.mu)
+	q.notFull = sync.NewCond(&q.mu)
+	return q
+}
+
+func (q *priorityQueue) offer(item workItem) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.n >= q.size {
+		return false
+	}
+	q.push(item)
+	return true
+}
+
+func (q *priorityQueue) put(ctx context.Context, item workItem) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.n >= q.size {
+		defer context.AfterFunc(ctx, q.wakeAll)()
+		for q.n >= q.size && ctx.Err() == nil {
+			q.notFull.Wait()
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+	}
+	q.push(item)
+	return true
+}
+
+// push queues item; the caller holds q.mu and has checked for room.
+func (q *priorityQueue) push(item workItem) {
+	item.queued = time.Now()
+	c := priorityClass(item.record.Level)
+	q.classes[c] = append(q.classes[c], item)
+	q.n++
+	q.notEmpty.Signal()
+}
+
+func (q *priorityQueue) take(ctx context.Context) (workItem, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.n == 0 && !q.closed {
+		defer context.AfterFunc(ctx, q.wakeAll)()
+		for q.n == 0 && !q.closed && ctx.Err() == nil {
+			q.notEmpty.Wait()
+		}
+	}
+	if ctx.Err() != nil || q.n == 0 {
+		return workItem{}, false
+	}
+	for c := range q.classes {
+		if len(q.classes[c]) == 0 {
+			continue
+		}
+		item := q.classes[c][0]
+		q.classes[c][0] = workItem{}
+		q.classes[c] = q.classes[c][1:]
+		q.n--
+		q.notFull.Signal()
+		wait := time.Since(item.queued)
+		w := &q.waits[c]
+		w.records++
+		w.total += wait
+		w.max = max(w.max, wait)
+		return item, true
+	}
+	return workItem{}, false // unreachable: q.n counts the classes' items
+}
+
+// wakeAll wakes every waiter to check its context.
+func (q *priorityQueue) wakeAll() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.notEmpty.Broadcast()
+	q.notFull.Broadcast()
+}
+
+func (q *priorityQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.notEmpty.Broadcast()
+}
+
+func (q *priorityQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.n
+}
+
+// stats returns the queue waits of each class so far.
+func (q *priorityQueue) stats() report.QueueWaitStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	s := report.QueueWaitStats{Classes: make([]report.QueueClassWait, priorityClasses)}
+	for c, w := range q.waits {
+		cw := report.QueueClassWait{Class: priorityClassNames[c], Records: w.records, MaxWaitSeconds: w.max.Seconds()}
+		if w.records > 0 {
+			cw.AvgWaitSeconds = w.total.Seconds() / float64(w.records)
+		}
+		s.Classes[c] = cw
+	}
+	return s
+}
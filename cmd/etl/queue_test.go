@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s-log-etl/internal/model"
+	"k8s-log-etl/internal/report"
+)
+
+func levelItem(level string, line int) workItem {
+	return workItem{line: line, record: model.Normalized{Level: level}}
+}
+
+func TestPriorityQueue_ServesHighestClassFirst(t *testing.T) {
+	q := newPriorityQueue(8)
+	for i, level := range []string{"INFO", "WARN", "ERROR", "DEBUG", "FATAL", "WARNING"} {
+		if !q.offer(levelItem(level, i+1)) {
+			t.Fatalf("offer %d refused", i+1)
+		}
+	}
+	q.close()
+	var lines []int
+	for {
+		item, ok := q.take(context.Background())
+		if !ok {
+			break
+		}
+		lines = append(lines, item.line)
+	}
+	// Each class keeps its arrival order.
+	if fmt.Sprint(lines) != "[3 5 2 6 1 4]" {
+		t.Errorf("took lines %v, want [3 5 2 6 1 4]", lines)
+	}
+	s := q.stats()
+	if len(s.Classes) != 3 || s.Classes[0].Class != "error" || s.Classes[0].Records != 2 || s.Classes[1].Records != 2 || s.Classes[2].Records != 2 {
+		t.Errorf("stats %+v", s)
+	}
+}
+
+func TestPriorityQueue_BoundedLikeChannel(t *testing.T) {
+	q := newPriorityQueue(1)
+	if !q.offer(levelItem("INFO", 1)) {
+		t.Fatal("offer into an empty queue refused")
+	}
+	if q.offer(levelItem("ERROR", 2)) {
+		t.Fatal("offer into a full queue accepted")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if q.put(ctx, levelItem("ERROR", 2)) {
+		t.Fatal("put into a full queue returned before a take")
+	}
+
+	// A take makes room for a blocked put.
+	put := make(chan bool)
+	go func() { put <- q.put(context.Background(), levelItem("ERROR", 3)) }()
+	if item, ok := q.take(context.Background()); !ok || item.line != 1 {
+		t.Fatalf("took %d, %v; want line 1", item.line, ok)
+	}
+	if !<-put {
+		t.Fatal("blocked put failed")
+	}
+	if q.len() != 1 {
+		t.Errorf("len %d, want 1", q.len())
+	}
+}
+
+func TestPriorityQueue_CancelAndCloseWakeTakers(t *testing.T) {
+	q := newPriorityQueue(4)
+	ctx, cancel := context.WithCancel(context.Background())
+	taken := make(chan bool)
+	go func() {
+		_, ok := q.take(ctx)
+		taken <- ok
+	}()
+	cancel()
+	if <-taken {
+		t.Error("take returned an item after its context was cancelled")
+	}
+
+	go func() {
+		_, ok := q.take(context.Background())
+		taken <- ok
+	}()
+	q.close()
+	if <-taken {
+		t.Error("take returned an item from a closed, empty queue")
+	}
+}
+
+func TestPriorityQueue_ConcurrentProducersAndWorkers(t *testing.T) {
+	const producers, perProducer, workers = 4, 250, 3
+	q := newPriorityQueue(16)
+	levels := []string{"INFO", "WARN", "ERROR"}
+
+	var took sync.WaitGroup
+	counts := make([]int, workers)
+	for w := 0; w < workers; w++ {
+		took.Add(1)
+		go func(w int) {
+			defer took.Done()
+			for {
+				if _, ok := q.take(context.Background()); !ok {
+					return
+				}
+				counts[w]++
+			}
+		}(w)
+	}
+	var put sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		put.Add(1)
+		go func(p int) {
+			defer put.Done()
+			for i := 0; i < perProducer; i++ {
+				item := levelItem(levels[i%len(levels)], p*perProducer+i)
+				if !q.offer(item) && !q.put(context.Background(), item) {
+					t.Error("put failed")
+					return
+				}
+			}
+		}(p)
+	}
+	put.Wait()
+	q.close()
+	took.Wait()
+
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+	if total != producers*perProducer {
+		t.Errorf("workers took %d records, want %d", total, producers*perProducer)
+	}
+	dequeued := 0
+	for _, c := range q.stats().Classes {
+		dequeued += c.Records
+	}
+	if dequeued != total || q.len() != 0 {
+		t.Errorf("stats count %d, len %d; want %d, 0", dequeued, q.len(), total)
+	}
+}
+
+// stalledWriter is a flakyWriter that closes stalled when its first write
+// starts and holds its writes until release is closed.
+type stalledWriter struct {
+	flakyWriter
+	stalled, release chan struct{}
+	once             sync.Once
+}
+
+func (w *stalledWriter) Write(record interface{}) error {
+	w.once.Do(func() { close(w.stalled) })
+	<-w.release
+	return w.flakyWriter.Write(record)
+}
+
+// eofSignalReader closes eof once in is exhausted, by when the pipeline
+// has queued every record of it.
+type eofSignalReader struct {
+	io.Reader
+	eof  chan struct{}
+	once sync.Once
+}
+
+func (r *eofSignalReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err == io.EOF {
+		r.once.Do(func() { close(r.eof) })
+	}
+	return n, err
+}
+
+func TestRunPipeline_QueuePriorityDrainsErrorsFirst(t *testing.T) {
+	cfg := dlqLimitConfig(t)
+	cfg.FilterLevels = []string{"INFO", "ERROR"}
+	cfg.QueueSize = 16
+	cfg.QueuePriorityByLevel = true
+
+	// The worker takes the first INFO and stalls on it while the rest of
+	// the input is queued behind it.
+	pr, pw := io.Pipe()
+	r := &eofSignalReader{Reader: pr, eof: make(chan struct{})}
+	w := &stalledWriter{stalled: make(chan struct{}), release: make(chan struct{})}
+	go func() {
+		for i, level := range []string{"INFO", "INFO", "INFO", "INFO", "ERROR", "ERROR"} {
+			fmt.Fprintf(pw, `{"ts":"2024-01-01T12:00:00Z","level":"%s","msg":"m%d","service":"api"}`+"\n", level, i+1)
+			if i == 0 {
+				<-w.stalled
+			}
+		}
+		pw.Close()
+		<-r.eof
+		close(w.release)
+	}()
+
+	rep := report.NewReport()
+	ctx := withBaseSink(context.Background(), w)
+	if err := runPipeline(ctx, r, cfg, rep); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+	if got := strings.Join(w.messages(), ","); got != "m1,m5,m6,m2,m3,m4" {
+		t.Errorf("sink got %s, want the queued ERRORs ahead of the INFOs", got)
+	}
+	q := rep.QueueWait
+	if q == nil || q.Classes[0].Records != 2 || q.Classes[2].Records != 4 {
+		t.Fatalf("queue wait %+v", q)
+	}
+	if !strings.Contains(rep.Prometheus(), `etl_queue_dequeued_total{class="error"} 2`) {
+		t.Error("dequeued errors missing from the metrics")
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync/atomic"
+
+	"k8s-log-etl/internal/clock"
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/logger"
+	"k8s-log-etl/internal/plugins"
+	"k8s-log-etl/internal/report"
+	"k8s-log-etl/internal/source"
+	"k8s-log-etl/internal/stages"
+)
+
+// readStage is the reader's half of a run: it reads the input, processes
+// each line into records, and queues them for the sink workers. It runs on
+// one goroutine; what it counts is published once reading ends.
+type readStage struct {
+	cfg        config.Config
+	rep        *report.Report
+	clock      clock.Clock
+	timer      stageTimer
+	queue      workQueue
+	inflight   *inflightLimiter
+	health     *healthState
+	probe      *benchProbe
+	dlq        *deadLetters // nil without a DLQ
+	schema     *stages.SchemaValidator
+	dropRules  *dropRuleSet
+	transforms []plugins.Named
+
+	// readCtx ends when reading stops on a fatal error, which sinkFatal
+	// returns; durationReached is raised by the max_duration timer.
+	readCtx         context.Context
+	sinkFatal       func() error
+	durationReached *atomic.Bool
+
+	// Set by init.
+	tracer       *recordTracer
+	reorder      *stages.Reorderer[workItem] // nil without sort_window
+	parser       stages.Parser
+	normOpts     stages.NormalizeOptions
+	unwrapOpts   stages.UnwrapOptions
+	captureRaw   bool
+	schemaAction string
+	dupKeys      report.DuplicateKeyStats
+	largeLines   report.LargeLineStats
+	schemaStats  report.SchemaStats
+	panicsLogged map[string]bool
+
+	// Where reading got to, and why it stopped.
+	lineNum           int
+	notEnqueued       int
+	skippedLines      int
+	enqueued          int
+	headReached       bool
+	durationStopped   bool
+	shutdownRequested bool
+	abortErr, scanErr error
+}
+
+// init sets up the per-run state the reader derives from r.cfg.
+func (r *readStage) init() {
+	cfg := r.cfg
+	r.schemaAction = strings.ToLower(cfg.SchemaAction)
+	if r.schemaAction == "" {
+		r.schemaAction = config.SchemaWarn
+	}
+	r.tracer = newRecordTracer(cfg.TraceRecord)
+	r.normOpts = normalizeOptions(cfg)
+	r.unwrapOpts = unwrapOptions(cfg)
+	r.captureRaw = r.dlq != nil && cfg.DLQRaw()
+	r.dupKeys = report.DuplicateKeyStats{ByKey: make(map[string]int)}
+	r.largeLines = report.LargeLineStats{PruneBytes: lineLimits(cfg).Prune, MaxBytes: cfg.MaxLineBytes}
+	r.schemaStats = report.SchemaStats{File: cfg.JSONSchemaFile, Action: r.schemaAction, ByViolation: make(map[string]int)}
+	r.panicsLogged = make(map[string]bool)
+	if window := cfg.SortWindowDuration(); window > 0 {
+		r.reorder = stages.NewReorderer[workItem](window, cfg.SortMaxRecords)
+	}
+}
+
+// read is the read stage: it takes records from input until the input
+// ends, nextCtx ends, a limit is reached, or the run stops, and processes
+// each line. A panic reading or transforming a record stops the input like
+// an abort: what was queued is still written and the report still goes
+// out.
+func (r *readStage) read(ctx, nextCtx context.Context, input source.Source) {
+	r.init()
+	defer func() {
+		if v := recover(); v != nil {
+			r.abortErr = pipelinePanic(ctx, r.rep, "reader", v)
+		}
+	}()
+	// lineAck is the reader's reference to the acknowledgement of the
+	// current input record, released once it moves on.
+	var lineAck *inputAck
+	for {
+		lineAck.release(true)
+		lineAck = nil
+		rec, err := input.Next(nextCtx)
+		if err != nil {
+			// An input that waits for records stops when nextCtx ends:
+			// on max_duration, or as reading stops anyway.
+			if nextCtx.Err() != nil {
+				r.limitReached()
+			} else if err != io.EOF {
+				r.scanErr = err
+			}
+			break
+		}
+		lineAck = newInputAck(rec.Ack)
+		// Check for shutdown signal
+		select {
+		case <-ctx.Done():
+			logger.InfoContext(ctx, "shutdown signal received, finishing in-flight records")
+			r.shutdownRequested = true
+			if len(bytes.TrimSpace(rec.Data)) != 0 {
+				r.notEnqueued++
+				lineAck.fail()
+			}
+		default:
+		}
+		if err := r.sinkFatal(); err != nil && !r.shutdownRequested {
+			r.abortErr = err
+			if len(bytes.TrimSpace(rec.Data)) != 0 {
+				r.notEnqueued++
+				lineAck.fail()
+			}
+		}
+
+		if r.shutdownRequested || r.abortErr != nil {
+			break
+		}
+		if r.limitReached() {
+			// Left for the next run, which skips limits.last_line.
+			if len(bytes.TrimSpace(rec.Data)) != 0 {
+				r.notEnqueued++
+				lineAck.fail()
+			}
+			break
+		}
+
+		// Data is only valid until the next Next; nothing below keeps it.
+		// A line too long to keep has none, but still counts.
+		if len(bytes.TrimSpace(rec.Data)) == 0 && rec.Large == nil {
+			continue
+		}
+
+		r.lineNum++
+		if r.skippedLines < r.cfg.Skip {
+			// Line numbers still count skipped lines, so they match the file.
+			r.skippedLines++
+			continue
+		}
+		r.rep.AddLine()
+		if r.process(ctx, rec, lineAck) {
+			break
+		}
+	}
+	// Reading may have stopped on a record rather than at the end of the
+	// input; the reader's reference to it goes, acknowledging it if it was
+	// queued and written.
+	lineAck.release(true)
+	switch {
+	case r.headReached:
+		logger.InfoContext(ctx, "head limit reached, finishing in-flight records", "head", r.cfg.Head)
+	case r.durationStopped:
+		logger.InfoContext(ctx, "max duration reached, finishing in-flight records", "max_duration", r.cfg.MaxDuration, "last_line", r.lineNum)
+	}
+}
+
+// enqueue hands item to the workers and reports whether it was taken. It
+// waits for room in the queue and under max_inflight_bytes, and gives up,
+// counting item as not enqueued, when the run is cancelled or the sink
+// failed for good. Taking the cfg.Head-th record sets headReached, which
+// ends the input.
+func (r *readStage) enqueue(ctx context.Context, item workItem) bool {
+	cancelled := func() bool {
+		item.ack.release(false)
+		// Workers exit on cancellation, so the queue may never drain.
+		if err := r.sinkFatal(); err != nil {
+			r.abortErr = err
+		} else {
+			logger.InfoContext(ctx, "shutdown signal received, finishing in-flight records")
+			r.shutdownRequested = true
+		}
+		r.notEnqueued++
+		return false
+	}
+	if !r.inflight.acquire(r.readCtx, item.size) {
+		return cancelled()
+	}
+	if !r.queue.offer(item) {
+		r.health.QueueFull(true)
+		if !r.queue.put(r.readCtx, item) {
+			r.inflight.release(item.size)
+			return cancelled()
+		}
+		r.health.QueueFull(false)
+	}
+	r.probe.QueueDepth(r.queue.len())
+	r.enqueued++
+	if r.cfg.Head > 0 && r.enqueued >= r.cfg.Head {
+		r.headReached = true
+	}
+	return true
+}
+
+// limitReached reports whether head or max_duration ends the input,
+// setting durationStopped once max_duration does.
+func (r *readStage) limitReached() bool {
+	if !r.headReached && r.durationReached.Load() {
+		r.durationStopped = true
+	}
+	return r.headReached || r.durationStopped
+}
+
+// finish queues what sort_window still buffers and publishes the read
+// stage's counts to the report, once reading has ended.
+func (r *readStage) finish(ctx context.Context) {
+	if r.cfg.StrictJSON {
+		r.rep.SetDuplicateKeys(r.dupKeys)
+	}
+	if r.largeLines.Pruned+r.largeLines.TooLong > 0 {
+		r.rep.SetLargeLines(r.largeLines)
+	}
+	if r.schema != nil {
+		r.rep.SetSchemaValidation(r.schemaStats)
+	}
+	if r.reorder == nil {
+		return
+	}
+	// Everything buffered was accepted before the input ended, so it is
+	// written even when the run stops on an error, as it would have been
+	// without sorting. Only a cancelled run leaves it behind.
+	pending := r.reorder.Flush()
+	if r.shutdownRequested {
+		r.notEnqueued += len(pending)
+	} else {
+		for i, it := range pending {
+			if r.headReached {
+				break // past the head, so not written by design
+			}
+			if !r.enqueue(ctx, it) {
+				r.notEnqueued += len(pending) - i - 1
+				break
+			}
+		}
+	}
+	st := r.reorder.Stats()
+	r.rep.SetSort(report.SortStats{
+		WindowSeconds: r.cfg.SortWindowDuration().Seconds(),
+		LateRecords:   st.Late,
+		Overflow:      st.Overflow,
+		MaxBuffered:   st.MaxBuffered,
+	})
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/fsutil"
+	"k8s-log-etl/internal/logger"
+	"k8s-log-etl/internal/model"
+	"k8s-log-etl/internal/plugins"
+	"k8s-log-etl/internal/report"
+	"k8s-log-etl/internal/sink"
+	"k8s-log-etl/internal/stages"
+)
+
+// cmdReplay re-sends dead-lettered records to the configured sink.
+func cmdReplay(args []string) error {
+	fs := newFlagSet("replay", "[flags] <dlq-file>",
+		"Re-send records from a dead-letter file to the configured sink, using the\nsame retry settings as run. Normalization failures are re-normalized and\nrun through the configured transforms first. Records that fail again are\nwritten to --dlq when set.")
+	loadConfig := configFlags(fs)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("replay requires exactly one dead-letter file")
+	}
+	path := fs.Arg(0)
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if err := config.Validate(cfg); err != nil {
+		return fmt.Errorf("configuration validation failed: %w", err)
+	}
+	if cfg.DLQPath != "" && fsutil.SamePath(cfg.DLQPath, path) {
+		return fmt.Errorf("--dlq must differ from the replayed file %s", path)
+	}
+	initLogger(cfg)
+	logConfigWarnings(cfg)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), shutdownSignals...)
+	defer cancel()
+	if err := preflight(ctx, cfg); err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open dlq file: %w", err)
+	}
+	defer f.Close()
+
+	rep := report.NewReport()
+	rep.RunID = newRunID()
+	if err := replayDLQ(ctx, f, cfg, rep); err != nil {
+		return err
+	}
+
+	fmt.Printf("Replayed: %d, Written OK: %d, Failed: %d, Normalize Failed: %d, Invalid: %d\n",
+		rep.TotalLines, rep.WrittenOK, rep.WriteFailed, rep.NormalizedFailed, rep.JSONFailed)
+	if failed := rep.WriteFailed + rep.NormalizedFailed; failed > 0 {
+		return fmt.Errorf("%d records failed to replay", failed)
+	}
+	return nil
+}
+
+// replayDLQ writes every record in a dead-letter stream to the sink. Lines
+// are counted in rep.TotalLines; unparseable lines in rep.JSONFailed.
+// Entries holding the raw input are unwrapped, normalized and transformed
+// again, except write failures that also hold the normalized record; ones
+// that still fail are counted in rep.NormalizedFailed.
+func replayDLQ(ctx context.Context, in io.Reader, cfg config.Config, rep *report.Report) (err error) {
+	w, err := openSink(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// The done marker and the caller read the counters.
+		rep.Sync()
+		closeErr := w.Close()
+		switch {
+		case err != nil:
+			if cfg.OutputAtomic {
+				if abortErr := sink.AbortAtomic(cfg.OutputPath); abortErr != nil {
+					logger.ErrorContext(ctx, "error removing temp output", "error", abortErr)
+				}
+			}
+		case closeErr != nil && !cfg.OutputAtomic:
+			err = fmt.Errorf("close sink: %w", closeErr)
+		default:
+			err = finishOutput(cfg, rep, w, closeErr)
+		}
+	}()
+
+	var dlqWriter *deadLetters
+	if cfg.DLQPath != "" {
+		dlq, err := openDLQ(cfg)
+		if err != nil {
+			return fmt.Errorf("open dlq: %w", err)
+		}
+		dlqWriter = newDeadLetters(dlq, cfg)
+		defer func() {
+			if err := dlqWriter.Close(); err != nil {
+				logger.ErrorContext(ctx, "error closing DLQ", "error", err)
+			}
+		}()
+	}
+
+	// Transforms are only needed for raw entries, so build them on first use.
+	var transforms []plugins.Named
+	var transformCloser io.Closer
+	defer func() {
+		if transformCloser != nil {
+			if err := transformCloser.Close(); err != nil {
+				logger.ErrorContext(ctx, "error closing transforms", "error", err)
+			}
+		}
+	}()
+
+	budget := newRetryBudget(cfg.SinkRetryBudgetDuration(), cfg.SinkRetryBudgetWindowDuration(), clockFrom(ctx))
+	if budget != nil {
+		ctx = withRetryBudget(ctx, budget)
+		defer func() { rep.SetRetryBudget(budget.stats()) }()
+	}
+	unwrapOpts := unwrapOptions(cfg)
+	scanner := bufio.NewScanner(in)
+	lineNum := 0
+	for scanner.Scan() {
+		if ctx.Err() != nil {
+			break
+		}
+		line := scanner.Bytes()
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+		lineNum++
+		rep.AddLine()
+
+		var rec dlqRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			rep.AddJSONFailed()
+			logger.WarnContext(ctx, "invalid dlq record", "error", err, "line", lineNum)
+			continue
+		}
+
+		if rec.Truncated {
+			// Replayed as-is: the core fields are intact and the dropped
+			// extra fields are marked with _etl_truncated.
+			logger.WarnContext(ctx, "replaying truncated dlq entry", "line", lineNum, "original_bytes", rec.OriginalBytes)
+		}
+		// An entry with both payloads that failed writing goes straight back
+		// to the sink; any other entry with the input record runs the
+		// pipeline again from normalization.
+		record := rec.Record
+		if rec.Raw != nil && (record == nil || rec.Stage != dlqStageSink) {
+			if len(unwrapOpts.Keys) > 0 {
+				// Raw was taken before unwrap, or is already unwrapped,
+				// which Unwrap leaves alone.
+				stages.Unwrap(rec.Raw, unwrapOpts)
+			}
+			if transformCloser == nil {
+				transforms, transformCloser, err = plugins.BuildTransforms(cfg)
+				if err != nil {
+					return fmt.Errorf("load transforms: %w", err)
+				}
+				names := make([]string, len(transforms))
+				for i, tf := range transforms {
+					names[i] = tf.Name
+				}
+				rep.InitTransforms(names)
+			}
+			n, err := stages.NormalizeWith(rec.Raw, normalizeOptions(cfg))
+			if err != nil {
+				code := ""
+				var nerr *stages.NormalizeError
+				if errors.As(err, &nerr) {
+					code = nerr.Code()
+				}
+				rep.AddNormalizeFailure(code)
+				logger.WarnContext(ctx, "replay normalization failed", "error", err, "line", lineNum)
+				if dlqWriter != nil {
+					reason := normalizeDLQReason(code)
+					if err := writeDLQ(ctx, dlqWriter, dlqRecord{Raw: rec.Raw, Line: rec.Line, Source: rec.Source, Stage: dlqStageNormalize, Reason: reason, Error: err.Error(), Attempts: 1,
+						RunID: rep.RunID, Truncated: rec.Truncated, OriginalBytes: rec.OriginalBytes}, cfg, rep); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			rep.AddNormalizedOK()
+			if cfg.SanitizeMessages && stages.SanitizeRecord(&n) {
+				rep.AddSanitized()
+			}
+			n, dropped, reason, err := applyReplayTransforms(n, transforms, rep)
+			if err != nil {
+				// As in run, where on_error drop also counts the record
+				// as failed normalization.
+				rep.AddNormalizedFailed()
+				logger.WarnContext(ctx, "replay transform failed", "error", err, "line", lineNum)
+				continue
+			}
+			if dropped {
+				rep.AddFiltered(reason)
+				continue
+			}
+			record = &n
+		}
+		if record == nil {
+			rep.AddJSONFailed()
+			logger.WarnContext(ctx, "dlq record has neither record nor raw input", "line", lineNum)
+			continue
+		}
+
+		retries, err := writeWithRetry(ctx, w, *record, cfg, rep)
+		if err != nil {
+			rep.AddWriteFailed()
+			logger.WarnContext(ctx, "replay write failed", "error", err, "retries", retries, "line", lineNum)
+			if errors.Is(err, errRetryBudget) && budget.exhaustedAt(lineNum) {
+				logger.WarnContext(ctx, "sink retry budget exhausted, failed writes are not retried until the next window", "budget", cfg.SinkRetryBudget, "window", cfg.SinkRetryBudgetWindowDuration().String(), "line", lineNum)
+			}
+			if dlqWriter != nil {
+				if err := writeDLQ(ctx, dlqWriter, dlqRecord{Record: record, Raw: rec.Raw, Line: rec.Line, Source: rec.Source, Stage: dlqStageSink, Reason: sinkDLQReason(err), Error: err.Error(),
+					Attempts: retries + 1, RunID: rep.RunID, Truncated: rec.Truncated, OriginalBytes: rec.OriginalBytes}, cfg, rep); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		rep.AddWriteOK()
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("scanner error: %w", err)
+	}
+	return ctx.Err()
+}
+
+// applyReplayTransforms runs n through the transform chain, counting each
+// transform's results in rep as run does. Errors fail the record unless the
+// transform's on_error policy is pass; replay has no per-transform DLQ or
+// abort handling.
+func applyReplayTransforms(n model.Normalized, transforms []plugins.Named, rep *report.Report) (model.Normalized, bool, string, error) {
+	for _, tf := range transforms {
+		start := time.Now()
+		nn, drop, reason, err := tf.Apply(n)
+		if err != nil {
+			rep.AddTransformResult(tf.Name, time.Since(start), false, "", transformErrorKind(err))
+			rep.AddTransformErrorOutcome(tf.Name, tf.OnError)
+			if tf.OnError == config.OnErrorPass {
+				continue
+			}
+			return n, false, "", fmt.Errorf("transform %s: %w", tf.Name, err)
+		}
+		rep.AddTransformResult(tf.Name, time.Since(start), drop, reason, "")
+		if drop {
+			return n, true, reason, nil
+		}
+		n = nn
+	}
+	return n, false, "", nil
+}
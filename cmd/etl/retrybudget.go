@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"k8s-log-etl/internal/clock"
+	"k8s-log-etl/internal/report"
+)
+
+// errRetryBudget marks a failed write that was not retried because
+// sink_retry_budget was spent. The sink error is wrapped with it, so the
+// DLQ reason is still that of the error.
+var errRetryBudget = errors.New("sink retry budget exhausted")
+
+// retryBudget is the backoff time all writes share under
+// sink_retry_budget, per sink_retry_budget_window. Workers draw on it
+// concurrently; once a backoff does not fit, the budget stays exhausted
+// until the window ends, so a sink that is flaky for a long stretch costs
+// each later record one attempt instead of a full retry schedule. Windows
+// follow each other on the budget's clock from when it is made, and each
+// starts with the full budget.
+type retryBudget struct {
+	limit  time.Duration
+	window time.Duration
+	clock  clock.Clock
+
+	mu           sync.Mutex
+	windowStart  time.Time
+	windowSpent  time.Duration
+	exhausted    bool // in the current window
+	warned       bool // in the current window
+	spent        time.Duration
+	windowsSpent int
+	firstLine    int
+	notRetried   int
+}
+
+type retryBudgetKey struct{}
+
+// newRetryBudget returns a budget of limit per window of c, or nil when
+// limit is not positive; a nil budget never runs out.
+func newRetryBudget(limit, window time.Duration, c clock.Clock) *retryBudget {
+	if limit <= 0 {
+		return nil
+	}
+	return &retryBudget{limit: limit, window: window, clock: c, windowStart: c.Now()}
+}
+
+// withRetryBudget makes writeWithRetry draw its backoffs from b.
+func withRetryBudget(ctx context.Context, b *retryBudget) context.Context {
+	if b == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, retryBudgetKey{}, b)
+}
+
+// retryBudgetFrom returns the budget attached to ctx, or nil.
+func retryBudgetFrom(ctx context.Context) *retryBudget {
+	b, _ := ctx.Value(retryBudgetKey{}).(*retryBudget)
+	return b
+}
+
+// roll starts the window now falls in, if the current one is over. b.mu
+// must be held.
+func (b *retryBudget) roll(now time.Time) {
+	elapsed := now.Sub(b.windowStart)
+	if b.window <= 0 || elapsed < b.window {
+		return
+	}
+	b.windowStart = b.windowStart.Add(elapsed / b.window * b.window)
+	b.windowSpent = 0
+	b.exhausted = false
+	b.warned = false
+}
+
+// take spends d of the budget and reports whether it was there. The first
+// backoff that does not fit exhausts the window's budget, and each refusal
+// is a write that goes without its retry.
+func (b *retryBudget) take(d time.Duration) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.roll(b.clock.Now())
+	if !b.exhausted {
+		if b.windowSpent+d <= b.limit {
+			b.windowSpent += d
+			b.spent += d
+			return true
+		}
+		b.exhausted = true
+		b.windowsSpent++
+	}
+	b.notRetried++
+	return false
+}
+
+// exhaustedAt records line as where the window's budget ran out and
+// reports whether this call did, so that only the first caller of each
+// window logs it.
+func (b *retryBudget) exhaustedAt(line int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.warned {
+		return false
+	}
+	b.warned = true
+	if b.firstLine == 0 {
+		b.firstLine = line
+	}
+	return true
+}
+
+// stats returns what the budget has been used for so far.
+func (b *retryBudget) stats() report.RetryBudgetStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.roll(b.clock.Now())
+	return report.RetryBudgetStats{
+		BudgetSeconds:    b.limit.Seconds(),
+		WindowSeconds:    b.window.Seconds(),
+		SpentSeconds:     b.spent.Seconds(),
+		Exhausted:        b.exhausted,
+		ExhaustedWindows: b.windowsSpent,
+		ExhaustedLine:    b.firstLine,
+		WritesNotRetried: b.notRetried,
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s-log-etl/internal/clock"
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/report"
+	"k8s-log-etl/internal/sink"
+)
+
+func TestRunPipeline_RetryBudgetExhausted(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+
+	cfg := config.Default()
+	cfg.MaxWorkers = 1
+	cfg.BatchSize = 0
+	cfg.SinkMaxRetries = 3
+	cfg.SinkBackoffBaseMS = 60_000
+	cfg.SinkBackoffMaxMS = 60_000
+	cfg.SinkRetryBudget = "100s"
+	cfg.DLQPath = filepath.Join(t.TempDir(), "dlq.jsonl")
+	cfg.ReportPath = filepath.Join(t.TempDir(), "report.json")
+	if err := config.Validate(cfg); err != nil {
+		t.Fatal(err)
+	}
+	rep := report.NewReport()
+	done := make(chan error, 1)
+	go func() {
+		ctx := withBaseSink(withClock(context.Background(), fake), &failingWriter{})
+		done <- runPipeline(ctx, strings.NewReader(dlqLimitInput(3)), cfg, rep)
+	}()
+	// The first backoff of line 1, at most 72s with jitter, fits the 100s
+	// budget; its second does not, and nothing after it is retried. The
+	// runtime sampler's ticker is the other waiter.
+	fake.BlockUntil(2)
+	fake.Advance(72 * time.Second)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("runPipeline: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("run still waiting on a backoff past the budget")
+	}
+
+	budget := rep.RetryBudget
+	if !budget.Exhausted || budget.ExhaustedLine != 1 || budget.WritesNotRetried != 3 || budget.BudgetSeconds != 100 || budget.SpentSeconds < 60 || budget.SpentSeconds > 72 {
+		t.Errorf("retry budget %+v", budget)
+	}
+	if rep.RetryStats.TotalRetries != 1 || rep.WriteFailed != 3 || rep.DLQWritten != 3 {
+		t.Errorf("retries %d, failed %d, dlq %d; want 1, 3, 3", rep.RetryStats.TotalRetries, rep.WriteFailed, rep.DLQWritten)
+	}
+	data, err := os.ReadFile(cfg.DLQPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), errRetryBudget.Error()); n != 3 {
+		t.Errorf("%d DLQ entries name the exhausted budget, want 3: %s", n, data)
+	}
+	if !strings.Contains(rep.Prometheus(), "etl_retry_budget_exhausted 1") {
+		t.Error("etl_retry_budget_exhausted missing from the metrics")
+	}
+}
+
+func TestWriteWithRetry_BudgetKeepsSinkError(t *testing.T) {
+	cfg := config.Default()
+	cfg.SinkMaxRetries = 2
+	ctx := withRetryBudget(context.Background(), newRetryBudget(time.Millisecond, time.Hour, clock.Real))
+	retries, err := writeWithRetry(ctx, &failingWriter{}, "test", cfg, report.NewReport())
+	if retries != 0 || !errors.Is(err, errRetryBudget) || !errors.Is(err, sink.ErrWriteSink) {
+		t.Errorf("retries %d, err %v; want no retry and both errors", retries, err)
+	}
+	if sinkDLQReason(err) != "write_error" {
+		t.Errorf("DLQ reason %q", sinkDLQReason(err))
+	}
+}
+
+func TestWriteWithRetry_BudgetStartsOverEachWindow(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+
+	cfg := config.Default()
+	cfg.SinkMaxRetries = 1
+	cfg.SinkBackoffBaseMS = 60_000
+	cfg.SinkBackoffMaxMS = 60_000
+	budget := newRetryBudget(100*time.Second, time.Hour, fake)
+	ctx := withRetryBudget(withClock(context.Background(), fake), budget)
+
+	// write runs writeWithRetry against a sink failing once, letting its
+	// backoff, if it takes one, elapse.
+	write := func(backoff bool) (int, error) {
+		type result struct {
+			retries int
+			err     error
+		}
+		done := make(chan result, 1)
+		go func() {
+			retries, err := writeWithRetry(ctx, &flakyWriter{fails: 1}, "test", cfg, report.NewReport())
+			done <- result{retries, err}
+		}()
+		if backoff {
+			fake.BlockUntil(1)
+			fake.Advance(72 * time.Second)
+		}
+		res := <-done
+		return res.retries, res.err
+	}
+
+	// The first backoff fits the 100s budget, the second does not.
+	if retries, err := write(true); retries != 1 || err != nil {
+		t.Fatalf("first write: %d retries, %v", retries, err)
+	}
+	if retries, err := write(false); retries != 0 || !errors.Is(err, errRetryBudget) {
+		t.Fatalf("second write: %d retries, %v; want the budget spent", retries, err)
+	}
+	if !budget.exhaustedAt(2) || budget.exhaustedAt(3) {
+		t.Error("want one warning per exhausted window")
+	}
+
+	// An hour after the run started, the next window retries again.
+	fake.Advance(time.Hour - fake.Now().Sub(time.Unix(0, 0)))
+	if retries, err := write(true); retries != 1 || err != nil {
+		t.Fatalf("write in the next window: %d retries, %v", retries, err)
+	}
+
+	got := budget.stats()
+	if got.Exhausted || got.ExhaustedWindows != 1 || got.ExhaustedLine != 2 || got.WritesNotRetried != 1 || got.WindowSeconds != 3600 || got.SpentSeconds < 120 || got.SpentSeconds > 144 {
+		t.Errorf("retry budget %+v", got)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"sync/atomic"
+
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/report"
+	"k8s-log-etl/internal/sink"
+)
+
+// routeConfig returns cfg as route name of output type router opens and
+// writes with: its router_route_options applied over the top-level
+// settings.
+func routeConfig(cfg config.Config, name string) config.Config {
+	for _, entry := range cfg.RouterRouteOptions {
+		if o, err := config.ParseRouteOptions(entry); err == nil && o.Route == name {
+			return o.Apply(cfg)
+		}
+	}
+	return cfg
+}
+
+// routeWrites looks up the route of each record of output type router, so
+// its write is retried with the route's settings, and counts the retries
+// and dead letters of each route. A nil *routeWrites finds no route.
+type routeWrites struct {
+	router *sink.RouterSink
+	routes map[string]*routeWrite
+}
+
+// routeWrite is one route's write settings and counts. A nil *routeWrite
+// counts nothing.
+type routeWrite struct {
+	cfg          config.Config
+	retries      atomic.Int64
+	deadLettered atomic.Int64
+}
+
+// newRouteWrites returns the routes of the router in w's chain, or nil
+// when there is none. With aggregation the router sees aggregate rows,
+// not the records workers write, so records keep the top-level settings.
+func newRouteWrites(w sink.Writer, cfg config.Config) *routeWrites {
+	router, ok := sink.Router(w)
+	if !ok || cfg.AggregateWindowSeconds > 0 {
+		return nil
+	}
+	rw := &routeWrites{router: router, routes: make(map[string]*routeWrite)}
+	for _, route := range router.Stats().Routes {
+		rw.routes[route.Name] = &routeWrite{cfg: routeConfig(cfg, route.Name)}
+	}
+	return rw
+}
+
+// lookup returns the route record goes to, or nil when there is none.
+func (rw *routeWrites) lookup(record any) *routeWrite {
+	if rw == nil {
+		return nil
+	}
+	name, ok := rw.router.RouteOf(record)
+	if !ok {
+		return nil
+	}
+	return rw.routes[name]
+}
+
+// config returns the settings to write with: the route's, or cfg when r is
+// nil.
+func (r *routeWrite) config(cfg config.Config) config.Config {
+	if r == nil {
+		return cfg
+	}
+	return r.cfg
+}
+
+// done counts the retries a write to the route took.
+func (r *routeWrite) done(retries int) {
+	if r != nil {
+		r.retries.Add(int64(retries))
+	}
+}
+
+// deadLetter counts a record of the route that went to the DLQ.
+func (r *routeWrite) deadLetter() {
+	if r != nil {
+		r.deadLettered.Add(1)
+	}
+}
+
+// report fills in the settings, retries, and dead letters of each of
+// stats' routes.
+func (rw *routeWrites) report(stats *report.RouterStats) {
+	if rw == nil {
+		return
+	}
+	for i := range stats.Routes {
+		r, ok := rw.routes[stats.Routes[i].Name]
+		if !ok {
+			continue
+		}
+		s := &stats.Routes[i]
+		s.BatchSize, s.MaxRetries = r.cfg.BatchSize, r.cfg.SinkMaxRetries
+		s.Retries, s.DeadLettered = int(r.retries.Load()), int(r.deadLettered.Load())
+	}
+}
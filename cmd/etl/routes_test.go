@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/report"
+)
+
+func TestRunPipeline_RouteByLevel(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.ReportPath = filepath.Join(t.TempDir(), "report.json")
+	cfg.OutputType = "router"
+	cfg.FilterLevels = []string{"INFO", "WARN", "ERROR", "FATAL"}
+	cfg.RouterRoutes = []string{"alerts=http:" + server.URL, "files=file:" + filepath.Join(dir, "out.jsonl")}
+	cfg.RouteByLevel = []string{"error|FATAL -> alerts"}
+	cfg.RouterRouteOptions = []string{"alerts batch_size=0 sink_max_retries=2 sink_backoff_base_ms=1 sink_backoff_max_ms=1"}
+	cfg.RouterDefault = "files"
+	cfg.DLQPath = filepath.Join(dir, "dlq.jsonl")
+	if err := config.Validate(cfg); err != nil {
+		t.Fatal(err)
+	}
+	input := strings.Join([]string{
+		`{"ts":"2024-01-01T12:00:00Z","level":"INFO","msg":"started","service":"api"}`,
+		`{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"card declined","service":"api"}`,
+		`{"ts":"2024-01-01T12:00:00Z","level":"WARN","msg":"slow query","service":"api"}`,
+		`{"ts":"2024-01-01T12:00:00Z","level":"fatal","msg":"out of memory","service":"api"}`,
+	}, "\n")
+	rep := report.NewReport()
+	if err := runPipeline(context.Background(), strings.NewReader(input), cfg, rep); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+
+	out, _ := os.ReadFile(filepath.Join(dir, "out.jsonl"))
+	if n := bytes.Count(out, []byte("\n")); n != 2 || !bytes.Contains(out, []byte("started")) || !bytes.Contains(out, []byte("slow query")) {
+		t.Errorf("files route got %s", out)
+	}
+	// Each alert is sent on its own and written three times, and the http
+	// sink makes each write three requests, all with the route's retries.
+	if n := requests.Load(); n != 18 {
+		t.Errorf("alerts route got %d requests, want 18", n)
+	}
+	dlq, _ := os.ReadFile(cfg.DLQPath)
+	if n := bytes.Count(dlq, []byte(`"reason":"write_error:alerts"`)); n != 2 || !bytes.Contains(dlq, []byte(`"attempts":3`)) {
+		t.Errorf("DLQ entries %s", dlq)
+	}
+	if rep.DLQReasons["write_error:alerts"] != 2 {
+		t.Errorf("DLQ reasons %v", rep.DLQReasons)
+	}
+
+	rt := rep.Router
+	if rt == nil || len(rt.Routes) != 2 {
+		t.Fatalf("router stats %+v", rt)
+	}
+	want := []report.RouteStats{
+		{Name: "alerts", Output: "http:" + server.URL, Failed: 6, BatchSize: 0, MaxRetries: 2, Retries: 4, DeadLettered: 2},
+		{Name: "files", Output: "file:" + filepath.Join(dir, "out.jsonl"), Records: 2, BatchSize: 100, MaxRetries: 3},
+	}
+	for i, w := range want {
+		if rt.Routes[i] != w {
+			t.Errorf("route %d = %+v, want %+v", i, rt.Routes[i], w)
+		}
+	}
+	if !strings.Contains(rep.Prometheus(), `etl_router_dead_lettered_total{route="alerts"} 2`) {
+		t.Error("dead-lettered records missing from the metrics")
+	}
+}
+
+func TestValidate_RouteByLevel(t *testing.T) {
+	cfg := config.Default()
+	cfg.OutputType = "router"
+	cfg.RouterRoutes = []string{"alerts=stdout", "rest=stdout"}
+	cfg.RouteByLevel = []string{"ERROR|FATAL -> alerts", "fatal -> rest", "WARN -> nowhere", "level=INFO -> rest"}
+	cfg.RouterRouteOptions = []string{"alerts batch_size=1", "alerts sink_max_retries=1", "rest retries=2", "rest batch_size=-1"}
+	err := config.Validate(cfg)
+	for _, want := range []string{
+		"route_by_level routes level FATAL twice",
+		`names unknown route "nowhere"`,
+		`invalid route_by_level entry "level=INFO -> rest"`,
+		`router_route_options sets route "alerts" twice`,
+		`setting "retries=2"`,
+		`setting "batch_size=-1"`,
+	} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate = %v, want it to mention %s", err, want)
+		}
+	}
+
+	cfg = config.Default()
+	cfg.RouteByLevel = []string{"ERROR -> alerts"}
+	if err := config.Validate(cfg); err == nil || !strings.Contains(err.Error(), "need output_type router") {
+		t.Errorf("Validate without the router = %v", err)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/fsutil"
+	"k8s-log-etl/internal/logger"
+	"k8s-log-etl/internal/report"
+	"k8s-log-etl/internal/source"
+)
+
+// runManifest is the content of run_manifest_path: what a run was given,
+// written when it starts, and how it ended, added when it ends. Config is
+// the effective configuration with secrets masked; saved on its own it
+// loads back with --config.
+type runManifest struct {
+	RunID     string           `json:"run_id"`
+	Hostname  string           `json:"hostname,omitempty"`
+	BuildInfo report.BuildInfo `json:"build_info"`
+	StartedAt time.Time        `json:"started_at"`
+	Config    config.Config    `json:"config"`
+	Inputs    []manifestInput  `json:"inputs"`
+
+	EndedAt    *time.Time  `json:"ended_at,omitempty"`
+	ExitStatus *int        `json:"exit_status,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	Summary    *runSummary `json:"summary,omitempty"`
+}
+
+// manifestInput is one input file. Bytes and SHA256 are only filled in
+// with run_manifest_checksums.
+type manifestInput struct {
+	Path   string `json:"path"`
+	Bytes  int64  `json:"bytes,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// runSummary is the report's headline counts.
+type runSummary struct {
+	TotalLines       int `json:"total_lines"`
+	JSONParsed       int `json:"json_parsed"`
+	JSONFailed       int `json:"json_failed"`
+	NormalizedOK     int `json:"normalized_ok"`
+	NormalizedFailed int `json:"normalized_failed"`
+	WrittenOK        int `json:"written_ok"`
+	WriteFailed      int `json:"written_failed"`
+	DLQWritten       int `json:"dlq_written"`
+}
+
+// startRunManifest writes the start of the run manifest and returns a
+// func that adds how the run ended, given its error, and writes it again.
+// Both are best-effort: failures are logged and the run goes on. Without
+// run_manifest_path the returned func does nothing.
+func startRunManifest(cfg config.Config, rep *report.Report) func(error) {
+	if cfg.RunManifestPath == "" {
+		return func(error) {}
+	}
+	m := runManifest{
+		RunID:     rep.RunID,
+		Hostname:  rep.Hostname,
+		BuildInfo: rep.BuildInfo,
+		StartedAt: time.Now().UTC(),
+		Config:    cfg.Redacted(),
+		Inputs:    manifestInputs(cfg),
+	}
+	write := func() {
+		if err := writeRunManifest(cfg.FilePerm(), cfg.RunManifestPath, m); err != nil {
+			logger.Warn("failed to write run manifest", "path", cfg.RunManifestPath, "error", err)
+		}
+	}
+	write()
+	return func(runErr error) {
+		ended := time.Now().UTC()
+		status := exitCode(runErr)
+		m.EndedAt, m.ExitStatus = &ended, &status
+		if runErr != nil {
+			m.Error = runErr.Error()
+		}
+		snap := rep.Snapshot()
+		m.Summary = &runSummary{
+			TotalLines:       snap.TotalLines,
+			JSONParsed:       snap.JSONParsed,
+			JSONFailed:       snap.JSONFailed,
+			NormalizedOK:     snap.NormalizedOK,
+			NormalizedFailed: snap.NormalizedFailed,
+			WrittenOK:        snap.WrittenOK,
+			WriteFailed:      snap.WriteFailed,
+			DLQWritten:       snap.DLQWritten,
+		}
+		write()
+	}
+}
+
+// manifestInputs lists the configured input files, glob patterns expanded,
+// hashing each with run_manifest_checksums. A file that cannot be hashed
+// is listed without a checksum; stdin is listed as "-". A NATS consumer
+// is listed by stream and name, leaving out the servers and whatever
+// credentials their URLs hold. The journal is listed as "journald", with
+// its units after a colon when filtered.
+func manifestInputs(cfg config.Config) []manifestInput {
+	if strings.EqualFold(cfg.InputType, config.InputNATS) {
+		return []manifestInput{{Path: "nats:" + cfg.NATSInputStream + "/" + cfg.NATSInputConsumer}}
+	}
+	if strings.EqualFold(cfg.InputType, config.InputJournald) {
+		if len(cfg.JournaldUnits) == 0 {
+			return []manifestInput{{Path: source.JournaldFile}}
+		}
+		return []manifestInput{{Path: source.JournaldFile + ":" + strings.Join(cfg.JournaldUnits, ",")}}
+	}
+	paths := []string{cfg.InputPath}
+	if len(cfg.Inputs) > 0 {
+		expanded, err := expandInputs(cfg.Inputs)
+		if err != nil {
+			expanded = cfg.Inputs
+		}
+		paths = expanded
+	}
+	inputs := make([]manifestInput, 0, len(paths))
+	for _, p := range paths {
+		if p == "" {
+			p = "-"
+		}
+		in := manifestInput{Path: p}
+		if cfg.RunManifestChecksums && p != "-" {
+			n, sum, err := hashFile(p)
+			if err != nil {
+				logger.Warn("failed to checksum input for run manifest", "path", p, "error", err)
+			} else {
+				in.Bytes, in.SHA256 = n, sum
+			}
+		}
+		inputs = append(inputs, in)
+	}
+	return inputs
+}
+
+func hashFile(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeRunManifest writes m to a temp file and renames it into place, so
+// a run killed while writing leaves the previous version intact.
+func writeRunManifest(perm fsutil.Perm, path string, m runManifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return perm.WriteFile(path, append(data, '\n'))
+}
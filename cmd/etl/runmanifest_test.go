@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/report"
+)
+
+func TestRunManifestRoundTripsConfig(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.jsonl")
+	content := []byte(`{"ts":"2024-01-01T00:00:00Z","level":"ERROR","service":"orders","msg":"x"}` + "\n")
+	if err := os.WriteFile(input, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tokenFile := filepath.Join(dir, "grpc-token")
+	if err := os.WriteFile(tokenFile, []byte("t0ken\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.ReadSecretFiles(config.Merge(config.Default(), config.Config{
+		InputPath:            input,
+		OutputType:           "file",
+		OutputPath:           filepath.Join(dir, "out.jsonl"),
+		FilterLevels:         []string{"ERROR"},
+		HTTPAuthToken:        "s3cret",
+		GRPCAuthTokenFile:    tokenFile,
+		RetryJitterSeed:      42,
+		RunManifestPath:      filepath.Join(dir, "run.json"),
+		RunManifestChecksums: true,
+	}))
+	rep := report.NewReport()
+	rep.RunID = "run-1"
+	finish := startRunManifest(cfg, rep)
+
+	var m runManifest
+	readJSON(t, cfg.RunManifestPath, &m)
+	if m.RunID != "run-1" || m.StartedAt.IsZero() || m.EndedAt != nil || m.Summary != nil {
+		t.Errorf("manifest at start = %+v, want run ID and start time only", m)
+	}
+
+	rep.AddLine()
+	finish(fmt.Errorf("pipeline failed: %w", errInputIdle))
+	m = runManifest{}
+	readJSON(t, cfg.RunManifestPath, &m)
+	if m.EndedAt == nil || m.ExitStatus == nil || *m.ExitStatus != exitInputIdle || m.Error == "" {
+		t.Errorf("manifest at end = %+v, want end time, exit status %d, and error", m, exitInputIdle)
+	}
+	if m.Summary == nil || m.Summary.TotalLines != 1 {
+		t.Errorf("summary = %+v, want 1 line", m.Summary)
+	}
+	sum := sha256.Sum256(content)
+	wantInputs := []manifestInput{{Path: input, Bytes: int64(len(content)), SHA256: hex.EncodeToString(sum[:])}}
+	if !reflect.DeepEqual(m.Inputs, wantInputs) {
+		t.Errorf("inputs = %+v, want %+v", m.Inputs, wantInputs)
+	}
+	if m.Config.HTTPAuthToken != config.Masked {
+		t.Errorf("http_auth_token = %q, want it masked", m.Config.HTTPAuthToken)
+	}
+	// A secret read from its file is left out, so the file is read again.
+	if m.Config.GRPCAuthToken != "" || m.Config.GRPCAuthTokenFile != tokenFile {
+		t.Errorf("grpc_auth_token = %q from %q, want only the file", m.Config.GRPCAuthToken, m.Config.GRPCAuthTokenFile)
+	}
+
+	// The config saved on its own loads back into the same config.
+	var raw struct {
+		Config json.RawMessage `json:"config"`
+	}
+	readJSON(t, cfg.RunManifestPath, &raw)
+	saved := filepath.Join(dir, "rerun.json")
+	if err := os.WriteFile(saved, raw.Config, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := config.Load(saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := config.Merge(config.Default(), loaded), cfg.Redacted(); !reflect.DeepEqual(got, want) {
+		t.Errorf("reloaded config differs:\n got %+v\nwant %+v", got, want)
+	}
+	if warns := config.Warnings(loaded); len(warns) > 0 {
+		t.Errorf("reloaded config warnings: %q", warns)
+	}
+	reloaded := config.ReadSecretFiles(config.Merge(config.Default(), loaded))
+	if err := config.Validate(reloaded); err != nil {
+		t.Errorf("reloaded config: %v", err)
+	}
+	if reloaded.GRPCAuthToken != "t0ken" {
+		t.Errorf("reloaded grpc_auth_token = %q, want it read from the file", reloaded.GRPCAuthToken)
+	}
+}
+
+func TestRunManifestIsBestEffort(t *testing.T) {
+	cfg := config.Default()
+	cfg.RunManifestPath = filepath.Join(t.TempDir(), "missing", "run.json")
+	cfg.RunManifestChecksums = true
+	cfg.InputPath = filepath.Join(t.TempDir(), "missing.jsonl")
+	finish := startRunManifest(cfg, report.NewReport())
+	finish(nil)
+	if _, err := os.Stat(cfg.RunManifestPath); !os.IsNotExist(err) {
+		t.Errorf("manifest in a missing directory: stat err = %v", err)
+	}
+}
+
+func readJSON(t *testing.T, path string, v any) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatal(err)
+	}
+}
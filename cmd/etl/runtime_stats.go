@@ -0,0 +1,51 @@
+package main
+
+import (
+	"runtime"
+	"sync"
+	"time"
+
+	"k8s-log-etl/internal/clock"
+	"k8s-log-etl/internal/report"
+)
+
+// runtimeSampleInterval is how often memory stats are sampled. ReadMemStats
+// briefly stops the world, so this stays coarse.
+const runtimeSampleInterval = 10 * time.Second
+
+// startRuntimeSampler records runtime.MemStats into rep every interval on
+// c and once more when stopped. stop is safe to call more than once.
+func startRuntimeSampler(rep *report.Report, interval time.Duration, c clock.Clock) (stop func()) {
+	var base runtime.MemStats
+	runtime.ReadMemStats(&base)
+	sample := func() {
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		rep.AddRuntimeSample(ms.HeapAlloc, ms.TotalAlloc-base.TotalAlloc, ms.NumGC-base.NumGC)
+	}
+
+	ticker := c.NewTicker(interval)
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C():
+				sample()
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			ticker.Stop()
+			close(done)
+			<-exited
+			sample()
+		})
+	}
+}
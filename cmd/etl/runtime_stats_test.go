@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"k8s-log-etl/internal/clock"
+	"k8s-log-etl/internal/report"
+)
+
+func TestRuntimeSampler(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+
+	rep := report.NewReport()
+	stop := startRuntimeSampler(rep, 10*time.Second, fake)
+	fake.BlockUntil(1)
+
+	garbage := make([][]byte, 0, 64)
+	for i := 0; i < 64; i++ {
+		garbage = append(garbage, make([]byte, 64*1024))
+	}
+	fake.Advance(10 * time.Second)
+	deadline := time.Now().Add(time.Second)
+	for strings.Contains(rep.Prometheus(), "etl_runtime_peak_heap_bytes 0\n") && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	stop()
+	stop() // idempotent
+	stats := rep.RuntimeStats
+	if stats.Samples != 2 {
+		t.Errorf("expected one periodic and one final sample, got %d", stats.Samples)
+	}
+	if stats.PeakHeapBytes == 0 || stats.TotalAllocBytes < uint64(len(garbage))*64*1024 {
+		t.Errorf("expected allocations since start to be counted, got %+v", stats)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"time"
+
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/logger"
+)
+
+// startServers starts the health and pprof HTTP servers that cfg enables.
+// Endpoints configured on the same address share one server. The returned
+// context carries the health state for runPipeline; stop shuts every server
+// down.
+func startServers(ctx context.Context, cfg config.Config) (context.Context, func(), error) {
+	muxes := make(map[string]*http.ServeMux)
+	var addrs []string
+	serveMux := func(addr string) *http.ServeMux {
+		if mux, ok := muxes[addr]; ok {
+			return mux
+		}
+		mux := http.NewServeMux()
+		muxes[addr] = mux
+		addrs = append(addrs, addr)
+		return mux
+	}
+	if cfg.HealthListen != "" {
+		health := newHealthState(
+			time.Duration(cfg.HealthStaleSeconds)*time.Second,
+			time.Duration(cfg.HealthQueueFullSeconds)*time.Second,
+		)
+		health.Register(serveMux(cfg.HealthListen))
+		ctx = withHealth(ctx, health)
+	}
+	if cfg.PprofListen != "" {
+		registerPprof(serveMux(cfg.PprofListen))
+	}
+
+	var stops []func()
+	stopAll := func() {
+		for _, stop := range stops {
+			stop()
+		}
+	}
+	for _, addr := range addrs {
+		stop, bound, err := startHTTPServer(addr, muxes[addr])
+		if err != nil {
+			stopAll()
+			return ctx, nil, err
+		}
+		stops = append(stops, stop)
+		logger.InfoContext(ctx, "http server listening", "addr", bound)
+	}
+	return ctx, stopAll, nil
+}
+
+// registerPprof adds the net/http/pprof endpoints under /debug/pprof/.
+func registerPprof(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
+
+// startHTTPServer serves handler on addr until the returned stop function is
+// called. stop waits at most a second for in-flight requests so it never
+// holds up shutdown.
+func startHTTPServer(addr string, handler http.Handler) (stop func(), boundAddr string, err error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, "", fmt.Errorf("listen %s: %w", addr, err)
+	}
+	srv := &http.Server{Handler: handler, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("http server failed", "addr", addr, "error", err)
+		}
+	}()
+	stop = func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			srv.Close()
+		}
+	}
+	return stop, ln.Addr().String(), nil
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// shutdownSignals start a graceful shutdown.
+var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// reloadSignals reload the drop rules file when drop_rules_reload is set.
+var reloadSignals = []os.Signal{syscall.SIGHUP}
+
+// statusSignals log a status snapshot of a running pipeline.
+var statusSignals = []os.Signal{syscall.SIGUSR1}
@@ -0,0 +1,16 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// shutdownSignals start a graceful shutdown. Windows delivers only
+// os.Interrupt, for Ctrl+C and Ctrl+Break; Go never raises SIGTERM there.
+var shutdownSignals = []os.Signal{os.Interrupt}
+
+// reloadSignals is empty: Windows has no SIGHUP, so drop_rules_reload has
+// no effect there.
+var reloadSignals []os.Signal
+
+// statusSignals is empty: Windows has no SIGUSR1, so no status snapshots.
+var statusSignals []os.Signal
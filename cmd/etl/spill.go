@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/logger"
+	"k8s-log-etl/internal/model"
+	"k8s-log-etl/internal/report"
+	"k8s-log-etl/internal/sink"
+	"k8s-log-etl/internal/source"
+)
+
+// defaultSpillMaxBytes bounds spill_dir when spill_max_bytes is unset.
+const defaultSpillMaxBytes = 1 << 30
+
+// spillProbeInterval is how long the drainer waits between attempts to
+// write spooled records. Tests shorten it.
+var spillProbeInterval = time.Second
+
+// spillEntry is one spooled record. Raw is the input record, kept when
+// dlq_payload asks for it so a record dead-lettered after draining still
+// carries it.
+type spillEntry struct {
+	Line   int              `json:"line,omitempty"`
+	Source *source.Origin   `json:"source,omitempty"`
+	Record model.Normalized `json:"record"`
+	Raw    json.RawMessage  `json:"raw,omitempty"`
+}
+
+// spillSegment is a closed spool file. done counts its entries already
+// written to the sink; it is saved next to the file when a drain stops
+// part way, so a later run skips them.
+type spillSegment struct {
+	path    string
+	size    int64
+	entries int
+	done    int
+}
+
+// spillQueue spools records that failed every retry to files in spill_dir
+// and writes them to the sink again once it recovers. Records are appended
+// to an active file; a drain pass closes it and works through the closed
+// files oldest first, deleting each once all its records are written.
+// Files left by an earlier run are drained too.
+type spillQueue struct {
+	dir      string
+	maxBytes int64
+
+	mu         sync.Mutex
+	active     *os.File
+	activePath string
+	activeSize int64
+	activeN    int
+	segments   []*spillSegment
+	bytes      int64 // spooled and not yet drained, active file included
+	seq        int
+	stats      report.SpillStats
+}
+
+// openSpillQueue opens the spool in dir, creating dir if needed, and picks
+// up the files an earlier run left there.
+func openSpillQueue(dir string, maxBytes int64) (*spillQueue, error) {
+	if maxBytes <= 0 {
+		maxBytes = defaultSpillMaxBytes
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("spill dir: %w", err)
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "spill-*.jsonl"))
+	if err != nil {
+		return nil, fmt.Errorf("spill dir: %w", err)
+	}
+	// Names start with the creation time, so this is oldest first.
+	sort.Strings(paths)
+	q := &spillQueue{dir: dir, maxBytes: maxBytes, stats: report.SpillStats{Dir: dir}}
+	for _, path := range paths {
+		seg, err := loadSpillSegment(path)
+		if err != nil {
+			return nil, err
+		}
+		if seg.done >= seg.entries {
+			removeSpillSegment(seg)
+			continue
+		}
+		q.segments = append(q.segments, seg)
+		q.bytes += seg.size
+		q.stats.Resumed += seg.entries - seg.done
+	}
+	return q, nil
+}
+
+// loadSpillSegment counts the entries of the spool file at path and reads
+// its checkpoint.
+func loadSpillSegment(path string) (*spillSegment, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("spill file: %w", err)
+	}
+	seg := &spillSegment{path: path, size: int64(len(data)), entries: bytes.Count(data, []byte("\n"))}
+	if done, err := os.ReadFile(path + ".drained"); err == nil {
+		seg.done, _ = strconv.Atoi(strings.TrimSpace(string(done)))
+	}
+	return seg, nil
+}
+
+func removeSpillSegment(seg *spillSegment) {
+	os.Remove(seg.path)
+	os.Remove(seg.path + ".drained")
+}
+
+// add spools entry and reports whether it fit under spill_max_bytes. A
+// record that does not fit, or that cannot be written to the spool, is
+// left for the caller to dead-letter.
+func (q *spillQueue) add(ctx context.Context, entry spillEntry) bool {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return false
+	}
+	data = append(data, '\n')
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.bytes+int64(len(data)) > q.maxBytes {
+		q.stats.Overflow++
+		return false
+	}
+	if q.active == nil {
+		q.seq++
+		path := filepath.Join(q.dir, fmt.Sprintf("spill-%020d-%06d.jsonl", time.Now().UnixNano(), q.seq))
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			logger.ErrorContext(ctx, "failed to create spill file", "error", err)
+			return false
+		}
+		q.active, q.activePath, q.activeSize, q.activeN = f, path, 0, 0
+	}
+	if _, err := q.active.Write(data); err != nil {
+		logger.ErrorContext(ctx, "failed to write to spill file", "path", q.activePath, "error", err)
+		return false
+	}
+	q.activeSize += int64(len(data))
+	q.activeN++
+	q.bytes += int64(len(data))
+	q.stats.Spilled++
+	return true
+}
+
+// rotate closes the active file, queueing it for draining. The caller
+// holds q.mu.
+func (q *spillQueue) rotate() {
+	if q.active == nil {
+		return
+	}
+	q.active.Close()
+	q.segments = append(q.segments, &spillSegment{path: q.activePath, size: q.activeSize, entries: q.activeN})
+	q.active, q.activeSize, q.activeN = nil, 0, 0
+}
+
+// drain writes spooled records through write, oldest first, until the
+// spool is empty or write fails; the record that failed stays spooled and
+// is the first one the next pass tries, which makes it the probe of the
+// sink. The active file is closed for draining only once the closed ones
+// are gone, so a long outage does not leave a file per pass. Only one
+// drain runs at a time.
+func (q *spillQueue) drain(ctx context.Context, write func(context.Context, spillEntry) error) error {
+	for {
+		q.mu.Lock()
+		if len(q.segments) == 0 {
+			q.rotate()
+		}
+		if len(q.segments) == 0 {
+			q.mu.Unlock()
+			return nil
+		}
+		seg := q.segments[0]
+		q.mu.Unlock()
+
+		if err := q.drainSegment(ctx, seg, write); err != nil {
+			if seg.done > 0 {
+				os.WriteFile(seg.path+".drained", []byte(strconv.Itoa(seg.done)+"\n"), 0o600)
+			}
+			return err
+		}
+		removeSpillSegment(seg)
+		q.mu.Lock()
+		q.segments = q.segments[1:]
+		q.bytes -= seg.size
+		q.mu.Unlock()
+	}
+}
+
+func (q *spillQueue) drainSegment(ctx context.Context, seg *spillSegment, write func(context.Context, spillEntry) error) error {
+	f, err := os.Open(seg.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	for i := 0; ; i++ {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				// A crash cut the last append short; it was never counted.
+				logger.WarnContext(ctx, "skipping torn spill entry", "path", seg.path, "entry", i+1)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if i < seg.done {
+			continue
+		}
+		var entry spillEntry
+		written := false
+		if err := json.Unmarshal(line, &entry); err != nil {
+			logger.WarnContext(ctx, "skipping unreadable spill entry", "path", seg.path, "entry", i+1, "error", err)
+		} else if err := write(ctx, entry); err != nil {
+			return err
+		} else {
+			written = true
+		}
+		q.mu.Lock()
+		seg.done++
+		if written {
+			q.stats.Drained++
+		}
+		q.mu.Unlock()
+	}
+}
+
+// run drains the spool every spillProbeInterval until ctx is done.
+func (q *spillQueue) run(ctx context.Context, write func(context.Context, spillEntry) error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-clockFrom(ctx).After(spillProbeInterval):
+		}
+		if q.pending() == 0 {
+			continue
+		}
+		if err := q.drain(ctx, write); err != nil && ctx.Err() == nil {
+			logger.DebugContext(ctx, "spill drain stopped, sink still failing", "error", err)
+		}
+	}
+}
+
+// pending is the number of records not yet drained.
+func (q *spillQueue) pending() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := q.activeN
+	for _, seg := range q.segments {
+		n += seg.entries - seg.done
+	}
+	return n
+}
+
+// close closes the active file, leaving what is still spooled for the
+// next run.
+func (q *spillQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.rotate()
+}
+
+// snapshot returns the spool's counts so far.
+func (q *spillQueue) snapshot() report.SpillStats {
+	pending := q.pending()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	s := q.stats
+	s.PendingRecords, s.PendingBytes = pending, q.bytes
+	return s
+}
+
+// drainSpill returns the drainer's write: one attempt per spooled record,
+// with no retries, since the drainer tries again on its next pass. The
+// sink refusing a record for good dead-letters it like a worker would.
+func drainSpill(w sink.Writer, dlq *deadLetters, cfg config.Config, rep *report.Report) func(context.Context, spillEntry) error {
+	timeout := time.Duration(cfg.SinkWriteTimeoutMS) * time.Millisecond
+	return func(ctx context.Context, e spillEntry) error {
+		err := writeOnce(ctx, w, e.Record, timeout)
+		if err == nil {
+			rep.AddWriteOK()
+			healthFrom(ctx).WriteOK()
+			return nil
+		}
+		if ctx.Err() != nil || spillable(err) {
+			return err
+		}
+		rep.AddWriteFailed()
+		itemCtx := lineContext(ctx, e.Line)
+		logger.WarnContext(itemCtx, "spooled record rejected by the sink", "error", err, "line", e.Line, "service", e.Record.Service)
+		if dlq != nil {
+			rec := dlqRecord{Record: &e.Record, rawJSON: e.Raw, Line: e.Line, Source: e.Source, Stage: dlqStageSink,
+				Reason: sinkDLQReason(err), Error: err.Error(), RunID: rep.RunID}
+			if err := writeDLQ(itemCtx, dlq, rec, cfg, rep); err != nil {
+				logger.WarnContext(itemCtx, "DLQ cap reached, spooled record dropped", "error", err)
+			}
+		}
+		return nil
+	}
+}
+
+// spillable reports whether a failed write may be spooled for later. Errors
+// that another attempt cannot fix, and a full local disk, are not.
+func spillable(err error) bool {
+	return !errors.Is(err, sink.ErrFormat) && !errors.Is(err, sink.ErrRejected) && !errors.Is(err, sink.ErrDiskFull) &&
+		!errors.Is(err, context.Canceled)
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s-log-etl/internal/model"
+	"k8s-log-etl/internal/report"
+	"k8s-log-etl/internal/sink"
+	"k8s-log-etl/internal/source"
+)
+
+// flakyWriter fails its first fails writes with a retryable error and
+// keeps the messages of the records it writes after that.
+type flakyWriter struct {
+	mu      sync.Mutex
+	fails   int
+	written []string
+}
+
+func (w *flakyWriter) Write(record interface{}) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.fails > 0 {
+		w.fails--
+		return sink.ErrWriteSink
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	var rec struct {
+		Message string `json:"message"`
+	}
+	json.Unmarshal(data, &rec)
+	w.written = append(w.written, rec.Message)
+	return nil
+}
+
+func (w *flakyWriter) Close() error { return nil }
+
+func (w *flakyWriter) messages() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]string(nil), w.written...)
+}
+
+func spillInput(n int) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&b, `{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"m%d","service":"api"}`+"\n", i)
+	}
+	return b.String()
+}
+
+func TestRunPipeline_SpillResumesOnNextRun(t *testing.T) {
+	cfg := dlqLimitConfig(t)
+	cfg.SpillDir = filepath.Join(t.TempDir(), "spill")
+
+	// The sink is down for the whole first run: nothing reaches the DLQ
+	// and every record is left in spill_dir.
+	rep := report.NewReport()
+	ctx := withBaseSink(context.Background(), &failingWriter{})
+	if err := runPipeline(ctx, strings.NewReader(spillInput(3)), cfg, rep); err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+	if s := rep.Spill; s == nil || s.Spilled != 3 || s.PendingRecords != 3 || s.PendingBytes == 0 {
+		t.Fatalf("first run spill %+v", rep.Spill)
+	}
+	if rep.WriteFailed != 0 || rep.DLQWritten != 0 {
+		t.Errorf("failed %d, dlq %d; want 0, 0", rep.WriteFailed, rep.DLQWritten)
+	}
+
+	// The next run finds them and drains them once its input is done.
+	w := &flakyWriter{}
+	rep = report.NewReport()
+	ctx = withBaseSink(context.Background(), w)
+	if err := runPipeline(ctx, strings.NewReader(""), cfg, rep); err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+	if got := strings.Join(w.messages(), ","); got != "m1,m2,m3" {
+		t.Errorf("drained %s, want m1,m2,m3", got)
+	}
+	if s := rep.Spill; s == nil || s.Resumed != 3 || s.Drained != 3 || s.PendingRecords != 0 || s.PendingBytes != 0 {
+		t.Errorf("second run spill %+v", rep.Spill)
+	}
+	if rep.WrittenOK != 3 {
+		t.Errorf("written %d, want 3", rep.WrittenOK)
+	}
+	entries, err := os.ReadDir(cfg.SpillDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("%d files left in spill_dir after draining", len(entries))
+	}
+	if !strings.Contains(rep.Prometheus(), `etl_spill_records_total{state="drained"} 3`) {
+		t.Error("drained records missing from the metrics")
+	}
+}
+
+func TestRunPipeline_SpilledRecordsArriveLate(t *testing.T) {
+	cfg := dlqLimitConfig(t)
+	cfg.SpillDir = t.TempDir()
+	w := &flakyWriter{fails: 1}
+	rep := report.NewReport()
+	ctx := withBaseSink(context.Background(), w)
+	if err := runPipeline(ctx, strings.NewReader(spillInput(3)), cfg, rep); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+	// m1 failed and was spooled; the records after it went straight
+	// through, so it reached the sink last.
+	if got := strings.Join(w.messages(), ","); got != "m2,m3,m1" {
+		t.Errorf("sink got %s, want m2,m3,m1", got)
+	}
+	if s := rep.Spill; s.Spilled != 1 || s.Drained != 1 || s.PendingRecords != 0 {
+		t.Errorf("spill %+v", s)
+	}
+}
+
+func TestRunPipeline_SpillOverflowFallsBackToDLQ(t *testing.T) {
+	cfg := dlqLimitConfig(t)
+	cfg.SpillDir = t.TempDir()
+	entry, err := json.Marshal(spillEntry{Line: 1, Source: &source.Origin{Line: 1}, Record: model.Normalized{TS: "2024-01-01T12:00:00Z", Level: "ERROR", Message: "m1", Service: "api"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Room for one entry; the others overflow to the DLQ.
+	cfg.SpillMaxBytes = int64(len(entry)) + 10
+	rep := report.NewReport()
+	ctx := withBaseSink(context.Background(), &failingWriter{})
+	if err := runPipeline(ctx, strings.NewReader(spillInput(3)), cfg, rep); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+	if s := rep.Spill; s.Spilled != 1 || s.Overflow != 2 || s.PendingRecords != 1 {
+		t.Errorf("spill %+v", s)
+	}
+	if rep.WriteFailed != 2 || rep.DLQWritten != 2 {
+		t.Errorf("failed %d, dlq %d; want 2, 2", rep.WriteFailed, rep.DLQWritten)
+	}
+}
+
+func TestSpillQueue_CheckpointSkipsDrainedEntries(t *testing.T) {
+	dir := t.TempDir()
+	q, err := openSpillQueue(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for i := 1; i <= 3; i++ {
+		q.add(ctx, spillEntry{Line: i, Record: model.Normalized{Message: fmt.Sprintf("m%d", i)}})
+	}
+	// The sink takes one record and fails on the next: the drain stops
+	// there and saves how far it got.
+	calls := 0
+	err = q.drain(ctx, func(context.Context, spillEntry) error {
+		if calls++; calls > 1 {
+			return sink.ErrWriteSink
+		}
+		return nil
+	})
+	if err == nil {
+		t.Fatal("drain did not report the failed write")
+	}
+	q.close()
+	paths, _ := filepath.Glob(filepath.Join(dir, "spill-*.jsonl"))
+	if len(paths) != 1 {
+		t.Fatalf("spill files %v, want one", paths)
+	}
+	if done, err := os.ReadFile(paths[0] + ".drained"); err != nil || strings.TrimSpace(string(done)) != "1" {
+		t.Fatalf("checkpoint %q, %v; want 1", done, err)
+	}
+	// A crash in the middle of an append leaves a torn last entry.
+	f, err := os.OpenFile(paths[0], os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"line":4,"record":{"mess`)
+	f.Close()
+
+	// A restart resumes after the checkpoint and skips the torn entry.
+	q, err = openSpillQueue(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.pending() != 2 || q.snapshot().Resumed != 2 {
+		t.Fatalf("pending %d, stats %+v; want 2 resumed", q.pending(), q.snapshot())
+	}
+	var lines []int
+	if err := q.drain(ctx, func(_ context.Context, e spillEntry) error {
+		lines = append(lines, e.Line)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(lines) != "[2 3]" {
+		t.Errorf("drained lines %v, want [2 3]", lines)
+	}
+	if left, _ := os.ReadDir(dir); len(left) != 0 {
+		t.Errorf("%d files left after draining", len(left))
+	}
+}
+
+func TestSpillQueue_RunDrainsOnceSinkRecovers(t *testing.T) {
+	prev := spillProbeInterval
+	spillProbeInterval = 5 * time.Millisecond
+	t.Cleanup(func() { spillProbeInterval = prev })
+
+	q, err := openSpillQueue(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	q.add(ctx, spillEntry{Line: 1, Record: model.Normalized{Message: "m1"}})
+	q.add(ctx, spillEntry{Line: 2, Record: model.Normalized{Message: "m2"}})
+
+	// The sink fails the first three passes, then recovers.
+	w := &flakyWriter{fails: 3}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		q.run(ctx, func(_ context.Context, e spillEntry) error { return w.Write(e.Record) })
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for q.pending() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d records still spooled", q.pending())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := strings.Join(w.messages(), ","); got != "m1,m2" {
+		t.Errorf("drained %s, want m1,m2", got)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"k8s-log-etl/internal/logger"
+)
+
+// errWorkerStalled stops a run, with stall_action abort, once a sink
+// worker has held one record for stall_warn_after.
+var errWorkerStalled = errors.New("sink worker stalled")
+
+// watchStalls checks the workers' progress every quarter of after until
+// ctx is done. A worker holding one record for after is logged with the
+// record's identifiers, and logged again, as an error, each time the wait
+// doubles. With abort set the first stall is passed to abort instead and
+// watching ends.
+func watchStalls(ctx context.Context, progress []workerProgress, after time.Duration, abort func(error)) {
+	c := clockFrom(ctx)
+	ticker := c.NewTicker(max(after/4, time.Millisecond))
+	defer ticker.Stop()
+	type stall struct {
+		active int64         // the worker's lastActive when the stall began
+		next   time.Duration // how long it may last before the next log
+	}
+	stalls := make([]stall, len(progress))
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+		now := c.Now()
+		for i := range progress {
+			p := &progress[i]
+			// begin stores lastActive before the line, so reading them the
+			// other way round never pairs a new record with an old time.
+			if p.inFlightLine.Load() == 0 {
+				stalls[i] = stall{}
+				continue
+			}
+			active := p.lastActive.Load()
+			if stalls[i].next == 0 || stalls[i].active != active {
+				stalls[i] = stall{active: active, next: after}
+			}
+			stuck := now.Sub(time.Unix(0, active))
+			if stuck < stalls[i].next {
+				continue
+			}
+			first := stalls[i].next == after
+			stalls[i].next *= 2
+			if first {
+				p.stalls.Add(1)
+			}
+			st := p.status(i)
+			attrs := []any{"worker_id", i, "stuck_seconds", stuck.Seconds(), "stall_warn_after", after.String(),
+				"line", st.InFlightLine, "service", st.InFlightService, "pod", st.InFlightPod, "trace_id", st.InFlightTraceID}
+			switch {
+			case abort != nil:
+				logger.ErrorContext(ctx, "sink worker stalled, stopping the run", attrs...)
+				abort(fmt.Errorf("%w: worker %d held line %d for %s", errWorkerStalled, i, st.InFlightLine, stuck.Round(time.Millisecond)))
+				return
+			case first:
+				logger.WarnContext(ctx, "sink worker stalled", attrs...)
+			default:
+				logger.ErrorContext(ctx, "sink worker still stalled", attrs...)
+			}
+		}
+	}
+}
+
+// longestHeld returns the worker that has held its record the longest at
+// now, and for how long; 0 when every worker is idle.
+func longestHeld(progress []workerProgress, now time.Time) (int, time.Duration) {
+	id, longest := -1, time.Duration(0)
+	for i := range progress {
+		p := &progress[i]
+		if p.inFlightLine.Load() == 0 {
+			continue
+		}
+		if held := now.Sub(time.Unix(0, p.lastActive.Load())); held > longest {
+			id, longest = i, held
+		}
+	}
+	return id, longest
+}
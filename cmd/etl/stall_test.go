@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s-log-etl/internal/clock"
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/logger"
+	"k8s-log-etl/internal/model"
+	"k8s-log-etl/internal/report"
+)
+
+// syncBuffer is a bytes.Buffer safe for the pipeline's goroutines to log
+// into while the test reads it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// waitForLog waits for logs to hold n lines containing msg.
+func waitForLog(t *testing.T, logs *syncBuffer, msg string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for strings.Count(logs.String(), `"msg":"`+msg+`"`) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("want %d %q entries, logs:\n%s", n, msg, logs.String())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWatchStallsEscalates(t *testing.T) {
+	fake := clock.NewFake(time.Unix(1_700_000_000, 0))
+	var logs syncBuffer
+	prev := logger.Logger()
+	logger.SetLogger(slog.New(slog.NewJSONHandler(&logs, nil)))
+	defer logger.SetLogger(prev)
+
+	progress := newWorkerProgress(2, fake)
+	progress[0].begin(&workItem{line: 7, record: model.Normalized{Service: "api", Pod: "api-0", TraceID: "t-1"}})
+	ctx, cancel := context.WithCancel(withClock(context.Background(), fake))
+	defer cancel()
+	go watchStalls(ctx, progress, time.Second, nil)
+
+	fake.BlockUntil(1)
+	fake.Advance(750 * time.Millisecond)
+	fake.Advance(250 * time.Millisecond)
+	waitForLog(t, &logs, "sink worker stalled", 1)
+	for _, want := range []string{`"worker_id":0`, `"line":7`, `"service":"api"`, `"pod":"api-0"`, `"trace_id":"t-1"`} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("stall warning lacks %s: %s", want, logs.String())
+		}
+	}
+
+	// Escalates once the stall has doubled, not on every tick before.
+	fake.Advance(750 * time.Millisecond)
+	fake.Advance(250 * time.Millisecond)
+	waitForLog(t, &logs, "sink worker still stalled", 1)
+	fake.Advance(time.Second)
+	fake.Advance(time.Second)
+	waitForLog(t, &logs, "sink worker still stalled", 2)
+	if n := strings.Count(logs.String(), "stalled"); n != 3 {
+		t.Errorf("%d stall entries, want 3:\n%s", n, logs.String())
+	}
+
+	// A new record starts a new stall.
+	progress[0].done()
+	progress[0].begin(&workItem{line: 8})
+	fake.Advance(time.Second)
+	waitForLog(t, &logs, "sink worker stalled", 2)
+	if st := progress[0].status(0); st.Stalls != 2 || progress[1].status(1).Stalls != 0 {
+		t.Errorf("stalls = %d and %d, want 2 and 0", st.Stalls, progress[1].status(1).Stalls)
+	}
+}
+
+func TestRunPipeline_StallAbort(t *testing.T) {
+	fake := clock.NewFake(time.Unix(1_700_000_000, 0))
+
+	w := &stalledWriter{stalled: make(chan struct{}), release: make(chan struct{})}
+	defer close(w.release)
+	cfg := config.Default()
+	cfg.ReportPath = filepath.Join(t.TempDir(), "report.json")
+	cfg.MaxWorkers = 1
+	cfg.BatchSize = 0
+	cfg.ShutdownTimeoutSeconds = 1
+	cfg.StallWarnAfter = "1m"
+	cfg.StallAction = config.StallAbort
+	if err := config.Validate(cfg); err != nil {
+		t.Fatal(err)
+	}
+	rep := report.NewReport()
+	done := make(chan error, 1)
+	go func() {
+		done <- runPipeline(withBaseSink(withClock(context.Background(), fake), w), strings.NewReader(idleTestLine+idleTestLine), cfg, rep)
+	}()
+
+	<-w.stalled
+	// The runtime sampler's ticker and the watchdog's.
+	fake.BlockUntil(2)
+	fake.Advance(time.Minute)
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("run did not stop on a stalled worker")
+	}
+	if !errors.Is(err, errWorkerStalled) {
+		t.Fatalf("err = %v, want errWorkerStalled", err)
+	}
+	// The stalled write is abandoned, so the worker has stopped by the time
+	// the run returns, with the writer still blocked.
+	stop := rep.Shutdown
+	if stop.Reason != report.StopStalled || len(stop.Workers) != 1 || stop.Workers[0].Stalls != 1 || stop.Workers[0].InFlight {
+		t.Errorf("shutdown = %+v, want reason stalled with worker 0 stalled once and stopped", stop)
+	}
+	if rep.WriteFailed == 0 || rep.WrittenOK != 0 {
+		t.Errorf("written %d, failed %d; want the abandoned record failed", rep.WrittenOK, rep.WriteFailed)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"runtime"
+	"time"
+
+	"k8s-log-etl/internal/logger"
+	"k8s-log-etl/internal/report"
+)
+
+// watchStatus logs a status snapshot each time a signal arrives on sig,
+// until ctx is done.
+func watchStatus(ctx context.Context, sig <-chan os.Signal, snapshot func()) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sig:
+			snapshot()
+		}
+	}
+}
+
+// logStatus logs where a running pipeline stands as one entry: the
+// report's counts so far, the queue, what each worker is writing and when
+// it last made progress, retries, and memory.
+func logStatus(ctx context.Context, start time.Time, rep *report.Report, queue workQueue, progress []workerProgress) {
+	snap := rep.Snapshot()
+	workers := make([]report.WorkerStatus, len(progress))
+	for i := range progress {
+		workers[i] = progress[i].status(i)
+	}
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	attrs := []any{
+		"elapsed_seconds", time.Since(start).Seconds(),
+		"lines_read", snap.TotalLines,
+		"json_failed", snap.JSONFailed,
+		"normalized_ok", snap.NormalizedOK,
+		"written_ok", snap.WrittenOK,
+		"written_failed", snap.WriteFailed,
+		"dlq_written", snap.DLQWritten,
+		"queue_depth", queue.len(),
+		"workers", workers,
+		slog.Group("retries",
+			"total", snap.RetryStats.TotalRetries,
+			"writes_with_retries", snap.RetryStats.WritesWithRetries,
+			"max_per_write", snap.RetryStats.MaxRetriesPerWrite,
+			"write_timeouts", snap.RetryStats.WriteTimeouts,
+		),
+		slog.Group("memory",
+			"heap_alloc_bytes", ms.HeapAlloc,
+			"heap_inuse_bytes", ms.HeapInuse,
+			"sys_bytes", ms.Sys,
+			"num_gc", ms.NumGC,
+			"goroutines", runtime.NumGoroutine(),
+		),
+	}
+	if snap.InFlight.MaxBytes > 0 {
+		attrs = append(attrs, "in_flight_bytes", snap.InFlight.CurrentBytes)
+	}
+	logger.InfoContext(ctx, "status snapshot", attrs...)
+}
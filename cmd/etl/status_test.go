@@ -0,0 +1,90 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"k8s-log-etl/internal/logger"
+	"k8s-log-etl/internal/report"
+	"k8s-log-etl/internal/sink"
+)
+
+func TestSIGUSR1LogsStatusSnapshot(t *testing.T) {
+	var logs syncBuffer
+	prev := logger.Logger()
+	logger.SetLogger(slog.New(slog.NewJSONHandler(&logs, nil)))
+	defer logger.SetLogger(prev)
+
+	// The input stays open so the run is still going when signalled.
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go io.WriteString(pw, idleTestLine+idleTestLine)
+
+	cfg := idleTestConfig(t, "", "")
+	mem := sink.NewMemorySink()
+	rep := report.NewReport()
+	done := make(chan error, 1)
+	go func() { done <- runPipeline(withBaseSink(context.Background(), mem), pr, cfg, rep) }()
+
+	// The handler is registered before the workers start, so once a
+	// record is written a signal is logged.
+	waitFor(t, "a written record", func() bool { return len(mem.Records()) > 0 })
+	syscall.Kill(syscall.Getpid(), syscall.SIGUSR1)
+	waitForLog(t, &logs, "status snapshot", 1)
+	waitFor(t, "both records", func() bool { return len(mem.Records()) == 2 })
+	pw.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	var entry struct {
+		Msg       string                `json:"msg"`
+		LinesRead int                   `json:"lines_read"`
+		Workers   []report.WorkerStatus `json:"workers"`
+		Retries   map[string]int        `json:"retries"`
+		Memory    map[string]float64    `json:"memory"`
+	}
+	for _, line := range strings.Split(logs.String(), "\n") {
+		if strings.Contains(line, `"status snapshot"`) {
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if entry.LinesRead == 0 || len(entry.Workers) != cfg.MaxWorkers {
+		t.Errorf("snapshot = %+v, want lines read and %d workers", entry, cfg.MaxWorkers)
+	}
+	if _, ok := entry.Retries["total"]; !ok {
+		t.Errorf("snapshot retries = %v, want total", entry.Retries)
+	}
+	if entry.Memory["heap_alloc_bytes"] == 0 || entry.Memory["goroutines"] == 0 {
+		t.Errorf("snapshot memory = %v", entry.Memory)
+	}
+	var active bool
+	for _, w := range entry.Workers {
+		active = active || w.LastActive != ""
+	}
+	if !active {
+		t.Errorf("no worker has a last_active time: %+v", entry.Workers)
+	}
+}
+
+// waitFor polls cond until it holds, failing the test after 10s.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
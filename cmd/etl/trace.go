@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"reflect"
+	"strings"
+
+	"k8s-log-etl/internal/logger"
+	"k8s-log-etl/internal/model"
+)
+
+// recordTracer picks out the records trace_record selects, "field=value",
+// and logs every stage decision about them at info level. For any other
+// record the cost is one field lookup and comparison per stage that
+// checks.
+type recordTracer struct {
+	field, value string
+}
+
+// newRecordTracer returns the tracer for spec, or nil when spec is empty;
+// a nil tracer matches nothing. Validate has checked spec's form.
+func newRecordTracer(spec string) *recordTracer {
+	field, value, ok := strings.Cut(spec, "=")
+	if !ok {
+		return nil
+	}
+	return &recordTracer{field: strings.TrimSpace(field), value: value}
+}
+
+// matchParsed reports whether the parsed input has the field at its top
+// level with the value. Records are matched on their input before
+// normalization, so those it rejects can be traced too.
+func (t *recordTracer) matchParsed(js map[string]any) bool {
+	if t == nil {
+		return false
+	}
+	v, ok := js[t.field]
+	return ok && t.equal(v)
+}
+
+// matchNormalized reports whether the normalized record has the field, by
+// output name or in Fields, with the value. It catches input keys that
+// normalization renames, e.g. trace to trace_id.
+func (t *recordTracer) matchNormalized(n model.Normalized) bool {
+	if t == nil {
+		return false
+	}
+	v, ok := n.Field(t.field)
+	return ok && t.equal(v)
+}
+
+func (t *recordTracer) equal(v any) bool {
+	if s, ok := v.(string); ok {
+		return s == t.value
+	}
+	return fmt.Sprint(v) == t.value
+}
+
+// traceStage logs one stage decision about a traced record.
+func traceStage(ctx context.Context, line int, stage string, args ...any) {
+	logger.InfoContext(lineContext(ctx, line), "record trace", append([]any{"stage", stage, "line", line}, args...)...)
+}
+
+// fieldChange is a value a transform changed. From is unset for an added
+// field and To for a removed one.
+type fieldChange struct {
+	From any `json:"from,omitempty"`
+	To   any `json:"to,omitempty"`
+}
+
+// cloneRecord copies n deeply enough for recordChanges: transforms may
+// change Fields in place.
+func cloneRecord(n model.Normalized) model.Normalized {
+	n.Fields = maps.Clone(n.Fields)
+	return n
+}
+
+// recordChanges lists what changed between before and after, keyed by
+// output name, with extra fields as "fields.<key>".
+func recordChanges(before, after model.Normalized) map[string]fieldChange {
+	changes := map[string]fieldChange{}
+	for _, name := range model.FieldNames {
+		b, _ := before.Field(name)
+		a, _ := after.Field(name)
+		if b != a {
+			changes[name] = fieldChange{From: b, To: a}
+		}
+	}
+	for k, b := range before.Fields {
+		a, ok := after.Fields[k]
+		if !ok {
+			changes["fields."+k] = fieldChange{From: b}
+		} else if !reflect.DeepEqual(a, b) {
+			changes["fields."+k] = fieldChange{From: b, To: a}
+		}
+	}
+	for k, a := range after.Fields {
+		if _, ok := before.Fields[k]; !ok {
+			changes["fields."+k] = fieldChange{To: a}
+		}
+	}
+	return changes
+}
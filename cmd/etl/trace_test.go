@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/logger"
+	"k8s-log-etl/internal/report"
+	"k8s-log-etl/internal/sink"
+)
+
+// traceLogs runs input through the pipeline with trace_record set and
+// returns the "record trace" entries by line.
+func traceLogs(t *testing.T, input string, cfg config.Config) map[float64][]map[string]any {
+	t.Helper()
+	var logs bytes.Buffer
+	prev := logger.Logger()
+	logger.SetLogger(slog.New(slog.NewJSONHandler(&logs, nil)))
+	defer logger.SetLogger(prev)
+
+	cfg.ReportPath = filepath.Join(t.TempDir(), "report.json")
+	if err := config.Validate(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := runPipeline(withBaseSink(context.Background(), sink.NewMemorySink()), strings.NewReader(input), cfg, report.NewReport()); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+	byLine := map[float64][]map[string]any{}
+	for _, l := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(l), &entry); err != nil {
+			t.Fatalf("log line %q: %v", l, err)
+		}
+		if entry["msg"] == "record trace" {
+			line := entry["line"].(float64)
+			byLine[line] = append(byLine[line], entry)
+		}
+	}
+	return byLine
+}
+
+func traceStages(entries []map[string]any) string {
+	var stages []string
+	for _, e := range entries {
+		s := e["stage"].(string)
+		if d, ok := e["decision"]; ok {
+			s += ":" + d.(string)
+		}
+		stages = append(stages, s)
+	}
+	return strings.Join(stages, " ")
+}
+
+func TestRunPipeline_TraceRecord(t *testing.T) {
+	input := `{"ts":"2024-01-01T12:00:00Z","level":"DEBUG","msg":"dropped","service":"api","trace_id":"abc123"}
+{"ts":"2024-01-01T12:00:01Z","level":"ERROR","msg":"written","service":"api","trace":"abc123","token":"secret"}
+{"ts":"2024-01-01T12:00:02Z","level":"ERROR","msg":"other","service":"api","trace_id":"zzz"}
+`
+	cfg := config.Default()
+	cfg.FilterLevels = []string{"ERROR"}
+	cfg.RedactKeys = []string{"token"}
+	cfg.BatchSize = 0
+	cfg.TraceRecord = "trace_id=abc123"
+	byLine := traceLogs(t, input, cfg)
+
+	if got := traceStages(byLine[1]); got != "parsed normalized:keep transform:drop" {
+		t.Errorf("dropped record stages = %q", got)
+	}
+	if drop := byLine[1][2]; drop["transform"] != "filter_redact" || drop["reason"] != "level" {
+		t.Errorf("drop entry = %v", drop)
+	}
+
+	// Matched after normalization moved trace into trace_id.
+	if got := traceStages(byLine[2]); got != "parsed normalized:keep transform:keep sink:written" {
+		t.Errorf("written record stages = %q", got)
+	}
+	keep := byLine[2][2]
+	changes, _ := keep["changes"].(map[string]any)
+	if token, _ := changes["fields.token"].(map[string]any); token["from"] != "secret" || token["to"] != nil {
+		t.Errorf("transform changes = %v, want the redacted token", keep["changes"])
+	}
+	if written := byLine[2][3]; written["retries"] != float64(0) {
+		t.Errorf("sink entry = %v", written)
+	}
+	if parsed := byLine[2][0]["record"].(map[string]any); parsed["trace"] != "abc123" {
+		t.Errorf("parsed entry = %v", byLine[2][0])
+	}
+
+	if len(byLine[3]) != 0 {
+		t.Errorf("untraced record logged: %v", byLine[3])
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"k8s-log-etl/internal/config"
+)
+
+// cmdValidate resolves and validates the effective configuration.
+func cmdValidate(args []string) error {
+	fs := newFlagSet("validate", "[flags]",
+		"Resolve the configuration from defaults, config file, env, and flags,\nvalidate it, run the startup checks of run, and exit non-zero if either fails.")
+	loadConfig := configFlags(fs)
+	flagPrint := fs.Bool("print", false, "print the effective configuration as JSON, with secrets masked")
+	fs.Parse(args)
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if err := config.Validate(cfg); err != nil {
+		return err
+	}
+	if err := preflight(context.Background(), cfg); err != nil {
+		return err
+	}
+	for _, w := range config.Warnings(cfg) {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", w)
+	}
+	if *flagPrint {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(cfg.Redacted()); err != nil {
+			return fmt.Errorf("print config: %w", err)
+		}
+	}
+	fmt.Println("configuration OK")
+	return nil
+}
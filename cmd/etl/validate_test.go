@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s-log-etl/internal/config"
+)
+
+func TestReadSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	token := filepath.Join(dir, "token")
+	password := filepath.Join(dir, "password")
+	os.WriteFile(token, []byte("  s3cr3t-token\n"), 0o600)
+	os.WriteFile(password, []byte("hunter2\n"), 0o600)
+
+	cfg := config.Default()
+	cfg.HTTPAuthTokenFile = token
+	cfg.ClickHousePasswordFile = password
+	cfg = config.ReadSecretFiles(cfg)
+	if cfg.HTTPAuthToken != "s3cr3t-token" || cfg.ClickHousePassword != "hunter2" {
+		t.Fatalf("read token %q, password %q", cfg.HTTPAuthToken, cfg.ClickHousePassword)
+	}
+	if err := config.Validate(cfg); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	// Secrets read from files are left out, the files kept; one set
+	// inline is masked.
+	red := cfg.Redacted()
+	if red.HTTPAuthToken != "" || red.ClickHousePassword != "" || red.HTTPAuthTokenFile != token {
+		t.Errorf("redacted token %q, password %q, token file %q", red.HTTPAuthToken, red.ClickHousePassword, red.HTTPAuthTokenFile)
+	}
+	inline := config.Default()
+	inline.HTTPAuthToken = "s3cr3t-token"
+	if red := inline.Redacted(); red.HTTPAuthToken != config.Masked {
+		t.Errorf("redacted inline token %q, want %q", red.HTTPAuthToken, config.Masked)
+	}
+	if cfg.HTTPAuthToken != "s3cr3t-token" {
+		t.Error("Redacted changed the config it was called on")
+	}
+	if empty := config.Default().Redacted(); empty.HTTPAuthToken != "" {
+		t.Errorf("an unset secret should stay empty, got %q", empty.HTTPAuthToken)
+	}
+
+	tests := []struct {
+		name string
+		edit func(*config.Config)
+		want string
+	}{
+		{"missing file", func(c *config.Config) { c.HTTPAuthTokenFile = filepath.Join(dir, "nope") }, "cannot read http_auth_token_file"},
+		{"empty file", func(c *config.Config) {
+			empty := filepath.Join(dir, "empty")
+			os.WriteFile(empty, []byte(" \n"), 0o600)
+			c.ClickHousePasswordFile = empty
+		}, "cannot read clickhouse_password_file"},
+		{"both set", func(c *config.Config) { c.HTTPAuthToken = "inline" }, "http_auth_token and http_auth_token_file are both set"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bad := cfg
+			tt.edit(&bad)
+			err := config.Validate(config.ReadSecretFiles(bad))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Validate error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCmdValidate_PrintMasksSecrets(t *testing.T) {
+	token := filepath.Join(t.TempDir(), "token")
+	os.WriteFile(token, []byte("s3cr3t-token\n"), 0o600)
+
+	stdout := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = w
+	printed := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(r)
+		printed <- data
+	}()
+	err = cmdValidate([]string{"--print", "--http-auth-token-file", token})
+	os.Stdout = stdout
+	w.Close()
+	out := <-printed
+	if err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+
+	if strings.Contains(string(out), "s3cr3t-token") {
+		t.Fatalf("validate --print leaked the token:\n%s", out)
+	}
+	var printedCfg struct {
+		Token     string `json:"http_auth_token"`
+		TokenFile string `json:"http_auth_token_file"`
+	}
+	if err := json.NewDecoder(strings.NewReader(string(out))).Decode(&printedCfg); err != nil {
+		t.Fatalf("decode printed config: %v\n%s", err, out)
+	}
+	// The token read from its file is left out, so the printed config
+	// loads back and reads the file again.
+	if printedCfg.Token != "" || printedCfg.TokenFile != token {
+		t.Errorf("printed token %q, token file %q", printedCfg.Token, printedCfg.TokenFile)
+	}
+}
+
+func TestValidateOutputResume(t *testing.T) {
+	cfg := config.Default()
+	cfg.OutputType, cfg.OutputPath, cfg.OutputResume, cfg.MaxWorkers = "file", "out.jsonl", true, 1
+	if err := config.Validate(cfg); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		edit func(*config.Config)
+		want string
+	}{
+		{"rotate", func(c *config.Config) { c.OutputType = "rotate" }, "output_resume requires output_type file"},
+		{"atomic", func(c *config.Config) { c.OutputAtomic = true }, "output_resume cannot be used with output_atomic"},
+		{"workers", func(c *config.Config) { c.MaxWorkers = 4 }, "output_resume requires max_workers 1"},
+		{"priority", func(c *config.Config) { c.QueuePriorityByLevel = true }, "output_resume requires max_workers 1"},
+		{"run metadata", func(c *config.Config) { c.StampRunMetadata = true }, "output_resume cannot be used with stamp_run_metadata"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bad := cfg
+			tt.edit(&bad)
+			if err := config.Validate(bad); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Validate error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate_OneReportOnStdout(t *testing.T) {
+	cfg := config.Default()
+	cfg.ReportPath = "-"
+	cfg.ReportMarkdownPath = "-"
+	cfg.ReportQualityPath = "quality.json"
+	err := config.Validate(cfg)
+	if err == nil || !strings.Contains(err.Error(), "more than one report on stdout: report, report_md are all -") {
+		t.Errorf("Validate = %v, want it to refuse two reports on stdout", err)
+	}
+
+	cfg.ReportPath = "report.json"
+	if err := config.Validate(cfg); err != nil {
+		t.Errorf("Validate with one report on stdout = %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+
+	"k8s-log-etl/internal/report"
+)
+
+// Build metadata, set at build time with e.g.
+// -ldflags "-X main.version=v1.2.3 -X main.commit=abc123 -X main.date=2025-01-01T00:00:00Z".
+// Unset values fall back to the module and VCS info embedded by the Go toolchain.
+var (
+	version = "dev"
+	commit  = ""
+	date    = ""
+)
+
+// buildInfo resolves the build metadata for this binary.
+func buildInfo() report.BuildInfo {
+	bi := report.BuildInfo{
+		Version:   version,
+		Commit:    commit,
+		Date:      date,
+		GoVersion: runtime.Version(),
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return bi
+	}
+	if bi.Version == "dev" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		bi.Version = info.Main.Version
+	}
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			if bi.Commit == "" {
+				bi.Commit = s.Value
+			}
+		case "vcs.time":
+			if bi.Date == "" {
+				bi.Date = s.Value
+			}
+		}
+	}
+	return bi
+}
+
+// versionString renders build metadata for --version.
+func versionString(bi report.BuildInfo) string {
+	commit := bi.Commit
+	if commit == "" {
+		commit = "unknown"
+	}
+	date := bi.Date
+	if date == "" {
+		date = "unknown"
+	}
+	return fmt.Sprintf("etl %s (commit %s, built %s, %s)", bi.Version, commit, date, bi.GoVersion)
+}
+
+// newRunID returns a random RFC 4122 version 4 UUID identifying this run.
+func newRunID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// crypto/rand never fails on supported platforms.
+		panic(fmt.Sprintf("generate run id: %v", err))
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/logger"
+	"k8s-log-etl/internal/report"
+	"k8s-log-etl/internal/sink"
+)
+
+// writeStage is the sink workers' half of a run: each worker takes records
+// off the queue and writes them, retrying, spilling, or dead-lettering the
+// ones the sink refuses.
+type writeStage struct {
+	cfg      config.Config
+	rep      *report.Report
+	queue    workQueue
+	inflight *inflightLimiter
+	pacing   *pacer
+	routes   *routeWrites
+	timer    stageTimer
+	progress []workerProgress
+	sink     sink.Writer
+	dlq      *deadLetters // nil without a DLQ
+	spill    *spillQueue  // nil without spill_dir
+	budget   *retryBudget
+	health   *healthState
+	notifier *sdNotifier
+	probe    *benchProbe
+
+	// readCtx ends the workers once reading stops on a fatal error; the
+	// writes run under writesCtx, which stall_action abort cancels.
+	readCtx     context.Context
+	writesCtx   context.Context
+	stopReading context.CancelCauseFunc
+}
+
+// work runs sink worker workerID until the queue is closed and drained or
+// readCtx ends. ctx carries the run's logging context.
+func (w *writeStage) work(ctx context.Context, workerID int) {
+	defer func() {
+		if v := recover(); v != nil {
+			w.stopReading(pipelinePanic(ctx, w.rep, fmt.Sprintf("worker %d", workerID), v))
+		}
+	}()
+	p := &w.progress[workerID]
+	// The worker counts its writes in its own shard, so the workers share
+	// no counter.
+	shard := w.rep.NewShard()
+	timer := stageTimer{rep: shard, enabled: w.timer.enabled}
+	for {
+		item, ok := w.queue.take(w.readCtx)
+		if !ok {
+			if w.readCtx.Err() != nil {
+				logger.DebugContext(ctx, "worker shutting down", "worker_id", workerID)
+			}
+			return
+		}
+		if w.pacing != nil {
+			ts, ok := item.record.EventTime()
+			w.pacing.wait(w.readCtx, ts, ok)
+		}
+		p.begin(&item)
+		writeStart, probeStart := timer.start(), w.probe.WriteStart()
+		route := w.routes.lookup(item.record)
+		// With a batching sink the record's input is settled once its
+		// batch is flushed, not as the sink takes it.
+		writeCtx, settle := item.ack.forWrite(w.writesCtx, func(err error) {
+			logger.WarnContext(lineContext(ctx, item.line), "batched write failed, input record not acknowledged", "error", err, "line", item.line)
+		})
+		retries, err := writeWithRetry(writeCtx, w.sink, item.record, route.config(w.cfg), w.rep)
+		route.done(retries)
+		timer.record("writing", writeStart)
+		w.inflight.release(item.size)
+		w.probe.WriteDone(probeStart)
+		p.done()
+		if item.trace {
+			if err != nil {
+				traceStage(ctx, item.line, "sink", "decision", "failed", "error", err.Error(), "retries", retries, "dlq", w.dlq != nil)
+			} else {
+				traceStage(ctx, item.line, "sink", "decision", "written", "retries", retries)
+			}
+		}
+		if err != nil {
+			w.failed(ctx, item, route, shard, retries, err, settle)
+			continue
+		}
+		settle(true)
+		shard.AddWriteOK()
+		w.health.WriteOK()
+		w.notifier.RecordWritten(ctx)
+		if retries > 0 {
+			logger.DebugContext(ctx, "write succeeded after retries", "retries", retries)
+		}
+	}
+}
+
+// failed handles a record the sink refused after retries: it is spooled to
+// spill_dir when that takes it, else counted as failed and dead-lettered.
+// settle acknowledges the record's input or leaves it for redelivery.
+func (w *writeStage) failed(ctx context.Context, item workItem, route *routeWrite, shard *report.Shard, retries int, err error, settle func(ok bool)) {
+	// Log identifiers only; the record itself may be huge.
+	itemCtx := lineContext(ctx, item.line)
+	if errors.Is(err, errRetryBudget) && w.budget.exhaustedAt(item.line) {
+		logger.WarnContext(itemCtx, "sink retry budget exhausted, failed writes are not retried until the next window", "budget", w.cfg.SinkRetryBudget, "window", w.cfg.SinkRetryBudgetWindowDuration().String(), "line", item.line)
+		w.rep.SetRetryBudget(w.budget.stats())
+	}
+	if w.spill != nil && spillable(err) && w.spill.add(itemCtx, spillEntry{Line: item.line, Source: &item.src, Record: item.record, Raw: item.raw}) {
+		logger.WarnContext(itemCtx, "write failed, record spooled to spill_dir", "error", err, "retries", retries, "line", item.line, "service", item.record.Service)
+		settle(true)
+		return
+	}
+	shard.AddWriteFailed()
+	logger.WarnContext(itemCtx, "write failed", "error", err, "retries", retries, "line", item.line, "service", item.record.Service)
+	if w.dlq != nil {
+		rec := dlqRecord{Record: &item.record, rawJSON: item.raw, Line: item.line, Source: &item.src, Stage: dlqStageSink,
+			Reason: sinkDLQReason(err), Error: err.Error(), Attempts: retries + 1, RunID: w.rep.RunID, ack: item.ack}
+		if err := writeDLQ(itemCtx, w.dlq, rec, w.cfg, w.rep); err != nil {
+			w.stopReading(err)
+		}
+		route.deadLetter()
+	}
+	// Without a DLQ the input record is left for redelivery.
+	settle(w.dlq != nil)
+	if errors.Is(err, sink.ErrDiskFull) || errors.Is(err, sink.ErrResumeMismatch) {
+		w.stopReading(err)
+	}
+}
@@ -0,0 +1,90 @@
+//go:build wasip1
+
+// Command mask is an example WASM transform for the ETL "wasm" transform.
+// It masks any field whose key contains "password", drops health-check
+// records, and spins forever on the message "__spin__" so hosts can
+// exercise their execution timeout.
+//
+// Build it as a WASI reactor:
+//
+//	GOOS=wasip1 GOARCH=wasm go build -buildmode=c-shared -o mask.wasm ./examples/wasm/mask
+//
+// Guest ABI: the host calls alloc(len) to get a buffer, writes the record
+// JSON into it, then calls transform(ptr, len). transform returns
+// (resultPtr << 32 | resultLen) pointing at a JSON response
+// {"record": {...}, "drop": bool, "reason": "...", "error": "..."}.
+// The host releases both buffers with dealloc(ptr).
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"unsafe"
+)
+
+// buffers keeps host-visible allocations reachable until dealloc.
+var buffers = map[uint32][]byte{}
+
+func main() {}
+
+//go:wasmexport alloc
+func alloc(size uint32) uint32 {
+	if size == 0 {
+		size = 1
+	}
+	buf := make([]byte, size)
+	ptr := uint32(uintptr(unsafe.Pointer(&buf[0])))
+	buffers[ptr] = buf
+	return ptr
+}
+
+//go:wasmexport dealloc
+func dealloc(ptr uint32) {
+	delete(buffers, ptr)
+}
+
+//go:wasmexport transform
+func transform(ptr, size uint32) uint64 {
+	input := buffers[ptr][:size]
+	return respond(apply(input))
+}
+
+type response struct {
+	Record map[string]any `json:"record,omitempty"`
+	Drop   bool           `json:"drop"`
+	Reason string         `json:"reason,omitempty"`
+	Error  string         `json:"error,omitempty"`
+}
+
+func apply(input []byte) response {
+	var rec map[string]any
+	if err := json.Unmarshal(input, &rec); err != nil {
+		return response{Error: err.Error()}
+	}
+	msg, _ := rec["Message"].(string)
+	if msg == "__spin__" {
+		for {
+		}
+	}
+	if strings.HasPrefix(strings.ToLower(msg), "healthcheck") {
+		return response{Drop: true, Reason: "healthcheck"}
+	}
+	if fields, ok := rec["Fields"].(map[string]any); ok {
+		for k := range fields {
+			if strings.Contains(strings.ToLower(k), "password") {
+				fields[k] = "[masked]"
+			}
+		}
+	}
+	return response{Record: rec}
+}
+
+func respond(resp response) uint64 {
+	out, err := json.Marshal(resp)
+	if err != nil {
+		out = []byte(`{"error":"marshal response"}`)
+	}
+	ptr := alloc(uint32(len(out)))
+	copy(buffers[ptr], out)
+	return uint64(ptr)<<32 | uint64(len(out))
+}
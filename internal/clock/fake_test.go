@@ -0,0 +1,67 @@
+package clock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFakeAfterFiresOnAdvance(t *testing.T) {
+	f := NewFake(time.Unix(0, 0))
+	ch := f.After(time.Second)
+	f.Advance(999 * time.Millisecond)
+	select {
+	case <-ch:
+		t.Fatal("fired early")
+	default:
+	}
+	f.Advance(time.Millisecond)
+	select {
+	case got := <-ch:
+		if !got.Equal(time.Unix(1, 0)) {
+			t.Fatalf("unexpected fire time %v", got)
+		}
+	default:
+		t.Fatal("expected timer to fire")
+	}
+}
+
+func TestFakeTickerRepeatsAndStops(t *testing.T) {
+	f := NewFake(time.Unix(0, 0))
+	tk := f.NewTicker(10 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		f.Advance(10 * time.Millisecond)
+		select {
+		case <-tk.C():
+		default:
+			t.Fatalf("tick %d missing", i)
+		}
+	}
+	tk.Stop()
+	f.Advance(time.Second)
+	select {
+	case <-tk.C():
+		t.Fatal("tick after Stop")
+	default:
+	}
+}
+
+func TestFakeSleep(t *testing.T) {
+	f := NewFake(time.Unix(0, 0))
+	done := make(chan error, 1)
+	go func() { done <- f.Sleep(context.Background(), time.Minute) }()
+	f.BlockUntil(1)
+	f.Advance(time.Minute)
+	if err := <-done; err != nil {
+		t.Fatalf("Sleep: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() { done <- f.Sleep(ctx, time.Minute) }()
+	f.BlockUntil(1)
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancellation, got %v", err)
+	}
+}
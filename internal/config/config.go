@@ -6,63 +6,488 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
+
+	"k8s-log-etl/internal/fsutil"
+	"k8s-log-etl/internal/model"
 )
 
 // Config holds ETL runtime options.
 type Config struct {
-	InputPath         string   `json:"input,omitempty" yaml:"input,omitempty"`
-	OutputPath        string   `json:"output,omitempty" yaml:"output,omitempty"`
-	ReportPath        string   `json:"report,omitempty" yaml:"report,omitempty"`
-	OutputType        string   `json:"output_type,omitempty" yaml:"output_type,omitempty"` // stdout|file|rotate
-	OutputMaxB        int64    `json:"output_max_bytes,omitempty" yaml:"output_max_bytes,omitempty"`
-	OutputMaxFiles    int      `json:"output_max_files,omitempty" yaml:"output_max_files,omitempty"`
-	FilterLevels      []string `json:"filter_levels,omitempty" yaml:"filter_levels,omitempty"`
-	FilterSvcs        []string `json:"filter_services,omitempty" yaml:"filter_services,omitempty"`
-	RedactKeys        []string `json:"redact_keys,omitempty" yaml:"redact_keys,omitempty"`
-	Transforms        []string `json:"transforms,omitempty" yaml:"transforms,omitempty"`
-	MaxWorkers        int      `json:"max_workers,omitempty" yaml:"max_workers,omitempty"`
-	QueueSize         int      `json:"queue_size,omitempty" yaml:"queue_size,omitempty"`
-	SinkMaxRetries    int      `json:"sink_max_retries,omitempty" yaml:"sink_max_retries,omitempty"`
-	SinkBackoffBaseMS int      `json:"sink_backoff_base_ms,omitempty" yaml:"sink_backoff_base_ms,omitempty"`
-	SinkBackoffMaxMS  int      `json:"sink_backoff_max_ms,omitempty" yaml:"sink_backoff_max_ms,omitempty"`
-	SinkBackoffJitter float64  `json:"sink_backoff_jitter_pct,omitempty" yaml:"sink_backoff_jitter_pct,omitempty"`
-	DLQPath           string   `json:"dlq,omitempty" yaml:"dlq,omitempty"`
+	InputPath string `json:"input,omitempty" yaml:"input,omitempty"`
+	// Inputs lists several input files or glob patterns and takes
+	// precedence over InputPath. They are read one after another, or, with
+	// InputMergeSorted, k-way merged by timestamp; each file must then be
+	// JSONL in time order.
+	Inputs           []string `json:"inputs,omitempty" yaml:"inputs,omitempty"`
+	InputMergeSorted bool     `json:"input_merge_sorted,omitempty" yaml:"input_merge_sorted,omitempty"`
+	// InputIdleTimeout (a duration such as 5m) warns when no complete line
+	// has arrived for that long, and again each time it passes. With
+	// InputIdleAction exit the run then ends with exit code 75.
+	InputIdleTimeout string `json:"input_idle_timeout,omitempty" yaml:"input_idle_timeout,omitempty"`
+	InputIdleAction  string `json:"input_idle_action,omitempty" yaml:"input_idle_action,omitempty"`
+	// InputReopenOnEOF, when input is a named pipe, opens it again for the
+	// next writer once the current one closes it, so a restarting producer
+	// does not end the run. The run then ends on a shutdown signal.
+	InputReopenOnEOF bool `json:"input_reopen_on_eof,omitempty" yaml:"input_reopen_on_eof,omitempty"`
+	// InputFormat is auto (the default), jsonl, or json_array.
+	InputFormat string `json:"input_format,omitempty" yaml:"input_format,omitempty"`
+	// LinePruneBytes is the length past which a JSONL line is not held in
+	// memory: it is scanned as it is read and only the keys normalization
+	// reads are kept, with fields_truncated set. A negative value disables
+	// it. MaxLineBytes skips lines longer than it; 0 means no limit.
+	LinePruneBytes int `json:"line_prune_bytes,omitempty" yaml:"line_prune_bytes,omitempty"`
+	MaxLineBytes   int `json:"max_line_bytes,omitempty" yaml:"max_line_bytes,omitempty"`
+	// InputType is file (the default), reading InputPath, Inputs, or
+	// stdin, or nats. Input type nats reads the JetStream stream
+	// NATSInputStream through the servers in InputPath (comma-separated
+	// URLs) with the durable pull consumer NATSInputConsumer, created or
+	// updated at startup with NATSInputFilterSubject, NATSInputAckWait (a
+	// duration such as 30s), and NATSInputMaxAckPending, which bounds the
+	// messages in flight. Each message is an input line; it is
+	// acknowledged once its records are written or dead-lettered. The
+	// credentials are those of output type nats.
+	InputType              string `json:"input_type,omitempty" yaml:"input_type,omitempty"`
+	NATSInputStream        string `json:"nats_input_stream,omitempty" yaml:"nats_input_stream,omitempty"`
+	NATSInputConsumer      string `json:"nats_input_consumer,omitempty" yaml:"nats_input_consumer,omitempty"`
+	NATSInputFilterSubject string `json:"nats_input_filter_subject,omitempty" yaml:"nats_input_filter_subject,omitempty"`
+	NATSInputMaxAckPending int    `json:"nats_input_max_ack_pending,omitempty" yaml:"nats_input_max_ack_pending,omitempty"`
+	NATSInputAckWait       string `json:"nats_input_ack_wait,omitempty" yaml:"nats_input_ack_wait,omitempty"`
+	// Input type journald reads the systemd journal through journalctl
+	// -o json, run as JournaldCommand (journalctl when empty) with
+	// JournaldUnits as --unit filters. JournaldFollow keeps reading new
+	// entries, as --follow does, rather than ending at the end of the
+	// journal. JournaldCursorFile holds the cursor of the last entry
+	// written, so the next run resumes after it.
+	JournaldCommand    []string `json:"journald_command,omitempty" yaml:"journald_command,omitempty"`
+	JournaldUnits      []string `json:"journald_units,omitempty" yaml:"journald_units,omitempty"`
+	JournaldFollow     bool     `json:"journald_follow,omitempty" yaml:"journald_follow,omitempty"`
+	JournaldCursorFile string   `json:"journald_cursor_file,omitempty" yaml:"journald_cursor_file,omitempty"`
+	OutputPath         string   `json:"output,omitempty" yaml:"output,omitempty"`
+	ReportPath         string   `json:"report,omitempty" yaml:"report,omitempty"`
+	// ReportFormat is json (the default) or markdown, the format written
+	// to ReportPath. ReportMarkdownPath additionally writes the Markdown
+	// report there, next to a JSON one.
+	ReportFormat       string `json:"report_format,omitempty" yaml:"report_format,omitempty"`
+	ReportMarkdownPath string `json:"report_md,omitempty" yaml:"report_md,omitempty"`
+	// ReportSchema is legacy (the default), the flat JSON report, or v2,
+	// which splits it into operational and data_quality sections.
+	// ReportOpsPath and ReportQualityPath also write each section alone.
+	ReportSchema      string `json:"report_schema,omitempty" yaml:"report_schema,omitempty"`
+	ReportOpsPath     string `json:"report_ops_path,omitempty" yaml:"report_ops_path,omitempty"`
+	ReportQualityPath string `json:"report_quality_path,omitempty" yaml:"report_quality_path,omitempty"`
+	OutputType        string `json:"output_type,omitempty" yaml:"output_type,omitempty"` // stdout|file|rotate
+	OutputMaxB        int64  `json:"output_max_bytes,omitempty" yaml:"output_max_bytes,omitempty"`
+	OutputMaxFiles    int    `json:"output_max_files,omitempty" yaml:"output_max_files,omitempty"`
+	// An OutputPath with placeholders, such as out/{namespace}/{date}.jsonl,
+	// is resolved per record by the file and rotate sinks. {date} is the
+	// record's UTC timestamp in OutputPathDateLayout, a Go time layout, and
+	// at most OutputPathMaxOpen resolved files are kept open at once.
+	OutputPathDateLayout string `json:"output_path_date_layout,omitempty" yaml:"output_path_date_layout,omitempty"`
+	OutputPathMaxOpen    int    `json:"output_path_max_open,omitempty" yaml:"output_path_max_open,omitempty"`
+	// OutputAtomic makes the file sink write to <output>.tmp and rename it
+	// into place only when the run succeeds.
+	OutputAtomic bool `json:"output_atomic,omitempty" yaml:"output_atomic,omitempty"`
+	// OutputDoneMarker writes <output>.done with a run summary once the
+	// output file is complete.
+	OutputDoneMarker bool `json:"output_done_marker,omitempty" yaml:"output_done_marker,omitempty"`
+	// OutputResume makes the file sink pick up where an interrupted run
+	// over the same input left the output file: what the file already
+	// holds is checked against this run's output instead of written again.
+	OutputResume bool `json:"output_resume,omitempty" yaml:"output_resume,omitempty"`
+	// OutputManifest writes <output>.manifest.json listing every output
+	// file with its record count, size, and SHA-256.
+	OutputManifest bool `json:"output_manifest,omitempty" yaml:"output_manifest,omitempty"`
+	// OutputIndex trace_id writes <output>.idx next to the file sink's
+	// output, placing each record with a trace ID by file, byte offset,
+	// and length for etl lookup.
+	OutputIndex string `json:"output_index,omitempty" yaml:"output_index,omitempty"`
+	// OutputFields, when set, reduces every emitted record to these fields:
+	// normalized values by output name (ts, level, service, ...) and dotted
+	// paths into Fields (fields.http.status). Unset emits whole records.
+	OutputFields []string `json:"output_fields,omitempty" yaml:"output_fields,omitempty"`
+	// OutputSchema names the JSON keys of emitted and dead-lettered
+	// records: legacy (the default) keeps the Go field names TS, Level,
+	// Service, Namespace, Pod, Node, Message, TraceID, Error, Stacktrace,
+	// Caller, Fields; v1 uses ts, level, service, namespace, pod, node,
+	// message, trace_id, error, stacktrace, caller, fields, leaving out
+	// empty optional values.
+	OutputSchema string `json:"output_schema,omitempty" yaml:"output_schema,omitempty"`
+	// OutputEscapeHTML, OutputSortKeys, and OutputIndent set how JSON
+	// output and DLQ entries are encoded. <, >, and & are escaped unless
+	// OutputEscapeHTML is false; see OutputEscapeHTMLEnabled. OutputSortKeys
+	// orders every object's keys. OutputIndent pretty-prints each record
+	// over several lines, for reading a few records by eye; the DLQ ignores
+	// it so replay can still read one entry per line.
+	OutputEscapeHTML *bool `json:"output_escape_html,omitempty" yaml:"output_escape_html,omitempty"`
+	OutputSortKeys   bool  `json:"output_sort_keys,omitempty" yaml:"output_sort_keys,omitempty"`
+	OutputIndent     int   `json:"output_indent,omitempty" yaml:"output_indent,omitempty"`
+	// OutputFileMode and OutputDirMode are octal permissions, such as 0600
+	// and 0700, for the output, rotated, DLQ, and report files and the
+	// directories made for them; see FilePerm. Unset, the umask decides.
+	// OutputOwner, as uid:gid, chowns the same files when running as root.
+	// OutputBaseDir jails every file the run writes to the directory: a
+	// path outside it, or through a symbolic link below it, is refused.
+	OutputFileMode string `json:"output_file_mode,omitempty" yaml:"output_file_mode,omitempty"`
+	OutputDirMode  string `json:"output_dir_mode,omitempty" yaml:"output_dir_mode,omitempty"`
+	OutputOwner    string `json:"output_owner,omitempty" yaml:"output_owner,omitempty"`
+	OutputBaseDir  string `json:"output_base_dir,omitempty" yaml:"output_base_dir,omitempty"`
+	// ValidatePaths makes a run check, before reading any input, that the
+	// directories of the output, report, and DLQ files exist, creating
+	// them if not, and can be written. Unset means on; see
+	// ValidatePathsEnabled.
+	ValidatePaths *bool `json:"validate_paths,omitempty" yaml:"validate_paths,omitempty"`
+	// Preflight makes the same startup check probe the sink as well: a
+	// HEAD request to an http or clickhouse URL, a TCP connection to a grpc
+	// or nats server, and opening an existing output file for writing.
+	Preflight bool `json:"preflight,omitempty" yaml:"preflight,omitempty"`
+	// StrictConfig makes keys in the config file that no option uses an
+	// error rather than a warning.
+	StrictConfig bool `json:"strict_config,omitempty" yaml:"strict_config,omitempty"`
+	// RunManifestPath names a file run writes at startup with the
+	// effective config (secrets masked), build info, inputs, start time,
+	// and hostname, and rewrites at the end adding the end time, exit
+	// status, and report summary. RunManifestChecksums adds each input
+	// file's size and SHA-256, which means reading every input twice.
+	RunManifestPath      string `json:"run_manifest_path,omitempty" yaml:"run_manifest_path,omitempty"`
+	RunManifestChecksums bool   `json:"run_manifest_checksums,omitempty" yaml:"run_manifest_checksums,omitempty"`
+	// AvroRegistryURL, when set, frames avro output in the Confluent wire
+	// format with the schema's ID under AvroSubject, registering the
+	// schema if the subject lacks it.
+	AvroRegistryURL string `json:"avro_registry_url,omitempty" yaml:"avro_registry_url,omitempty"`
+	AvroSubject     string `json:"avro_subject,omitempty" yaml:"avro_subject,omitempty"`
+	// OutputFormat is json (the default), template, which renders each
+	// record as a line of text from OutputTemplate, a Go text/template,
+	// pretty, aligned and colored lines for a terminal showing PrettyFields
+	// (all Fields when empty), or avro, binary Avro datums.
+	OutputFormat   string   `json:"output_format,omitempty" yaml:"output_format,omitempty"`
+	OutputTemplate string   `json:"output_template,omitempty" yaml:"output_template,omitempty"`
+	PrettyFields   []string `json:"pretty_fields,omitempty" yaml:"pretty_fields,omitempty"`
+	FilterLevels   []string `json:"filter_levels,omitempty" yaml:"filter_levels,omitempty"`
+	FilterSvcs     []string `json:"filter_services,omitempty" yaml:"filter_services,omitempty"`
+	RedactKeys     []string `json:"redact_keys,omitempty" yaml:"redact_keys,omitempty"`
+	Transforms     []string `json:"transforms,omitempty" yaml:"transforms,omitempty"`
+	// TransformOnError sets per-transform error policies as "name=policy"
+	// entries; transforms not listed use OnErrorDrop.
+	TransformOnError []string `json:"transform_on_error,omitempty" yaml:"transform_on_error,omitempty"`
+	// TransformAudit stamps every record with _etl_transforms, the
+	// transforms that evaluated it and their outcomes, and counts in the
+	// report how far records got through the chain. For diagnosis only.
+	TransformAudit bool `json:"transform_audit,omitempty" yaml:"transform_audit,omitempty"`
+	// RedactionAudit makes filter_redact add _redacted_keys, the names of
+	// the redact_keys it removed, to each record it redacted. The values
+	// removed are never recorded.
+	RedactionAudit bool `json:"redaction_audit,omitempty" yaml:"redaction_audit,omitempty"`
+	// MetricsRules is the JSON rules file for the metrics_extract transform.
+	MetricsRules string `json:"metrics_rules,omitempty" yaml:"metrics_rules,omitempty"`
+	// NamespaceQuota caps each namespace's records for the namespace_quota
+	// transform, as "namespace=limit" entries: a record count, or a size
+	// with a B, KB, MB, or GB suffix. A namespace may have one of each. A
+	// "*" entry caps every namespace not listed, each on its own.
+	NamespaceQuota []string `json:"namespace_quota,omitempty" yaml:"namespace_quota,omitempty"`
+	// NamespaceQuotaInterval (a duration such as 1h) starts every quota
+	// afresh each interval; unset, a quota lasts the whole run.
+	NamespaceQuotaInterval string `json:"namespace_quota_interval,omitempty" yaml:"namespace_quota_interval,omitempty"`
+	// QuotaOverflow is drop, dropping a namespace's records past its
+	// quota with reason quota, or sample, which keeps one in every
+	// QuotaSampleEvery of them.
+	QuotaOverflow    string `json:"quota_overflow,omitempty" yaml:"quota_overflow,omitempty"`
+	QuotaSampleEvery int    `json:"quota_sample_every,omitempty" yaml:"quota_sample_every,omitempty"`
+	// TraceRecord, "field=value", logs every stage decision at info level
+	// for the records whose field has that value, for debugging where a
+	// record went.
+	TraceRecord string `json:"trace_record,omitempty" yaml:"trace_record,omitempty"`
+	// DropRulesFile lists known-noise message patterns, one per line, that
+	// drop records right after normalization. With DropRulesReload it is
+	// read again on SIGHUP.
+	DropRulesFile   string `json:"drop_rules_file,omitempty" yaml:"drop_rules_file,omitempty"`
+	DropRulesReload bool   `json:"drop_rules_reload,omitempty" yaml:"drop_rules_reload,omitempty"`
+	// MetricsTextfilePath, when set, receives the Prometheus report at the
+	// end of every run, successful or not, for node_exporter's textfile
+	// collector.
+	MetricsTextfilePath string `json:"metrics_textfile_path,omitempty" yaml:"metrics_textfile_path,omitempty"`
+	// Exec transform: child process command (argv) and per-record timeout.
+	ExecCommand   []string `json:"exec_command,omitempty" yaml:"exec_command,omitempty"`
+	ExecTimeoutMS int      `json:"exec_timeout_ms,omitempty" yaml:"exec_timeout_ms,omitempty"`
+	// WASM transform: module path, per-record timeout, and guest memory limit.
+	WasmModule        string `json:"wasm_module,omitempty" yaml:"wasm_module,omitempty"`
+	WasmTimeoutMS     int    `json:"wasm_timeout_ms,omitempty" yaml:"wasm_timeout_ms,omitempty"`
+	WasmMemoryLimitMB int    `json:"wasm_memory_limit_mb,omitempty" yaml:"wasm_memory_limit_mb,omitempty"`
+	MaxWorkers        int    `json:"max_workers,omitempty" yaml:"max_workers,omitempty"`
+	QueueSize         int    `json:"queue_size,omitempty" yaml:"queue_size,omitempty"`
+	// QueuePriorityByLevel hands queued ERROR and FATAL records to the
+	// workers first, then WARN, then the rest, instead of in input order.
+	QueuePriorityByLevel bool `json:"queue_priority_by_level,omitempty" yaml:"queue_priority_by_level,omitempty"`
+	// MaxInflightBytes caps the estimated bytes of records queued for the
+	// sink but not yet written; reading pauses while it is reached. 0
+	// leaves only queue_size.
+	MaxInflightBytes  int64   `json:"max_inflight_bytes,omitempty" yaml:"max_inflight_bytes,omitempty"`
+	SinkMaxRetries    int     `json:"sink_max_retries,omitempty" yaml:"sink_max_retries,omitempty"`
+	SinkBackoffBaseMS int     `json:"sink_backoff_base_ms,omitempty" yaml:"sink_backoff_base_ms,omitempty"`
+	SinkBackoffMaxMS  int     `json:"sink_backoff_max_ms,omitempty" yaml:"sink_backoff_max_ms,omitempty"`
+	SinkBackoffJitter float64 `json:"sink_backoff_jitter_pct,omitempty" yaml:"sink_backoff_jitter_pct,omitempty"`
+	// RetryJitterSeed seeds the backoff jitter so a rerun retries on the
+	// same schedule; 0 seeds it from the clock. run records the seed it
+	// used in the run manifest.
+	RetryJitterSeed int64 `json:"retry_jitter_seed,omitempty" yaml:"retry_jitter_seed,omitempty"`
+	// SinkWriteTimeoutMS bounds each sink write attempt; 0 disables the timeout.
+	SinkWriteTimeoutMS int `json:"sink_write_timeout_ms,omitempty" yaml:"sink_write_timeout_ms,omitempty"`
+	// StallWarnAfter (a duration such as 2m) warns when a sink worker has
+	// held one record for that long, and again each time the wait
+	// doubles. With StallAction abort the run is stopped instead.
+	StallWarnAfter string `json:"stall_warn_after,omitempty" yaml:"stall_warn_after,omitempty"`
+	StallAction    string `json:"stall_action,omitempty" yaml:"stall_action,omitempty"`
+	// SinkRetryBudget (a duration such as 10m) caps the backoff time all
+	// writes spend together in each SinkRetryBudgetWindow (a duration,
+	// default 1h); once it is spent, failed writes are not retried until
+	// the next window.
+	SinkRetryBudget       string `json:"sink_retry_budget,omitempty" yaml:"sink_retry_budget,omitempty"`
+	SinkRetryBudgetWindow string `json:"sink_retry_budget_window,omitempty" yaml:"sink_retry_budget_window,omitempty"`
+	// HTTPMaxIdleConns, HTTPMaxConnsPerHost and HTTPIdleConnTimeout (a
+	// duration such as 90s) tune the connection pool of the http sink; 0
+	// or unset takes the default of 100 idle connections, no cap and 90s.
+	HTTPMaxIdleConns    int    `json:"http_max_idle_conns,omitempty" yaml:"http_max_idle_conns,omitempty"`
+	HTTPMaxConnsPerHost int    `json:"http_max_conns_per_host,omitempty" yaml:"http_max_conns_per_host,omitempty"`
+	HTTPIdleConnTimeout string `json:"http_idle_conn_timeout,omitempty" yaml:"http_idle_conn_timeout,omitempty"`
+	// HTTPCompress gzip compresses the http sink's request bodies.
+	HTTPCompress string `json:"http_compress,omitempty" yaml:"http_compress,omitempty"`
+	// HTTPAuthToken is sent by the http sink as a bearer token. It is best
+	// read from the file HTTPAuthTokenFile names; see ReadSecretFiles.
+	HTTPAuthToken     string `json:"http_auth_token,omitempty" yaml:"http_auth_token,omitempty" secret:"http_auth_token"`
+	HTTPAuthTokenFile string `json:"http_auth_token_file,omitempty" yaml:"http_auth_token_file,omitempty"`
+	// Output type clickhouse inserts into ClickHouseTable through the HTTP
+	// interface at OutputPath, gzipping bodies when ClickHouseGzip is set.
+	// The credentials come only from ETL_CLICKHOUSE_USER and
+	// ETL_CLICKHOUSE_PASSWORD, or the password from the file
+	// ClickHousePasswordFile names, so they stay out of config files and
+	// validate output.
+	ClickHouseDatabase     string `json:"clickhouse_database,omitempty" yaml:"clickhouse_database,omitempty"`
+	ClickHouseTable        string `json:"clickhouse_table,omitempty" yaml:"clickhouse_table,omitempty"`
+	ClickHouseGzip         bool   `json:"clickhouse_gzip,omitempty" yaml:"clickhouse_gzip,omitempty"`
+	ClickHouseUser         string `json:"-" yaml:"-"`
+	ClickHousePassword     string `json:"-" yaml:"-" secret:"clickhouse_password"`
+	ClickHousePasswordFile string `json:"clickhouse_password_file,omitempty" yaml:"clickhouse_password_file,omitempty"`
+	// Output type grpc streams records to the LogSink service of
+	// docs/logsink.proto at OutputPath (host:port). GRPCTLS connects with
+	// TLS, verifying the server against the system roots or the PEM
+	// certificates in GRPCCAFile. GRPCAuthToken is sent as a bearer token.
+	GRPCTLS           bool   `json:"grpc_tls,omitempty" yaml:"grpc_tls,omitempty"`
+	GRPCCAFile        string `json:"grpc_ca_file,omitempty" yaml:"grpc_ca_file,omitempty"`
+	GRPCAuthToken     string `json:"grpc_auth_token,omitempty" yaml:"grpc_auth_token,omitempty" secret:"grpc_auth_token"`
+	GRPCAuthTokenFile string `json:"grpc_auth_token_file,omitempty" yaml:"grpc_auth_token_file,omitempty"`
+	// Output type nats publishes to JetStream through the servers in
+	// OutputPath (comma-separated URLs), on NATSSubject with {service},
+	// {namespace}, and {level} filled in. Up to NATSMaxInFlight publishes
+	// wait for acks, each for NATSAckTimeout (a duration such as 5s). The
+	// credentials come from NATSCredsFile, or only from ETL_NATS_USER,
+	// ETL_NATS_PASSWORD, and ETL_NATS_TOKEN.
+	NATSSubject     string `json:"nats_subject,omitempty" yaml:"nats_subject,omitempty"`
+	NATSCredsFile   string `json:"nats_creds_file,omitempty" yaml:"nats_creds_file,omitempty"`
+	NATSMaxInFlight int    `json:"nats_max_in_flight,omitempty" yaml:"nats_max_in_flight,omitempty"`
+	NATSAckTimeout  string `json:"nats_ack_timeout,omitempty" yaml:"nats_ack_timeout,omitempty"`
+	NATSUser        string `json:"-" yaml:"-"`
+	NATSPassword    string `json:"-" yaml:"-"`
+	NATSToken       string `json:"-" yaml:"-"`
+	// Output type object stores records in the object store at OutputPath
+	// (gs://bucket/prefix or azblob://container/prefix), one object per
+	// OutputMaxB bytes or ObjectMaxAgeSeconds; negative disables the age
+	// limit.
+	ObjectMaxAgeSeconds int `json:"object_max_age_seconds,omitempty" yaml:"object_max_age_seconds,omitempty"`
+	// Output type faulty wraps the FaultyInner sink (stdout when empty) and
+	// fails writes on purpose, for resilience drills: with probability
+	// FaultyFailRate, on every FaultyFailEvery-th attempt, and on every
+	// attempt once FaultyFailAfter records have been written. Each attempt
+	// is delayed by FaultyLatencyMS. FaultySeed makes the random failures
+	// repeatable.
+	FaultyInner     string  `json:"faulty_inner,omitempty" yaml:"faulty_inner,omitempty"`
+	FaultyFailRate  float64 `json:"faulty_fail_rate,omitempty" yaml:"faulty_fail_rate,omitempty"`
+	FaultyFailEvery int     `json:"faulty_fail_every,omitempty" yaml:"faulty_fail_every,omitempty"`
+	FaultyFailAfter int     `json:"faulty_fail_after,omitempty" yaml:"faulty_fail_after,omitempty"`
+	FaultyLatencyMS int     `json:"faulty_latency_ms,omitempty" yaml:"faulty_latency_ms,omitempty"`
+	FaultySeed      int64   `json:"faulty_seed,omitempty" yaml:"faulty_seed,omitempty"`
+	// Output type router sends each record to one of RouterRoutes, each
+	// "name=output_type:output" (e.g. payments=http:https://...), opened
+	// with the rest of this config. RouterRules, "conditions -> name", are
+	// tried in order and the first whose conditions all hold picks the
+	// route; records no rule matches go to RouterDefault, or fail as
+	// unroutable, and so to the DLQ, when it is unset.
+	RouterRoutes  []string `json:"router_routes,omitempty" yaml:"router_routes,omitempty"`
+	RouterRules   []string `json:"router_rules,omitempty" yaml:"router_rules,omitempty"`
+	RouterDefault string   `json:"router_default,omitempty" yaml:"router_default,omitempty"`
+	// RouteByLevel, "LEVEL|LEVEL -> name", routes records by level alone,
+	// tried after RouterRules. RouterRouteOptions, "name key=value ...",
+	// give a route its own batching and retry settings in place of the
+	// top-level ones.
+	RouteByLevel       []string `json:"route_by_level,omitempty" yaml:"route_by_level,omitempty"`
+	RouterRouteOptions []string `json:"router_route_options,omitempty" yaml:"router_route_options,omitempty"`
+	DLQPath            string   `json:"dlq,omitempty" yaml:"dlq,omitempty"`
+	// DLQNormalizeFailures dead-letters records that fail normalization,
+	// keeping the parsed input so replay can re-normalize them.
+	DLQNormalizeFailures bool `json:"dlq_normalize_failures,omitempty" yaml:"dlq_normalize_failures,omitempty"`
+	// StrictJSON scans every parsed line for keys repeated within one
+	// object, which decoding resolves to the last value. DLQDuplicateKeys
+	// dead-letters the records of such lines instead of processing them.
+	StrictJSON       bool `json:"strict_json,omitempty" yaml:"strict_json,omitempty"`
+	DLQDuplicateKeys bool `json:"dlq_duplicate_keys,omitempty" yaml:"dlq_duplicate_keys,omitempty"`
+	// JSONSchemaFile is a JSON Schema every parsed record is validated
+	// against before normalization. SchemaAction says what happens to the
+	// records that fail it: warn (the default) counts them and lets them
+	// through, drop drops them, and dlq dead-letters them with the
+	// validation message.
+	JSONSchemaFile string `json:"json_schema_file,omitempty" yaml:"json_schema_file,omitempty"`
+	SchemaAction   string `json:"schema_action,omitempty" yaml:"schema_action,omitempty"`
+	// DLQMaxRecordBytes caps the encoded size of a DLQ entry; larger entries
+	// keep their core fields and drop the rest. A negative value disables it.
+	DLQMaxRecordBytes int `json:"dlq_max_record_bytes,omitempty" yaml:"dlq_max_record_bytes,omitempty"`
+	// DLQMaxRecords and DLQMaxBytes cap the entries and bytes written to
+	// the DLQ in a run; 0 means no cap. Past a cap DLQOverflowPolicy either
+	// drops further entries or aborts the run.
+	DLQMaxRecords     int    `json:"dlq_max_records,omitempty" yaml:"dlq_max_records,omitempty"`
+	DLQMaxBytes       int64  `json:"dlq_max_bytes,omitempty" yaml:"dlq_max_bytes,omitempty"`
+	DLQOverflowPolicy string `json:"dlq_overflow_policy,omitempty" yaml:"dlq_overflow_policy,omitempty"`
+	// DLQPayload is what a DLQ entry carries of the failed record: the
+	// normalized record (the default), the raw input, or both.
+	DLQPayload string `json:"dlq_payload,omitempty" yaml:"dlq_payload,omitempty"`
+	// SpillDir, when set, spools records that failed every retry to files
+	// in this directory instead of the DLQ, and writes them to the sink
+	// once it recovers. SpillMaxBytes bounds the directory, 1 GiB when 0;
+	// records past it go to the DLQ.
+	SpillDir      string `json:"spill_dir,omitempty" yaml:"spill_dir,omitempty"`
+	SpillMaxBytes int64  `json:"spill_max_bytes,omitempty" yaml:"spill_max_bytes,omitempty"`
+	// TopMessages is how many of the most frequent message templates the
+	// report lists; a negative value disables tracking.
+	TopMessages int `json:"top_messages,omitempty" yaml:"top_messages,omitempty"`
+	// ReportMaxLabelValues caps how many levels, services, and namespaces
+	// the report counts apiece; records with any other value are counted
+	// under __other__. A negative value removes the cap.
+	ReportMaxLabelValues int `json:"report_max_label_values,omitempty" yaml:"report_max_label_values,omitempty"`
+	// StampRunMetadata adds _etl_run_id and _etl_host to every record's Fields.
+	StampRunMetadata bool `json:"stamp_run_metadata,omitempty" yaml:"stamp_run_metadata,omitempty"`
+	// StampProvenance adds _src_file and _src_line, where the record was
+	// read, to every record's Fields.
+	StampProvenance bool `json:"stamp_provenance,omitempty" yaml:"stamp_provenance,omitempty"`
+	// SanitizeMessages cleans control characters, invalid UTF-8, and long
+	// runs of whitespace out of every record's message and string fields.
+	SanitizeMessages bool `json:"sanitize_messages,omitempty" yaml:"sanitize_messages,omitempty"`
+	// DeriveServiceFromPod fills an empty service from the pod name, e.g.
+	// payments-api-7d9f8b6c4-xk2lp -> payments-api.
+	DeriveServiceFromPod bool `json:"derive_service_from_pod,omitempty" yaml:"derive_service_from_pod,omitempty"`
+	// UnwrapKeys name keys, such as fluentd's log, whose string value holds
+	// the original record; it is merged in before normalization. On key
+	// collisions UnwrapConflict picks the inner (default) or outer value.
+	UnwrapKeys     []string `json:"unwrap_keys,omitempty" yaml:"unwrap_keys,omitempty"`
+	UnwrapConflict string   `json:"unwrap_conflict,omitempty" yaml:"unwrap_conflict,omitempty"`
+	// SortWindow (a duration such as 10s) reorders records by timestamp,
+	// holding each until the newest timestamp seen is the window past it.
+	// SortMaxRecords caps the records held; past it the oldest is written
+	// early. A negative cap disables it.
+	SortWindow     string `json:"sort_window,omitempty" yaml:"sort_window,omitempty"`
+	SortMaxRecords int    `json:"sort_max_records,omitempty" yaml:"sort_max_records,omitempty"`
+	// TimestampShift moves every record's timestamp by a fixed duration
+	// such as +72h, or with rebase-now by whatever makes the input's latest
+	// record current. Setting it adds the timestamp_shift transform to the
+	// end of the chain unless transforms already lists it.
+	TimestampShift string `json:"timestamp_shift,omitempty" yaml:"timestamp_shift,omitempty"`
+	// ReplaySpeed paces sink writes by the gaps between the records'
+	// timestamps divided by it: 1 writes at the original rate, 2 twice as
+	// fast. A gap waits at most ReplayMaxSleep (a duration, default 10s).
+	// It needs max_workers 1 so records are written in order.
+	ReplaySpeed    float64 `json:"replay_speed,omitempty" yaml:"replay_speed,omitempty"`
+	ReplayMaxSleep string  `json:"replay_max_sleep,omitempty" yaml:"replay_max_sleep,omitempty"`
+	// TimestampShiftLatest is the input's latest event time, which
+	// rebase-now moves to the time the transform is built. It is not a
+	// config key: the run finds it by reading the input files first.
+	TimestampShiftLatest time.Time `json:"-" yaml:"-"`
+	// Skip ignores the first Skip non-empty input lines before parsing.
+	// Head stops reading once Head records have been queued for the sink,
+	// then drains as at the end of the input. 0 disables either.
+	Skip int `json:"skip,omitempty" yaml:"skip,omitempty"`
+	Head int `json:"head,omitempty" yaml:"head,omitempty"`
+	// MaxDuration (a duration such as 14m) stops reading once the pipeline
+	// has run that long, then drains like Head, and the run exits with
+	// code 124.
+	MaxDuration string `json:"max_duration,omitempty" yaml:"max_duration,omitempty"`
+	// StageTimings controls the per-stage timings in the report. Unset means
+	// on; see StageTimingsEnabled.
+	StageTimings *bool `json:"stage_timings,omitempty" yaml:"stage_timings,omitempty"`
 	// Batching configuration
-	BatchSize         int      `json:"batch_size,omitempty" yaml:"batch_size,omitempty"`
-	BatchFlushInterval int      `json:"batch_flush_interval_ms,omitempty" yaml:"batch_flush_interval_ms,omitempty"`
+	BatchSize          int `json:"batch_size,omitempty" yaml:"batch_size,omitempty"`
+	BatchFlushInterval int `json:"batch_flush_interval_ms,omitempty" yaml:"batch_flush_interval_ms,omitempty"`
+	// Aggregation: when AggregateWindowSeconds > 0 the sink receives one
+	// count row per event-time window and group instead of records.
+	AggregateWindowSeconds   int      `json:"aggregate_window_seconds,omitempty" yaml:"aggregate_window_seconds,omitempty"`
+	AggregateGroupBy         []string `json:"aggregate_group_by,omitempty" yaml:"aggregate_group_by,omitempty"`
+	AggregateField           string   `json:"aggregate_field,omitempty" yaml:"aggregate_field,omitempty"`
+	AggregateLatenessSeconds int      `json:"aggregate_lateness_seconds,omitempty" yaml:"aggregate_lateness_seconds,omitempty"`
+	AggregateLate            string   `json:"aggregate_late,omitempty" yaml:"aggregate_late,omitempty"` // drop|amend
+	AggregateMaxBuckets      int      `json:"aggregate_max_buckets,omitempty" yaml:"aggregate_max_buckets,omitempty"`
 	// Shutdown configuration
 	ShutdownTimeoutSeconds int `json:"shutdown_timeout_seconds,omitempty" yaml:"shutdown_timeout_seconds,omitempty"`
+	// Health probes. HealthListen (host:port) enables /healthz and /readyz.
+	// /readyz fails when no write has succeeded for HealthStaleSeconds
+	// (0 = never) or the queue has been full for HealthQueueFullSeconds
+	// (negative disables).
+	HealthListen           string `json:"health_listen,omitempty" yaml:"health_listen,omitempty"`
+	HealthStaleSeconds     int    `json:"health_stale_seconds,omitempty" yaml:"health_stale_seconds,omitempty"`
+	HealthQueueFullSeconds int    `json:"health_queue_full_seconds,omitempty" yaml:"health_queue_full_seconds,omitempty"`
+	// Profiling. PprofListen serves net/http/pprof, sharing the health
+	// server when both use the same address. CPUProfile writes a CPU profile
+	// covering the whole run.
+	PprofListen string `json:"pprof_listen,omitempty" yaml:"pprof_listen,omitempty"`
+	CPUProfile  string `json:"cpu_profile,omitempty" yaml:"cpu_profile,omitempty"`
 	// Logging configuration
-	LogLevel            string `json:"log_level,omitempty" yaml:"log_level,omitempty"` // debug, info, warn, error
-	LogFormat           string `json:"log_format,omitempty" yaml:"log_format,omitempty"` // json, text
+	LogLevel  string `json:"log_level,omitempty" yaml:"log_level,omitempty"`   // debug, info, warn, error
+	LogFormat string `json:"log_format,omitempty" yaml:"log_format,omitempty"` // json, text
+
+	// unknownKeys and keyWarnings are what Load found among the config
+	// file's keys: ones no option uses, which Validate rejects under
+	// StrictConfig and Warnings reports otherwise, and renamed ones.
+	unknownKeys []string
+	keyWarnings []string
 }
 
+// DefaultLinePruneBytes is the line_prune_bytes of Default: lines past
+// 1 MiB are pruned.
+const DefaultLinePruneBytes = 1 << 20
+
 // Default returns a Config with sensible defaults.
 func Default() Config {
 	return Config{
 		// Maintain legacy behavior of reading bundled sample logs.
-		InputPath:         "examples/k8s_logs.jsonl",
-		ReportPath:        "report.json",
-		OutputType:        "stdout",
-		OutputMaxB:        10 * 1024 * 1024, // 10 MiB default rotation threshold
-		OutputMaxFiles:    5,
-		FilterLevels:      []string{"WARN", "ERROR"},
-		Transforms:        []string{"filter_redact"},
-		MaxWorkers:        4,
-		QueueSize:         128,
-		SinkMaxRetries:    3,
-		SinkBackoffBaseMS: 100,
-		SinkBackoffMaxMS:  2000,
-		SinkBackoffJitter: 0.2,
-		BatchSize:         100,
-		BatchFlushInterval: 1000, // 1 second
+		InputPath:              "examples/k8s_logs.jsonl",
+		ReportPath:             "report.json",
+		OutputType:             "stdout",
+		OutputMaxB:             10 * 1024 * 1024, // 10 MiB default rotation threshold
+		OutputMaxFiles:         5,
+		ObjectMaxAgeSeconds:    300,
+		FilterLevels:           []string{"WARN", "ERROR"},
+		Transforms:             []string{"filter_redact"},
+		TopMessages:            20,
+		ReportMaxLabelValues:   1000,
+		QuotaSampleEvery:       100,
+		SortMaxRecords:         100000,
+		DLQMaxRecordBytes:      64 * 1024,
+		LinePruneBytes:         DefaultLinePruneBytes,
+		MaxWorkers:             4,
+		QueueSize:              128,
+		SinkMaxRetries:         3,
+		SinkBackoffBaseMS:      100,
+		SinkBackoffMaxMS:       2000,
+		SinkBackoffJitter:      0.2,
+		BatchSize:              100,
+		BatchFlushInterval:     1000, // 1 second
 		ShutdownTimeoutSeconds: 30,
-		LogLevel:           "info",
-		LogFormat:          "json",
+		HealthQueueFullSeconds: 30,
+		LogLevel:               "info",
+		LogFormat:              "json",
 	}
 }
 
@@ -73,6 +498,60 @@ func Merge(base, override Config) Config {
 	if override.InputPath != "" {
 		result.InputPath = override.InputPath
 	}
+	if len(override.Inputs) > 0 {
+		result.Inputs = override.Inputs
+	}
+	if override.InputMergeSorted {
+		result.InputMergeSorted = true
+	}
+	if override.InputIdleTimeout != "" {
+		result.InputIdleTimeout = override.InputIdleTimeout
+	}
+	if override.InputIdleAction != "" {
+		result.InputIdleAction = override.InputIdleAction
+	}
+	if override.InputReopenOnEOF {
+		result.InputReopenOnEOF = true
+	}
+	if override.InputFormat != "" {
+		result.InputFormat = override.InputFormat
+	}
+	if override.LinePruneBytes != 0 {
+		result.LinePruneBytes = override.LinePruneBytes
+	}
+	if override.MaxLineBytes != 0 {
+		result.MaxLineBytes = override.MaxLineBytes
+	}
+	if override.InputType != "" {
+		result.InputType = override.InputType
+	}
+	if override.NATSInputStream != "" {
+		result.NATSInputStream = override.NATSInputStream
+	}
+	if override.NATSInputConsumer != "" {
+		result.NATSInputConsumer = override.NATSInputConsumer
+	}
+	if override.NATSInputFilterSubject != "" {
+		result.NATSInputFilterSubject = override.NATSInputFilterSubject
+	}
+	if override.NATSInputMaxAckPending != 0 {
+		result.NATSInputMaxAckPending = override.NATSInputMaxAckPending
+	}
+	if override.NATSInputAckWait != "" {
+		result.NATSInputAckWait = override.NATSInputAckWait
+	}
+	if len(override.JournaldCommand) > 0 {
+		result.JournaldCommand = override.JournaldCommand
+	}
+	if len(override.JournaldUnits) > 0 {
+		result.JournaldUnits = override.JournaldUnits
+	}
+	if override.JournaldFollow {
+		result.JournaldFollow = true
+	}
+	if override.JournaldCursorFile != "" {
+		result.JournaldCursorFile = override.JournaldCursorFile
+	}
 	if override.OutputPath != "" {
 		result.OutputPath = override.OutputPath
 	}
@@ -85,9 +564,87 @@ func Merge(base, override Config) Config {
 	if override.OutputMaxFiles != 0 {
 		result.OutputMaxFiles = override.OutputMaxFiles
 	}
+	if override.OutputPathDateLayout != "" {
+		result.OutputPathDateLayout = override.OutputPathDateLayout
+	}
+	if override.OutputPathMaxOpen != 0 {
+		result.OutputPathMaxOpen = override.OutputPathMaxOpen
+	}
+	if override.OutputAtomic {
+		result.OutputAtomic = true
+	}
+	if override.OutputDoneMarker {
+		result.OutputDoneMarker = true
+	}
+	if override.OutputResume {
+		result.OutputResume = true
+	}
+	if override.OutputIndex != "" {
+		result.OutputIndex = override.OutputIndex
+	}
+	if override.OutputManifest {
+		result.OutputManifest = true
+	}
+	if len(override.OutputFields) > 0 {
+		result.OutputFields = override.OutputFields
+	}
+	if override.OutputFormat != "" {
+		result.OutputFormat = override.OutputFormat
+	}
+	if override.OutputSchema != "" {
+		result.OutputSchema = override.OutputSchema
+	}
+	if override.OutputTemplate != "" {
+		result.OutputTemplate = override.OutputTemplate
+	}
+	if override.OutputEscapeHTML != nil {
+		result.OutputEscapeHTML = override.OutputEscapeHTML
+	}
+	if override.OutputSortKeys {
+		result.OutputSortKeys = true
+	}
+	if override.OutputIndent != 0 {
+		result.OutputIndent = override.OutputIndent
+	}
+	if override.OutputFileMode != "" {
+		result.OutputFileMode = override.OutputFileMode
+	}
+	if override.OutputDirMode != "" {
+		result.OutputDirMode = override.OutputDirMode
+	}
+	if override.OutputOwner != "" {
+		result.OutputOwner = override.OutputOwner
+	}
+	if override.OutputBaseDir != "" {
+		result.OutputBaseDir = override.OutputBaseDir
+	}
+	if override.AvroRegistryURL != "" {
+		result.AvroRegistryURL = override.AvroRegistryURL
+	}
+	if override.AvroSubject != "" {
+		result.AvroSubject = override.AvroSubject
+	}
+	if len(override.PrettyFields) > 0 {
+		result.PrettyFields = override.PrettyFields
+	}
 	if override.ReportPath != "" {
 		result.ReportPath = override.ReportPath
 	}
+	if override.ReportFormat != "" {
+		result.ReportFormat = override.ReportFormat
+	}
+	if override.ReportMarkdownPath != "" {
+		result.ReportMarkdownPath = override.ReportMarkdownPath
+	}
+	if override.ReportSchema != "" {
+		result.ReportSchema = override.ReportSchema
+	}
+	if override.ReportOpsPath != "" {
+		result.ReportOpsPath = override.ReportOpsPath
+	}
+	if override.ReportQualityPath != "" {
+		result.ReportQualityPath = override.ReportQualityPath
+	}
 	if len(override.FilterLevels) > 0 {
 		result.FilterLevels = override.FilterLevels
 	}
@@ -100,12 +657,69 @@ func Merge(base, override Config) Config {
 	if len(override.Transforms) > 0 {
 		result.Transforms = override.Transforms
 	}
+	if len(override.TransformOnError) > 0 {
+		result.TransformOnError = override.TransformOnError
+	}
+	if override.TopMessages != 0 {
+		result.TopMessages = override.TopMessages
+	}
+	if override.ReportMaxLabelValues != 0 {
+		result.ReportMaxLabelValues = override.ReportMaxLabelValues
+	}
+	if override.MetricsRules != "" {
+		result.MetricsRules = override.MetricsRules
+	}
+	if len(override.NamespaceQuota) > 0 {
+		result.NamespaceQuota = override.NamespaceQuota
+	}
+	if override.NamespaceQuotaInterval != "" {
+		result.NamespaceQuotaInterval = override.NamespaceQuotaInterval
+	}
+	if override.QuotaOverflow != "" {
+		result.QuotaOverflow = override.QuotaOverflow
+	}
+	if override.QuotaSampleEvery != 0 {
+		result.QuotaSampleEvery = override.QuotaSampleEvery
+	}
+	if override.TraceRecord != "" {
+		result.TraceRecord = override.TraceRecord
+	}
+	if override.DropRulesFile != "" {
+		result.DropRulesFile = override.DropRulesFile
+	}
+	if override.DropRulesReload {
+		result.DropRulesReload = true
+	}
+	if override.MetricsTextfilePath != "" {
+		result.MetricsTextfilePath = override.MetricsTextfilePath
+	}
+	if len(override.ExecCommand) > 0 {
+		result.ExecCommand = override.ExecCommand
+	}
+	if override.ExecTimeoutMS > 0 {
+		result.ExecTimeoutMS = override.ExecTimeoutMS
+	}
+	if override.WasmModule != "" {
+		result.WasmModule = override.WasmModule
+	}
+	if override.WasmTimeoutMS > 0 {
+		result.WasmTimeoutMS = override.WasmTimeoutMS
+	}
+	if override.WasmMemoryLimitMB > 0 {
+		result.WasmMemoryLimitMB = override.WasmMemoryLimitMB
+	}
 	if override.MaxWorkers > 0 {
 		result.MaxWorkers = override.MaxWorkers
 	}
 	if override.QueueSize > 0 {
 		result.QueueSize = override.QueueSize
 	}
+	if override.QueuePriorityByLevel {
+		result.QueuePriorityByLevel = true
+	}
+	if override.MaxInflightBytes > 0 {
+		result.MaxInflightBytes = override.MaxInflightBytes
+	}
 	if override.SinkMaxRetries > 0 {
 		result.SinkMaxRetries = override.SinkMaxRetries
 	}
@@ -118,18 +732,278 @@ func Merge(base, override Config) Config {
 	if override.SinkBackoffJitter > 0 {
 		result.SinkBackoffJitter = override.SinkBackoffJitter
 	}
+	if override.RetryJitterSeed != 0 {
+		result.RetryJitterSeed = override.RetryJitterSeed
+	}
+	if override.SinkWriteTimeoutMS > 0 {
+		result.SinkWriteTimeoutMS = override.SinkWriteTimeoutMS
+	}
+	if override.StallWarnAfter != "" {
+		result.StallWarnAfter = override.StallWarnAfter
+	}
+	if override.StallAction != "" {
+		result.StallAction = override.StallAction
+	}
+	if override.SinkRetryBudget != "" {
+		result.SinkRetryBudget = override.SinkRetryBudget
+	}
+	if override.SinkRetryBudgetWindow != "" {
+		result.SinkRetryBudgetWindow = override.SinkRetryBudgetWindow
+	}
+	if override.HTTPMaxIdleConns != 0 {
+		result.HTTPMaxIdleConns = override.HTTPMaxIdleConns
+	}
+	if override.HTTPMaxConnsPerHost != 0 {
+		result.HTTPMaxConnsPerHost = override.HTTPMaxConnsPerHost
+	}
+	if override.HTTPIdleConnTimeout != "" {
+		result.HTTPIdleConnTimeout = override.HTTPIdleConnTimeout
+	}
+	if override.HTTPCompress != "" {
+		result.HTTPCompress = override.HTTPCompress
+	}
+	if override.HTTPAuthToken != "" {
+		result.HTTPAuthToken = override.HTTPAuthToken
+	}
+	if override.HTTPAuthTokenFile != "" {
+		result.HTTPAuthTokenFile = override.HTTPAuthTokenFile
+	}
+	if override.ClickHouseDatabase != "" {
+		result.ClickHouseDatabase = override.ClickHouseDatabase
+	}
+	if override.ClickHouseTable != "" {
+		result.ClickHouseTable = override.ClickHouseTable
+	}
+	if override.ClickHouseGzip {
+		result.ClickHouseGzip = true
+	}
+	if override.ObjectMaxAgeSeconds != 0 {
+		result.ObjectMaxAgeSeconds = override.ObjectMaxAgeSeconds
+	}
+	if override.ClickHouseUser != "" {
+		result.ClickHouseUser = override.ClickHouseUser
+	}
+	if override.ClickHousePassword != "" {
+		result.ClickHousePassword = override.ClickHousePassword
+	}
+	if override.ClickHousePasswordFile != "" {
+		result.ClickHousePasswordFile = override.ClickHousePasswordFile
+	}
+	if override.GRPCTLS {
+		result.GRPCTLS = true
+	}
+	if override.GRPCCAFile != "" {
+		result.GRPCCAFile = override.GRPCCAFile
+	}
+	if override.GRPCAuthToken != "" {
+		result.GRPCAuthToken = override.GRPCAuthToken
+	}
+	if override.GRPCAuthTokenFile != "" {
+		result.GRPCAuthTokenFile = override.GRPCAuthTokenFile
+	}
+	if override.NATSSubject != "" {
+		result.NATSSubject = override.NATSSubject
+	}
+	if override.NATSCredsFile != "" {
+		result.NATSCredsFile = override.NATSCredsFile
+	}
+	if override.NATSMaxInFlight != 0 {
+		result.NATSMaxInFlight = override.NATSMaxInFlight
+	}
+	if override.NATSAckTimeout != "" {
+		result.NATSAckTimeout = override.NATSAckTimeout
+	}
+	if override.NATSUser != "" {
+		result.NATSUser = override.NATSUser
+	}
+	if override.NATSPassword != "" {
+		result.NATSPassword = override.NATSPassword
+	}
+	if override.NATSToken != "" {
+		result.NATSToken = override.NATSToken
+	}
+	if override.FaultyInner != "" {
+		result.FaultyInner = override.FaultyInner
+	}
+	if override.FaultyFailRate > 0 {
+		result.FaultyFailRate = override.FaultyFailRate
+	}
+	if override.FaultyFailEvery > 0 {
+		result.FaultyFailEvery = override.FaultyFailEvery
+	}
+	if override.FaultyFailAfter > 0 {
+		result.FaultyFailAfter = override.FaultyFailAfter
+	}
+	if override.FaultyLatencyMS > 0 {
+		result.FaultyLatencyMS = override.FaultyLatencyMS
+	}
+	if override.FaultySeed != 0 {
+		result.FaultySeed = override.FaultySeed
+	}
+	if len(override.RouterRoutes) > 0 {
+		result.RouterRoutes = override.RouterRoutes
+	}
+	if len(override.RouterRules) > 0 {
+		result.RouterRules = override.RouterRules
+	}
+	if override.RouterDefault != "" {
+		result.RouterDefault = override.RouterDefault
+	}
+	if len(override.RouteByLevel) > 0 {
+		result.RouteByLevel = override.RouteByLevel
+	}
+	if len(override.RouterRouteOptions) > 0 {
+		result.RouterRouteOptions = override.RouterRouteOptions
+	}
 	if override.DLQPath != "" {
 		result.DLQPath = override.DLQPath
 	}
+	if override.StampRunMetadata {
+		result.StampRunMetadata = true
+	}
+	if override.StampProvenance {
+		result.StampProvenance = true
+	}
+	if override.TransformAudit {
+		result.TransformAudit = true
+	}
+	if override.RedactionAudit {
+		result.RedactionAudit = true
+	}
+	if override.DeriveServiceFromPod {
+		result.DeriveServiceFromPod = true
+	}
+	if override.SanitizeMessages {
+		result.SanitizeMessages = true
+	}
+	if len(override.UnwrapKeys) > 0 {
+		result.UnwrapKeys = override.UnwrapKeys
+	}
+	if override.UnwrapConflict != "" {
+		result.UnwrapConflict = override.UnwrapConflict
+	}
+	if override.SortWindow != "" {
+		result.SortWindow = override.SortWindow
+	}
+	if override.SortMaxRecords != 0 {
+		result.SortMaxRecords = override.SortMaxRecords
+	}
+	if override.TimestampShift != "" {
+		result.TimestampShift = override.TimestampShift
+	}
+	if override.ReplaySpeed != 0 {
+		result.ReplaySpeed = override.ReplaySpeed
+	}
+	if override.ReplayMaxSleep != "" {
+		result.ReplayMaxSleep = override.ReplayMaxSleep
+	}
+	if override.Skip != 0 {
+		result.Skip = override.Skip
+	}
+	if override.Head != 0 {
+		result.Head = override.Head
+	}
+	if override.MaxDuration != "" {
+		result.MaxDuration = override.MaxDuration
+	}
+	if override.StageTimings != nil {
+		result.StageTimings = override.StageTimings
+	}
+	if override.ValidatePaths != nil {
+		result.ValidatePaths = override.ValidatePaths
+	}
+	if override.Preflight {
+		result.Preflight = true
+	}
+	if override.StrictConfig {
+		result.StrictConfig = true
+	}
+	if override.RunManifestPath != "" {
+		result.RunManifestPath = override.RunManifestPath
+	}
+	if override.RunManifestChecksums {
+		result.RunManifestChecksums = true
+	}
+	result.unknownKeys = append(slices.Clip(result.unknownKeys), override.unknownKeys...)
+	result.keyWarnings = append(slices.Clip(result.keyWarnings), override.keyWarnings...)
+	if override.DLQNormalizeFailures {
+		result.DLQNormalizeFailures = true
+	}
+	if override.StrictJSON {
+		result.StrictJSON = true
+	}
+	if override.DLQDuplicateKeys {
+		result.DLQDuplicateKeys = true
+	}
+	if override.JSONSchemaFile != "" {
+		result.JSONSchemaFile = override.JSONSchemaFile
+	}
+	if override.SchemaAction != "" {
+		result.SchemaAction = override.SchemaAction
+	}
+	if override.DLQMaxRecordBytes != 0 {
+		result.DLQMaxRecordBytes = override.DLQMaxRecordBytes
+	}
+	if override.DLQMaxRecords != 0 {
+		result.DLQMaxRecords = override.DLQMaxRecords
+	}
+	if override.DLQMaxBytes != 0 {
+		result.DLQMaxBytes = override.DLQMaxBytes
+	}
+	if override.DLQOverflowPolicy != "" {
+		result.DLQOverflowPolicy = override.DLQOverflowPolicy
+	}
+	if override.DLQPayload != "" {
+		result.DLQPayload = override.DLQPayload
+	}
+	if override.SpillDir != "" {
+		result.SpillDir = override.SpillDir
+	}
+	if override.SpillMaxBytes != 0 {
+		result.SpillMaxBytes = override.SpillMaxBytes
+	}
 	if override.BatchSize > 0 {
 		result.BatchSize = override.BatchSize
 	}
 	if override.BatchFlushInterval > 0 {
 		result.BatchFlushInterval = override.BatchFlushInterval
 	}
+	if override.AggregateWindowSeconds > 0 {
+		result.AggregateWindowSeconds = override.AggregateWindowSeconds
+	}
+	if len(override.AggregateGroupBy) > 0 {
+		result.AggregateGroupBy = override.AggregateGroupBy
+	}
+	if override.AggregateField != "" {
+		result.AggregateField = override.AggregateField
+	}
+	if override.AggregateLatenessSeconds > 0 {
+		result.AggregateLatenessSeconds = override.AggregateLatenessSeconds
+	}
+	if override.AggregateLate != "" {
+		result.AggregateLate = override.AggregateLate
+	}
+	if override.AggregateMaxBuckets > 0 {
+		result.AggregateMaxBuckets = override.AggregateMaxBuckets
+	}
 	if override.ShutdownTimeoutSeconds > 0 {
 		result.ShutdownTimeoutSeconds = override.ShutdownTimeoutSeconds
 	}
+	if override.HealthListen != "" {
+		result.HealthListen = override.HealthListen
+	}
+	if override.HealthStaleSeconds != 0 {
+		result.HealthStaleSeconds = override.HealthStaleSeconds
+	}
+	if override.HealthQueueFullSeconds != 0 {
+		result.HealthQueueFullSeconds = override.HealthQueueFullSeconds
+	}
+	if override.PprofListen != "" {
+		result.PprofListen = override.PprofListen
+	}
+	if override.CPUProfile != "" {
+		result.CPUProfile = override.CPUProfile
+	}
 	if override.LogLevel != "" {
 		result.LogLevel = override.LogLevel
 	}
@@ -147,6 +1021,72 @@ func FromEnv(base Config) Config {
 	if v := os.Getenv("ETL_INPUT"); v != "" {
 		result.InputPath = v
 	}
+	if v := os.Getenv("ETL_INPUTS"); v != "" {
+		result.Inputs = ParseList(v)
+	}
+	if v := os.Getenv("ETL_INPUT_MERGE_SORTED"); v != "" {
+		if parsed, err := strconv.ParseBool(v); err == nil {
+			result.InputMergeSorted = parsed
+		}
+	}
+	if v := os.Getenv("ETL_INPUT_IDLE_TIMEOUT"); v != "" {
+		result.InputIdleTimeout = v
+	}
+	if v := os.Getenv("ETL_INPUT_IDLE_ACTION"); v != "" {
+		result.InputIdleAction = v
+	}
+	if v := os.Getenv("ETL_INPUT_REOPEN_ON_EOF"); v != "" {
+		if parsed, err := strconv.ParseBool(v); err == nil {
+			result.InputReopenOnEOF = parsed
+		}
+	}
+	if v := os.Getenv("ETL_INPUT_FORMAT"); v != "" {
+		result.InputFormat = v
+	}
+	if v := os.Getenv("ETL_LINE_PRUNE_BYTES"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil {
+			result.LinePruneBytes = parsed
+		}
+	}
+	if v := os.Getenv("ETL_MAX_LINE_BYTES"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil {
+			result.MaxLineBytes = parsed
+		}
+	}
+	if v := os.Getenv("ETL_INPUT_TYPE"); v != "" {
+		result.InputType = v
+	}
+	if v := os.Getenv("ETL_NATS_INPUT_STREAM"); v != "" {
+		result.NATSInputStream = v
+	}
+	if v := os.Getenv("ETL_NATS_INPUT_CONSUMER"); v != "" {
+		result.NATSInputConsumer = v
+	}
+	if v := os.Getenv("ETL_NATS_INPUT_FILTER_SUBJECT"); v != "" {
+		result.NATSInputFilterSubject = v
+	}
+	if v := os.Getenv("ETL_NATS_INPUT_MAX_ACK_PENDING"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil {
+			result.NATSInputMaxAckPending = parsed
+		}
+	}
+	if v := os.Getenv("ETL_NATS_INPUT_ACK_WAIT"); v != "" {
+		result.NATSInputAckWait = v
+	}
+	if v := os.Getenv("ETL_JOURNALD_COMMAND"); v != "" {
+		result.JournaldCommand = strings.Fields(v)
+	}
+	if v := os.Getenv("ETL_JOURNALD_UNITS"); v != "" {
+		result.JournaldUnits = ParseList(v)
+	}
+	if v := os.Getenv("ETL_JOURNALD_FOLLOW"); v != "" {
+		if parsed, err := strconv.ParseBool(v); err == nil {
+			result.JournaldFollow = parsed
+		}
+	}
+	if v := os.Getenv("ETL_JOURNALD_CURSOR_FILE"); v != "" {
+		result.JournaldCursorFile = v
+	}
 	if v := os.Getenv("ETL_OUTPUT"); v != "" {
 		result.OutputPath = v
 	}
@@ -163,6 +1103,85 @@ func FromEnv(base Config) Config {
 			result.OutputMaxFiles = parsed
 		}
 	}
+	if v := os.Getenv("ETL_OUTPUT_PATH_DATE_LAYOUT"); v != "" {
+		result.OutputPathDateLayout = v
+	}
+	if v := os.Getenv("ETL_OUTPUT_PATH_MAX_OPEN"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil {
+			result.OutputPathMaxOpen = parsed
+		}
+	}
+	if v := os.Getenv("ETL_OUTPUT_ATOMIC"); v != "" {
+		if parsed, err := strconv.ParseBool(v); err == nil {
+			result.OutputAtomic = parsed
+		}
+	}
+	if v := os.Getenv("ETL_OUTPUT_DONE_MARKER"); v != "" {
+		if parsed, err := strconv.ParseBool(v); err == nil {
+			result.OutputDoneMarker = parsed
+		}
+	}
+	if v := os.Getenv("ETL_OUTPUT_RESUME"); v != "" {
+		if parsed, err := strconv.ParseBool(v); err == nil {
+			result.OutputResume = parsed
+		}
+	}
+	if v := os.Getenv("ETL_OUTPUT_INDEX"); v != "" {
+		result.OutputIndex = v
+	}
+	if v := os.Getenv("ETL_OUTPUT_MANIFEST"); v != "" {
+		if parsed, err := strconv.ParseBool(v); err == nil {
+			result.OutputManifest = parsed
+		}
+	}
+	if v := os.Getenv("ETL_OUTPUT_FIELDS"); v != "" {
+		result.OutputFields = ParseList(v)
+	}
+	if v := os.Getenv("ETL_OUTPUT_FORMAT"); v != "" {
+		result.OutputFormat = v
+	}
+	if v := os.Getenv("ETL_OUTPUT_SCHEMA"); v != "" {
+		result.OutputSchema = v
+	}
+	if v := os.Getenv("ETL_OUTPUT_TEMPLATE"); v != "" {
+		result.OutputTemplate = v
+	}
+	if v := os.Getenv("ETL_OUTPUT_ESCAPE_HTML"); v != "" {
+		if parsed, err := strconv.ParseBool(v); err == nil {
+			result.OutputEscapeHTML = &parsed
+		}
+	}
+	if v := os.Getenv("ETL_OUTPUT_SORT_KEYS"); v != "" {
+		if parsed, err := strconv.ParseBool(v); err == nil {
+			result.OutputSortKeys = parsed
+		}
+	}
+	if v := os.Getenv("ETL_OUTPUT_INDENT"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil {
+			result.OutputIndent = parsed
+		}
+	}
+	if v := os.Getenv("ETL_OUTPUT_FILE_MODE"); v != "" {
+		result.OutputFileMode = v
+	}
+	if v := os.Getenv("ETL_OUTPUT_DIR_MODE"); v != "" {
+		result.OutputDirMode = v
+	}
+	if v := os.Getenv("ETL_OUTPUT_OWNER"); v != "" {
+		result.OutputOwner = v
+	}
+	if v := os.Getenv("ETL_OUTPUT_BASE_DIR"); v != "" {
+		result.OutputBaseDir = v
+	}
+	if v := os.Getenv("ETL_AVRO_REGISTRY_URL"); v != "" {
+		result.AvroRegistryURL = v
+	}
+	if v := os.Getenv("ETL_AVRO_SUBJECT"); v != "" {
+		result.AvroSubject = v
+	}
+	if v := os.Getenv("ETL_PRETTY_FIELDS"); v != "" {
+		result.PrettyFields = ParseList(v)
+	}
 	if v := os.Getenv("ETL_MAX_WORKERS"); v != "" {
 		if parsed, err := strconv.Atoi(v); err == nil {
 			result.MaxWorkers = parsed
@@ -173,6 +1192,16 @@ func FromEnv(base Config) Config {
 			result.QueueSize = parsed
 		}
 	}
+	if v := os.Getenv("ETL_QUEUE_PRIORITY_BY_LEVEL"); v != "" {
+		if parsed, err := strconv.ParseBool(v); err == nil {
+			result.QueuePriorityByLevel = parsed
+		}
+	}
+	if v := os.Getenv("ETL_MAX_INFLIGHT_BYTES"); v != "" {
+		if parsed, err := strconv.ParseInt(v, 10, 64); err == nil {
+			result.MaxInflightBytes = parsed
+		}
+	}
 	if v := os.Getenv("ETL_SINK_MAX_RETRIES"); v != "" {
 		if parsed, err := strconv.Atoi(v); err == nil {
 			result.SinkMaxRetries = parsed
@@ -193,90 +1222,1123 @@ func FromEnv(base Config) Config {
 			result.SinkBackoffJitter = parsed
 		}
 	}
+	if v := os.Getenv("ETL_RETRY_JITTER_SEED"); v != "" {
+		if parsed, err := strconv.ParseInt(v, 10, 64); err == nil {
+			result.RetryJitterSeed = parsed
+		}
+	}
+	if v := os.Getenv("ETL_SINK_WRITE_TIMEOUT_MS"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil {
+			result.SinkWriteTimeoutMS = parsed
+		}
+	}
+	if v := os.Getenv("ETL_STALL_WARN_AFTER"); v != "" {
+		result.StallWarnAfter = v
+	}
+	if v := os.Getenv("ETL_STALL_ACTION"); v != "" {
+		result.StallAction = v
+	}
+	if v := os.Getenv("ETL_SINK_RETRY_BUDGET"); v != "" {
+		result.SinkRetryBudget = v
+	}
+	if v := os.Getenv("ETL_SINK_RETRY_BUDGET_WINDOW"); v != "" {
+		result.SinkRetryBudgetWindow = v
+	}
+	if v := os.Getenv("ETL_HTTP_MAX_IDLE_CONNS"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil {
+			result.HTTPMaxIdleConns = parsed
+		}
+	}
+	if v := os.Getenv("ETL_HTTP_MAX_CONNS_PER_HOST"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil {
+			result.HTTPMaxConnsPerHost = parsed
+		}
+	}
+	if v := os.Getenv("ETL_HTTP_IDLE_CONN_TIMEOUT"); v != "" {
+		result.HTTPIdleConnTimeout = v
+	}
+	if v := os.Getenv("ETL_HTTP_COMPRESS"); v != "" {
+		result.HTTPCompress = v
+	}
+	if v := os.Getenv("ETL_HTTP_AUTH_TOKEN"); v != "" {
+		result.HTTPAuthToken = v
+	}
+	if v := os.Getenv("ETL_HTTP_AUTH_TOKEN_FILE"); v != "" {
+		result.HTTPAuthTokenFile = v
+	}
 	if v := os.Getenv("ETL_DLQ"); v != "" {
 		result.DLQPath = v
 	}
 	if v := os.Getenv("ETL_REPORT"); v != "" {
 		result.ReportPath = v
 	}
+	if v := os.Getenv("ETL_REPORT_FORMAT"); v != "" {
+		result.ReportFormat = v
+	}
+	if v := os.Getenv("ETL_REPORT_MD"); v != "" {
+		result.ReportMarkdownPath = v
+	}
+	if v := os.Getenv("ETL_REPORT_SCHEMA"); v != "" {
+		result.ReportSchema = v
+	}
+	if v := os.Getenv("ETL_REPORT_OPS_PATH"); v != "" {
+		result.ReportOpsPath = v
+	}
+	if v := os.Getenv("ETL_REPORT_QUALITY_PATH"); v != "" {
+		result.ReportQualityPath = v
+	}
 	if v := os.Getenv("ETL_FILTER_LEVELS"); v != "" {
-		result.FilterLevels = parseList(v)
+		result.FilterLevels = ParseList(v)
 	}
 	if v := os.Getenv("ETL_FILTER_SERVICES"); v != "" {
-		result.FilterSvcs = parseList(v)
+		result.FilterSvcs = ParseList(v)
 	}
 	if v := os.Getenv("ETL_REDACT_KEYS"); v != "" {
-		result.RedactKeys = parseList(v)
+		result.RedactKeys = ParseList(v)
 	}
 	if v := os.Getenv("ETL_TRANSFORMS"); v != "" {
-		result.Transforms = parseList(v)
+		result.Transforms = ParseList(v)
 	}
-	if v := os.Getenv("ETL_BATCH_SIZE"); v != "" {
+	if v := os.Getenv("ETL_TRANSFORM_ON_ERROR"); v != "" {
+		result.TransformOnError = ParseList(v)
+	}
+	if v := os.Getenv("ETL_TOP_MESSAGES"); v != "" {
 		if parsed, err := strconv.Atoi(v); err == nil {
-			result.BatchSize = parsed
+			result.TopMessages = parsed
 		}
 	}
-	if v := os.Getenv("ETL_BATCH_FLUSH_INTERVAL_MS"); v != "" {
+	if v := os.Getenv("ETL_REPORT_MAX_LABEL_VALUES"); v != "" {
 		if parsed, err := strconv.Atoi(v); err == nil {
-			result.BatchFlushInterval = parsed
+			result.ReportMaxLabelValues = parsed
 		}
 	}
-	if v := os.Getenv("ETL_SHUTDOWN_TIMEOUT_SECONDS"); v != "" {
+	if v := os.Getenv("ETL_METRICS_RULES"); v != "" {
+		result.MetricsRules = v
+	}
+	if v := os.Getenv("ETL_NAMESPACE_QUOTA"); v != "" {
+		result.NamespaceQuota = ParseList(v)
+	}
+	if v := os.Getenv("ETL_NAMESPACE_QUOTA_INTERVAL"); v != "" {
+		result.NamespaceQuotaInterval = v
+	}
+	if v := os.Getenv("ETL_QUOTA_OVERFLOW"); v != "" {
+		result.QuotaOverflow = v
+	}
+	if v := os.Getenv("ETL_QUOTA_SAMPLE_EVERY"); v != "" {
 		if parsed, err := strconv.Atoi(v); err == nil {
-			result.ShutdownTimeoutSeconds = parsed
+			result.QuotaSampleEvery = parsed
+		}
+	}
+	if v := os.Getenv("ETL_TRACE_RECORD"); v != "" {
+		result.TraceRecord = v
+	}
+	if v := os.Getenv("ETL_DROP_RULES_FILE"); v != "" {
+		result.DropRulesFile = v
+	}
+	if v := os.Getenv("ETL_DROP_RULES_RELOAD"); v != "" {
+		if parsed, err := strconv.ParseBool(v); err == nil {
+			result.DropRulesReload = parsed
+		}
+	}
+	if v := os.Getenv("ETL_METRICS_TEXTFILE_PATH"); v != "" {
+		result.MetricsTextfilePath = v
+	}
+	if v := os.Getenv("ETL_DERIVE_SERVICE_FROM_POD"); v != "" {
+		if parsed, err := strconv.ParseBool(v); err == nil {
+			result.DeriveServiceFromPod = parsed
+		}
+	}
+	if v := os.Getenv("ETL_UNWRAP_KEYS"); v != "" {
+		result.UnwrapKeys = ParseList(v)
+	}
+	if v := os.Getenv("ETL_UNWRAP_CONFLICT"); v != "" {
+		result.UnwrapConflict = v
+	}
+	if v := os.Getenv("ETL_CLICKHOUSE_DATABASE"); v != "" {
+		result.ClickHouseDatabase = v
+	}
+	if v := os.Getenv("ETL_CLICKHOUSE_TABLE"); v != "" {
+		result.ClickHouseTable = v
+	}
+	if v := os.Getenv("ETL_CLICKHOUSE_GZIP"); v != "" {
+		if parsed, err := strconv.ParseBool(v); err == nil {
+			result.ClickHouseGzip = parsed
+		}
+	}
+	if v := os.Getenv("ETL_OBJECT_MAX_AGE_SECONDS"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil {
+			result.ObjectMaxAgeSeconds = parsed
+		}
+	}
+	if v := os.Getenv("ETL_CLICKHOUSE_USER"); v != "" {
+		result.ClickHouseUser = v
+	}
+	if v := os.Getenv("ETL_CLICKHOUSE_PASSWORD"); v != "" {
+		result.ClickHousePassword = v
+	}
+	if v := os.Getenv("ETL_CLICKHOUSE_PASSWORD_FILE"); v != "" {
+		result.ClickHousePasswordFile = v
+	}
+	if v := os.Getenv("ETL_GRPC_TLS"); v != "" {
+		if parsed, err := strconv.ParseBool(v); err == nil {
+			result.GRPCTLS = parsed
+		}
+	}
+	if v := os.Getenv("ETL_GRPC_CA_FILE"); v != "" {
+		result.GRPCCAFile = v
+	}
+	if v := os.Getenv("ETL_GRPC_AUTH_TOKEN"); v != "" {
+		result.GRPCAuthToken = v
+	}
+	if v := os.Getenv("ETL_GRPC_AUTH_TOKEN_FILE"); v != "" {
+		result.GRPCAuthTokenFile = v
+	}
+	if v := os.Getenv("ETL_NATS_SUBJECT"); v != "" {
+		result.NATSSubject = v
+	}
+	if v := os.Getenv("ETL_NATS_CREDS_FILE"); v != "" {
+		result.NATSCredsFile = v
+	}
+	if v := os.Getenv("ETL_NATS_MAX_IN_FLIGHT"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil {
+			result.NATSMaxInFlight = parsed
+		}
+	}
+	if v := os.Getenv("ETL_NATS_ACK_TIMEOUT"); v != "" {
+		result.NATSAckTimeout = v
+	}
+	if v := os.Getenv("ETL_NATS_USER"); v != "" {
+		result.NATSUser = v
+	}
+	if v := os.Getenv("ETL_NATS_PASSWORD"); v != "" {
+		result.NATSPassword = v
+	}
+	if v := os.Getenv("ETL_NATS_TOKEN"); v != "" {
+		result.NATSToken = v
+	}
+	if v := os.Getenv("ETL_FAULTY_INNER"); v != "" {
+		result.FaultyInner = v
+	}
+	if v := os.Getenv("ETL_FAULTY_FAIL_RATE"); v != "" {
+		if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+			result.FaultyFailRate = parsed
+		}
+	}
+	if v := os.Getenv("ETL_FAULTY_FAIL_EVERY"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil {
+			result.FaultyFailEvery = parsed
+		}
+	}
+	if v := os.Getenv("ETL_FAULTY_FAIL_AFTER"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil {
+			result.FaultyFailAfter = parsed
+		}
+	}
+	if v := os.Getenv("ETL_FAULTY_LATENCY_MS"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil {
+			result.FaultyLatencyMS = parsed
+		}
+	}
+	if v := os.Getenv("ETL_FAULTY_SEED"); v != "" {
+		if parsed, err := strconv.ParseInt(v, 10, 64); err == nil {
+			result.FaultySeed = parsed
+		}
+	}
+	if v := os.Getenv("ETL_ROUTER_ROUTES"); v != "" {
+		result.RouterRoutes = ParseList(v)
+	}
+	if v := os.Getenv("ETL_ROUTER_RULES"); v != "" {
+		result.RouterRules = ParseList(v)
+	}
+	if v := os.Getenv("ETL_ROUTER_DEFAULT"); v != "" {
+		result.RouterDefault = v
+	}
+	if v := os.Getenv("ETL_ROUTE_BY_LEVEL"); v != "" {
+		result.RouteByLevel = ParseList(v)
+	}
+	if v := os.Getenv("ETL_ROUTER_ROUTE_OPTIONS"); v != "" {
+		result.RouterRouteOptions = ParseList(v)
+	}
+	if v := os.Getenv("ETL_SORT_WINDOW"); v != "" {
+		result.SortWindow = v
+	}
+	if v := os.Getenv("ETL_SORT_MAX_RECORDS"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil {
+			result.SortMaxRecords = parsed
+		}
+	}
+	if v := os.Getenv("ETL_TIMESTAMP_SHIFT"); v != "" {
+		result.TimestampShift = v
+	}
+	if v := os.Getenv("ETL_REPLAY_SPEED"); v != "" {
+		if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+			result.ReplaySpeed = parsed
+		}
+	}
+	if v := os.Getenv("ETL_REPLAY_MAX_SLEEP"); v != "" {
+		result.ReplayMaxSleep = v
+	}
+	if v := os.Getenv("ETL_SKIP"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil {
+			result.Skip = parsed
+		}
+	}
+	if v := os.Getenv("ETL_HEAD"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil {
+			result.Head = parsed
+		}
+	}
+	if v := os.Getenv("ETL_MAX_DURATION"); v != "" {
+		result.MaxDuration = v
+	}
+	if v := os.Getenv("ETL_STAGE_TIMINGS"); v != "" {
+		if parsed, err := strconv.ParseBool(v); err == nil {
+			result.StageTimings = &parsed
+		}
+	}
+	if v := os.Getenv("ETL_VALIDATE_PATHS"); v != "" {
+		if parsed, err := strconv.ParseBool(v); err == nil {
+			result.ValidatePaths = &parsed
+		}
+	}
+	if v := os.Getenv("ETL_PREFLIGHT"); v != "" {
+		if parsed, err := strconv.ParseBool(v); err == nil {
+			result.Preflight = parsed
+		}
+	}
+	if v := os.Getenv("ETL_STRICT_CONFIG"); v != "" {
+		if parsed, err := strconv.ParseBool(v); err == nil {
+			result.StrictConfig = parsed
+		}
+	}
+	if v := os.Getenv("ETL_RUN_MANIFEST_PATH"); v != "" {
+		result.RunManifestPath = v
+	}
+	if v := os.Getenv("ETL_RUN_MANIFEST_CHECKSUMS"); v != "" {
+		if parsed, err := strconv.ParseBool(v); err == nil {
+			result.RunManifestChecksums = parsed
+		}
+	}
+	if v := os.Getenv("ETL_STAMP_RUN_METADATA"); v != "" {
+		if parsed, err := strconv.ParseBool(v); err == nil {
+			result.StampRunMetadata = parsed
+		}
+	}
+	if v := os.Getenv("ETL_SANITIZE_MESSAGES"); v != "" {
+		if parsed, err := strconv.ParseBool(v); err == nil {
+			result.SanitizeMessages = parsed
+		}
+	}
+	if v := os.Getenv("ETL_STAMP_PROVENANCE"); v != "" {
+		if parsed, err := strconv.ParseBool(v); err == nil {
+			result.StampProvenance = parsed
+		}
+	}
+	if v := os.Getenv("ETL_TRANSFORM_AUDIT"); v != "" {
+		if parsed, err := strconv.ParseBool(v); err == nil {
+			result.TransformAudit = parsed
+		}
+	}
+	if v := os.Getenv("ETL_REDACTION_AUDIT"); v != "" {
+		if parsed, err := strconv.ParseBool(v); err == nil {
+			result.RedactionAudit = parsed
+		}
+	}
+	if v := os.Getenv("ETL_DLQ_NORMALIZE_FAILURES"); v != "" {
+		if parsed, err := strconv.ParseBool(v); err == nil {
+			result.DLQNormalizeFailures = parsed
+		}
+	}
+	if v := os.Getenv("ETL_STRICT_JSON"); v != "" {
+		if parsed, err := strconv.ParseBool(v); err == nil {
+			result.StrictJSON = parsed
+		}
+	}
+	if v := os.Getenv("ETL_DLQ_DUPLICATE_KEYS"); v != "" {
+		if parsed, err := strconv.ParseBool(v); err == nil {
+			result.DLQDuplicateKeys = parsed
+		}
+	}
+	if v := os.Getenv("ETL_JSON_SCHEMA_FILE"); v != "" {
+		result.JSONSchemaFile = v
+	}
+	if v := os.Getenv("ETL_SCHEMA_ACTION"); v != "" {
+		result.SchemaAction = v
+	}
+	if v := os.Getenv("ETL_DLQ_MAX_RECORD_BYTES"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil {
+			result.DLQMaxRecordBytes = parsed
+		}
+	}
+	if v := os.Getenv("ETL_DLQ_MAX_RECORDS"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil {
+			result.DLQMaxRecords = parsed
+		}
+	}
+	if v := os.Getenv("ETL_DLQ_MAX_BYTES"); v != "" {
+		if parsed, err := strconv.ParseInt(v, 10, 64); err == nil {
+			result.DLQMaxBytes = parsed
+		}
+	}
+	if v := os.Getenv("ETL_DLQ_OVERFLOW_POLICY"); v != "" {
+		result.DLQOverflowPolicy = v
+	}
+	if v := os.Getenv("ETL_DLQ_PAYLOAD"); v != "" {
+		result.DLQPayload = v
+	}
+	if v := os.Getenv("ETL_SPILL_DIR"); v != "" {
+		result.SpillDir = v
+	}
+	if v := os.Getenv("ETL_SPILL_MAX_BYTES"); v != "" {
+		if parsed, err := strconv.ParseInt(v, 10, 64); err == nil {
+			result.SpillMaxBytes = parsed
+		}
+	}
+	if v := os.Getenv("ETL_EXEC_COMMAND"); v != "" {
+		result.ExecCommand = strings.Fields(v)
+	}
+	if v := os.Getenv("ETL_EXEC_TIMEOUT_MS"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil {
+			result.ExecTimeoutMS = parsed
+		}
+	}
+	if v := os.Getenv("ETL_WASM_MODULE"); v != "" {
+		result.WasmModule = v
+	}
+	if v := os.Getenv("ETL_WASM_TIMEOUT_MS"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil {
+			result.WasmTimeoutMS = parsed
+		}
+	}
+	if v := os.Getenv("ETL_WASM_MEMORY_LIMIT_MB"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil {
+			result.WasmMemoryLimitMB = parsed
+		}
+	}
+	if v := os.Getenv("ETL_BATCH_SIZE"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil {
+			result.BatchSize = parsed
+		}
+	}
+	if v := os.Getenv("ETL_BATCH_FLUSH_INTERVAL_MS"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil {
+			result.BatchFlushInterval = parsed
+		}
+	}
+	if v := os.Getenv("ETL_AGGREGATE_WINDOW_SECONDS"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil {
+			result.AggregateWindowSeconds = parsed
+		}
+	}
+	if v := os.Getenv("ETL_AGGREGATE_GROUP_BY"); v != "" {
+		result.AggregateGroupBy = ParseList(v)
+	}
+	if v := os.Getenv("ETL_AGGREGATE_FIELD"); v != "" {
+		result.AggregateField = v
+	}
+	if v := os.Getenv("ETL_AGGREGATE_LATENESS_SECONDS"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil {
+			result.AggregateLatenessSeconds = parsed
+		}
+	}
+	if v := os.Getenv("ETL_AGGREGATE_LATE"); v != "" {
+		result.AggregateLate = v
+	}
+	if v := os.Getenv("ETL_AGGREGATE_MAX_BUCKETS"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil {
+			result.AggregateMaxBuckets = parsed
+		}
+	}
+	if v := os.Getenv("ETL_SHUTDOWN_TIMEOUT_SECONDS"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil {
+			result.ShutdownTimeoutSeconds = parsed
+		}
+	}
+	if v := os.Getenv("ETL_HEALTH_LISTEN"); v != "" {
+		result.HealthListen = v
+	}
+	if v := os.Getenv("ETL_HEALTH_STALE_SECONDS"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil {
+			result.HealthStaleSeconds = parsed
+		}
+	}
+	if v := os.Getenv("ETL_HEALTH_QUEUE_FULL_SECONDS"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil {
+			result.HealthQueueFullSeconds = parsed
+		}
+	}
+	if v := os.Getenv("ETL_PPROF_LISTEN"); v != "" {
+		result.PprofListen = v
+	}
+	if v := os.Getenv("ETL_CPU_PROFILE"); v != "" {
+		result.CPUProfile = v
+	}
+	if v := os.Getenv("ETL_LOG_LEVEL"); v != "" {
+		result.LogLevel = v
+	}
+	if v := os.Getenv("ETL_LOG_FORMAT"); v != "" {
+		result.LogFormat = v
+	}
+
+	return result
+}
+
+// Load reads a JSON or YAML config file into Config. Keys no option uses
+// are left out and reported by Validate or Warnings, with the closest
+// known key as a suggestion; renamed keys are read under their new name.
+func Load(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+
+	var cfg Config
+	ext := strings.ToLower(filepath.Ext(path))
+
+	format, doc := "json", data
+	switch ext {
+	case ".yaml", ".yml":
+		format = "yaml"
+		raw, err := parseYAML(data)
+		if err != nil {
+			return Config{}, fmt.Errorf("parse yaml: %w", err)
+		}
+		if doc, err = json.Marshal(raw); err != nil {
+			return Config{}, fmt.Errorf("parse yaml: %w", err)
+		}
+	}
+	if err := json.Unmarshal(doc, &cfg); err != nil {
+		return Config{}, fmt.Errorf("parse %s: %w", format, err)
+	}
+	if err := checkKeys(doc, &cfg); err != nil {
+		return Config{}, fmt.Errorf("parse %s: %w", format, err)
+	}
+
+	return cfg, nil
+}
+
+// OutputEscapeHTMLEnabled reports whether JSON output escapes <, >, and &.
+// It does unless output_escape_html is explicitly false, as json.Marshal
+// always has.
+func (c Config) OutputEscapeHTMLEnabled() bool {
+	return c.OutputEscapeHTML == nil || *c.OutputEscapeHTML
+}
+
+// ValidatePathsEnabled reports whether a run checks its output, report, and
+// DLQ paths before it starts. It does unless validate_paths is false.
+func (c Config) ValidatePathsEnabled() bool {
+	return c.ValidatePaths == nil || *c.ValidatePaths
+}
+
+// FilePerm is how output, DLQ, and report files are created, from
+// OutputFileMode, OutputDirMode, OutputOwner, and OutputBaseDir. Invalid
+// values are left out; Validate reports them.
+func (c Config) FilePerm() fsutil.Perm {
+	var p fsutil.Perm
+	p.FileMode, _ = parseFileMode(c.OutputFileMode)
+	p.DirMode, _ = parseFileMode(c.OutputDirMode)
+	if uid, gid, err := parseOwner(c.OutputOwner); err == nil && c.OutputOwner != "" {
+		p.Chown, p.UID, p.GID = true, uid, gid
+	}
+	p.Base = c.OutputBaseDir
+	return p
+}
+
+// validateBaseDir checks that every path the run writes to lies under
+// OutputBaseDir, when it is set. The sinks and report writers check again
+// as they create each file, which also catches symbolic links made since.
+func validateBaseDir(cfg Config) []string {
+	if cfg.OutputBaseDir == "" {
+		return nil
+	}
+	if fi, err := os.Stat(cfg.OutputBaseDir); err != nil || !fi.IsDir() {
+		return []string{fmt.Sprintf("output_base_dir %q must be an existing directory", cfg.OutputBaseDir)}
+	}
+	var errs []string
+	check := func(key, path string) {
+		if path == "" || path == "-" {
+			return
+		}
+		if err := fsutil.Within(cfg.OutputBaseDir, path); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", key, err))
+		}
+	}
+	switch strings.ToLower(cfg.OutputType) {
+	case "file", "rotate", "rotating":
+		path := cfg.OutputPath
+		if cfg.OutputPathTemplated() {
+			// Placeholders resolve to single path elements, so the
+			// literal prefix decides where files can land.
+			path = filepath.Dir(path[:strings.IndexByte(path, '{')] + "_")
+		}
+		check("output", path)
+	}
+	check("report", cfg.ReportPath)
+	check("report_md", cfg.ReportMarkdownPath)
+	check("report_ops_path", cfg.ReportOpsPath)
+	check("report_quality_path", cfg.ReportQualityPath)
+	if !strings.HasPrefix(cfg.DLQPath, "s3://") {
+		check("dlq", cfg.DLQPath)
+	}
+	check("spill_dir", cfg.SpillDir)
+	check("run_manifest_path", cfg.RunManifestPath)
+	check("journald_cursor_file", cfg.JournaldCursorFile)
+	check("metrics_textfile_path", cfg.MetricsTextfilePath)
+	return errs
+}
+
+// parseFileMode parses an octal permission such as 0600, 600, or 0o600.
+// An empty string is mode 0, meaning unset.
+func parseFileMode(s string) (os.FileMode, error) {
+	if s == "" {
+		return 0, nil
+	}
+	digits := strings.TrimPrefix(strings.TrimPrefix(s, "0o"), "0O")
+	m, err := strconv.ParseUint(digits, 8, 32)
+	if err != nil || m == 0 || m > 0o777 {
+		return 0, fmt.Errorf("must be an octal permission from 0001 to 0777, such as 0600")
+	}
+	return os.FileMode(m), nil
+}
+
+// parseOwner parses a numeric uid:gid, as in a pod's securityContext.
+func parseOwner(s string) (uid, gid int, err error) {
+	u, g, ok := strings.Cut(s, ":")
+	if ok {
+		uid, err = strconv.Atoi(u)
+		if err == nil {
+			gid, err = strconv.Atoi(g)
+		}
+	}
+	if !ok || err != nil || uid < 0 || gid < 0 {
+		return 0, 0, fmt.Errorf("must be a numeric uid:gid, such as 1000:1000")
+	}
+	return uid, gid, nil
+}
+
+// StageTimingsEnabled reports whether per-stage timings are recorded. They
+// are on unless stage_timings is explicitly false.
+func (c Config) StageTimingsEnabled() bool {
+	return c.StageTimings == nil || *c.StageTimings
+}
+
+// LegacySchema reports whether records are encoded with the legacy field
+// names, as they are unless output_schema is v1.
+func (c Config) LegacySchema() bool {
+	return !strings.EqualFold(c.OutputSchema, SchemaV1)
+}
+
+// DLQRaw reports whether DLQ entries carry the raw input, as they do
+// under dlq_payload raw or both.
+func (c Config) DLQRaw() bool {
+	return strings.EqualFold(c.DLQPayload, DLQPayloadRaw) || strings.EqualFold(c.DLQPayload, DLQPayloadBoth)
+}
+
+// DLQNormalized reports whether DLQ entries carry the normalized record,
+// as they do unless dlq_payload is raw.
+func (c Config) DLQNormalized() bool {
+	return !strings.EqualFold(c.DLQPayload, DLQPayloadRaw)
+}
+
+// SinkRetryBudgetDuration is SinkRetryBudget parsed, or 0 when it is unset
+// or invalid; Validate reports invalid values.
+func (c Config) SinkRetryBudgetDuration() time.Duration {
+	d, err := time.ParseDuration(c.SinkRetryBudget)
+	if err != nil || d < 0 {
+		return 0
+	}
+	return d
+}
+
+// DefaultSinkRetryBudgetWindow is how often sink_retry_budget starts over
+// unless sink_retry_budget_window says otherwise.
+const DefaultSinkRetryBudgetWindow = time.Hour
+
+// SinkRetryBudgetWindowDuration is SinkRetryBudgetWindow parsed, or
+// DefaultSinkRetryBudgetWindow when it is unset or invalid; Validate
+// reports invalid values.
+func (c Config) SinkRetryBudgetWindowDuration() time.Duration {
+	d, err := time.ParseDuration(c.SinkRetryBudgetWindow)
+	if err != nil || d <= 0 {
+		return DefaultSinkRetryBudgetWindow
+	}
+	return d
+}
+
+// HTTPIdleConnTimeoutDuration is HTTPIdleConnTimeout parsed, or 0 when it
+// is unset or invalid; Validate reports invalid values.
+func (c Config) HTTPIdleConnTimeoutDuration() time.Duration {
+	d, err := time.ParseDuration(c.HTTPIdleConnTimeout)
+	if err != nil || d < 0 {
+		return 0
+	}
+	return d
+}
+
+// NATSAckTimeoutDuration is NATSAckTimeout parsed, or 0 when it is unset
+// or invalid.
+func (c Config) NATSAckTimeoutDuration() time.Duration {
+	d, err := time.ParseDuration(c.NATSAckTimeout)
+	if err != nil || d < 0 {
+		return 0
+	}
+	return d
+}
+
+// NATSInputAckWaitDuration is NATSInputAckWait parsed, or 0 when it is
+// unset or invalid.
+func (c Config) NATSInputAckWaitDuration() time.Duration {
+	d, err := time.ParseDuration(c.NATSInputAckWait)
+	if err != nil || d <= 0 {
+		return 0
+	}
+	return d
+}
+
+// InputIdleTimeoutDuration is InputIdleTimeout parsed, or 0 when it is
+// unset or invalid; Validate reports invalid values.
+func (c Config) InputIdleTimeoutDuration() time.Duration {
+	d, err := time.ParseDuration(c.InputIdleTimeout)
+	if err != nil || d < 0 {
+		return 0
+	}
+	return d
+}
+
+// MaxDurationDuration is MaxDuration parsed, or 0 when it is unset or
+// invalid; Validate reports invalid values.
+func (c Config) MaxDurationDuration() time.Duration {
+	d, err := time.ParseDuration(c.MaxDuration)
+	if err != nil || d < 0 {
+		return 0
+	}
+	return d
+}
+
+// StallWarnAfterDuration is StallWarnAfter parsed, or 0 when it is unset
+// or invalid; Validate reports invalid values.
+func (c Config) StallWarnAfterDuration() time.Duration {
+	d, err := time.ParseDuration(c.StallWarnAfter)
+	if err != nil || d < 0 {
+		return 0
+	}
+	return d
+}
+
+// NamespaceQuotaIntervalDuration is NamespaceQuotaInterval parsed, or 0
+// when it is unset or invalid; Validate reports invalid values.
+func (c Config) NamespaceQuotaIntervalDuration() time.Duration {
+	d, err := time.ParseDuration(c.NamespaceQuotaInterval)
+	if err != nil || d < 0 {
+		return 0
+	}
+	return d
+}
+
+// SortWindowDuration is SortWindow parsed, or 0 when it is unset or invalid;
+// Validate reports invalid values.
+func (c Config) SortWindowDuration() time.Duration {
+	d, err := time.ParseDuration(c.SortWindow)
+	if err != nil || d < 0 {
+		return 0
+	}
+	return d
+}
+
+// DefaultOutputPathDateLayout is the layout of {date} in an output path
+// template, and DefaultOutputPathMaxOpen how many resolved files are kept
+// open, when unset.
+const (
+	DefaultOutputPathDateLayout = time.DateOnly
+	DefaultOutputPathMaxOpen    = 64
+)
+
+// OutputPathTemplated reports whether OutputPath has placeholders to
+// resolve per record.
+func (c Config) OutputPathTemplated() bool {
+	switch strings.ToLower(c.OutputType) {
+	case "file", "rotate", "rotating":
+		return strings.Contains(c.OutputPath, "{")
+	}
+	return false
+}
+
+// OutputPathLayout is OutputPathDateLayout, or DefaultOutputPathDateLayout
+// when it is unset.
+func (c Config) OutputPathLayout() string {
+	if c.OutputPathDateLayout == "" {
+		return DefaultOutputPathDateLayout
+	}
+	return c.OutputPathDateLayout
+}
+
+// OutputPathOpenFiles is OutputPathMaxOpen, or DefaultOutputPathMaxOpen
+// when it is unset.
+func (c Config) OutputPathOpenFiles() int {
+	if c.OutputPathMaxOpen <= 0 {
+		return DefaultOutputPathMaxOpen
+	}
+	return c.OutputPathMaxOpen
+}
+
+// DefaultReplayMaxSleep is the longest replay_speed waits between two
+// records unless replay_max_sleep says otherwise.
+const DefaultReplayMaxSleep = 10 * time.Second
+
+// ReplayMaxSleepDuration is ReplayMaxSleep parsed, or
+// DefaultReplayMaxSleep when it is unset or invalid; Validate reports
+// invalid values.
+func (c Config) ReplayMaxSleepDuration() time.Duration {
+	d, err := time.ParseDuration(c.ReplayMaxSleep)
+	if err != nil || d <= 0 {
+		return DefaultReplayMaxSleep
+	}
+	return d
+}
+
+// TimestampShiftRebaseNow is the timestamp_shift that moves the input's
+// latest record to the start of the run, keeping the input's span.
+const TimestampShiftRebaseNow = "rebase-now"
+
+// TimestampShiftDuration is TimestampShift parsed as a fixed shift, or 0
+// when it is unset, rebase-now, or invalid; Validate reports invalid
+// values.
+func (c Config) TimestampShiftDuration() time.Duration {
+	d, err := time.ParseDuration(strings.TrimSpace(c.TimestampShift))
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+// TimestampRebase reports whether TimestampShift is rebase-now.
+func (c Config) TimestampRebase() bool {
+	return strings.EqualFold(strings.TrimSpace(c.TimestampShift), TimestampShiftRebaseNow)
+}
+
+// Input types.
+const (
+	InputFile     = "file"     // input, inputs, or stdin
+	InputNATS     = "nats"     // a JetStream consumer
+	InputJournald = "journald" // the systemd journal, through journalctl
+)
+
+// Input formats.
+const (
+	InputAuto      = "auto"       // json_array if the input starts with '[', else jsonl
+	InputJSONL     = "jsonl"      // one JSON object per line
+	InputJSONArray = "json_array" // a single top-level array of objects
+)
+
+// Report formats.
+const (
+	ReportJSON     = "json"     // the full report as indented JSON
+	ReportMarkdown = "markdown" // a compact summary for PR comments and job summaries
+)
+
+// Report schemas.
+const (
+	ReportSchemaLegacy = "legacy" // one flat JSON object, as before v2
+	ReportSchemaV2     = "v2"     // operational and data_quality sections
+)
+
+// IndexTraceID is the output_index that indexes records by trace ID.
+const IndexTraceID = "trace_id"
+
+// Input idle actions.
+const (
+	IdleWarn = "warn" // log and count, keep waiting
+	IdleExit = "exit" // end the run with exit code 75
+)
+
+// Quota overflow actions.
+const (
+	QuotaDrop   = "drop"   // drop every record past the quota
+	QuotaSample = "sample" // keep one in quota_sample_every of them
+)
+
+// Schema actions, for records failing json_schema_file.
+const (
+	SchemaWarn = "warn" // count them and process them as usual
+	SchemaDrop = "drop" // drop them
+	SchemaDLQ  = "dlq"  // dead-letter them with the validation message
+)
+
+// Stall actions.
+const (
+	StallWarn  = "warn"  // log, escalating as the stall goes on
+	StallAbort = "abort" // stop the run
+)
+
+// DLQ overflow policies.
+const (
+	DLQOverflowDrop  = "drop"  // stop writing DLQ entries, count them as dlq_overflow
+	DLQOverflowAbort = "abort" // stop the pipeline
+)
+
+// HTTP sink body compression.
+const (
+	HTTPCompressNone = "none"
+	HTTPCompressGzip = "gzip"
+)
+
+// DLQ payloads.
+const (
+	DLQPayloadNormalized = "normalized" // the record as it reached the failing stage
+	DLQPayloadRaw        = "raw"        // the input record as read
+	DLQPayloadBoth       = "both"
+)
+
+// Output formats.
+const (
+	FormatJSON     = "json"     // one JSON object per line
+	FormatTemplate = "template" // one line of text per record from output_template
+	FormatPretty   = "pretty"   // aligned, colored lines for a terminal (stdout only)
+	FormatAvro     = "avro"     // binary Avro datums (file, rotate, and object only)
+)
+
+// DefaultAvroSubject is the registry subject used when avro_subject is
+// unset.
+const DefaultAvroSubject = "k8s-log-etl-value"
+
+// Output schemas, the JSON keys of a record.
+const (
+	SchemaLegacy = "legacy" // Go field names: TS, Level, TraceID, ...
+	SchemaV1     = "v1"     // lowercase names: ts, level, trace_id, ...
+)
+
+// Unwrap conflict policies, for keys in both the outer record and the
+// unwrapped payload.
+const (
+	UnwrapInner = "inner" // the payload's value wins
+	UnwrapOuter = "outer" // the outer record's value is kept
+)
+
+// Transform error policies.
+const (
+	OnErrorDrop  = "drop"  // discard the record (counted as a normalization failure)
+	OnErrorPass  = "pass"  // continue with the record as it was before the transform
+	OnErrorDLQ   = "dlq"   // write the record and error to the dead-letter file
+	OnErrorAbort = "abort" // stop the pipeline with an error
+)
+
+// OnErrorPolicies parses TransformOnError into a map from lowercased
+// transform name to policy.
+func OnErrorPolicies(cfg Config) (map[string]string, error) {
+	policies := make(map[string]string, len(cfg.TransformOnError))
+	for _, entry := range cfg.TransformOnError {
+		name, policy, ok := strings.Cut(entry, "=")
+		name, policy = strings.TrimSpace(name), strings.ToLower(strings.TrimSpace(policy))
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid transform_on_error entry %q: want name=policy", entry)
+		}
+		switch policy {
+		case OnErrorDrop, OnErrorPass, OnErrorDLQ, OnErrorAbort:
+		default:
+			return nil, fmt.Errorf("invalid transform_on_error policy %q for %s: must be drop, pass, dlq, or abort", policy, name)
+		}
+		policies[strings.ToLower(name)] = policy
+	}
+	return policies, nil
+}
+
+// QuotaLimit is a namespace's quota from namespace_quota; a zero field is
+// no limit.
+type QuotaLimit struct {
+	Records int64
+	Bytes   int64
+}
+
+// NamespaceQuotas parses NamespaceQuota into limits by namespace, "*"
+// included.
+func NamespaceQuotas(cfg Config) (map[string]QuotaLimit, error) {
+	quotas := make(map[string]QuotaLimit, len(cfg.NamespaceQuota))
+	for _, entry := range cfg.NamespaceQuota {
+		ns, limit, ok := strings.Cut(entry, "=")
+		ns, limit = strings.TrimSpace(ns), strings.TrimSpace(limit)
+		if !ok || ns == "" || limit == "" {
+			return nil, fmt.Errorf("invalid namespace_quota entry %q: want namespace=limit", entry)
+		}
+		q := quotas[ns]
+		if n, err := strconv.ParseInt(limit, 10, 64); err == nil && n > 0 {
+			if q.Records != 0 {
+				return nil, fmt.Errorf("namespace_quota lists a record limit for %s twice", ns)
+			}
+			q.Records = n
+		} else if n, err := parseSize(limit); err == nil && n > 0 {
+			if q.Bytes != 0 {
+				return nil, fmt.Errorf("namespace_quota lists a size limit for %s twice", ns)
+			}
+			q.Bytes = n
+		} else {
+			return nil, fmt.Errorf("invalid namespace_quota limit %q for %s: want a positive record count or a size such as 500MB", limit, ns)
+		}
+		quotas[ns] = q
+	}
+	return quotas, nil
+}
+
+// parseSize parses a size with a B, KB, MB, or GB suffix, in powers of
+// 1024.
+func parseSize(s string) (int64, error) {
+	num, mult := strings.ToUpper(s), int64(0)
+	for _, unit := range []struct {
+		suffix string
+		mult   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(num, unit.suffix) {
+			num, mult = strings.TrimSpace(strings.TrimSuffix(num, unit.suffix)), unit.mult
+			break
 		}
 	}
-	if v := os.Getenv("ETL_LOG_LEVEL"); v != "" {
-		result.LogLevel = v
+	if mult == 0 {
+		return 0, fmt.Errorf("size %q has no unit", s)
 	}
-	if v := os.Getenv("ETL_LOG_FORMAT"); v != "" {
-		result.LogFormat = v
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n > math.MaxInt64/mult {
+		return 0, fmt.Errorf("invalid size %q", s)
 	}
+	return n * mult, nil
+}
 
-	return result
+// RouterRoute is a parsed router_routes entry.
+type RouterRoute struct {
+	Name       string
+	OutputType string
+	Output     string
 }
 
-// Load reads a JSON or YAML config file into Config.
-func Load(path string) (Config, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return Config{}, err
-	}
+// RouterRule is a parsed router_rules entry: a record matches when every
+// condition holds.
+type RouterRule struct {
+	Conditions []RouterCondition
+	Route      string
+}
 
-	var cfg Config
-	ext := strings.ToLower(filepath.Ext(path))
+// RouterCondition holds when the record's Field has one of Values. Field
+// is an output name such as namespace, or a dotted path into fields.
+type RouterCondition struct {
+	Field  string
+	Values []string
+}
 
-	switch ext {
-	case ".yaml", ".yml":
-		if err := unmarshalYAML(data, &cfg); err != nil {
-			return Config{}, fmt.Errorf("parse yaml: %w", err)
+// routerOutputTypes are the output types a route may use.
+var routerOutputTypes = []string{"stdout", "file", "rotate", "rotating", "http", "webhook", "clickhouse", "object"}
+
+// ParseRouterRoute parses a router_routes entry, "name=output_type:output".
+// The output is optional for stdout.
+func ParseRouterRoute(entry string) (RouterRoute, error) {
+	name, target, ok := strings.Cut(entry, "=")
+	outputType, output, _ := strings.Cut(target, ":")
+	r := RouterRoute{Name: strings.TrimSpace(name), OutputType: strings.ToLower(strings.TrimSpace(outputType)), Output: strings.TrimSpace(output)}
+	switch {
+	case !ok || r.Name == "" || r.OutputType == "":
+		return r, fmt.Errorf("invalid router_routes entry %q: want name=output_type:output", entry)
+	case !slices.Contains(routerOutputTypes, r.OutputType):
+		return r, fmt.Errorf("invalid router_routes entry %q: output_type must be one of %s", entry, strings.Join(routerOutputTypes, ", "))
+	case r.Output == "" && r.OutputType != "stdout":
+		return r, fmt.Errorf("invalid router_routes entry %q: output_type %s needs an output", entry, r.OutputType)
+	}
+	return r, nil
+}
+
+// ParseRouterRule parses a router_rules entry, "field=value ... -> route".
+// Conditions are separated by spaces and a value may list alternatives
+// with |, e.g. "namespace=payments level=ERROR|FATAL -> payments".
+func ParseRouterRule(entry string) (RouterRule, error) {
+	match, route, ok := strings.Cut(entry, "->")
+	rule := RouterRule{Route: strings.TrimSpace(route)}
+	if !ok || rule.Route == "" || strings.TrimSpace(match) == "" {
+		return rule, fmt.Errorf("invalid router_rules entry %q: want field=value ... -> route", entry)
+	}
+	for _, cond := range strings.Fields(match) {
+		field, values, ok := strings.Cut(cond, "=")
+		if !ok || field == "" || values == "" {
+			return rule, fmt.Errorf("invalid router_rules condition %q in %q: want field=value", cond, entry)
 		}
-	default:
-		if err := json.Unmarshal(data, &cfg); err != nil {
-			return Config{}, fmt.Errorf("parse json: %w", err)
+		rule.Conditions = append(rule.Conditions, RouterCondition{Field: field, Values: strings.Split(values, "|")})
+	}
+	return rule, nil
+}
+
+// ParseRouteByLevel parses a route_by_level entry, "LEVEL|LEVEL -> route",
+// into the router rule it stands for.
+func ParseRouteByLevel(entry string) (RouterRule, error) {
+	levels, route, ok := strings.Cut(entry, "->")
+	rule := RouterRule{Route: strings.TrimSpace(route)}
+	var values []string
+	for _, l := range strings.Split(levels, "|") {
+		l = strings.ToUpper(strings.TrimSpace(l))
+		if l == "" || strings.ContainsAny(l, " \t=") {
+			ok = false
 		}
+		values = append(values, l)
+	}
+	if !ok || rule.Route == "" {
+		return rule, fmt.Errorf("invalid route_by_level entry %q: want LEVEL|LEVEL -> route", entry)
 	}
+	rule.Conditions = []RouterCondition{{Field: "level", Values: values}}
+	return rule, nil
+}
 
-	return cfg, nil
+// RouteOptions is a parsed router_route_options entry: the settings Route
+// uses in place of the top-level ones.
+type RouteOptions struct {
+	Route  string
+	Values map[string]int
+}
+
+// routeOptionKeys are the settings a route may set for itself.
+var routeOptionKeys = []string{"batch_size", "batch_flush_interval_ms", "sink_max_retries", "sink_backoff_base_ms", "sink_backoff_max_ms", "sink_write_timeout_ms"}
+
+// ParseRouteOptions parses a router_route_options entry, "route key=value
+// ...", e.g. "alerts batch_size=1 sink_max_retries=8". Values are
+// non-negative integers.
+func ParseRouteOptions(entry string) (RouteOptions, error) {
+	fields := strings.Fields(entry)
+	if len(fields) < 2 || strings.Contains(fields[0], "=") {
+		return RouteOptions{}, fmt.Errorf("invalid router_route_options entry %q: want route key=value ...", entry)
+	}
+	o := RouteOptions{Route: fields[0], Values: make(map[string]int, len(fields)-1)}
+	for _, kv := range fields[1:] {
+		key, value, _ := strings.Cut(kv, "=")
+		if !slices.Contains(routeOptionKeys, key) {
+			return o, fmt.Errorf("invalid router_route_options setting %q in %q: key must be one of %s", kv, entry, strings.Join(routeOptionKeys, ", "))
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return o, fmt.Errorf("invalid router_route_options setting %q in %q: want a non-negative integer", kv, entry)
+		}
+		o.Values[key] = n
+	}
+	return o, nil
 }
 
-func parseList(s string) []string {
-	parts := strings.FieldsFunc(s, func(r rune) bool {
-		return r == ',' || r == ';'
-	})
-	out := make([]string, 0, len(parts))
-	for _, p := range parts {
-		if trimmed := strings.TrimSpace(p); trimmed != "" {
-			out = append(out, trimmed)
+// Apply returns cfg with o's settings in place.
+func (o RouteOptions) Apply(cfg Config) Config {
+	for key, n := range o.Values {
+		switch key {
+		case "batch_size":
+			cfg.BatchSize = n
+		case "batch_flush_interval_ms":
+			cfg.BatchFlushInterval = n
+		case "sink_max_retries":
+			cfg.SinkMaxRetries = n
+		case "sink_backoff_base_ms":
+			cfg.SinkBackoffBaseMS = n
+		case "sink_backoff_max_ms":
+			cfg.SinkBackoffMaxMS = n
+		case "sink_write_timeout_ms":
+			cfg.SinkWriteTimeoutMS = n
 		}
 	}
-	return out
+	return cfg
 }
 
-// unmarshalYAML is a tiny, limited YAML reader that supports top-level key/value
+// parseYAML is a tiny, limited YAML reader that supports top-level key/value
 // pairs and simple lists (e.g., "filter_levels:\n  - WARN\n  - ERROR").
 // It intentionally avoids third-party dependencies.
-func unmarshalYAML(data []byte, out any) error {
+func parseYAML(data []byte) (map[string]any, error) {
 	lines := splitLines(data)
 	raw := make(map[string]any)
 
@@ -288,12 +2350,12 @@ func unmarshalYAML(data []byte, out any) error {
 		}
 
 		if strings.HasPrefix(line, "-") {
-			return errors.New("top-level lists are not supported")
+			return nil, errors.New("top-level lists are not supported")
 		}
 
 		parts := strings.SplitN(line, ":", 2)
 		if len(parts) != 2 {
-			return fmt.Errorf("invalid line %q", line)
+			return nil, fmt.Errorf("invalid line %q", line)
 		}
 
 		key := strings.TrimSpace(parts[0])
@@ -325,11 +2387,7 @@ func unmarshalYAML(data []byte, out any) error {
 		i++
 	}
 
-	jsonBytes, err := json.Marshal(raw)
-	if err != nil {
-		return err
-	}
-	return json.Unmarshal(jsonBytes, out)
+	return raw, nil
 }
 
 func parseScalar(val string) any {
@@ -362,15 +2420,219 @@ func splitLines(data []byte) []string {
 func Validate(cfg Config) error {
 	var errs []string
 
+	if cfg.StrictConfig {
+		errs = append(errs, cfg.unknownKeys...)
+	}
+
+	switch cfg.InputFormat {
+	case "", InputAuto, InputJSONL, InputJSONArray:
+	default:
+		errs = append(errs, fmt.Sprintf("invalid input_format %q: must be auto, jsonl, or json_array", cfg.InputFormat))
+	}
+	if cfg.MaxLineBytes < 0 {
+		errs = append(errs, fmt.Sprintf("max_line_bytes cannot be negative: %d", cfg.MaxLineBytes))
+	} else if cfg.MaxLineBytes > 0 && cfg.LinePruneBytes > 0 && cfg.MaxLineBytes <= cfg.LinePruneBytes {
+		errs = append(errs, fmt.Sprintf("max_line_bytes (%d) must be larger than line_prune_bytes (%d), or no line is ever pruned", cfg.MaxLineBytes, cfg.LinePruneBytes))
+	}
+	if cfg.InputIdleTimeout != "" {
+		if d, err := time.ParseDuration(cfg.InputIdleTimeout); err != nil || d <= 0 {
+			errs = append(errs, fmt.Sprintf("invalid input_idle_timeout %q: must be a positive duration such as 5m", cfg.InputIdleTimeout))
+		}
+	}
+	switch strings.ToLower(cfg.InputIdleAction) {
+	case "", IdleWarn:
+	case IdleExit:
+		if cfg.InputIdleTimeout == "" {
+			errs = append(errs, "input_idle_action exit requires input_idle_timeout")
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("invalid input_idle_action %q: must be warn or exit", cfg.InputIdleAction))
+	}
+	if cfg.InputReopenOnEOF && len(cfg.Inputs) > 0 {
+		errs = append(errs, "input_reopen_on_eof applies to input, not inputs")
+	}
+	switch strings.ToLower(cfg.InputType) {
+	case "", InputFile:
+	case InputNATS:
+		errs = append(errs, validateNATSInput(cfg)...)
+	case InputJournald:
+		errs = append(errs, validateJournaldInput(cfg)...)
+	default:
+		errs = append(errs, fmt.Sprintf("invalid input_type %q: must be file, nats, or journald", cfg.InputType))
+	}
+	if cfg.NATSInputMaxAckPending < 0 {
+		errs = append(errs, fmt.Sprintf("nats_input_max_ack_pending cannot be negative: %d", cfg.NATSInputMaxAckPending))
+	}
+	if cfg.NATSInputAckWait != "" {
+		if d, err := time.ParseDuration(cfg.NATSInputAckWait); err != nil || d <= 0 {
+			errs = append(errs, fmt.Sprintf("invalid nats_input_ack_wait %q: must be a positive duration such as 30s", cfg.NATSInputAckWait))
+		}
+	}
+	switch strings.ToLower(cfg.ReportFormat) {
+	case "", ReportJSON, ReportMarkdown:
+	default:
+		errs = append(errs, fmt.Sprintf("invalid report_format %q: must be json or markdown", cfg.ReportFormat))
+	}
+	// Reports written to stdout one after another would run together.
+	var stdoutReports []string
+	for _, r := range []struct{ key, path string }{
+		{"report", cfg.ReportPath},
+		{"report_md", cfg.ReportMarkdownPath},
+		{"report_ops_path", cfg.ReportOpsPath},
+		{"report_quality_path", cfg.ReportQualityPath},
+	} {
+		if r.path == "-" {
+			stdoutReports = append(stdoutReports, r.key)
+		}
+	}
+	if len(stdoutReports) > 1 {
+		errs = append(errs, fmt.Sprintf("more than one report on stdout: %s are all -", strings.Join(stdoutReports, ", ")))
+	}
+	switch strings.ToLower(cfg.ReportSchema) {
+	case "", ReportSchemaLegacy, ReportSchemaV2:
+	default:
+		errs = append(errs, fmt.Sprintf("invalid report_schema %q: must be legacy or v2", cfg.ReportSchema))
+	}
+	if cfg.StallWarnAfter != "" {
+		if d, err := time.ParseDuration(cfg.StallWarnAfter); err != nil || d <= 0 {
+			errs = append(errs, fmt.Sprintf("invalid stall_warn_after %q: must be a positive duration such as 2m", cfg.StallWarnAfter))
+		}
+	}
+	switch strings.ToLower(cfg.StallAction) {
+	case "", StallWarn:
+	case StallAbort:
+		if cfg.StallWarnAfter == "" {
+			errs = append(errs, "stall_action abort requires stall_warn_after")
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("invalid stall_action %q: must be warn or abort", cfg.StallAction))
+	}
+	if cfg.InputMergeSorted {
+		if len(cfg.Inputs) == 0 {
+			errs = append(errs, "input_merge_sorted requires inputs to be set")
+		}
+		if cfg.InputFormat == InputJSONArray {
+			errs = append(errs, "input_merge_sorted reads inputs as JSONL and cannot be used with input_format json_array")
+		}
+	}
+
+	// A faulty sink is checked as the sink it wraps from here on.
+	if cfg.OutputType == "faulty" {
+		if cfg.FaultyFailRate < 0 || cfg.FaultyFailRate > 1 {
+			errs = append(errs, fmt.Sprintf("faulty_fail_rate must be between 0.0 and 1.0, got: %.2f", cfg.FaultyFailRate))
+		}
+		if cfg.FaultyFailEvery < 0 || cfg.FaultyFailAfter < 0 || cfg.FaultyLatencyMS < 0 {
+			errs = append(errs, "faulty_fail_every, faulty_fail_after, and faulty_latency_ms cannot be negative")
+		}
+		if cfg.FaultyInner == "faulty" {
+			errs = append(errs, "faulty_inner cannot be faulty")
+		}
+		cfg.OutputType = cfg.FaultyInner
+	}
+
 	// Validate output type
-	if cfg.OutputType != "" && cfg.OutputType != "stdout" && cfg.OutputType != "file" && cfg.OutputType != "rotate" && cfg.OutputType != "rotating" {
-		errs = append(errs, fmt.Sprintf("invalid output_type %q: must be stdout, file, or rotate", cfg.OutputType))
+	if cfg.OutputType != "" && cfg.OutputType != "stdout" && cfg.OutputType != "file" && cfg.OutputType != "rotate" && cfg.OutputType != "rotating" && cfg.OutputType != "http" && cfg.OutputType != "webhook" && cfg.OutputType != "clickhouse" && cfg.OutputType != "grpc" && cfg.OutputType != "nats" && cfg.OutputType != "object" && cfg.OutputType != "router" {
+		errs = append(errs, fmt.Sprintf("invalid output_type %q: must be stdout, file, rotate, http, webhook, clickhouse, grpc, nats, object, or router", cfg.OutputType))
+	}
+	if cfg.OutputType == "router" {
+		errs = append(errs, validateRouter(cfg)...)
+	} else if len(cfg.RouteByLevel) > 0 || len(cfg.RouterRouteOptions) > 0 {
+		errs = append(errs, "route_by_level and router_route_options need output_type router")
+	}
+	if cfg.OutputType == "object" {
+		if u, err := url.Parse(cfg.OutputPath); err != nil || (u.Scheme != "gs" && u.Scheme != "azblob") || u.Host == "" || u.RawQuery != "" {
+			errs = append(errs, fmt.Sprintf("output_path must be a gs://bucket/prefix or azblob://container/prefix URL for output_type object, got %q", cfg.OutputPath))
+		}
+	}
+	if cfg.OutputType == "clickhouse" {
+		if u, err := url.Parse(cfg.OutputPath); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Sprintf("output_path must be an http or https URL for output_type clickhouse, got %q", cfg.OutputPath))
+		}
+		if cfg.ClickHouseTable == "" {
+			errs = append(errs, "clickhouse_table is required when output_type is clickhouse")
+		}
+		if f := strings.ToLower(cfg.OutputFormat); f != "" && f != FormatJSON {
+			errs = append(errs, fmt.Sprintf("output_type clickhouse requires output_format json, got %q", cfg.OutputFormat))
+		}
+		if len(cfg.OutputFields) > 0 || cfg.AggregateWindowSeconds > 0 {
+			errs = append(errs, "output_fields and aggregate_window_seconds cannot be used with output_type clickhouse: rows have fixed columns")
+		}
+	}
+	if cfg.OutputType == "grpc" {
+		if _, _, err := net.SplitHostPort(cfg.OutputPath); err != nil {
+			errs = append(errs, fmt.Sprintf("output_path must be host:port for output_type grpc, got %q", cfg.OutputPath))
+		}
+		if f := strings.ToLower(cfg.OutputFormat); f != "" && f != FormatJSON {
+			errs = append(errs, fmt.Sprintf("output_type grpc requires output_format json, got %q", cfg.OutputFormat))
+		}
+		if len(cfg.OutputFields) > 0 || cfg.AggregateWindowSeconds > 0 {
+			errs = append(errs, "output_fields and aggregate_window_seconds cannot be used with output_type grpc: LogRecord has fixed fields")
+		}
+	}
+	if cfg.OutputType == "nats" {
+		if cfg.OutputPath == "" {
+			errs = append(errs, "output_path must list the NATS server URLs for output_type nats")
+		}
+		if cfg.NATSSubject == "" {
+			errs = append(errs, "nats_subject is required when output_type is nats")
+		}
+		if f := strings.ToLower(cfg.OutputFormat); f != "" && f != FormatJSON {
+			errs = append(errs, fmt.Sprintf("output_type nats requires output_format json, got %q", cfg.OutputFormat))
+		}
+	}
+	if cfg.NATSMaxInFlight < 0 {
+		errs = append(errs, fmt.Sprintf("nats_max_in_flight cannot be negative: %d", cfg.NATSMaxInFlight))
+	}
+	if cfg.NATSAckTimeout != "" {
+		if d, err := time.ParseDuration(cfg.NATSAckTimeout); err != nil || d <= 0 {
+			errs = append(errs, fmt.Sprintf("invalid nats_ack_timeout %q: must be a positive duration such as 5s", cfg.NATSAckTimeout))
+		}
 	}
 
 	// Validate output path requirements
 	if (cfg.OutputType == "file" || cfg.OutputType == "rotate" || cfg.OutputType == "rotating") && cfg.OutputPath == "" {
 		errs = append(errs, "output_path is required when output_type is file or rotate")
 	}
+	if cfg.OutputAtomic || cfg.OutputDoneMarker {
+		switch cfg.OutputType {
+		case "file":
+		case "rotate", "rotating":
+			errs = append(errs, "output_atomic and output_done_marker cannot be used with output_type rotate: rotated files are published as they fill")
+		default:
+			errs = append(errs, fmt.Sprintf("output_atomic and output_done_marker require output_type file, got %q", cfg.OutputType))
+		}
+	}
+	if cfg.RunManifestChecksums && cfg.RunManifestPath == "" {
+		errs = append(errs, "run_manifest_checksums requires run_manifest_path")
+	}
+	if cfg.OutputResume {
+		// A resumed run must write exactly what the interrupted one did.
+		switch {
+		case cfg.OutputType != "file":
+			errs = append(errs, fmt.Sprintf("output_resume requires output_type file, got %q", cfg.OutputType))
+		case cfg.OutputAtomic:
+			errs = append(errs, "output_resume cannot be used with output_atomic: an interrupted atomic run leaves no output to resume")
+		}
+		if cfg.MaxWorkers > 1 || cfg.QueuePriorityByLevel {
+			errs = append(errs, "output_resume requires max_workers 1 without queue_priority_by_level, so records are written in input order")
+		}
+		if cfg.StampRunMetadata {
+			errs = append(errs, "output_resume cannot be used with stamp_run_metadata: each run stamps its own run ID")
+		}
+	}
+	if cfg.OutputManifest && cfg.OutputType != "file" && cfg.OutputType != "rotate" && cfg.OutputType != "rotating" {
+		errs = append(errs, fmt.Sprintf("output_manifest requires output_type file or rotate, got %q", cfg.OutputType))
+	}
+	if cfg.OutputIndex != "" {
+		switch {
+		case cfg.OutputIndex != IndexTraceID:
+			errs = append(errs, fmt.Sprintf("invalid output_index %q: must be trace_id", cfg.OutputIndex))
+		case cfg.OutputType != "file" && cfg.OutputType != "rotate" && cfg.OutputType != "rotating":
+			errs = append(errs, fmt.Sprintf("output_index requires output_type file or rotate, got %q", cfg.OutputType))
+		case cfg.OutputResume:
+			errs = append(errs, "output_index cannot be used with output_resume: the index is written afresh each run")
+		}
+	}
 
 	// Validate numeric limits (must be non-negative)
 	if cfg.MaxWorkers < 0 {
@@ -379,6 +2641,24 @@ func Validate(cfg Config) error {
 	if cfg.QueueSize < 0 {
 		errs = append(errs, fmt.Sprintf("queue_size cannot be negative: %d", cfg.QueueSize))
 	}
+	if cfg.OutputIndent < 0 {
+		errs = append(errs, fmt.Sprintf("output_indent cannot be negative: %d", cfg.OutputIndent))
+	}
+	if _, err := parseFileMode(cfg.OutputFileMode); err != nil {
+		errs = append(errs, fmt.Sprintf("invalid output_file_mode %q: %v", cfg.OutputFileMode, err))
+	}
+	if _, err := parseFileMode(cfg.OutputDirMode); err != nil {
+		errs = append(errs, fmt.Sprintf("invalid output_dir_mode %q: %v", cfg.OutputDirMode, err))
+	}
+	if cfg.OutputOwner != "" {
+		if _, _, err := parseOwner(cfg.OutputOwner); err != nil {
+			errs = append(errs, fmt.Sprintf("invalid output_owner %q: %v", cfg.OutputOwner, err))
+		}
+	}
+	errs = append(errs, validateBaseDir(cfg)...)
+	if cfg.MaxInflightBytes < 0 {
+		errs = append(errs, fmt.Sprintf("max_inflight_bytes cannot be negative: %d", cfg.MaxInflightBytes))
+	}
 	if cfg.SinkMaxRetries < 0 {
 		errs = append(errs, fmt.Sprintf("sink_max_retries cannot be negative: %d", cfg.SinkMaxRetries))
 	}
@@ -391,12 +2671,53 @@ func Validate(cfg Config) error {
 	if cfg.SinkBackoffJitter < 0 {
 		errs = append(errs, fmt.Sprintf("sink_backoff_jitter_pct cannot be negative: %.2f", cfg.SinkBackoffJitter))
 	}
+	if cfg.SinkWriteTimeoutMS < 0 {
+		errs = append(errs, fmt.Sprintf("sink_write_timeout_ms cannot be negative: %d", cfg.SinkWriteTimeoutMS))
+	}
+	if cfg.SinkRetryBudget != "" {
+		if d, err := time.ParseDuration(cfg.SinkRetryBudget); err != nil || d <= 0 {
+			errs = append(errs, fmt.Sprintf("invalid sink_retry_budget %q: must be a positive duration such as 10m", cfg.SinkRetryBudget))
+		}
+	}
+	if cfg.SinkRetryBudgetWindow != "" {
+		if d, err := time.ParseDuration(cfg.SinkRetryBudgetWindow); err != nil || d <= 0 {
+			errs = append(errs, fmt.Sprintf("invalid sink_retry_budget_window %q: must be a positive duration such as 1h", cfg.SinkRetryBudgetWindow))
+		}
+	}
+	if cfg.HTTPMaxIdleConns < 0 {
+		errs = append(errs, fmt.Sprintf("http_max_idle_conns cannot be negative: %d", cfg.HTTPMaxIdleConns))
+	}
+	if cfg.HTTPMaxConnsPerHost < 0 {
+		errs = append(errs, fmt.Sprintf("http_max_conns_per_host cannot be negative: %d", cfg.HTTPMaxConnsPerHost))
+	}
+	if cfg.HTTPIdleConnTimeout != "" {
+		if d, err := time.ParseDuration(cfg.HTTPIdleConnTimeout); err != nil || d <= 0 {
+			errs = append(errs, fmt.Sprintf("invalid http_idle_conn_timeout %q: must be a positive duration such as 90s", cfg.HTTPIdleConnTimeout))
+		}
+	}
+	switch strings.ToLower(cfg.HTTPCompress) {
+	case "", HTTPCompressNone, HTTPCompressGzip:
+	default:
+		errs = append(errs, fmt.Sprintf("invalid http_compress %q: must be none or gzip", cfg.HTTPCompress))
+	}
 	if cfg.OutputMaxB < 0 {
 		errs = append(errs, fmt.Sprintf("output_max_bytes cannot be negative: %d", cfg.OutputMaxB))
 	}
 	if cfg.OutputMaxFiles < 0 {
 		errs = append(errs, fmt.Sprintf("output_max_files cannot be negative: %d", cfg.OutputMaxFiles))
 	}
+	if cfg.OutputPathMaxOpen < 0 {
+		errs = append(errs, fmt.Sprintf("output_path_max_open cannot be negative: %d", cfg.OutputPathMaxOpen))
+	}
+	if cfg.OutputPathTemplated() {
+		// Each of these works on the one output file the path names.
+		if cfg.OutputAtomic || cfg.OutputDoneMarker || cfg.OutputResume || cfg.OutputManifest || cfg.OutputIndex != "" {
+			errs = append(errs, "output_atomic, output_done_marker, output_resume, output_manifest, and output_index need an output path without placeholders")
+		}
+		if len(cfg.OutputFields) > 0 || cfg.AggregateWindowSeconds > 0 {
+			errs = append(errs, "an output path with placeholders is resolved from whole records and cannot be used with output_fields or aggregate_window_seconds")
+		}
+	}
 
 	// Validate DLQ path
 	if cfg.DLQPath != "" {
@@ -408,6 +2729,54 @@ func Validate(cfg Config) error {
 			errs = append(errs, "DLQ path cannot be empty or whitespace-only")
 		}
 	}
+	if cfg.DLQNormalizeFailures && cfg.DLQPath == "" {
+		errs = append(errs, "dlq_normalize_failures requires dlq to be set")
+	}
+	if cfg.DLQDuplicateKeys && (!cfg.StrictJSON || cfg.DLQPath == "") {
+		errs = append(errs, "dlq_duplicate_keys requires strict_json and dlq to be set")
+	}
+	switch strings.ToLower(cfg.SchemaAction) {
+	case "", SchemaWarn, SchemaDrop:
+	case SchemaDLQ:
+		if cfg.DLQPath == "" {
+			errs = append(errs, "schema_action dlq requires dlq to be set")
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("invalid schema_action %q: must be warn, drop, or dlq", cfg.SchemaAction))
+	}
+	if cfg.SchemaAction != "" && cfg.JSONSchemaFile == "" {
+		errs = append(errs, "schema_action requires json_schema_file to be set")
+	}
+	if cfg.TraceRecord != "" {
+		if field, _, ok := strings.Cut(cfg.TraceRecord, "="); !ok || strings.TrimSpace(field) == "" {
+			errs = append(errs, fmt.Sprintf("invalid trace_record %q: must be field=value, e.g. trace_id=abc123", cfg.TraceRecord))
+		}
+	}
+	if cfg.DropRulesReload && cfg.DropRulesFile == "" {
+		errs = append(errs, "drop_rules_reload requires drop_rules_file to be set")
+	}
+	if cfg.DLQMaxRecords < 0 {
+		errs = append(errs, fmt.Sprintf("dlq_max_records cannot be negative: %d", cfg.DLQMaxRecords))
+	}
+	if cfg.DLQMaxBytes < 0 {
+		errs = append(errs, fmt.Sprintf("dlq_max_bytes cannot be negative: %d", cfg.DLQMaxBytes))
+	}
+	switch strings.ToLower(cfg.DLQOverflowPolicy) {
+	case "", DLQOverflowDrop, DLQOverflowAbort:
+	default:
+		errs = append(errs, fmt.Sprintf("invalid dlq_overflow_policy %q: must be drop or abort", cfg.DLQOverflowPolicy))
+	}
+	switch strings.ToLower(cfg.DLQPayload) {
+	case "", DLQPayloadNormalized, DLQPayloadRaw, DLQPayloadBoth:
+	default:
+		errs = append(errs, fmt.Sprintf("invalid dlq_payload %q: must be normalized, raw or both", cfg.DLQPayload))
+	}
+	if cfg.SpillMaxBytes < 0 {
+		errs = append(errs, fmt.Sprintf("spill_max_bytes cannot be negative: %d", cfg.SpillMaxBytes))
+	}
+	if cfg.SpillDir != "" && strings.TrimSpace(cfg.SpillDir) == "" {
+		errs = append(errs, "spill_dir cannot be whitespace-only")
+	}
 
 	// Validate backoff configuration consistency
 	if cfg.SinkBackoffMaxMS > 0 && cfg.SinkBackoffBaseMS > 0 && cfg.SinkBackoffMaxMS < cfg.SinkBackoffBaseMS {
@@ -427,11 +2796,205 @@ func Validate(cfg Config) error {
 		errs = append(errs, fmt.Sprintf("batch_flush_interval_ms cannot be negative: %d", cfg.BatchFlushInterval))
 	}
 
+	// Validate aggregation configuration
+	if cfg.AggregateWindowSeconds < 0 {
+		errs = append(errs, fmt.Sprintf("aggregate_window_seconds cannot be negative: %d", cfg.AggregateWindowSeconds))
+	}
+	if cfg.AggregateLatenessSeconds < 0 {
+		errs = append(errs, fmt.Sprintf("aggregate_lateness_seconds cannot be negative: %d", cfg.AggregateLatenessSeconds))
+	}
+	if cfg.AggregateMaxBuckets < 0 {
+		errs = append(errs, fmt.Sprintf("aggregate_max_buckets cannot be negative: %d", cfg.AggregateMaxBuckets))
+	}
+	if l := strings.ToLower(cfg.AggregateLate); l != "" && l != "drop" && l != "amend" {
+		errs = append(errs, fmt.Sprintf("invalid aggregate_late %q: must be drop or amend", cfg.AggregateLate))
+	}
+	switch strings.ToLower(cfg.OutputFormat) {
+	case "", FormatJSON:
+	case FormatTemplate:
+		if cfg.OutputTemplate == "" {
+			errs = append(errs, "output_template is required when output_format is template")
+		}
+		if t := strings.ToLower(cfg.OutputType); t != "" && t != "stdout" && t != "file" && t != "rotate" && t != "rotating" && t != "object" {
+			errs = append(errs, fmt.Sprintf("output_format template requires output_type stdout, file, rotate, or object, got %q", cfg.OutputType))
+		}
+		if len(cfg.OutputFields) > 0 {
+			errs = append(errs, "output_fields cannot be used with output_format template: use field in the template instead")
+		}
+		if cfg.AggregateWindowSeconds > 0 {
+			errs = append(errs, "output_format template cannot be used with aggregate_window_seconds")
+		}
+	case FormatPretty:
+		if t := strings.ToLower(cfg.OutputType); t != "" && t != "stdout" {
+			errs = append(errs, fmt.Sprintf("output_format pretty requires output_type stdout, got %q", cfg.OutputType))
+		}
+		if len(cfg.OutputFields) > 0 {
+			errs = append(errs, "output_fields cannot be used with output_format pretty: use pretty_fields instead")
+		}
+		if cfg.AggregateWindowSeconds > 0 {
+			errs = append(errs, "output_format pretty cannot be used with aggregate_window_seconds")
+		}
+	case FormatAvro:
+		if t := strings.ToLower(cfg.OutputType); t != "file" && t != "rotate" && t != "rotating" && t != "object" {
+			errs = append(errs, fmt.Sprintf("output_format avro requires output_type file, rotate, or object, got %q", cfg.OutputType))
+		}
+		if len(cfg.OutputFields) > 0 {
+			errs = append(errs, "output_fields cannot be used with output_format avro: the schema is fixed")
+		}
+		if cfg.AggregateWindowSeconds > 0 {
+			errs = append(errs, "output_format avro cannot be used with aggregate_window_seconds")
+		}
+		if cfg.AvroRegistryURL != "" {
+			if u, err := url.Parse(cfg.AvroRegistryURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				errs = append(errs, fmt.Sprintf("invalid avro_registry_url %q: must be an http or https URL", cfg.AvroRegistryURL))
+			}
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("invalid output_format %q: must be json, template, pretty, or avro", cfg.OutputFormat))
+	}
+	if s := strings.ToLower(cfg.OutputSchema); s != "" && s != SchemaLegacy && s != SchemaV1 {
+		errs = append(errs, fmt.Sprintf("invalid output_schema %q: must be legacy or v1", cfg.OutputSchema))
+	}
+	if len(cfg.OutputFields) > 0 && cfg.AggregateWindowSeconds > 0 {
+		errs = append(errs, "output_fields cannot be used with aggregate_window_seconds: aggregate rows have their own shape")
+	}
+
+	// Validate external transform configuration
+	for _, name := range cfg.Transforms {
+		if strings.EqualFold(name, "exec") && len(cfg.ExecCommand) == 0 {
+			errs = append(errs, "exec_command is required when the exec transform is enabled")
+		}
+		if strings.EqualFold(name, "wasm") && cfg.WasmModule == "" {
+			errs = append(errs, "wasm_module is required when the wasm transform is enabled")
+		}
+		if strings.EqualFold(name, "metrics_extract") && cfg.MetricsRules == "" {
+			errs = append(errs, "metrics_rules is required when the metrics_extract transform is enabled")
+		}
+		if strings.EqualFold(name, "namespace_quota") && len(cfg.NamespaceQuota) == 0 {
+			errs = append(errs, "namespace_quota is required when the namespace_quota transform is enabled")
+		}
+		if strings.EqualFold(name, "timestamp_shift") && cfg.TimestampShift == "" {
+			errs = append(errs, "timestamp_shift is required when the timestamp_shift transform is enabled")
+		}
+	}
+	if _, err := NamespaceQuotas(cfg); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if cfg.NamespaceQuotaInterval != "" {
+		if d, err := time.ParseDuration(cfg.NamespaceQuotaInterval); err != nil || d <= 0 {
+			errs = append(errs, fmt.Sprintf("invalid namespace_quota_interval %q: must be a positive duration such as 1h", cfg.NamespaceQuotaInterval))
+		}
+	}
+	switch strings.ToLower(cfg.QuotaOverflow) {
+	case "", QuotaDrop, QuotaSample:
+	default:
+		errs = append(errs, fmt.Sprintf("invalid quota_overflow %q: must be drop or sample", cfg.QuotaOverflow))
+	}
+	if cfg.QuotaSampleEvery < 0 {
+		errs = append(errs, fmt.Sprintf("quota_sample_every cannot be negative: %d", cfg.QuotaSampleEvery))
+	}
+	if cfg.MetricsTextfilePath != "" && !strings.HasSuffix(cfg.MetricsTextfilePath, ".prom") {
+		errs = append(errs, fmt.Sprintf("metrics_textfile_path must end in .prom for the textfile collector to read it: %s", cfg.MetricsTextfilePath))
+	}
+	if policies, err := OnErrorPolicies(cfg); err != nil {
+		errs = append(errs, err.Error())
+	} else {
+		for name, policy := range policies {
+			found := false
+			for _, t := range cfg.Transforms {
+				found = found || strings.EqualFold(t, name)
+			}
+			if !found {
+				errs = append(errs, fmt.Sprintf("transform_on_error names %q, which is not in transforms", name))
+			}
+			if policy == OnErrorDLQ && cfg.DLQPath == "" {
+				errs = append(errs, fmt.Sprintf("transform_on_error %s=dlq requires dlq to be set", name))
+			}
+		}
+	}
+	if cfg.ExecTimeoutMS < 0 {
+		errs = append(errs, fmt.Sprintf("exec_timeout_ms cannot be negative: %d", cfg.ExecTimeoutMS))
+	}
+	if cfg.WasmTimeoutMS < 0 {
+		errs = append(errs, fmt.Sprintf("wasm_timeout_ms cannot be negative: %d", cfg.WasmTimeoutMS))
+	}
+	if cfg.WasmMemoryLimitMB < 0 {
+		errs = append(errs, fmt.Sprintf("wasm_memory_limit_mb cannot be negative: %d", cfg.WasmMemoryLimitMB))
+	}
+
+	if cfg.SortWindow != "" {
+		if d, err := time.ParseDuration(cfg.SortWindow); err != nil || d < 0 {
+			errs = append(errs, fmt.Sprintf("invalid sort_window %q: must be a non-negative duration such as 10s", cfg.SortWindow))
+		}
+	}
+	if cfg.ReplaySpeed < 0 {
+		errs = append(errs, fmt.Sprintf("replay_speed cannot be negative: %g", cfg.ReplaySpeed))
+	}
+	if cfg.ReplaySpeed > 0 {
+		// Pacing spaces each record from the one before, which only means
+		// something when they are written in order.
+		if cfg.MaxWorkers > 1 {
+			errs = append(errs, fmt.Sprintf("replay_speed needs max_workers 1 so records are written in order, not %d", cfg.MaxWorkers))
+		}
+		if cfg.QueuePriorityByLevel {
+			errs = append(errs, "replay_speed cannot be used with queue_priority_by_level: it writes records out of order")
+		}
+	}
+	if cfg.ReplayMaxSleep != "" {
+		if d, err := time.ParseDuration(cfg.ReplayMaxSleep); err != nil || d <= 0 {
+			errs = append(errs, fmt.Sprintf("invalid replay_max_sleep %q: must be a positive duration such as 10s", cfg.ReplayMaxSleep))
+		}
+	}
+	if cfg.TimestampRebase() {
+		// The input is read once to find its latest timestamp, then again
+		// by the run.
+		if t := strings.ToLower(cfg.InputType); t == InputNATS || t == InputJournald {
+			errs = append(errs, fmt.Sprintf("timestamp_shift rebase-now cannot be used with input_type %s: it reads the input twice", t))
+		} else if len(cfg.Inputs) == 0 && (cfg.InputPath == "" || cfg.InputPath == "-") {
+			errs = append(errs, "timestamp_shift rebase-now cannot be used with stdin input: it reads the input twice")
+		}
+	} else if cfg.TimestampShift != "" {
+		if d, err := time.ParseDuration(strings.TrimSpace(cfg.TimestampShift)); err != nil || d == 0 {
+			errs = append(errs, fmt.Sprintf("invalid timestamp_shift %q: must be a non-zero duration such as +72h or -30m, or rebase-now", cfg.TimestampShift))
+		}
+	}
+	switch strings.ToLower(cfg.UnwrapConflict) {
+	case "", UnwrapInner, UnwrapOuter:
+	default:
+		errs = append(errs, fmt.Sprintf("invalid unwrap_conflict %q: must be inner or outer", cfg.UnwrapConflict))
+	}
+	if cfg.Skip < 0 {
+		errs = append(errs, fmt.Sprintf("skip cannot be negative: %d", cfg.Skip))
+	}
+	if cfg.Head < 0 {
+		errs = append(errs, fmt.Sprintf("head cannot be negative: %d", cfg.Head))
+	}
+	if cfg.MaxDuration != "" {
+		if d, err := time.ParseDuration(cfg.MaxDuration); err != nil || d <= 0 {
+			errs = append(errs, fmt.Sprintf("invalid max_duration %q: must be a positive duration such as 14m", cfg.MaxDuration))
+		}
+	}
+
 	// Validate shutdown timeout
 	if cfg.ShutdownTimeoutSeconds < 0 {
 		errs = append(errs, fmt.Sprintf("shutdown_timeout_seconds cannot be negative: %d", cfg.ShutdownTimeoutSeconds))
 	}
 
+	// Validate health probes
+	if cfg.HealthListen != "" {
+		if _, _, err := net.SplitHostPort(cfg.HealthListen); err != nil {
+			errs = append(errs, fmt.Sprintf("health_listen must be host:port (e.g. :8081): %s", cfg.HealthListen))
+		}
+	}
+	if cfg.PprofListen != "" {
+		if _, _, err := net.SplitHostPort(cfg.PprofListen); err != nil {
+			errs = append(errs, fmt.Sprintf("pprof_listen must be host:port (e.g. :6060): %s", cfg.PprofListen))
+		}
+	}
+	if cfg.HealthStaleSeconds < 0 {
+		errs = append(errs, fmt.Sprintf("health_stale_seconds cannot be negative: %d", cfg.HealthStaleSeconds))
+	}
+
 	// Validate log level
 	validLogLevels := map[string]bool{"debug": true, "info": true, "warn": true, "error": true}
 	if cfg.LogLevel != "" && !validLogLevels[strings.ToLower(cfg.LogLevel)] {
@@ -444,8 +3007,162 @@ func Validate(cfg Config) error {
 		errs = append(errs, fmt.Sprintf("invalid log_format %q: must be json or text", cfg.LogFormat))
 	}
 
+	errs = append(errs, secretErrors(cfg)...)
+
 	if len(errs) > 0 {
 		return fmt.Errorf("configuration validation failed:\n  - %s", strings.Join(errs, "\n  - "))
 	}
 	return nil
 }
+
+// validateNATSInput checks the settings of input type nats.
+func validateNATSInput(cfg Config) []string {
+	var errs []string
+	if strings.TrimSpace(cfg.InputPath) == "" {
+		errs = append(errs, "input must list the NATS server URLs for input_type nats")
+	}
+	for _, server := range strings.Split(cfg.InputPath, ",") {
+		if server = strings.TrimSpace(server); server == "" {
+			continue
+		}
+		if !strings.Contains(server, "://") {
+			server = "nats://" + server
+		}
+		if u, err := url.Parse(server); err != nil || u.Host == "" || (u.Scheme != "nats" && u.Scheme != "tls") {
+			errs = append(errs, fmt.Sprintf("input must list nats:// or tls:// server URLs for input_type nats, got %q", server))
+		}
+	}
+	// JetStream names become subject tokens.
+	for _, name := range []struct{ key, value string }{{"nats_input_stream", cfg.NATSInputStream}, {"nats_input_consumer", cfg.NATSInputConsumer}} {
+		switch {
+		case name.value == "":
+			errs = append(errs, name.key+" is required when input_type is nats")
+		case strings.ContainsAny(name.value, ".*> \t\r\n/\\"):
+			errs = append(errs, fmt.Sprintf("invalid %s %q: must not hold dots, wildcards, slashes, or spaces", name.key, name.value))
+		}
+	}
+	if strings.ContainsAny(cfg.NATSInputFilterSubject, " \t\r\n") {
+		errs = append(errs, fmt.Sprintf("invalid nats_input_filter_subject %q: must not hold spaces", cfg.NATSInputFilterSubject))
+	}
+	if len(cfg.Inputs) > 0 || cfg.InputMergeSorted || cfg.InputReopenOnEOF {
+		errs = append(errs, "inputs, input_merge_sorted, and input_reopen_on_eof cannot be used with input_type nats")
+	}
+	if cfg.InputFormat == InputJSONArray {
+		errs = append(errs, "input_type nats reads each message as a line and cannot be used with input_format json_array")
+	}
+	return errs
+}
+
+// validateJournaldInput checks the settings of input type journald.
+func validateJournaldInput(cfg Config) []string {
+	var errs []string
+	if len(cfg.JournaldCommand) > 0 && cfg.JournaldCommand[0] == "" {
+		errs = append(errs, "journald_command must start with the program to run")
+	}
+	for _, unit := range cfg.JournaldUnits {
+		// Units become journalctl arguments.
+		if unit == "" || strings.HasPrefix(unit, "-") || strings.ContainsAny(unit, " \t\r\n") {
+			errs = append(errs, fmt.Sprintf("invalid journald_units entry %q: must be a unit name or pattern such as nginx.service", unit))
+		}
+	}
+	if len(cfg.Inputs) > 0 || cfg.InputMergeSorted || cfg.InputReopenOnEOF {
+		errs = append(errs, "inputs, input_merge_sorted, and input_reopen_on_eof cannot be used with input_type journald")
+	}
+	if cfg.InputFormat == InputJSONArray {
+		errs = append(errs, "input_type journald reads one entry per line and cannot be used with input_format json_array")
+	}
+	return errs
+}
+
+// validateRouter checks the routes and rules of output type router.
+func validateRouter(cfg Config) []string {
+	var errs []string
+	if len(cfg.RouterRoutes) == 0 {
+		errs = append(errs, "router_routes is required when output_type is router")
+	}
+	routes := map[string]bool{}
+	for _, entry := range cfg.RouterRoutes {
+		r, err := ParseRouterRoute(entry)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if routes[r.Name] {
+			errs = append(errs, fmt.Sprintf("router_routes names route %q twice", r.Name))
+		}
+		routes[r.Name] = true
+	}
+	for _, entry := range cfg.RouterRules {
+		rule, err := ParseRouterRule(entry)
+		if err != nil {
+			errs = append(errs, err.Error())
+		} else if !routes[rule.Route] {
+			errs = append(errs, fmt.Sprintf("router_rules entry %q names unknown route %q", entry, rule.Route))
+		}
+	}
+	levels := map[string]bool{}
+	for _, entry := range cfg.RouteByLevel {
+		rule, err := ParseRouteByLevel(entry)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if !routes[rule.Route] {
+			errs = append(errs, fmt.Sprintf("route_by_level entry %q names unknown route %q", entry, rule.Route))
+		}
+		for _, l := range rule.Conditions[0].Values {
+			if levels[l] {
+				errs = append(errs, fmt.Sprintf("route_by_level routes level %s twice", l))
+			}
+			levels[l] = true
+		}
+	}
+	optioned := map[string]bool{}
+	for _, entry := range cfg.RouterRouteOptions {
+		o, err := ParseRouteOptions(entry)
+		switch {
+		case err != nil:
+			errs = append(errs, err.Error())
+		case !routes[o.Route]:
+			errs = append(errs, fmt.Sprintf("router_route_options entry %q names unknown route %q", entry, o.Route))
+		case optioned[o.Route]:
+			errs = append(errs, fmt.Sprintf("router_route_options sets route %q twice", o.Route))
+		}
+		optioned[o.Route] = true
+	}
+	if cfg.RouterDefault != "" && !routes[cfg.RouterDefault] {
+		errs = append(errs, fmt.Sprintf("router_default names unknown route %q", cfg.RouterDefault))
+	}
+	return errs
+}
+
+// Warnings returns settings that are valid but probably not what was meant.
+// Unlike Validate's findings they do not stop a run.
+func Warnings(cfg Config) []string {
+	warns := slices.Clone(cfg.keyWarnings)
+	if !cfg.StrictConfig {
+		for _, k := range cfg.unknownKeys {
+			warns = append(warns, k+"; it is ignored")
+		}
+	}
+	if len(cfg.NamespaceQuota) > 0 && !slices.ContainsFunc(cfg.Transforms, func(t string) bool { return strings.EqualFold(t, "namespace_quota") }) {
+		warns = append(warns, "namespace_quota is ignored: the namespace_quota transform is not in transforms")
+	}
+	if cfg.RedactionAudit && len(cfg.RedactKeys) == 0 {
+		warns = append(warns, "redaction_audit has nothing to record: redact_keys is empty")
+	}
+	if cfg.OutputType == "faulty" {
+		warns = append(warns, "output_type faulty fails writes on purpose; it is meant for testing and drills only")
+	}
+	if cfg.OutputOwner != "" && os.Geteuid() != 0 {
+		warns = append(warns, fmt.Sprintf("output_owner %q is ignored: files can only be given away when running as root", cfg.OutputOwner))
+	}
+	for _, f := range cfg.OutputFields {
+		lower := strings.ToLower(f)
+		if slices.Contains(model.FieldNames, lower) || lower == "fields" || strings.HasPrefix(lower, "fields.") {
+			continue
+		}
+		warns = append(warns, fmt.Sprintf("output_fields entry %q is not a normalized field (%s); it is read from fields, use fields.%s to say so", f, strings.Join(model.FieldNames, ", "), f))
+	}
+	return warns
+}
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// renamedKeys maps a config key that was renamed to its new name. A file
+// still using the old key loads as if it used the new one, with a
+// warning, for a release; then the entry is dropped and the old key is
+// reported as unknown like any other.
+var renamedKeys = map[string]string{}
+
+// knownKeys are the keys a config file may set, sorted: the json name of
+// every Config field.
+var knownKeys = findKnownKeys()
+
+func findKnownKeys() []string {
+	t := reflect.TypeOf(Config{})
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		if key, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ","); key != "" && key != "-" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// checkKeys looks over the top-level keys of doc, a config file as a JSON
+// object, setting in cfg the values of renamed keys and recording what it
+// found for Validate and Warnings. A renamed key whose new name is also
+// set is ignored.
+func checkKeys(doc []byte, cfg *Config) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(doc, &raw); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(raw))
+	for key := range raw {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+
+	for _, key := range names {
+		if newKey, ok := renamedKeys[key]; ok {
+			if _, set := raw[newKey]; set {
+				cfg.keyWarnings = append(cfg.keyWarnings, fmt.Sprintf("config key %q is deprecated and ignored because %q is also set", key, newKey))
+				continue
+			}
+			renamed, err := json.Marshal(map[string]json.RawMessage{newKey: raw[key]})
+			if err != nil {
+				return err
+			}
+			if err := json.Unmarshal(renamed, cfg); err != nil {
+				return fmt.Errorf("%s (set as %s): %w", newKey, key, err)
+			}
+			cfg.keyWarnings = append(cfg.keyWarnings, fmt.Sprintf("config key %q is deprecated; rename it to %q", key, newKey))
+			continue
+		}
+		if _, ok := sort.Find(len(knownKeys), func(i int) int { return strings.Compare(key, knownKeys[i]) }); ok {
+			continue
+		}
+		msg := fmt.Sprintf("unknown config key %q", key)
+		if s := suggestKey(key); s != "" {
+			msg += fmt.Sprintf(" (did you mean %q?)", s)
+		}
+		cfg.unknownKeys = append(cfg.unknownKeys, msg)
+	}
+	return nil
+}
+
+// suggestKey returns the known key closest to key by edit distance, if one
+// is close enough to be a likely misspelling: at most a third of its
+// length away, and never more than three edits.
+func suggestKey(key string) string {
+	best, bestDist := "", min(3, max(1, len(key)/3))+1
+	for _, known := range knownKeys {
+		if d := editDistance(key, known); d < bestDist {
+			best, bestDist = known, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
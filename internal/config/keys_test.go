@@ -0,0 +1,112 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadUnknownKeys(t *testing.T) {
+	files := map[string]string{
+		"etl.yaml": "filter_service:\n  - orders\noutput: out.jsonl\nfrobnicate: 1\n",
+		"etl.json": `{"filter_service": ["orders"], "output": "out.jsonl", "frobnicate": 1}`,
+	}
+	want := []string{
+		`unknown config key "filter_service" (did you mean "filter_services"?)`,
+		`unknown config key "frobnicate"`,
+	}
+	for name, content := range files {
+		cfg, err := Load(writeConfig(t, name, content))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if cfg.OutputPath != "out.jsonl" {
+			t.Errorf("%s: output = %q, want out.jsonl", name, cfg.OutputPath)
+		}
+		if !reflect.DeepEqual(cfg.unknownKeys, want) {
+			t.Errorf("%s: unknown keys = %q, want %q", name, cfg.unknownKeys, want)
+		}
+
+		// Merged under defaults and overrides, the findings are warnings
+		// unless strict_config is on.
+		merged := Merge(Merge(Default(), cfg), Config{OutputType: "file"})
+		if err := Validate(merged); err != nil {
+			t.Errorf("%s: Validate: %v", name, err)
+		}
+		warns := strings.Join(Warnings(merged), "\n")
+		if !strings.Contains(warns, want[0]+"; it is ignored") {
+			t.Errorf("%s: warnings %q lack %q", name, warns, want[0])
+		}
+
+		merged.StrictConfig = true
+		err = Validate(merged)
+		if err == nil || !strings.Contains(err.Error(), want[0]) || !strings.Contains(err.Error(), want[1]) {
+			t.Errorf("%s: strict Validate = %v, want both unknown keys", name, err)
+		}
+		if warns := strings.Join(Warnings(merged), "\n"); strings.Contains(warns, "unknown config key") {
+			t.Errorf("%s: strict warnings repeat the errors: %q", name, warns)
+		}
+	}
+}
+
+func TestLoadRenamedKeys(t *testing.T) {
+	renamedKeys["output_max_size"] = "output_max_bytes"
+	t.Cleanup(func() { delete(renamedKeys, "output_max_size") })
+
+	cfg, err := Load(writeConfig(t, "etl.yaml", "output_max_size: 1024\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.OutputMaxB != 1024 {
+		t.Errorf("output_max_bytes = %d, want 1024 from the old key", cfg.OutputMaxB)
+	}
+	if len(cfg.unknownKeys) != 0 {
+		t.Errorf("renamed key reported unknown: %q", cfg.unknownKeys)
+	}
+	want := []string{`config key "output_max_size" is deprecated; rename it to "output_max_bytes"`}
+	if got := Warnings(cfg); !reflect.DeepEqual(got, want) {
+		t.Errorf("warnings = %q, want %q", got, want)
+	}
+
+	cfg, err = Load(writeConfig(t, "etl.json", `{"output_max_size": 1024, "output_max_bytes": 2048}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.OutputMaxB != 2048 {
+		t.Errorf("output_max_bytes = %d, want 2048 from the new key", cfg.OutputMaxB)
+	}
+	if got := Warnings(cfg); len(got) != 1 || !strings.Contains(got[0], "ignored") {
+		t.Errorf("warnings = %q, want the old key reported ignored", got)
+	}
+
+	if _, err := Load(writeConfig(t, "bad.json", `{"output_max_size": "big"}`)); err == nil || !strings.Contains(err.Error(), "output_max_size") {
+		t.Errorf("bad renamed value: err = %v, want it to name the old key", err)
+	}
+}
+
+func TestSuggestKey(t *testing.T) {
+	tests := map[string]string{
+		"filter_service":             "filter_services",
+		"ouptut":                     "output",
+		"max_worker":                 "max_workers",
+		"redactkeys":                 "redact_keys",
+		"xyz":                        "",
+		"completely_unrelated_thing": "",
+	}
+	for key, want := range tests {
+		if got := suggestKey(key); got != want {
+			t.Errorf("suggestKey(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// ParseList splits a list setting, as given in an env var or a flag, into
+// its items. Items are separated by commas or semicolons; \, and \; stand
+// for a comma or semicolon inside an item, and any other backslash is kept,
+// so Windows paths need no escaping. A value that is a JSON array of
+// strings, such as ["user_email","a,b"], is taken item by item instead.
+// Items are trimmed and empty ones dropped.
+func ParseList(s string) []string {
+	var items []string
+	if trimmed := strings.TrimSpace(s); strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal([]byte(trimmed), &items); err != nil {
+			items = nil
+		}
+	}
+	if items == nil {
+		items = splitList(s)
+	}
+	out := make([]string, 0, len(items))
+	for _, item := range items {
+		if trimmed := strings.TrimSpace(item); trimmed != "" {
+			out = append(out, trimmed)
+		}
+	}
+	return out
+}
+
+// splitList splits s at unescaped commas and semicolons.
+func splitList(s string) []string {
+	var items []string
+	var item strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s) && (s[i+1] == ',' || s[i+1] == ';'):
+			i++
+			item.WriteByte(s[i])
+		case c == ',' || c == ';':
+			items = append(items, item.String())
+			item.Reset()
+		default:
+			item.WriteByte(c)
+		}
+	}
+	return append(items, item.String())
+}
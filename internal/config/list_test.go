@@ -0,0 +1,43 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseList(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"a,b;c", []string{"a", "b", "c"}},
+		{" a , ,b, ", []string{"a", "b"}},
+		{"", []string{}},
+		{`a\,b,c`, []string{"a,b", "c"}},
+		{`a\;b;c`, []string{"a;b", "c"}},
+		{`C:\logs\*.jsonl,\\server\share\x.jsonl`, []string{`C:\logs\*.jsonl`, `\\server\share\x.jsonl`}},
+		{`trailing\`, []string{`trailing\`}},
+		{`["user_email","a,b", " c ",""]`, []string{"user_email", "a,b", "c"}},
+		{` ["a;b"] `, []string{"a;b"}},
+		// Not a JSON array of strings, so split as usual.
+		{`[a,b]`, []string{"[a", "b]"}},
+		{`[1,2]`, []string{"[1", "2]"}},
+	}
+	for _, tt := range tests {
+		if got := ParseList(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseList(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFromEnvListJSON(t *testing.T) {
+	t.Setenv("ETL_REDACT_KEYS", `["token","a,b"]`)
+	t.Setenv("ETL_FILTER_SERVICES", `orders\,eu;payments`)
+	cfg := FromEnv(Default())
+	if want := []string{"token", "a,b"}; !reflect.DeepEqual(cfg.RedactKeys, want) {
+		t.Errorf("RedactKeys = %q, want %q", cfg.RedactKeys, want)
+	}
+	if want := []string{"orders,eu", "payments"}; !reflect.DeepEqual(cfg.FilterSvcs, want) {
+		t.Errorf("FilterSvcs = %q, want %q", cfg.FilterSvcs, want)
+	}
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// Masked is what Redacted puts in place of a set secret.
+const Masked = "***"
+
+// A Config field holding a credential is tagged secret:"<key>", its config
+// key. A string field keyed <key>_file next to it names a file to read
+// the value from, the usual way to hand a Kubernetes Secret to a pod. A
+// new sink's credential gets file loading, validation, and masking by
+// declaring the two fields; Merge and FromEnv still list them like any
+// other.
+type secretField struct {
+	key   string
+	value int // index of the secret field
+	file  int // index of its <key>_file field
+}
+
+var secretFields = findSecretFields()
+
+func findSecretFields() []secretField {
+	t := reflect.TypeOf(Config{})
+	byKey := make(map[string]int, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if key, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ","); key != "" && key != "-" {
+			byKey[key] = i
+		}
+	}
+	var fields []secretField
+	for i := 0; i < t.NumField(); i++ {
+		key := t.Field(i).Tag.Get("secret")
+		if key == "" {
+			continue
+		}
+		file, ok := byKey[key+"_file"]
+		if !ok || t.Field(file).Type.Kind() != reflect.String {
+			panic(fmt.Sprintf("config: secret %s has no %s_file string field", key, key))
+		}
+		fields = append(fields, secretField{key: key, value: i, file: file})
+	}
+	return fields
+}
+
+// ReadSecretFiles returns cfg with each unset secret whose <key>_file is
+// set read from that file, surrounding whitespace trimmed. A file that
+// cannot be read, or a secret also set another way, leaves the secret as
+// it was; Validate reports both.
+func ReadSecretFiles(cfg Config) Config {
+	v := reflect.ValueOf(&cfg).Elem()
+	for _, f := range secretFields {
+		path := v.Field(f.file).String()
+		if path == "" || v.Field(f.value).String() != "" {
+			continue
+		}
+		if secret, err := readSecretFile(path); err == nil {
+			v.Field(f.value).SetString(secret)
+		}
+	}
+	return cfg
+}
+
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	secret := strings.TrimSpace(string(data))
+	if secret == "" {
+		return "", fmt.Errorf("file is empty")
+	}
+	return secret, nil
+}
+
+// secretErrors is Validate's findings for the secret files of cfg.
+func secretErrors(cfg Config) []string {
+	var errs []string
+	v := reflect.ValueOf(cfg)
+	for _, f := range secretFields {
+		path := v.Field(f.file).String()
+		if path == "" {
+			continue
+		}
+		secret, err := readSecretFile(path)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("cannot read %s_file %q: %v", f.key, path, err))
+			continue
+		}
+		// After ReadSecretFiles the value matches the file unless it was
+		// also set some other way.
+		if inline := v.Field(f.value).String(); inline != "" && inline != secret {
+			errs = append(errs, fmt.Sprintf("%s and %s_file are both set; use one", f.key, f.key))
+		}
+	}
+	return errs
+}
+
+// Redacted returns cfg with every set secret replaced by Masked, for
+// printing. The _file paths are kept; they are not secret. A secret read
+// from its _file is left empty instead, so the redacted config loads back
+// and reads the file again.
+func (c Config) Redacted() Config {
+	v := reflect.ValueOf(&c).Elem()
+	for _, f := range secretFields {
+		switch {
+		case v.Field(f.file).String() != "":
+			v.Field(f.value).SetString("")
+		case v.Field(f.value).String() != "":
+			v.Field(f.value).SetString(Masked)
+		}
+	}
+	return c
+}
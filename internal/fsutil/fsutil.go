@@ -0,0 +1,85 @@
+// Package fsutil wraps the file operations whose behavior differs between
+// Unix and Windows.
+//
+// On Windows a file cannot be renamed over, or deleted, while another
+// process holds it open without delete sharing, which virus scanners,
+// indexers, and log tailers routinely do for a moment. Rename and Remove
+// retry those failures briefly instead of failing the run.
+package fsutil
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// retryDelays are the waits between attempts when an operation fails
+// because another process has the file open.
+var retryDelays = []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond, 80 * time.Millisecond}
+
+// Rename moves oldpath to newpath, replacing newpath if it exists.
+func Rename(oldpath, newpath string) error {
+	return retry(func() error { return os.Rename(oldpath, newpath) }, isInUse)
+}
+
+// Remove deletes path. A path that does not exist is not an error.
+func Remove(path string) error {
+	err := retry(func() error { return os.Remove(path) }, isInUse)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// SamePath reports whether a and b name the same file path, ignoring case
+// where the file system does.
+func SamePath(a, b string) bool {
+	return samePath(a, b, caseInsensitive)
+}
+
+func samePath(a, b string, fold bool) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		absA, absB = a, b
+	}
+	if fold {
+		return strings.EqualFold(absA, absB)
+	}
+	return absA == absB
+}
+
+// retry runs op until it succeeds, fails with an error transient does not
+// accept, or retryDelays run out.
+func retry(op func() error, transient func(error) bool) error {
+	err := op()
+	for _, d := range retryDelays {
+		if err == nil || !transient(err) {
+			return err
+		}
+		time.Sleep(d)
+		err = op()
+	}
+	return err
+}
+
+// Windows error codes for a file another process has open.
+const (
+	errorAccessDenied     = syscall.Errno(5)
+	errorSharingViolation = syscall.Errno(32)
+	errorLockViolation    = syscall.Errno(33)
+)
+
+// inUseOnWindows reports whether err is one of the Windows errors for a
+// file held open elsewhere. It is platform independent so it can be
+// tested anywhere; isInUse only uses it on Windows.
+func inUseOnWindows(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	return errno == errorAccessDenied || errno == errorSharingViolation || errno == errorLockViolation
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package fsutil
+
+import "syscall"
+
+// noFollow makes opening a symbolic link fail.
+const noFollow = syscall.O_NOFOLLOW
+
+// caseInsensitive is false even on macOS, whose default volumes ignore
+// case: paths that differ only in case are rare there and never wrong to
+// treat as different.
+const caseInsensitive = false
+
+// isInUse is always false: Unix renames and removes open files.
+func isInUse(error) bool {
+	return false
+}
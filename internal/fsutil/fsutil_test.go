@@ -0,0 +1,102 @@
+package fsutil
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	old := retryDelays
+	retryDelays = []time.Duration{0, 0, 0}
+	t.Cleanup(func() { retryDelays = old })
+
+	busy := &fs.PathError{Op: "rename", Path: "out.jsonl", Err: errorSharingViolation}
+	calls := 0
+	err := retry(func() error {
+		if calls++; calls < 3 {
+			return busy
+		}
+		return nil
+	}, inUseOnWindows)
+	if err != nil || calls != 3 {
+		t.Errorf("retry = %v after %d calls, want success on the 3rd", err, calls)
+	}
+
+	calls = 0
+	err = retry(func() error { calls++; return busy }, inUseOnWindows)
+	if !errors.Is(err, errorSharingViolation) || calls != 4 {
+		t.Errorf("retry = %v after %d calls, want the last error after 4", err, calls)
+	}
+
+	calls = 0
+	err = retry(func() error { calls++; return os.ErrNotExist }, inUseOnWindows)
+	if !errors.Is(err, os.ErrNotExist) || calls != 1 {
+		t.Errorf("a permanent error should not be retried: %v after %d calls", err, calls)
+	}
+}
+
+func TestInUseOnWindows(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&fs.PathError{Op: "remove", Path: "x", Err: errorAccessDenied}, true},
+		{&os.LinkError{Op: "rename", Old: "a", New: "b", Err: errorSharingViolation}, true},
+		{fmt.Errorf("wrapped: %w", errorLockViolation), true},
+		{&fs.PathError{Op: "remove", Path: "x", Err: syscall.Errno(2)}, false}, // ERROR_FILE_NOT_FOUND
+		{errors.New("access denied"), false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := inUseOnWindows(tt.err); got != tt.want {
+			t.Errorf("inUseOnWindows(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestSamePath(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "DLQ.jsonl")
+	tests := []struct {
+		a, b string
+		fold bool
+		want bool
+	}{
+		{a, a, false, true},
+		{a, filepath.Join(dir, ".", "DLQ.jsonl"), false, true},
+		{a, filepath.Join(dir, "dlq.jsonl"), false, false},
+		{a, filepath.Join(dir, "dlq.jsonl"), true, true},
+		{`C:\Logs\dlq.jsonl`, `c:\logs\DLQ.JSONL`, true, true},
+		{a, filepath.Join(dir, "other.jsonl"), true, false},
+	}
+	for _, tt := range tests {
+		if got := samePath(tt.a, tt.b, tt.fold); got != tt.want {
+			t.Errorf("samePath(%q, %q, fold=%v) = %v, want %v", tt.a, tt.b, tt.fold, got, tt.want)
+		}
+	}
+}
+
+func TestRemoveMissing(t *testing.T) {
+	if err := Remove(filepath.Join(t.TempDir(), "nope")); err != nil {
+		t.Errorf("Remove of a missing file = %v, want nil", err)
+	}
+}
+
+func TestRenameReplaces(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "out.tmp"), filepath.Join(dir, "out")
+	os.WriteFile(src, []byte("new"), 0o644)
+	os.WriteFile(dst, []byte("old"), 0o644)
+	if err := Rename(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(dst); string(b) != "new" {
+		t.Errorf("dst = %q, want new", b)
+	}
+}
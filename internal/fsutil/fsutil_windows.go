@@ -0,0 +1,13 @@
+//go:build windows
+
+package fsutil
+
+// noFollow is unset: Within's checks are all Windows has.
+const noFollow = 0
+
+// NTFS and FAT compare names case-insensitively.
+const caseInsensitive = true
+
+func isInUse(err error) bool {
+	return inUseOnWindows(err)
+}
@@ -0,0 +1,80 @@
+package fsutil
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// Errors returned by Within.
+var (
+	// ErrUnsafePath is a path holding a null byte.
+	ErrUnsafePath = errors.New("unsafe path")
+	// ErrOutsideBase is a path that does not lie under the base
+	// directory.
+	ErrOutsideBase = errors.New("path outside the base directory")
+	// ErrSymlink is a path through a symbolic link below the base
+	// directory, which could lead out of it.
+	ErrSymlink = errors.New("path through a symbolic link")
+)
+
+// Within checks that path, made absolute, lies under base, and that none of
+// its components below base that already exist is a symbolic link. base's
+// own components may be links; it is trusted configuration. A path with a
+// null byte is refused whatever base is.
+func Within(base, path string) error {
+	if strings.ContainsRune(path, 0) {
+		return fmt.Errorf("%w: %q holds a null byte", ErrUnsafePath, path)
+	}
+	absBase, err := filepath.Abs(base)
+	if err != nil {
+		return err
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(absBase, absPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%w: %s is not under %s", ErrOutsideBase, path, base)
+	}
+	if rel == "." {
+		return nil
+	}
+	dir := absBase
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		dir = filepath.Join(dir, part)
+		fi, err := os.Lstat(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil // nothing below exists yet
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%w: %s", ErrSymlink, dir)
+		}
+	}
+	return nil
+}
+
+// SafeName returns v fit to be one element of a path: every character but
+// letters, digits, '.', '_', and '-' becomes '_', as does each "..", and a
+// lone "." is "_". Path separators, null bytes, and other control
+// characters are among those replaced, so the result cannot name another
+// directory.
+func SafeName(v string) string {
+	v = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '_' || r == '-' {
+			return r
+		}
+		return '_'
+	}, v)
+	if v == "." {
+		return "_"
+	}
+	return strings.ReplaceAll(v, "..", "_")
+}
@@ -0,0 +1,88 @@
+package fsutil
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWithin(t *testing.T) {
+	base := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(base, "logs"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	outside := t.TempDir()
+	for _, tc := range []struct {
+		path string
+		want error
+	}{
+		{filepath.Join(base, "out.jsonl"), nil},
+		{filepath.Join(base, "logs", "new", "out.jsonl"), nil},
+		{base, nil},
+		{filepath.Join(base, "logs", "..", "out.jsonl"), nil},
+		{filepath.Join(base, "..", "out.jsonl"), ErrOutsideBase},
+		{filepath.Join(base, "logs", "..", "..", "etc", "passwd"), ErrOutsideBase},
+		{filepath.Join(outside, "out.jsonl"), ErrOutsideBase},
+		{base + "-sibling", ErrOutsideBase},
+		{filepath.Join(base, "out\x00.jsonl"), ErrUnsafePath},
+	} {
+		if err := Within(base, tc.path); !errors.Is(err, tc.want) {
+			t.Errorf("Within(%q) = %v, want %v", tc.path, err, tc.want)
+		}
+	}
+}
+
+func TestWithinRefusesSymlinks(t *testing.T) {
+	base, outside := t.TempDir(), t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(base, "escape")); err != nil {
+		t.Skipf("symlinks unavailable: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "x"), filepath.Join(base, "out.jsonl")); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{
+		filepath.Join(base, "escape", "out.jsonl"),
+		filepath.Join(base, "out.jsonl"),
+	} {
+		if err := Within(base, path); !errors.Is(err, ErrSymlink) {
+			t.Errorf("Within(%q) = %v, want ErrSymlink", path, err)
+		}
+	}
+
+	// Perm with a Base refuses them too, before creating anything.
+	p := Perm{Base: base}
+	if _, err := p.Create(filepath.Join(base, "out.jsonl")); !errors.Is(err, ErrSymlink) {
+		t.Errorf("Create through a link = %v", err)
+	}
+	if err := p.MkdirAll(filepath.Join(base, "escape", "sub")); !errors.Is(err, ErrSymlink) {
+		t.Errorf("MkdirAll through a link = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "sub")); !os.IsNotExist(err) {
+		t.Errorf("MkdirAll made a directory outside the base: %v", err)
+	}
+}
+
+func TestPermCheckRefusesNullBytes(t *testing.T) {
+	if _, err := (Perm{}).Create(filepath.Join(t.TempDir(), "a\x00b")); !errors.Is(err, ErrUnsafePath) {
+		t.Errorf("Create = %v, want ErrUnsafePath", err)
+	}
+}
+
+func TestSafeName(t *testing.T) {
+	for in, want := range map[string]string{
+		"payments-api_2.log": "payments-api_2.log",
+		"../../etc":          "____etc",
+		"..":                 "_",
+		".":                  "_",
+		`a\b/c`:              "a_b_c",
+		"/etc/passwd":        "_etc_passwd",
+		"nul\x00byte":        "nul_byte",
+		"new\nline":          "new_line",
+		"héllo wörld":        "héllo_wörld",
+	} {
+		if got := SafeName(in); got != want {
+			t.Errorf("SafeName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package fsutil
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Perm says how the files a run writes are created. The zero Perm keeps
+// the os defaults: files are 0666 and directories 0755, less the umask,
+// and a file that already exists keeps its mode.
+type Perm struct {
+	// FileMode and DirMode, when not zero, are set on each file created
+	// and each directory made for one, whatever the umask.
+	FileMode os.FileMode
+	DirMode  os.FileMode
+	// With Chown, files and directories are given to UID and GID. It only
+	// takes effect when running as root, e.g. in an initContainer that
+	// prepares a volume for a non-root reader.
+	Chown    bool
+	UID, GID int
+	// Base, when set, jails the run's files: every path must pass Within
+	// Base before anything is created there, and files are opened without
+	// following a final symbolic link.
+	Base string
+}
+
+// Check returns the error Within gives path under p.Base, or nil when
+// Base is unset and path is otherwise usable.
+func (p Perm) Check(path string) error {
+	if p.Base == "" {
+		if strings.ContainsRune(path, 0) {
+			return fmt.Errorf("%w: %q holds a null byte", ErrUnsafePath, path)
+		}
+		return nil
+	}
+	return Within(p.Base, path)
+}
+
+// Create creates or truncates path like os.Create, with p applied.
+func (p Perm) Create(path string) (*os.File, error) {
+	return p.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+}
+
+// OpenFile is os.OpenFile with p applied, also to a file that already
+// exists, in place of a mode.
+func (p Perm) OpenFile(path string, flag int) (*os.File, error) {
+	if err := p.Check(path); err != nil {
+		return nil, err
+	}
+	if p.Base != "" {
+		flag |= noFollow
+	}
+	mode := p.FileMode
+	if mode == 0 {
+		mode = 0o666
+	}
+	f, err := os.OpenFile(path, flag, mode)
+	if err != nil {
+		return nil, err
+	}
+	if p.FileMode != 0 {
+		// OpenFile's mode is narrowed by the umask and ignored for a file
+		// that already exists.
+		err = f.Chmod(p.FileMode)
+	}
+	if err == nil && p.chown() {
+		err = f.Chown(p.UID, p.GID)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// WriteFile writes data to path.tmp, created with p, and renames it over
+// path, so a reader never sees a partial file. A stale path.tmp is
+// removed first and the new one is created exclusively: a symbolic link
+// planted there is never followed, even without Base.
+func (p Perm) WriteFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	for _, name := range []string{path, tmp} {
+		if err := p.Check(name); err != nil {
+			return err
+		}
+	}
+	if err := Remove(tmp); err != nil {
+		return err
+	}
+	f, err := p.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// MkdirAll creates dir and any missing parents like os.MkdirAll, applying
+// p to the directories it creates; existing ones are left alone.
+func (p Perm) MkdirAll(dir string) error {
+	if err := p.Check(dir); err != nil {
+		return err
+	}
+	mode := p.DirMode
+	if mode == 0 {
+		mode = 0o755
+	}
+	if p.DirMode == 0 && !p.chown() {
+		return os.MkdirAll(dir, mode)
+	}
+	var missing []string
+	for d := filepath.Clean(dir); ; {
+		if _, err := os.Stat(d); !errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		missing = append(missing, d)
+		parent := filepath.Dir(d)
+		if parent == d {
+			break
+		}
+		d = parent
+	}
+	if err := os.MkdirAll(dir, mode); err != nil {
+		return err
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		if p.DirMode != 0 {
+			if err := os.Chmod(missing[i], p.DirMode); err != nil {
+				return err
+			}
+		}
+		if p.chown() {
+			if err := os.Chown(missing[i], p.UID, p.GID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// chown reports whether p changes owners: only root may give files away,
+// and Windows, where Geteuid is -1, has no such owners.
+func (p Perm) chown() bool {
+	return p.Chown && os.Geteuid() == 0
+}
@@ -0,0 +1,133 @@
+//go:build !windows
+
+package fsutil
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestPerm_CreateSetsModeWhateverTheUmask(t *testing.T) {
+	old := syscall.Umask(0o077)
+	t.Cleanup(func() { syscall.Umask(old) })
+
+	path := filepath.Join(t.TempDir(), "out.jsonl")
+	// An existing file keeps its mode with os.Create; Perm changes it.
+	if err := os.WriteFile(path, []byte("old\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Perm{FileMode: 0o660}.Create(path)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	f.Close()
+	if got := mode(t, path); got != 0o660 {
+		t.Errorf("mode %o, want 660", got)
+	}
+
+	// The zero Perm leaves the umask in charge, as os.Create does.
+	plain := filepath.Join(t.TempDir(), "plain.jsonl")
+	if f, err = (Perm{}).Create(plain); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	f.Close()
+	if got := mode(t, plain); got != 0o600 {
+		t.Errorf("zero Perm mode %o, want 600 under umask 077", got)
+	}
+}
+
+func TestPerm_MkdirAllOnlyChangesNewDirectories(t *testing.T) {
+	old := syscall.Umask(0o022)
+	t.Cleanup(func() { syscall.Umask(old) })
+
+	root := t.TempDir()
+	if err := os.Chmod(root, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(root, "a", "b")
+	if err := (Perm{DirMode: 0o770}).MkdirAll(dir); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	for _, d := range []string{filepath.Join(root, "a"), dir} {
+		if got := mode(t, d); got != 0o770 {
+			t.Errorf("%s: mode %o, want 770", d, got)
+		}
+	}
+	if got := mode(t, root); got != 0o755 {
+		t.Errorf("existing directory changed to %o", got)
+	}
+}
+
+func TestPerm_Chown(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("chown needs root")
+	}
+	dir := filepath.Join(t.TempDir(), "dlq")
+	p := Perm{Chown: true, UID: 4321, GID: 4322}
+	if err := p.MkdirAll(dir); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	f, err := p.Create(filepath.Join(dir, "dlq.jsonl"))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	f.Close()
+	for _, path := range []string{dir, f.Name()} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		st := info.Sys().(*syscall.Stat_t)
+		if st.Uid != 4321 || st.Gid != 4322 {
+			t.Errorf("%s owned by %d:%d, want 4321:4322", path, st.Uid, st.Gid)
+		}
+	}
+}
+
+func mode(t *testing.T, path string) os.FileMode {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Mode().Perm()
+}
+
+func TestPerm_WriteFileReplacesPlantedLink(t *testing.T) {
+	dir := t.TempDir()
+	victim := filepath.Join(t.TempDir(), "victim")
+	if err := os.WriteFile(victim, []byte("keep\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "manifest.json")
+	if err := os.Symlink(victim, path+".tmp"); err != nil {
+		t.Fatal(err)
+	}
+	// Without Base the link is replaced; under Base it is refused, as any
+	// path through a link is.
+	if err := (Perm{}).WriteFile(path, []byte("new\n")); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "new\n" {
+		t.Errorf("%s = %q, %v", path, data, err)
+	}
+	if data, _ := os.ReadFile(victim); string(data) != "keep\n" {
+		t.Fatalf("the link's target was written: %q", data)
+	}
+	if err := os.Symlink(victim, path+".tmp"); err != nil {
+		t.Fatal(err)
+	}
+	if err := (Perm{Base: dir}).WriteFile(path, []byte("again\n")); !errors.Is(err, ErrSymlink) {
+		t.Errorf("WriteFile under Base = %v, want ErrSymlink", err)
+	}
+	if data, _ := os.ReadFile(victim); string(data) != "keep\n" {
+		t.Errorf("the link's target was written: %q", data)
+	}
+	// Under Base the target path is checked as well.
+	if err := (Perm{Base: dir}).WriteFile(filepath.Join(t.TempDir(), "x"), nil); !errors.Is(err, ErrOutsideBase) {
+		t.Errorf("WriteFile outside Base = %v, want ErrOutsideBase", err)
+	}
+}
@@ -6,12 +6,16 @@ import (
 	"os"
 )
 
-var defaultLogger *slog.Logger
+var (
+	defaultLogger *slog.Logger
+	// level is shared by every handler so SetLevel works regardless of format.
+	level = new(slog.LevelVar)
+)
 
 func init() {
 	// Default to JSON handler for structured logs
 	defaultLogger = slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{
-		Level: slog.LevelInfo,
+		Level: level,
 	}))
 }
 
@@ -23,15 +27,13 @@ func SetLogger(l *slog.Logger) {
 // SetTextLogger configures the logger to use text output instead of JSON.
 func SetTextLogger() {
 	defaultLogger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
-		Level: slog.LevelInfo,
+		Level: level,
 	}))
 }
 
 // SetLevel sets the log level.
-func SetLevel(level slog.Level) {
-	defaultLogger = slog.New(defaultLogger.Handler().WithOptions(slog.HandlerOptions{
-		Level: level,
-	}))
+func SetLevel(l slog.Level) {
+	level.Set(l)
 }
 
 // Logger returns the default logger.
@@ -44,12 +46,12 @@ func WithContext(ctx context.Context) *slog.Logger {
 	if ctx == nil {
 		return defaultLogger
 	}
-	
+
 	// Extract trace ID from context if available
 	if traceID := ctx.Value("trace_id"); traceID != nil {
 		return defaultLogger.With("trace_id", traceID)
 	}
-	
+
 	return defaultLogger
 }
 
@@ -92,4 +94,3 @@ func Debug(msg string, args ...any) {
 func DebugContext(ctx context.Context, msg string, args ...any) {
 	WithContext(ctx).Debug(msg, args...)
 }
-
@@ -1,13 +1,180 @@
 package model
 
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Normalized is a log record in the pipeline's common shape. It encodes
+// with the output names in FieldNames (output_schema v1); see Legacy for
+// the names used before.
 type Normalized struct {
-	TS        string
-	Level     string
-	Service   string
-	Namespace string
-	Pod       string
-	Node      string
-	Message   string
-	TraceID   string
-	Fields    map[string]any
+	TS        string `json:"ts"`
+	Level     string `json:"level"`
+	Service   string `json:"service"`
+	Namespace string `json:"namespace,omitempty"`
+	Pod       string `json:"pod,omitempty"`
+	Node      string `json:"node,omitempty"`
+	Message   string `json:"message"`
+	TraceID   string `json:"trace_id,omitempty"`
+	// Error, Stacktrace, and Caller are promoted from the common error
+	// keys of structured loggers; they are omitted from output when empty.
+	Error      string         `json:"error,omitempty"`
+	Stacktrace string         `json:"stacktrace,omitempty"`
+	Caller     string         `json:"caller,omitempty"`
+	Fields     map[string]any `json:"fields,omitempty"`
+	// Time is TS as parsed by normalization, for stages that need the
+	// event time without parsing TS again; read it with EventTime. It is
+	// not encoded, so records decoded from JSON have the zero Time. Code
+	// that changes TS must set Time to match.
+	Time time.Time `json:"-"`
+}
+
+// EventTime returns the record's event time: Time when normalization set
+// it, else TS parsed as RFC3339Nano, as for records decoded from a plugin
+// reply or a DLQ entry. ok is false when TS does not parse either.
+func (n Normalized) EventTime() (t time.Time, ok bool) {
+	if !n.Time.IsZero() {
+		return n.Time, true
+	}
+	t, err := time.Parse(time.RFC3339Nano, n.TS)
+	return t, err == nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler. It accepts both the v1 names
+// and the legacy ones, so DLQ files and plugin replies written in either
+// schema decode alike.
+func (n *Normalized) UnmarshalJSON(data []byte) error {
+	type plain Normalized
+	var v struct {
+		plain
+		// Every other legacy name matches its v1 name ignoring case.
+		LegacyTraceID string `json:"TraceID"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*n = Normalized(v.plain)
+	if n.TraceID == "" {
+		n.TraceID = v.LegacyTraceID
+	}
+	return nil
+}
+
+// Legacy is a Normalized record that encodes with the Go field names (TS,
+// Level, ..., TraceID, Fields) the output had before the json tags were
+// added (output_schema legacy). Every key but Error, Stacktrace, and Caller
+// is always present.
+type Legacy Normalized
+
+// MarshalJSON implements json.Marshaler.
+func (l Legacy) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		TS, Level, Service, Namespace, Pod, Node, Message, TraceID string
+		Error                                                      string `json:",omitempty"`
+		Stacktrace                                                 string `json:",omitempty"`
+		Caller                                                     string `json:",omitempty"`
+		Fields                                                     map[string]any
+	}{l.TS, l.Level, l.Service, l.Namespace, l.Pod, l.Node, l.Message, l.TraceID, l.Error, l.Stacktrace, l.Caller, l.Fields})
+}
+
+// FieldNames are the output names of the normalized values, as accepted by
+// Field.
+var FieldNames = []string{"ts", "level", "service", "namespace", "pod", "node", "message", "trace_id", "error", "stacktrace", "caller"}
+
+// Field looks up a record value by its output name (one of FieldNames),
+// falling back to Fields. Empty normalized values and nil Fields values are
+// reported as missing.
+func (n Normalized) Field(name string) (any, bool) {
+	switch name {
+	case "ts":
+		return n.TS, n.TS != ""
+	case "level":
+		return n.Level, n.Level != ""
+	case "service":
+		return n.Service, n.Service != ""
+	case "namespace":
+		return n.Namespace, n.Namespace != ""
+	case "pod":
+		return n.Pod, n.Pod != ""
+	case "node":
+		return n.Node, n.Node != ""
+	case "message":
+		return n.Message, n.Message != ""
+	case "trace_id":
+		return n.TraceID, n.TraceID != ""
+	case "error":
+		return n.Error, n.Error != ""
+	case "stacktrace":
+		return n.Stacktrace, n.Stacktrace != ""
+	case "caller":
+		return n.Caller, n.Caller != ""
+	}
+	v, ok := n.Fields[name]
+	return v, ok && v != nil
+}
+
+// AsFloat converts a decoded JSON value (number or numeric string) to float64.
+func AsFloat(v any) (float64, bool) {
+	switch x := v.(type) {
+	case float64:
+		return x, true
+	case int:
+		return float64(x), true
+	case int64:
+		return float64(x), true
+	case json.Number:
+		f, err := x.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(x), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// Overheads in bytes used by ApproxSize, rounded up from what the Go
+// runtime spends on a Normalized, a map entry, and a boxed value.
+const (
+	recordOverhead = 256
+	entryOverhead  = 64
+	valueOverhead  = 16
+)
+
+// ApproxSize estimates the memory n holds: the lengths of its strings plus
+// a fixed overhead per record, map entry, and value. It is cheap enough to
+// compute for every record and meant for budgets, not exact accounting.
+func (n Normalized) ApproxSize() int64 {
+	size := recordOverhead + len(n.TS) + len(n.Level) + len(n.Service) + len(n.Namespace) +
+		len(n.Pod) + len(n.Node) + len(n.Message) + len(n.TraceID) +
+		len(n.Error) + len(n.Stacktrace) + len(n.Caller)
+	return int64(size) + approxMapSize(n.Fields)
+}
+
+func approxMapSize(m map[string]any) int64 {
+	var size int64
+	for k, v := range m {
+		size += entryOverhead + int64(len(k)) + approxValueSize(v)
+	}
+	return size
+}
+
+func approxValueSize(v any) int64 {
+	switch x := v.(type) {
+	case string:
+		return valueOverhead + int64(len(x))
+	case json.Number:
+		return valueOverhead + int64(len(x))
+	case map[string]any:
+		return valueOverhead + approxMapSize(x)
+	case []any:
+		size := int64(valueOverhead)
+		for _, e := range x {
+			size += approxValueSize(e)
+		}
+		return size
+	}
+	return valueOverhead
 }
@@ -0,0 +1,355 @@
+// Package natsconn speaks the NATS client protocol for the nats output
+// type and the nats input: the INFO, TLS, and CONNECT handshake with a
+// server, publishing, and reading the messages it delivers. It holds no
+// state beyond one connection; reconnecting is up to its callers.
+package natsconn
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/tls"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s-log-etl/internal/logger"
+)
+
+// DialTimeout bounds connecting to a server, TLS and the CONNECT
+// handshake included.
+const DialTimeout = 10 * time.Second
+
+// ErrAuthorization is wrapped by Dial's error when the server refused the
+// credentials.
+var ErrAuthorization = errors.New("nats authorization failed")
+
+// Auth is how a connection authenticates. User and password given in a
+// server URL take precedence over User and Password.
+type Auth struct {
+	User     string
+	Password string
+	Token    string
+	// JWT and Seed come from a creds file; see ReadCreds.
+	JWT  string
+	Seed ed25519.PrivateKey
+}
+
+// Conn is a connection to one server. Writes may come from several
+// goroutines; ReadMsg must be called from one at a time.
+type Conn struct {
+	conn       net.Conn
+	Headers    bool // the server takes headers, HPUB
+	MaxPayload int
+
+	r     *bufio.Reader
+	pongs chan struct{}
+
+	mu sync.Mutex // guards w
+	w  *bufio.Writer
+}
+
+// Msg is a message the server delivered on a subscription.
+type Msg struct {
+	Subject string
+	Reply   string
+	Header  []byte // the raw header block of an HMSG, starting "NATS/1.0"
+	Data    []byte
+}
+
+// Status returns the status of a message's header, such as "404 No
+// Messages", or "" when it has none.
+func (m Msg) Status() string {
+	line, _, _ := strings.Cut(string(m.Header), "\r\n")
+	return strings.TrimSpace(strings.TrimPrefix(line, "NATS/1.0"))
+}
+
+// info is the part of a server's INFO a client uses.
+type info struct {
+	Headers     bool   `json:"headers"`
+	MaxPayload  int    `json:"max_payload"`
+	TLSRequired bool   `json:"tls_required"`
+	Nonce       string `json:"nonce"`
+}
+
+// Servers splits a comma-separated list of server URLs, adding the
+// nats:// scheme to a bare host:port.
+func Servers(list string) []string {
+	var servers []string
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		if !strings.Contains(s, "://") {
+			s = "nats://" + s
+		}
+		servers = append(servers, s)
+	}
+	return servers
+}
+
+// Dial connects to server, nats://host:port or tls://host:port: it reads
+// the INFO, upgrades to TLS when asked, and authenticates with CONNECT,
+// waiting for the server's PONG to be sure it accepted.
+func Dial(server string, auth Auth) (*Conn, error) {
+	u, err := url.Parse(server)
+	if err != nil || u.Host == "" || (u.Scheme != "nats" && u.Scheme != "tls") {
+		return nil, fmt.Errorf("invalid nats server URL %q", server)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	conn, err := net.DialTimeout("tcp", host, DialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("nats %s: %v", u.Host, err)
+	}
+	conn.SetDeadline(time.Now().Add(DialTimeout))
+	fail := func(format string, args ...any) (*Conn, error) {
+		conn.Close()
+		return nil, fmt.Errorf("nats %s: "+format, append([]any{u.Host}, args...)...)
+	}
+
+	br := bufio.NewReader(conn)
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return fail("reading INFO: %v", err)
+	}
+	var in info
+	if op, arg, _ := strings.Cut(strings.TrimSpace(line), " "); op != "INFO" || json.Unmarshal([]byte(arg), &in) != nil {
+		return fail("expected INFO, got %q", strings.TrimSpace(line))
+	}
+	if u.Scheme == "tls" || in.TLSRequired {
+		tc := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		if err := tc.Handshake(); err != nil {
+			return fail("tls: %v", err)
+		}
+		conn, br = tc, bufio.NewReader(tc)
+	}
+
+	connect := map[string]any{
+		"verbose": false, "pedantic": false, "lang": "go", "version": "etl", "protocol": 1, "name": "etl",
+		"headers": in.Headers, "no_responders": in.Headers,
+	}
+	user, pass := auth.User, auth.Password
+	if u.User != nil {
+		user = u.User.Username()
+		pass, _ = u.User.Password()
+	}
+	if user != "" {
+		connect["user"], connect["pass"] = user, pass
+	}
+	if auth.Token != "" {
+		connect["auth_token"] = auth.Token
+	}
+	if auth.JWT != "" {
+		connect["jwt"] = auth.JWT
+		connect["sig"] = base64.RawURLEncoding.EncodeToString(ed25519.Sign(auth.Seed, []byte(in.Nonce)))
+	}
+	hello, _ := json.Marshal(connect)
+	w := bufio.NewWriter(conn)
+	fmt.Fprintf(w, "CONNECT %s\r\nPING\r\n", hello)
+	if err := w.Flush(); err != nil {
+		return fail("%v", err)
+	}
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return fail("reading the CONNECT reply: %v", err)
+		}
+		line = strings.TrimSpace(line)
+		if line == "PONG" {
+			break
+		}
+		if strings.HasPrefix(line, "-ERR") {
+			if msg := strings.ToLower(line); strings.Contains(msg, "authorization") || strings.Contains(msg, "authentication") {
+				c, err := fail("%s", line)
+				return c, fmt.Errorf("%w: %w", ErrAuthorization, err)
+			}
+			return fail("%s", line)
+		}
+	}
+	conn.SetDeadline(time.Time{})
+	return &Conn{conn: conn, Headers: in.Headers, MaxPayload: in.MaxPayload, r: br, w: w, pongs: make(chan struct{}, 1)}, nil
+}
+
+// Close closes the connection, without flushing what is buffered.
+func (c *Conn) Close() error { return c.conn.Close() }
+
+// send writes what write buffers and flushes it, holding the write lock.
+func (c *Conn) send(write func(w *bufio.Writer)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	write(c.w)
+	return c.w.Flush()
+}
+
+// Subscribe subscribes to subject, which may hold wildcards, with the
+// subscription ID sid.
+func (c *Conn) Subscribe(subject string, sid int) error {
+	return c.send(func(w *bufio.Writer) { fmt.Fprintf(w, "SUB %s %d\r\n", subject, sid) })
+}
+
+// Publish sends data on subject. reply, when set, is where the receiver
+// answers. msgID, when set and the server takes headers, goes in a
+// Nats-Msg-Id header, which JetStream uses to store a message once.
+func (c *Conn) Publish(subject, reply, msgID string, data []byte) error {
+	return c.send(func(w *bufio.Writer) {
+		if reply != "" {
+			reply += " "
+		}
+		if msgID != "" && c.Headers {
+			hdr := "NATS/1.0\r\nNats-Msg-Id: " + msgID + "\r\n\r\n"
+			fmt.Fprintf(w, "HPUB %s %s%d %d\r\n%s", subject, reply, len(hdr), len(hdr)+len(data), hdr)
+		} else {
+			fmt.Fprintf(w, "PUB %s %s%d\r\n", subject, reply, len(data))
+		}
+		w.Write(data)
+		w.WriteString("\r\n")
+	})
+}
+
+// Ping asks the server for a PONG and waits up to timeout for it, which
+// tells that it has processed everything sent before. It relies on a
+// goroutine calling ReadMsg to receive the PONG.
+func (c *Conn) Ping(timeout time.Duration) error {
+	// Drop a PONG left over from an earlier Ping that timed out.
+	select {
+	case <-c.pongs:
+	default:
+	}
+	if err := c.send(func(w *bufio.Writer) { w.WriteString("PING\r\n") }); err != nil {
+		return err
+	}
+	select {
+	case <-c.pongs:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("no PONG from the nats server within %s", timeout)
+	}
+}
+
+// ReadMsg reads what the server sends until the next message: it answers
+// PINGs, hands PONGs to Ping, and logs server errors. Any error means the
+// connection is no longer usable.
+func (c *Conn) ReadMsg() (Msg, error) {
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return Msg{}, err
+		}
+		op, args, _ := strings.Cut(strings.TrimSpace(line), " ")
+		switch strings.ToUpper(op) {
+		case "PING":
+			if err := c.send(func(w *bufio.Writer) { w.WriteString("PONG\r\n") }); err != nil {
+				return Msg{}, err
+			}
+		case "PONG":
+			select {
+			case c.pongs <- struct{}{}:
+			default:
+			}
+		case "-ERR":
+			logger.Warn("nats server error", "error", args)
+		case "MSG", "HMSG":
+			// MSG <subject> <sid> [reply] <size>; HMSG adds the header size
+			// before the total.
+			f := strings.Fields(args)
+			hmsg := strings.EqualFold(op, "HMSG")
+			want := 3
+			if hmsg {
+				want = 4
+			}
+			if len(f) < want || len(f) > want+1 {
+				return Msg{}, fmt.Errorf("bad %s line %q", op, strings.TrimSpace(line))
+			}
+			total, err1 := strconv.Atoi(f[len(f)-1])
+			hdrLen := 0
+			var err2 error
+			if hmsg {
+				hdrLen, err2 = strconv.Atoi(f[len(f)-2])
+			}
+			if err1 != nil || err2 != nil || hdrLen < 0 || hdrLen > total {
+				return Msg{}, fmt.Errorf("bad %s line %q", op, strings.TrimSpace(line))
+			}
+			buf := make([]byte, total+2)
+			if _, err := io.ReadFull(c.r, buf); err != nil {
+				return Msg{}, err
+			}
+			m := Msg{Subject: f[0], Header: buf[:hdrLen], Data: buf[hdrLen:total]}
+			if len(f) == want+1 {
+				m.Reply = f[2]
+			}
+			return m, nil
+		}
+	}
+}
+
+// ReadCreds reads the user JWT and NKey seed from a creds file, as nsc
+// writes them.
+func ReadCreds(path string) (string, ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	block := func(name string) string {
+		_, rest, ok := strings.Cut(string(data), "-----BEGIN "+name+"-----")
+		if !ok {
+			return ""
+		}
+		body, _, _ := strings.Cut(rest, "------END "+name+"------")
+		return strings.TrimSpace(body)
+	}
+	jwt, seed := block("NATS USER JWT"), block("USER NKEY SEED")
+	if jwt == "" || seed == "" {
+		return "", nil, errors.New("no user JWT and NKey seed found")
+	}
+	key, err := decodeNKeySeed(seed)
+	if err != nil {
+		return "", nil, err
+	}
+	return jwt, key, nil
+}
+
+// decodeNKeySeed decodes an NKey seed ("SU..."): base32 holding two
+// prefix bytes, the 32-byte ed25519 seed, and a CRC-16 of the rest.
+func decodeNKeySeed(seed string) (ed25519.PrivateKey, error) {
+	raw, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(seed)
+	if err != nil || len(raw) != 2+ed25519.SeedSize+2 {
+		return nil, errors.New("malformed NKey seed")
+	}
+	body := raw[:len(raw)-2]
+	if crc16(body) != binary.LittleEndian.Uint16(raw[len(raw)-2:]) {
+		return nil, errors.New("NKey seed checksum mismatch")
+	}
+	if body[0]&0xf8 != 18<<3 { // 'S'
+		return nil, errors.New("not an NKey seed")
+	}
+	return ed25519.NewKeyFromSeed(body[2:]), nil
+}
+
+// crc16 is the CRC-16/XMODEM checksum NKeys use.
+func crc16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for range 8 {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,27 @@
+package plugins
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrTransformTimeout indicates a transform did not finish a record within its timeout.
+	ErrTransformTimeout = errors.New("transform timeout")
+	// ErrTransformPanic indicates a transform panicked on a record.
+	ErrTransformPanic = errors.New("transform panic")
+)
+
+// PanicError is the error a transform returns in place of a panic. It
+// matches ErrTransformPanic with errors.Is.
+type PanicError struct {
+	Value any
+	// Stack is the panicking goroutine's stack, from runtime/debug.Stack.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrTransformPanic, e.Value)
+}
+
+func (e *PanicError) Unwrap() error { return ErrTransformPanic }
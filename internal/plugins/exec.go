@@ -0,0 +1,215 @@
+package plugins
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/logger"
+	"k8s-log-etl/internal/model"
+)
+
+// guestResponse is the decision an external transform (exec child or WASM
+// guest) returns for each record. A missing record leaves the input
+// unchanged; a non-empty error fails the record.
+type guestResponse struct {
+	Record *model.Normalized `json:"record,omitempty"`
+	Drop   bool              `json:"drop"`
+	Reason string            `json:"reason,omitempty"`
+	Error  string            `json:"error,omitempty"`
+}
+
+// execTransform pipes records through a long-lived child process: one JSON
+// line in on stdin, one JSON response line out on stdout. The child is
+// started lazily, restarted after it crashes or times out, and stopped on
+// Close.
+type execTransform struct {
+	command []string
+	timeout time.Duration
+
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	closed bool
+
+	started  int
+	errors   int
+	timeouts int
+	restarts int
+}
+
+type execResult struct {
+	line []byte
+	err  error
+}
+
+// newExecTransform validates the command and returns an unstarted transform.
+func newExecTransform(cfg config.Config) (Transform, io.Closer, error) {
+	if len(cfg.ExecCommand) == 0 {
+		return nil, nil, errors.New("exec_command is required for the exec transform")
+	}
+	timeout := time.Duration(cfg.ExecTimeoutMS) * time.Millisecond
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	et := &execTransform{command: cfg.ExecCommand, timeout: timeout}
+	return et.apply, et, nil
+}
+
+func (et *execTransform) apply(n model.Normalized) (model.Normalized, bool, string, error) {
+	et.mu.Lock()
+	defer et.mu.Unlock()
+
+	if et.closed {
+		return n, false, "", errors.New("exec transform closed")
+	}
+	if et.cmd == nil {
+		if err := et.start(); err != nil {
+			et.errors++
+			return n, false, "", err
+		}
+	}
+
+	data, err := json.Marshal(model.Legacy(n)) // guests always get the legacy names
+	if err != nil {
+		et.errors++
+		return n, false, "", fmt.Errorf("exec transform: marshal record: %w", err)
+	}
+	data = append(data, '\n')
+
+	// Write and read in the background so a child that stops reading or
+	// stops answering cannot stall the pipeline past the timeout.
+	stdin, stdout := et.stdin, et.stdout
+	done := make(chan execResult, 1)
+	go func() {
+		if _, err := stdin.Write(data); err != nil {
+			done <- execResult{err: err}
+			return
+		}
+		line, err := stdout.ReadBytes('\n')
+		done <- execResult{line: line, err: err}
+	}()
+
+	timer := time.NewTimer(et.timeout)
+	defer timer.Stop()
+	var res execResult
+	select {
+	case res = <-done:
+	case <-timer.C:
+		et.timeouts++
+		et.errors++
+		et.stop(true)
+		return n, false, "", fmt.Errorf("exec transform: %w after %v", ErrTransformTimeout, et.timeout)
+	}
+	if res.err != nil {
+		// The child exited or closed its pipes; restart it on the next record.
+		et.errors++
+		et.stop(true)
+		return n, false, "", fmt.Errorf("exec transform: child failed: %w", res.err)
+	}
+
+	var resp guestResponse
+	if err := json.Unmarshal(res.line, &resp); err != nil {
+		et.errors++
+		return n, false, "", fmt.Errorf("exec transform: invalid response: %w", err)
+	}
+	if resp.Error != "" {
+		et.errors++
+		return n, false, "", fmt.Errorf("exec transform: %s", resp.Error)
+	}
+	out := n
+	if resp.Record != nil {
+		out = *resp.Record
+	}
+	if resp.Drop {
+		reason := resp.Reason
+		if reason == "" {
+			reason = "exec"
+		}
+		return out, true, reason, nil
+	}
+	return out, false, "", nil
+}
+
+// start launches the child process. Callers hold et.mu.
+func (et *execTransform) start() error {
+	cmd := exec.Command(et.command[0], et.command[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("exec transform: stdin pipe: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("exec transform: stdout pipe: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("exec transform: start %s: %w", et.command[0], err)
+	}
+	if et.started > 0 {
+		et.restarts++
+		logger.Warn("exec transform restarted", "command", et.command[0], "restarts", et.restarts)
+	}
+	et.started++
+	et.cmd = cmd
+	et.stdin = stdin
+	et.stdout = bufio.NewReader(stdout)
+	return nil
+}
+
+// stop shuts the child down: closing stdin asks it to exit, kill forces it.
+// Callers hold et.mu.
+func (et *execTransform) stop(kill bool) error {
+	if et.cmd == nil {
+		return nil
+	}
+	cmd := et.cmd
+	et.stdin.Close()
+	et.cmd, et.stdin, et.stdout = nil, nil, nil
+
+	if kill {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil
+	}
+	waited := make(chan error, 1)
+	go func() { waited <- cmd.Wait() }()
+	select {
+	case err := <-waited:
+		return err
+	case <-time.After(et.timeout):
+		cmd.Process.Kill()
+		<-waited
+		return fmt.Errorf("exec transform: child did not exit within %v, killed", et.timeout)
+	}
+}
+
+// Close stops the child, giving it up to the timeout to exit after stdin is
+// closed, and logs the error counters.
+func (et *execTransform) Close() error {
+	et.mu.Lock()
+	defer et.mu.Unlock()
+	if et.closed {
+		return nil
+	}
+	et.closed = true
+	err := et.stop(false)
+	logger.Info("exec transform stopped", "command", et.command[0], "errors", et.errors, "timeouts", et.timeouts, "restarts", et.restarts)
+	return err
+}
+
+func init() {
+	RegisterFactory(Info{
+		Name:        "exec",
+		Description: "pipe each record as a JSON line through a long-running child process and read the result back",
+		ConfigKeys:  []string{"exec_command", "exec_timeout_ms"},
+	}, newExecTransform)
+}
@@ -0,0 +1,133 @@
+package plugins
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/model"
+)
+
+// TestHelperProcess is the child process used by the exec transform tests.
+// It is a no-op unless invoked by helperConfig.
+func TestHelperProcess(t *testing.T) {
+	mode := os.Getenv("ETL_EXEC_HELPER")
+	if mode == "" {
+		return
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var n model.Normalized
+		if err := json.Unmarshal(scanner.Bytes(), &n); err != nil {
+			fmt.Printf(`{"error":%q}`+"\n", err.Error())
+			continue
+		}
+		switch mode {
+		case "upper":
+			n.Message = strings.ToUpper(n.Message)
+			out, _ := json.Marshal(guestResponse{Record: &n})
+			fmt.Println(string(out))
+		case "drop":
+			fmt.Println(`{"drop":true,"reason":"masked"}`)
+		case "crash":
+			os.Exit(3)
+		case "hang":
+			time.Sleep(time.Hour)
+		}
+	}
+	os.Exit(0)
+}
+
+func helperConfig(t *testing.T, mode string) config.Config {
+	t.Helper()
+	t.Setenv("ETL_EXEC_HELPER", mode)
+	return config.Config{
+		Transforms:    []string{"exec"},
+		ExecCommand:   []string{os.Args[0], "-test.run=^TestHelperProcess$"},
+		ExecTimeoutMS: 2000,
+	}
+}
+
+func buildExec(t *testing.T, cfg config.Config) Transform {
+	t.Helper()
+	transforms, closer, err := BuildTransforms(cfg)
+	if err != nil {
+		t.Fatalf("BuildTransforms: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := closer.Close(); err != nil {
+			t.Errorf("close: %v", err)
+		}
+	})
+	return transforms[0].Apply
+}
+
+func TestExecTransformRewritesRecord(t *testing.T) {
+	tf := buildExec(t, helperConfig(t, "upper"))
+	for i := 0; i < 3; i++ {
+		out, drop, _, err := tf(model.Normalized{Level: "ERROR", Message: "boom"})
+		if err != nil {
+			t.Fatalf("apply %d: %v", i, err)
+		}
+		if drop || out.Message != "BOOM" {
+			t.Fatalf("expected rewritten record, got drop=%v msg=%q", drop, out.Message)
+		}
+	}
+}
+
+func TestExecTransformDrop(t *testing.T) {
+	tf := buildExec(t, helperConfig(t, "drop"))
+	_, drop, reason, err := tf(model.Normalized{Message: "secret"})
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if !drop || reason != "masked" {
+		t.Fatalf("expected drop with reason masked, got drop=%v reason=%q", drop, reason)
+	}
+}
+
+func TestExecTransformRestartsAfterCrash(t *testing.T) {
+	cfg := helperConfig(t, "crash")
+	transforms, closer, err := BuildTransforms(cfg)
+	if err != nil {
+		t.Fatalf("BuildTransforms: %v", err)
+	}
+	defer closer.Close()
+	et := closer.(closerList)[0].(*execTransform)
+
+	for i := 0; i < 2; i++ {
+		if _, _, _, err := transforms[0].Apply(model.Normalized{Message: "x"}); err == nil {
+			t.Fatalf("expected error from crashing child")
+		}
+	}
+	if et.restarts != 1 {
+		t.Fatalf("expected 1 restart, got %d", et.restarts)
+	}
+}
+
+func TestExecTransformTimeout(t *testing.T) {
+	cfg := helperConfig(t, "hang")
+	cfg.ExecTimeoutMS = 50
+	tf := buildExec(t, cfg)
+
+	start := time.Now()
+	_, _, _, err := tf(model.Normalized{Message: "x"})
+	if err == nil || !errors.Is(err, ErrTransformTimeout) {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("timeout not enforced, took %v", time.Since(start))
+	}
+}
+
+func TestExecTransformRequiresCommand(t *testing.T) {
+	if _, _, err := BuildTransforms(config.Config{Transforms: []string{"exec"}}); err == nil {
+		t.Fatal("expected error without exec_command")
+	}
+}
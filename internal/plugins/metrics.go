@@ -0,0 +1,280 @@
+package plugins
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/model"
+	"k8s-log-etl/internal/report"
+)
+
+const (
+	metricsDefaultMaxSeries = 100
+	metricsPrefix           = "etl_extracted_"
+)
+
+var (
+	metricNameRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	// Default histogram buckets suit millisecond latencies.
+	metricsDefaultBuckets = []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+)
+
+// metricRule is one entry in the metrics_rules file.
+//
+// A rule matches a record when its regex (if any) matches the message and
+// its field (if any) is present. The value comes from field, else from the
+// regex's "value" named group; counters without either count matches.
+// Labels are read from named regex groups first, then from normalized
+// fields (level, service, namespace, pod, node, trace_id) or Fields keys.
+type metricRule struct {
+	Name      string    `json:"name"`
+	Type      string    `json:"type"` // counter | histogram
+	Regex     string    `json:"regex,omitempty"`
+	Field     string    `json:"field,omitempty"`
+	Labels    []string  `json:"labels,omitempty"`
+	Buckets   []float64 `json:"buckets,omitempty"`
+	MaxSeries int       `json:"max_series,omitempty"`
+}
+
+type compiledRule struct {
+	metricRule
+	re       *regexp.Regexp
+	valueIdx int   // regex group holding the value, -1 if none
+	labelIdx []int // regex group per label, -1 to read from the record
+
+	series        map[string]*metricSeries
+	badValues     int
+	droppedSeries int
+}
+
+type metricSeries struct {
+	labels  []string
+	value   float64 // counter total or histogram sum
+	count   int     // histogram observations
+	buckets []int   // histogram counts per bucket, not cumulative
+}
+
+// metricsTransform accumulates metrics from records during the run and
+// exports them through report.Collector. Records pass through unchanged.
+type metricsTransform struct {
+	mu    sync.Mutex
+	rules []*compiledRule
+}
+
+func newMetricsTransform(cfg config.Config) (Transform, io.Closer, error) {
+	if cfg.MetricsRules == "" {
+		return nil, nil, errors.New("metrics_rules is required for the metrics_extract transform")
+	}
+	data, err := os.ReadFile(cfg.MetricsRules)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read metrics rules: %w", err)
+	}
+	var rules []metricRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, nil, fmt.Errorf("parse metrics rules: %w", err)
+	}
+	mt := &metricsTransform{}
+	seen := make(map[string]bool)
+	for i, rule := range rules {
+		cr, err := compileRule(rule)
+		if err != nil {
+			return nil, nil, fmt.Errorf("metrics rule %d: %w", i, err)
+		}
+		if seen[cr.Name] {
+			return nil, nil, fmt.Errorf("metrics rule %d: duplicate name %q", i, cr.Name)
+		}
+		seen[cr.Name] = true
+		mt.rules = append(mt.rules, cr)
+	}
+	return mt.apply, mt, nil
+}
+
+func compileRule(rule metricRule) (*compiledRule, error) {
+	if !metricNameRe.MatchString(rule.Name) {
+		return nil, fmt.Errorf("invalid metric name %q", rule.Name)
+	}
+	rule.Type = strings.ToLower(rule.Type)
+	if rule.Type != "counter" && rule.Type != "histogram" {
+		return nil, fmt.Errorf("%s: invalid type %q: must be counter or histogram", rule.Name, rule.Type)
+	}
+	cr := &compiledRule{metricRule: rule, valueIdx: -1, series: make(map[string]*metricSeries)}
+	if rule.Regex != "" {
+		re, err := regexp.Compile(rule.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", rule.Name, err)
+		}
+		cr.re = re
+		cr.valueIdx = re.SubexpIndex("value")
+	}
+	if rule.Type == "histogram" && rule.Field == "" && cr.valueIdx < 0 {
+		return nil, fmt.Errorf("%s: histogram needs a field or a regex with a (?P<value>...) group", rule.Name)
+	}
+	for _, label := range rule.Labels {
+		if !metricNameRe.MatchString(label) || label == "le" {
+			return nil, fmt.Errorf("%s: invalid label name %q", rule.Name, label)
+		}
+		idx := -1
+		if cr.re != nil {
+			idx = cr.re.SubexpIndex(label)
+		}
+		cr.labelIdx = append(cr.labelIdx, idx)
+	}
+	if rule.Type == "histogram" {
+		if len(cr.Buckets) == 0 {
+			cr.Buckets = metricsDefaultBuckets
+		}
+		if !sort.Float64sAreSorted(cr.Buckets) {
+			return nil, fmt.Errorf("%s: buckets must be in ascending order", rule.Name)
+		}
+	}
+	if cr.MaxSeries <= 0 {
+		cr.MaxSeries = metricsDefaultMaxSeries
+	}
+	return cr, nil
+}
+
+func (mt *metricsTransform) apply(n model.Normalized) (model.Normalized, bool, string, error) {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	for _, r := range mt.rules {
+		r.observe(n)
+	}
+	return n, false, "", nil
+}
+
+// observe updates the rule from one record. Callers hold mt.mu.
+func (r *compiledRule) observe(n model.Normalized) {
+	var groups []string
+	if r.re != nil {
+		if groups = r.re.FindStringSubmatch(n.Message); groups == nil {
+			return
+		}
+	}
+
+	value := 1.0
+	switch {
+	case r.Field != "":
+		raw, ok := n.Field(r.Field)
+		if !ok {
+			return
+		}
+		v, ok := model.AsFloat(raw)
+		if !ok {
+			r.badValues++
+			return
+		}
+		value = v
+	case r.valueIdx >= 0:
+		v, err := strconv.ParseFloat(groups[r.valueIdx], 64)
+		if err != nil {
+			r.badValues++
+			return
+		}
+		value = v
+	}
+	if r.Type == "counter" && value < 0 {
+		r.badValues++
+		return
+	}
+
+	labels := make([]string, len(r.Labels))
+	for i, label := range r.Labels {
+		if idx := r.labelIdx[i]; idx >= 0 {
+			labels[i] = groups[idx]
+		} else if v, ok := n.Field(label); ok {
+			labels[i] = fmt.Sprint(v)
+		}
+	}
+	key := strings.Join(labels, "\xff")
+	s, ok := r.series[key]
+	if !ok {
+		if len(r.series) >= r.MaxSeries {
+			r.droppedSeries++
+			return
+		}
+		s = &metricSeries{labels: labels}
+		if r.Type == "histogram" {
+			s.buckets = make([]int, len(r.Buckets))
+		}
+		r.series[key] = s
+	}
+
+	s.value += value
+	if r.Type == "histogram" {
+		s.count++
+		if i := sort.SearchFloat64s(r.Buckets, value); i < len(s.buckets) {
+			s.buckets[i]++
+		}
+	}
+}
+
+// WritePrometheus renders every rule's series in rule order, with series
+// sorted by label values.
+func (mt *metricsTransform) WritePrometheus(w io.Writer) {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	for _, r := range mt.rules {
+		name := metricsPrefix + r.Name
+		report.WriteFamily(w, name, r.Type, "Extracted by metrics_extract rule "+r.Name+".")
+		keys := make([]string, 0, len(r.series))
+		for k := range r.series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			s := r.series[k]
+			if r.Type == "counter" {
+				report.WriteSample(w, name, s.value, labelPairs(r.Labels, s.labels)...)
+				continue
+			}
+			cumulative := 0
+			for i, b := range r.Buckets {
+				cumulative += s.buckets[i]
+				report.WriteSample(w, name+"_bucket", float64(cumulative), labelPairs(r.Labels, s.labels, "le", report.FormatValue(b))...)
+			}
+			report.WriteSample(w, name+"_bucket", float64(s.count), labelPairs(r.Labels, s.labels, "le", "+Inf")...)
+			report.WriteSample(w, name+"_sum", s.value, labelPairs(r.Labels, s.labels)...)
+			report.WriteSample(w, name+"_count", float64(s.count), labelPairs(r.Labels, s.labels)...)
+		}
+	}
+	report.WriteFamily(w, "etl_extracted_bad_values_total", report.Counter, "Matched records whose value was missing a number or negative for a counter.")
+	for _, r := range mt.rules {
+		report.WriteSample(w, "etl_extracted_bad_values_total", float64(r.badValues), "rule", r.Name)
+	}
+	report.WriteFamily(w, "etl_extracted_series_dropped_total", report.Counter, "Records not counted because their rule reached max_series.")
+	for _, r := range mt.rules {
+		report.WriteSample(w, "etl_extracted_series_dropped_total", float64(r.droppedSeries), "rule", r.Name)
+	}
+}
+
+// labelPairs interleaves label names and values for report.WriteSample,
+// followed by any extra pairs.
+func labelPairs(names, values []string, extra ...string) []string {
+	out := make([]string, 0, 2*len(names)+len(extra))
+	for i, name := range names {
+		out = append(out, name, values[i])
+	}
+	return append(out, extra...)
+}
+
+// Close is a no-op; metricsTransform holds no resources.
+func (mt *metricsTransform) Close() error {
+	return nil
+}
+
+func init() {
+	RegisterFactory(Info{
+		Name:        "metrics_extract",
+		Description: "extract counters and histograms from messages and fields by rule, exported with the Prometheus metrics",
+		ConfigKeys:  []string{"metrics_rules"},
+	}, newMetricsTransform)
+}
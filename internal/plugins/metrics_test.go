@@ -0,0 +1,116 @@
+package plugins
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/model"
+	"k8s-log-etl/internal/report"
+)
+
+func buildMetrics(t *testing.T, rules string) Named {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(path, []byte(rules), 0o644); err != nil {
+		t.Fatalf("write rules: %v", err)
+	}
+	transforms, closer, err := BuildTransforms(config.Config{Transforms: []string{"metrics_extract"}, MetricsRules: path})
+	if err != nil {
+		t.Fatalf("BuildTransforms: %v", err)
+	}
+	t.Cleanup(func() { closer.Close() })
+	return transforms[0]
+}
+
+func prometheus(c report.Collector) string {
+	sb := &strings.Builder{}
+	c.WritePrometheus(sb)
+	return sb.String()
+}
+
+func TestMetricsExtractCounterAndHistogram(t *testing.T) {
+	tf := buildMetrics(t, `[
+		{"name": "http_requests_total", "type": "counter", "regex": "status=(?P<status>\\d+)", "labels": ["status", "service"]},
+		{"name": "http_request_ms", "type": "histogram", "regex": "in (?P<value>\\d+)ms", "buckets": [100, 500]}
+	]`)
+	if tf.Collector == nil {
+		t.Fatal("expected metrics_extract to expose a collector")
+	}
+	for _, msg := range []string{
+		"request completed in 153ms status=500",
+		"request completed in 20ms status=200",
+		"request completed in 900ms status=500",
+		"unrelated message",
+	} {
+		in := model.Normalized{Service: "api", Message: msg}
+		out, drop, _, err := tf.Apply(in)
+		if err != nil || drop || out.Message != msg {
+			t.Fatalf("expected record to pass unchanged, got drop=%v err=%v msg=%q", drop, err, out.Message)
+		}
+	}
+
+	got := prometheus(tf.Collector)
+	for _, want := range []string{
+		`etl_extracted_http_requests_total{status="200",service="api"} 1`,
+		`etl_extracted_http_requests_total{status="500",service="api"} 2`,
+		`etl_extracted_http_request_ms_bucket{le="100"} 1`,
+		`etl_extracted_http_request_ms_bucket{le="500"} 2`,
+		`etl_extracted_http_request_ms_bucket{le="+Inf"} 3`,
+		`etl_extracted_http_request_ms_sum 1073`,
+		`etl_extracted_http_request_ms_count 3`,
+	} {
+		if !strings.Contains(got, want+"\n") {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+}
+
+func TestMetricsExtractBadValuesAndCardinality(t *testing.T) {
+	tf := buildMetrics(t, `[
+		{"name": "latency_ms", "type": "histogram", "field": "latency"},
+		{"name": "by_pod_total", "type": "counter", "labels": ["pod"], "max_series": 1}
+	]`)
+	records := []model.Normalized{
+		{Pod: "a", Fields: map[string]any{"latency": "12.5"}},
+		{Pod: "b", Fields: map[string]any{"latency": "fast"}},
+		{Pod: "a"},
+	}
+	for _, rec := range records {
+		if _, _, _, err := tf.Apply(rec); err != nil {
+			t.Fatalf("bad values must not fail records: %v", err)
+		}
+	}
+
+	got := prometheus(tf.Collector)
+	for _, want := range []string{
+		`etl_extracted_latency_ms_count 1`,
+		`etl_extracted_bad_values_total{rule="latency_ms"} 1`,
+		`etl_extracted_by_pod_total{pod="a"} 2`,
+		`etl_extracted_series_dropped_total{rule="by_pod_total"} 1`,
+	} {
+		if !strings.Contains(got, want+"\n") {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+}
+
+func TestMetricsExtractRejectsInvalidRules(t *testing.T) {
+	for name, rules := range map[string]string{
+		"bad regex":          `[{"name": "x", "type": "counter", "regex": "("}]`,
+		"bad type":           `[{"name": "x", "type": "gauge"}]`,
+		"bad name":           `[{"name": "x-y", "type": "counter"}]`,
+		"histogram no value": `[{"name": "x", "type": "histogram", "regex": "status=(\\d+)"}]`,
+		"duplicate":          `[{"name": "x", "type": "counter"}, {"name": "x", "type": "counter"}]`,
+	} {
+		path := filepath.Join(t.TempDir(), "rules.json")
+		if err := os.WriteFile(path, []byte(rules), 0o644); err != nil {
+			t.Fatalf("write rules: %v", err)
+		}
+		if _, _, err := BuildTransforms(config.Config{Transforms: []string{"metrics_extract"}, MetricsRules: path}); err == nil {
+			t.Errorf("%s: expected build error", name)
+		}
+	}
+}
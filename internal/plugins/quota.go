@@ -0,0 +1,218 @@
+package plugins
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/logger"
+	"k8s-log-etl/internal/model"
+	"k8s-log-etl/internal/report"
+)
+
+// ReasonQuota is the drop reason for records past their namespace's quota.
+const ReasonQuota = "quota"
+
+// quotaTransform caps the records of each namespace, by count or by
+// Normalized.ApproxSize bytes, per run or per interval. Records past the
+// cap are dropped, or with quota_overflow sample, thinned to one in every
+// quota_sample_every.
+type quotaTransform struct {
+	limits   map[string]config.QuotaLimit
+	interval time.Duration
+	overflow string
+	every    int64
+	now      func() time.Time
+	start    time.Time
+
+	mu         sync.RWMutex
+	namespaces map[string]*namespaceQuota
+}
+
+// namespaceQuota is one namespace's quota and its use.
+type namespaceQuota struct {
+	limit config.QuotaLimit
+	// records and bytes count toward the quota in the current period of
+	// namespace_quota_interval, and over counts the records past it.
+	period         atomic.Int64
+	records, bytes atomic.Int64
+	over           atomic.Int64
+
+	passed, passedBytes atomic.Int64
+	dropped, sampled    atomic.Int64
+	caps                atomic.Int64
+	firstCapped         atomic.Int64 // Unix nanoseconds; 0 until capped
+}
+
+func newQuotaTransform(cfg config.Config) (Transform, io.Closer, error) {
+	limits, err := config.NamespaceQuotas(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(limits) == 0 {
+		return nil, nil, errors.New("namespace_quota is required for the namespace_quota transform")
+	}
+	qt := &quotaTransform{
+		limits:     limits,
+		interval:   cfg.NamespaceQuotaIntervalDuration(),
+		overflow:   strings.ToLower(cfg.QuotaOverflow),
+		every:      int64(max(cfg.QuotaSampleEvery, 1)),
+		now:        time.Now,
+		namespaces: make(map[string]*namespaceQuota),
+	}
+	if qt.overflow == "" {
+		qt.overflow = config.QuotaDrop
+	}
+	qt.start = qt.now()
+	for ns, limit := range limits {
+		if ns != "*" {
+			qt.namespaces[ns] = &namespaceQuota{limit: limit}
+		}
+	}
+	return qt.apply, qt, nil
+}
+
+func (qt *quotaTransform) apply(n model.Normalized) (model.Normalized, bool, string, error) {
+	q := qt.quota(n.Namespace)
+	if q == nil {
+		return n, false, "", nil
+	}
+	now := qt.now()
+	if qt.interval > 0 {
+		if period := int64(now.Sub(qt.start) / qt.interval); q.period.Load() != period {
+			q.period.Store(period)
+			q.records.Store(0)
+			q.bytes.Store(0)
+			q.over.Store(0)
+		}
+	}
+	size := n.ApproxSize()
+	records, bytes := q.records.Add(1), q.bytes.Add(size)
+	if (q.limit.Records == 0 || records <= q.limit.Records) && (q.limit.Bytes == 0 || bytes <= q.limit.Bytes) {
+		q.passed.Add(1)
+		q.passedBytes.Add(size)
+		return n, false, "", nil
+	}
+	over := q.over.Add(1)
+	if over == 1 {
+		q.caps.Add(1)
+		q.firstCapped.CompareAndSwap(0, now.UnixNano())
+		logger.Warn("namespace reached its quota", "namespace", n.Namespace,
+			"max_records", q.limit.Records, "max_bytes", q.limit.Bytes, "overflow", qt.overflow)
+	}
+	if qt.overflow == config.QuotaSample && over%qt.every == 0 {
+		q.sampled.Add(1)
+		q.passed.Add(1)
+		q.passedBytes.Add(size)
+		return n, false, "", nil
+	}
+	q.dropped.Add(1)
+	return n, true, ReasonQuota, nil
+}
+
+// quota returns the quota of namespace ns, one of its own or one made from
+// the "*" entry, or nil when it has none.
+func (qt *quotaTransform) quota(ns string) *namespaceQuota {
+	qt.mu.RLock()
+	q := qt.namespaces[ns]
+	qt.mu.RUnlock()
+	if q != nil {
+		return q
+	}
+	limit, ok := qt.limits["*"]
+	if !ok {
+		return nil
+	}
+	qt.mu.Lock()
+	defer qt.mu.Unlock()
+	if q = qt.namespaces[ns]; q == nil {
+		q = &namespaceQuota{limit: limit}
+		qt.namespaces[ns] = q
+	}
+	return q
+}
+
+// stats returns the quotas' use so far, by namespace.
+func (qt *quotaTransform) stats() report.QuotaStats {
+	qt.mu.RLock()
+	defer qt.mu.RUnlock()
+	s := report.QuotaStats{IntervalSeconds: qt.interval.Seconds(), Overflow: qt.overflow}
+	for _, ns := range qt.sortedNamespaces() {
+		q := qt.namespaces[ns]
+		n := report.NamespaceQuotaStats{
+			Namespace:  ns,
+			MaxRecords: q.limit.Records,
+			MaxBytes:   q.limit.Bytes,
+			Records:    q.passed.Load(),
+			Bytes:      q.passedBytes.Load(),
+			Dropped:    q.dropped.Load(),
+			Sampled:    q.sampled.Load(),
+			Caps:       int(q.caps.Load()),
+		}
+		if at := q.firstCapped.Load(); at != 0 {
+			n.FirstCappedAt = time.Unix(0, at).UTC().Format(time.RFC3339Nano)
+		}
+		s.Namespaces = append(s.Namespaces, n)
+	}
+	return s
+}
+
+// sortedNamespaces lists the namespaces with a quota. Callers hold qt.mu.
+func (qt *quotaTransform) sortedNamespaces() []string {
+	names := make([]string, 0, len(qt.namespaces))
+	for ns := range qt.namespaces {
+		names = append(names, ns)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// WritePrometheus exports each namespace's quota use.
+func (qt *quotaTransform) WritePrometheus(w io.Writer) {
+	s := qt.stats()
+	families := []struct {
+		name, typ, help string
+		value           func(report.NamespaceQuotaStats) float64
+	}{
+		{"etl_quota_records_total", report.Counter, "Records the namespace quota let through.", func(n report.NamespaceQuotaStats) float64 { return float64(n.Records) }},
+		{"etl_quota_bytes_total", report.Counter, "Estimated bytes of the records the namespace quota let through.", func(n report.NamespaceQuotaStats) float64 { return float64(n.Bytes) }},
+		{"etl_quota_dropped_total", report.Counter, "Records dropped past the namespace quota.", func(n report.NamespaceQuotaStats) float64 { return float64(n.Dropped) }},
+		{"etl_quota_sampled_total", report.Counter, "Records kept past the namespace quota by quota_overflow sample.", func(n report.NamespaceQuotaStats) float64 { return float64(n.Sampled) }},
+		{"etl_quota_caps_total", report.Counter, "Intervals in which the namespace went over its quota.", func(n report.NamespaceQuotaStats) float64 { return float64(n.Caps) }},
+	}
+	for _, f := range families {
+		report.WriteFamily(w, f.name, f.typ, f.help)
+		for _, n := range s.Namespaces {
+			report.WriteSample(w, f.name, f.value(n), "namespace", n.Namespace)
+		}
+	}
+}
+
+// Close is a no-op; quotaTransform holds no resources.
+func (qt *quotaTransform) Close() error {
+	return nil
+}
+
+// QuotaUsage returns the use of the namespace_quota transform's quotas,
+// if the chain has one.
+func QuotaUsage(transforms []Named) (report.QuotaStats, bool) {
+	for _, tf := range transforms {
+		if qt, ok := tf.Collector.(*quotaTransform); ok {
+			return qt.stats(), true
+		}
+	}
+	return report.QuotaStats{}, false
+}
+
+func init() {
+	RegisterFactory(Info{
+		Name:        "namespace_quota",
+		Description: "cap each namespace's records or bytes per run or interval, dropping or sampling the rest with reason quota",
+		ConfigKeys:  []string{"namespace_quota", "namespace_quota_interval", "quota_overflow", "quota_sample_every"},
+	}, newQuotaTransform)
+}
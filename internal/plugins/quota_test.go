@@ -0,0 +1,117 @@
+package plugins
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/model"
+)
+
+func buildQuota(t *testing.T, cfg config.Config) (Named, *quotaTransform) {
+	t.Helper()
+	cfg.Transforms = []string{"namespace_quota"}
+	transforms, closer, err := BuildTransforms(cfg)
+	if err != nil {
+		t.Fatalf("BuildTransforms: %v", err)
+	}
+	t.Cleanup(func() { closer.Close() })
+	return transforms[0], transforms[0].Collector.(*quotaTransform)
+}
+
+// applyN runs n records of namespace ns through tf and counts the drops.
+func applyN(t *testing.T, tf Named, ns string, n int) (dropped int) {
+	t.Helper()
+	for range n {
+		_, drop, reason, err := tf.Apply(model.Normalized{Namespace: ns, Message: "m"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if drop {
+			if reason != ReasonQuota {
+				t.Fatalf("reason = %q, want %q", reason, ReasonQuota)
+			}
+			dropped++
+		}
+	}
+	return dropped
+}
+
+func TestNamespaceQuotaDrops(t *testing.T) {
+	tf, _ := buildQuota(t, config.Config{NamespaceQuota: []string{"payments=3", "*=5"}})
+	if got := applyN(t, tf, "payments", 10); got != 7 {
+		t.Errorf("payments dropped %d, want 7", got)
+	}
+	if got := applyN(t, tf, "web", 6); got != 1 {
+		t.Errorf("web dropped %d, want 1 under the * quota", got)
+	}
+	if got := applyN(t, tf, "batch", 5); got != 0 {
+		t.Errorf("batch dropped %d, want 0: each namespace has its own * quota", got)
+	}
+
+	stats, ok := QuotaUsage([]Named{tf})
+	if !ok || stats.Overflow != config.QuotaDrop || len(stats.Namespaces) != 3 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	p := stats.Namespaces[1]
+	if p.Namespace != "payments" || p.Records != 3 || p.Dropped != 7 || p.Caps != 1 || p.FirstCappedAt == "" {
+		t.Errorf("payments = %+v", p)
+	}
+	if b := stats.Namespaces[0]; b.Namespace != "batch" || b.Caps != 0 || b.FirstCappedAt != "" {
+		t.Errorf("batch = %+v", b)
+	}
+	if out := prometheus(tf.Collector); !strings.Contains(out, `etl_quota_dropped_total{namespace="payments"} 7`) {
+		t.Errorf("Prometheus output:\n%s", out)
+	}
+}
+
+func TestNamespaceQuotaBytesAndUnlisted(t *testing.T) {
+	size := model.Normalized{Namespace: "logs", Message: "m"}.ApproxSize()
+	tf, _ := buildQuota(t, config.Config{NamespaceQuota: []string{"logs=" + strconv.FormatInt(2*size, 10) + "B"}})
+	if got := applyN(t, tf, "logs", 4); got != 2 {
+		t.Errorf("logs dropped %d, want 2 past %d bytes", got, 2*size)
+	}
+	if got := applyN(t, tf, "other", 4); got != 0 {
+		t.Errorf("unlisted namespace dropped %d without a * quota", got)
+	}
+}
+
+func TestNamespaceQuotaSample(t *testing.T) {
+	tf, _ := buildQuota(t, config.Config{NamespaceQuota: []string{"a=2"}, QuotaOverflow: "sample", QuotaSampleEvery: 4})
+	if got := applyN(t, tf, "a", 2+12); got != 9 {
+		t.Errorf("dropped %d, want 9: one in 4 of the 12 past the quota kept", got)
+	}
+	stats, _ := QuotaUsage([]Named{tf})
+	if n := stats.Namespaces[0]; n.Sampled != 3 || n.Records != 5 {
+		t.Errorf("stats = %+v, want 3 sampled of 5 let through", n)
+	}
+}
+
+func TestNamespaceQuotaInterval(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	tf, qt := buildQuota(t, config.Config{NamespaceQuota: []string{"a=2"}, NamespaceQuotaInterval: "1m"})
+	qt.now = func() time.Time { return now }
+	qt.start = now
+	if got := applyN(t, tf, "a", 3); got != 1 {
+		t.Errorf("first minute dropped %d, want 1", got)
+	}
+	now = now.Add(time.Minute)
+	if got := applyN(t, tf, "a", 3); got != 1 {
+		t.Errorf("second minute dropped %d, want 1", got)
+	}
+	stats, _ := QuotaUsage([]Named{tf})
+	if n := stats.Namespaces[0]; n.Caps != 2 || n.Records != 4 || n.FirstCappedAt != "2023-11-14T22:13:20Z" {
+		t.Errorf("stats = %+v, want 2 caps, first in the first minute", n)
+	}
+}
+
+func TestNamespaceQuotaConfigErrors(t *testing.T) {
+	for _, quota := range [][]string{nil, {"a"}, {"a=0"}, {"a=lots"}, {"a=1", "a=2"}} {
+		cfg := config.Config{Transforms: []string{"namespace_quota"}, NamespaceQuota: quota}
+		if _, _, err := BuildTransforms(cfg); err == nil {
+			t.Errorf("namespace_quota %q: want an error", quota)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package plugins
+
+import (
+	"io"
+	"maps"
+	"slices"
+
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/model"
+	"k8s-log-etl/internal/report"
+	"k8s-log-etl/internal/stages"
+)
+
+// filterRedact is the built-in filter_redact transform, FilterStage as a
+// transform, exporting how often each redact key was removed.
+type filterRedact struct {
+	fs *stages.FilterStage
+}
+
+func newFilterRedact(cfg config.Config) (Transform, io.Closer, error) {
+	fr := &filterRedact{fs: stages.NewFilterStage(cfg)}
+	return fr.apply, fr, nil
+}
+
+func (fr *filterRedact) apply(n model.Normalized) (model.Normalized, bool, string, error) {
+	if keep, reason := fr.fs.Apply(&n); !keep {
+		return n, true, reason, nil
+	}
+	return n, false, "", nil
+}
+
+// WritePrometheus exports the records each redact key was removed from.
+func (fr *filterRedact) WritePrometheus(w io.Writer) {
+	counts := fr.fs.Redactions()
+	report.WriteFamily(w, "etl_redactions_total", report.Counter, "Records a redact key was removed from, by key.")
+	for _, key := range slices.Sorted(maps.Keys(counts)) {
+		report.WriteSample(w, "etl_redactions_total", float64(counts[key]), "key", key)
+	}
+}
+
+// Close is a no-op; filterRedact holds no resources.
+func (fr *filterRedact) Close() error {
+	return nil
+}
+
+// Redactions returns how many records each redact key was removed from,
+// summed over the filter_redact transforms of the chain, if it has any.
+func Redactions(transforms []Named) (map[string]int, bool) {
+	var counts map[string]int
+	for _, tf := range transforms {
+		fr, ok := tf.Collector.(*filterRedact)
+		if !ok {
+			continue
+		}
+		if counts == nil {
+			counts = make(map[string]int)
+		}
+		for key, n := range fr.fs.Redactions() {
+			counts[key] += n
+		}
+	}
+	return counts, counts != nil
+}
+
+func init() {
+	RegisterFactory(Info{
+		Name:        "filter_redact",
+		Description: "drop records outside the allowed levels and services, and remove redacted keys from fields",
+		ConfigKeys:  []string{"filter_levels", "filter_services", "redact_keys", "redaction_audit"},
+	}, newFilterRedact)
+}
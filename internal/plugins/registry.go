@@ -1,51 +1,185 @@
 package plugins
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"runtime/debug"
+	"slices"
 	"strings"
 
 	"k8s-log-etl/internal/config"
 	"k8s-log-etl/internal/model"
-	"k8s-log-etl/internal/stages"
+	"k8s-log-etl/internal/report"
 )
 
 // Transform applies a mutation to a record and can drop it with a reason.
 // Returned record replaces the input.
 type Transform func(model.Normalized) (model.Normalized, bool, string, error)
 
-var transformRegistry = map[string]func(config.Config) Transform{}
+// Named is a built transform tagged with its configured name and the
+// config.OnError* policy the pipeline applies when it returns an error.
+type Named struct {
+	Name    string
+	Apply   Transform
+	OnError string
+	// Collector is set when the transform's closer also exports metrics.
+	Collector report.Collector
+}
+
+// Factory builds a transform that may hold resources such as a child
+// process. A non-nil closer is closed when the pipeline shuts down.
+type Factory func(config.Config) (Transform, io.Closer, error)
 
-// RegisterTransform registers a transform factory by name.
-func RegisterTransform(name string, builder func(config.Config) Transform) {
-	transformRegistry[strings.ToLower(name)] = builder
+// Info describes a registered transform, for `etl transforms` and the docs
+// generated from it.
+type Info struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// ConfigKeys are the config keys the transform reads.
+	ConfigKeys []string `json:"config_keys"`
 }
 
-// BuildTransforms constructs the transforms specified in config.Transforms.
-func BuildTransforms(cfg config.Config) ([]Transform, error) {
+type registered struct {
+	info    Info
+	factory Factory
+}
+
+var transformRegistry = map[string]registered{}
+
+// RegisterTransform registers a transform builder under info.Name.
+func RegisterTransform(info Info, builder func(config.Config) Transform) {
+	RegisterFactory(info, func(cfg config.Config) (Transform, io.Closer, error) {
+		return builder(cfg), nil, nil
+	})
+}
+
+// RegisterFactory registers a transform factory that can fail or hold
+// resources under info.Name.
+func RegisterFactory(info Info, factory Factory) {
+	transformRegistry[strings.ToLower(info.Name)] = registered{info: info, factory: factory}
+}
+
+// Registered describes every registered transform, by name.
+func Registered() []Info {
+	infos := make([]Info, 0, len(transformRegistry))
+	for _, r := range transformRegistry {
+		infos = append(infos, r.info)
+	}
+	slices.SortFunc(infos, func(a, b Info) int { return strings.Compare(a.Name, b.Name) })
+	return infos
+}
+
+// Names returns the transform names BuildTransforms will use, in order.
+// timestamp_shift set adds its transform last, after every transform that
+// can drop a record, unless the chain already places it.
+func Names(cfg config.Config) []string {
 	names := cfg.Transforms
 	if len(names) == 0 {
 		names = []string{"filter_redact"}
 	}
-	var result []Transform
+	if cfg.TimestampShift != "" && !slices.ContainsFunc(names, func(n string) bool { return strings.EqualFold(n, "timestamp_shift") }) {
+		names = append(slices.Clip(names), "timestamp_shift")
+	}
+	return names
+}
+
+// BuildTransforms constructs the transforms specified in config.Transforms.
+// The returned closer releases resources held by the transforms and must be
+// closed once the pipeline is done with them.
+func BuildTransforms(cfg config.Config) ([]Named, io.Closer, error) {
+	names := Names(cfg)
+	policies, err := config.OnErrorPolicies(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	var result []Named
+	var closers closerList
 	for _, name := range names {
-		builder, ok := transformRegistry[strings.ToLower(name)]
+		r, ok := transformRegistry[strings.ToLower(name)]
 		if !ok {
-			return nil, fmt.Errorf("unknown transform %q", name)
+			closers.Close()
+			return nil, nil, unknownTransform(name)
+		}
+		tf, closer, err := r.factory(cfg)
+		if err != nil {
+			closers.Close()
+			return nil, nil, fmt.Errorf("build transform %q: %w", name, err)
+		}
+		if closer != nil {
+			closers = append(closers, closer)
+		}
+		policy := policies[strings.ToLower(name)]
+		if policy == "" {
+			policy = config.OnErrorDrop
+		}
+		named := Named{Name: name, Apply: recovering(tf), OnError: policy}
+		if c, ok := closer.(report.Collector); ok {
+			named.Collector = c
+		}
+		result = append(result, named)
+	}
+	return result, closers, nil
+}
+
+// CheckTransforms builds the transforms in cfg.Transforms and closes them
+// again, so a typo or a transform that cannot start is found before the
+// run reads any input. Unlike BuildTransforms it returns every problem,
+// joined, rather than the first.
+func CheckTransforms(cfg config.Config) error {
+	var errs []error
+	for _, name := range Names(cfg) {
+		r, ok := transformRegistry[strings.ToLower(name)]
+		if !ok {
+			errs = append(errs, unknownTransform(name))
+			continue
+		}
+		_, closer, err := r.factory(cfg)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("build transform %q: %w", name, err))
+			continue
+		}
+		if closer != nil {
+			closer.Close()
 		}
-		result = append(result, builder(cfg))
 	}
-	return result, nil
+	return errors.Join(errs...)
 }
 
-func init() {
-	// Built-in filter+redact plugin using existing FilterStage.
-	RegisterTransform("filter_redact", func(cfg config.Config) Transform {
-		fs := stages.NewFilterStage(cfg)
-		return func(n model.Normalized) (model.Normalized, bool, string, error) {
-			if ok, reason := fs.Apply(&n); !ok {
-				return n, true, reason, nil
+// unknownTransform is the error for a name no transform is registered
+// under, listing the names that are.
+func unknownTransform(name string) error {
+	var names []string
+	for _, info := range Registered() {
+		names = append(names, info.Name)
+	}
+	return fmt.Errorf("unknown transform %q (registered: %s)", name, strings.Join(names, ", "))
+}
+
+// recovering returns tf with a panic on a record turned into a *PanicError,
+// so one bad record meets the transform's on_error policy instead of
+// taking down the run.
+func recovering(tf Transform) Transform {
+	return func(n model.Normalized) (out model.Normalized, drop bool, reason string, err error) {
+		defer func() {
+			if v := recover(); v != nil {
+				out, drop, reason = n, false, ""
+				err = &PanicError{Value: v, Stack: debug.Stack()}
 			}
-			return n, false, "", nil
+		}()
+		return tf(n)
+	}
+}
+
+// closerList closes every closer, in reverse build order.
+type closerList []io.Closer
+
+func (c closerList) Close() error {
+	var errs []error
+	for i := len(c) - 1; i >= 0; i-- {
+		if err := c[i].Close(); err != nil {
+			errs = append(errs, err)
 		}
-	})
+	}
+	return errors.Join(errs...)
 }
@@ -0,0 +1,42 @@
+package plugins
+
+import (
+	"strings"
+	"testing"
+
+	"k8s-log-etl/internal/config"
+)
+
+func TestCheckTransformsListsEveryProblem(t *testing.T) {
+	cfg := config.Default()
+	cfg.Transforms = []string{"filter_redact", "filter_redcat", "metrics_extract", "nope"}
+	cfg.MetricsRules = ""
+	err := CheckTransforms(cfg)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok || len(joined.Unwrap()) != 3 {
+		t.Fatalf("expected three joined errors, got %v", err)
+	}
+	msg := err.Error()
+	for _, want := range []string{`unknown transform "filter_redcat"`, `unknown transform "nope"`, `build transform "metrics_extract"`, "registered: exec, filter_redact, metrics_extract, namespace_quota, timestamp_shift, wasm"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("error %q does not mention %q", msg, want)
+		}
+	}
+
+	cfg.Transforms = []string{"filter_redact"}
+	if err := CheckTransforms(cfg); err != nil {
+		t.Fatalf("CheckTransforms: %v", err)
+	}
+}
+
+func TestBuildTransformsUnknownListsRegistered(t *testing.T) {
+	cfg := config.Default()
+	cfg.Transforms = []string{"filter_redcat"}
+	_, _, err := BuildTransforms(cfg)
+	if err == nil || !strings.Contains(err.Error(), "registered: exec, filter_redact") {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package plugins
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/model"
+	"k8s-log-etl/internal/report"
+)
+
+// FieldTimestampShifted tags a record whose timestamp timestamp_shift
+// moved.
+const FieldTimestampShifted = "ts_shifted"
+
+// timestampShift moves every record's timestamp by a fixed duration, for
+// replaying old logs as if they were current. A record already tagged
+// ts_shifted is left alone, so one that comes back through the chain, as
+// a replayed DLQ entry can, is not shifted twice.
+type timestampShift struct {
+	shift time.Duration
+	stats report.TimestampShiftStats // fixed once built
+
+	shifted, skipped atomic.Int64
+}
+
+func newTimestampShift(cfg config.Config) (Transform, io.Closer, error) {
+	ts := &timestampShift{stats: report.TimestampShiftStats{Mode: "fixed"}}
+	shift := cfg.TimestampShiftDuration()
+	switch {
+	case cfg.TimestampRebase():
+		if cfg.TimestampShiftLatest.IsZero() {
+			return nil, nil, errors.New("timestamp_shift rebase-now needs the latest timestamp of input files read before the run; use a fixed shift such as +72h here")
+		}
+		// Whole seconds keep the records' fractional seconds as they were.
+		shift = time.Since(cfg.TimestampShiftLatest).Round(time.Second)
+		ts.stats.Mode = config.TimestampShiftRebaseNow
+		ts.stats.Latest = cfg.TimestampShiftLatest.Format(time.RFC3339Nano)
+	case shift == 0:
+		return nil, nil, errors.New("timestamp_shift is required for the timestamp_shift transform")
+	}
+	ts.shift = shift
+	ts.stats.Shift = shift.String()
+	ts.stats.ShiftSeconds = shift.Seconds()
+	return ts.apply, ts, nil
+}
+
+func (ts *timestampShift) apply(n model.Normalized) (model.Normalized, bool, string, error) {
+	t, ok := n.EventTime()
+	if done, _ := n.Fields[FieldTimestampShifted].(bool); done || !ok {
+		ts.skipped.Add(1)
+		return n, false, "", nil
+	}
+	t = t.Add(ts.shift)
+	n.TS = t.Format(time.RFC3339Nano)
+	n.Time = t
+	if n.Fields == nil {
+		n.Fields = make(map[string]any)
+	}
+	n.Fields[FieldTimestampShifted] = true
+	ts.shifted.Add(1)
+	return n, false, "", nil
+}
+
+func (ts *timestampShift) snapshot() report.TimestampShiftStats {
+	s := ts.stats
+	s.Shifted, s.Skipped = ts.shifted.Load(), ts.skipped.Load()
+	return s
+}
+
+// WritePrometheus exports the shift and the records it was applied to.
+func (ts *timestampShift) WritePrometheus(w io.Writer) {
+	s := ts.snapshot()
+	report.WriteFamily(w, "etl_timestamp_shift_seconds", report.Gauge, "Seconds added to every record's timestamp by timestamp_shift.")
+	report.WriteSample(w, "etl_timestamp_shift_seconds", s.ShiftSeconds)
+	report.WriteFamily(w, "etl_timestamp_shifted_total", report.Counter, "Records whose timestamp timestamp_shift moved.")
+	report.WriteSample(w, "etl_timestamp_shifted_total", float64(s.Shifted))
+}
+
+// Close is a no-op; timestampShift holds no resources.
+func (ts *timestampShift) Close() error {
+	return nil
+}
+
+// TimestampShift returns the shift the timestamp_shift transform applied
+// and the records it applied it to, if the chain has one.
+func TimestampShift(transforms []Named) (report.TimestampShiftStats, bool) {
+	for _, tf := range transforms {
+		if ts, ok := tf.Collector.(*timestampShift); ok {
+			return ts.snapshot(), true
+		}
+	}
+	return report.TimestampShiftStats{}, false
+}
+
+func init() {
+	RegisterFactory(Info{
+		Name:        "timestamp_shift",
+		Description: "move every record's timestamp by a fixed duration or so the input's latest record is current, tagging it ts_shifted",
+		ConfigKeys:  []string{"timestamp_shift"},
+	}, newTimestampShift)
+}
@@ -0,0 +1,89 @@
+package plugins
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/model"
+)
+
+func TestTimestampShiftFixed(t *testing.T) {
+	transforms, closer, err := BuildTransforms(config.Config{Transforms: []string{"timestamp_shift"}, TimestampShift: "+72h"})
+	if err != nil {
+		t.Fatalf("BuildTransforms: %v", err)
+	}
+	defer closer.Close()
+	tf := transforms[0]
+
+	in := time.Date(2024, 1, 1, 12, 0, 0, 500, time.FixedZone("", 3600))
+	n, drop, _, err := tf.Apply(model.Normalized{TS: in.Format(time.RFC3339Nano), Time: in, Message: "m"})
+	if err != nil || drop {
+		t.Fatalf("Apply = drop %v, err %v", drop, err)
+	}
+	want := in.Add(72 * time.Hour)
+	if !n.Time.Equal(want) || n.TS != "2024-01-04T12:00:00.0000005+01:00" || n.Fields[FieldTimestampShifted] != true {
+		t.Errorf("shifted to %s (%s), fields %v", n.TS, n.Time, n.Fields)
+	}
+
+	// Already shifted, or without a timestamp: left alone.
+	again, _, _, _ := tf.Apply(n)
+	if again.TS != n.TS {
+		t.Errorf("shifted twice: %s", again.TS)
+	}
+	if none, _, _, _ := tf.Apply(model.Normalized{TS: "yesterday", Message: "m"}); none.TS != "yesterday" || none.Fields != nil {
+		t.Errorf("record without a timestamp = %+v", none)
+	}
+	// A record decoded from JSON has only TS.
+	if decoded, _, _, _ := tf.Apply(model.Normalized{TS: "2024-01-01T00:00:00Z"}); decoded.TS != "2024-01-04T00:00:00Z" {
+		t.Errorf("decoded record shifted to %s", decoded.TS)
+	}
+
+	stats, ok := TimestampShift(transforms)
+	if !ok || stats.Mode != "fixed" || stats.Shift != "72h0m0s" || stats.ShiftSeconds != 72*3600 || stats.Shifted != 2 || stats.Skipped != 2 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestTimestampShiftRebaseNow(t *testing.T) {
+	latest := time.Now().Add(-48 * time.Hour)
+	cfg := config.Config{Transforms: []string{"timestamp_shift"}, TimestampShift: "rebase-now", TimestampShiftLatest: latest}
+	transforms, closer, err := BuildTransforms(cfg)
+	if err != nil {
+		t.Fatalf("BuildTransforms: %v", err)
+	}
+	defer closer.Close()
+
+	n, _, _, _ := transforms[0].Apply(model.Normalized{Time: latest, TS: latest.Format(time.RFC3339Nano)})
+	if d := time.Since(n.Time); d < -time.Second || d > 5*time.Second {
+		t.Errorf("latest record shifted to %s, want about now", n.Time)
+	}
+	stats, _ := TimestampShift(transforms)
+	if stats.Mode != config.TimestampShiftRebaseNow || stats.Latest != latest.Format(time.RFC3339Nano) || stats.ShiftSeconds < 48*3600 || stats.ShiftSeconds != float64(int64(stats.ShiftSeconds)) {
+		t.Errorf("stats = %+v, want whole seconds of about 48h", stats)
+	}
+
+	// Without the input's latest time there is nothing to rebase on.
+	cfg.TimestampShiftLatest = time.Time{}
+	if _, _, err := BuildTransforms(cfg); err == nil || !strings.Contains(err.Error(), "rebase-now") {
+		t.Errorf("BuildTransforms without the latest time = %v", err)
+	}
+}
+
+func TestNamesAddsTimestampShiftLast(t *testing.T) {
+	for _, tc := range []struct {
+		transforms []string
+		want       []string
+	}{
+		{nil, []string{"filter_redact", "timestamp_shift"}},
+		{[]string{"filter_redact", "namespace_quota"}, []string{"filter_redact", "namespace_quota", "timestamp_shift"}},
+		{[]string{"Timestamp_Shift", "filter_redact"}, []string{"Timestamp_Shift", "filter_redact"}},
+	} {
+		cfg := config.Config{Transforms: tc.transforms, TimestampShift: "+1h"}
+		if got := Names(cfg); !slices.Equal(got, tc.want) {
+			t.Errorf("Names(%v) = %v, want %v", tc.transforms, got, tc.want)
+		}
+	}
+}
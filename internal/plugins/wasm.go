@@ -0,0 +1,228 @@
+package plugins
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/model"
+)
+
+const (
+	wasmPageSize      = 64 * 1024
+	wasmDefaultPoolSz = 4
+)
+
+// wasmTransform runs records through a WASM guest module.
+//
+// Guest ABI: the module exports memory, alloc(size i32) -> ptr i32, and
+// transform(ptr i32, len i32) -> i64. The host writes the record JSON into a
+// buffer from alloc and calls transform, which returns (resultPtr << 32 |
+// resultLen) pointing at a guestResponse JSON. If the guest exports
+// dealloc(ptr i32), both buffers are released through it.
+//
+// The module is compiled once. Instances are pooled so concurrent callers
+// each get their own; an instance that traps or times out is discarded.
+type wasmTransform struct {
+	path     string
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	timeout  time.Duration
+	pool     chan api.Module
+}
+
+// newWasmTransform compiles the module and instantiates one instance up
+// front so ABI and initialization errors surface at build time.
+func newWasmTransform(cfg config.Config) (Transform, io.Closer, error) {
+	if cfg.WasmModule == "" {
+		return nil, nil, errors.New("wasm_module is required for the wasm transform")
+	}
+	code, err := os.ReadFile(cfg.WasmModule)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read wasm module: %w", err)
+	}
+	timeout := time.Duration(cfg.WasmTimeoutMS) * time.Millisecond
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	limitMB := cfg.WasmMemoryLimitMB
+	if limitMB <= 0 {
+		limitMB = 128
+	}
+
+	ctx := context.Background()
+	rcfg := wazero.NewRuntimeConfig().
+		WithCloseOnContextDone(true).
+		WithMemoryLimitPages(uint32(limitMB * 1024 * 1024 / wasmPageSize))
+	runtime := wazero.NewRuntimeWithConfig(ctx, rcfg)
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, runtime); err != nil {
+		runtime.Close(ctx)
+		return nil, nil, fmt.Errorf("instantiate wasi: %w", err)
+	}
+	compiled, err := runtime.CompileModule(ctx, code)
+	if err != nil {
+		runtime.Close(ctx)
+		return nil, nil, fmt.Errorf("compile wasm module: %w", err)
+	}
+	exports := compiled.ExportedFunctions()
+	for _, name := range []string{"alloc", "transform"} {
+		if _, ok := exports[name]; !ok {
+			runtime.Close(ctx)
+			return nil, nil, fmt.Errorf("wasm module %s does not export %q", cfg.WasmModule, name)
+		}
+	}
+
+	wt := &wasmTransform{
+		path:     cfg.WasmModule,
+		runtime:  runtime,
+		compiled: compiled,
+		timeout:  timeout,
+		pool:     make(chan api.Module, wasmDefaultPoolSz),
+	}
+	mod, err := wt.instantiate(ctx)
+	if err != nil {
+		runtime.Close(ctx)
+		return nil, nil, err
+	}
+	wt.put(mod)
+	return wt.apply, wt, nil
+}
+
+func (wt *wasmTransform) instantiate(ctx context.Context) (api.Module, error) {
+	mcfg := wazero.NewModuleConfig().
+		WithName("").
+		WithStartFunctions("_initialize").
+		WithStderr(os.Stderr)
+	mod, err := wt.runtime.InstantiateModule(ctx, wt.compiled, mcfg)
+	if err != nil {
+		return nil, fmt.Errorf("instantiate wasm module %s: %w", wt.path, err)
+	}
+	if mod.Memory() == nil {
+		mod.Close(ctx)
+		return nil, fmt.Errorf("wasm module %s does not export memory", wt.path)
+	}
+	return mod, nil
+}
+
+// get takes a pooled instance or creates a new one.
+func (wt *wasmTransform) get(ctx context.Context) (api.Module, error) {
+	select {
+	case mod := <-wt.pool:
+		return mod, nil
+	default:
+		return wt.instantiate(ctx)
+	}
+}
+
+// put returns a healthy instance to the pool, closing it if the pool is full.
+func (wt *wasmTransform) put(mod api.Module) {
+	select {
+	case wt.pool <- mod:
+	default:
+		mod.Close(context.Background())
+	}
+}
+
+func (wt *wasmTransform) apply(n model.Normalized) (model.Normalized, bool, string, error) {
+	// Guests always get the legacy field names, whatever output_schema is.
+	data, err := json.Marshal(model.Legacy(n))
+	if err != nil {
+		return n, false, "", fmt.Errorf("wasm transform: marshal record: %w", err)
+	}
+
+	mod, err := wt.get(context.Background())
+	if err != nil {
+		return n, false, "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), wt.timeout)
+	defer cancel()
+
+	out, err := wt.call(ctx, mod, data)
+	if err != nil {
+		// The instance is closed on timeout and may be corrupt after a
+		// trap; never reuse it.
+		mod.Close(context.Background())
+		if ctx.Err() != nil {
+			return n, false, "", fmt.Errorf("wasm transform: %w after %v", ErrTransformTimeout, wt.timeout)
+		}
+		return n, false, "", fmt.Errorf("wasm transform: guest error: %w", err)
+	}
+	wt.put(mod)
+
+	var resp guestResponse
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return n, false, "", fmt.Errorf("wasm transform: invalid response: %w", err)
+	}
+	if resp.Error != "" {
+		return n, false, "", fmt.Errorf("wasm transform: %s", resp.Error)
+	}
+	result := n
+	if resp.Record != nil {
+		result = *resp.Record
+	}
+	if resp.Drop {
+		reason := resp.Reason
+		if reason == "" {
+			reason = "wasm"
+		}
+		return result, true, reason, nil
+	}
+	return result, false, "", nil
+}
+
+// call passes data to the guest and returns a copy of its response.
+func (wt *wasmTransform) call(ctx context.Context, mod api.Module, data []byte) ([]byte, error) {
+	mem := mod.Memory()
+	res, err := mod.ExportedFunction("alloc").Call(ctx, uint64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("alloc: %w", err)
+	}
+	inPtr := uint32(res[0])
+	if !mem.Write(inPtr, data) {
+		return nil, fmt.Errorf("alloc returned out-of-range buffer %d+%d", inPtr, len(data))
+	}
+
+	res, err = mod.ExportedFunction("transform").Call(ctx, uint64(inPtr), uint64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	outPtr, outLen := uint32(res[0]>>32), uint32(res[0])
+	view, ok := mem.Read(outPtr, outLen)
+	if !ok {
+		return nil, fmt.Errorf("transform returned out-of-range result %d+%d", outPtr, outLen)
+	}
+	out := make([]byte, len(view))
+	copy(out, view)
+
+	if dealloc := mod.ExportedFunction("dealloc"); dealloc != nil {
+		if _, err := dealloc.Call(ctx, uint64(inPtr)); err != nil {
+			return nil, fmt.Errorf("dealloc: %w", err)
+		}
+		if _, err := dealloc.Call(ctx, uint64(outPtr)); err != nil {
+			return nil, fmt.Errorf("dealloc: %w", err)
+		}
+	}
+	return out, nil
+}
+
+// Close releases all instances and the compiled module.
+func (wt *wasmTransform) Close() error {
+	return wt.runtime.Close(context.Background())
+}
+
+func init() {
+	RegisterFactory(Info{
+		Name:        "wasm",
+		Description: "run each record through a sandboxed WebAssembly module",
+		ConfigKeys:  []string{"wasm_module", "wasm_timeout_ms", "wasm_memory_limit_mb"},
+	}, newWasmTransform)
+}
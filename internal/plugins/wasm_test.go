@@ -0,0 +1,113 @@
+package plugins
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/model"
+)
+
+var (
+	maskModuleOnce sync.Once
+	maskModulePath string
+	maskModuleErr  error
+)
+
+// maskModule builds examples/wasm/mask once per test binary.
+func maskModule(t *testing.T) string {
+	t.Helper()
+	maskModuleOnce.Do(func() {
+		dir, err := os.MkdirTemp("", "etl-wasm")
+		if err != nil {
+			maskModuleErr = err
+			return
+		}
+		maskModulePath = filepath.Join(dir, "mask.wasm")
+		cmd := exec.Command("go", "build", "-buildmode=c-shared", "-o", maskModulePath, "./examples/wasm/mask")
+		cmd.Dir = filepath.Join("..", "..")
+		cmd.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			maskModuleErr = errors.New(string(out))
+		}
+	})
+	if maskModuleErr != nil {
+		t.Skipf("build example wasm module: %v", maskModuleErr)
+	}
+	return maskModulePath
+}
+
+func buildWasm(t *testing.T, cfg config.Config) Transform {
+	t.Helper()
+	cfg.Transforms = []string{"wasm"}
+	transforms, closer, err := BuildTransforms(cfg)
+	if err != nil {
+		t.Fatalf("BuildTransforms: %v", err)
+	}
+	t.Cleanup(func() { closer.Close() })
+	return transforms[0].Apply
+}
+
+func TestWasmTransformMasksFields(t *testing.T) {
+	tf := buildWasm(t, config.Config{WasmModule: maskModule(t)})
+	for i := 0; i < 3; i++ {
+		out, drop, _, err := tf(model.Normalized{
+			Level:   "ERROR",
+			Message: "login failed",
+			Fields:  map[string]any{"db_password": "hunter2", "user": "bob"},
+		})
+		if err != nil {
+			t.Fatalf("apply %d: %v", i, err)
+		}
+		if drop {
+			t.Fatalf("unexpected drop")
+		}
+		if out.Fields["db_password"] != "[masked]" || out.Fields["user"] != "bob" {
+			t.Fatalf("unexpected fields: %v", out.Fields)
+		}
+	}
+}
+
+func TestWasmTransformDrop(t *testing.T) {
+	tf := buildWasm(t, config.Config{WasmModule: maskModule(t)})
+	_, drop, reason, err := tf(model.Normalized{Message: "healthcheck ok"})
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if !drop || reason != "healthcheck" {
+		t.Fatalf("expected healthcheck drop, got drop=%v reason=%q", drop, reason)
+	}
+}
+
+func TestWasmTransformTimeoutReplacesInstance(t *testing.T) {
+	tf := buildWasm(t, config.Config{WasmModule: maskModule(t), WasmTimeoutMS: 100})
+	if _, _, _, err := tf(model.Normalized{Message: "__spin__"}); !errors.Is(err, ErrTransformTimeout) {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+	// A fresh instance serves the next record.
+	if _, _, _, err := tf(model.Normalized{Message: "fine"}); err != nil {
+		t.Fatalf("apply after timeout: %v", err)
+	}
+}
+
+func TestWasmTransformMemoryLimit(t *testing.T) {
+	cfg := config.Config{Transforms: []string{"wasm"}, WasmModule: maskModule(t), WasmMemoryLimitMB: 1}
+	if _, _, err := BuildTransforms(cfg); err == nil {
+		t.Fatal("expected instantiation to fail under a 1 MiB memory limit")
+	}
+}
+
+func TestWasmTransformRejectsInvalidModule(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bad.wasm")
+	if err := os.WriteFile(path, []byte("not wasm"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	cfg := config.Config{Transforms: []string{"wasm"}, WasmModule: path}
+	if _, _, err := BuildTransforms(cfg); err == nil {
+		t.Fatal("expected compile error")
+	}
+}
@@ -0,0 +1,81 @@
+package report
+
+import (
+	"math"
+	"math/bits"
+)
+
+// distinctPrecision sets the registers of a distinct sketch to
+// 2^distinctPrecision, for a standard error of about 1.6%.
+const distinctPrecision = 12
+
+const distinctRegisters = 1 << distinctPrecision
+
+// distinct estimates how many distinct strings were added to it with a
+// HyperLogLog sketch: memory is fixed at one byte per register however many
+// names a run sees. Two sketches merge by taking the larger of each
+// register, so the counts sketched since the last sync combine with the
+// report's into the count of their union.
+type distinct struct {
+	regs [distinctRegisters]uint8
+}
+
+func (d *distinct) add(s string) {
+	x := mix64(fnv64a(s))
+	idx := x >> (64 - distinctPrecision)
+	// The rank is the position of the first 1 bit in the remaining bits;
+	// the sentinel bit caps it when they are all 0.
+	rank := uint8(bits.LeadingZeros64(x<<distinctPrecision|1<<(distinctPrecision-1))) + 1
+	if rank > d.regs[idx] {
+		d.regs[idx] = rank
+	}
+}
+
+// merge folds o into d.
+func (d *distinct) merge(o *distinct) {
+	for i, r := range o.regs {
+		if r > d.regs[i] {
+			d.regs[i] = r
+		}
+	}
+}
+
+// estimate returns the estimated number of distinct strings added. Small
+// counts use linear counting over the empty registers, which is close to
+// exact while most registers are still empty.
+func (d *distinct) estimate() int {
+	const m = float64(distinctRegisters)
+	sum, zeros := 0.0, 0
+	for _, r := range d.regs {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+	e := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if e <= 2.5*m && zeros > 0 {
+		e = m * math.Log(m/float64(zeros))
+	}
+	return int(math.Round(e))
+}
+
+// fnv64a is FNV-1a, inlined so adding a name does not allocate.
+func fnv64a(s string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+// mix64 is the murmur3 finalizer. FNV's high bits, which pick the
+// register, are poorly spread for short keys that differ only at the end.
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,85 @@
+package report
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestDistinct_Estimate(t *testing.T) {
+	for _, n := range []int{0, 1, 10, 100, 1000, 10000, 200000} {
+		var d distinct
+		for i := 0; i < n; i++ {
+			name := fmt.Sprintf("pod-%d", i)
+			d.add(name)
+			d.add(name) // repeats are not counted
+		}
+		got := d.estimate()
+		// Small counts are all but exact; beyond that, 3 standard errors.
+		tolerance := max(1, int(0.05*float64(n)))
+		if n <= 100 {
+			tolerance = 1
+		}
+		if math.Abs(float64(got-n)) > float64(tolerance) {
+			t.Errorf("n=%d: estimate %d, want within %d", n, got, tolerance)
+		}
+	}
+}
+
+func TestDistinct_MergeIsUnion(t *testing.T) {
+	var a, b, both distinct
+	for i := 0; i < 30000; i++ {
+		name := fmt.Sprintf("trace-%d", i)
+		both.add(name)
+		if i < 20000 {
+			a.add(name)
+		}
+		if i >= 10000 {
+			b.add(name)
+		}
+	}
+	a.merge(&b)
+	if a.regs != both.regs {
+		t.Error("merged sketch differs from the sketch of the union")
+	}
+	if got := a.estimate(); math.Abs(float64(got-30000)) > 1500 {
+		t.Errorf("merged estimate %d, want about 30000", got)
+	}
+}
+
+func TestReportDistinctConcurrent(t *testing.T) {
+	rep := NewReport()
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				// Every goroutine sees the same services and namespaces but
+				// its own pods.
+				rep.AddDistinct(fmt.Sprintf("svc-%d", i%5), "prod", fmt.Sprintf("pod-%d-%d", g, i), "")
+			}
+		}(g)
+	}
+	wg.Wait()
+	rep.AddDistinct("svc-0", "staging", "", "abc")
+	rep.Sync()
+	if rep.DistinctServices != 5 || rep.DistinctNamespaces != 2 || rep.DistinctTraceIDs != 1 {
+		t.Errorf("distinct services %d, namespaces %d, trace ids %d; want 5, 2, 1", rep.DistinctServices, rep.DistinctNamespaces, rep.DistinctTraceIDs)
+	}
+	if math.Abs(float64(rep.DistinctPods-2000)) > 100 {
+		t.Errorf("distinct pods %d, want about 2000", rep.DistinctPods)
+	}
+
+	// A later sync folds in new names without losing the earlier ones.
+	rep.AddDistinct("svc-9", "", "", "")
+	snap := rep.Snapshot()
+	if snap.DistinctServices != 6 || snap.DistinctPods != rep.DistinctPods {
+		t.Errorf("after another service, snapshot has %d services, %d pods", snap.DistinctServices, snap.DistinctPods)
+	}
+	if !strings.Contains(rep.Prometheus(), `etl_distinct_values{field="service"} 6`) {
+		t.Error("distinct services missing from the metrics")
+	}
+}
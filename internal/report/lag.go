@@ -0,0 +1,94 @@
+package report
+
+import (
+	"math"
+	"time"
+)
+
+// LagStats describes how far behind their event time records were
+// processed: the processing time minus the record's timestamp, in seconds.
+// Records timestamped ahead of the clock, e.g. from clock skew, are counted
+// in Future and left out of the rest rather than read as negative lags.
+type LagStats struct {
+	Records    int     `json:"records"`
+	MinSeconds float64 `json:"min_seconds"`
+	AvgSeconds float64 `json:"avg_seconds"`
+	P95Seconds float64 `json:"p95_seconds"`
+	MaxSeconds float64 `json:"max_seconds"`
+	// LastSeconds is the lag of the most recent record.
+	LastSeconds float64 `json:"last_seconds"`
+	Future      int     `json:"future"`
+}
+
+// Lag histogram buckets grow by lagGrowth from lagFloor, so a quantile read
+// from them is within 5% of the true lag, in fixed memory. Lags below the
+// floor share the first bucket and lags past the last share the last.
+const (
+	lagFloor   = time.Millisecond
+	lagGrowth  = 1.05
+	lagBuckets = 480 // up to about 150 days
+)
+
+// lagTracker accumulates record lags for LagStats.
+type lagTracker struct {
+	count    int
+	sum      float64
+	min, max float64
+	last     float64
+	future   int
+	buckets  [lagBuckets]int
+}
+
+func (t *lagTracker) add(lag time.Duration) {
+	if lag < 0 {
+		t.future++
+		return
+	}
+	s := lag.Seconds()
+	if t.count == 0 || s < t.min {
+		t.min = s
+	}
+	t.max = max(t.max, s)
+	t.count++
+	t.sum += s
+	t.last = s
+	t.buckets[lagBucket(lag)]++
+}
+
+func lagBucket(lag time.Duration) int {
+	if lag <= lagFloor {
+		return 0
+	}
+	i := int(math.Log(float64(lag)/float64(lagFloor)) / math.Log(lagGrowth))
+	return min(i, lagBuckets-1)
+}
+
+// quantile returns the lag below which a fraction q of the records fall,
+// as the middle of its bucket, clamped to the lags seen.
+func (t *lagTracker) quantile(q float64) float64 {
+	if t.count == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(q * float64(t.count)))
+	seen := 0
+	for i, n := range t.buckets {
+		if seen += n; seen >= rank {
+			if i == lagBuckets-1 {
+				return t.max // the last bucket has no upper bound
+			}
+			lo := lagFloor.Seconds() * math.Pow(lagGrowth, float64(i))
+			return min(max(lo*math.Sqrt(lagGrowth), t.min), t.max)
+		}
+	}
+	return t.max
+}
+
+func (t *lagTracker) stats() LagStats {
+	s := LagStats{Records: t.count, Future: t.future}
+	if t.count > 0 {
+		s.MinSeconds, s.MaxSeconds, s.LastSeconds = t.min, t.max, t.last
+		s.AvgSeconds = t.sum / float64(t.count)
+		s.P95Seconds = t.quantile(0.95)
+	}
+	return s
+}
@@ -0,0 +1,56 @@
+package report
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLagTracker_Stats(t *testing.T) {
+	var tr lagTracker
+	// 1..1000 seconds, then a record from the future.
+	for i := 1; i <= 1000; i++ {
+		tr.add(time.Duration(i) * time.Second)
+	}
+	tr.add(-time.Minute)
+	s := tr.stats()
+	if s.Records != 1000 || s.Future != 1 {
+		t.Fatalf("records %d, future %d; want 1000, 1", s.Records, s.Future)
+	}
+	if s.MinSeconds != 1 || s.MaxSeconds != 1000 || s.LastSeconds != 1000 || s.AvgSeconds != 500.5 {
+		t.Errorf("stats %+v", s)
+	}
+	if math.Abs(s.P95Seconds-950)/950 > 0.05 {
+		t.Errorf("p95 %v, want within 5%% of 950", s.P95Seconds)
+	}
+}
+
+func TestLagTracker_Extremes(t *testing.T) {
+	var tr lagTracker
+	tr.add(0)
+	tr.add(time.Microsecond)
+	if s := tr.stats(); s.P95Seconds != s.MaxSeconds || s.MaxSeconds != time.Microsecond.Seconds() {
+		t.Errorf("sub-bucket lags: %+v", s)
+	}
+	tr.add(10 * 365 * 24 * time.Hour) // far past the last bucket
+	if s := tr.stats(); s.P95Seconds != s.MaxSeconds {
+		t.Errorf("p95 %v, want the max %v for a lag past the buckets", s.P95Seconds, s.MaxSeconds)
+	}
+}
+
+func TestReportRecordLag(t *testing.T) {
+	rep := NewReport()
+	rep.AddRecordLag(2 * time.Second)
+	rep.AddRecordLag(-time.Second)
+	snap := rep.Snapshot()
+	if snap.RecordLag.Records != 1 || snap.RecordLag.Future != 1 || snap.RecordLag.LastSeconds != 2 {
+		t.Errorf("record lag %+v", snap.RecordLag)
+	}
+	out := rep.Prometheus()
+	for _, want := range []string{"etl_record_lag_seconds 2\n", "etl_records_future_timestamp 1\n"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics missing %q", want)
+		}
+	}
+}
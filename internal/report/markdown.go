@@ -0,0 +1,148 @@
+package report
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"k8s-log-etl/internal/fsutil"
+)
+
+// markdownTopRows is how many rows a breakdown table shows before the
+// rest are folded into a "+N more" row.
+const markdownTopRows = 10
+
+// WriteMarkdown writes a Snapshot of the report as a compact Markdown
+// document, for posting as a PR comment or job summary: the headline
+// counts, the top levels and services, filter and DLQ reasons, redacted
+// keys, and stage timings.
+func (r *Report) WriteMarkdown(w io.Writer) error {
+	snap := r.Snapshot()
+	var sb strings.Builder
+
+	sb.WriteString("# ETL run report\n\n")
+	if snap.RunID != "" {
+		fmt.Fprintf(&sb, "Run `%s`", snap.RunID)
+		if snap.Hostname != "" {
+			fmt.Fprintf(&sb, " on `%s`", snap.Hostname)
+		}
+		if snap.BuildInfo.Version != "" {
+			fmt.Fprintf(&sb, ", etl %s", snap.BuildInfo.Version)
+		}
+		sb.WriteString("\n\n")
+	}
+
+	sb.WriteString("| Metric | Value |\n|---|---:|\n")
+	rows := []struct {
+		name  string
+		value string
+	}{
+		{"Lines read", strconv.Itoa(snap.TotalLines)},
+		{"JSON parsed", strconv.Itoa(snap.JSONParsed)},
+		{"JSON failed", strconv.Itoa(snap.JSONFailed)},
+		{"Normalized", strconv.Itoa(snap.NormalizedOK)},
+		{"Normalize failed", strconv.Itoa(snap.NormalizedFailed)},
+		{"Filtered", strconv.Itoa(sumCounts(snap.Filtered.ByReason))},
+		{"Written", strconv.Itoa(snap.WrittenOK)},
+		{"Write failed", strconv.Itoa(snap.WriteFailed)},
+		{"Dead-lettered", strconv.Itoa(snap.DLQWritten)},
+		{"Retries", strconv.Itoa(snap.RetryStats.TotalRetries)},
+		{"Duration", fmt.Sprintf("%.3fs", snap.DurationSeconds)},
+		{"Throughput", fmt.Sprintf("%.1f lines/s", snap.Throughput)},
+	}
+	for _, row := range rows {
+		fmt.Fprintf(&sb, "| %s | %s |\n", row.name, row.value)
+	}
+	if snap.Shutdown.Reason != "" && snap.Shutdown.Reason != StopEOF {
+		fmt.Fprintf(&sb, "| Stopped by | %s |\n", markdownCell(snap.Shutdown.Reason))
+	}
+
+	writeMarkdownCounts(&sb, "Levels", "Level", snap.ByLevel)
+	writeMarkdownCounts(&sb, "Services", "Service", snap.ByService)
+	writeMarkdownCounts(&sb, "Namespaces", "Namespace", snap.ByNamespace)
+	if len(snap.LabelsTruncated) > 0 {
+		fmt.Fprintf(&sb, "\nCounts by %s stop at %d values; records with any other value are counted under `%s`.\n",
+			strings.Join(snap.LabelsTruncated, ", "), snap.MaxLabelValues, OtherLabel)
+	}
+	writeMarkdownCounts(&sb, "Filter reasons", "Reason", snap.Filtered.ByReason)
+	writeMarkdownCounts(&sb, "Normalize failures", "Reason", snap.NormalizeFailuresByReason)
+	writeMarkdownCounts(&sb, "DLQ reasons", "Reason", snap.DLQReasons)
+	writeMarkdownCounts(&sb, "Redactions", "Key", snap.RedactionsByKey)
+
+	st := snap.StageTimings
+	if st.ParsingSeconds > 0 || st.NormalizationSeconds > 0 || st.FilteringSeconds > 0 || st.WritingSeconds > 0 {
+		sb.WriteString("\n## Stage timings\n\n| Stage | Seconds |\n|---|---:|\n")
+		fmt.Fprintf(&sb, "| Parsing | %.3f |\n", st.ParsingSeconds)
+		fmt.Fprintf(&sb, "| Normalization | %.3f |\n", st.NormalizationSeconds)
+		fmt.Fprintf(&sb, "| Filtering | %.3f |\n", st.FilteringSeconds)
+		fmt.Fprintf(&sb, "| Writing | %.3f |\n", st.WritingSeconds)
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// WriteMarkdownFile writes the Markdown report to path, created with
+// perm, or to stdout when path is "-".
+func (r *Report) WriteMarkdownFile(path string, perm fsutil.Perm) error {
+	if path == "" || path == "-" {
+		return r.WriteMarkdown(os.Stdout)
+	}
+	f, err := perm.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := r.WriteMarkdown(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeMarkdownCounts writes a section with the largest counts, most
+// frequent first, the rest summed up in a "+N more" row. Nothing is
+// written for an empty map.
+func writeMarkdownCounts(sb *strings.Builder, title, column string, counts map[string]int) {
+	if len(counts) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		return cmp.Or(cmp.Compare(counts[b], counts[a]), strings.Compare(a, b))
+	})
+	fmt.Fprintf(sb, "\n## %s\n\n| %s | Count |\n|---|---:|\n", title, column)
+	for _, k := range keys[:min(len(keys), markdownTopRows)] {
+		fmt.Fprintf(sb, "| %s | %d |\n", markdownCell(k), counts[k])
+	}
+	if rest := keys[min(len(keys), markdownTopRows):]; len(rest) > 0 {
+		sum := 0
+		for _, k := range rest {
+			sum += counts[k]
+		}
+		fmt.Fprintf(sb, "| +%d more | %d |\n", len(rest), sum)
+	}
+}
+
+// markdownCell makes s safe inside a table cell: pipes and backslashes
+// escaped, line breaks turned into spaces, and empty shown as (empty).
+func markdownCell(s string) string {
+	if s == "" {
+		return "(empty)"
+	}
+	return strings.NewReplacer(`\`, `\\`, "|", `\|`, "\r\n", " ", "\n", " ", "\r", " ").Replace(s)
+}
+
+func sumCounts(counts map[string]int) int {
+	n := 0
+	for _, c := range counts {
+		n += c
+	}
+	return n
+}
@@ -0,0 +1,70 @@
+package report
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMarkdownGolden(t *testing.T) {
+	rep := NewReport()
+	rep.RunID = "run-1"
+	rep.Hostname = "node-a"
+	rep.BuildInfo = BuildInfo{Version: "v1.2.3"}
+	rep.TotalLines = 40
+	rep.JSONParsed = 39
+	rep.JSONFailed = 1
+	rep.NormalizedOK = 37
+	rep.AddNormalizeFailure("missing_ts")
+	rep.AddNormalizeFailure("missing_ts")
+	rep.WrittenOK = 30
+	for i := range 12 {
+		for range i + 1 {
+			rep.AddService(fmt.Sprintf("svc-%02d", i))
+		}
+	}
+	rep.AddLevel("pipe|and\nnewline")
+	rep.AddLevel("ERROR")
+	rep.AddLevel("INFO")
+	rep.AddLevel("INFO")
+	rep.AddFiltered("level")
+	rep.AddDLQWithReason("write_timeout")
+	rep.DurationSeconds = 2
+	rep.Throughput = 20
+	rep.StageTimings.ParsingSeconds = 0.25
+	rep.StageTimings.WritingSeconds = 1.5
+	rep.Shutdown.Reason = StopSignal
+
+	var sb strings.Builder
+	if err := rep.WriteMarkdown(&sb); err != nil {
+		t.Fatal(err)
+	}
+	got := sb.String()
+	golden := filepath.Join("testdata", "report.md.golden")
+	if *update {
+		if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+			t.Fatalf("update golden: %v", err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("read golden (run with -update to create): %v", err)
+	}
+	if got != string(want) {
+		t.Errorf("Markdown output differs from %s (run with -update to accept):\n%s", golden, got)
+	}
+}
+
+func TestMarkdownEmptySectionsOmitted(t *testing.T) {
+	var sb strings.Builder
+	if err := NewReport().WriteMarkdown(&sb); err != nil {
+		t.Fatal(err)
+	}
+	for _, section := range []string{"## Levels", "## Services", "## DLQ reasons", "## Stage timings", "Stopped by"} {
+		if strings.Contains(sb.String(), section) {
+			t.Errorf("empty report has %q:\n%s", section, sb.String())
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Prometheus metric types for WriteFamily.
+const (
+	Counter   = "counter"
+	Gauge     = "gauge"
+	Histogram = "histogram"
+)
+
+// WriteFamily writes the # HELP and # TYPE lines that start a metric family.
+// All samples of the family must follow before the next family starts.
+func WriteFamily(w io.Writer, name, typ, help string) {
+	name = SanitizeName(name, true)
+	fmt.Fprintf(w, "# HELP %s %s\n", name, escapeHelp(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+// WriteSample writes one sample line. labels holds alternating label names
+// and values; names are sanitized and values escaped per the text
+// exposition format.
+func WriteSample(w io.Writer, name string, value float64, labels ...string) {
+	var sb strings.Builder
+	sb.WriteString(SanitizeName(name, true))
+	if len(labels) > 0 {
+		sb.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			sb.WriteString(SanitizeName(labels[i], false))
+			sb.WriteString(`="`)
+			sb.WriteString(escapeLabelValue(labels[i+1]))
+			sb.WriteByte('"')
+		}
+		sb.WriteByte('}')
+	}
+	sb.WriteByte(' ')
+	sb.WriteString(FormatValue(value))
+	sb.WriteByte('\n')
+	io.WriteString(w, sb.String())
+}
+
+// FormatValue renders a sample value: integers without a decimal point,
+// other values in the shortest exact form, and the special values as
+// +Inf, -Inf, and NaN.
+func FormatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// SanitizeName replaces every character that is not valid in a metric name
+// (or, when metric is false, a label name) with '_', and prefixes names
+// that begin with a digit.
+func SanitizeName(name string, metric bool) string {
+	if name == "" {
+		return "_"
+	}
+	var sb strings.Builder
+	for i, r := range name {
+		valid := r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') ||
+			(r >= '0' && r <= '9' && i > 0) || (metric && r == ':')
+		switch {
+		case valid:
+			sb.WriteRune(r)
+		case i == 0 && r >= '0' && r <= '9':
+			sb.WriteByte('_')
+			sb.WriteRune(r)
+		default:
+			sb.WriteByte('_')
+		}
+	}
+	return sb.String()
+}
+
+var (
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabelValue(v string) string {
+	return labelValueEscaper.Replace(strings.ToValidUTF8(v, "�"))
+}
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(strings.ToValidUTF8(help, "�"))
+}
+
+// writeCounts writes one sample per map entry, sorted by key. prefix holds
+// labels that precede label on every sample.
+func writeCounts(w io.Writer, name, label string, counts map[string]int, prefix ...string) {
+	for _, k := range sortedKeys(counts) {
+		WriteSample(w, name, float64(counts[k]), append(append([]string(nil), prefix...), label, k)...)
+	}
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
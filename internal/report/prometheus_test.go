@@ -0,0 +1,105 @@
+package report
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files")
+
+func TestPrometheusGolden(t *testing.T) {
+	rep := NewReport()
+	rep.BuildInfo = BuildInfo{Version: "v1.2.3", Commit: "abc123", Date: "2024-01-01", GoVersion: "go1.25"}
+	rep.TotalLines = 10
+	rep.JSONParsed = 9
+	rep.JSONFailed = 1
+	rep.NormalizedOK = 8
+	rep.AddNormalizeFailure("missing_ts")
+	for _, svc := range []string{`say "hi"`, `C:\logs`, "multi\nline", "café-服务", "bad\xffutf8", "plain"} {
+		rep.AddService(svc)
+	}
+	rep.AddLevel("ERROR")
+	rep.AddLevel("WARN")
+	rep.AddLevel("ERROR")
+	rep.AddFiltered("level")
+	rep.AddFiltered(`custom "reason"`)
+	rep.AddDLQWithReason("write_timeout")
+	rep.DurationSeconds = 2
+	rep.Throughput = 5
+	rep.JSONErrorRate = 0.1
+	rep.StageTimings.ParsingSeconds = 0.25
+	rep.InitTransforms([]string{"filter_redact", "exec"})
+	rep.AddTransformResult("filter_redact", 0, true, "level", "")
+	rep.AddTransformResult("exec", 0, false, "", "timeout")
+	rep.AddTransformErrorOutcome("exec", "dlq")
+	rep.AddCollector(collectorFunc(func(w io.Writer) {
+		WriteFamily(w, "etl_test_collector", Gauge, "A collector's help text with a \\ backslash\nand a newline.")
+		WriteSample(w, "etl_test_collector", 1.5, "1st-label", "x")
+	}))
+
+	got := rep.Prometheus()
+	golden := filepath.Join("testdata", "prometheus.golden")
+	if *update {
+		if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+			t.Fatalf("update golden: %v", err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("read golden (run with -update to create): %v", err)
+	}
+	if got != string(want) {
+		t.Errorf("Prometheus output differs from %s (run with -update to accept):\n%s", golden, got)
+	}
+	if second := rep.Prometheus(); second != got {
+		t.Errorf("Prometheus output is not deterministic")
+	}
+}
+
+func TestPrometheusFamiliesAreContiguous(t *testing.T) {
+	rep := NewReport()
+	rep.InitTransforms([]string{"a", "b"})
+	rep.AddService("api")
+	seen := map[string]bool{}
+	current := ""
+	for _, line := range strings.Split(strings.TrimSpace(rep.Prometheus()), "\n") {
+		if !strings.HasPrefix(line, "# TYPE ") {
+			continue
+		}
+		name := strings.Fields(line)[2]
+		if seen[name] && name != current {
+			t.Errorf("family %s appears in more than one block", name)
+		}
+		seen[name] = true
+		current = name
+	}
+}
+
+func TestSanitizeName(t *testing.T) {
+	cases := []struct {
+		in     string
+		metric bool
+		want   string
+	}{
+		{"service", false, "service"},
+		{"http.status-code", false, "http_status_code"},
+		{"1st", false, "_1st"},
+		{"ns:metric", true, "ns:metric"},
+		{"ns:label", false, "ns_label"},
+		{"naïve", false, "na_ve"},
+		{"", false, "_"},
+	}
+	for _, tc := range cases {
+		if got := SanitizeName(tc.in, tc.metric); got != tc.want {
+			t.Errorf("SanitizeName(%q, %v) = %q, want %q", tc.in, tc.metric, got, tc.want)
+		}
+	}
+}
+
+type collectorFunc func(io.Writer)
+
+func (f collectorFunc) WritePrometheus(w io.Writer) { f(w) }
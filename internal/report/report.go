@@ -1,96 +1,1383 @@
 package report
 
 import (
-	"encoding/json"
-	"fmt"
 	"io"
-	"os"
+	"maps"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"k8s-log-etl/internal/fsutil"
 )
 
-// Report aggregates ETL processing statistics.
+// Report aggregates ETL processing statistics. Its methods are safe for
+// concurrent use. The counters updated for every record (writes, error
+// details, stage timings, levels, services, and namespaces) are kept in
+// shards and reach the exported fields when the report syncs: in
+// SetDuration, WriteJSON, and Prometheus, or by calling Sync. A goroutine
+// counting many records takes its own Shard, so it shares no lock or
+// counter with the others.
 type Report struct {
-	TotalLines       int            `json:"total_lines"`
-	JSONFailed       int            `json:"json_failed"`
-	JSONParsed       int            `json:"json_parsed"`
-	NormalizedOK     int            `json:"normalized_ok"`
-	NormalizedFailed int            `json:"normalized_failed"`
-	WrittenOK        int            `json:"written_ok"`
-	WriteFailed      int            `json:"written_failed"`
-	ByLevel          map[string]int `json:"by_level"`
-	ByService        map[string]int `json:"by_service"`
-	Filtered         FilterStats    `json:"filtered"`
-	DLQWritten       int            `json:"dlq_written"`
-	DurationSeconds  float64        `json:"duration_seconds"`
-	Throughput       float64        `json:"throughput_lines_per_sec"`
-	JSONErrorRate    float64        `json:"json_error_rate"`
-	NormalizeErrRate float64        `json:"normalize_error_rate"`
-	WriteErrorRate   float64        `json:"write_error_rate"`
+	// Run provenance
+	RunID      string    `json:"run_id,omitempty"`
+	Hostname   string    `json:"hostname,omitempty"`
+	BuildInfo  BuildInfo `json:"build_info"`
+	TotalLines int       `json:"total_lines"`
+	// InputMode and InputFiles describe a run over several input files:
+	// InputConcat or InputMergeSorted, and the lines read from each.
+	InputMode  string      `json:"input_mode,omitempty"`
+	InputFiles []InputFile `json:"input_files,omitempty"`
+	JSONFailed int         `json:"json_failed"`
+	JSONParsed int         `json:"json_parsed"`
+	// RecordsExtracted counts JSON objects decoded from parsed lines. It
+	// exceeds JSONParsed when lines hold concatenated objects.
+	RecordsExtracted int `json:"records_extracted"`
+	// Unwrap counts records whose unwrap_keys payload was lifted out;
+	// zero when unwrap_keys is unset.
+	Unwrap           UnwrapStats `json:"unwrap"`
+	NormalizedOK     int         `json:"normalized_ok"`
+	NormalizedFailed int         `json:"normalized_failed"`
+	// NormalizeFailuresByReason breaks NormalizedFailed down by
+	// stages.NormalizeError code, e.g. "missing_ts".
+	NormalizeFailuresByReason map[string]int `json:"normalize_failures_by_reason"`
+	// WithError and WithStacktrace count normalized records carrying an
+	// error message or a stack trace.
+	WithError      int            `json:"with_error"`
+	WithStacktrace int            `json:"with_stacktrace"`
+	WrittenOK      int            `json:"written_ok"`
+	WriteFailed    int            `json:"written_failed"`
+	ByLevel        map[string]int `json:"by_level"`
+	ByService      map[string]int `json:"by_service"`
+	ByNamespace    map[string]int `json:"by_namespace,omitempty"`
+	// MaxLabelValues caps ByLevel, ByService, and ByNamespace at that many
+	// values each; records with any other value are counted under
+	// OtherLabel. Zero means no cap. LabelsTruncated lists the labels,
+	// "level", "service", or "namespace", whose cap was reached.
+	MaxLabelValues  int         `json:"max_label_values,omitempty"`
+	LabelsTruncated []string    `json:"labels_truncated,omitempty"`
+	Filtered        FilterStats `json:"filtered"`
+	DLQWritten      int         `json:"dlq_written"`
+	// Sanitized counts records whose message or fields sanitize_messages
+	// changed.
+	Sanitized int `json:"sanitized"`
+	// DistinctServices, DistinctNamespaces, DistinctPods, and
+	// DistinctTraceIDs estimate how many different values of each the
+	// normalized records carried, within about 2%, from fixed-size
+	// sketches rather than a map of every name. Unlike the counts they are
+	// replaced, not added to, when the report syncs.
+	DistinctServices   int `json:"distinct_services"`
+	DistinctNamespaces int `json:"distinct_namespaces"`
+	DistinctPods       int `json:"distinct_pods"`
+	DistinctTraceIDs   int `json:"distinct_trace_ids"`
+	// ManifestPath is where the output manifest is written, when enabled.
+	ManifestPath string `json:"manifest_path,omitempty"`
+	// DLQTruncated counts DLQ entries whose extra fields were dropped to
+	// fit dlq_max_record_bytes.
+	DLQTruncated int `json:"dlq_truncated"`
+	// DLQOverflow counts records that were not dead-lettered because the
+	// DLQ reached dlq_max_records or dlq_max_bytes. They are lost.
+	DLQOverflow      int     `json:"dlq_overflow"`
+	DurationSeconds  float64 `json:"duration_seconds"`
+	Throughput       float64 `json:"throughput_lines_per_sec"`
+	JSONErrorRate    float64 `json:"json_error_rate"`
+	NormalizeErrRate float64 `json:"normalize_error_rate"`
+	WriteErrorRate   float64 `json:"write_error_rate"`
 	// Per-stage timings in seconds
 	StageTimings StageTimings `json:"stage_timings"`
 	// Retry statistics
 	RetryStats RetryStats `json:"retry_stats"`
 	// DLQ reasons breakdown
 	DLQReasons map[string]int `json:"dlq_reasons"`
-	mu         sync.Mutex     `json:"-"`
+	// Per-transform statistics, in chain order
+	Transforms []TransformStats `json:"transforms"`
+	// TransformAudit counts how far records got through the transform
+	// chain; nil unless transform_audit is set.
+	TransformAudit *TransformAuditStats `json:"transform_audit,omitempty"`
+	// RedactionsByKey counts the records filter_redact removed each
+	// redact key from; keys never found are left out.
+	RedactionsByKey map[string]int `json:"redactions_by_key,omitempty"`
+	// Most frequent message templates; filled in by SetDuration
+	TopMessages []MessageCount `json:"top_messages,omitempty"`
+	// Shutdown records why the run stopped and what was still in flight.
+	Shutdown ShutdownStats `json:"shutdown"`
+	// Panic describes the panic that stopped the run, if one did.
+	Panic *PanicInfo `json:"panic,omitempty"`
+	// RuntimeStats holds Go memory numbers sampled during the run.
+	RuntimeStats RuntimeStats `json:"runtime_stats"`
+	// Sort describes the timestamp reordering stage; zero when sort_window
+	// is off.
+	Sort SortStats `json:"sort"`
+	// Limits records skip and head, which make a run cover only part of
+	// its input; zero when neither is set.
+	Limits LimitStats `json:"limits"`
+	// InFlight tracks the estimated bytes of records queued for the sink;
+	// zero when max_inflight_bytes is off.
+	InFlight InFlightStats `json:"inflight"`
+	// InputIdle tracks silences in the input; zero when
+	// input_idle_timeout is off.
+	InputIdle InputIdleStats `json:"input_idle"`
+	// RetryBudget tracks the backoff time spent against
+	// sink_retry_budget; zero when it is off.
+	RetryBudget RetryBudgetStats `json:"retry_budget"`
+	// RecordLag tracks how far behind their timestamps records were
+	// normalized.
+	RecordLag LagStats `json:"record_lag"`
+	// DropRules lists the drop_rules_file rules with their hits; nil
+	// unless drop_rules_file is set.
+	DropRules *DropRuleStats `json:"drop_rules,omitempty"`
+	// HTTPConnections counts the connections of the http sink; nil unless
+	// output_type is http.
+	HTTPConnections *HTTPConnStats `json:"http_connections,omitempty"`
+	// Spill tracks the records spooled to spill_dir; nil unless spill_dir
+	// is set.
+	Spill *SpillStats `json:"spill,omitempty"`
+	// Router counts the records of each route; nil unless output_type is
+	// router.
+	Router *RouterStats `json:"router,omitempty"`
+	// NATSInput counts the messages read from JetStream; nil unless
+	// input_type is nats.
+	NATSInput *NATSInputStats `json:"nats_input,omitempty"`
+	// JournaldInput counts the entries read from the systemd journal; nil
+	// unless input_type is journald.
+	JournaldInput *JournaldInputStats `json:"journald_input,omitempty"`
+	// DuplicateKeys counts input lines repeating a key within one object;
+	// nil unless strict_json is set.
+	DuplicateKeys *DuplicateKeyStats `json:"duplicate_keys,omitempty"`
+	// LargeLines counts the input lines past line_prune_bytes or
+	// max_line_bytes; nil unless there were any.
+	LargeLines *LargeLineStats `json:"large_lines,omitempty"`
+	// SchemaValidation counts the records failing json_schema_file; nil
+	// unless it is set.
+	SchemaValidation *SchemaStats `json:"schema_validation,omitempty"`
+	// QueueWait tracks how long records of each level class waited in the
+	// queue; nil unless queue_priority_by_level is set.
+	QueueWait *QueueWaitStats `json:"queue_wait,omitempty"`
+	// Faults counts the failures the faulty sink injected; nil unless
+	// output_type is faulty.
+	Faults *FaultStats `json:"faults,omitempty"`
+	// Quotas describes the namespace quotas; nil unless the
+	// namespace_quota transform is in the chain.
+	Quotas *QuotaStats `json:"quotas,omitempty"`
+	// TimestampShift describes the shift applied to the records'
+	// timestamps; nil unless the timestamp_shift transform is in the chain.
+	TimestampShift *TimestampShiftStats `json:"timestamp_shift,omitempty"`
+	// ReplayPacing describes how replay_speed spaced the writes; nil
+	// unless it is set.
+	ReplayPacing *ReplayPacingStats `json:"replay_pacing,omitempty"`
+	// OutputPaths lists the files an output path with placeholders
+	// resolved to; nil unless the output path has placeholders.
+	OutputPaths *OutputPathStats `json:"output_paths,omitempty"`
+	topTracker  *topMessages
+	collectors  []Collector
+	distinct    *distinctSet
+	lag         lagTracker
+	shard       *Shard       // used by the report's own Add methods
+	shards      []*Shard     // every shard, for sync
+	maxLabels   atomic.Int64 // MaxLabelValues, for shards to read without r.mu
+	onTruncate  func(label string)
+	mu          sync.Mutex `json:"-"`
+}
+
+// counters are the per-record counts, kept apart from the exported fields
+// so that updating them is a single atomic add.
+type counters struct {
+	writtenOK      atomic.Int64
+	writeFailed    atomic.Int64
+	withError      atomic.Int64
+	withStacktrace atomic.Int64
+	stageNanos     [4]atomic.Int64 // indexed by stageIndex
+}
+
+// stageIndex maps AddStageTiming's stage names to counters.stageNanos.
+func stageIndex(stage string) int {
+	switch stage {
+	case "parsing":
+		return 0
+	case "normalization":
+		return 1
+	case "filtering":
+		return 2
+	case "writing":
+		return 3
+	}
+	return -1
+}
+
+// Shard counts a goroutine's records apart from the report. Its counters
+// and lock are only shared with sync, so goroutines that each use their
+// own shard never wait on one another, short of a shard filling up with
+// MaxLabelValues values and merging itself into the report.
+type Shard struct {
+	hot      counters
+	mu       sync.Mutex
+	r        *Report
+	counts   [numLabels]map[string]int // indexed by label
+	distinct *distinctSet              // nil until AddDistinct
+}
+
+// OtherLabel is the value records are counted under once ByLevel,
+// ByService, or ByNamespace holds MaxLabelValues values.
+const OtherLabel = "__other__"
+
+// The labels a shard counts, indexing Shard.counts and labelNames.
+const (
+	labelLevel = iota
+	labelService
+	labelNamespace
+	numLabels
+)
+
+// labelNames are the labels' names in LabelsTruncated and Prometheus.
+var labelNames = [numLabels]string{"level", "service", "namespace"}
+
+// distinctSet holds a distinct sketch for each of service, namespace, pod,
+// and trace ID.
+type distinctSet struct {
+	services, namespaces, pods, traceIDs distinct
+}
+
+func (d *distinctSet) merge(o *distinctSet) {
+	d.services.merge(&o.services)
+	d.namespaces.merge(&o.namespaces)
+	d.pods.merge(&o.pods)
+	d.traceIDs.merge(&o.traceIDs)
+}
+
+// NewShard returns a shard whose counts are merged into the report
+// whenever it syncs.
+func (r *Report) NewShard() *Shard {
+	s := &Shard{r: r}
+	for i := range s.counts {
+		s.counts[i] = make(map[string]int)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.shards = append(r.shards, s)
+	return s
+}
+
+// AddWriteOK increments successful writes.
+func (s *Shard) AddWriteOK() {
+	s.hot.writtenOK.Add(1)
+}
+
+// AddWriteFailed increments failed writes.
+func (s *Shard) AddWriteFailed() {
+	s.hot.writeFailed.Add(1)
+}
+
+// AddErrorDetails counts a normalized record's error and stack trace.
+func (s *Shard) AddErrorDetails(hasError, hasStacktrace bool) {
+	if hasError {
+		s.hot.withError.Add(1)
+	}
+	if hasStacktrace {
+		s.hot.withStacktrace.Add(1)
+	}
+}
+
+// AddStageTiming adds time to a specific stage.
+func (s *Shard) AddStageTiming(stage string, duration time.Duration) {
+	if i := stageIndex(stage); i >= 0 {
+		s.hot.stageNanos[i].Add(int64(duration))
+	}
+}
+
+// AddLevel increments the count for a log level.
+func (s *Shard) AddLevel(level string) {
+	s.add(labelLevel, level)
+}
+
+// AddService increments the count for a service.
+func (s *Shard) AddService(service string) {
+	s.add(labelService, service)
+}
+
+// AddNamespace increments the count for a namespace.
+func (s *Shard) AddNamespace(namespace string) {
+	s.add(labelNamespace, namespace)
+}
+
+// add increments value's count for label. A shard holds no more values
+// per label than the report may: when a new value would pass that, the
+// shard is merged into the report first, which counts value under
+// OtherLabel if the report is full too. Memory stays bounded however many
+// values there are.
+func (s *Shard) add(label int, value string) {
+	if value == "" {
+		return
+	}
+	s.mu.Lock()
+	m, limit := s.counts[label], s.r.maxLabels.Load()
+	if _, ok := m[value]; ok || limit <= 0 || int64(len(m)) < limit {
+		m[value]++
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Unlock()
+	s.r.mu.Lock()
+	defer s.r.mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.r.mergeShard(s)
+	s.r.countLabel(label, value, 1)
+}
+
+// AddDistinct adds a record's service, namespace, pod, and trace ID to the
+// distinct counts. Empty values are not counted.
+func (s *Shard) AddDistinct(service, namespace, pod, traceID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.distinct == nil {
+		s.distinct = &distinctSet{}
+	}
+	for _, v := range [...]struct {
+		d *distinct
+		s string
+	}{{&s.distinct.services, service}, {&s.distinct.namespaces, namespace}, {&s.distinct.pods, pod}, {&s.distinct.traceIDs, traceID}} {
+		if v.s != "" {
+			v.d.add(v.s)
+		}
+	}
 }
 
+// Collector contributes extra metric lines to Prometheus output, e.g. values
+// a transform accumulates during the run. Implementations should write whole
+// families with WriteFamily and WriteSample.
+type Collector interface {
+	WritePrometheus(w io.Writer)
+}
+
+// BuildInfo identifies the binary that produced the report.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Date      string `json:"date"`
+	GoVersion string `json:"go_version"`
+}
+
+// FilterStats counts dropped records. Level and Service cover the built-in
+// filter rules; every other reason is counted in Other. ByReason has the
+// full breakdown, including reasons from custom transforms.
 type FilterStats struct {
-	Level   int `json:"by_level"`
-	Service int `json:"by_service"`
-	Other   int `json:"other"`
+	Level    int            `json:"by_level"`
+	Service  int            `json:"by_service"`
+	Other    int            `json:"other"`
+	ByReason map[string]int `json:"by_reason"`
 }
 
 // StageTimings tracks time spent in each pipeline stage.
 type StageTimings struct {
-	ParsingSeconds      float64 `json:"parsing_seconds"`
+	ParsingSeconds       float64 `json:"parsing_seconds"`
 	NormalizationSeconds float64 `json:"normalization_seconds"`
-	FilteringSeconds    float64 `json:"filtering_seconds"`
-	WritingSeconds      float64 `json:"writing_seconds"`
+	FilteringSeconds     float64 `json:"filtering_seconds"`
+	WritingSeconds       float64 `json:"writing_seconds"`
+}
+
+// TransformStats tracks one transform in the chain, keyed by its configured name.
+type TransformStats struct {
+	Name            string         `json:"name"`
+	RecordsIn       int            `json:"records_in"`
+	Dropped         int            `json:"dropped"`
+	DroppedByReason map[string]int `json:"dropped_by_reason"`
+	Errors          int            `json:"errors"`
+	ErrorsByKind    map[string]int `json:"errors_by_kind"`
+	// ErrorsByPolicy counts failed records by the on_error outcome applied.
+	ErrorsByPolicy map[string]int `json:"errors_by_policy"`
+	Seconds        float64        `json:"seconds"`
+}
+
+// TransformAuditStats sorts the records that reached the transform chain
+// by how far they got. A record the chain stopped early is counted under
+// the transform it stopped at, or drop_rule for one dropped before it.
+type TransformAuditStats struct {
+	// FullyEvaluated counts records every transform evaluated and kept.
+	FullyEvaluated int `json:"fully_evaluated"`
+	// PassedErrors counts records that reached the end of the chain past
+	// one or more failed transforms with on_error pass.
+	PassedErrors     int            `json:"passed_errors"`
+	ShortCircuited   int            `json:"short_circuited"`
+	ShortCircuitedBy map[string]int `json:"short_circuited_by"`
+}
+
+// RuntimeStats summarizes runtime.MemStats samples taken during the run.
+// TotalAllocBytes and NumGC count from the start of the run.
+type RuntimeStats struct {
+	PeakHeapBytes   uint64 `json:"peak_heap_bytes"`
+	TotalAllocBytes uint64 `json:"total_alloc_bytes"`
+	NumGC           uint32 `json:"num_gc"`
+	Samples         int    `json:"samples"`
+}
+
+// UnwrapStats counts what the unwrap step did with records.
+type UnwrapStats struct {
+	JSON int `json:"json"` // JSON payloads merged into the record
+	Text int `json:"text"` // plain text payloads used as the message
+	// Failed counts payloads that looked like JSON but did not parse; the
+	// records went on unchanged.
+	Failed int `json:"failed"`
+}
+
+// InFlightStats describes the max_inflight_bytes cap. Sizes are the
+// estimates of model.Normalized.ApproxSize.
+type InFlightStats struct {
+	MaxBytes     int64 `json:"max_bytes"`
+	CurrentBytes int64 `json:"current_bytes"` // held now; at the end, left unwritten
+	PeakBytes    int64 `json:"peak_bytes"`
+	// Waits counts records the reader held back until enough bytes were
+	// written.
+	Waits int `json:"waits"`
+}
+
+// InputIdleStats describes waits for input under input_idle_timeout.
+type InputIdleStats struct {
+	TimeoutSeconds float64 `json:"timeout_seconds"`
+	// IdleSeconds is how long the input has been silent: 0 once a record
+	// arrives, and at the end, how long the run waited for one.
+	IdleSeconds    float64 `json:"idle_seconds"`
+	MaxIdleSeconds float64 `json:"max_idle_seconds"` // longest wait for a record
+	// Warnings counts the times the timeout passed without a record.
+	Warnings int `json:"warnings"`
+	// Exited is true when input_idle_action exit ended the run.
+	Exited bool `json:"exited"`
+}
+
+// RetryBudgetStats describes the backoff time the run's writes spent
+// against sink_retry_budget.
+type RetryBudgetStats struct {
+	BudgetSeconds float64 `json:"budget_seconds"`
+	WindowSeconds float64 `json:"window_seconds"`
+	SpentSeconds  float64 `json:"spent_seconds"` // over the whole run
+	// Exhausted is whether the current window's budget is spent;
+	// ExhaustedWindows counts the windows whose budget ran out.
+	Exhausted        bool `json:"exhausted"`
+	ExhaustedWindows int  `json:"exhausted_windows"`
+	// ExhaustedLine is the input line whose failed write first found the
+	// budget spent.
+	ExhaustedLine int `json:"exhausted_line,omitempty"`
+	// WritesNotRetried counts failed writes left without a retry because
+	// the budget was spent.
+	WritesNotRetried int `json:"writes_not_retried"`
+}
+
+// SpillStats describes the spill queue in spill_dir. Records spooled but
+// not drained by the end of the run are still pending and are drained by
+// the next run with the same spill_dir.
+type SpillStats struct {
+	Dir string `json:"dir"`
+	// Spilled counts records spooled after every retry failed, Drained
+	// those later written to the sink from the spool, and Overflow those
+	// dead-lettered because spill_max_bytes was reached.
+	Spilled  int `json:"spilled"`
+	Drained  int `json:"drained"`
+	Overflow int `json:"overflow"`
+	// Resumed counts the records found in spill_dir at the start of the
+	// run, left by an earlier one.
+	Resumed        int   `json:"resumed"`
+	PendingRecords int   `json:"pending_records"`
+	PendingBytes   int64 `json:"pending_bytes"`
+}
+
+// RouterStats describes the routes of output type router, in the order
+// they were configured.
+type RouterStats struct {
+	Routes  []RouteStats `json:"routes"`
+	Default string       `json:"default,omitempty"`
+	// Unroutable counts records no rule matched while there was no
+	// default route; they were dead-lettered as unroutable.
+	Unroutable int `json:"unroutable"`
+}
+
+// NATSInputStats describes the messages input type nats read from its
+// consumer.
+type NATSInputStats struct {
+	Stream   string `json:"stream"`
+	Consumer string `json:"consumer"`
+	Received int    `json:"received"`
+	// Acked counts messages acknowledged once their records were written
+	// or dead-lettered; the rest are redelivered to a later run.
+	Acked   int `json:"acked"`
+	Unacked int `json:"unacked"`
+	// Redelivered counts messages the server had delivered before, and
+	// MaxDeliveries is the most times one message was delivered.
+	Redelivered   int `json:"redelivered"`
+	MaxDeliveries int `json:"max_deliveries"`
+}
+
+// JournaldInputStats describes the entries input type journald read
+// from journalctl.
+type JournaldInputStats struct {
+	Units []string `json:"units,omitempty"`
+	Read  int      `json:"read"`
+	// Acked counts entries whose records were written, dead-lettered, or
+	// dropped on purpose; the cursor only moves past those.
+	Acked   int `json:"acked"`
+	Unacked int `json:"unacked"`
+	// ResumedFrom is the cursor journald_cursor_file held at the start,
+	// and Cursor the one last saved there; both are empty without one.
+	ResumedFrom string `json:"resumed_from,omitempty"`
+	Cursor      string `json:"cursor,omitempty"`
+}
+
+// RouteStats counts the records handed to a route's sink, batched ones
+// included, and its failed write attempts.
+type RouteStats struct {
+	Name    string `json:"name"`
+	Output  string `json:"output"`
+	Records int    `json:"records"`
+	Failed  int    `json:"failed"`
+	// BatchSize and MaxRetries are the route's settings, from
+	// router_route_options or the top level. Retries counts the retries of
+	// its records' writes and DeadLettered those that went to the DLQ.
+	BatchSize    int `json:"batch_size"`
+	MaxRetries   int `json:"max_retries"`
+	Retries      int `json:"retries"`
+	DeadLettered int `json:"dead_lettered"`
+}
+
+// DuplicateKeyStats counts the lines strict_json found with a duplicate
+// key. ByKey is keyed by the first duplicate of each line, as a dotted
+// path.
+type DuplicateKeyStats struct {
+	Lines        int            `json:"lines"`
+	ByKey        map[string]int `json:"by_key"`
+	DeadLettered int            `json:"dead_lettered"`
+}
+
+// LargeLineStats counts the JSONL lines too long to hold. Pruned lines
+// kept only the keys normalization reads; TooLong lines were skipped and
+// are also counted as json_failed. DroppedKeys counts the keys pruning
+// left out, and LargestBytes is the longest line seen.
+type LargeLineStats struct {
+	PruneBytes   int   `json:"prune_bytes"`
+	MaxBytes     int   `json:"max_bytes,omitempty"`
+	Pruned       int   `json:"pruned"`
+	TooLong      int   `json:"too_long"`
+	DroppedKeys  int   `json:"dropped_keys"`
+	LargestBytes int64 `json:"largest_bytes"`
+}
+
+// MaxSchemaViolations caps the distinct violations SchemaStats.ByViolation
+// keeps, so records failing in many different places cannot grow it
+// without bound.
+const MaxSchemaViolations = 100
+
+// SchemaStats counts the records json_schema_file found invalid, and what
+// schema_action did with them. ByViolation is keyed by the failing keyword
+// and a JSON pointer into the record, "type at /level"; past
+// MaxSchemaViolations distinct keys, violations are counted in
+// OtherViolations.
+type SchemaStats struct {
+	File            string         `json:"file"`
+	Action          string         `json:"action"`
+	Checked         int            `json:"checked"`
+	Invalid         int            `json:"invalid"`
+	ByViolation     map[string]int `json:"by_violation"`
+	OtherViolations int            `json:"other_violations"`
+	Dropped         int            `json:"dropped"`
+	DeadLettered    int            `json:"dead_lettered"`
+}
+
+// AddViolation counts one violation by key.
+func (s *SchemaStats) AddViolation(key string) {
+	if s.ByViolation == nil {
+		s.ByViolation = make(map[string]int)
+	}
+	if _, ok := s.ByViolation[key]; !ok && len(s.ByViolation) >= MaxSchemaViolations {
+		s.OtherViolations++
+		return
+	}
+	s.ByViolation[key]++
+}
+
+// QueueWaitStats lists the queue waits of the priority classes of
+// queue_priority_by_level, highest first.
+type QueueWaitStats struct {
+	Classes []QueueClassWait `json:"classes"`
+}
+
+// QueueClassWait is the time records of one class spent between being
+// queued and a worker taking them.
+type QueueClassWait struct {
+	Class          string  `json:"class"`
+	Records        int     `json:"records"`
+	AvgWaitSeconds float64 `json:"avg_wait_seconds"`
+	MaxWaitSeconds float64 `json:"max_wait_seconds"`
+}
+
+// DropRuleStats describes the rules of drop_rules_file. Rules lists every
+// rule of the file as last loaded, so those that never match show up with
+// zero hits and can be pruned.
+type DropRuleStats struct {
+	File    string        `json:"file"`
+	Reloads int           `json:"reloads"`
+	Rules   []DropRuleHit `json:"rules"`
+}
+
+// DropRuleHit is a drop rule, by its line in the file, and the records it
+// dropped. Hits of a rule kept across a reload include those from before.
+type DropRuleHit struct {
+	Line    int    `json:"line"`
+	Pattern string `json:"pattern"`
+	Hits    int64  `json:"hits"`
+}
+
+// FaultStats counts the write failures the faulty sink injected. A failure
+// matching several faults counts once, under the first of AfterLimit,
+// EveryNth, and Random.
+type FaultStats struct {
+	Attempts   int `json:"attempts"` // write attempts, retries included
+	Injected   int `json:"injected"` // attempts failed on purpose
+	Random     int `json:"random"`
+	EveryNth   int `json:"every_nth"`
+	AfterLimit int `json:"after_limit"`
+	Delayed    int `json:"delayed"` // attempts held back by the injected latency
+}
+
+// QuotaStats describes the quotas of the namespace_quota transform.
+type QuotaStats struct {
+	// IntervalSeconds is how often the quotas start afresh; 0 when they
+	// last the whole run.
+	IntervalSeconds float64               `json:"interval_seconds"`
+	Overflow        string                `json:"overflow"`
+	Namespaces      []NamespaceQuotaStats `json:"namespaces"`
+}
+
+// NamespaceQuotaStats is one namespace's use of its quota over the run.
+// Records and Bytes count what the quota let through, Sampled included.
+type NamespaceQuotaStats struct {
+	Namespace  string `json:"namespace"`
+	MaxRecords int64  `json:"max_records,omitempty"`
+	MaxBytes   int64  `json:"max_bytes,omitempty"`
+	Records    int64  `json:"records"`
+	Bytes      int64  `json:"bytes"`
+	Dropped    int64  `json:"dropped"`
+	Sampled    int64  `json:"sampled"`
+	// FirstCappedAt is when the namespace first went over its quota, RFC
+	// 3339; Caps counts the intervals in which it did.
+	FirstCappedAt string `json:"first_capped_at,omitempty"`
+	Caps          int    `json:"caps"`
+}
+
+// TimestampShiftStats describes the timestamp_shift transform.
+type TimestampShiftStats struct {
+	// Mode is fixed or rebase-now. Shift is the duration added to every
+	// timestamp, as Go writes it, and ShiftSeconds the same in seconds.
+	Mode         string  `json:"mode"`
+	Shift        string  `json:"shift"`
+	ShiftSeconds float64 `json:"shift_seconds"`
+	// Latest is the input's latest timestamp before the shift, RFC 3339,
+	// which rebase-now moved to the start of the run.
+	Latest string `json:"latest,omitempty"`
+	// Shifted counts the records whose timestamp was moved; Skipped those
+	// without a usable timestamp or already shifted, left as they were.
+	Shifted int64 `json:"shifted"`
+	Skipped int64 `json:"skipped"`
+}
+
+// OutputPathStats describes the files an output path template resolved to.
+type OutputPathStats struct {
+	Template string `json:"template"`
+	MaxOpen  int    `json:"max_open"`
+	// Created lists every resolved path, sorted; rotated files are in the
+	// manifest's file list, not here.
+	Created []string `json:"created"`
+	// Opens counts files opened or reopened, and Evictions files closed to
+	// keep at most MaxOpen open.
+	Opens     int64 `json:"opens"`
+	Evictions int64 `json:"evictions"`
+}
+
+// ReplayPacingStats describes the pacing of sink writes by replay_speed.
+type ReplayPacingStats struct {
+	Speed           float64 `json:"speed"`
+	MaxSleepSeconds float64 `json:"max_sleep_seconds"`
+	// Records counts the records paced, and Unpaced those without a
+	// usable timestamp, written without waiting.
+	Records int64 `json:"records"`
+	Unpaced int64 `json:"unpaced"`
+	// SpanSeconds is the time between the first and the latest record's
+	// timestamps, and DurationSeconds the time from writing the first to
+	// writing the last: the effective replay duration. EffectiveSpeed is
+	// their ratio, below Speed when the sink or a capped wait held the
+	// replay back, and 0 until it can be measured.
+	SpanSeconds     float64 `json:"span_seconds"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	EffectiveSpeed  float64 `json:"effective_speed"`
+	SleptSeconds    float64 `json:"slept_seconds"`
+	// Capped counts the waits cut short at max_sleep_seconds.
+	Capped int64 `json:"capped"`
+}
+
+// HTTPConnStats counts the connections the http sink's requests used. A
+// high New against Reused means the pool is too small for the workers.
+type HTTPConnStats struct {
+	Requests      int `json:"requests"` // attempts, retries included
+	New           int `json:"new"`
+	Reused        int `json:"reused"`
+	DNSLookups    int `json:"dns_lookups"`
+	TLSHandshakes int `json:"tls_handshakes"`
+}
+
+// Input modes for Report.InputMode.
+const (
+	InputConcat      = "concat"       // files read one after another
+	InputMergeSorted = "merge_sorted" // files k-way merged by timestamp
+)
+
+// InputFile is one input of a multi-file run.
+type InputFile struct {
+	Path  string `json:"path"`
+	Lines int    `json:"lines"`
+	// Merged is false for files that were concatenated after the merge
+	// because their first line had no usable timestamp.
+	Merged bool `json:"merged"`
+}
+
+// SortStats summarizes the sort_window reordering stage.
+type SortStats struct {
+	WindowSeconds float64 `json:"window_seconds"`
+	// LateRecords arrived more than the window behind the newest timestamp
+	// seen and were written immediately, out of order.
+	LateRecords int `json:"late_records"`
+	// Overflow counts records written early because sort_max_records were
+	// already buffered.
+	Overflow    int `json:"overflow"`
+	MaxBuffered int `json:"max_buffered"`
+}
+
+// LimitStats describes the skip, head, and max_duration limits of a run.
+type LimitStats struct {
+	Skip int `json:"skip"`
+	Head int `json:"head"`
+	// SkippedLines counts non-empty lines ignored because of Skip. It is
+	// less than Skip when the input is shorter.
+	SkippedLines int `json:"skipped_lines"`
+	// HeadReached is true when the run stopped after Head records.
+	HeadReached        bool    `json:"head_reached"`
+	MaxDurationSeconds float64 `json:"max_duration_seconds,omitempty"`
+	// MaxDurationReached is true when the run stopped reading because it
+	// had run for max_duration.
+	MaxDurationReached bool `json:"max_duration_reached,omitempty"`
+	// LastLine is the number of the last non-empty input line the run
+	// processed, counting skipped lines. A run given skip LastLine picks
+	// up after it.
+	LastLine int `json:"last_line,omitempty"`
+}
+
+// Stop reasons for ShutdownStats.Reason.
+const (
+	StopEOF      = "eof"          // input exhausted
+	StopHead     = "head"         // head records were enqueued; drained like eof
+	StopDuration = "max_duration" // the run reached max_duration; drained like eof
+	StopSignal   = "signal"       // context cancelled, e.g. SIGTERM
+	StopTimeout  = "timeout"      // workers did not finish within the shutdown timeout
+	StopError    = "error"        // input error or on_error=abort
+	StopIdle     = "idle"         // no input for input_idle_timeout, with input_idle_action exit
+	StopDiskFull = "disk_full"    // the output disk filled up
+	StopPanic    = "panic"        // the reader or a sink worker panicked
+	StopStalled  = "stalled"      // a sink worker held one record past stall_warn_after, with stall_action abort
+)
+
+// PanicInfo is a panic recovered in the pipeline outside a transform.
+type PanicInfo struct {
+	// Where is "reader" or "worker N".
+	Where   string `json:"where"`
+	Message string `json:"message"`
+	Stack   string `json:"stack"`
+}
+
+// ShutdownStats is a snapshot of the pipeline taken when it stopped.
+type ShutdownStats struct {
+	Reason string `json:"reason"`
+	// LinesNotEnqueued counts lines read from the input that never reached
+	// the worker queue because the run was stopping.
+	LinesNotEnqueued int `json:"lines_not_enqueued"`
+	// QueueRemaining is how many records were still queued when the
+	// workers stopped or were abandoned.
+	QueueRemaining int            `json:"queue_remaining"`
+	Workers        []WorkerStatus `json:"workers"`
+}
+
+// WorkerStatus is one sink worker's progress at shutdown.
+type WorkerStatus struct {
+	ID        int  `json:"id"`
+	Processed int  `json:"processed"`
+	InFlight  bool `json:"in_flight"`
+	// InFlightLine is the input line being written, when InFlight, and
+	// the others identify its record.
+	InFlightLine    int    `json:"in_flight_line,omitempty"`
+	InFlightService string `json:"in_flight_service,omitempty"`
+	InFlightPod     string `json:"in_flight_pod,omitempty"`
+	InFlightTraceID string `json:"in_flight_trace_id,omitempty"`
+	// LastActive is when the worker last took or finished a record,
+	// RFC 3339; empty if it never has.
+	LastActive string `json:"last_active,omitempty"`
+	// Stalls counts the times the worker held one record past
+	// stall_warn_after.
+	Stalls int `json:"stalls,omitempty"`
 }
 
 // RetryStats tracks retry attempts for sink writes.
 type RetryStats struct {
-	TotalRetries      int `json:"total_retries"`
-	WritesWithRetries int `json:"writes_with_retries"`
+	TotalRetries       int `json:"total_retries"`
+	WritesWithRetries  int `json:"writes_with_retries"`
 	MaxRetriesPerWrite int `json:"max_retries_per_write"`
+	// WriteTimeouts counts write attempts that exceeded sink_write_timeout_ms.
+	WriteTimeouts int `json:"write_timeouts"`
 }
 
 // NewReport initializes a Report with maps ready to use.
 func NewReport() *Report {
-	return &Report{
-		ByLevel:   make(map[string]int),
-		ByService: make(map[string]int),
-		DLQReasons: make(map[string]int),
+	r := &Report{
+		ByLevel:     make(map[string]int),
+		ByService:   make(map[string]int),
+		ByNamespace: make(map[string]int),
+		DLQReasons:  make(map[string]int),
+		Filtered:    FilterStats{ByReason: make(map[string]int)},
+
+		NormalizeFailuresByReason: make(map[string]int),
 	}
+	r.shard = r.NewShard()
+	return r
 }
 
-// AddLevel increments the count for a log level.
-func (r *Report) AddLevel(level string) {
+// Sync moves the shards' counts into the exported fields. Fields set
+// directly are added to, not replaced.
+func (r *Report) Sync() {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	if level == "" {
+	r.sync()
+}
+
+// sync is Sync for callers holding r.mu. Each count is taken and zeroed
+// in one step, so concurrent updates are never lost or counted twice.
+func (r *Report) sync() {
+	stages := [...]*float64{
+		&r.StageTimings.ParsingSeconds,
+		&r.StageTimings.NormalizationSeconds,
+		&r.StageTimings.FilteringSeconds,
+		&r.StageTimings.WritingSeconds,
+	}
+	for _, s := range r.shards {
+		r.WrittenOK += int(s.hot.writtenOK.Swap(0))
+		r.WriteFailed += int(s.hot.writeFailed.Swap(0))
+		r.WithError += int(s.hot.withError.Swap(0))
+		r.WithStacktrace += int(s.hot.withStacktrace.Swap(0))
+		for i, sec := range stages {
+			*sec += time.Duration(s.hot.stageNanos[i].Swap(0)).Seconds()
+		}
+		s.mu.Lock()
+		r.mergeShard(s)
+		s.mu.Unlock()
+	}
+	if r.lag.count > 0 || r.lag.future > 0 {
+		r.RecordLag = r.lag.stats()
+	}
+	if r.distinct != nil {
+		r.DistinctServices = r.distinct.services.estimate()
+		r.DistinctNamespaces = r.distinct.namespaces.estimate()
+		r.DistinctPods = r.distinct.pods.estimate()
+		r.DistinctTraceIDs = r.distinct.traceIDs.estimate()
+	}
+}
+
+// mergeShard moves s's label and distinct counts into the report. The
+// caller holds r.mu and s.mu.
+func (r *Report) mergeShard(s *Shard) {
+	for label, m := range s.counts {
+		for k, n := range m {
+			r.countLabel(label, k, n)
+		}
+		clear(m)
+	}
+	if s.distinct != nil {
+		if r.distinct == nil {
+			r.distinct = &distinctSet{}
+		}
+		r.distinct.merge(s.distinct)
+		clear(s.distinct.services.regs[:])
+		clear(s.distinct.namespaces.regs[:])
+		clear(s.distinct.pods.regs[:])
+		clear(s.distinct.traceIDs.regs[:])
+	}
+}
+
+// labelCounts returns the exported map a label is counted in.
+func (r *Report) labelCounts(label int) map[string]int {
+	switch label {
+	case labelLevel:
+		return r.ByLevel
+	case labelService:
+		return r.ByService
+	}
+	return r.ByNamespace
+}
+
+// countLabel adds n to value's count for label, or to OtherLabel's when
+// value is new and the label already has MaxLabelValues values. The
+// caller holds r.mu.
+func (r *Report) countLabel(label int, value string, n int) {
+	m := r.labelCounts(label)
+	if _, ok := m[value]; ok || r.MaxLabelValues <= 0 {
+		m[value] += n
 		return
 	}
-	r.ByLevel[level]++
+	held := len(m)
+	if _, ok := m[OtherLabel]; ok {
+		held--
+	}
+	if held < r.MaxLabelValues {
+		m[value] += n
+		return
+	}
+	m[OtherLabel] += n
+	name := labelNames[label]
+	if !slices.Contains(r.LabelsTruncated, name) {
+		r.LabelsTruncated = append(r.LabelsTruncated, name)
+		slices.Sort(r.LabelsTruncated)
+		if r.onTruncate != nil {
+			r.onTruncate(name)
+		}
+	}
+}
+
+// Snapshot syncs the report and returns a deep copy of its exported
+// fields, for readers such as a metrics endpoint while the pipeline keeps
+// counting. The copy shares the report's collectors.
+func (r *Report) Snapshot() *Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sync()
+	c := NewReport()
+	c.RunID, c.Hostname, c.BuildInfo = r.RunID, r.Hostname, r.BuildInfo
+	c.TotalLines = r.TotalLines
+	c.InputMode, c.InputFiles = r.InputMode, slices.Clone(r.InputFiles)
+	c.JSONFailed, c.JSONParsed, c.RecordsExtracted = r.JSONFailed, r.JSONParsed, r.RecordsExtracted
+	c.Unwrap = r.Unwrap
+	c.NormalizedOK, c.NormalizedFailed = r.NormalizedOK, r.NormalizedFailed
+	c.NormalizeFailuresByReason = maps.Clone(r.NormalizeFailuresByReason)
+	c.WithError, c.WithStacktrace, c.Sanitized = r.WithError, r.WithStacktrace, r.Sanitized
+	c.DistinctServices, c.DistinctNamespaces, c.DistinctPods, c.DistinctTraceIDs = r.DistinctServices, r.DistinctNamespaces, r.DistinctPods, r.DistinctTraceIDs
+	c.WrittenOK, c.WriteFailed = r.WrittenOK, r.WriteFailed
+	c.ByLevel, c.ByService, c.ByNamespace = maps.Clone(r.ByLevel), maps.Clone(r.ByService), maps.Clone(r.ByNamespace)
+	c.MaxLabelValues, c.LabelsTruncated = r.MaxLabelValues, slices.Clone(r.LabelsTruncated)
+	c.Filtered = r.Filtered
+	c.Filtered.ByReason = maps.Clone(r.Filtered.ByReason)
+	c.DLQWritten, c.ManifestPath, c.DLQTruncated, c.DLQOverflow = r.DLQWritten, r.ManifestPath, r.DLQTruncated, r.DLQOverflow
+	c.DurationSeconds, c.Throughput = r.DurationSeconds, r.Throughput
+	c.JSONErrorRate, c.NormalizeErrRate, c.WriteErrorRate = r.JSONErrorRate, r.NormalizeErrRate, r.WriteErrorRate
+	c.StageTimings, c.RetryStats = r.StageTimings, r.RetryStats
+	c.DLQReasons = maps.Clone(r.DLQReasons)
+	c.RedactionsByKey = maps.Clone(r.RedactionsByKey)
+	c.Transforms = slices.Clone(r.Transforms)
+	for i := range c.Transforms {
+		ts := &c.Transforms[i]
+		ts.DroppedByReason = maps.Clone(ts.DroppedByReason)
+		ts.ErrorsByKind = maps.Clone(ts.ErrorsByKind)
+		ts.ErrorsByPolicy = maps.Clone(ts.ErrorsByPolicy)
+	}
+	if r.TransformAudit != nil {
+		a := *r.TransformAudit
+		a.ShortCircuitedBy = maps.Clone(a.ShortCircuitedBy)
+		c.TransformAudit = &a
+	}
+	c.TopMessages = slices.Clone(r.TopMessages)
+	c.Shutdown = r.Shutdown
+	c.Shutdown.Workers = slices.Clone(r.Shutdown.Workers)
+	if r.Panic != nil {
+		p := *r.Panic
+		c.Panic = &p
+	}
+	c.RuntimeStats, c.Sort, c.Limits, c.InFlight, c.InputIdle = r.RuntimeStats, r.Sort, r.Limits, r.InFlight, r.InputIdle
+	c.RetryBudget, c.RecordLag = r.RetryBudget, r.RecordLag
+	if r.DropRules != nil {
+		d := *r.DropRules
+		d.Rules = slices.Clone(d.Rules)
+		c.DropRules = &d
+	}
+	if r.HTTPConnections != nil {
+		h := *r.HTTPConnections
+		c.HTTPConnections = &h
+	}
+	if r.Spill != nil {
+		s := *r.Spill
+		c.Spill = &s
+	}
+	if r.Router != nil {
+		rt := *r.Router
+		rt.Routes = slices.Clone(rt.Routes)
+		c.Router = &rt
+	}
+	if r.NATSInput != nil {
+		n := *r.NATSInput
+		c.NATSInput = &n
+	}
+	if r.JournaldInput != nil {
+		j := *r.JournaldInput
+		j.Units = slices.Clone(j.Units)
+		c.JournaldInput = &j
+	}
+	if r.DuplicateKeys != nil {
+		d := *r.DuplicateKeys
+		d.ByKey = maps.Clone(d.ByKey)
+		c.DuplicateKeys = &d
+	}
+	if r.LargeLines != nil {
+		l := *r.LargeLines
+		c.LargeLines = &l
+	}
+	if r.SchemaValidation != nil {
+		s := *r.SchemaValidation
+		s.ByViolation = maps.Clone(s.ByViolation)
+		c.SchemaValidation = &s
+	}
+	if r.QueueWait != nil {
+		q := *r.QueueWait
+		q.Classes = slices.Clone(q.Classes)
+		c.QueueWait = &q
+	}
+	if r.Faults != nil {
+		f := *r.Faults
+		c.Faults = &f
+	}
+	if r.Quotas != nil {
+		q := *r.Quotas
+		q.Namespaces = slices.Clone(q.Namespaces)
+		c.Quotas = &q
+	}
+	if r.TimestampShift != nil {
+		t := *r.TimestampShift
+		c.TimestampShift = &t
+	}
+	if r.ReplayPacing != nil {
+		p := *r.ReplayPacing
+		c.ReplayPacing = &p
+	}
+	if r.OutputPaths != nil {
+		o := *r.OutputPaths
+		o.Created = slices.Clone(o.Created)
+		c.OutputPaths = &o
+	}
+	c.collectors = slices.Clone(r.collectors)
+	return c
+}
+
+// AddLine counts a non-empty input line.
+func (r *Report) AddLine() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.TotalLines++
+}
+
+// AddJSONParsed counts a line parsed into records JSON objects.
+func (r *Report) AddJSONParsed(records int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.JSONParsed++
+	r.RecordsExtracted += records
+}
+
+// AddJSONFailed counts a line that was not valid JSON.
+func (r *Report) AddJSONFailed() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.JSONFailed++
+}
+
+// AddUnwrap adds to the unwrap counts.
+func (r *Report) AddUnwrap(s UnwrapStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Unwrap.JSON += s.JSON
+	r.Unwrap.Text += s.Text
+	r.Unwrap.Failed += s.Failed
+}
+
+// AddNormalizedOK counts a record that normalized.
+func (r *Report) AddNormalizedOK() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.NormalizedOK++
+}
+
+// AddSanitized counts a record whose text sanitize_messages changed.
+func (r *Report) AddSanitized() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Sanitized++
+}
+
+// AddNormalizedFailed counts a record dropped after normalization, e.g.
+// by a transform error. Normalization failures themselves are counted by
+// AddNormalizeFailure.
+func (r *Report) AddNormalizedFailed() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.NormalizedFailed++
+}
+
+// AddRuntimeSample folds one memory sample into RuntimeStats. totalAlloc
+// and numGC are run totals so far, so the latest sample wins.
+func (r *Report) AddRuntimeSample(heapAlloc, totalAlloc uint64, numGC uint32) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if heapAlloc > r.RuntimeStats.PeakHeapBytes {
+		r.RuntimeStats.PeakHeapBytes = heapAlloc
+	}
+	r.RuntimeStats.TotalAllocBytes = totalAlloc
+	r.RuntimeStats.NumGC = numGC
+	r.RuntimeStats.Samples++
+}
+
+// SetRunID records the run's ID.
+func (r *Report) SetRunID(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.RunID = id
+}
+
+// SetManifestPath records where the output manifest is written.
+func (r *Report) SetManifestPath(path string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ManifestPath = path
+}
+
+// SetInputs records how several input files were read.
+func (r *Report) SetInputs(mode string, files []InputFile) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.InputMode = mode
+	r.InputFiles = files
+}
+
+// SetLimits records the run's skip, head, and max_duration limits.
+func (r *Report) SetLimits(l LimitStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Limits = l
+}
+
+// SetInFlight records the in-flight byte counts.
+func (r *Report) SetInFlight(s InFlightStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.InFlight = s
+}
+
+// AddRecordLag counts a record normalized lag after its timestamp. A
+// negative lag counts the record as timestamped in the future.
+func (r *Report) AddRecordLag(lag time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lag.add(lag)
+}
+
+// SetRetryBudget records the retry budget counts.
+func (r *Report) SetRetryBudget(s RetryBudgetStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.RetryBudget = s
+}
+
+// SetInputIdle records the input idle counts.
+func (r *Report) SetInputIdle(s InputIdleStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.InputIdle = s
+}
+
+// SetHTTPConnections records the http sink's connection counts.
+func (r *Report) SetHTTPConnections(h HTTPConnStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.HTTPConnections = &h
+}
+
+// SetSpill records the spill queue counts.
+func (r *Report) SetSpill(s SpillStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Spill = &s
+}
+
+// SetRouter records the router's counts.
+func (r *Report) SetRouter(s RouterStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Router = &s
+}
+
+// SetJournaldInput records the journald input's entry counts.
+func (r *Report) SetJournaldInput(s JournaldInputStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.JournaldInput = &s
+}
+
+// SetNATSInput records the nats input's message counts.
+func (r *Report) SetNATSInput(s NATSInputStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.NATSInput = &s
+}
+
+// SetLargeLines records the lines past line_prune_bytes or max_line_bytes.
+func (r *Report) SetLargeLines(s LargeLineStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.LargeLines = &s
+}
+
+// SetDuplicateKeys records the duplicate keys strict_json found.
+func (r *Report) SetDuplicateKeys(s DuplicateKeyStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.DuplicateKeys = &s
+}
+
+// SetSchemaValidation records the json_schema_file validation counts.
+func (r *Report) SetSchemaValidation(s SchemaStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.SchemaValidation = &s
+}
+
+// SetQuotas records the namespace quotas' use.
+func (r *Report) SetQuotas(s QuotaStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Quotas = &s
+}
+
+// SetTimestampShift records the timestamp shift applied.
+func (r *Report) SetTimestampShift(s TimestampShiftStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.TimestampShift = &s
+}
+
+// SetReplayPacing records how replay_speed paced the writes.
+func (r *Report) SetReplayPacing(s ReplayPacingStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ReplayPacing = &s
+}
+
+// SetOutputPaths records the files an output path template resolved to.
+func (r *Report) SetOutputPaths(s OutputPathStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.OutputPaths = &s
+}
+
+// SetQueueWait records the queue waits of the priority classes.
+func (r *Report) SetQueueWait(s QueueWaitStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.QueueWait = &s
+}
+
+// SetFaults records the faulty sink's counts.
+func (r *Report) SetFaults(f FaultStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Faults = &f
+}
+
+// SetDropRules records the drop rules and their hits.
+func (r *Report) SetDropRules(s DropRuleStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.DropRules = &s
+}
+
+// SetSort records the reordering stage's counts.
+func (r *Report) SetSort(s SortStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Sort = s
+}
+
+// SetShutdown records the shutdown snapshot.
+func (r *Report) SetShutdown(s ShutdownStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Shutdown = s
+}
+
+// SetPanic records the panic that stopped the run. Only the first is kept;
+// later ones are usually its consequences.
+func (r *Report) SetPanic(p PanicInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Panic == nil {
+		r.Panic = &p
+	}
+}
+
+// AddNormalizeFailure counts a normalization failure and its reason code.
+func (r *Report) AddNormalizeFailure(code string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if code == "" {
+		code = "unknown"
+	}
+	r.NormalizedFailed++
+	r.NormalizeFailuresByReason[code]++
+}
+
+// AddErrorDetails counts a normalized record's error and stack trace.
+func (r *Report) AddErrorDetails(hasError, hasStacktrace bool) {
+	r.shard.AddErrorDetails(hasError, hasStacktrace)
+}
+
+// AddLevel increments the count for a log level. Goroutines counting many
+// records should use their own Shard instead.
+func (r *Report) AddLevel(level string) {
+	r.shard.AddLevel(level)
+}
+
+// AddDistinct adds a record's service, namespace, pod, and trace ID to the
+// distinct counts.
+func (r *Report) AddDistinct(service, namespace, pod, traceID string) {
+	r.shard.AddDistinct(service, namespace, pod, traceID)
 }
 
 // AddService increments the count for a service.
 func (r *Report) AddService(service string) {
+	r.shard.AddService(service)
+}
+
+// AddNamespace increments the count for a namespace.
+func (r *Report) AddNamespace(namespace string) {
+	r.shard.AddNamespace(namespace)
+}
+
+// LimitLabelValues caps ByLevel, ByService, and ByNamespace at n values
+// each, counting records with any other value under OtherLabel; n <= 0
+// removes the cap. onTruncate, when not nil, is called with the label's
+// name the first time each label reaches the cap, while r is locked.
+func (r *Report) LimitLabelValues(n int, onTruncate func(label string)) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	if service == "" {
-		return
-	}
-	r.ByService[service]++
+	r.MaxLabelValues = max(n, 0)
+	r.maxLabels.Store(int64(r.MaxLabelValues))
+	r.onTruncate = onTruncate
 }
 
 // AddFiltered increments filter stats by reason.
 func (r *Report) AddFiltered(reason string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	if reason == "" {
+		reason = "unknown"
+	}
+	r.Filtered.ByReason[reason]++
 	switch reason {
 	case "level":
 		r.Filtered.Level++
@@ -101,18 +1388,15 @@ func (r *Report) AddFiltered(reason string) {
 	}
 }
 
-// AddWriteOK increments successful writes.
+// AddWriteOK increments successful writes. Goroutines writing many
+// records should use their own Shard instead.
 func (r *Report) AddWriteOK() {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	r.WrittenOK++
+	r.shard.AddWriteOK()
 }
 
 // AddWriteFailed increments failed writes.
 func (r *Report) AddWriteFailed() {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	r.WriteFailed++
+	r.shard.AddWriteFailed()
 }
 
 // AddDLQ increments DLQ count.
@@ -133,6 +1417,134 @@ func (r *Report) AddDLQWithReason(reason string) {
 	r.DLQReasons[reason]++
 }
 
+// AddDLQTruncated counts a DLQ entry that was truncated to fit the size limit.
+func (r *Report) AddDLQTruncated() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.DLQTruncated++
+}
+
+// AddDLQOverflow counts a record dropped because the DLQ was full.
+func (r *Report) AddDLQOverflow() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.DLQOverflow++
+}
+
+// EnableTopMessages starts tracking the k most frequent message templates.
+func (r *Report) EnableTopMessages(k int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if k > 0 {
+		r.topTracker = newTopMessages(k)
+	}
+}
+
+// AddMessage counts msg under its Fingerprint template when top-message
+// tracking is enabled.
+func (r *Report) AddMessage(msg string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.topTracker != nil {
+		r.topTracker.add(msg)
+	}
+}
+
+// AddCollector registers c to be rendered at the end of Prometheus output.
+func (r *Report) AddCollector(c Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// InitTransforms registers the transform chain so every transform appears in
+// the report, in order, even if it never sees a record.
+func (r *Report) InitTransforms(names []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, name := range names {
+		r.transformStats(name)
+	}
+}
+
+// AddTransformResult records one record passing through a transform. A
+// non-empty errKind marks the record as failed (e.g. "timeout", "panic",
+// "error").
+func (r *Report) AddTransformResult(name string, d time.Duration, dropped bool, reason, errKind string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ts := r.transformStats(name)
+	ts.RecordsIn++
+	ts.Seconds += d.Seconds()
+	switch {
+	case errKind != "":
+		ts.Errors++
+		ts.ErrorsByKind[errKind]++
+	case dropped:
+		ts.Dropped++
+		ts.DroppedByReason[reason]++
+	}
+}
+
+// AddTransformErrorOutcome records which on_error policy handled a failed record.
+func (r *Report) AddTransformErrorOutcome(name, policy string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.transformStats(name).ErrorsByPolicy[policy]++
+}
+
+// SetRedactions records how many records filter_redact removed each
+// redact key from.
+func (r *Report) SetRedactions(counts map[string]int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.RedactionsByKey = counts
+}
+
+// EnableTransformAudit adds TransformAudit to the report.
+func (r *Report) EnableTransformAudit() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.TransformAudit = &TransformAuditStats{ShortCircuitedBy: make(map[string]int)}
+}
+
+// AddTransformAudit counts a record leaving the transform chain: stopped
+// at the named transform, or, with stoppedAt empty, at the end of it,
+// having failed one or more transforms on the way when passedErrors is set.
+func (r *Report) AddTransformAudit(stoppedAt string, passedErrors bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	a := r.TransformAudit
+	if a == nil {
+		return
+	}
+	switch {
+	case stoppedAt != "":
+		a.ShortCircuited++
+		a.ShortCircuitedBy[stoppedAt]++
+	case passedErrors:
+		a.PassedErrors++
+	default:
+		a.FullyEvaluated++
+	}
+}
+
+// transformStats returns the stats entry for name, creating it. Callers hold r.mu.
+func (r *Report) transformStats(name string) *TransformStats {
+	for i := range r.Transforms {
+		if r.Transforms[i].Name == name {
+			return &r.Transforms[i]
+		}
+	}
+	r.Transforms = append(r.Transforms, TransformStats{
+		Name:            name,
+		DroppedByReason: make(map[string]int),
+		ErrorsByKind:    make(map[string]int),
+		ErrorsByPolicy:  make(map[string]int),
+	})
+	return &r.Transforms[len(r.Transforms)-1]
+}
+
 // AddRetry increments retry statistics.
 func (r *Report) AddRetry(retries int) {
 	r.mu.Lock()
@@ -146,31 +1558,32 @@ func (r *Report) AddRetry(retries int) {
 	}
 }
 
-// AddStageTiming adds time to a specific stage.
-func (r *Report) AddStageTiming(stage string, duration time.Duration) {
+// AddWriteTimeout increments the count of timed-out write attempts.
+func (r *Report) AddWriteTimeout() {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	seconds := duration.Seconds()
-	switch stage {
-	case "parsing":
-		r.StageTimings.ParsingSeconds += seconds
-	case "normalization":
-		r.StageTimings.NormalizationSeconds += seconds
-	case "filtering":
-		r.StageTimings.FilteringSeconds += seconds
-	case "writing":
-		r.StageTimings.WritingSeconds += seconds
-	}
+	r.RetryStats.WriteTimeouts++
+}
+
+// AddStageTiming adds time to a specific stage. Goroutines timing many
+// records should use their own Shard instead.
+func (r *Report) AddStageTiming(stage string, duration time.Duration) {
+	r.shard.AddStageTiming(stage, duration)
 }
 
-// SetDuration computes derived metrics based on runtime.
+// SetDuration syncs the report and computes derived metrics based on
+// runtime.
 func (r *Report) SetDuration(d time.Duration) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	r.sync()
 	if d <= 0 && r.TotalLines > 0 {
 		d = time.Nanosecond
 	}
 	r.DurationSeconds = d.Seconds()
+	if r.topTracker != nil {
+		r.TopMessages = r.topTracker.top()
+	}
 	if d.Seconds() > 0 {
 		r.Throughput = float64(r.TotalLines) / d.Seconds()
 	}
@@ -184,65 +1597,280 @@ func (r *Report) SetDuration(d time.Duration) {
 	}
 }
 
-// WriteJSON writes the report to a JSON file at the given path.
-func (r *Report) WriteJSON(path string) error {
-	var closer io.Closer
-	var w io.Writer
-	if path == "" || path == "-" {
-		w = os.Stdout
-	} else {
-		f, err := os.Create(path)
-		if err != nil {
-			return err
-		}
-		closer = f
-		w = f
+// WriteJSON writes a Snapshot of the report to a JSON file at the given
+// path, created with perm. The report can quote messages, e.g. in
+// top_messages, so it is created like the output.
+func (r *Report) WriteJSON(path string, perm fsutil.Perm) error {
+	return writeIndentedJSON(path, perm, r.Snapshot())
+}
+
+// WritePrometheusFile renders Prometheus output plus last-run gauges to a
+// temp file next to path and renames it into place, so a textfile collector
+// never reads a partial file.
+func (r *Report) WritePrometheusFile(path string, perm fsutil.Perm, success bool, at time.Time) error {
+	sb := &strings.Builder{}
+	sb.WriteString(r.Prometheus())
+	WriteFamily(sb, "etl_last_run_timestamp_seconds", Gauge, "Unix time the last run finished.")
+	WriteSample(sb, "etl_last_run_timestamp_seconds", float64(at.Unix()))
+	WriteFamily(sb, "etl_last_run_success", Gauge, "Whether the last run succeeded (1) or failed (0).")
+	ok := 0.0
+	if success {
+		ok = 1
 	}
-	defer func() {
-		if closer != nil {
-			closer.Close()
-		}
-	}()
+	WriteSample(sb, "etl_last_run_success", ok)
 
-	enc := json.NewEncoder(w)
-	enc.SetIndent("", "  ")
-	return enc.Encode(r)
+	return perm.WriteFile(path, []byte(sb.String()))
 }
 
-// Prometheus renders counters/gauges for metrics scraping.
+// Prometheus syncs the report and renders counters/gauges for metrics
+// scraping.
 func (r *Report) Prometheus() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sync()
+
 	sb := &strings.Builder{}
-	fmt.Fprintf(sb, "etl_total_lines %d\n", r.TotalLines)
-	fmt.Fprintf(sb, "etl_json_failed %d\n", r.JSONFailed)
-	fmt.Fprintf(sb, "etl_json_parsed %d\n", r.JSONParsed)
-	fmt.Fprintf(sb, "etl_normalized_ok %d\n", r.NormalizedOK)
-	fmt.Fprintf(sb, "etl_normalized_failed %d\n", r.NormalizedFailed)
-	fmt.Fprintf(sb, "etl_written_ok %d\n", r.WrittenOK)
-	fmt.Fprintf(sb, "etl_written_failed %d\n", r.WriteFailed)
-	fmt.Fprintf(sb, "etl_dlq_written %d\n", r.DLQWritten)
-	fmt.Fprintf(sb, "etl_duration_seconds %.6f\n", r.DurationSeconds)
-	fmt.Fprintf(sb, "etl_throughput_lines_per_sec %.6f\n", r.Throughput)
-	fmt.Fprintf(sb, "etl_json_error_rate %.6f\n", r.JSONErrorRate)
-	fmt.Fprintf(sb, "etl_normalize_error_rate %.6f\n", r.NormalizeErrRate)
-	fmt.Fprintf(sb, "etl_write_error_rate %.6f\n", r.WriteErrorRate)
-	fmt.Fprintf(sb, "etl_filtered_level %d\n", r.Filtered.Level)
-	fmt.Fprintf(sb, "etl_filtered_service %d\n", r.Filtered.Service)
-	fmt.Fprintf(sb, "etl_filtered_other %d\n", r.Filtered.Other)
-	for k, v := range r.ByLevel {
-		fmt.Fprintf(sb, "etl_level_total{level=%q} %d\n", k, v)
-	}
-	for k, v := range r.ByService {
-		fmt.Fprintf(sb, "etl_service_total{service=%q} %d\n", k, v)
-	}
-	fmt.Fprintf(sb, "etl_stage_timing_seconds{stage=\"parsing\"} %.6f\n", r.StageTimings.ParsingSeconds)
-	fmt.Fprintf(sb, "etl_stage_timing_seconds{stage=\"normalization\"} %.6f\n", r.StageTimings.NormalizationSeconds)
-	fmt.Fprintf(sb, "etl_stage_timing_seconds{stage=\"filtering\"} %.6f\n", r.StageTimings.FilteringSeconds)
-	fmt.Fprintf(sb, "etl_stage_timing_seconds{stage=\"writing\"} %.6f\n", r.StageTimings.WritingSeconds)
-	fmt.Fprintf(sb, "etl_retry_total %d\n", r.RetryStats.TotalRetries)
-	fmt.Fprintf(sb, "etl_retry_writes_with_retries %d\n", r.RetryStats.WritesWithRetries)
-	fmt.Fprintf(sb, "etl_retry_max_per_write %d\n", r.RetryStats.MaxRetriesPerWrite)
-	for reason, count := range r.DLQReasons {
-		fmt.Fprintf(sb, "etl_dlq_reason_total{reason=%q} %d\n", reason, count)
+	family := func(name, typ, help string) { WriteFamily(sb, name, typ, help) }
+	single := func(name, typ, help string, v float64) {
+		WriteFamily(sb, name, typ, help)
+		WriteSample(sb, name, v)
+	}
+
+	if r.BuildInfo.Version != "" {
+		family("etl_build_info", Gauge, "Build information of the running binary.")
+		WriteSample(sb, "etl_build_info", 1,
+			"version", r.BuildInfo.Version, "commit", r.BuildInfo.Commit,
+			"date", r.BuildInfo.Date, "go_version", r.BuildInfo.GoVersion)
+	}
+	single("etl_total_lines", Counter, "Non-empty input lines read.", float64(r.TotalLines))
+	single("etl_json_failed", Counter, "Input lines that were not valid JSON.", float64(r.JSONFailed))
+	single("etl_json_parsed", Counter, "Input lines parsed as JSON.", float64(r.JSONParsed))
+	single("etl_records_extracted", Counter, "JSON objects decoded from parsed lines.", float64(r.RecordsExtracted))
+	single("etl_normalized_ok", Counter, "Records normalized successfully.", float64(r.NormalizedOK))
+	single("etl_normalized_failed", Counter, "Records that failed normalization or were dropped by a transform error.", float64(r.NormalizedFailed))
+	family("etl_normalize_failures_total", Counter, "Normalization failures by reason code.")
+	writeCounts(sb, "etl_normalize_failures_total", "reason", r.NormalizeFailuresByReason)
+	single("etl_records_sanitized", Counter, "Normalized records whose message or fields sanitize_messages changed.", float64(r.Sanitized))
+	single("etl_records_with_error", Counter, "Normalized records with an error message.", float64(r.WithError))
+	single("etl_records_with_stacktrace", Counter, "Normalized records with a stack trace.", float64(r.WithStacktrace))
+	single("etl_written_ok", Counter, "Records written to the sink.", float64(r.WrittenOK))
+	single("etl_written_failed", Counter, "Records that failed to write after all retries.", float64(r.WriteFailed))
+	single("etl_dlq_written", Counter, "Entries written to the dead-letter file.", float64(r.DLQWritten))
+	single("etl_dlq_truncated", Counter, "Dead-letter entries truncated to fit dlq_max_record_bytes.", float64(r.DLQTruncated))
+	single("etl_dlq_overflow", Counter, "Records not dead-lettered because the DLQ reached its limits.", float64(r.DLQOverflow))
+	single("etl_duration_seconds", Gauge, "Run duration in seconds.", r.DurationSeconds)
+	single("etl_throughput_lines_per_sec", Gauge, "Input lines processed per second.", r.Throughput)
+	single("etl_json_error_rate", Gauge, "Fraction of input lines that were not valid JSON.", r.JSONErrorRate)
+	single("etl_normalize_error_rate", Gauge, "Fraction of input lines that failed normalization.", r.NormalizeErrRate)
+	single("etl_write_error_rate", Gauge, "Fraction of writes that failed.", r.WriteErrorRate)
+	single("etl_filtered_level", Counter, "Records dropped by the level filter.", float64(r.Filtered.Level))
+	single("etl_filtered_service", Counter, "Records dropped by the service filter.", float64(r.Filtered.Service))
+	single("etl_filtered_other", Counter, "Records dropped for any other reason.", float64(r.Filtered.Other))
+	family("etl_filtered_total", Counter, "Records dropped by transforms, by reason.")
+	writeCounts(sb, "etl_filtered_total", "reason", r.Filtered.ByReason)
+	family("etl_level_total", Counter, "Normalized records by level.")
+	writeCounts(sb, "etl_level_total", "level", r.ByLevel)
+	family("etl_service_total", Counter, "Normalized records by service.")
+	writeCounts(sb, "etl_service_total", "service", r.ByService)
+	family("etl_namespace_total", Counter, "Normalized records by namespace.")
+	writeCounts(sb, "etl_namespace_total", "namespace", r.ByNamespace)
+	family("etl_report_labels_truncated", Gauge, "1 when a label reached report_max_label_values and further values are counted as __other__.")
+	for _, name := range labelNames {
+		truncated := 0.0
+		if slices.Contains(r.LabelsTruncated, name) {
+			truncated = 1
+		}
+		WriteSample(sb, "etl_report_labels_truncated", truncated, "label", name)
+	}
+	family("etl_distinct_values", Gauge, "Estimated number of distinct values of a field across normalized records.")
+	WriteSample(sb, "etl_distinct_values", float64(r.DistinctServices), "field", "service")
+	WriteSample(sb, "etl_distinct_values", float64(r.DistinctNamespaces), "field", "namespace")
+	WriteSample(sb, "etl_distinct_values", float64(r.DistinctPods), "field", "pod")
+	WriteSample(sb, "etl_distinct_values", float64(r.DistinctTraceIDs), "field", "trace_id")
+	family("etl_stage_timing_seconds", Gauge, "Time spent in each pipeline stage, in seconds.")
+	WriteSample(sb, "etl_stage_timing_seconds", r.StageTimings.ParsingSeconds, "stage", "parsing")
+	WriteSample(sb, "etl_stage_timing_seconds", r.StageTimings.NormalizationSeconds, "stage", "normalization")
+	WriteSample(sb, "etl_stage_timing_seconds", r.StageTimings.FilteringSeconds, "stage", "filtering")
+	WriteSample(sb, "etl_stage_timing_seconds", r.StageTimings.WritingSeconds, "stage", "writing")
+	single("etl_retry_total", Counter, "Sink write retries.", float64(r.RetryStats.TotalRetries))
+	single("etl_retry_writes_with_retries", Counter, "Writes that needed at least one retry.", float64(r.RetryStats.WritesWithRetries))
+	single("etl_retry_max_per_write", Gauge, "Most retries needed by a single write.", float64(r.RetryStats.MaxRetriesPerWrite))
+	single("etl_retry_write_timeouts", Counter, "Write attempts that exceeded sink_write_timeout_ms.", float64(r.RetryStats.WriteTimeouts))
+	single("etl_record_lag_seconds", Gauge, "Seconds between the timestamp of the latest record and its normalization.", r.RecordLag.LastSeconds)
+	single("etl_record_lag_min_seconds", Gauge, "Smallest record lag in seconds.", r.RecordLag.MinSeconds)
+	single("etl_record_lag_avg_seconds", Gauge, "Average record lag in seconds.", r.RecordLag.AvgSeconds)
+	single("etl_record_lag_p95_seconds", Gauge, "95th percentile record lag in seconds, to within 5%.", r.RecordLag.P95Seconds)
+	single("etl_record_lag_max_seconds", Gauge, "Largest record lag in seconds.", r.RecordLag.MaxSeconds)
+	single("etl_records_future_timestamp", Counter, "Records timestamped ahead of the clock, left out of the lag figures.", float64(r.RecordLag.Future))
+	if r.RetryBudget.BudgetSeconds > 0 {
+		exhausted := 0.0
+		if r.RetryBudget.Exhausted {
+			exhausted = 1
+		}
+		single("etl_retry_budget_spent_seconds", Counter, "Backoff seconds spent against sink_retry_budget.", r.RetryBudget.SpentSeconds)
+		single("etl_retry_budget_exhausted", Gauge, "1 while the current sink_retry_budget_window has spent sink_retry_budget.", exhausted)
+		single("etl_retry_budget_writes_not_retried", Counter, "Failed writes not retried because sink_retry_budget was spent.", float64(r.RetryBudget.WritesNotRetried))
+	}
+	family("etl_dlq_reason_total", Counter, "Dead-letter entries by reason.")
+	writeCounts(sb, "etl_dlq_reason_total", "reason", r.DLQReasons)
+	single("etl_runtime_peak_heap_bytes", Gauge, "Peak heap in use across runtime samples.", float64(r.RuntimeStats.PeakHeapBytes))
+	single("etl_runtime_alloc_bytes", Counter, "Bytes allocated since the run started.", float64(r.RuntimeStats.TotalAllocBytes))
+	single("etl_runtime_gc", Counter, "Garbage collections since the run started.", float64(r.RuntimeStats.NumGC))
+	single("etl_sort_late_records", Counter, "Records that arrived too late for the sort window and were written out of order.", float64(r.Sort.LateRecords))
+	single("etl_sort_overflow", Counter, "Records written early because the sort buffer was full.", float64(r.Sort.Overflow))
+	if r.InFlight.MaxBytes > 0 {
+		single("etl_inflight_bytes", Gauge, "Estimated bytes of records queued for the sink.", float64(r.InFlight.CurrentBytes))
+		single("etl_inflight_peak_bytes", Gauge, "Most estimated bytes queued for the sink at once.", float64(r.InFlight.PeakBytes))
+		single("etl_inflight_waits", Counter, "Records held back by max_inflight_bytes.", float64(r.InFlight.Waits))
+	}
+	if r.InputIdle.TimeoutSeconds > 0 {
+		single("etl_input_idle_seconds", Gauge, "Seconds the input has been silent; at the end of a run, how long it waited for a record.", r.InputIdle.IdleSeconds)
+		single("etl_input_idle_max_seconds", Gauge, "Longest wait for an input record.", r.InputIdle.MaxIdleSeconds)
+		single("etl_input_idle_warnings", Counter, "Times input_idle_timeout passed without an input record.", float64(r.InputIdle.Warnings))
+	}
+	if r.DropRules != nil {
+		family("etl_drop_rule_hits_total", Counter, "Records dropped by each drop_rules_file rule, by line.")
+		for _, rule := range r.DropRules.Rules {
+			WriteSample(sb, "etl_drop_rule_hits_total", float64(rule.Hits), "line", strconv.Itoa(rule.Line))
+		}
+	}
+	if h := r.HTTPConnections; h != nil {
+		family("etl_http_connections_total", Counter, "Connections the http sink's requests used: new ones dialed and pooled ones reused.")
+		WriteSample(sb, "etl_http_connections_total", float64(h.New), "state", "new")
+		WriteSample(sb, "etl_http_connections_total", float64(h.Reused), "state", "reused")
+		single("etl_http_dns_lookups_total", Counter, "DNS lookups by the http sink.", float64(h.DNSLookups))
+		single("etl_http_tls_handshakes_total", Counter, "TLS handshakes by the http sink.", float64(h.TLSHandshakes))
+	}
+	if s := r.Spill; s != nil {
+		family("etl_spill_records_total", Counter, "Records that failed every retry, by what became of them: spooled to spill_dir, drained from it to the sink, or dead-lettered because spill_max_bytes was reached.")
+		WriteSample(sb, "etl_spill_records_total", float64(s.Spilled), "state", "spilled")
+		WriteSample(sb, "etl_spill_records_total", float64(s.Drained), "state", "drained")
+		WriteSample(sb, "etl_spill_records_total", float64(s.Overflow), "state", "overflow")
+		single("etl_spill_pending_records", Gauge, "Records in spill_dir not yet written to the sink.", float64(s.PendingRecords))
+		single("etl_spill_pending_bytes", Gauge, "Bytes of spill_dir files not yet drained.", float64(s.PendingBytes))
+	}
+	if rt := r.Router; rt != nil {
+		family("etl_router_records_total", Counter, "Records written to each route of the router.")
+		for _, route := range rt.Routes {
+			WriteSample(sb, "etl_router_records_total", float64(route.Records), "route", route.Name)
+		}
+		family("etl_router_write_failures_total", Counter, "Failed write attempts to each route of the router.")
+		for _, route := range rt.Routes {
+			WriteSample(sb, "etl_router_write_failures_total", float64(route.Failed), "route", route.Name)
+		}
+		family("etl_router_retries_total", Counter, "Write retries of records of each route of the router.")
+		for _, route := range rt.Routes {
+			WriteSample(sb, "etl_router_retries_total", float64(route.Retries), "route", route.Name)
+		}
+		family("etl_router_dead_lettered_total", Counter, "Records of each route of the router that were dead-lettered.")
+		for _, route := range rt.Routes {
+			WriteSample(sb, "etl_router_dead_lettered_total", float64(route.DeadLettered), "route", route.Name)
+		}
+		single("etl_router_unroutable_total", Counter, "Records no router rule matched, with no default route.", float64(rt.Unroutable))
+	}
+	if p := r.ReplayPacing; p != nil {
+		single("etl_replay_duration_seconds", Gauge, "Seconds from the first paced write to the last, with replay_speed.", p.DurationSeconds)
+		single("etl_replay_sleep_seconds_total", Counter, "Seconds replay_speed waited between writes.", p.SleptSeconds)
+	}
+	if o := r.OutputPaths; o != nil {
+		single("etl_output_paths", Gauge, "Files the output path template resolved to.", float64(len(o.Created)))
+		single("etl_output_path_evictions_total", Counter, "Resolved output files closed to stay within output_path_max_open.", float64(o.Evictions))
+	}
+	if n := r.NATSInput; n != nil {
+		family("etl_nats_input_messages_total", Counter, "Messages read from the JetStream consumer, by whether they were acknowledged.")
+		WriteSample(sb, "etl_nats_input_messages_total", float64(n.Acked), "state", "acked")
+		WriteSample(sb, "etl_nats_input_messages_total", float64(n.Unacked), "state", "unacked")
+		single("etl_nats_input_redelivered_total", Counter, "Messages the JetStream consumer had delivered before.", float64(n.Redelivered))
+	}
+	if j := r.JournaldInput; j != nil {
+		family("etl_journald_input_entries_total", Counter, "Entries read from the systemd journal, by whether they were acknowledged.")
+		WriteSample(sb, "etl_journald_input_entries_total", float64(j.Acked), "state", "acked")
+		WriteSample(sb, "etl_journald_input_entries_total", float64(j.Unacked), "state", "unacked")
+	}
+	if d := r.DuplicateKeys; d != nil {
+		single("etl_duplicate_key_lines_total", Counter, "Input lines with a key repeated within one object, found by strict_json.", float64(d.Lines))
+		single("etl_duplicate_key_dead_lettered_total", Counter, "Records dead-lettered for a duplicate key.", float64(d.DeadLettered))
+	}
+	if l := r.LargeLines; l != nil {
+		single("etl_lines_pruned_total", Counter, "Input lines longer than line_prune_bytes, read keeping only the keys normalization reads.", float64(l.Pruned))
+		single("etl_lines_too_long_total", Counter, "Input lines longer than max_line_bytes, skipped.", float64(l.TooLong))
+	}
+	if s := r.SchemaValidation; s != nil {
+		single("etl_schema_checked_total", Counter, "Records validated against json_schema_file.", float64(s.Checked))
+		single("etl_schema_invalid_total", Counter, "Records failing json_schema_file.", float64(s.Invalid))
+		single("etl_schema_dropped_total", Counter, "Records dropped for failing json_schema_file.", float64(s.Dropped))
+		single("etl_schema_dead_lettered_total", Counter, "Records dead-lettered for failing json_schema_file.", float64(s.DeadLettered))
+		family("etl_schema_violations_total", Counter, "Schema violations by failing keyword and path; past the cap they are counted under violation=\"other\".")
+		for _, key := range slices.Sorted(maps.Keys(s.ByViolation)) {
+			WriteSample(sb, "etl_schema_violations_total", float64(s.ByViolation[key]), "violation", key)
+		}
+		if s.OtherViolations > 0 {
+			WriteSample(sb, "etl_schema_violations_total", float64(s.OtherViolations), "violation", "other")
+		}
+	}
+	if q := r.QueueWait; q != nil {
+		family("etl_queue_dequeued_total", Counter, "Records workers took from the priority queue, by level class.")
+		for _, c := range q.Classes {
+			WriteSample(sb, "etl_queue_dequeued_total", float64(c.Records), "class", c.Class)
+		}
+		family("etl_queue_wait_avg_seconds", Gauge, "Average time records of each level class waited in the priority queue.")
+		for _, c := range q.Classes {
+			WriteSample(sb, "etl_queue_wait_avg_seconds", c.AvgWaitSeconds, "class", c.Class)
+		}
+		family("etl_queue_wait_max_seconds", Gauge, "Longest time a record of each level class waited in the priority queue.")
+		for _, c := range q.Classes {
+			WriteSample(sb, "etl_queue_wait_max_seconds", c.MaxWaitSeconds, "class", c.Class)
+		}
+	}
+	if r.Faults != nil {
+		family("etl_injected_faults_total", Counter, "Write attempts the faulty sink failed on purpose, by fault.")
+		WriteSample(sb, "etl_injected_faults_total", float64(r.Faults.Random), "fault", "random")
+		WriteSample(sb, "etl_injected_faults_total", float64(r.Faults.EveryNth), "fault", "every_nth")
+		WriteSample(sb, "etl_injected_faults_total", float64(r.Faults.AfterLimit), "fault", "after_limit")
+	}
+
+	// Transforms keep their pipeline order; each family lists them all.
+	family("etl_transform_records_in_total", Counter, "Records passed to each transform.")
+	for _, ts := range r.Transforms {
+		WriteSample(sb, "etl_transform_records_in_total", float64(ts.RecordsIn), "transform", ts.Name)
+	}
+	family("etl_transform_dropped_total", Counter, "Records dropped by each transform.")
+	for _, ts := range r.Transforms {
+		WriteSample(sb, "etl_transform_dropped_total", float64(ts.Dropped), "transform", ts.Name)
+	}
+	family("etl_transform_dropped_reason_total", Counter, "Records dropped by each transform, by reason.")
+	for _, ts := range r.Transforms {
+		writeCounts(sb, "etl_transform_dropped_reason_total", "reason", ts.DroppedByReason, "transform", ts.Name)
+	}
+	family("etl_transform_errors_total", Counter, "Errors returned by each transform.")
+	for _, ts := range r.Transforms {
+		WriteSample(sb, "etl_transform_errors_total", float64(ts.Errors), "transform", ts.Name)
+	}
+	family("etl_transform_errors_by_kind_total", Counter, "Transform errors by kind (error or timeout).")
+	for _, ts := range r.Transforms {
+		writeCounts(sb, "etl_transform_errors_by_kind_total", "kind", ts.ErrorsByKind, "transform", ts.Name)
+	}
+	family("etl_transform_errors_by_policy_total", Counter, "Transform errors by the on_error policy applied.")
+	for _, ts := range r.Transforms {
+		writeCounts(sb, "etl_transform_errors_by_policy_total", "policy", ts.ErrorsByPolicy, "transform", ts.Name)
+	}
+	family("etl_transform_seconds_total", Counter, "Time spent in each transform, in seconds.")
+	for _, ts := range r.Transforms {
+		WriteSample(sb, "etl_transform_seconds_total", ts.Seconds, "transform", ts.Name)
+	}
+	if a := r.TransformAudit; a != nil {
+		family("etl_transform_audit_records_total", Counter, "Records leaving the transform chain, by how far they got.")
+		WriteSample(sb, "etl_transform_audit_records_total", float64(a.FullyEvaluated), "outcome", "fully_evaluated")
+		WriteSample(sb, "etl_transform_audit_records_total", float64(a.PassedErrors), "outcome", "passed_errors")
+		WriteSample(sb, "etl_transform_audit_records_total", float64(a.ShortCircuited), "outcome", "short_circuited")
+		family("etl_transform_audit_short_circuited_total", Counter, "Records the transform chain stopped early, by the transform it stopped at.")
+		for _, name := range slices.Sorted(maps.Keys(a.ShortCircuitedBy)) {
+			WriteSample(sb, "etl_transform_audit_short_circuited_total", float64(a.ShortCircuitedBy[name]), "transform", name)
+		}
+	}
+
+	for _, c := range r.collectors {
+		c.WritePrometheus(sb)
 	}
 	return sb.String()
 }
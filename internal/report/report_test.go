@@ -0,0 +1,305 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fill sets every exported field reachable from v to a non-zero value,
+// giving maps and slices one element.
+func fill(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				fill(v.Field(i))
+			}
+		}
+	case reflect.Pointer:
+		v.Set(reflect.New(v.Type().Elem()))
+		fill(v.Elem())
+	case reflect.Map:
+		k, e := reflect.New(v.Type().Key()).Elem(), reflect.New(v.Type().Elem()).Elem()
+		fill(k)
+		fill(e)
+		v.Set(reflect.MakeMap(v.Type()))
+		v.SetMapIndex(k, e)
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), 1, 1))
+		fill(v.Index(0))
+	case reflect.String:
+		v.SetString("x")
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		v.SetInt(7)
+	case reflect.Uint64, reflect.Uint32:
+		v.SetUint(7)
+	case reflect.Float64:
+		v.SetFloat(0.5)
+	}
+}
+
+func TestSnapshotCopiesEveryField(t *testing.T) {
+	rep := NewReport()
+	fill(reflect.ValueOf(rep).Elem())
+	want, err := json.Marshal(rep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap := rep.Snapshot()
+	got, err := json.Marshal(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Fatalf("snapshot differs; a field is probably missing from Snapshot\n got: %s\nwant: %s", got, want)
+	}
+
+	// Nothing is shared: later counting leaves the snapshot alone.
+	rep.AddLevel("x")
+	rep.AddFiltered("x")
+	rep.AddTransformResult("x", 0, true, "x", "")
+	rep.AddLine()
+	rep.Sync()
+	if again, _ := json.Marshal(snap); string(again) != string(want) {
+		t.Errorf("snapshot changed after the report did:\n got: %s\nwant: %s", again, want)
+	}
+	snap.AddLevel("ERROR") // the copy is a working report
+}
+
+func TestReportConcurrentCounters(t *testing.T) {
+	rep := NewReport()
+	rep.StageTimings.WritingSeconds = 1 // set directly; Sync adds to it
+	const goroutines, perG = 16, 1000
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			// Half the goroutines count through their own shard, half
+			// through the report's.
+			shard := rep.NewShard()
+			for i := 0; i < perG; i++ {
+				if g%2 == 0 {
+					shard.AddWriteOK()
+					shard.AddErrorDetails(true, i%2 == 0)
+					shard.AddStageTiming("writing", time.Millisecond)
+					shard.AddLevel("ERROR")
+					shard.AddService("api")
+				} else {
+					rep.AddWriteOK()
+					rep.AddErrorDetails(true, i%2 == 0)
+					rep.AddStageTiming("writing", time.Millisecond)
+					rep.AddLevel("WARN")
+				}
+			}
+		}(g)
+	}
+	// Readers sync while the counters move.
+	for i := 0; i < 10; i++ {
+		rep.Prometheus()
+	}
+	wg.Wait()
+	rep.Sync()
+	rep.Sync() // nothing is counted twice
+
+	const total = goroutines * perG
+	if rep.WrittenOK != total || rep.WithError != total || rep.WithStacktrace != total/2 {
+		t.Errorf("written %d, with error %d, with stacktrace %d", rep.WrittenOK, rep.WithError, rep.WithStacktrace)
+	}
+	if rep.ByLevel["ERROR"] != total/2 || rep.ByLevel["WARN"] != total/2 || rep.ByService["api"] != total/2 {
+		t.Errorf("by level %v, by service %v", rep.ByLevel, rep.ByService)
+	}
+	if got, want := rep.StageTimings.WritingSeconds, 1+float64(total)/1000; got < want-1e-6 || got > want+1e-6 {
+		t.Errorf("writing seconds = %v, want %v", got, want)
+	}
+}
+
+func TestReportCapsLabelValues(t *testing.T) {
+	rep := NewReport()
+	var truncated []string
+	rep.LimitLabelValues(100, func(label string) { truncated = append(truncated, label) })
+	rep.AddService("api")
+	rep.AddNamespace("payments")
+	rep.Sync()
+
+	// Every record has its own service, like job names with a UUID suffix.
+	const goroutines, perG = 8, 5000
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			shard := rep.NewShard()
+			for i := 0; i < perG; i++ {
+				shard.AddLevel("INFO")
+				shard.AddService(fmt.Sprintf("job-%d-%d", g, i))
+				shard.AddNamespace("payments")
+				shard.mu.Lock()
+				n := len(shard.counts[labelService])
+				shard.mu.Unlock()
+				if n > 100 {
+					t.Errorf("shard holds %d services", n)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	rep.AddService("api") // counted under its own name, not __other__
+	snap := rep.Snapshot()
+
+	if len(snap.ByService) != 101 {
+		t.Errorf("by_service has %d values, want 100 and %s", len(snap.ByService), OtherLabel)
+	}
+	sum := 0
+	for _, n := range snap.ByService {
+		sum += n
+	}
+	if sum != goroutines*perG+2 || snap.ByService["api"] != 2 || snap.ByService[OtherLabel] != goroutines*perG-99 {
+		t.Errorf("by_service sums to %d, api %d, %s %d", sum, snap.ByService["api"], OtherLabel, snap.ByService[OtherLabel])
+	}
+	if len(snap.ByLevel) != 1 || snap.ByNamespace["payments"] != goroutines*perG+1 {
+		t.Errorf("by_level %v, by_namespace %v", snap.ByLevel, snap.ByNamespace)
+	}
+	if strings.Join(snap.LabelsTruncated, ",") != "service" || strings.Join(truncated, ",") != "service" {
+		t.Errorf("labels truncated %v, warned for %v", snap.LabelsTruncated, truncated)
+	}
+
+	// Every rendering shows the same capped counts.
+	if got := snap.DataQuality().ByService; len(got) != 101 || got[OtherLabel] != snap.ByService[OtherLabel] {
+		t.Errorf("data quality by_service has %d values", len(got))
+	}
+	prom := snap.Prometheus()
+	if n := strings.Count(prom, "\netl_service_total{"); n != 101 {
+		t.Errorf("prometheus has %d service samples", n)
+	}
+	for _, line := range []string{
+		`etl_service_total{service="__other__"} ` + fmt.Sprint(snap.ByService[OtherLabel]),
+		`etl_report_labels_truncated{label="service"} 1`,
+		`etl_report_labels_truncated{label="level"} 0`,
+	} {
+		if !strings.Contains(prom, line+"\n") {
+			t.Errorf("prometheus output lacks %s", line)
+		}
+	}
+	var md strings.Builder
+	if err := snap.WriteMarkdown(&md); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(md.String(), "Counts by service stop at 100 values") {
+		t.Errorf("markdown does not mention the cap:\n%s", md.String())
+	}
+}
+
+// lockedCounters is the single-mutex design the hot counters replaced,
+// kept as the benchmark baseline.
+type lockedCounters struct {
+	mu        sync.Mutex
+	writtenOK int
+	writing   float64
+	byLevel   map[string]int
+	byService map[string]int
+}
+
+func (c *lockedCounters) add(level, service string, d time.Duration) {
+	c.mu.Lock()
+	c.writtenOK++
+	c.mu.Unlock()
+	c.mu.Lock()
+	c.writing += d.Seconds()
+	c.mu.Unlock()
+	c.mu.Lock()
+	c.byLevel[level]++
+	c.mu.Unlock()
+	c.mu.Lock()
+	c.byService[service]++
+	c.mu.Unlock()
+}
+
+// hammer runs b.N calls of add spread over 16 goroutines, each with its
+// own setup.
+func hammer(b *testing.B, setup func() func()) {
+	const goroutines = 16
+	adds := make([]func(), goroutines)
+	for g := range adds {
+		adds[g] = setup()
+	}
+	b.ResetTimer()
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		n := b.N / goroutines
+		if g < b.N%goroutines {
+			n++
+		}
+		wg.Add(1)
+		go func(add func(), n int) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				add()
+			}
+		}(adds[g], n)
+	}
+	wg.Wait()
+}
+
+func BenchmarkCounters16Goroutines(b *testing.B) {
+	b.Run("mutex", func(b *testing.B) {
+		c := &lockedCounters{byLevel: make(map[string]int), byService: make(map[string]int)}
+		hammer(b, func() func() {
+			return func() { c.add("ERROR", "api", time.Microsecond) }
+		})
+	})
+	// Every goroutine counting through the report's own shard.
+	b.Run("report", func(b *testing.B) {
+		rep := NewReport()
+		hammer(b, func() func() {
+			return func() {
+				rep.AddWriteOK()
+				rep.AddStageTiming("writing", time.Microsecond)
+				rep.AddLevel("ERROR")
+				rep.AddService("api")
+			}
+		})
+		rep.Sync()
+	})
+	b.Run("shard", func(b *testing.B) {
+		rep := NewReport()
+		hammer(b, func() func() {
+			shard := rep.NewShard()
+			return func() {
+				shard.AddWriteOK()
+				shard.AddStageTiming("writing", time.Microsecond)
+				shard.AddLevel("ERROR")
+				shard.AddService("api")
+			}
+		})
+		rep.Sync()
+	})
+}
+
+func TestSchemaStatsAddViolationCaps(t *testing.T) {
+	var s SchemaStats
+	for i := range MaxSchemaViolations + 5 {
+		s.AddViolation(fmt.Sprintf("type at /f%d", i))
+	}
+	s.AddViolation("type at /f0")
+	if len(s.ByViolation) != MaxSchemaViolations || s.ByViolation["type at /f0"] != 2 || s.OtherViolations != 5 {
+		t.Fatalf("%d violations, f0 = %d, other = %d", len(s.ByViolation), s.ByViolation["type at /f0"], s.OtherViolations)
+	}
+
+	rep := NewReport()
+	rep.SetSchemaValidation(s)
+	prom := rep.Prometheus()
+	for _, want := range []string{`etl_schema_violations_total{violation="type at /f0"} 2`, `etl_schema_violations_total{violation="other"} 5`} {
+		if !strings.Contains(prom, want) {
+			t.Errorf("Prometheus output lacks %s", want)
+		}
+	}
+}
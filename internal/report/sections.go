@@ -0,0 +1,243 @@
+package report
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+
+	"k8s-log-etl/internal/fsutil"
+)
+
+// Report schemas, the shapes WriteJSONSchema writes.
+const (
+	SchemaLegacy = "legacy" // the flat Report, as WriteJSON writes it
+	SchemaV2     = "v2"     // run provenance plus the Operational and DataQuality sections
+)
+
+// Operational is the part of a report about how the run went: volume
+// and speed, writes and retries, the queue and sinks, and why it stopped.
+type Operational struct {
+	TotalLines      int                  `json:"total_lines"`
+	InputMode       string               `json:"input_mode,omitempty"`
+	InputFiles      []InputFile          `json:"input_files,omitempty"`
+	DurationSeconds float64              `json:"duration_seconds"`
+	Throughput      float64              `json:"throughput_lines_per_sec"`
+	WrittenOK       int                  `json:"written_ok"`
+	WriteFailed     int                  `json:"written_failed"`
+	WriteErrorRate  float64              `json:"write_error_rate"`
+	DLQWritten      int                  `json:"dlq_written"`
+	DLQTruncated    int                  `json:"dlq_truncated"`
+	DLQOverflow     int                  `json:"dlq_overflow"`
+	ManifestPath    string               `json:"manifest_path,omitempty"`
+	StageTimings    StageTimings         `json:"stage_timings"`
+	RetryStats      RetryStats           `json:"retry_stats"`
+	RetryBudget     RetryBudgetStats     `json:"retry_budget"`
+	InFlight        InFlightStats        `json:"inflight"`
+	InputIdle       InputIdleStats       `json:"input_idle"`
+	Sort            SortStats            `json:"sort"`
+	Limits          LimitStats           `json:"limits"`
+	RecordLag       LagStats             `json:"record_lag"`
+	RuntimeStats    RuntimeStats         `json:"runtime_stats"`
+	Shutdown        ShutdownStats        `json:"shutdown"`
+	Panic           *PanicInfo           `json:"panic,omitempty"`
+	HTTPConnections *HTTPConnStats       `json:"http_connections,omitempty"`
+	Spill           *SpillStats          `json:"spill,omitempty"`
+	Router          *RouterStats         `json:"router,omitempty"`
+	NATSInput       *NATSInputStats      `json:"nats_input,omitempty"`
+	JournaldInput   *JournaldInputStats  `json:"journald_input,omitempty"`
+	QueueWait       *QueueWaitStats      `json:"queue_wait,omitempty"`
+	Faults          *FaultStats          `json:"faults,omitempty"`
+	Quotas          *QuotaStats          `json:"quotas,omitempty"`
+	TimestampShift  *TimestampShiftStats `json:"timestamp_shift,omitempty"`
+	ReplayPacing    *ReplayPacingStats   `json:"replay_pacing,omitempty"`
+	OutputPaths     *OutputPathStats     `json:"output_paths,omitempty"`
+}
+
+// DataQuality is the part of a report about the records themselves: what
+// parsed and normalized, what was filtered or dead-lettered and why, and
+// what the records carried.
+type DataQuality struct {
+	TotalLines                int                  `json:"total_lines"`
+	JSONParsed                int                  `json:"json_parsed"`
+	JSONFailed                int                  `json:"json_failed"`
+	JSONErrorRate             float64              `json:"json_error_rate"`
+	RecordsExtracted          int                  `json:"records_extracted"`
+	Unwrap                    UnwrapStats          `json:"unwrap"`
+	DuplicateKeys             *DuplicateKeyStats   `json:"duplicate_keys,omitempty"`
+	LargeLines                *LargeLineStats      `json:"large_lines,omitempty"`
+	SchemaValidation          *SchemaStats         `json:"schema_validation,omitempty"`
+	NormalizedOK              int                  `json:"normalized_ok"`
+	NormalizedFailed          int                  `json:"normalized_failed"`
+	NormalizeErrRate          float64              `json:"normalize_error_rate"`
+	NormalizeFailuresByReason map[string]int       `json:"normalize_failures_by_reason"`
+	WithError                 int                  `json:"with_error"`
+	WithStacktrace            int                  `json:"with_stacktrace"`
+	Sanitized                 int                  `json:"sanitized"`
+	ByLevel                   map[string]int       `json:"by_level"`
+	ByService                 map[string]int       `json:"by_service"`
+	ByNamespace               map[string]int       `json:"by_namespace,omitempty"`
+	MaxLabelValues            int                  `json:"max_label_values,omitempty"`
+	LabelsTruncated           []string             `json:"labels_truncated,omitempty"`
+	DistinctServices          int                  `json:"distinct_services"`
+	DistinctNamespaces        int                  `json:"distinct_namespaces"`
+	DistinctPods              int                  `json:"distinct_pods"`
+	DistinctTraceIDs          int                  `json:"distinct_trace_ids"`
+	Filtered                  FilterStats          `json:"filtered"`
+	DropRules                 *DropRuleStats       `json:"drop_rules,omitempty"`
+	Transforms                []TransformStats     `json:"transforms"`
+	TransformAudit            *TransformAuditStats `json:"transform_audit,omitempty"`
+	RedactionsByKey           map[string]int       `json:"redactions_by_key,omitempty"`
+	DLQReasons                map[string]int       `json:"dlq_reasons"`
+	TopMessages               []MessageCount       `json:"top_messages,omitempty"`
+}
+
+// ReportV2 is the v2 report: the run's provenance and its two sections,
+// each of which can also be written on its own.
+type ReportV2 struct {
+	Schema      string      `json:"schema"`
+	RunID       string      `json:"run_id,omitempty"`
+	Hostname    string      `json:"hostname,omitempty"`
+	BuildInfo   BuildInfo   `json:"build_info"`
+	Operational Operational `json:"operational"`
+	DataQuality DataQuality `json:"data_quality"`
+}
+
+// Operational returns the operational section of a Snapshot.
+func (r *Report) Operational() Operational {
+	return r.Snapshot().operational()
+}
+
+// DataQuality returns the data-quality section of a Snapshot.
+func (r *Report) DataQuality() DataQuality {
+	return r.Snapshot().dataQuality()
+}
+
+// V2 returns a Snapshot in the v2 shape. Both sections come from the
+// same snapshot, so their shared counts agree.
+func (r *Report) V2() ReportV2 {
+	snap := r.Snapshot()
+	return ReportV2{
+		Schema:      SchemaV2,
+		RunID:       snap.RunID,
+		Hostname:    snap.Hostname,
+		BuildInfo:   snap.BuildInfo,
+		Operational: snap.operational(),
+		DataQuality: snap.dataQuality(),
+	}
+}
+
+// operational and dataQuality copy fields of a snapshot, which nothing
+// else refers to, so the maps and slices are not cloned again.
+func (r *Report) operational() Operational {
+	return Operational{
+		TotalLines:      r.TotalLines,
+		InputMode:       r.InputMode,
+		InputFiles:      r.InputFiles,
+		DurationSeconds: r.DurationSeconds,
+		Throughput:      r.Throughput,
+		WrittenOK:       r.WrittenOK,
+		WriteFailed:     r.WriteFailed,
+		WriteErrorRate:  r.WriteErrorRate,
+		DLQWritten:      r.DLQWritten,
+		DLQTruncated:    r.DLQTruncated,
+		DLQOverflow:     r.DLQOverflow,
+		ManifestPath:    r.ManifestPath,
+		StageTimings:    r.StageTimings,
+		RetryStats:      r.RetryStats,
+		RetryBudget:     r.RetryBudget,
+		InFlight:        r.InFlight,
+		InputIdle:       r.InputIdle,
+		Sort:            r.Sort,
+		Limits:          r.Limits,
+		RecordLag:       r.RecordLag,
+		RuntimeStats:    r.RuntimeStats,
+		Shutdown:        r.Shutdown,
+		Panic:           r.Panic,
+		HTTPConnections: r.HTTPConnections,
+		Spill:           r.Spill,
+		Router:          r.Router,
+		NATSInput:       r.NATSInput,
+		JournaldInput:   r.JournaldInput,
+		QueueWait:       r.QueueWait,
+		Faults:          r.Faults,
+		Quotas:          r.Quotas,
+		TimestampShift:  r.TimestampShift,
+		ReplayPacing:    r.ReplayPacing,
+		OutputPaths:     r.OutputPaths,
+	}
+}
+
+func (r *Report) dataQuality() DataQuality {
+	return DataQuality{
+		TotalLines:                r.TotalLines,
+		JSONParsed:                r.JSONParsed,
+		JSONFailed:                r.JSONFailed,
+		JSONErrorRate:             r.JSONErrorRate,
+		RecordsExtracted:          r.RecordsExtracted,
+		Unwrap:                    r.Unwrap,
+		DuplicateKeys:             r.DuplicateKeys,
+		LargeLines:                r.LargeLines,
+		SchemaValidation:          r.SchemaValidation,
+		NormalizedOK:              r.NormalizedOK,
+		NormalizedFailed:          r.NormalizedFailed,
+		NormalizeErrRate:          r.NormalizeErrRate,
+		NormalizeFailuresByReason: r.NormalizeFailuresByReason,
+		WithError:                 r.WithError,
+		WithStacktrace:            r.WithStacktrace,
+		Sanitized:                 r.Sanitized,
+		ByLevel:                   r.ByLevel,
+		ByService:                 r.ByService,
+		ByNamespace:               r.ByNamespace,
+		MaxLabelValues:            r.MaxLabelValues,
+		LabelsTruncated:           r.LabelsTruncated,
+		DistinctServices:          r.DistinctServices,
+		DistinctNamespaces:        r.DistinctNamespaces,
+		DistinctPods:              r.DistinctPods,
+		DistinctTraceIDs:          r.DistinctTraceIDs,
+		Filtered:                  r.Filtered,
+		DropRules:                 r.DropRules,
+		Transforms:                r.Transforms,
+		TransformAudit:            r.TransformAudit,
+		RedactionsByKey:           r.RedactionsByKey,
+		DLQReasons:                r.DLQReasons,
+		TopMessages:               r.TopMessages,
+	}
+}
+
+// WriteJSONSchema writes the report like WriteJSON in the given schema:
+// SchemaLegacy, or "", for the flat report and SchemaV2 for ReportV2.
+func (r *Report) WriteJSONSchema(path, schema string, perm fsutil.Perm) error {
+	if schema == SchemaV2 {
+		return writeIndentedJSON(path, perm, r.V2())
+	}
+	return r.WriteJSON(path, perm)
+}
+
+// WriteOperationalJSON writes the operational section alone, like
+// WriteJSON.
+func (r *Report) WriteOperationalJSON(path string, perm fsutil.Perm) error {
+	return writeIndentedJSON(path, perm, r.Operational())
+}
+
+// WriteDataQualityJSON writes the data-quality section alone, like
+// WriteJSON.
+func (r *Report) WriteDataQualityJSON(path string, perm fsutil.Perm) error {
+	return writeIndentedJSON(path, perm, r.DataQuality())
+}
+
+// writeIndentedJSON writes v as indented JSON to a file at path, created
+// with perm, or to stdout when path is "-".
+func writeIndentedJSON(path string, perm fsutil.Perm, v any) error {
+	var w io.Writer = os.Stdout
+	if path != "" && path != "-" {
+		f, err := perm.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,188 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s-log-etl/internal/fsutil"
+)
+
+// sectionsTestReport fills in fields from both sections.
+func sectionsTestReport() *Report {
+	rep := NewReport()
+	rep.RunID, rep.Hostname = "run-1", "node-a"
+	rep.BuildInfo = BuildInfo{Version: "v1.2.3", Commit: "abc123", Date: "2024-01-01", GoVersion: "go1.25"}
+	rep.TotalLines = 10
+	rep.JSONParsed = 9
+	rep.JSONFailed = 1
+	rep.NormalizedOK = 8
+	rep.AddNormalizeFailure("missing_ts")
+	rep.AddLevel("ERROR")
+	rep.AddService("api")
+	rep.AddFiltered("level")
+	rep.AddDLQWithReason("write_timeout")
+	rep.WrittenOK = 6
+	rep.DurationSeconds = 2
+	rep.Throughput = 5
+	rep.JSONErrorRate = 0.1
+	rep.StageTimings.WritingSeconds = 0.5
+	rep.RetryStats.TotalRetries = 3
+	rep.Shutdown.Reason = StopEOF
+	rep.InFlight.MaxBytes, rep.InFlight.PeakBytes = 4096, 2048
+	rep.Spill = &SpillStats{Dir: "/spill", Spilled: 1}
+	rep.DuplicateKeys = &DuplicateKeyStats{Lines: 1, ByKey: map[string]int{"a": 1}}
+	return rep
+}
+
+// TestLegacyJSONUnchanged pins the flat report shape that report_schema
+// legacy writes, byte for byte, for the dashboards reading it.
+func TestLegacyJSONUnchanged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+	if err := sectionsTestReport().WriteJSON(path, fsutil.Perm{}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden := filepath.Join("testdata", "report_legacy.json.golden")
+	if *update {
+		if err := os.WriteFile(golden, got, 0o644); err != nil {
+			t.Fatalf("update golden: %v", err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("read golden (run with -update to create): %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("legacy report differs from %s:\n%s", golden, got)
+	}
+}
+
+// TestV2CoversLegacyFields checks that every key of the legacy report
+// lands in the v2 provenance or in one of its sections, so a field added
+// to Report is not left out of v2.
+func TestV2CoversLegacyFields(t *testing.T) {
+	rep := sectionsTestReport()
+	rep.Panic = &PanicInfo{Where: "reader"}
+	rep.DropRules = &DropRuleStats{}
+	rep.HTTPConnections = &HTTPConnStats{}
+	rep.Router = &RouterStats{}
+	rep.NATSInput = &NATSInputStats{}
+	rep.JournaldInput = &JournaldInputStats{}
+	rep.QueueWait = &QueueWaitStats{}
+	rep.Faults = &FaultStats{}
+	rep.Quotas = &QuotaStats{}
+	rep.TimestampShift = &TimestampShiftStats{}
+	rep.ReplayPacing = &ReplayPacingStats{}
+	rep.OutputPaths = &OutputPathStats{}
+	rep.LargeLines = &LargeLineStats{}
+	rep.SchemaValidation = &SchemaStats{ByViolation: map[string]int{"type at /a": 1}}
+	rep.TopMessages = []MessageCount{{}}
+	rep.EnableTransformAudit()
+	rep.SetRedactions(map[string]int{"token": 1})
+	rep.InputMode, rep.InputFiles, rep.ManifestPath = InputConcat, []InputFile{{Path: "a"}}, "m.json"
+	rep.LimitLabelValues(1, nil)
+	rep.AddNamespace("a")
+	rep.AddNamespace("b")
+
+	var legacy, v2 map[string]json.RawMessage
+	mustRoundTrip(t, rep.Snapshot(), &legacy)
+	mustRoundTrip(t, rep.V2(), &v2)
+	var ops, quality map[string]json.RawMessage
+	mustRoundTrip(t, v2["operational"], &ops)
+	mustRoundTrip(t, v2["data_quality"], &quality)
+	for key, want := range legacy {
+		got, ok := v2[key]
+		if !ok {
+			got, ok = ops[key]
+		}
+		if !ok {
+			got, ok = quality[key]
+		}
+		if !ok {
+			t.Errorf("legacy key %q is not in the v2 report", key)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("v2 %s = %s, legacy has %s", key, got, want)
+		}
+	}
+	if string(v2["schema"]) != `"v2"` {
+		t.Errorf("schema = %s, want v2", v2["schema"])
+	}
+}
+
+func TestWriteSections(t *testing.T) {
+	dir := t.TempDir()
+	rep := sectionsTestReport()
+	opsPath, qualityPath, v2Path := filepath.Join(dir, "ops.json"), filepath.Join(dir, "quality.json"), filepath.Join(dir, "v2.json")
+	if err := rep.WriteOperationalJSON(opsPath, fsutil.Perm{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rep.WriteDataQualityJSON(qualityPath, fsutil.Perm{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rep.WriteJSONSchema(v2Path, SchemaV2, fsutil.Perm{}); err != nil {
+		t.Fatal(err)
+	}
+	var ops Operational
+	var quality DataQuality
+	var v2 ReportV2
+	readJSONFile(t, opsPath, &ops)
+	readJSONFile(t, qualityPath, &quality)
+	readJSONFile(t, v2Path, &v2)
+	if ops.WrittenOK != 6 || ops.RetryStats.TotalRetries != 3 || ops.Spill == nil {
+		t.Errorf("operational = %+v", ops)
+	}
+	if quality.JSONFailed != 1 || quality.ByLevel["ERROR"] != 1 || quality.DLQReasons["write_timeout"] != 1 {
+		t.Errorf("data quality = %+v", quality)
+	}
+	if v2.RunID != "run-1" || v2.Operational.WrittenOK != 6 || v2.DataQuality.NormalizedFailed != 1 {
+		t.Errorf("v2 = %+v", v2)
+	}
+}
+
+// TestPrometheusCoversBothSections checks that the Prometheus output,
+// which keeps its metric names whatever the report schema, has metrics
+// from each section.
+func TestPrometheusCoversBothSections(t *testing.T) {
+	out := sectionsTestReport().Prometheus()
+	for _, metric := range []string{
+		// operational
+		"etl_throughput_lines_per_sec", "etl_written_ok", "etl_retry_total", "etl_stage_timing_seconds", "etl_inflight_peak_bytes", "etl_spill_records_total",
+		// data quality
+		"etl_json_failed", "etl_normalize_failures_total", "etl_filtered_total", "etl_level_total", "etl_dlq_reason_total", "etl_duplicate_key_lines_total",
+	} {
+		if !strings.Contains(out, "\n"+metric) {
+			t.Errorf("Prometheus output lacks %s", metric)
+		}
+	}
+}
+
+func mustRoundTrip(t *testing.T, v, out any) {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readJSONFile(t *testing.T, path string, v any) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,205 @@
+package report
+
+import (
+	"container/heap"
+	"sort"
+	"strings"
+)
+
+// MessageCount is one entry in the report's top_messages list.
+type MessageCount struct {
+	Template string `json:"template"`
+	Count    int    `json:"count"`
+	Example  string `json:"example"`
+	// ErrorBound is how much Count may overstate the true count; it is
+	// non-zero only for templates that entered the tracker after an eviction.
+	ErrorBound int `json:"error_bound,omitempty"`
+}
+
+// topMessages tracks the most frequent message templates with the
+// Space-Saving algorithm: memory is fixed at capacity entries, and a new
+// template evicts the current minimum, inheriting its count as error.
+type topMessages struct {
+	k        int
+	capacity int
+	entries  map[string]*topEntry
+	heap     topHeap
+}
+
+type topEntry struct {
+	template string
+	example  string
+	count    int
+	err      int
+	index    int
+}
+
+func newTopMessages(k int) *topMessages {
+	capacity := k * 10
+	if capacity < 100 {
+		capacity = 100
+	}
+	return &topMessages{k: k, capacity: capacity, entries: make(map[string]*topEntry, capacity)}
+}
+
+func (t *topMessages) add(msg string) {
+	template := Fingerprint(msg)
+	if e, ok := t.entries[template]; ok {
+		e.count++
+		heap.Fix(&t.heap, e.index)
+		return
+	}
+	if len(t.heap) < t.capacity {
+		e := &topEntry{template: template, example: msg, count: 1}
+		t.entries[template] = e
+		heap.Push(&t.heap, e)
+		return
+	}
+	// Replace the minimum in place; it keeps its heap slot.
+	e := t.heap[0]
+	delete(t.entries, e.template)
+	e.err = e.count
+	e.count++
+	e.template, e.example = template, msg
+	t.entries[template] = e
+	heap.Fix(&t.heap, 0)
+}
+
+// top returns the k most frequent templates, highest count first.
+func (t *topMessages) top() []MessageCount {
+	out := make([]MessageCount, 0, len(t.heap))
+	for _, e := range t.heap {
+		out = append(out, MessageCount{Template: e.template, Count: e.count, Example: e.example, ErrorBound: e.err})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Template < out[j].Template
+	})
+	if len(out) > t.k {
+		out = out[:t.k]
+	}
+	return out
+}
+
+type topHeap []*topEntry
+
+func (h topHeap) Len() int           { return len(h) }
+func (h topHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h topHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *topHeap) Push(x any) {
+	e := x.(*topEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+func (h *topHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// Fingerprint collapses the variable parts of a message so that
+// "request 123 failed" and "request 456 failed" share a template. UUIDs
+// become <uuid>, hex identifiers (0x-prefixed, or all-hex tokens containing
+// a digit) become <hex>, and remaining digit runs become <num>.
+func Fingerprint(msg string) string {
+	var sb strings.Builder
+	sb.Grow(len(msg))
+	for i := 0; i < len(msg); {
+		if isUUIDAt(msg, i) {
+			sb.WriteString("<uuid>")
+			i += 36
+			continue
+		}
+		if !isAlnum(msg[i]) {
+			sb.WriteByte(msg[i])
+			i++
+			continue
+		}
+		j := i
+		for j < len(msg) && isAlnum(msg[j]) {
+			j++
+		}
+		writeToken(&sb, msg[i:j])
+		i = j
+	}
+	return sb.String()
+}
+
+func writeToken(sb *strings.Builder, tok string) {
+	digits, hex := 0, true
+	for k := 0; k < len(tok); k++ {
+		c := tok[k]
+		if isDigit(c) {
+			digits++
+		} else if !isHexLetter(c) {
+			hex = false
+		}
+	}
+	switch {
+	case digits == 0:
+		sb.WriteString(tok)
+	case digits == len(tok):
+		sb.WriteString("<num>")
+	case len(tok) > 2 && tok[0] == '0' && (tok[1] == 'x' || tok[1] == 'X') && isHex(tok[2:]):
+		sb.WriteString("<hex>")
+	case hex:
+		sb.WriteString("<hex>")
+	default:
+		// Mixed tokens such as "v2" or "5s" keep their letters.
+		for k := 0; k < len(tok); {
+			if !isDigit(tok[k]) {
+				sb.WriteByte(tok[k])
+				k++
+				continue
+			}
+			for k < len(tok) && isDigit(tok[k]) {
+				k++
+			}
+			sb.WriteString("<num>")
+		}
+	}
+}
+
+// isUUIDAt reports whether s has an 8-4-4-4-12 hex UUID starting at i that
+// is not part of a longer alphanumeric token.
+func isUUIDAt(s string, i int) bool {
+	if len(s)-i < 36 || (i > 0 && isAlnum(s[i-1])) {
+		return false
+	}
+	for k := 0; k < 36; k++ {
+		c := s[i+k]
+		switch k {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if !isDigit(c) && !isHexLetter(c) {
+				return false
+			}
+		}
+	}
+	return i+36 == len(s) || !isAlnum(s[i+36])
+}
+
+func isHex(s string) bool {
+	for k := 0; k < len(s); k++ {
+		if !isDigit(s[k]) && !isHexLetter(s[k]) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func isDigit(c byte) bool     { return c >= '0' && c <= '9' }
+func isHexLetter(c byte) bool { return (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F') }
+func isAlnum(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
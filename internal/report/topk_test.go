@@ -0,0 +1,76 @@
+package report
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	cases := map[string]string{
+		"request 123 failed": "request <num> failed",
+		"request 456 failed": "request <num> failed",
+		"user 550e8400-e29b-41d4-a716-446655440000 logged in": "user <uuid> logged in",
+		"ptr 0x7ffee3b0 freed":                                "ptr <hex> freed",
+		"commit deadbeef12 pushed":                            "commit <hex> pushed",
+		"retry in 5s on v2":                                   "retry in <num>s on v<num>",
+		"pod orders-7f9c8d-x2 restarted":                      "pod orders-<hex>-x<num> restarted",
+		"no variable parts":                                   "no variable parts",
+		"took 1.25ms":                                         "took <num>.<num>ms",
+		"id=550e8400-e29b-41d4-a716-446655440000,status=500":  "id=<uuid>,status=<num>",
+	}
+	for in, want := range cases {
+		if got := Fingerprint(in); got != want {
+			t.Errorf("Fingerprint(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestTopMessagesOrdersByCount(t *testing.T) {
+	rep := NewReport()
+	rep.EnableTopMessages(2)
+	for i := 0; i < 5; i++ {
+		rep.AddMessage(fmt.Sprintf("request %d failed", i))
+	}
+	for i := 0; i < 3; i++ {
+		rep.AddMessage(fmt.Sprintf("cache miss for key %d", i))
+	}
+	rep.AddMessage("startup complete")
+	rep.SetDuration(1)
+
+	if len(rep.TopMessages) != 2 {
+		t.Fatalf("expected top 2, got %+v", rep.TopMessages)
+	}
+	first, second := rep.TopMessages[0], rep.TopMessages[1]
+	if first.Template != "request <num> failed" || first.Count != 5 || first.Example != "request 0 failed" {
+		t.Errorf("unexpected first entry: %+v", first)
+	}
+	if second.Template != "cache miss for key <num>" || second.Count != 3 {
+		t.Errorf("unexpected second entry: %+v", second)
+	}
+}
+
+func TestTopMessagesIsSpaceBounded(t *testing.T) {
+	tm := newTopMessages(1)
+	// One heavy hitter among many distinct one-off templates.
+	for i := 0; i < 1000; i++ {
+		tm.add("hot path")
+		tm.add(fmt.Sprintf("unique %c%c%c", 'a'+i%26, 'a'+(i/26)%26, 'a'+(i/676)%26))
+	}
+	if len(tm.entries) > tm.capacity || len(tm.heap) > tm.capacity {
+		t.Fatalf("tracker grew past capacity %d: %d entries", tm.capacity, len(tm.entries))
+	}
+	top := tm.top()
+	if len(top) != 1 || top[0].Template != "hot path" || top[0].Count < 1000 {
+		t.Fatalf("expected hot path to stay on top, got %+v", top)
+	}
+}
+
+func TestTopMessagesDisabled(t *testing.T) {
+	rep := NewReport()
+	rep.EnableTopMessages(0)
+	rep.AddMessage("anything")
+	rep.SetDuration(1)
+	if rep.TopMessages != nil {
+		t.Fatalf("expected no top messages when disabled, got %+v", rep.TopMessages)
+	}
+}
@@ -0,0 +1,278 @@
+package sink
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s-log-etl/internal/model"
+	"k8s-log-etl/internal/report"
+)
+
+// AggregateOptions configures an AggregateSink.
+type AggregateOptions struct {
+	Window     time.Duration // event-time bucket size
+	GroupBy    []string      // record fields to group by (see model.Normalized.Field)
+	ValueField string        // optional numeric field for sum/min/max
+	Lateness   time.Duration // how long past its end a bucket stays open
+	Amend      bool          // emit late records as amendment rows instead of dropping them
+	MaxBuckets int           // open buckets before the oldest is flushed early
+}
+
+// AggregateRow is one output row: the counts for a bucket and group.
+type AggregateRow struct {
+	BucketStart string            `json:"bucket_start"`
+	BucketEnd   string            `json:"bucket_end"`
+	Group       map[string]string `json:"group"`
+	Count       int               `json:"count"`
+	Sum         *float64          `json:"sum,omitempty"`
+	Min         *float64          `json:"min,omitempty"`
+	Max         *float64          `json:"max,omitempty"`
+	Amendment   bool              `json:"amendment,omitempty"`
+}
+
+type aggBucket struct {
+	start  time.Time
+	groups map[string]*aggGroup
+}
+
+type aggGroup struct {
+	dims     []string
+	count    int
+	values   int // records that carried a numeric ValueField
+	sum      float64
+	min, max float64
+}
+
+// AggregateSink replaces records with per-bucket, per-group counts. Buckets
+// are keyed by event time (the record's TS) and flushed to the wrapped sink
+// once the newest event time seen passes their end plus Lateness, when more
+// than MaxBuckets are open, and on Close.
+//
+// A record is counted once, when its write returns nil, so retrying a
+// failed write never counts it twice. Rows a flush could not write stay
+// pending: the next write writes them before counting its own record, and
+// fails without counting it while they cannot be written.
+type AggregateSink struct {
+	wrapped Writer
+	opts    AggregateOptions
+
+	mu             sync.Mutex
+	buckets        map[int64]*aggBucket
+	watermark      time.Time // newest event time seen
+	flushedThrough time.Time // buckets starting before this have been flushed
+
+	rows       int
+	late       int
+	amendments int
+	evicted    int
+}
+
+// NewAggregateSink wraps w so it receives aggregate rows instead of records.
+func NewAggregateSink(w Writer, opts AggregateOptions) (*AggregateSink, error) {
+	if opts.Window <= 0 {
+		return nil, fmt.Errorf("%w: aggregate window must be positive", ErrOpenSink)
+	}
+	if opts.MaxBuckets <= 0 {
+		opts.MaxBuckets = 60
+	}
+	return &AggregateSink{wrapped: w, opts: opts, buckets: make(map[int64]*aggBucket)}, nil
+}
+
+// Write adds a model.Normalized record to its bucket and flushes any buckets
+// the event time has moved past.
+func (as *AggregateSink) Write(record interface{}) error {
+	n, ok := record.(model.Normalized)
+	if !ok {
+		return fmt.Errorf("%w: aggregate sink expects normalized records, got %T", ErrWriteSink, record)
+	}
+	ts, ok := n.EventTime()
+	if !ok {
+		return fmt.Errorf("%w: aggregate sink: invalid ts %q", ErrWriteSink, n.TS)
+	}
+	start := ts.UTC().Truncate(as.opts.Window)
+	dims := make([]string, len(as.opts.GroupBy))
+	for i, name := range as.opts.GroupBy {
+		if v, ok := n.Field(name); ok {
+			dims[i] = fmt.Sprint(v)
+		}
+	}
+	value, hasValue := 0.0, false
+	if as.opts.ValueField != "" {
+		if raw, ok := n.Field(as.opts.ValueField); ok {
+			value, hasValue = model.AsFloat(raw)
+		}
+	}
+
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	if err := as.flushCompleted(); err != nil {
+		return err
+	}
+	if start.Before(as.flushedThrough) {
+		if as.opts.Amend {
+			g := &aggGroup{dims: dims}
+			g.add(value, hasValue)
+			if err := as.writeRow(start, g, true); err != nil {
+				return err
+			}
+			as.amendments++
+		}
+		as.late++
+		return nil
+	}
+
+	key := start.UnixNano()
+	b := as.buckets[key]
+	if b == nil {
+		b = &aggBucket{start: start, groups: make(map[string]*aggGroup)}
+		as.buckets[key] = b
+	}
+	gk := strings.Join(dims, "\xff")
+	g := b.groups[gk]
+	if g == nil {
+		g = &aggGroup{dims: dims}
+		b.groups[gk] = g
+	}
+	g.add(value, hasValue)
+
+	if ts.After(as.watermark) {
+		as.watermark = ts
+	}
+	// The record is counted: a failed flush is not its failure, and is
+	// reported by the next write or Close, which flush again.
+	as.flushCompleted()
+	return nil
+}
+
+func (g *aggGroup) add(value float64, hasValue bool) {
+	g.count++
+	if !hasValue {
+		return
+	}
+	if g.values == 0 || value < g.min {
+		g.min = value
+	}
+	if g.values == 0 || value > g.max {
+		g.max = value
+	}
+	g.sum += value
+	g.values++
+}
+
+// flushCompleted flushes, oldest first, buckets past their end plus
+// Lateness and any beyond MaxBuckets. Callers hold as.mu.
+func (as *AggregateSink) flushCompleted() error {
+	for _, b := range as.sortedBuckets() {
+		complete := !as.watermark.Before(b.start.Add(as.opts.Window + as.opts.Lateness))
+		overCap := len(as.buckets) > as.opts.MaxBuckets
+		if !complete && !overCap {
+			break
+		}
+		if err := as.flushBucket(b); err != nil {
+			return err
+		}
+		if !complete {
+			as.evicted++
+		}
+	}
+	return nil
+}
+
+func (as *AggregateSink) sortedBuckets() []*aggBucket {
+	out := make([]*aggBucket, 0, len(as.buckets))
+	for _, b := range as.buckets {
+		out = append(out, b)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].start.Before(out[j].start) })
+	return out
+}
+
+// flushBucket writes one row per group, sorted by group values, and drops
+// the bucket once all are written. A failed flush keeps the groups not
+// written yet, so flushing the bucket again does not repeat the others.
+// Callers hold as.mu.
+func (as *AggregateSink) flushBucket(b *aggBucket) error {
+	keys := make([]string, 0, len(b.groups))
+	for k := range b.groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := as.writeRow(b.start, b.groups[k], false); err != nil {
+			return err
+		}
+		delete(b.groups, k)
+	}
+	delete(as.buckets, b.start.UnixNano())
+	if end := b.start.Add(as.opts.Window); end.After(as.flushedThrough) {
+		as.flushedThrough = end
+	}
+	return nil
+}
+
+func (as *AggregateSink) writeRow(start time.Time, g *aggGroup, amendment bool) error {
+	row := AggregateRow{
+		BucketStart: start.Format(time.RFC3339),
+		BucketEnd:   start.Add(as.opts.Window).Format(time.RFC3339),
+		Group:       make(map[string]string, len(as.opts.GroupBy)),
+		Count:       g.count,
+		Amendment:   amendment,
+	}
+	for i, name := range as.opts.GroupBy {
+		row.Group[name] = g.dims[i]
+	}
+	if g.values > 0 {
+		sum, lo, hi := g.sum, g.min, g.max
+		row.Sum, row.Min, row.Max = &sum, &lo, &hi
+	}
+	if err := as.wrapped.Write(row); err != nil {
+		return err
+	}
+	as.rows++
+	return nil
+}
+
+// Unwrap implements Unwrapper.
+func (as *AggregateSink) Unwrap() Writer {
+	return as.wrapped
+}
+
+// Close flushes every open bucket and closes the wrapped sink.
+func (as *AggregateSink) Close() error {
+	as.mu.Lock()
+	var flushErr error
+	for _, b := range as.sortedBuckets() {
+		if err := as.flushBucket(b); err != nil && flushErr == nil {
+			flushErr = err
+		}
+	}
+	as.mu.Unlock()
+	if err := as.wrapped.Close(); err != nil {
+		return err
+	}
+	return flushErr
+}
+
+// WritePrometheus implements report.Collector.
+func (as *AggregateSink) WritePrometheus(w io.Writer) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	for _, m := range []struct {
+		name, typ, help string
+		value           int
+	}{
+		{"etl_aggregate_rows_total", report.Counter, "Aggregate rows written.", as.rows},
+		{"etl_aggregate_late_total", report.Counter, "Records that arrived after their window was flushed.", as.late},
+		{"etl_aggregate_amendments_total", report.Counter, "Amendment rows written for late records.", as.amendments},
+		{"etl_aggregate_evicted_buckets_total", report.Counter, "Windows flushed early to stay under aggregate_max_buckets.", as.evicted},
+		{"etl_aggregate_open_buckets", report.Gauge, "Windows still open.", len(as.buckets)},
+	} {
+		report.WriteFamily(w, m.name, m.typ, m.help)
+		report.WriteSample(w, m.name, float64(m.value))
+	}
+}
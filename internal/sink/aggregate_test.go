@@ -0,0 +1,188 @@
+package sink
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s-log-etl/internal/model"
+)
+
+func aggRecord(ts, service, level string, latency any) model.Normalized {
+	n := model.Normalized{TS: ts, Service: service, Level: level}
+	if latency != nil {
+		n.Fields = map[string]any{"latency_ms": latency}
+	}
+	return n
+}
+
+func rows(t *testing.T, tw *testWriter) []AggregateRow {
+	t.Helper()
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	out := make([]AggregateRow, 0, len(tw.records))
+	for _, r := range tw.records {
+		out = append(out, r.(AggregateRow))
+	}
+	return out
+}
+
+func TestAggregateSink_FlushesAsEventTimeAdvances(t *testing.T) {
+	tw := &testWriter{}
+	as, err := NewAggregateSink(tw, AggregateOptions{
+		Window:     time.Minute,
+		GroupBy:    []string{"service", "level"},
+		ValueField: "latency_ms",
+	})
+	if err != nil {
+		t.Fatalf("NewAggregateSink: %v", err)
+	}
+
+	for _, rec := range []model.Normalized{
+		aggRecord("2024-01-01T12:00:05Z", "api", "ERROR", 10.0),
+		aggRecord("2024-01-01T12:00:30Z", "api", "ERROR", "30"),
+		aggRecord("2024-01-01T12:00:40Z", "db", "WARN", nil),
+	} {
+		if err := as.Write(rec); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if got := rows(t, tw); len(got) != 0 {
+		t.Fatalf("expected no rows before the bucket completes, got %+v", got)
+	}
+
+	// Crossing into the next minute completes the first bucket.
+	if err := as.Write(aggRecord("2024-01-01T12:01:00Z", "api", "ERROR", nil)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	got := rows(t, tw)
+	if len(got) != 2 {
+		t.Fatalf("expected 2 rows for the first bucket, got %+v", got)
+	}
+	api := got[0]
+	if api.BucketStart != "2024-01-01T12:00:00Z" || api.BucketEnd != "2024-01-01T12:01:00Z" ||
+		api.Group["service"] != "api" || api.Count != 2 || *api.Sum != 40 || *api.Min != 10 || *api.Max != 30 {
+		t.Errorf("unexpected api row: %+v", api)
+	}
+	if db := got[1]; db.Group["service"] != "db" || db.Count != 1 || db.Sum != nil {
+		t.Errorf("unexpected db row: %+v", db)
+	}
+
+	if err := as.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if got := rows(t, tw); len(got) != 3 || got[2].BucketStart != "2024-01-01T12:01:00Z" {
+		t.Fatalf("expected the open bucket to flush on close, got %+v", got)
+	}
+}
+
+func TestAggregateSink_LateRecords(t *testing.T) {
+	for _, amend := range []bool{false, true} {
+		tw := &testWriter{}
+		as, err := NewAggregateSink(tw, AggregateOptions{Window: time.Minute, GroupBy: []string{"service"}, Amend: amend})
+		if err != nil {
+			t.Fatalf("NewAggregateSink: %v", err)
+		}
+		as.Write(aggRecord("2024-01-01T12:00:05Z", "api", "ERROR", nil))
+		as.Write(aggRecord("2024-01-01T12:01:05Z", "api", "ERROR", nil))
+		// The 12:00 bucket has been flushed; this record is late.
+		if err := as.Write(aggRecord("2024-01-01T12:00:50Z", "api", "ERROR", nil)); err != nil {
+			t.Fatalf("Write late: %v", err)
+		}
+
+		got := rows(t, tw)
+		if as.late != 1 {
+			t.Errorf("amend=%v: expected 1 late record, got %d", amend, as.late)
+		}
+		switch {
+		case !amend && len(got) != 1:
+			t.Errorf("expected late record to be dropped, got %+v", got)
+		case amend && (len(got) != 2 || !got[1].Amendment || got[1].Count != 1 || got[1].BucketStart != "2024-01-01T12:00:00Z"):
+			t.Errorf("expected an amendment row, got %+v", got)
+		}
+		as.Close()
+	}
+}
+
+func TestAggregateSink_MaxBucketsBoundsMemory(t *testing.T) {
+	tw := &testWriter{}
+	as, err := NewAggregateSink(tw, AggregateOptions{Window: time.Minute, Lateness: time.Hour, MaxBuckets: 2})
+	if err != nil {
+		t.Fatalf("NewAggregateSink: %v", err)
+	}
+	for _, ts := range []string{"2024-01-01T12:00:00Z", "2024-01-01T12:01:00Z", "2024-01-01T12:02:00Z"} {
+		if err := as.Write(aggRecord(ts, "api", "ERROR", nil)); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if len(as.buckets) != 2 || as.evicted != 1 {
+		t.Fatalf("expected 2 open buckets and 1 eviction, got %d open, %d evicted", len(as.buckets), as.evicted)
+	}
+	if got := rows(t, tw); len(got) != 1 || got[0].BucketStart != "2024-01-01T12:00:00Z" {
+		t.Fatalf("expected the oldest bucket to be flushed, got %+v", got)
+	}
+
+	sb := &strings.Builder{}
+	as.WritePrometheus(sb)
+	if !strings.Contains(sb.String(), "etl_aggregate_evicted_buckets_total 1\n") {
+		t.Errorf("missing eviction metric:\n%s", sb.String())
+	}
+	as.Close()
+}
+
+// rejectingWriter fails the rows of the groups in reject.
+type rejectingWriter struct {
+	testWriter
+	reject map[string]bool
+}
+
+func (rw *rejectingWriter) Write(record interface{}) error {
+	if rw.reject[record.(AggregateRow).Group["service"]] {
+		return ErrWriteSink
+	}
+	return rw.testWriter.Write(record)
+}
+
+func TestAggregateSink_RetriedWriteCountsOnce(t *testing.T) {
+	rw := &rejectingWriter{reject: map[string]bool{"web": true}}
+	as, err := NewAggregateSink(rw, AggregateOptions{Window: time.Minute, GroupBy: []string{"service"}})
+	if err != nil {
+		t.Fatalf("NewAggregateSink: %v", err)
+	}
+	for _, rec := range []model.Normalized{
+		aggRecord("2024-01-01T12:00:10Z", "api", "ERROR", nil),
+		aggRecord("2024-01-01T12:00:20Z", "web", "ERROR", nil),
+		// Counted even though the flush it triggers fails halfway.
+		aggRecord("2024-01-01T12:01:00Z", "api", "ERROR", nil),
+	} {
+		if err := as.Write(rec); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	// The pending web row fails the next write, which is retried until it
+	// can be written.
+	rec := aggRecord("2024-01-01T12:01:05Z", "api", "ERROR", nil)
+	for range 3 {
+		if err := as.Write(rec); err == nil {
+			t.Fatal("Write succeeded with a row pending that cannot be written")
+		}
+	}
+	delete(rw.reject, "web")
+	if err := as.Write(rec); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := as.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	var got []string
+	for _, r := range rows(t, &rw.testWriter) {
+		got = append(got, r.BucketStart+" "+r.Group["service"]+" "+strconv.Itoa(r.Count))
+	}
+	want := []string{"2024-01-01T12:00:00Z api 1", "2024-01-01T12:00:00Z web 1", "2024-01-01T12:01:00Z api 2"}
+	if !slices.Equal(got, want) {
+		t.Errorf("rows %q, want %q", got, want)
+	}
+}
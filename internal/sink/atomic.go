@@ -0,0 +1,67 @@
+package sink
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"k8s-log-etl/internal/fsutil"
+)
+
+// AtomicTempPath is where an atomic file sink writes until CommitAtomic
+// moves the output into place.
+func AtomicTempPath(path string) string {
+	return path + ".tmp"
+}
+
+// NewAtomicFileSink writes JSONL to AtomicTempPath(path). Close syncs and
+// closes the temp file; the caller then publishes it with CommitAtomic or
+// discards it with AbortAtomic, so readers of path never see partial output.
+func NewAtomicFileSink(path string) (*JSONLSink, error) {
+	f, err := createAtomic(path, fsutil.Perm{})
+	if err != nil {
+		return nil, err
+	}
+	return newFileJSONLSink(f, path, DefaultJSONEncoding), nil
+}
+
+// createAtomic creates the temp file for an atomic sink publishing to path.
+// The rename keeps its mode and owner, so perm applies to the output too.
+func createAtomic(path string, perm fsutil.Perm) (io.WriteCloser, error) {
+	f, err := perm.Create(AtomicTempPath(path))
+	if err != nil {
+		return nil, fileError(ErrOpenSink, err)
+	}
+	return syncCloser{f}, nil
+}
+
+// CommitAtomic renames the temp file written by NewAtomicFileSink to path.
+func CommitAtomic(path string) error {
+	if err := fsutil.Rename(AtomicTempPath(path), path); err != nil {
+		return fmt.Errorf("%w: %v", ErrCommitSink, err)
+	}
+	return nil
+}
+
+// AbortAtomic removes the temp file written by NewAtomicFileSink, leaving
+// any previous output at path untouched.
+func AbortAtomic(path string) error {
+	if err := fsutil.Remove(AtomicTempPath(path)); err != nil {
+		return fmt.Errorf("%w: %v", ErrCommitSink, err)
+	}
+	return nil
+}
+
+// syncCloser flushes the file to disk before closing it so a committed
+// rename never points at data still in the page cache.
+type syncCloser struct {
+	*os.File
+}
+
+func (f syncCloser) Close() error {
+	if err := f.Sync(); err != nil {
+		f.File.Close()
+		return err
+	}
+	return f.File.Close()
+}
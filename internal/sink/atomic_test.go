@@ -0,0 +1,67 @@
+package sink
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAtomicFileSinkCommit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.jsonl")
+
+	s, err := NewAtomicFileSink(path)
+	if err != nil {
+		t.Fatalf("init sink: %v", err)
+	}
+	if err := s.Write(map[string]any{"i": 1}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected no output before commit, stat err=%v", err)
+	}
+
+	if err := CommitAtomic(path); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	if string(data) != "{\"i\":1}\n" {
+		t.Fatalf("unexpected output %q", data)
+	}
+	if _, err := os.Stat(AtomicTempPath(path)); !os.IsNotExist(err) {
+		t.Fatalf("expected temp file to be gone, stat err=%v", err)
+	}
+}
+
+func TestAtomicFileSinkAbortKeepsPreviousOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.jsonl")
+	if err := os.WriteFile(path, []byte("previous\n"), 0o644); err != nil {
+		t.Fatalf("seed output: %v", err)
+	}
+
+	s, err := NewAtomicFileSink(path)
+	if err != nil {
+		t.Fatalf("init sink: %v", err)
+	}
+	s.Write(map[string]any{"i": 1})
+	s.Close()
+
+	if err := AbortAtomic(path); err != nil {
+		t.Fatalf("abort: %v", err)
+	}
+	if _, err := os.Stat(AtomicTempPath(path)); !os.IsNotExist(err) {
+		t.Fatalf("expected temp file to be removed, stat err=%v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "previous\n" {
+		t.Fatalf("expected previous output to be untouched, got %q", data)
+	}
+	// Aborting twice is harmless.
+	if err := AbortAtomic(path); err != nil {
+		t.Fatalf("second abort: %v", err)
+	}
+}
@@ -0,0 +1,167 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s-log-etl/internal/model"
+)
+
+// AvroSchema is the Avro schema of a model.Normalized record. Every value is
+// a string, empty when unset; Fields values are JSON-encoded so nested
+// values keep their type.
+const AvroSchema = `{"type":"record","name":"Normalized","namespace":"k8s_log_etl","fields":[` +
+	`{"name":"ts","type":"string"},` +
+	`{"name":"level","type":"string"},` +
+	`{"name":"service","type":"string","default":""},` +
+	`{"name":"namespace","type":"string","default":""},` +
+	`{"name":"pod","type":"string","default":""},` +
+	`{"name":"node","type":"string","default":""},` +
+	`{"name":"message","type":"string"},` +
+	`{"name":"trace_id","type":"string","default":""},` +
+	`{"name":"error","type":"string","default":""},` +
+	`{"name":"stacktrace","type":"string","default":""},` +
+	`{"name":"caller","type":"string","default":""},` +
+	`{"name":"fields","type":{"type":"map","values":"string"},"default":{}}]}`
+
+// confluentMagic starts every record in the Confluent wire format, followed
+// by the 4-byte big-endian schema ID.
+const confluentMagic = 0
+
+// AvroFormat encodes model.Normalized records as Avro binary datums of
+// AvroSchema. With a schema ID (see SchemaRegistry) each datum is framed
+// in the Confluent wire format; with ID < 0 it is written bare. Other
+// record types and Fields values that cannot be JSON-encoded are ErrFormat
+// errors.
+func AvroFormat(schemaID int) LineFormat {
+	return func(record any) ([]byte, error) {
+		n, ok := record.(model.Normalized)
+		if !ok {
+			return nil, fmt.Errorf("%w: avro: unsupported record type %T", ErrFormat, record)
+		}
+		var buf []byte
+		if schemaID >= 0 {
+			buf = append(buf, confluentMagic)
+			buf = binary.BigEndian.AppendUint32(buf, uint32(schemaID))
+		}
+		return appendAvro(buf, n)
+	}
+}
+
+func appendAvro(buf []byte, n model.Normalized) ([]byte, error) {
+	for _, s := range []string{n.TS, n.Level, n.Service, n.Namespace, n.Pod, n.Node, n.Message, n.TraceID, n.Error, n.Stacktrace, n.Caller} {
+		buf = appendAvroString(buf, s)
+	}
+	// Maps are written as one block of pairs and an empty block; sorting
+	// the keys keeps the bytes stable across runs.
+	if len(n.Fields) > 0 {
+		keys := make([]string, 0, len(n.Fields))
+		for k := range n.Fields {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		buf = binary.AppendVarint(buf, int64(len(keys)))
+		for _, k := range keys {
+			v, err := json.Marshal(n.Fields[k])
+			if err != nil {
+				return nil, fmt.Errorf("%w: avro: field %q: %v", ErrFormat, k, err)
+			}
+			buf = appendAvroString(buf, k)
+			buf = appendAvroString(buf, string(v))
+		}
+	}
+	return binary.AppendVarint(buf, 0), nil
+}
+
+// appendAvroString appends s as an Avro string: its zig-zag varint length,
+// then its bytes. binary.AppendVarint uses the same zig-zag encoding as
+// Avro longs.
+func appendAvroString(buf []byte, s string) []byte {
+	buf = binary.AppendVarint(buf, int64(len(s)))
+	return append(buf, s...)
+}
+
+// SchemaRegistry looks up and registers schemas in a Confluent Schema
+// Registry, caching the IDs.
+type SchemaRegistry struct {
+	url    string
+	client *http.Client
+	mu     sync.Mutex
+	ids    map[string]int // by subject + schema
+}
+
+// NewSchemaRegistry returns a client for the registry at baseURL.
+func NewSchemaRegistry(baseURL string) *SchemaRegistry {
+	return &SchemaRegistry{
+		url:    strings.TrimRight(baseURL, "/"),
+		client: &http.Client{Timeout: 10 * time.Second},
+		ids:    make(map[string]int),
+	}
+}
+
+// ID returns the ID of schema under subject, registering it when the
+// subject does not have it yet. Errors wrap ErrOpenSink.
+func (r *SchemaRegistry) ID(ctx context.Context, subject, schema string) (int, error) {
+	key := subject + "\x00" + schema
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if id, ok := r.ids[key]; ok {
+		return id, nil
+	}
+	base := r.url + "/subjects/" + url.PathEscape(subject)
+	id, err := r.post(ctx, base, schema)
+	if errors.Is(err, errSchemaNotFound) {
+		id, err = r.post(ctx, base+"/versions", schema)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%w: schema registry: subject %q: %v", ErrOpenSink, subject, err)
+	}
+	r.ids[key] = id
+	return id, nil
+}
+
+// errSchemaNotFound is a 404 from a lookup: the subject or the schema under
+// it does not exist.
+var errSchemaNotFound = errors.New("schema not found")
+
+func (r *SchemaRegistry) post(ctx context.Context, endpoint, schema string) (int, error) {
+	body, err := json.Marshal(map[string]string{"schema": schema})
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return 0, errSchemaNotFound
+	case resp.StatusCode/100 != 2:
+		return 0, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(data))
+	}
+	var out struct {
+		ID *int `json:"id"`
+	}
+	if err := json.Unmarshal(data, &out); err != nil || out.ID == nil {
+		return 0, fmt.Errorf("unexpected response: %s", bytes.TrimSpace(data))
+	}
+	return *out.ID, nil
+}
@@ -0,0 +1,184 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/model"
+)
+
+// readHexFixture returns the bytes of a testdata hex dump: hex pairs,
+// with everything after a # on a line a comment.
+func readHexFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var digits strings.Builder
+	for _, line := range strings.Split(string(data), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		digits.WriteString(strings.Join(strings.Fields(line), ""))
+	}
+	out, err := hex.DecodeString(digits.String())
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return out
+}
+
+// stubRegistry answers lookups with 404 until the schema is registered.
+type stubRegistry struct {
+	mu       sync.Mutex
+	schemas  map[string]string // by subject
+	requests []string
+}
+
+func (s *stubRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	var body struct{ Schema string }
+	json.NewDecoder(r.Body).Decode(&body)
+	switch r.URL.Path {
+	case "/subjects/logs-value":
+		if s.schemas["logs-value"] != body.Schema {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"error_code":40401,"message":"Subject not found"}`)
+			return
+		}
+	case "/subjects/logs-value/versions":
+		s.schemas["logs-value"] = body.Schema
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	io.WriteString(w, `{"subject":"logs-value","version":1,"id":42}`)
+}
+
+// TestAvroFormat_Encoding checks the datums against bytes worked out from
+// the Avro specification in testdata/avro_record.hex, not against a
+// decoder sharing the encoder's reading of it.
+func TestAvroFormat_Encoding(t *testing.T) {
+	rec := model.Normalized{
+		TS:      "2024-01-01T12:00:00Z",
+		Level:   "ERROR",
+		Service: "payments",
+		Message: "boom",
+		TraceID: "t1",
+		Error:   "timeout",
+		Fields: map[string]any{
+			"http":  map[string]any{"status": float64(500)},
+			"retry": true,
+			"user":  "u1",
+		},
+	}
+	datum := readHexFixture(t, "avro_record.hex")
+	for _, tt := range []struct {
+		id   int
+		want []byte
+	}{
+		{-1, datum},
+		{42, append([]byte{0, 0, 0, 0, 42}, datum...)},
+	} {
+		got, err := AvroFormat(tt.id)(rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("id %d: % x\nwant % x", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestAvroFormat_EncodingErrorsArePermanent(t *testing.T) {
+	format := AvroFormat(-1)
+	if _, err := format(map[string]any{"key": "row"}); !errors.Is(err, ErrFormat) {
+		t.Errorf("unsupported record: err = %v, want ErrFormat", err)
+	}
+	if _, err := format(model.Normalized{Fields: map[string]any{"bad": math.Inf(1)}}); !errors.Is(err, ErrFormat) {
+		t.Errorf("unencodable field: err = %v, want ErrFormat", err)
+	}
+}
+
+func TestSchemaRegistry_RegistersOnceAndCaches(t *testing.T) {
+	stub := &stubRegistry{schemas: map[string]string{}}
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+
+	reg := NewSchemaRegistry(srv.URL + "/")
+	for i := 0; i < 2; i++ {
+		id, err := reg.ID(context.Background(), "logs-value", AvroSchema)
+		if err != nil || id != 42 {
+			t.Fatalf("ID = %d, %v; want 42", id, err)
+		}
+	}
+	want := []string{"POST /subjects/logs-value", "POST /subjects/logs-value/versions"}
+	if !reflect.DeepEqual(stub.requests, want) {
+		t.Errorf("requests = %v, want %v", stub.requests, want)
+	}
+
+	// A new client finds the registered schema by lookup alone.
+	stub.requests = nil
+	if id, err := NewSchemaRegistry(srv.URL).ID(context.Background(), "logs-value", AvroSchema); err != nil || id != 42 {
+		t.Fatalf("ID = %d, %v; want 42", id, err)
+	}
+	if len(stub.requests) != 1 {
+		t.Errorf("requests = %v, want a single lookup", stub.requests)
+	}
+
+	if _, err := reg.ID(context.Background(), "other", AvroSchema); !errors.Is(err, ErrOpenSink) {
+		t.Errorf("registry error: err = %v, want ErrOpenSink", err)
+	}
+}
+
+func TestBuild_AvroFileWithRegistry(t *testing.T) {
+	stub := &stubRegistry{schemas: map[string]string{}}
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+
+	cfg := config.Default()
+	cfg.OutputType = "file"
+	cfg.OutputFormat = config.FormatAvro
+	cfg.OutputPath = filepath.Join(t.TempDir(), "out.avro")
+	cfg.AvroRegistryURL = srv.URL
+	cfg.AvroSubject = "logs-value"
+	w, err := Build(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recs := []model.Normalized{
+		{TS: "2024-01-01T12:00:00Z", Level: "ERROR", Message: "one"},
+		{TS: "2024-01-01T12:00:01Z", Level: "WARN", Message: "two", Fields: map[string]any{"n": float64(2)}},
+	}
+	for _, r := range recs {
+		if err := w.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(cfg.OutputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := readHexFixture(t, "avro_registry.hex"); !bytes.Equal(data, want) {
+		t.Errorf("file = % x\nwant % x", data, want)
+	}
+}
@@ -0,0 +1,252 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const azureStorageVersion = "2021-08-06"
+
+// Azurite's well-known development account.
+const (
+	azuriteAccount  = "devstoreaccount1"
+	azuriteKey      = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+	azuriteEndpoint = "http://127.0.0.1:10000/devstoreaccount1"
+)
+
+// AzureBlobBackend uploads objects as block blobs to an Azure Storage
+// container. Credentials come from the environment, as for the Azure CLI
+// and SDKs: AZURE_STORAGE_CONNECTION_STRING, or AZURE_STORAGE_ACCOUNT with
+// AZURE_STORAGE_KEY or AZURE_STORAGE_SAS_TOKEN, or a service principal in
+// AZURE_TENANT_ID, AZURE_CLIENT_ID, and AZURE_CLIENT_SECRET.
+type AzureBlobBackend struct {
+	container string
+	endpoint  string // account blob endpoint, without a trailing slash
+	account   string
+	key       []byte // shared key, or nil
+	sas       string // SAS query, or ""
+	token     *azureToken
+	client    *http.Client
+}
+
+// NewAzureBlobBackend returns a backend for container.
+func NewAzureBlobBackend(container string) (*AzureBlobBackend, error) {
+	b := &AzureBlobBackend{container: container, client: &http.Client{Timeout: 5 * time.Minute}}
+	if err := b.configure(); err != nil {
+		return nil, fmt.Errorf("%w: azblob: %v", ErrOpenSink, err)
+	}
+	return b, nil
+}
+
+func (b *AzureBlobBackend) configure() error {
+	var key string
+	if cs := os.Getenv("AZURE_STORAGE_CONNECTION_STRING"); cs != "" {
+		kv := map[string]string{}
+		for _, part := range strings.Split(cs, ";") {
+			if k, v, ok := strings.Cut(part, "="); ok {
+				kv[strings.ToLower(strings.TrimSpace(k))] = strings.TrimSpace(v)
+			}
+		}
+		if strings.EqualFold(kv["usedevelopmentstorage"], "true") {
+			b.account, key, b.endpoint = azuriteAccount, azuriteKey, azuriteEndpoint
+		} else {
+			b.account, key, b.sas, b.endpoint = kv["accountname"], kv["accountkey"], kv["sharedaccesssignature"], kv["blobendpoint"]
+			if b.endpoint == "" && b.account != "" {
+				proto, suffix := kv["defaultendpointsprotocol"], kv["endpointsuffix"]
+				if proto == "" {
+					proto = "https"
+				}
+				if suffix == "" {
+					suffix = "core.windows.net"
+				}
+				b.endpoint = proto + "://" + b.account + ".blob." + suffix
+			}
+		}
+		if b.endpoint == "" {
+			return errors.New("AZURE_STORAGE_CONNECTION_STRING has no AccountName or BlobEndpoint")
+		}
+		if key == "" && b.sas == "" {
+			return errors.New("AZURE_STORAGE_CONNECTION_STRING has no AccountKey or SharedAccessSignature")
+		}
+	} else {
+		b.account = os.Getenv("AZURE_STORAGE_ACCOUNT")
+		if b.account == "" {
+			return errors.New("set AZURE_STORAGE_CONNECTION_STRING or AZURE_STORAGE_ACCOUNT")
+		}
+		b.endpoint = "https://" + b.account + ".blob.core.windows.net"
+		key, b.sas = os.Getenv("AZURE_STORAGE_KEY"), os.Getenv("AZURE_STORAGE_SAS_TOKEN")
+		if key == "" && b.sas == "" {
+			tenant, id, secret := os.Getenv("AZURE_TENANT_ID"), os.Getenv("AZURE_CLIENT_ID"), os.Getenv("AZURE_CLIENT_SECRET")
+			if tenant == "" || id == "" || secret == "" {
+				return errors.New("set AZURE_STORAGE_KEY, AZURE_STORAGE_SAS_TOKEN, or AZURE_TENANT_ID, AZURE_CLIENT_ID, and AZURE_CLIENT_SECRET")
+			}
+			b.token = &azureToken{tenant: tenant, clientID: id, secret: secret, client: &http.Client{Timeout: 30 * time.Second}}
+		}
+	}
+	b.endpoint = strings.TrimRight(b.endpoint, "/")
+	b.sas = strings.TrimPrefix(b.sas, "?")
+	if key != "" {
+		var err error
+		if b.key, err = base64.StdEncoding.DecodeString(key); err != nil {
+			return fmt.Errorf("account key is not base64: %v", err)
+		}
+	}
+	return nil
+}
+
+// Put implements ObjectBackend with a single Put Blob request.
+func (b *AzureBlobBackend) Put(ctx context.Context, key string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	endpoint := b.endpoint + "/" + url.PathEscape(b.container) + "/" + escapeBlobName(key)
+	if b.sas != "" && b.key == nil {
+		endpoint += "?" + b.sas
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("x-ms-blob-type", "BlockBlob")
+	req.Header.Set("x-ms-version", azureStorageVersion)
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	switch {
+	case b.key != nil:
+		req.Header.Set("Authorization", "SharedKey "+b.account+":"+b.sign(req))
+	case b.token != nil:
+		tok, err := b.token.get(ctx)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+tok)
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("azblob: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// escapeBlobName escapes each segment of a blob name, keeping the slashes.
+func escapeBlobName(name string) string {
+	parts := strings.Split(name, "/")
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+	return strings.Join(parts, "/")
+}
+
+// sign returns the Shared Key signature of req.
+func (b *AzureBlobBackend) sign(req *http.Request) string {
+	h := req.Header
+	length := ""
+	if req.ContentLength > 0 {
+		length = strconv.FormatInt(req.ContentLength, 10)
+	}
+	var sb strings.Builder
+	for _, v := range []string{
+		req.Method, h.Get("Content-Encoding"), h.Get("Content-Language"), length,
+		h.Get("Content-MD5"), h.Get("Content-Type"), "", h.Get("If-Modified-Since"),
+		h.Get("If-Match"), h.Get("If-None-Match"), h.Get("If-Unmodified-Since"), h.Get("Range"),
+	} {
+		sb.WriteString(v)
+		sb.WriteByte('\n')
+	}
+	var names []string
+	for name := range h {
+		if n := strings.ToLower(name); strings.HasPrefix(n, "x-ms-") {
+			names = append(names, n)
+		}
+	}
+	slices.Sort(names)
+	for _, n := range names {
+		sb.WriteString(n + ":" + strings.TrimSpace(h.Get(n)) + "\n")
+	}
+	sb.WriteString("/" + b.account + req.URL.EscapedPath())
+	query := req.URL.Query()
+	params := make([]string, 0, len(query))
+	for name := range query {
+		params = append(params, name)
+	}
+	slices.Sort(params)
+	for _, p := range params {
+		vals := slices.Clone(query[p])
+		slices.Sort(vals)
+		sb.WriteString("\n" + strings.ToLower(p) + ":" + strings.Join(vals, ","))
+	}
+	mac := hmac.New(sha256.New, b.key)
+	mac.Write([]byte(sb.String()))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// azureToken fetches Microsoft Entra ID tokens for a service principal and
+// caches them until shortly before they expire.
+type azureToken struct {
+	tenant, clientID, secret string
+	client                   *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func (t *azureToken) get(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != "" && time.Until(t.expires) > time.Minute {
+		return t.token, nil
+	}
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {t.clientID},
+		"client_secret": {t.secret},
+		"scope":         {"https://storage.azure.com/.default"},
+	}
+	endpoint := "https://login.microsoftonline.com/" + url.PathEscape(t.tenant) + "/oauth2/v2.0/token"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("azblob: access token: %v", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("azblob: access token: %s: %s", resp.Status, bytes.TrimSpace(data))
+	}
+	var out struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(data, &out); err != nil || out.AccessToken == "" {
+		return "", errors.New("azblob: access token: unexpected response")
+	}
+	t.token = out.AccessToken
+	t.expires = time.Now().Add(time.Duration(out.ExpiresIn) * time.Second)
+	return t.token, nil
+}
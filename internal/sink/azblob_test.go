@@ -0,0 +1,85 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAzureBlobBackend_Credentials(t *testing.T) {
+	tests := []struct {
+		name            string
+		env             map[string]string
+		endpoint, sas   string
+		sharedKey, fail bool
+	}{
+		{"connection string", map[string]string{"AZURE_STORAGE_CONNECTION_STRING": "DefaultEndpointsProtocol=https;AccountName=acct;AccountKey=a2V5;EndpointSuffix=core.chinacloudapi.cn"}, "https://acct.blob.core.chinacloudapi.cn", "", true, false},
+		{"development storage", map[string]string{"AZURE_STORAGE_CONNECTION_STRING": "UseDevelopmentStorage=true"}, azuriteEndpoint, "", true, false},
+		{"sas token", map[string]string{"AZURE_STORAGE_ACCOUNT": "acct", "AZURE_STORAGE_SAS_TOKEN": "?sv=2021&sig=x"}, "https://acct.blob.core.windows.net", "sv=2021&sig=x", false, false},
+		{"account without credentials", map[string]string{"AZURE_STORAGE_ACCOUNT": "acct"}, "", "", false, true},
+		{"nothing", nil, "", "", false, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, k := range []string{"AZURE_STORAGE_CONNECTION_STRING", "AZURE_STORAGE_ACCOUNT", "AZURE_STORAGE_KEY", "AZURE_STORAGE_SAS_TOKEN", "AZURE_TENANT_ID", "AZURE_CLIENT_ID", "AZURE_CLIENT_SECRET"} {
+				t.Setenv(k, tc.env[k])
+			}
+			b, err := NewAzureBlobBackend("logs")
+			if tc.fail {
+				if !errors.Is(err, ErrOpenSink) {
+					t.Errorf("err = %v, want ErrOpenSink", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b.endpoint != tc.endpoint || b.sas != tc.sas || (b.key != nil) != tc.sharedKey {
+				t.Errorf("endpoint %q, sas %q, shared key %v", b.endpoint, b.sas, b.key != nil)
+			}
+		})
+	}
+}
+
+func TestAzureBlobBackend_PutBlockBlob(t *testing.T) {
+	var req *http.Request
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req = r
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+	t.Setenv("AZURE_STORAGE_CONNECTION_STRING", "AccountName=devstoreaccount1;AccountKey="+azuriteKey+";BlobEndpoint="+srv.URL+"/devstoreaccount1/")
+
+	b, err := NewAzureBlobBackend("logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Put(context.Background(), "k8s/a b.jsonl", strings.NewReader("{}\n")); err != nil {
+		t.Fatal(err)
+	}
+	if req.Method != http.MethodPut || req.URL.EscapedPath() != "/devstoreaccount1/logs/k8s/a%20b.jsonl" || body != "{}\n" {
+		t.Errorf("%s %s body %q", req.Method, req.URL.EscapedPath(), body)
+	}
+	if req.Header.Get("x-ms-blob-type") != "BlockBlob" || !strings.HasPrefix(req.Header.Get("Authorization"), "SharedKey devstoreaccount1:") {
+		t.Errorf("headers = %v", req.Header)
+	}
+
+	t.Setenv("AZURE_STORAGE_CONNECTION_STRING", "BlobEndpoint="+srv.URL+"/acct;SharedAccessSignature=sv=2021&sig=x")
+	b, err = NewAzureBlobBackend("logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Put(context.Background(), "k.jsonl", strings.NewReader("x")); err != nil {
+		t.Fatal(err)
+	}
+	if req.URL.RawQuery != "sv=2021&sig=x" || req.Header.Get("Authorization") != "" {
+		t.Errorf("SAS request query %q, headers %v", req.URL.RawQuery, req.Header)
+	}
+}
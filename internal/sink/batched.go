@@ -4,24 +4,43 @@ import (
 	"context"
 	"sync"
 	"time"
+
+	"k8s-log-etl/internal/clock"
 )
 
+// BatchWriter is implemented by sinks that write a batch in one request.
+// BatchedSink hands them each flush whole instead of record by record.
+type BatchWriter interface {
+	WriteBatch(ctx context.Context, records []any) error
+}
+
 // BatchedSink wraps a Writer to batch writes for better performance.
 type BatchedSink struct {
-	wrapped      Writer
-	batchSize    int
+	wrapped       Writer
+	batchSize     int
 	flushInterval time.Duration
-	buffer       []interface{}
-	mu           sync.Mutex
-	flushTicker  *time.Ticker
-	done         chan struct{}
-	wg           sync.WaitGroup
-	ctx          context.Context
-	cancel       context.CancelFunc
+	buffer        []interface{}
+	pending       []*Pending // for each record in buffer, nil unless deferred
+	mu            sync.Mutex
+	flushMu       sync.Mutex // serializes writes to the wrapped sink
+	clock         clock.Clock
+	flushTicker   clock.Ticker
+	done          chan struct{}
+	wg            sync.WaitGroup
+	ctx           context.Context
+	cancel        context.CancelFunc
+	// panicked is set by flushLoop before it exits; Close reads it after
+	// waiting for the loop.
+	panicked *WritePanic
 }
 
 // NewBatchedSink creates a new batched sink wrapper.
 func NewBatchedSink(wrapped Writer, batchSize int, flushInterval time.Duration) (*BatchedSink, error) {
+	return NewBatchedSinkWithClock(wrapped, batchSize, flushInterval, clock.Real)
+}
+
+// NewBatchedSinkWithClock is NewBatchedSink with the flush ticker driven by clk.
+func NewBatchedSinkWithClock(wrapped Writer, batchSize int, flushInterval time.Duration, clk clock.Clock) (*BatchedSink, error) {
 	if batchSize <= 0 {
 		return nil, ErrOpenSink
 	}
@@ -31,17 +50,18 @@ func NewBatchedSink(wrapped Writer, batchSize int, flushInterval time.Duration)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	bs := &BatchedSink{
-		wrapped:      wrapped,
-		batchSize:    batchSize,
+		wrapped:       wrapped,
+		clock:         clk,
+		batchSize:     batchSize,
 		flushInterval: flushInterval,
-		buffer:       make([]interface{}, 0, batchSize),
-		done:         make(chan struct{}),
-		ctx:          ctx,
-		cancel:       cancel,
+		buffer:        make([]interface{}, 0, batchSize),
+		done:          make(chan struct{}),
+		ctx:           ctx,
+		cancel:        cancel,
 	}
 
 	// Start flush ticker
-	bs.flushTicker = time.NewTicker(flushInterval)
+	bs.flushTicker = clk.NewTicker(flushInterval)
 	bs.wg.Add(1)
 	go bs.flushLoop()
 
@@ -50,54 +70,146 @@ func NewBatchedSink(wrapped Writer, batchSize int, flushInterval time.Duration)
 
 // Write adds a record to the batch. Flushes automatically when batch is full.
 func (bs *BatchedSink) Write(record interface{}) error {
-	bs.mu.Lock()
-	bs.buffer = append(bs.buffer, record)
-	shouldFlush := len(bs.buffer) >= bs.batchSize
-	bs.mu.Unlock()
+	if batch, pending := bs.add(record, nil); batch != nil {
+		return bs.writeBatch(context.Background(), batch, pending)
+	}
+	return nil
+}
 
-	if shouldFlush {
-		return bs.flush()
+// WriteContext adds a record to the batch like Write. Appending to the buffer
+// is never bounded by ctx; only the flush it may trigger is. A record with a
+// Pending in ctx that does not fill the batch is deferred: its outcome is
+// the flush that writes it.
+func (bs *BatchedSink) WriteContext(ctx context.Context, record interface{}) error {
+	if batch, pending := bs.add(record, pendingFrom(ctx)); batch != nil {
+		// The flush itself keeps running in the background and holds
+		// flushMu, so an abandoned flush never overlaps the next one.
+		return runContext(ctx, func() error { return bs.writeBatch(ctx, batch, pending) })
 	}
 	return nil
 }
 
-// flush writes all buffered records to the wrapped sink.
-func (bs *BatchedSink) flush() error {
+// add appends a record and, when that fills the batch, takes the batch to
+// write, so the record is written by the call that added it. The record
+// that fills the batch gets the flush's error back and is not deferred.
+func (bs *BatchedSink) add(record interface{}, p *Pending) ([]interface{}, []*Pending) {
 	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	bs.buffer = append(bs.buffer, record)
+	if len(bs.buffer) < bs.batchSize {
+		if p != nil {
+			p.deferred.Store(true)
+		}
+		bs.pending = append(bs.pending, p)
+		return nil, nil
+	}
+	bs.pending = append(bs.pending, nil)
+	return bs.take()
+}
+
+// take empties the buffer, returning its records and their Pending, nil
+// for records without one. The caller holds mu.
+func (bs *BatchedSink) take() ([]interface{}, []*Pending) {
 	if len(bs.buffer) == 0 {
-		bs.mu.Unlock()
-		return nil
+		return nil, nil
 	}
 	batch := make([]interface{}, len(bs.buffer))
 	copy(batch, bs.buffer)
+	pending := make([]*Pending, len(bs.pending))
+	copy(pending, bs.pending)
 	bs.buffer = bs.buffer[:0]
+	clear(bs.pending)
+	bs.pending = bs.pending[:0]
+	return batch, pending
+}
+
+// flush writes all buffered records to the wrapped sink.
+func (bs *BatchedSink) flush() error {
+	return bs.Flush(context.Background())
+}
+
+// Flush writes all buffered records to the wrapped sink, passing ctx to
+// sinks that support it, once any flush under way is done. It implements
+// Flusher.
+func (bs *BatchedSink) Flush(ctx context.Context) error {
+	bs.flushMu.Lock()
+	defer bs.flushMu.Unlock()
+	bs.mu.Lock()
+	batch, pending := bs.take()
 	bs.mu.Unlock()
+	return bs.writeLocked(ctx, batch, pending)
+}
 
+// writeBatch writes a batch add took, after any flush under way.
+func (bs *BatchedSink) writeBatch(ctx context.Context, batch []interface{}, pending []*Pending) error {
+	bs.flushMu.Lock()
+	defer bs.flushMu.Unlock()
+	return bs.writeLocked(ctx, batch, pending)
+}
+
+// writeLocked writes batch to the wrapped sink and settles the Pending of
+// its deferred records with the outcome. The caller holds flushMu.
+func (bs *BatchedSink) writeLocked(ctx context.Context, batch []interface{}, pending []*Pending) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	err := bs.writeTo(ctx, batch)
+	for _, p := range pending {
+		if p != nil {
+			p.done(err)
+		}
+	}
+	return err
+}
+
+// writeTo writes batch to the wrapped sink, passing ctx to sinks that
+// support it.
+func (bs *BatchedSink) writeTo(ctx context.Context, batch []interface{}) error {
+	if bw, ok := bs.wrapped.(BatchWriter); ok {
+		return bw.WriteBatch(ctx, batch)
+	}
+	cw, hasContext := bs.wrapped.(ContextWriter)
 	// Write all records in the batch
 	for _, record := range batch {
-		if err := bs.wrapped.Write(record); err != nil {
+		var err error
+		if hasContext {
+			err = cw.WriteContext(ctx, record)
+		} else {
+			err = bs.wrapped.Write(record)
+		}
+		if err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-// flushLoop periodically flushes the buffer.
+// flushLoop periodically flushes the buffer. A panic in a flush ends the
+// loop and is raised again by Close, on the goroutine that owns the sink.
 func (bs *BatchedSink) flushLoop() {
 	defer bs.wg.Done()
+	defer func() {
+		if v := recover(); v != nil {
+			bs.panicked = newWritePanic(v)
+		}
+	}()
 	for {
 		select {
 		case <-bs.ctx.Done():
 			return
-		case <-bs.flushTicker.C:
-			if err := bs.flush(); err != nil {
-				// Log error but continue
-				continue
-			}
+		case <-bs.flushTicker.C():
+			// A failed flush reaches its deferred records through their
+			// Pending; the next tick flushes what was written since.
+			bs.flush()
 		}
 	}
 }
 
+// Unwrap implements Unwrapper.
+func (bs *BatchedSink) Unwrap() Writer {
+	return bs.wrapped
+}
+
 // Close flushes remaining records and closes the wrapped sink.
 func (bs *BatchedSink) Close() error {
 	// Stop ticker and flush loop
@@ -105,6 +217,10 @@ func (bs *BatchedSink) Close() error {
 	bs.flushTicker.Stop()
 	close(bs.done)
 	bs.wg.Wait()
+	if bs.panicked != nil {
+		bs.wrapped.Close()
+		panic(bs.panicked)
+	}
 
 	// Flush remaining records
 	if err := bs.flush(); err != nil {
@@ -113,4 +229,3 @@ func (bs *BatchedSink) Close() error {
 
 	return bs.wrapped.Close()
 }
-
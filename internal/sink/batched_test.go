@@ -1,20 +1,28 @@
 package sink
 
 import (
+	"context"
+	"errors"
 	"sync"
 	"testing"
 	"time"
+
+	"k8s-log-etl/internal/clock"
 )
 
 type testWriter struct {
 	records []interface{}
 	mu      sync.Mutex
+	written chan struct{} // optional; signaled after each write
 }
 
 func (tw *testWriter) Write(record interface{}) error {
 	tw.mu.Lock()
-	defer tw.mu.Unlock()
 	tw.records = append(tw.records, record)
+	tw.mu.Unlock()
+	if tw.written != nil {
+		tw.written <- struct{}{}
+	}
 	return nil
 }
 
@@ -22,9 +30,16 @@ func (tw *testWriter) Close() error {
 	return nil
 }
 
+func (tw *testWriter) count() int {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	return len(tw.records)
+}
+
 func TestBatchedSink_Write(t *testing.T) {
 	tw := &testWriter{}
-	bs, err := NewBatchedSink(tw, 3, 100*time.Millisecond)
+	clk := clock.NewFake(time.Unix(0, 0))
+	bs, err := NewBatchedSinkWithClock(tw, 3, 100*time.Millisecond, clk)
 	if err != nil {
 		t.Fatalf("NewBatchedSink: %v", err)
 	}
@@ -37,28 +52,23 @@ func TestBatchedSink_Write(t *testing.T) {
 	if err := bs.Write("record2"); err != nil {
 		t.Fatalf("Write: %v", err)
 	}
-
-	// Give it a moment to ensure no premature flush
-	time.Sleep(10 * time.Millisecond)
-	if len(tw.records) != 0 {
-		t.Errorf("expected 0 records, got %d", len(tw.records))
+	if n := tw.count(); n != 0 {
+		t.Errorf("expected 0 records, got %d", n)
 	}
 
-	// Write 3rd record (should trigger flush)
+	// Write 3rd record (flushes synchronously)
 	if err := bs.Write("record3"); err != nil {
 		t.Fatalf("Write: %v", err)
 	}
-
-	// Wait for flush
-	time.Sleep(50 * time.Millisecond)
-	if len(tw.records) != 3 {
-		t.Errorf("expected 3 records after flush, got %d", len(tw.records))
+	if n := tw.count(); n != 3 {
+		t.Errorf("expected 3 records after flush, got %d", n)
 	}
 }
 
 func TestBatchedSink_FlushInterval(t *testing.T) {
-	tw := &testWriter{}
-	bs, err := NewBatchedSink(tw, 10, 50*time.Millisecond)
+	tw := &testWriter{written: make(chan struct{}, 1)}
+	clk := clock.NewFake(time.Unix(0, 0))
+	bs, err := NewBatchedSinkWithClock(tw, 10, 50*time.Millisecond, clk)
 	if err != nil {
 		t.Fatalf("NewBatchedSink: %v", err)
 	}
@@ -69,16 +79,26 @@ func TestBatchedSink_FlushInterval(t *testing.T) {
 		t.Fatalf("Write: %v", err)
 	}
 
-	// Wait for flush interval
-	time.Sleep(100 * time.Millisecond)
-	if len(tw.records) != 1 {
-		t.Errorf("expected 1 record after flush interval, got %d", len(tw.records))
+	clk.Advance(49 * time.Millisecond)
+	if n := tw.count(); n != 0 {
+		t.Errorf("expected no flush before the interval, got %d records", n)
+	}
+
+	// The tick at 50ms flushes from the background loop.
+	clk.Advance(time.Millisecond)
+	select {
+	case <-tw.written:
+	case <-time.After(5 * time.Second):
+		t.Fatal("flush interval did not flush")
+	}
+	if n := tw.count(); n != 1 {
+		t.Errorf("expected 1 record after flush interval, got %d", n)
 	}
 }
 
 func TestBatchedSink_Close(t *testing.T) {
 	tw := &testWriter{}
-	bs, err := NewBatchedSink(tw, 10, 100*time.Millisecond)
+	bs, err := NewBatchedSinkWithClock(tw, 10, 100*time.Millisecond, clock.NewFake(time.Unix(0, 0)))
 	if err != nil {
 		t.Fatalf("NewBatchedSink: %v", err)
 	}
@@ -96,8 +116,169 @@ func TestBatchedSink_Close(t *testing.T) {
 		t.Fatalf("Close: %v", err)
 	}
 
-	if len(tw.records) != 2 {
-		t.Errorf("expected 2 records after close, got %d", len(tw.records))
+	if n := tw.count(); n != 2 {
+		t.Errorf("expected 2 records after close, got %d", n)
 	}
 }
 
+type blockingWriter struct {
+	testWriter
+	release chan struct{}
+}
+
+func (bw *blockingWriter) Write(record interface{}) error {
+	<-bw.release
+	return bw.testWriter.Write(record)
+}
+
+func TestBatchedSink_WriteContextTimesOutOnFlush(t *testing.T) {
+	bw := &blockingWriter{release: make(chan struct{})}
+	bs, err := NewBatchedSink(bw, 2, time.Hour)
+	if err != nil {
+		t.Fatalf("NewBatchedSink: %v", err)
+	}
+
+	// Appending is not bounded by the deadline, even an expired one.
+	expired, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	if err := bs.WriteContext(expired, "record1"); err != nil {
+		t.Fatalf("append should ignore deadline: %v", err)
+	}
+
+	// The second write triggers a flush, which is bounded.
+	ctx, cancel2 := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel2()
+	err = bs.WriteContext(ctx, "record2")
+	var abandoned *AbandonedWrite
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.As(err, &abandoned) {
+		t.Fatalf("expected an abandoned write past its deadline, got %v", err)
+	}
+
+	close(bw.release)
+	if returned, err := abandoned.Wait(context.Background()); !returned || err != nil {
+		t.Fatalf("abandoned flush: returned %v, %v", returned, err)
+	}
+	if err := bs.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if len(bw.records) != 2 {
+		t.Errorf("expected abandoned flush to complete with 2 records, got %d", len(bw.records))
+	}
+}
+
+func TestBatchedSink_FlushErrorDropsRestOfBatch(t *testing.T) {
+	tw := &testWriter{}
+	// The second write to reach tw fails. The flush stops there and the rest
+	// of the batch, already taken from the buffer, is lost.
+	fs := NewFaultySink(tw, FaultyOptions{FailEvery: 2})
+	bs, err := NewBatchedSinkWithClock(fs, 3, time.Hour, clock.NewFake(time.Unix(0, 0)))
+	if err != nil {
+		t.Fatalf("NewBatchedSink: %v", err)
+	}
+	bs.Write("a")
+	bs.Write("b")
+	if err := bs.Write("c"); !errors.Is(err, ErrInjected) {
+		t.Fatalf("flush error = %v, want ErrInjected", err)
+	}
+	if n := tw.count(); n != 1 {
+		t.Errorf("expected the flush to stop after 1 record, got %d", n)
+	}
+	if err := bs.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if n := tw.count(); n != 1 {
+		t.Errorf("Close should not resend the rest of a failed batch, got %d records", n)
+	}
+	if s := fs.Stats(); s.Attempts != 2 || s.Injected != 1 {
+		t.Errorf("stats = %+v", s)
+	}
+}
+
+func TestBatchedSink_PendingSettledByFlush(t *testing.T) {
+	tw := &testWriter{}
+	fs := NewFaultySink(tw, FaultyOptions{FailEvery: 2})
+	bs, err := NewBatchedSinkWithClock(fs, 3, time.Hour, clock.NewFake(time.Unix(0, 0)))
+	if err != nil {
+		t.Fatalf("NewBatchedSink: %v", err)
+	}
+	defer bs.Close()
+	var outcomes []error
+	write := func(record string) (*Pending, error) {
+		ctx, p := WithPending(context.Background(), func(err error) { outcomes = append(outcomes, err) })
+		return p, bs.WriteContext(ctx, record)
+	}
+	// Buffered records are deferred until the flush; the one filling the
+	// batch gets the flush's error back instead.
+	pa, _ := write("a")
+	pb, _ := write("b")
+	pc, err := write("c")
+	if !pa.Deferred() || !pb.Deferred() || pc.Deferred() {
+		t.Errorf("deferred = %v, %v, %v, want the first two", pa.Deferred(), pb.Deferred(), pc.Deferred())
+	}
+	if !errors.Is(err, ErrInjected) || len(outcomes) != 2 || !errors.Is(outcomes[0], ErrInjected) || !errors.Is(outcomes[1], ErrInjected) {
+		t.Fatalf("flush error = %v, outcomes %v, want ErrInjected for each", err, outcomes)
+	}
+
+	outcomes = nil
+	pd, _ := write("d")
+	if err := Flush(context.Background(), NewProjectSink(bs, []string{"x"})); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if !pd.Deferred() || len(outcomes) != 1 || outcomes[0] != nil {
+		t.Errorf("outcomes after a flush through a wrapper = %v", outcomes)
+	}
+}
+
+type panicWriter struct {
+	testWriter
+	calls chan struct{} // optional; signaled before each panic
+}
+
+func (pw *panicWriter) Write(interface{}) error {
+	if pw.calls != nil {
+		pw.calls <- struct{}{}
+	}
+	panic("flush bug")
+}
+
+// recoverWritePanic calls f and returns the *WritePanic it raised, if any.
+func recoverWritePanic(f func()) (p *WritePanic) {
+	defer func() {
+		if v := recover(); v != nil {
+			p, _ = v.(*WritePanic)
+		}
+	}()
+	f()
+	return nil
+}
+
+func TestBatchedSink_PanicReachesCaller(t *testing.T) {
+	// A flush bounded by a context runs on its own goroutine.
+	bs, err := NewBatchedSinkWithClock(&panicWriter{}, 1, time.Hour, clock.NewFake(time.Unix(0, 0)))
+	if err != nil {
+		t.Fatalf("NewBatchedSink: %v", err)
+	}
+	p := recoverWritePanic(func() { bs.WriteContext(context.Background(), "a") })
+	if p == nil || p.Value != "flush bug" || len(p.Stack) == 0 {
+		t.Fatalf("WriteContext raised %+v, want the flush panic", p)
+	}
+	bs.Close()
+
+	// So does the timed flush; Close raises what it left behind.
+	clk := clock.NewFake(time.Unix(0, 0))
+	pw := &panicWriter{calls: make(chan struct{}, 1)}
+	bs, err = NewBatchedSinkWithClock(pw, 10, time.Second, clk)
+	if err != nil {
+		t.Fatalf("NewBatchedSink: %v", err)
+	}
+	bs.Write("b")
+	clk.Advance(time.Second)
+	select {
+	case <-pw.calls:
+	case <-time.After(5 * time.Second):
+		t.Fatal("flush interval did not flush")
+	}
+	if p := recoverWritePanic(func() { bs.Close() }); p == nil || p.Value != "flush bug" {
+		t.Fatalf("Close raised %+v, want the timed flush panic", p)
+	}
+}
@@ -3,28 +3,82 @@ package sink
 import (
 	"context"
 	"fmt"
-	"net/http"
+	"io"
 	"os"
 	"strings"
+	"text/template"
 	"time"
 
 	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/natsconn"
 )
 
 // Build constructs a sink based on config.
 func Build(ctx context.Context, cfg config.Config) (Writer, error) {
+	var tmpl *template.Template
+	var format LineFormat // for the file and rotate sinks; nil for JSONL
+	enc := JSONEncodingOf(cfg)
+	switch {
+	case strings.EqualFold(cfg.OutputFormat, config.FormatTemplate):
+		var err error
+		if tmpl, err = ParseTemplate(cfg.OutputTemplate); err != nil {
+			return nil, err
+		}
+		format = TemplateFormat(tmpl)
+	case strings.EqualFold(cfg.OutputFormat, config.FormatAvro):
+		schemaID := -1
+		if cfg.AvroRegistryURL != "" {
+			subject := cfg.AvroSubject
+			if subject == "" {
+				subject = config.DefaultAvroSubject
+			}
+			var err error
+			if schemaID, err = NewSchemaRegistry(cfg.AvroRegistryURL).ID(ctx, subject, AvroSchema); err != nil {
+				return nil, err
+			}
+		}
+		format = AvroFormat(schemaID)
+	}
+	if cfg.OutputPathTemplated() {
+		if format == nil {
+			format = enc.Line
+		}
+		return buildPathTemplate(cfg, format)
+	}
 	switch strings.ToLower(cfg.OutputType) {
 	case "", "stdout":
-		return NewJSONLSink(nopCloser{os.Stdout}), nil
+		if strings.EqualFold(cfg.OutputFormat, config.FormatPretty) {
+			return NewPrettySink(nopCloser{os.Stdout}, PrettyOptions{
+				Color:  ColorEnabled(os.Stdout),
+				Width:  TerminalWidth(os.Stdout),
+				Fields: cfg.PrettyFields,
+			}), nil
+		}
+		if tmpl != nil {
+			return NewTemplateSink(nopCloser{os.Stdout}, tmpl), nil
+		}
+		return NewEncodedJSONLSink(nopCloser{os.Stdout}, enc), nil
 	case "file":
 		if cfg.OutputPath == "" {
 			return nil, fmt.Errorf("%w: output path required for file sink", ErrOpenSink)
 		}
-		f, err := os.Create(cfg.OutputPath)
+		f, err := createOutputFile(cfg)
+		if err != nil {
+			return nil, err
+		}
+		index, err := openOutputIndex(cfg)
 		if err != nil {
-			return nil, fmt.Errorf("%w: %v", ErrOpenSink, err)
+			f.Close()
+			return nil, err
 		}
-		return NewJSONLSink(f), nil
+		if format != nil {
+			s := newFileTemplateSink(f, cfg.OutputPath, format)
+			s.index = index
+			return s, nil
+		}
+		s := newFileJSONLSink(f, cfg.OutputPath, enc)
+		s.index = index
+		return s, nil
 	case "rotate", "rotating":
 		if cfg.OutputPath == "" {
 			return nil, fmt.Errorf("%w: output path required for rotating sink", ErrOpenSink)
@@ -37,12 +91,111 @@ func Build(ctx context.Context, cfg config.Config) (Writer, error) {
 		if maxFiles <= 0 {
 			maxFiles = 5
 		}
-		return NewRotatingJSONLSink(cfg.OutputPath, maxBytes, maxFiles)
+		if format == nil {
+			format = enc.Line
+		}
+		s, err := NewRotatingSink(cfg.OutputPath, maxBytes, maxFiles, format, cfg.FilePerm())
+		if err != nil {
+			return nil, err
+		}
+		if s.traces, err = openOutputIndex(cfg); err != nil {
+			s.Close()
+			return nil, err
+		}
+		return s, nil
 	case "http", "webhook":
 		if cfg.OutputPath == "" {
 			return nil, fmt.Errorf("%w: output URL required for http sink", ErrOpenSink)
 		}
-		return NewHTTPSink(ctx, cfg.OutputPath, cfg.SinkMaxRetries, time.Duration(cfg.SinkBackoffBaseMS)*time.Millisecond)
+		return NewHTTPSink(ctx, cfg.OutputPath, cfg.SinkMaxRetries, time.Duration(cfg.SinkBackoffBaseMS)*time.Millisecond, HTTPOptions{
+			Gzip:            strings.EqualFold(cfg.HTTPCompress, config.HTTPCompressGzip),
+			MaxIdleConns:    cfg.HTTPMaxIdleConns,
+			MaxConnsPerHost: cfg.HTTPMaxConnsPerHost,
+			IdleConnTimeout: cfg.HTTPIdleConnTimeoutDuration(),
+			AuthToken:       cfg.HTTPAuthToken,
+		})
+	case "clickhouse":
+		return NewClickHouseSink(ClickHouseOptions{
+			URL:         cfg.OutputPath,
+			Database:    cfg.ClickHouseDatabase,
+			Table:       cfg.ClickHouseTable,
+			User:        cfg.ClickHouseUser,
+			Password:    cfg.ClickHousePassword,
+			Gzip:        cfg.ClickHouseGzip,
+			MaxRetries:  cfg.SinkMaxRetries,
+			BackoffBase: time.Duration(cfg.SinkBackoffBaseMS) * time.Millisecond,
+		})
+	case "grpc":
+		return NewGRPCSink(GRPCOptions{
+			Target:      cfg.OutputPath,
+			TLS:         cfg.GRPCTLS,
+			CAFile:      cfg.GRPCCAFile,
+			AuthToken:   cfg.GRPCAuthToken,
+			MaxRetries:  cfg.SinkMaxRetries,
+			BackoffBase: time.Duration(cfg.SinkBackoffBaseMS) * time.Millisecond,
+		})
+	case "nats":
+		return NewNATSSink(NATSOptions{
+			Servers:      natsconn.Servers(cfg.OutputPath),
+			Subject:      cfg.NATSSubject,
+			User:         cfg.NATSUser,
+			Password:     cfg.NATSPassword,
+			Token:        cfg.NATSToken,
+			CredsFile:    cfg.NATSCredsFile,
+			MaxInFlight:  cfg.NATSMaxInFlight,
+			AckTimeout:   cfg.NATSAckTimeoutDuration(),
+			MaxRetries:   cfg.SinkMaxRetries,
+			BackoffBase:  time.Duration(cfg.SinkBackoffBaseMS) * time.Millisecond,
+			CloseTimeout: time.Duration(cfg.ShutdownTimeoutSeconds) * time.Second,
+		})
+	case "object":
+		scheme, bucket, prefix, err := ParseObjectURL(cfg.OutputPath)
+		if err != nil {
+			return nil, err
+		}
+		backend, err := NewObjectBackend(scheme, bucket)
+		if err != nil {
+			return nil, err
+		}
+		ext := ".jsonl"
+		switch {
+		case tmpl != nil:
+			ext = ".log"
+		case strings.EqualFold(cfg.OutputFormat, config.FormatAvro):
+			ext = ".avro"
+		}
+		if format == nil {
+			format = enc.Line
+		}
+		return NewObjectStoreSink(backend, ObjectStoreOptions{
+			Prefix:   prefix,
+			Ext:      ext,
+			MaxBytes: cfg.OutputMaxB,
+			MaxAge:   time.Duration(cfg.ObjectMaxAgeSeconds) * time.Second,
+			RunID:    runIDFrom(ctx),
+			Format:   format,
+		}), nil
+	case "faulty":
+		inner := cfg
+		inner.OutputType = cfg.FaultyInner
+		if strings.EqualFold(inner.OutputType, "faulty") {
+			return nil, fmt.Errorf("%w: faulty sink cannot wrap itself", ErrOpenSink)
+		}
+		w, err := Build(ctx, inner)
+		if err != nil {
+			return nil, err
+		}
+		return NewFaultySink(w, FaultyOptions{
+			FailRate:  cfg.FaultyFailRate,
+			FailEvery: cfg.FaultyFailEvery,
+			FailAfter: cfg.FaultyFailAfter,
+			Latency:   time.Duration(cfg.FaultyLatencyMS) * time.Millisecond,
+			Seed:      cfg.FaultySeed,
+		}), nil
+	case "router":
+		// Each route gets its own batching and projection, so the caller
+		// opens them and hands them to NewRouterSink.
+		return nil, fmt.Errorf("%w: output type router is opened route by route with NewRouterSink", ErrOpenSink)
 	case "s3":
 		// S3 sink would require AWS SDK - placeholder for now
 		return nil, fmt.Errorf("%w: S3 sink not yet implemented (requires AWS SDK)", ErrOpenSink)
@@ -54,6 +207,63 @@ func Build(ctx context.Context, cfg config.Config) (Writer, error) {
 	}
 }
 
+// createOutputFile creates the file for the file sink, or its temp file when
+// the output is atomic. A resumed output is opened as it is, and a named
+// pipe write-only, waiting for its reader.
+func createOutputFile(cfg config.Config) (io.WriteCloser, error) {
+	if IsFIFO(cfg.OutputPath) {
+		if cfg.OutputAtomic || cfg.OutputResume || cfg.OutputIndex != "" {
+			return nil, fmt.Errorf("%w: output %s is a named pipe; output_atomic, output_resume, and output_index need a regular file", ErrOpenSink, cfg.OutputPath)
+		}
+		return openFIFO(cfg.OutputPath)
+	}
+	if cfg.OutputAtomic {
+		return createAtomic(cfg.OutputPath, cfg.FilePerm())
+	}
+	if cfg.OutputResume {
+		f, err := openResume(cfg.OutputPath, cfg.FilePerm())
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	}
+	f, err := cfg.FilePerm().Create(cfg.OutputPath)
+	if err != nil {
+		return nil, fileError(ErrOpenSink, err)
+	}
+	return f, nil
+}
+
+// buildPathTemplate builds the file or rotate sink of an output path with
+// placeholders.
+func buildPathTemplate(cfg config.Config, format LineFormat) (Writer, error) {
+	path, err := ParseOutputPath(cfg.OutputPath, cfg.OutputPathLayout())
+	if err != nil {
+		return nil, err
+	}
+	var maxBytes int64 // file: never rotate
+	if !strings.EqualFold(cfg.OutputType, "file") {
+		maxBytes = cfg.OutputMaxB
+		if maxBytes <= 0 {
+			maxBytes = 10 * 1024 * 1024 // fallback
+		}
+	}
+	maxFiles := cfg.OutputMaxFiles
+	if maxFiles <= 0 {
+		maxFiles = 5
+	}
+	return NewPathTemplateSink(path, maxBytes, maxFiles, format, cfg.FilePerm(), cfg.OutputPathOpenFiles()), nil
+}
+
+// openOutputIndex opens the trace index of the file or rotating sink's
+// output, or returns nil without output_index.
+func openOutputIndex(cfg config.Config) (*traceIndex, error) {
+	if cfg.OutputIndex == "" {
+		return nil, nil
+	}
+	return openTraceIndex(TraceIndexPath(cfg.OutputPath), cfg.FilePerm())
+}
+
 type nopCloser struct {
 	w *os.File
 }
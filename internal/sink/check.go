@@ -0,0 +1,199 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/natsconn"
+)
+
+// probeTimeout bounds each connectivity probe of Check.
+const probeTimeout = 10 * time.Second
+
+// Check reports the problems Build would have opening a sink for cfg,
+// without opening one: no file is created or truncated and nothing is
+// written. With probe it also tries the destination: a HEAD request to an
+// http or clickhouse URL, whatever its status, a TCP connection to a grpc
+// or nats server, and opening an existing output file for writing. Router routes are each checked; their problems
+// are joined.
+func Check(ctx context.Context, cfg config.Config, probe bool) error {
+	if strings.EqualFold(cfg.OutputFormat, config.FormatTemplate) {
+		if _, err := ParseTemplate(cfg.OutputTemplate); err != nil {
+			return err
+		}
+	}
+	switch strings.ToLower(cfg.OutputType) {
+	case "", "stdout":
+		return nil
+	case "file", "rotate", "rotating":
+		if cfg.OutputPath == "" {
+			return fmt.Errorf("%w: output path required for %s sink", ErrOpenSink, cfg.OutputType)
+		}
+		if cfg.OutputPathTemplated() {
+			// The files are only known once records arrive.
+			_, err := ParseOutputPath(cfg.OutputPath, cfg.OutputPathLayout())
+			return err
+		}
+		if IsFIFO(cfg.OutputPath) {
+			// Not probed: opening a pipe for writing waits for its reader.
+			if !strings.EqualFold(cfg.OutputType, "file") {
+				return fmt.Errorf("%w: output %s is a named pipe; the %s sink needs a regular file", ErrOpenSink, cfg.OutputPath, cfg.OutputType)
+			}
+			if cfg.OutputAtomic || cfg.OutputResume || cfg.OutputIndex != "" {
+				return fmt.Errorf("%w: output %s is a named pipe; output_atomic, output_resume, and output_index need a regular file", ErrOpenSink, cfg.OutputPath)
+			}
+			return nil
+		}
+		if probe {
+			// A missing file is the directory's business; see the path check.
+			f, err := os.OpenFile(cfg.OutputPath, os.O_WRONLY, 0)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fileError(ErrOpenSink, err)
+			}
+			if f != nil {
+				f.Close()
+			}
+		}
+		return nil
+	case "http", "webhook", "clickhouse":
+		if err := checkURL(cfg.OutputPath); err != nil {
+			return err
+		}
+		if probe {
+			token := ""
+			if !strings.EqualFold(cfg.OutputType, "clickhouse") {
+				token = cfg.HTTPAuthToken
+			}
+			return probeURL(ctx, cfg.OutputPath, token)
+		}
+		return nil
+	case "grpc":
+		if _, _, err := net.SplitHostPort(cfg.OutputPath); err != nil {
+			return fmt.Errorf("%w: output %q is not a grpc host:port", ErrOpenSink, cfg.OutputPath)
+		}
+		if cfg.GRPCCAFile != "" {
+			if _, err := os.Stat(cfg.GRPCCAFile); err != nil {
+				return fmt.Errorf("%w: grpc_ca_file: %v", ErrOpenSink, err)
+			}
+		}
+		if probe {
+			return probeDial(ctx, cfg.OutputPath)
+		}
+		return nil
+	case "nats":
+		servers := natsconn.Servers(cfg.OutputPath)
+		if len(servers) == 0 {
+			return fmt.Errorf("%w: nats server URL required", ErrOpenSink)
+		}
+		if _, err := ParseNATSSubject(cfg.NATSSubject); err != nil {
+			return fmt.Errorf("%w: %v", ErrOpenSink, err)
+		}
+		if cfg.NATSCredsFile != "" {
+			if _, _, err := natsconn.ReadCreds(cfg.NATSCredsFile); err != nil {
+				return fmt.Errorf("%w: nats creds file: %v", ErrOpenSink, err)
+			}
+		}
+		if probe {
+			u, err := url.Parse(servers[0])
+			if err != nil {
+				return fmt.Errorf("%w: %v", ErrOpenSink, err)
+			}
+			host := u.Host
+			if u.Port() == "" {
+				host = net.JoinHostPort(u.Hostname(), "4222")
+			}
+			return probeDial(ctx, host)
+		}
+		return nil
+	case "object":
+		scheme, bucket, _, err := ParseObjectURL(cfg.OutputPath)
+		if err != nil {
+			return err
+		}
+		_, err = NewObjectBackend(scheme, bucket)
+		return err
+	case "faulty":
+		inner := cfg
+		inner.OutputType = cfg.FaultyInner
+		if strings.EqualFold(inner.OutputType, "faulty") {
+			return fmt.Errorf("%w: faulty sink cannot wrap itself", ErrOpenSink)
+		}
+		return Check(ctx, inner, probe)
+	case "router":
+		var errs []error
+		for _, entry := range cfg.RouterRoutes {
+			r, err := config.ParseRouterRoute(entry)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			child := cfg
+			child.OutputType, child.OutputPath = r.OutputType, r.Output
+			if err := Check(ctx, child, probe); err != nil {
+				errs = append(errs, fmt.Errorf("route %s: %w", r.Name, err))
+			}
+		}
+		return errors.Join(errs...)
+	case "s3":
+		return fmt.Errorf("%w: S3 sink not yet implemented (requires AWS SDK)", ErrOpenSink)
+	case "kafka":
+		return fmt.Errorf("%w: Kafka sink not yet implemented (requires Kafka client library)", ErrOpenSink)
+	default:
+		return fmt.Errorf("%w: unknown output type %q", ErrOpenSink, cfg.OutputType)
+	}
+}
+
+// checkURL reports whether raw is an absolute http or https URL.
+func checkURL(raw string) error {
+	if raw == "" {
+		return fmt.Errorf("%w: output URL required", ErrOpenSink)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrOpenSink, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: output %q is not an http or https URL", ErrOpenSink, raw)
+	}
+	return nil
+}
+
+// probeURL sends a HEAD request to raw. Any response will do: endpoints
+// that only take POST answer HEAD with an error status, but they answer.
+func probeURL(ctx context.Context, raw, token string) error {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, raw, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrOpenSink, err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrOpenSink, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// probeDial opens a TCP connection to addr and closes it again.
+func probeDial(ctx context.Context, addr string) error {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrOpenSink, err)
+	}
+	conn.Close()
+	return nil
+}
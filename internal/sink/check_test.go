@@ -0,0 +1,93 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s-log-etl/internal/config"
+)
+
+func TestCheckProbesHTTP(t *testing.T) {
+	var method, auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, auth = r.Method, r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}))
+	cfg := config.Config{OutputType: "http", OutputPath: srv.URL, HTTPAuthToken: "t0ken"}
+
+	if err := Check(context.Background(), cfg, false); err != nil {
+		t.Fatalf("check without probe: %v", err)
+	}
+	if method != "" {
+		t.Fatalf("check without probe sent a %s request", method)
+	}
+	// Any answer means the endpoint is reachable.
+	if err := Check(context.Background(), cfg, true); err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	if method != http.MethodHead || auth != "Bearer t0ken" {
+		t.Fatalf("probe sent %s with Authorization %q", method, auth)
+	}
+
+	srv.Close()
+	if err := Check(context.Background(), cfg, true); !errors.Is(err, ErrOpenSink) {
+		t.Fatalf("expected ErrOpenSink for a closed server, got %v", err)
+	}
+}
+
+func TestCheckDoesNotTouchFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.jsonl")
+	if err := os.WriteFile(path, []byte("kept\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Config{OutputType: "file", OutputPath: path}
+	if err := Check(context.Background(), cfg, true); err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "kept\n" {
+		t.Fatalf("check changed the output to %q", data)
+	}
+	cfg.OutputPath = filepath.Join(dir, "missing.jsonl")
+	if err := Check(context.Background(), cfg, true); err != nil {
+		t.Fatalf("check of a missing file: %v", err)
+	}
+	if _, err := os.Stat(cfg.OutputPath); !os.IsNotExist(err) {
+		t.Fatalf("check created the output, stat err=%v", err)
+	}
+}
+
+func TestCheckErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.Config
+		want []string
+	}{
+		{"unknown type", config.Config{OutputType: "ftp"}, []string{`unknown output type "ftp"`}},
+		{"file without path", config.Config{OutputType: "file"}, []string{"output path required"}},
+		{"http without scheme", config.Config{OutputType: "http", OutputPath: "collector:8080"}, []string{"not an http or https URL"}},
+		{"bad template", config.Config{OutputFormat: config.FormatTemplate, OutputTemplate: "{{.Message"}, []string{"template"}},
+		{"faulty wraps unknown", config.Config{OutputType: "faulty", FaultyInner: "ftp"}, []string{`unknown output type "ftp"`}},
+		{"router routes", config.Config{OutputType: "router", RouterRoutes: []string{"a=http:nohost", "b=ftp:x"}},
+			[]string{"route a:", "b=ftp:x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Check(context.Background(), tt.cfg, false)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not mention %q", err, want)
+				}
+			}
+		})
+	}
+}
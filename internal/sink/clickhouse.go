@@ -0,0 +1,265 @@
+package sink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+
+	"k8s-log-etl/internal/clock"
+	"k8s-log-etl/internal/model"
+)
+
+// ClickHouseOptions configures NewClickHouseSink.
+type ClickHouseOptions struct {
+	URL      string // HTTP interface, e.g. http://clickhouse:8123
+	Database string // empty for the user's default database
+	Table    string
+	User     string
+	Password string
+	// Gzip compresses request bodies.
+	Gzip        bool
+	MaxRetries  int
+	BackoffBase time.Duration
+}
+
+// ClickHouseSink inserts records into a ClickHouse table over the HTTP
+// interface with INSERT ... FORMAT JSONEachRow. Behind a BatchedSink each
+// flush is one insert.
+//
+// Rows use the v1 output names as columns, with Fields as a JSON string:
+// ts, level, service, namespace, pod, node, message, trace_id, error,
+// stacktrace, caller, fields.
+type ClickHouseSink struct {
+	endpoint string // with the query
+	opts     ClickHouseOptions
+	client   *http.Client
+	clock    clock.Clock
+}
+
+// NewClickHouseSink creates a ClickHouse sink. Nothing is sent until the
+// first write.
+func NewClickHouseSink(opts ClickHouseOptions) (*ClickHouseSink, error) {
+	u, err := url.Parse(opts.URL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("%w: invalid ClickHouse URL %q", ErrOpenSink, opts.URL)
+	}
+	if opts.Table == "" {
+		return nil, fmt.Errorf("%w: ClickHouse table required", ErrOpenSink)
+	}
+	q := u.Query()
+	q.Set("query", "INSERT INTO "+quoteIdent(opts.Table)+" FORMAT JSONEachRow")
+	if opts.Database != "" {
+		q.Set("database", opts.Database)
+	}
+	u.RawQuery = q.Encode()
+	return &ClickHouseSink{
+		endpoint: u.String(),
+		opts:     opts,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		clock:    clock.Real,
+	}, nil
+}
+
+// quoteIdent quotes a ClickHouse identifier with backticks.
+func quoteIdent(name string) string {
+	var b bytes.Buffer
+	b.WriteByte('`')
+	for _, r := range name {
+		if r == '`' || r == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('`')
+	return b.String()
+}
+
+// clickHouseRow is one JSONEachRow line.
+type clickHouseRow struct {
+	TS         string `json:"ts"`
+	Level      string `json:"level"`
+	Service    string `json:"service"`
+	Namespace  string `json:"namespace"`
+	Pod        string `json:"pod"`
+	Node       string `json:"node"`
+	Message    string `json:"message"`
+	TraceID    string `json:"trace_id"`
+	Error      string `json:"error"`
+	Stacktrace string `json:"stacktrace"`
+	Caller     string `json:"caller"`
+	Fields     string `json:"fields"`
+}
+
+// appendRow appends record as a JSONEachRow line. Records other than
+// model.Normalized, or with Fields that cannot be encoded, are ErrFormat
+// errors.
+func appendRow(buf []byte, record any) ([]byte, error) {
+	var n model.Normalized
+	switch r := record.(type) {
+	case model.Normalized:
+		n = r
+	case model.Legacy:
+		n = model.Normalized(r)
+	default:
+		return nil, fmt.Errorf("%w: clickhouse: unsupported record type %T", ErrFormat, record)
+	}
+	fields := []byte("{}")
+	if len(n.Fields) > 0 {
+		var err error
+		if fields, err = json.Marshal(n.Fields); err != nil {
+			return nil, fmt.Errorf("%w: clickhouse: fields: %v", ErrFormat, err)
+		}
+	}
+	line, err := json.Marshal(clickHouseRow{
+		TS: n.TS, Level: n.Level, Service: n.Service, Namespace: n.Namespace, Pod: n.Pod, Node: n.Node,
+		Message: n.Message, TraceID: n.TraceID, Error: n.Error, Stacktrace: n.Stacktrace, Caller: n.Caller,
+		Fields: string(fields),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: clickhouse: %v", ErrFormat, err)
+	}
+	return append(append(buf, line...), '\n'), nil
+}
+
+func (cs *ClickHouseSink) Write(record any) error {
+	return cs.WriteBatch(context.Background(), []any{record})
+}
+
+// WriteContext implements ContextWriter.
+func (cs *ClickHouseSink) WriteContext(ctx context.Context, record any) error {
+	return cs.WriteBatch(ctx, []any{record})
+}
+
+// WriteBatch implements BatchWriter, inserting records in one request.
+// Retryable failures are retried up to MaxRetries times; errors ClickHouse
+// reports for the data or the query wrap ErrRejected and are returned at
+// once.
+func (cs *ClickHouseSink) WriteBatch(ctx context.Context, records []any) error {
+	var body []byte
+	for _, r := range records {
+		var err error
+		if body, err = appendRow(body, r); err != nil {
+			return err
+		}
+	}
+	if cs.opts.Gzip {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(body)
+		if err := zw.Close(); err != nil {
+			return fmt.Errorf("%w: gzip: %v", ErrWriteSink, err)
+		}
+		body = buf.Bytes()
+	}
+
+	var err error
+	for attempt := 0; attempt <= cs.opts.MaxRetries; attempt++ {
+		if attempt > 0 {
+			if err := cs.clock.Sleep(ctx, cs.opts.BackoffBase*time.Duration(1<<(attempt-1))); err != nil {
+				return err
+			}
+		}
+		err = cs.post(ctx, body)
+		if err == nil || errors.Is(err, ErrRejected) {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
+func (cs *ClickHouseSink) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cs.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: create request: %v", ErrWriteSink, err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if cs.opts.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if cs.opts.User != "" {
+		req.Header.Set("X-ClickHouse-User", cs.opts.User)
+	}
+	if cs.opts.Password != "" {
+		req.Header.Set("X-ClickHouse-Key", cs.opts.Password)
+	}
+	resp, err := cs.client.Do(req)
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return fmt.Errorf("%w: %w: clickhouse request failed: %v", ErrWriteSink, ErrWriteTimeout, err)
+		}
+		return fmt.Errorf("%w: clickhouse request failed: %v", ErrWriteSink, err)
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	return clickHouseError(resp, msg)
+}
+
+// clickHouseCode matches the code in an exception message such as
+// "Code: 60. DB::Exception: Table default.logs does not exist".
+var clickHouseCode = regexp.MustCompile(`Code: (\d+)`)
+
+// clickHousePermanent lists exception codes for problems with the data, the
+// query, or the credentials, which no retry can fix.
+var clickHousePermanent = map[int]bool{
+	6:   true, // CANNOT_PARSE_TEXT
+	16:  true, // NO_SUCH_COLUMN_IN_TABLE
+	26:  true, // CANNOT_PARSE_QUOTED_STRING
+	27:  true, // CANNOT_PARSE_INPUT_ASSERTION_FAILED
+	41:  true, // CANNOT_PARSE_DATETIME
+	53:  true, // TYPE_MISMATCH
+	60:  true, // UNKNOWN_TABLE
+	62:  true, // SYNTAX_ERROR
+	81:  true, // UNKNOWN_DATABASE
+	117: true, // INCORRECT_DATA
+	192: true, // UNKNOWN_USER
+	193: true, // WRONG_PASSWORD
+	497: true, // ACCESS_DENIED
+	516: true, // AUTHENTICATION_FAILED
+}
+
+// clickHouseError turns a failed response into an error. The code comes
+// from the X-ClickHouse-Exception-Code header or the body. Known permanent
+// codes, and 4xx statuses without a code other than 408 and 429, wrap
+// ErrRejected.
+func clickHouseError(resp *http.Response, body []byte) error {
+	text := string(bytes.TrimSpace(body))
+	code, err := strconv.Atoi(resp.Header.Get("X-ClickHouse-Exception-Code"))
+	if err != nil {
+		code = 0
+		if m := clickHouseCode.FindStringSubmatch(text); m != nil {
+			code, _ = strconv.Atoi(m[1])
+		}
+	}
+	permanent := clickHousePermanent[code]
+	if code == 0 {
+		s := resp.StatusCode
+		permanent = s/100 == 4 && s != http.StatusRequestTimeout && s != http.StatusTooManyRequests
+	}
+	if permanent {
+		return fmt.Errorf("%w: %w: clickhouse status %d, code %d: %s", ErrWriteSink, ErrRejected, resp.StatusCode, code, text)
+	}
+	return fmt.Errorf("%w: clickhouse status %d, code %d: %s", ErrWriteSink, resp.StatusCode, code, text)
+}
+
+// Close releases idle connections.
+func (cs *ClickHouseSink) Close() error {
+	cs.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,175 @@
+package sink
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"k8s-log-etl/internal/model"
+)
+
+// clickHouseStub records inserts and answers with the queued responses,
+// then 200.
+type clickHouseStub struct {
+	t         *testing.T
+	responses []func(w http.ResponseWriter)
+	queries   []string
+	bodies    [][]map[string]any
+	headers   []http.Header
+}
+
+func (s *clickHouseStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.queries = append(s.queries, r.URL.RawQuery)
+	s.headers = append(s.headers, r.Header.Clone())
+	body := io.Reader(r.Body)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			s.t.Errorf("gzip body: %v", err)
+			return
+		}
+		body = zr
+	}
+	var rows []map[string]any
+	sc := bufio.NewScanner(body)
+	for sc.Scan() {
+		var row map[string]any
+		if err := json.Unmarshal(sc.Bytes(), &row); err != nil {
+			s.t.Errorf("row is not JSON: %q", sc.Text())
+		}
+		rows = append(rows, row)
+	}
+	s.bodies = append(s.bodies, rows)
+	if len(s.responses) > 0 {
+		respond := s.responses[0]
+		s.responses = s.responses[1:]
+		respond(w)
+	}
+}
+
+func TestClickHouseSink_InsertsBatchAsJSONEachRow(t *testing.T) {
+	for _, gz := range []bool{false, true} {
+		stub := &clickHouseStub{t: t}
+		srv := httptest.NewServer(stub)
+		cs, err := NewClickHouseSink(ClickHouseOptions{URL: srv.URL, Database: "logs", Table: "events", User: "etl", Password: "secret", Gzip: gz})
+		if err != nil {
+			t.Fatal(err)
+		}
+		bs, err := NewBatchedSink(cs, 2, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recs := []any{
+			model.Normalized{TS: "2024-01-01T12:00:00Z", Level: "ERROR", Service: "api", Message: "boom", TraceID: "t1", Fields: map[string]any{"status": float64(500)}},
+			model.Legacy{TS: "2024-01-01T12:00:01Z", Level: "WARN", Service: "api", Message: "slow"},
+		}
+		for _, r := range recs {
+			if err := bs.Write(r); err != nil {
+				t.Fatalf("gzip=%v: Write: %v", gz, err)
+			}
+		}
+		if err := bs.Close(); err != nil {
+			t.Fatal(err)
+		}
+		srv.Close()
+
+		if len(stub.queries) != 1 {
+			t.Fatalf("gzip=%v: %d inserts, want one for the batch", gz, len(stub.queries))
+		}
+		if q := stub.queries[0]; q != "database=logs&query=INSERT+INTO+%60events%60+FORMAT+JSONEachRow" {
+			t.Errorf("query = %s", q)
+		}
+		h := stub.headers[0]
+		if h.Get("X-ClickHouse-User") != "etl" || h.Get("X-ClickHouse-Key") != "secret" {
+			t.Errorf("credentials not sent: %v", h)
+		}
+		rows := stub.bodies[0]
+		if len(rows) != 2 {
+			t.Fatalf("gzip=%v: %d rows, want 2", gz, len(rows))
+		}
+		if rows[0]["ts"] != "2024-01-01T12:00:00Z" || rows[0]["trace_id"] != "t1" || rows[0]["fields"] != `{"status":500}` || rows[0]["node"] != "" {
+			t.Errorf("row 0 = %v", rows[0])
+		}
+		if rows[1]["message"] != "slow" || rows[1]["fields"] != "{}" || len(rows[1]) != 12 {
+			t.Errorf("row 1 = %v", rows[1])
+		}
+	}
+}
+
+func TestClickHouseSink_ErrorClassification(t *testing.T) {
+	exception := func(status int, header, body string) func(http.ResponseWriter) {
+		return func(w http.ResponseWriter) {
+			if header != "" {
+				w.Header().Set("X-ClickHouse-Exception-Code", header)
+			}
+			w.WriteHeader(status)
+			io.WriteString(w, body)
+		}
+	}
+	tests := []struct {
+		name     string
+		resp     func(http.ResponseWriter)
+		rejected bool
+		attempts int
+	}{
+		{"unknown table in body", exception(404, "", "Code: 60. DB::Exception: Table logs.events does not exist. (UNKNOWN_TABLE)"), true, 1},
+		{"bad data in header", exception(500, "27", "Cannot parse input"), true, 1},
+		{"memory limit is retried", exception(500, "241", "Code: 241. DB::Exception: Memory limit exceeded"), false, 3},
+		{"bad request without code", exception(400, "", "bad request"), true, 1},
+		{"unavailable without code is retried", exception(503, "", "try later"), false, 3},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			stub := &clickHouseStub{t: t, responses: []func(http.ResponseWriter){tc.resp, tc.resp, tc.resp}}
+			srv := httptest.NewServer(stub)
+			defer srv.Close()
+			cs, err := NewClickHouseSink(ClickHouseOptions{URL: srv.URL, Table: "events", MaxRetries: 2, BackoffBase: time.Millisecond})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = cs.Write(model.Normalized{TS: "2024-01-01T12:00:00Z", Level: "ERROR", Message: "m"})
+			if err == nil || !errors.Is(err, ErrWriteSink) {
+				t.Fatalf("err = %v, want a write error", err)
+			}
+			if errors.Is(err, ErrRejected) != tc.rejected {
+				t.Errorf("err = %v, rejected want %v", err, tc.rejected)
+			}
+			if len(stub.queries) != tc.attempts {
+				t.Errorf("%d attempts, want %d", len(stub.queries), tc.attempts)
+			}
+		})
+	}
+
+	t.Run("recovers after a retry", func(t *testing.T) {
+		stub := &clickHouseStub{t: t, responses: []func(http.ResponseWriter){exception(503, "", "busy")}}
+		srv := httptest.NewServer(stub)
+		defer srv.Close()
+		cs, _ := NewClickHouseSink(ClickHouseOptions{URL: srv.URL, Table: "events", MaxRetries: 1, BackoffBase: time.Millisecond})
+		if err := cs.WriteContext(context.Background(), model.Normalized{Message: "m"}); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	})
+
+	t.Run("unsupported records are format errors", func(t *testing.T) {
+		cs, _ := NewClickHouseSink(ClickHouseOptions{URL: "http://127.0.0.1:1", Table: "events"})
+		if err := cs.Write(map[string]any{"row": 1}); !errors.Is(err, ErrFormat) {
+			t.Errorf("err = %v, want ErrFormat", err)
+		}
+	})
+}
+
+func TestQuoteIdent(t *testing.T) {
+	if got := quoteIdent("we`ird\\name"); got != "`we\\`ird\\\\name`" {
+		t.Errorf("quoteIdent = %s", got)
+	}
+	if got := quoteIdent("events"); got != "`events`" {
+		t.Errorf("quoteIdent = %s", got)
+	}
+}
@@ -0,0 +1,151 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// ContextWriter is implemented by sinks that can abandon a write when the
+// context is done (e.g. HTTP requests, batched flushes).
+type ContextWriter interface {
+	WriteContext(ctx context.Context, record any) error
+}
+
+// WriteContext writes record to w, honoring ctx cancellation and deadlines.
+// Sinks implementing ContextWriter are called directly. For other sinks the
+// write runs in a goroutine and an *AbandonedWrite is returned if ctx
+// finishes first; the abandoned write may still complete in the background.
+func WriteContext(ctx context.Context, w Writer, record any) error {
+	if cw, ok := w.(ContextWriter); ok {
+		return cw.WriteContext(ctx, record)
+	}
+	return runContext(ctx, func() error { return w.Write(record) })
+}
+
+// runContext runs write in a goroutine and returns its error, or an
+// *AbandonedWrite wrapping ctx.Err() if ctx finishes first. A panic in write
+// is raised again in the caller as a *WritePanic, so it is not lost with the
+// goroutine.
+func runContext(ctx context.Context, write func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	panicked := make(chan *WritePanic, 1)
+	go func() {
+		defer func() {
+			if v := recover(); v != nil {
+				panicked <- newWritePanic(v)
+			}
+		}()
+		done <- write()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case p := <-panicked:
+		panic(p)
+	case <-ctx.Done():
+		return &AbandonedWrite{err: ctx.Err(), done: done, panicked: panicked}
+	}
+}
+
+// AbandonedWrite is the error of a write given up on when its context
+// finished, on a sink that cannot interrupt it: the write goes on in the
+// background and may still store the record. It wraps the context's error.
+type AbandonedWrite struct {
+	err      error
+	done     <-chan error
+	panicked <-chan *WritePanic
+}
+
+func (a *AbandonedWrite) Error() string { return a.err.Error() }
+
+func (a *AbandonedWrite) Unwrap() error { return a.err }
+
+// Wait waits until the abandoned write returns, so the record is not tried
+// again while it may yet be written, or until ctx is done. It reports
+// whether the write returned, and its error. A panic in the write is
+// raised again as a *WritePanic. Once the write has returned, Wait may not
+// be called again.
+func (a *AbandonedWrite) Wait(ctx context.Context) (returned bool, err error) {
+	select {
+	case err := <-a.done:
+		return true, err
+	case p := <-a.panicked:
+		panic(p)
+	case <-ctx.Done():
+		return false, nil
+	}
+}
+
+// WritePanic is a panic raised by a sink write that ran on another
+// goroutine, with that goroutine's stack.
+type WritePanic struct {
+	Value any
+	Stack []byte
+}
+
+func newWritePanic(v any) *WritePanic {
+	if p, ok := v.(*WritePanic); ok {
+		return p // already carried over from a nested write
+	}
+	return &WritePanic{Value: v, Stack: debug.Stack()}
+}
+
+func (p *WritePanic) String() string { return fmt.Sprint(p.Value) }
+
+// Pending settles a record a buffering sink took but has not written yet.
+// The writer attaches one to the write's context with WithPending; a sink
+// that buffers the record marks it deferred and, once the buffer is
+// flushed, calls its done func with the flush's outcome. A sink that
+// writes the record before returning leaves it alone, and the write's own
+// error is the outcome.
+type Pending struct {
+	done     func(err error)
+	deferred atomic.Bool
+}
+
+type pendingKey struct{}
+
+// WithPending returns ctx carrying a Pending that calls done.
+func WithPending(ctx context.Context, done func(err error)) (context.Context, *Pending) {
+	p := &Pending{done: done}
+	return context.WithValue(ctx, pendingKey{}, p), p
+}
+
+// pendingFrom returns the Pending attached to ctx, or nil.
+func pendingFrom(ctx context.Context) *Pending {
+	p, _ := ctx.Value(pendingKey{}).(*Pending)
+	return p
+}
+
+// Deferred reports whether a sink took the record for a later flush, so
+// the outcome comes through done rather than the write's error.
+func (p *Pending) Deferred() bool {
+	return p.deferred.Load()
+}
+
+// Flusher is implemented by sinks that buffer records, to write them out
+// before the sink is closed.
+type Flusher interface {
+	Flush(ctx context.Context) error
+}
+
+// Flush writes out what the sinks in w's chain buffer, settling their
+// Pending records. It is a no-op when none buffers.
+func Flush(ctx context.Context, w Writer) error {
+	for w != nil {
+		if f, ok := w.(Flusher); ok {
+			return f.Flush(ctx)
+		}
+		u, ok := w.(Unwrapper)
+		if !ok {
+			break
+		}
+		w = u.Unwrap()
+	}
+	return nil
+}
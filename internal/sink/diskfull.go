@@ -0,0 +1,37 @@
+package sink
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"syscall"
+)
+
+// IsDiskFull reports whether err comes from a full disk or an exhausted
+// disk quota, either as the system error or as a sink error wrapping
+// ErrDiskFull.
+func IsDiskFull(err error) bool {
+	var errno syscall.Errno
+	return errors.Is(err, ErrDiskFull) || errors.As(err, &errno) && slices.Contains(diskFullErrnos, errno)
+}
+
+// fileError wraps an error from writing a local file with kind, adding
+// ErrDiskFull when the disk or quota is full.
+func fileError(kind, err error) error {
+	if IsDiskFull(err) {
+		return fmt.Errorf("%w: %w: %v", kind, ErrDiskFull, err)
+	}
+	if errors.Is(err, ErrResumeMismatch) {
+		return err // already a sink error
+	}
+	return fmt.Errorf("%w: %v", kind, err)
+}
+
+// closeError is fileError for Close, which returns other errors as they
+// are.
+func closeError(err error) error {
+	if err != nil && IsDiskFull(err) {
+		return fileError(ErrWriteSink, err)
+	}
+	return err
+}
@@ -0,0 +1,7 @@
+//go:build !windows
+
+package sink
+
+import "syscall"
+
+var diskFullErrnos = []syscall.Errno{syscall.ENOSPC, syscall.EDQUOT}
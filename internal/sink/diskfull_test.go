@@ -0,0 +1,47 @@
+package sink
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+// fullDisk accepts limit bytes and then fails like a write to a full disk.
+type fullDisk struct {
+	strings.Builder
+	limit int
+}
+
+func (d *fullDisk) Write(p []byte) (int, error) {
+	if d.Len()+len(p) > d.limit {
+		return 0, &os.PathError{Op: "write", Path: "out.jsonl", Err: diskFullErrnos[0]}
+	}
+	return d.Builder.Write(p)
+}
+
+func (d *fullDisk) Close() error { return nil }
+
+func TestJSONLSink_DiskFull(t *testing.T) {
+	s := NewJSONLSink(&fullDisk{limit: 10})
+	if err := s.Write("first"); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	err := s.Write("second")
+	if !errors.Is(err, ErrWriteSink) || !errors.Is(err, ErrDiskFull) || !IsDiskFull(err) {
+		t.Fatalf("err = %v, want ErrWriteSink and ErrDiskFull", err)
+	}
+	if !strings.Contains(err.Error(), "out.jsonl") {
+		t.Errorf("err = %v, want the path", err)
+	}
+}
+
+func TestFileError_OtherErrorsAreNotDiskFull(t *testing.T) {
+	err := fileError(ErrWriteSink, &os.PathError{Op: "write", Path: "out.jsonl", Err: os.ErrPermission})
+	if !errors.Is(err, ErrWriteSink) || errors.Is(err, ErrDiskFull) || IsDiskFull(err) {
+		t.Errorf("err = %v, want ErrWriteSink only", err)
+	}
+	if closeError(nil) != nil {
+		t.Error("closeError(nil) != nil")
+	}
+}
@@ -0,0 +1,11 @@
+//go:build windows
+
+package sink
+
+import "syscall"
+
+var diskFullErrnos = []syscall.Errno{
+	39,   // ERROR_HANDLE_DISK_FULL
+	112,  // ERROR_DISK_FULL
+	1295, // ERROR_DISK_QUOTA_EXCEEDED
+}
@@ -7,6 +7,31 @@ var (
 	ErrOpenSink = errors.New("open sink")
 	// ErrWriteSink indicates a failure while writing a record.
 	ErrWriteSink = errors.New("write sink")
+	// ErrFormat indicates a record could not be rendered in the output
+	// format. Retrying the write cannot succeed.
+	ErrFormat = errors.New("format record")
 	// ErrRotateSink indicates a failure while rotating an output file.
 	ErrRotateSink = errors.New("rotate sink")
+	// ErrCommitSink indicates a failure to publish or discard atomic output.
+	ErrCommitSink = errors.New("commit sink")
+	// ErrWriteTimeout indicates a write did not complete within the configured timeout.
+	ErrWriteTimeout = errors.New("write timeout")
+	// ErrRejected indicates the destination refused the records, e.g. for
+	// bad data or credentials. Retrying the write cannot succeed.
+	ErrRejected = errors.New("rejected by destination")
+	// ErrDiskFull indicates a local output file could not be written
+	// because its disk or quota is full. Retrying cannot succeed until
+	// space is freed.
+	ErrDiskFull = errors.New("disk full")
+	// ErrInjected indicates a write failed on purpose in the faulty sink.
+	ErrInjected = errors.New("injected failure")
+	// ErrUnroutable indicates no router rule matched a record and the
+	// router has no default route. It is returned together with
+	// ErrRejected, since retrying cannot succeed.
+	ErrUnroutable = errors.New("no route for record")
+	// ErrResumeMismatch indicates a resumed file sink was given output
+	// that differs from what the file already holds, so the run is not
+	// repeating the one it resumes. It is returned together with
+	// ErrRejected, since retrying cannot succeed.
+	ErrResumeMismatch = errors.New("output differs from the run being resumed")
 )
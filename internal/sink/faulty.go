@@ -0,0 +1,140 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// FaultyOptions configures NewFaultySink. Zero values disable each fault.
+type FaultyOptions struct {
+	// FailRate is the probability, 0 to 1, that a write fails.
+	FailRate float64
+	// FailEvery fails every FailEvery-th write attempt.
+	FailEvery int
+	// FailAfter fails every write once FailAfter records have been written,
+	// as if the destination went away.
+	FailAfter int
+	// Latency delays every write attempt, failed or not.
+	Latency time.Duration
+	// Seed seeds the FailRate draws, so a run can be repeated exactly.
+	Seed int64
+}
+
+// FaultyStats counts what a FaultySink did. A write that matches several
+// faults is counted under the first of AfterLimit, EveryNth, Random.
+type FaultyStats struct {
+	Attempts   int // write attempts, including retries
+	Injected   int // attempts failed on purpose
+	Random     int
+	EveryNth   int
+	AfterLimit int
+	Delayed    int
+}
+
+// FaultySink fails writes to a real sink on purpose, for testing retries,
+// the DLQ, and alerting. Failed writes return an error wrapping
+// ErrInjected and never reach the wrapped sink.
+type FaultySink struct {
+	wrapped Writer
+	opts    FaultyOptions
+	mu      sync.Mutex
+	rng     *rand.Rand
+	written int
+	stats   FaultyStats
+}
+
+// NewFaultySink wraps w with the faults in opts.
+func NewFaultySink(w Writer, opts FaultyOptions) *FaultySink {
+	return &FaultySink{wrapped: w, opts: opts, rng: rand.New(rand.NewSource(opts.Seed))}
+}
+
+func (fs *FaultySink) Write(record any) error {
+	return fs.WriteContext(context.Background(), record)
+}
+
+// WriteContext implements ContextWriter. The injected latency ends early,
+// with ctx.Err(), when ctx is done.
+func (fs *FaultySink) WriteContext(ctx context.Context, record any) error {
+	if fs.opts.Latency > 0 {
+		t := time.NewTimer(fs.opts.Latency)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		}
+	}
+	if err := fs.inject(); err != nil {
+		return err
+	}
+	if err := WriteContext(ctx, fs.wrapped, record); err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	fs.written++
+	fs.mu.Unlock()
+	return nil
+}
+
+// inject counts an attempt and returns the error it fails with, if any.
+func (fs *FaultySink) inject() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.stats.Attempts++
+	if fs.opts.Latency > 0 {
+		fs.stats.Delayed++
+	}
+	// Draw on every attempt so the sequence depends only on the seed.
+	random := fs.opts.FailRate > 0 && fs.rng.Float64() < fs.opts.FailRate
+	var reason string
+	switch {
+	case fs.opts.FailAfter > 0 && fs.written >= fs.opts.FailAfter:
+		fs.stats.AfterLimit++
+		reason = fmt.Sprintf("after %d records", fs.opts.FailAfter)
+	case fs.opts.FailEvery > 0 && fs.stats.Attempts%fs.opts.FailEvery == 0:
+		fs.stats.EveryNth++
+		reason = fmt.Sprintf("attempt %d", fs.stats.Attempts)
+	case random:
+		fs.stats.Random++
+		reason = "random"
+	default:
+		return nil
+	}
+	fs.stats.Injected++
+	return fmt.Errorf("%w: %s", ErrInjected, reason)
+}
+
+// Stats returns the counts so far.
+func (fs *FaultySink) Stats() FaultyStats {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.stats
+}
+
+// Unwrap implements Unwrapper.
+func (fs *FaultySink) Unwrap() Writer {
+	return fs.wrapped
+}
+
+func (fs *FaultySink) Close() error {
+	return fs.wrapped.Close()
+}
+
+// Faults returns the stats of the FaultySink in w's chain. The second
+// result is false when there is none.
+func Faults(w Writer) (FaultyStats, bool) {
+	for w != nil {
+		if fs, ok := w.(*FaultySink); ok {
+			return fs.Stats(), true
+		}
+		u, ok := w.(Unwrapper)
+		if !ok {
+			break
+		}
+		w = u.Unwrap()
+	}
+	return FaultyStats{}, false
+}
@@ -0,0 +1,84 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// outcomes writes n records to fs and returns which attempts failed.
+func outcomes(t *testing.T, fs *FaultySink, n int) []bool {
+	t.Helper()
+	failed := make([]bool, n)
+	for i := range failed {
+		err := fs.Write(i)
+		if err != nil && !errors.Is(err, ErrInjected) {
+			t.Fatalf("write %d: unexpected error %v", i, err)
+		}
+		failed[i] = err != nil
+	}
+	return failed
+}
+
+func TestFaultySink_EveryNthAndAfter(t *testing.T) {
+	tw := &testWriter{}
+	fs := NewFaultySink(tw, FaultyOptions{FailEvery: 3, FailAfter: 4})
+	got := outcomes(t, fs, 8)
+	// Attempts 3 and 6 fail; after the 4th record (attempt 5) everything does.
+	want := []bool{false, false, true, false, false, true, true, true}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("failures = %v, want %v", got, want)
+		}
+	}
+	if tw.count() != 4 {
+		t.Errorf("wrapped sink got %d records, want 4", tw.count())
+	}
+	want8 := FaultyStats{Attempts: 8, Injected: 4, EveryNth: 1, AfterLimit: 3}
+	if s := fs.Stats(); s != want8 {
+		t.Errorf("stats = %+v, want %+v", s, want8)
+	}
+}
+
+func TestFaultySink_SeededRate(t *testing.T) {
+	opts := FaultyOptions{FailRate: 0.3, Seed: 42}
+	a := outcomes(t, NewFaultySink(&testWriter{}, opts), 200)
+	b := outcomes(t, NewFaultySink(&testWriter{}, opts), 200)
+	failed := 0
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("attempt %d differs between runs with the same seed", i)
+		}
+		if a[i] {
+			failed++
+		}
+	}
+	if failed < 30 || failed > 90 {
+		t.Errorf("%d of 200 writes failed at rate 0.3", failed)
+	}
+}
+
+func TestFaultySink_LatencyHonorsContext(t *testing.T) {
+	fs := NewFaultySink(&testWriter{}, FaultyOptions{Latency: time.Hour})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := fs.WriteContext(ctx, "x"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want deadline exceeded", err)
+	}
+}
+
+func TestFaults_ThroughWrappers(t *testing.T) {
+	fs := NewFaultySink(&testWriter{}, FaultyOptions{FailEvery: 1})
+	bs, err := NewBatchedSink(fs, 2, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewProjectSink(bs, []string{"level"})
+	if _, ok := Faults(w); !ok {
+		t.Fatal("Faults did not find the faulty sink")
+	}
+	if _, ok := Faults(bs.wrapped.(*FaultySink).wrapped); ok {
+		t.Error("Faults found a faulty sink where there is none")
+	}
+}
@@ -0,0 +1,106 @@
+package sink
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"k8s-log-etl/internal/logger"
+)
+
+// fifoPollInterval is how often a fifoWriter without a reader tries the
+// pipe again.
+var fifoPollInterval = 100 * time.Millisecond
+
+// IsFIFO reports whether path is a named pipe.
+func IsFIFO(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode()&os.ModeNamedPipe != 0
+}
+
+// fifoWriter writes to a named pipe. It opens the pipe write-only, never
+// creating or truncating it, and once a reader has it open writes block
+// like any pipe's. While the pipe has no reader, at the start or after
+// its reader closes it, a Write waits for the next one and then writes
+// the whole of p to it, rather than failing with EPIPE.
+type fifoWriter struct {
+	path   string
+	closed chan struct{}
+	once   sync.Once
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+// openFIFO opens the named pipe at path for writing, waiting for a reader.
+func openFIFO(path string) (*fifoWriter, error) {
+	w := &fifoWriter{path: path, closed: make(chan struct{})}
+	if err := w.open(); err != nil {
+		return nil, fileError(ErrOpenSink, err)
+	}
+	return w, nil
+}
+
+// open opens the pipe, polling while it has no reader. Callers hold w.mu
+// or own w.
+func (w *fifoWriter) open() error {
+	warned := false
+	for {
+		f, err := openFIFOWriteOnly(w.path)
+		if err == nil {
+			if warned {
+				logger.Info("output pipe has a reader again", "path", w.path)
+			}
+			w.f = f
+			return nil
+		}
+		if !errors.Is(err, syscall.ENXIO) {
+			return err
+		}
+		if !warned {
+			logger.Warn("output pipe has no reader, waiting for one", "path", w.path)
+			warned = true
+		}
+		select {
+		case <-w.closed:
+			return fmt.Errorf("%s: %w", w.path, os.ErrClosed)
+		case <-time.After(fifoPollInterval):
+		}
+	}
+}
+
+func (w *fifoWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for {
+		if w.f == nil {
+			if err := w.open(); err != nil {
+				return 0, err
+			}
+		}
+		n, err := w.f.Write(p)
+		if !errors.Is(err, syscall.EPIPE) {
+			return n, err
+		}
+		// The reader went away, maybe mid-record; its successor gets the
+		// record whole.
+		w.f.Close()
+		w.f = nil
+	}
+}
+
+// Close closes the pipe, ending a wait for a reader.
+func (w *fifoWriter) Close() error {
+	w.once.Do(func() { close(w.closed) })
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	return err
+}
@@ -0,0 +1,126 @@
+//go:build linux
+
+package sink
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"k8s-log-etl/internal/config"
+)
+
+func mkfifo(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "out.pipe")
+	if err := syscall.Mkfifo(path, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// readLines opens the pipe at path, reads n lines, and closes it.
+func readLines(t *testing.T, path string, n int) <-chan []string {
+	t.Helper()
+	out := make(chan []string, 1)
+	go func() {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Error(err)
+			out <- nil
+			return
+		}
+		defer f.Close()
+		sc := bufio.NewScanner(f)
+		sc.Buffer(nil, 1<<20)
+		var lines []string
+		for len(lines) < n && sc.Scan() {
+			lines = append(lines, sc.Text())
+		}
+		out <- lines
+	}()
+	return out
+}
+
+func TestFIFOWriterOutlastsItsReaders(t *testing.T) {
+	prev := fifoPollInterval
+	fifoPollInterval = time.Millisecond
+	t.Cleanup(func() { fifoPollInterval = prev })
+	path := mkfifo(t)
+
+	first := readLines(t, path, 2)
+	s, err := Build(t.Context(), config.Config{OutputType: "file", OutputPath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for _, msg := range []string{"a", "b"} {
+		if err := s.Write(map[string]string{"msg": msg}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := <-first; len(got) != 2 {
+		t.Fatalf("first reader got %q", got)
+	}
+
+	// The reader is gone: the next write fails with EPIPE underneath and
+	// waits for the second reader, which gets every record whole, even
+	// ones larger than the pipe's buffer.
+	big := strings.Repeat("x", 200<<10)
+	second := readLines(t, path, 2)
+	for _, msg := range []string{"c", big} {
+		if err := s.Write(map[string]string{"msg": msg}); err != nil {
+			t.Fatalf("write after the reader left: %v", err)
+		}
+	}
+	got := <-second
+	if len(got) != 2 || got[0] != `{"msg":"c"}` || got[1] != `{"msg":"`+big+`"}` {
+		t.Fatalf("second reader got %d lines, first %.40q", len(got), got)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode()&os.ModeNamedPipe == 0 {
+		t.Errorf("output is no longer a named pipe: %v, %v", info, err)
+	}
+}
+
+func TestFIFOWriterCloseEndsWait(t *testing.T) {
+	prev := fifoPollInterval
+	fifoPollInterval = time.Millisecond
+	t.Cleanup(func() { fifoPollInterval = prev })
+	w := &fifoWriter{path: mkfifo(t), closed: make(chan struct{})}
+	done := make(chan error, 1)
+	go func() {
+		_, err := w.Write([]byte("x\n"))
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	w.Close()
+	select {
+	case err := <-done:
+		if !errors.Is(err, os.ErrClosed) {
+			t.Errorf("Write = %v, want os.ErrClosed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not end the wait for a reader")
+	}
+}
+
+func TestFIFOOutputRejectsFileModes(t *testing.T) {
+	path := mkfifo(t)
+	for _, cfg := range []config.Config{
+		{OutputType: "file", OutputPath: path, OutputAtomic: true},
+		{OutputType: "file", OutputPath: path, OutputResume: true},
+		{OutputType: "rotate", OutputPath: path},
+	} {
+		if err := Check(t.Context(), cfg, true); !errors.Is(err, ErrOpenSink) {
+			t.Errorf("Check(%+v) = %v, want ErrOpenSink", cfg, err)
+		}
+	}
+	if err := Check(t.Context(), config.Config{OutputType: "file", OutputPath: path}, true); err != nil {
+		t.Errorf("Check of a pipe = %v; it must not wait for a reader", err)
+	}
+}
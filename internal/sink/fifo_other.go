@@ -0,0 +1,14 @@
+//go:build !windows
+
+package sink
+
+import (
+	"os"
+	"syscall"
+)
+
+// openFIFOWriteOnly opens the named pipe at path for writing without
+// waiting for a reader; with none it fails with ENXIO.
+func openFIFOWriteOnly(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+}
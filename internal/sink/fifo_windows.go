@@ -0,0 +1,14 @@
+//go:build windows
+
+package sink
+
+import (
+	"errors"
+	"os"
+)
+
+// openFIFOWriteOnly fails: Windows has no named pipes in the file system,
+// so IsFIFO is never true.
+func openFIFOWriteOnly(path string) (*os.File, error) {
+	return nil, errors.New("named pipes are not supported on windows")
+}
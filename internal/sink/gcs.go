@@ -0,0 +1,257 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	gcsEndpoint    = "https://storage.googleapis.com"
+	gcsScope       = "https://www.googleapis.com/auth/devstorage.read_write"
+	googleTokenURL = "https://oauth2.googleapis.com/token"
+)
+
+// GCSBackend uploads objects to a Google Cloud Storage bucket with the JSON
+// API. Credentials are found the way Google's client libraries find them:
+// the file named by GOOGLE_APPLICATION_CREDENTIALS (a service account key
+// or authorized user), gcloud's application default credentials, then the
+// metadata server. With STORAGE_EMULATOR_HOST set, requests go to the
+// emulator without credentials.
+type GCSBackend struct {
+	bucket   string
+	endpoint string
+	client   *http.Client
+	token    *googleToken // nil for the emulator
+}
+
+// NewGCSBackend returns a backend for bucket.
+func NewGCSBackend(bucket string) (*GCSBackend, error) {
+	b := &GCSBackend{
+		bucket:   bucket,
+		endpoint: gcsEndpoint,
+		client:   &http.Client{Timeout: 5 * time.Minute},
+	}
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		if !strings.Contains(host, "://") {
+			host = "http://" + host
+		}
+		b.endpoint = strings.TrimRight(host, "/")
+		return b, nil
+	}
+	creds, err := findGoogleCredentials()
+	if err != nil {
+		return nil, fmt.Errorf("%w: gcs: %v", ErrOpenSink, err)
+	}
+	b.token = &googleToken{creds: creds, client: &http.Client{Timeout: 30 * time.Second}}
+	return b, nil
+}
+
+// Put implements ObjectBackend with a single-request media upload.
+func (b *GCSBackend) Put(ctx context.Context, key string, r io.Reader) error {
+	q := url.Values{"uploadType": {"media"}, "name": {key}}
+	endpoint := b.endpoint + "/upload/storage/v1/b/" + url.PathEscape(b.bucket) + "/o?" + q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if b.token != nil {
+		tok, err := b.token.get(ctx)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+tok)
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("gcs: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// googleCredentials is a credentials file written by gcloud or downloaded
+// for a service account. A nil value means the metadata server.
+type googleCredentials struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func findGoogleCredentials() (*googleCredentials, error) {
+	path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if path == "" {
+		dir := os.Getenv("CLOUDSDK_CONFIG")
+		if dir == "" {
+			if runtime.GOOS == "windows" {
+				dir = filepath.Join(os.Getenv("APPDATA"), "gcloud")
+			} else if home, err := os.UserHomeDir(); err == nil {
+				dir = filepath.Join(home, ".config", "gcloud")
+			}
+		}
+		path = filepath.Join(dir, "application_default_credentials.json")
+		if _, err := os.Stat(path); err != nil {
+			return nil, nil
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c googleCredentials
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("credentials %s: %v", path, err)
+	}
+	switch c.Type {
+	case "service_account":
+		if c.TokenURI == "" {
+			c.TokenURI = googleTokenURL
+		}
+	case "authorized_user":
+	default:
+		return nil, fmt.Errorf("credentials %s: unsupported type %q", path, c.Type)
+	}
+	return &c, nil
+}
+
+// googleToken fetches access tokens and caches them until shortly before
+// they expire.
+type googleToken struct {
+	creds  *googleCredentials
+	client *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func (t *googleToken) get(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != "" && time.Until(t.expires) > time.Minute {
+		return t.token, nil
+	}
+	req, err := t.request(ctx)
+	if err != nil {
+		return "", fmt.Errorf("gcs: access token: %v", err)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("gcs: access token: %v", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("gcs: access token: %s: %s", resp.Status, bytes.TrimSpace(data))
+	}
+	var out struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(data, &out); err != nil || out.AccessToken == "" {
+		return "", errors.New("gcs: access token: unexpected response")
+	}
+	t.token = out.AccessToken
+	t.expires = time.Now().Add(time.Duration(out.ExpiresIn) * time.Second)
+	return t.token, nil
+}
+
+func (t *googleToken) request(ctx context.Context) (*http.Request, error) {
+	c := t.creds
+	if c == nil {
+		host := os.Getenv("GCE_METADATA_HOST")
+		if host == "" {
+			host = "metadata.google.internal"
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+host+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Metadata-Flavor", "Google")
+		return req, nil
+	}
+	form := url.Values{}
+	endpoint := googleTokenURL
+	if c.Type == "service_account" {
+		assertion, err := serviceAccountJWT(c, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		endpoint = c.TokenURI
+		form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+		form.Set("assertion", assertion)
+	} else {
+		form.Set("grant_type", "refresh_token")
+		form.Set("client_id", c.ClientID)
+		form.Set("client_secret", c.ClientSecret)
+		form.Set("refresh_token", c.RefreshToken)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, nil
+}
+
+// serviceAccountJWT signs the RS256 assertion exchanged for an access token.
+func serviceAccountJWT(c *googleCredentials, now time.Time) (string, error) {
+	block, _ := pem.Decode([]byte(c.PrivateKey))
+	if block == nil {
+		return "", errors.New("service account private_key is not PEM")
+	}
+	var key *rsa.PrivateKey
+	if k, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		var ok bool
+		if key, ok = k.(*rsa.PrivateKey); !ok {
+			return "", errors.New("service account private_key is not RSA")
+		}
+	} else if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+		return "", fmt.Errorf("service account private_key: %v", err)
+	}
+	claims, err := json.Marshal(map[string]any{
+		"iss":   c.ClientEmail,
+		"scope": gcsScope,
+		"aud":   c.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	signed := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`)) + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + enc.EncodeToString(sig), nil
+}
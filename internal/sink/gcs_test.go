@@ -0,0 +1,96 @@
+package sink
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGCSBackend_PutToEmulator(t *testing.T) {
+	var gotPath, gotName, gotBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotName = r.URL.Path, r.URL.Query().Get("name")
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+		io.WriteString(w, `{}`)
+	}))
+	defer srv.Close()
+	t.Setenv("STORAGE_EMULATOR_HOST", strings.TrimPrefix(srv.URL, "http://"))
+
+	b, err := NewGCSBackend("logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Put(context.Background(), "k8s/2024/01/01/00/a.jsonl", strings.NewReader("{}\n")); err != nil {
+		t.Fatal(err)
+	}
+	if gotPath != "/upload/storage/v1/b/logs/o" || gotName != "k8s/2024/01/01/00/a.jsonl" || gotBody != "{}\n" {
+		t.Errorf("upload path %q, name %q, body %q", gotPath, gotName, gotBody)
+	}
+}
+
+func TestGCSBackend_ServiceAccountToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+
+	var assertion string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		assertion = r.PostForm.Get("assertion")
+		io.WriteString(w, `{"access_token":"tok","expires_in":3600}`)
+	}))
+	defer srv.Close()
+
+	creds, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "etl@project.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    srv.URL,
+	})
+	path := filepath.Join(t.TempDir(), "key.json")
+	os.WriteFile(path, creds, 0o600)
+	t.Setenv("STORAGE_EMULATOR_HOST", "")
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", path)
+
+	b, err := NewGCSBackend("logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if tok, err := b.token.get(context.Background()); err != nil || tok != "tok" {
+			t.Fatalf("token = %q, %v", tok, err)
+		}
+	}
+
+	parts := strings.Split(assertion, ".")
+	if len(parts) != 3 {
+		t.Fatalf("assertion %q is not a JWT", assertion)
+	}
+	sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, sum[:], sig); err != nil {
+		t.Errorf("bad signature: %v", err)
+	}
+	var claims map[string]any
+	payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	json.Unmarshal(payload, &claims)
+	if claims["iss"] != "etl@project.iam.gserviceaccount.com" || claims["aud"] != srv.URL || claims["scope"] != gcsScope {
+		t.Errorf("claims = %v", claims)
+	}
+}
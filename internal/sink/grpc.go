@@ -0,0 +1,649 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s-log-etl/internal/clock"
+	"k8s-log-etl/internal/model"
+)
+
+// GRPCStreamMethod is the path of the streaming method of the LogSink
+// service in docs/logsink.proto.
+const GRPCStreamMethod = "/etl.v1.LogSink/Stream"
+
+// DefaultGRPCAckTimeout is how long a write waits for the ack of its
+// record before the stream is given up as stuck and opened again.
+const DefaultGRPCAckTimeout = 30 * time.Second
+
+// grpcCloseTimeout bounds how long Close waits for the server to end the
+// stream after the last record.
+const grpcCloseTimeout = 5 * time.Second
+
+// grpcMaxMessage caps the size of an Ack the sink reads.
+const grpcMaxMessage = 4 << 20
+
+// GRPCOptions configures NewGRPCSink.
+type GRPCOptions struct {
+	Target string // host:port
+	// TLS connects with TLS, verifying the server against the system
+	// roots, or against the PEM certificates in CAFile when it is set.
+	TLS    bool
+	CAFile string
+	// AuthToken, when set, is sent as "authorization: Bearer <AuthToken>".
+	AuthToken   string
+	MaxRetries  int
+	BackoffBase time.Duration
+	// AckTimeout defaults to DefaultGRPCAckTimeout.
+	AckTimeout time.Duration
+}
+
+// GRPCSink streams records to the LogSink service of docs/logsink.proto
+// over one bidirectional gRPC stream, spoken over net/http's HTTP/2
+// client. A write returns once the server has acknowledged its record, so
+// a nil error means the collector accepted it; concurrent writes share
+// the stream and are acknowledged together.
+//
+// When the stream breaks, the next one resends the records not yet
+// acknowledged with their sequence numbers, under the same session, so
+// the server can drop those it already has. A write gives up after the
+// stream failed MaxRetries times while it waited. A record the server
+// rejects fails with ErrRejected.
+type GRPCSink struct {
+	url         string // of GRPCStreamMethod
+	client      *http.Client
+	authToken   string
+	session     string
+	maxRetries  int
+	backoffBase time.Duration
+	ackTimeout  time.Duration
+	clock       clock.Clock
+
+	// opening is held while a stream is opened and the pending records
+	// are sent again on it.
+	opening sync.Mutex
+
+	mu      sync.Mutex
+	stream  *grpcStream // the open stream, nil before the first write
+	seq     uint64      // last sequence number assigned
+	pending map[uint64]*grpcPending
+	closed  bool
+}
+
+// grpcPending is a record sent and not yet acknowledged, or one a write
+// is about to send.
+type grpcPending struct {
+	record []byte // LogRecord fields other than seq
+	done   chan error
+	// seq and sentOn are guarded by GRPCSink.mu; seq is 0 until sent.
+	seq    uint64
+	sentOn *grpcStream
+}
+
+// grpcStream is one call of the Stream method.
+type grpcStream struct {
+	pr     *io.PipeReader
+	pw     *io.PipeWriter
+	cancel context.CancelFunc
+
+	sendMu  sync.Mutex // serializes frames, in sequence order
+	lastSeq uint64     // last sequence number sent, guarded by sendMu
+
+	endOnce sync.Once
+	done    chan struct{}
+	err     error // why the stream ended, set before done is closed
+}
+
+// NewGRPCSink creates a gRPC sink. Nothing is sent until the first write.
+func NewGRPCSink(opts GRPCOptions) (*GRPCSink, error) {
+	if _, _, err := net.SplitHostPort(opts.Target); err != nil {
+		return nil, fmt.Errorf("%w: grpc target must be host:port, got %q", ErrOpenSink, opts.Target)
+	}
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+		Protocols:           new(http.Protocols),
+	}
+	scheme := "http"
+	if opts.TLS || opts.CAFile != "" {
+		scheme = "https"
+		transport.Protocols.SetHTTP2(true)
+		if opts.CAFile != "" {
+			pem, err := os.ReadFile(opts.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("%w: grpc_ca_file: %v", ErrOpenSink, err)
+			}
+			roots := x509.NewCertPool()
+			if !roots.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("%w: grpc_ca_file %s holds no PEM certificates", ErrOpenSink, opts.CAFile)
+			}
+			transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+		}
+	} else {
+		// Plaintext gRPC is HTTP/2 with prior knowledge.
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	}
+	var id [16]byte
+	rand.Read(id[:])
+	gs := &GRPCSink{
+		url:         scheme + "://" + opts.Target + GRPCStreamMethod,
+		client:      &http.Client{Transport: transport},
+		authToken:   opts.AuthToken,
+		session:     hex.EncodeToString(id[:]),
+		maxRetries:  opts.MaxRetries,
+		backoffBase: opts.BackoffBase,
+		ackTimeout:  opts.AckTimeout,
+		clock:       clock.Real,
+		pending:     make(map[uint64]*grpcPending),
+	}
+	if gs.ackTimeout <= 0 {
+		gs.ackTimeout = DefaultGRPCAckTimeout
+	}
+	if _, err := url.Parse(gs.url); err != nil {
+		return nil, fmt.Errorf("%w: grpc target %q: %v", ErrOpenSink, opts.Target, err)
+	}
+	return gs, nil
+}
+
+func (gs *GRPCSink) Write(record any) error {
+	return gs.WriteContext(context.Background(), record)
+}
+
+// WriteContext implements ContextWriter. It sends the record and waits
+// for its ack, opening the stream again and backing off when it breaks.
+func (gs *GRPCSink) WriteContext(ctx context.Context, record any) error {
+	msg, err := appendLogRecord(nil, record)
+	if err != nil {
+		return err
+	}
+	p := &grpcPending{record: msg, done: make(chan error, 1)}
+	defer gs.forget(p)
+
+	for attempt := 0; ; attempt++ {
+		st, err := gs.current()
+		if err == nil {
+			err = gs.send(st, p)
+		}
+		if err == nil {
+			timer := time.NewTimer(gs.ackTimeout)
+			select {
+			case err = <-p.done:
+				timer.Stop()
+				return err
+			case <-st.done:
+				err = st.err
+			case <-timer.C:
+				err = fmt.Errorf("%w: %w: no grpc ack within %s", ErrWriteSink, ErrWriteTimeout, gs.ackTimeout)
+				st.end(err)
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			}
+			timer.Stop()
+		}
+		// The ack may have come in just before the stream ended.
+		select {
+		case err := <-p.done:
+			return err
+		default:
+		}
+		if errors.Is(err, ErrRejected) || attempt >= gs.maxRetries {
+			return err
+		}
+		if err := gs.clock.Sleep(ctx, gs.backoffBase*time.Duration(1<<attempt)); err != nil {
+			return err
+		}
+	}
+}
+
+// forget drops p from the records a new stream sends again, once its
+// write has returned.
+func (gs *GRPCSink) forget(p *grpcPending) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if p.seq != 0 && gs.pending[p.seq] == p {
+		delete(gs.pending, p.seq)
+	}
+}
+
+// current returns the open stream, opening one when there is none or it
+// has ended. A new stream is first given the pending records, in sequence
+// order, before any write can send on it.
+func (gs *GRPCSink) current() (*grpcStream, error) {
+	live := func() (*grpcStream, error) {
+		gs.mu.Lock()
+		defer gs.mu.Unlock()
+		if gs.closed {
+			return nil, fmt.Errorf("%w: grpc sink is closed", ErrWriteSink)
+		}
+		if gs.stream != nil && !gs.stream.ended() {
+			return gs.stream, nil
+		}
+		return nil, nil
+	}
+	if st, err := live(); st != nil || err != nil {
+		return st, err
+	}
+	gs.opening.Lock()
+	defer gs.opening.Unlock()
+	if st, err := live(); st != nil || err != nil {
+		return st, err
+	}
+
+	st := gs.openStream()
+	gs.mu.Lock()
+	resend := make([]*grpcPending, 0, len(gs.pending))
+	for _, p := range gs.pending {
+		resend = append(resend, p)
+	}
+	gs.mu.Unlock()
+	slices.SortFunc(resend, func(a, b *grpcPending) int { return compareSeq(a.seq, b.seq) })
+	for _, p := range resend {
+		if err := gs.send(st, p); err != nil {
+			return nil, err
+		}
+	}
+	gs.mu.Lock()
+	gs.stream = st
+	gs.mu.Unlock()
+	return st, nil
+}
+
+func compareSeq(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// send writes p to st unless it was already sent there, or has been
+// acknowledged or given up on since. A record first sent on an earlier
+// stream keeps its sequence number when it still follows the last one sent
+// on st, so the server can recognize it; otherwise it takes a new one,
+// since numbers must increase along a stream.
+func (gs *GRPCSink) send(st *grpcStream, p *grpcPending) error {
+	st.sendMu.Lock()
+	defer st.sendMu.Unlock()
+
+	gs.mu.Lock()
+	if p.sentOn == st || (p.seq != 0 && gs.pending[p.seq] != p) {
+		gs.mu.Unlock()
+		return nil
+	}
+	if p.seq == 0 || p.seq <= st.lastSeq {
+		delete(gs.pending, p.seq)
+		gs.seq++
+		p.seq = gs.seq
+		gs.pending[p.seq] = p
+	}
+	p.sentOn = st
+	st.lastSeq = p.seq
+	frame := make([]byte, 5, 5+binary.MaxVarintLen64+1+len(p.record))
+	frame = appendProtoVarint(frame, 1, p.seq)
+	gs.mu.Unlock()
+
+	frame = append(frame, p.record...)
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(frame)-5))
+	if _, err := st.pw.Write(frame); err != nil {
+		err = fmt.Errorf("%w: grpc send: %v", ErrWriteSink, err)
+		st.end(err)
+		return err
+	}
+	return nil
+}
+
+// openStream starts a call of the Stream method. Its records are written
+// to the request body as they are sent; its acks are read by receive.
+func (gs *GRPCSink) openStream() *grpcStream {
+	ctx, cancel := context.WithCancel(context.Background())
+	pr, pw := io.Pipe()
+	st := &grpcStream{pr: pr, pw: pw, cancel: cancel, done: make(chan struct{})}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, gs.url, pr)
+	if err != nil {
+		st.end(fmt.Errorf("%w: create grpc request: %v", ErrWriteSink, err))
+		return st
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+	req.Header.Set("Etl-Session", gs.session)
+	if gs.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+gs.authToken)
+	}
+	go gs.receive(st, req)
+	return st
+}
+
+// receive reads the acks of st until it ends.
+func (gs *GRPCSink) receive(st *grpcStream, req *http.Request) {
+	resp, err := gs.client.Do(req)
+	if err != nil {
+		st.end(fmt.Errorf("%w: grpc stream: %v", ErrWriteSink, err))
+		return
+	}
+	defer resp.Body.Close()
+	if err := grpcResponseError(resp); err != nil {
+		st.end(err)
+		return
+	}
+	br := bufio.NewReader(resp.Body)
+	for {
+		msg, err := readGRPCMessage(br)
+		if err == io.EOF {
+			if err = grpcStatusError(resp.Trailer); err == nil {
+				err = fmt.Errorf("%w: grpc stream closed by the server", ErrWriteSink)
+			}
+			st.end(err)
+			return
+		}
+		if err != nil {
+			st.end(fmt.Errorf("%w: grpc stream: %v", ErrWriteSink, err))
+			return
+		}
+		ack, err := parseAck(msg)
+		if err != nil {
+			st.end(fmt.Errorf("%w: grpc stream: bad ack: %v", ErrWriteSink, err))
+			return
+		}
+		gs.acked(st, ack)
+	}
+}
+
+// acked hands the records an ack covers their verdicts. Only records last
+// sent on st are covered; the ack says nothing about the others.
+func (gs *GRPCSink) acked(st *grpcStream, ack grpcAck) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	for _, n := range ack.rejected {
+		if p := gs.pending[n.seq]; p != nil && p.sentOn == st {
+			p.done <- fmt.Errorf("%w: %w: grpc server rejected the record: %s", ErrWriteSink, ErrRejected, n.reason)
+			delete(gs.pending, n.seq)
+		}
+	}
+	for seq, p := range gs.pending {
+		if seq <= ack.lastSeq && p.sentOn == st {
+			p.done <- nil
+			delete(gs.pending, seq)
+		}
+	}
+}
+
+// ended reports whether the stream has ended.
+func (st *grpcStream) ended() bool {
+	select {
+	case <-st.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// end ends the stream for err, the first time it is called, unblocking
+// writes waiting to send and the receive loop.
+func (st *grpcStream) end(err error) {
+	st.endOnce.Do(func() {
+		st.err = err
+		close(st.done)
+		st.cancel()
+		st.pr.CloseWithError(err)
+	})
+}
+
+// Close ends the stream: the server is told no more records follow and
+// given grpcCloseTimeout to end its side.
+func (gs *GRPCSink) Close() error {
+	gs.mu.Lock()
+	gs.closed = true
+	st := gs.stream
+	gs.mu.Unlock()
+	if st != nil {
+		st.sendMu.Lock()
+		st.pw.Close()
+		st.sendMu.Unlock()
+		select {
+		case <-st.done:
+		case <-time.After(grpcCloseTimeout):
+		}
+		st.end(fmt.Errorf("%w: grpc sink is closed", ErrWriteSink))
+	}
+	gs.client.CloseIdleConnections()
+	return nil
+}
+
+// appendLogRecord appends the fields of record as a LogRecord, all but
+// seq. Records other than model.Normalized, or with Fields values that
+// cannot be JSON-encoded, are ErrFormat errors.
+func appendLogRecord(buf []byte, record any) ([]byte, error) {
+	var n model.Normalized
+	switch r := record.(type) {
+	case model.Normalized:
+		n = r
+	case model.Legacy:
+		n = model.Normalized(r)
+	default:
+		return nil, fmt.Errorf("%w: grpc: unsupported record type %T", ErrFormat, record)
+	}
+	for i, s := range []string{n.TS, n.Level, n.Service, n.Namespace, n.Pod, n.Node, n.Message, n.TraceID, n.Error, n.Stacktrace, n.Caller} {
+		buf = appendProtoString(buf, uint64(i+2), s)
+	}
+	// Map entries are sorted by key to keep the bytes stable across runs.
+	keys := make([]string, 0, len(n.Fields))
+	for k := range n.Fields {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		v, err := json.Marshal(n.Fields[k])
+		if err != nil {
+			return nil, fmt.Errorf("%w: grpc: field %q: %v", ErrFormat, k, err)
+		}
+		entry := appendProtoString(nil, 1, k)
+		entry = appendProtoString(entry, 2, string(v))
+		buf = appendProtoBytes(buf, 13, entry)
+	}
+	return buf, nil
+}
+
+// Protobuf wire types.
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+	protoFixed32 = 5
+)
+
+func appendProtoVarint(buf []byte, field, v uint64) []byte {
+	buf = binary.AppendUvarint(buf, field<<3|protoVarint)
+	return binary.AppendUvarint(buf, v)
+}
+
+// appendProtoString appends s unless it is empty, proto3's default.
+func appendProtoString(buf []byte, field uint64, s string) []byte {
+	if s == "" {
+		return buf
+	}
+	buf = binary.AppendUvarint(buf, field<<3|protoBytes)
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func appendProtoBytes(buf []byte, field uint64, b []byte) []byte {
+	buf = binary.AppendUvarint(buf, field<<3|protoBytes)
+	buf = binary.AppendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+// readProtoFields calls fn with each field of the protobuf message msg:
+// v holds a varint's value, b a length-delimited field's bytes. Fixed-size
+// fields are skipped.
+func readProtoFields(msg []byte, fn func(field uint64, v uint64, b []byte) error) error {
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return errors.New("truncated tag")
+		}
+		msg = msg[n:]
+		field, wire := tag>>3, tag&7
+		var v uint64
+		var b []byte
+		switch wire {
+		case protoVarint:
+			if v, n = binary.Uvarint(msg); n <= 0 {
+				return errors.New("truncated varint")
+			}
+			msg = msg[n:]
+		case protoBytes:
+			l, n := binary.Uvarint(msg)
+			if n <= 0 || l > uint64(len(msg)-n) {
+				return errors.New("truncated field")
+			}
+			b, msg = msg[n:n+int(l)], msg[n+int(l):]
+		case protoFixed64, protoFixed32:
+			size := 8
+			if wire == protoFixed32 {
+				size = 4
+			}
+			if len(msg) < size {
+				return errors.New("truncated field")
+			}
+			msg = msg[size:]
+			continue
+		default:
+			return fmt.Errorf("unsupported wire type %d", wire)
+		}
+		if err := fn(field, v, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// grpcAck is a decoded Ack.
+type grpcAck struct {
+	lastSeq  uint64
+	rejected []grpcNack
+}
+
+type grpcNack struct {
+	seq    uint64
+	reason string
+}
+
+func parseAck(msg []byte) (grpcAck, error) {
+	var ack grpcAck
+	err := readProtoFields(msg, func(field, v uint64, b []byte) error {
+		switch field {
+		case 1:
+			ack.lastSeq = v
+		case 2:
+			var n grpcNack
+			if err := readProtoFields(b, func(field, v uint64, b []byte) error {
+				switch field {
+				case 1:
+					n.seq = v
+				case 2:
+					n.reason = string(b)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			ack.rejected = append(ack.rejected, n)
+		}
+		return nil
+	})
+	return ack, err
+}
+
+// readGRPCMessage reads one length-prefixed message. It returns io.EOF at
+// the end of the stream, between messages.
+func readGRPCMessage(r *bufio.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = errors.New("message prefix cut short")
+		}
+		return nil, err
+	}
+	if prefix[0] != 0 {
+		return nil, errors.New("compressed message, though no compression was offered")
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > grpcMaxMessage {
+		return nil, fmt.Errorf("message of %d bytes is over the %d byte limit", size, grpcMaxMessage)
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, fmt.Errorf("message cut short: %v", err)
+	}
+	return msg, nil
+}
+
+// grpcPermanent lists the gRPC status codes no retry can fix.
+var grpcPermanent = map[int]bool{
+	3:  true, // INVALID_ARGUMENT
+	7:  true, // PERMISSION_DENIED
+	12: true, // UNIMPLEMENTED
+	16: true, // UNAUTHENTICATED
+}
+
+// grpcResponseError checks the response headers of a call: an HTTP error
+// status, a response that is not gRPC, or a trailers-only response
+// ending the call at once.
+func grpcResponseError(resp *http.Response) error {
+	if resp.StatusCode != http.StatusOK {
+		switch resp.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+			return fmt.Errorf("%w: %w: grpc call got http status %d", ErrWriteSink, ErrRejected, resp.StatusCode)
+		}
+		return fmt.Errorf("%w: grpc call got http status %d", ErrWriteSink, resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/grpc") {
+		return fmt.Errorf("%w: grpc call got content type %q", ErrWriteSink, ct)
+	}
+	if resp.Header.Get("Grpc-Status") != "" {
+		if err := grpcStatusError(resp.Header); err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: grpc stream closed by the server", ErrWriteSink)
+	}
+	return nil
+}
+
+// grpcStatusError returns the error of a non-OK grpc-status in h, or nil.
+// Statuses in grpcPermanent wrap ErrRejected.
+func grpcStatusError(h http.Header) error {
+	code := h.Get("Grpc-Status")
+	if code == "" || code == "0" {
+		return nil
+	}
+	msg, err := url.PathUnescape(h.Get("Grpc-Message"))
+	if err != nil {
+		msg = h.Get("Grpc-Message")
+	}
+	if n, _ := strconv.Atoi(code); grpcPermanent[n] {
+		return fmt.Errorf("%w: %w: grpc status %s: %s", ErrWriteSink, ErrRejected, code, msg)
+	}
+	return fmt.Errorf("%w: grpc status %s: %s", ErrWriteSink, code, msg)
+}
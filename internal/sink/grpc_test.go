@@ -0,0 +1,333 @@
+package sink
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"k8s-log-etl/internal/model"
+)
+
+// logSinkFile describes docs/logsink.proto for dynamicpb, standing in for
+// generated code, so the test server decodes records with the protobuf
+// library rather than the sink's own encoder.
+var logSinkFile = func() protoreflect.FileDescriptor {
+	field := func(name string, n int32, typ descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			Number:   proto.Int32(n),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     typ.Enum(),
+			JsonName: proto.String(name),
+		}
+	}
+	repeated := func(name string, n int32, typeName string) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			Number:   proto.Int32(n),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum(),
+			Type:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+			TypeName: proto.String(typeName),
+			JsonName: proto.String(name),
+		}
+	}
+	const str, u64 = descriptorpb.FieldDescriptorProto_TYPE_STRING, descriptorpb.FieldDescriptorProto_TYPE_UINT64
+	record := &descriptorpb.DescriptorProto{
+		Name:  proto.String("LogRecord"),
+		Field: []*descriptorpb.FieldDescriptorProto{field("seq", 1, u64)},
+		NestedType: []*descriptorpb.DescriptorProto{{
+			Name:    proto.String("FieldsEntry"),
+			Field:   []*descriptorpb.FieldDescriptorProto{field("key", 1, str), field("value", 2, str)},
+			Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+		}},
+	}
+	for i, name := range []string{"ts", "level", "service", "namespace", "pod", "node", "message", "trace_id", "error", "stacktrace", "caller"} {
+		record.Field = append(record.Field, field(name, int32(i+2), str))
+	}
+	record.Field = append(record.Field, repeated("fields", 13, ".etl.v1.LogRecord.FieldsEntry"))
+	fd, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:    proto.String("logsink.proto"),
+		Package: proto.String("etl.v1"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			record,
+			{Name: proto.String("Ack"), Field: []*descriptorpb.FieldDescriptorProto{field("last_seq", 1, u64), repeated("rejected", 2, ".etl.v1.Nack")}},
+			{Name: proto.String("Nack"), Field: []*descriptorpb.FieldDescriptorProto{field("seq", 1, u64), field("reason", 2, str)}},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("LogSink"),
+			Method: []*descriptorpb.MethodDescriptorProto{{
+				Name:            proto.String("Stream"),
+				InputType:       proto.String(".etl.v1.LogRecord"),
+				OutputType:      proto.String(".etl.v1.Ack"),
+				ClientStreaming: proto.Bool(true),
+				ServerStreaming: proto.Bool(true),
+			}},
+		}},
+	}, nil)
+	if err != nil {
+		panic(err)
+	}
+	return fd
+}()
+
+// logSinkServer is an in-process LogSink server. It stores each sequence
+// number of a session once, acks every record as it arrives, and rejects
+// those reject names.
+type logSinkServer struct {
+	token string
+	// breakAfter ends the first stream with UNAVAILABLE when its record
+	// number breakAfter has been stored, before acking it.
+	breakAfter int
+	reject     func(model.Normalized) string
+
+	mu       sync.Mutex
+	streams  int
+	sessions map[string]bool
+	seen     map[uint64]bool
+	stored   []model.Normalized
+}
+
+// logSinkService registers logSinkServer as the LogSink service.
+var logSinkService = grpc.ServiceDesc{
+	ServiceName: string(logSinkFile.Services().ByName("LogSink").FullName()),
+	HandlerType: (*any)(nil),
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "Stream",
+		Handler:       func(srv any, ss grpc.ServerStream) error { return srv.(*logSinkServer).stream(ss) },
+		ServerStreams: true,
+		ClientStreams: true,
+	}},
+	Metadata: "logsink.proto",
+}
+
+func (s *logSinkServer) stream(ss grpc.ServerStream) error {
+	md, _ := metadata.FromIncomingContext(ss.Context())
+	s.mu.Lock()
+	s.streams++
+	stream := s.streams
+	s.sessions[strings.Join(md.Get("etl-session"), ",")] = true
+	s.mu.Unlock()
+	if s.token != "" && !slices.Equal(md.Get("authorization"), []string{"Bearer " + s.token}) {
+		return status.Error(codes.Unauthenticated, "bad token")
+	}
+
+	msgs := logSinkFile.Messages()
+	ackDesc, nackDesc := msgs.ByName("Ack"), msgs.ByName("Nack")
+	received := 0
+	for {
+		in := dynamicpb.NewMessage(msgs.ByName("LogRecord"))
+		if err := ss.RecvMsg(in); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		seq, rec := logRecordOf(in)
+		received++
+		ack := dynamicpb.NewMessage(ackDesc)
+		s.mu.Lock()
+		if reason := s.reject(rec); reason != "" {
+			nack := dynamicpb.NewMessage(nackDesc)
+			nack.Set(nackDesc.Fields().ByName("seq"), protoreflect.ValueOfUint64(seq))
+			nack.Set(nackDesc.Fields().ByName("reason"), protoreflect.ValueOfString(reason))
+			ack.Mutable(ackDesc.Fields().ByName("rejected")).List().Append(protoreflect.ValueOfMessage(nack))
+		} else if !s.seen[seq] {
+			s.seen[seq] = true
+			s.stored = append(s.stored, rec)
+		}
+		s.mu.Unlock()
+		if stream == 1 && received == s.breakAfter {
+			return status.Error(codes.Unavailable, "stream broken")
+		}
+		ack.Set(ackDesc.Fields().ByName("last_seq"), protoreflect.ValueOfUint64(seq))
+		if err := ss.SendMsg(ack); err != nil {
+			return err
+		}
+	}
+}
+
+// logRecordOf reads a LogRecord the way a collector would.
+func logRecordOf(m *dynamicpb.Message) (uint64, model.Normalized) {
+	fields := m.Descriptor().Fields()
+	get := func(name protoreflect.Name) string { return m.Get(fields.ByName(name)).String() }
+	n := model.Normalized{
+		TS: get("ts"), Level: get("level"), Service: get("service"), Namespace: get("namespace"), Pod: get("pod"), Node: get("node"),
+		Message: get("message"), TraceID: get("trace_id"), Error: get("error"), Stacktrace: get("stacktrace"), Caller: get("caller"),
+	}
+	m.Get(fields.ByName("fields")).Map().Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+		if n.Fields == nil {
+			n.Fields = make(map[string]any)
+		}
+		var x any
+		json.Unmarshal([]byte(v.String()), &x)
+		n.Fields[k.String()] = x
+		return true
+	})
+	return m.Get(fields.ByName("seq")).Uint(), n
+}
+
+// newLogSinkServer serves srv with grpc-go, over TLS with creds when it is
+// not nil, and returns its address.
+func newLogSinkServer(t *testing.T, srv *logSinkServer, creds credentials.TransportCredentials) string {
+	t.Helper()
+	srv.sessions = make(map[string]bool)
+	srv.seen = make(map[uint64]bool)
+	if srv.reject == nil {
+		srv.reject = func(model.Normalized) string { return "" }
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var opts []grpc.ServerOption
+	if creds != nil {
+		opts = append(opts, grpc.Creds(creds))
+	}
+	gsrv := grpc.NewServer(opts...)
+	gsrv.RegisterService(&logSinkService, srv)
+	go gsrv.Serve(lis)
+	t.Cleanup(gsrv.Stop)
+	return lis.Addr().String()
+}
+
+func TestGRPCSinkResendsAfterStreamBreaks(t *testing.T) {
+	srv := &logSinkServer{token: "s3cret", breakAfter: 30, reject: func(n model.Normalized) string {
+		if strings.HasPrefix(n.Message, "bad") {
+			return "message starts with bad"
+		}
+		return ""
+	}}
+	addr := newLogSinkServer(t, srv, nil)
+	gs, err := NewGRPCSink(GRPCOptions{Target: addr, AuthToken: "s3cret", MaxRetries: 3, BackoffBase: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Four writers share the stream, so records are in flight together
+	// when the server cuts it.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var rejected []string
+	for w := range 4 {
+		wg.Go(func() {
+			for i := range 25 {
+				msg := fmt.Sprintf("m%d-%d", w, i)
+				if i == 7 {
+					msg = "bad" + msg
+				}
+				err := gs.Write(model.Normalized{TS: "2024-01-02T03:04:05Z", Level: "ERROR", Service: "api", Message: msg, Fields: map[string]any{"n": i}})
+				switch {
+				case err == nil:
+				case errors.Is(err, ErrRejected) && strings.Contains(err.Error(), "message starts with bad"):
+					mu.Lock()
+					rejected = append(rejected, msg)
+					mu.Unlock()
+				default:
+					t.Errorf("write %s: %v", msg, err)
+				}
+			}
+		})
+	}
+	wg.Wait()
+	if err := gs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.streams < 2 || len(srv.sessions) != 1 {
+		t.Errorf("%d streams in %d sessions, want a second stream in the same session", srv.streams, len(srv.sessions))
+	}
+	if len(rejected) != 4 {
+		t.Errorf("rejected %v, want the four bad records", rejected)
+	}
+	var got []string
+	for _, rec := range srv.stored {
+		got = append(got, rec.Message)
+	}
+	slices.Sort(got)
+	if len(got) != 96 || len(slices.Compact(slices.Clone(got))) != 96 {
+		t.Errorf("server stored %d records, %d distinct; want each of the 96 good ones once", len(got), len(slices.Compact(got)))
+	}
+	if rec := srv.stored[0]; rec.Service != "api" || rec.TS != "2024-01-02T03:04:05Z" || rec.Fields["n"] == nil {
+		t.Errorf("stored record %+v", rec)
+	}
+}
+
+func TestGRPCSinkUnauthenticated(t *testing.T) {
+	addr := newLogSinkServer(t, &logSinkServer{token: "right"}, nil)
+	gs, err := NewGRPCSink(GRPCOptions{Target: addr, AuthToken: "wrong", MaxRetries: 5, BackoffBase: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gs.Close()
+	err = gs.Write(model.Normalized{Message: "m"})
+	if !errors.Is(err, ErrRejected) || !strings.Contains(err.Error(), "grpc status 16: bad token") {
+		t.Errorf("write = %v, want a rejection for the token", err)
+	}
+}
+
+func TestGRPCSinkGivesUpWhenUnreachable(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+	gs, err := NewGRPCSink(GRPCOptions{Target: addr, MaxRetries: 2, BackoffBase: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gs.Close()
+	err = gs.Write(model.Normalized{Message: "m"})
+	if !errors.Is(err, ErrWriteSink) || errors.Is(err, ErrRejected) {
+		t.Errorf("write = %v, want a retryable write error", err)
+	}
+}
+
+func TestGRPCSinkTLS(t *testing.T) {
+	// httptest's certificate, for 127.0.0.1, serves the gRPC server too.
+	certs := httptest.NewUnstartedServer(nil)
+	certs.StartTLS()
+	certs.Close()
+	srv := &logSinkServer{}
+	addr := newLogSinkServer(t, srv, credentials.NewTLS(&tls.Config{Certificates: certs.TLS.Certificates}))
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certs.Certificate().Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	gs, err := NewGRPCSink(GRPCOptions{Target: addr, CAFile: ca})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gs.Write(model.Legacy{Message: "over tls"}); err != nil {
+		t.Fatal(err)
+	}
+	gs.Close()
+	if len(srv.stored) != 1 || srv.stored[0].Message != "over tls" {
+		t.Errorf("stored %+v", srv.stored)
+	}
+}
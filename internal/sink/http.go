@@ -2,12 +2,21 @@ package sink
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
-	"encoding/json"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"k8s-log-etl/internal/clock"
+	"k8s-log-etl/internal/logger"
 )
 
 // HTTPSink writes records to an HTTP endpoint.
@@ -16,22 +25,92 @@ type HTTPSink struct {
 	client      *http.Client
 	maxRetries  int
 	backoffBase time.Duration
+	clock       clock.Clock
+	trace       *httptrace.ClientTrace
+	conns       struct{ requests, created, reused, dns, tls atomic.Int64 }
+	gzip        bool
+	authToken   string
+	// gzipRejected is set once the endpoint answered a gzipped body with
+	// 415; later bodies are sent uncompressed.
+	gzipRejected atomic.Bool
+}
+
+// HTTPOptions tunes the connection pool and request bodies of an
+// HTTPSink. Zero values take the defaults.
+type HTTPOptions struct {
+	// Gzip compresses each request body and sets Content-Encoding.
+	Gzip bool
+	// MaxIdleConns is how many idle connections are kept for reuse; the
+	// default is DefaultHTTPMaxIdleConns. All of them may go to the one
+	// host the sink posts to, unlike with http.DefaultTransport, which
+	// keeps two per host and so reconnects constantly under many workers.
+	MaxIdleConns int
+	// MaxConnsPerHost caps the open connections; the default is no cap.
+	MaxConnsPerHost int
+	// IdleConnTimeout closes connections idle this long; the default is
+	// DefaultHTTPIdleConnTimeout.
+	IdleConnTimeout time.Duration
+	// AuthToken, when set, is sent as "Authorization: Bearer <AuthToken>".
+	AuthToken string
+}
+
+// HTTP connection pool defaults.
+const (
+	DefaultHTTPMaxIdleConns    = 100
+	DefaultHTTPIdleConnTimeout = 90 * time.Second
+)
+
+// HTTPConnStats counts the connections the requests of an HTTPSink used.
+// Each attempt, retries included, is a request.
+type HTTPConnStats struct {
+	Requests      int
+	New           int // connections dialed
+	Reused        int // requests sent on a pooled connection
+	DNSLookups    int
+	TLSHandshakes int
 }
 
 // NewHTTPSink creates a new HTTP sink.
-func NewHTTPSink(ctx context.Context, url string, maxRetries int, backoffBase time.Duration) (*HTTPSink, error) {
+func NewHTTPSink(ctx context.Context, url string, maxRetries int, backoffBase time.Duration, opts HTTPOptions) (*HTTPSink, error) {
 	if url == "" {
 		return nil, fmt.Errorf("%w: URL required for HTTP sink", ErrOpenSink)
 	}
 
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = opts.MaxIdleConns
+	if transport.MaxIdleConns <= 0 {
+		transport.MaxIdleConns = DefaultHTTPMaxIdleConns
+	}
+	transport.MaxIdleConnsPerHost = transport.MaxIdleConns
+	transport.MaxConnsPerHost = opts.MaxConnsPerHost
+	transport.IdleConnTimeout = opts.IdleConnTimeout
+	if transport.IdleConnTimeout <= 0 {
+		transport.IdleConnTimeout = DefaultHTTPIdleConnTimeout
+	}
 	hs := &HTTPSink{
 		url:         url,
 		maxRetries:  maxRetries,
 		backoffBase: backoffBase,
+		clock:       clock.Real,
+		gzip:        opts.Gzip,
+		authToken:   opts.AuthToken,
 		client: &http.Client{
-			Timeout: 30 * time.Second,
+			Timeout:   30 * time.Second,
+			Transport: transport,
 		},
 	}
+	hs.trace = &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			hs.conns.requests.Add(1)
+			if info.Reused {
+				hs.conns.reused.Add(1)
+			} else {
+				hs.conns.created.Add(1)
+			}
+		},
+		DNSDone:          func(httptrace.DNSDoneInfo) { hs.conns.dns.Add(1) },
+		TLSHandshakeDone: func(tls.ConnectionState, error) { hs.conns.tls.Add(1) },
+	}
 
 	// Test connection
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
@@ -47,24 +126,55 @@ func NewHTTPSink(ctx context.Context, url string, maxRetries int, backoffBase ti
 
 // Write sends a record to the HTTP endpoint.
 func (hs *HTTPSink) Write(record interface{}) error {
-	data, err := json.Marshal(record)
+	return hs.WriteContext(context.Background(), record)
+}
+
+// WriteContext sends a record, aborting the request and any pending backoff
+// when ctx is done. The client's own timeout still applies, so whichever of
+// the two is shorter wins.
+func (hs *HTTPSink) WriteContext(ctx context.Context, record interface{}) error {
+	data, err := marshalRecord(record)
 	if err != nil {
 		return fmt.Errorf("%w: marshal error: %v", ErrWriteSink, err)
 	}
+	body, gzipped := data, hs.gzip && !hs.gzipRejected.Load()
+	if gzipped {
+		buf := gzipBuffers.Get().(*bytes.Buffer)
+		defer gzipBuffers.Put(buf)
+		if body, err = gzipInto(buf, data); err != nil {
+			return fmt.Errorf("%w: gzip: %v", ErrWriteSink, err)
+		}
+	}
 
 	var lastErr error
 	for attempt := 0; attempt <= hs.maxRetries; attempt++ {
-		req, err := http.NewRequest("POST", hs.url, bytes.NewReader(data))
+		req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, hs.trace), "POST", hs.url, bytes.NewReader(body))
 		if err != nil {
 			return fmt.Errorf("%w: create request: %v", ErrWriteSink, err)
 		}
 		req.Header.Set("Content-Type", "application/json")
+		if hs.authToken != "" {
+			req.Header.Set("Authorization", "Bearer "+hs.authToken)
+		}
+		if gzipped {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
 
 		resp, err := hs.client.Do(req)
 		if err != nil {
-			lastErr = fmt.Errorf("%w: http request failed: %v", ErrWriteSink, err)
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				lastErr = fmt.Errorf("%w: %w: http request failed: %v", ErrWriteSink, ErrWriteTimeout, err)
+			} else {
+				lastErr = fmt.Errorf("%w: http request failed: %v", ErrWriteSink, err)
+			}
 			if attempt < hs.maxRetries {
-				time.Sleep(hs.backoffBase * time.Duration(1<<attempt))
+				if err := hs.backoff(ctx, attempt); err != nil {
+					return err
+				}
 				continue
 			}
 			return lastErr
@@ -77,10 +187,22 @@ func (hs *HTTPSink) Write(record interface{}) error {
 		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
 			return nil
 		}
+		if resp.StatusCode == http.StatusUnsupportedMediaType && gzipped {
+			// The endpoint does not take compressed bodies. Send this one
+			// again uncompressed, without counting it as a retry.
+			if hs.gzipRejected.CompareAndSwap(false, true) {
+				logger.WarnContext(ctx, "http endpoint rejected a gzipped body with 415, sending bodies uncompressed", "url", hs.url)
+			}
+			body, gzipped = data, false
+			attempt--
+			continue
+		}
 
 		lastErr = fmt.Errorf("%w: http error status %d", ErrWriteSink, resp.StatusCode)
 		if attempt < hs.maxRetries {
-			time.Sleep(hs.backoffBase * time.Duration(1<<attempt))
+			if err := hs.backoff(ctx, attempt); err != nil {
+				return err
+			}
 			continue
 		}
 	}
@@ -88,6 +210,60 @@ func (hs *HTTPSink) Write(record interface{}) error {
 	return lastErr
 }
 
+// gzipWriters and gzipBuffers are reused across requests; a gzip.Writer
+// allocates several hundred KiB of state.
+var (
+	gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+	gzipBuffers = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+)
+
+// gzipInto compresses data into buf, returning buf's bytes.
+func gzipInto(buf *bytes.Buffer, data []byte) ([]byte, error) {
+	buf.Reset()
+	zw := gzipWriters.Get().(*gzip.Writer)
+	defer gzipWriters.Put(zw)
+	zw.Reset(buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// backoff sleeps before the next attempt, returning early if ctx is done.
+func (hs *HTTPSink) backoff(ctx context.Context, attempt int) error {
+	return hs.clock.Sleep(ctx, hs.backoffBase*time.Duration(1<<attempt))
+}
+
+// ConnStats returns the connection counts so far.
+func (hs *HTTPSink) ConnStats() HTTPConnStats {
+	return HTTPConnStats{
+		Requests:      int(hs.conns.requests.Load()),
+		New:           int(hs.conns.created.Load()),
+		Reused:        int(hs.conns.reused.Load()),
+		DNSLookups:    int(hs.conns.dns.Load()),
+		TLSHandshakes: int(hs.conns.tls.Load()),
+	}
+}
+
+// HTTPConnections returns the connection counts of the HTTPSink in w's
+// chain. The second result is false when there is none.
+func HTTPConnections(w Writer) (HTTPConnStats, bool) {
+	for w != nil {
+		if hs, ok := w.(*HTTPSink); ok {
+			return hs.ConnStats(), true
+		}
+		u, ok := w.(Unwrapper)
+		if !ok {
+			break
+		}
+		w = u.Unwrap()
+	}
+	return HTTPConnStats{}, false
+}
+
 // Close closes the HTTP sink (no-op for HTTP).
 func (hs *HTTPSink) Close() error {
 	if hs.client != nil {
@@ -95,4 +271,3 @@ func (hs *HTTPSink) Close() error {
 	}
 	return nil
 }
-
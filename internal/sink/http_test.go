@@ -1,12 +1,22 @@
 package sink
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
+	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"k8s-log-etl/internal/clock"
+	"k8s-log-etl/internal/logger"
+	"k8s-log-etl/internal/model"
 )
 
 func TestHTTPSink_Write(t *testing.T) {
@@ -22,7 +32,7 @@ func TestHTTPSink_Write(t *testing.T) {
 	defer server.Close()
 
 	ctx := context.Background()
-	hs, err := NewHTTPSink(ctx, server.URL, 3, 10*time.Millisecond)
+	hs, err := NewHTTPSink(ctx, server.URL, 3, 10*time.Millisecond, HTTPOptions{})
 	if err != nil {
 		t.Fatalf("NewHTTPSink: %v", err)
 	}
@@ -33,9 +43,7 @@ func TestHTTPSink_Write(t *testing.T) {
 		t.Fatalf("Write: %v", err)
 	}
 
-	// Give server time to process
-	time.Sleep(50 * time.Millisecond)
-
+	// Write returns after the server responds, so the record has arrived.
 	if len(receivedRecords) != 1 {
 		t.Errorf("expected 1 record, got %d", len(receivedRecords))
 	}
@@ -54,14 +62,23 @@ func TestHTTPSink_Retry(t *testing.T) {
 	defer server.Close()
 
 	ctx := context.Background()
-	hs, err := NewHTTPSink(ctx, server.URL, 3, 10*time.Millisecond)
+	hs, err := NewHTTPSink(ctx, server.URL, 3, time.Second, HTTPOptions{})
 	if err != nil {
 		t.Fatalf("NewHTTPSink: %v", err)
 	}
 	defer hs.Close()
+	clk := clock.NewFake(time.Unix(0, 0))
+	hs.clock = clk
 
 	record := map[string]interface{}{"test": "value"}
-	if err := hs.Write(record); err != nil {
+	done := make(chan error, 1)
+	go func() { done <- hs.Write(record) }()
+	// Two failures back off for 1s and then 2s of fake time.
+	for _, wait := range []time.Duration{time.Second, 2 * time.Second} {
+		clk.BlockUntil(1)
+		clk.Advance(wait)
+	}
+	if err := <-done; err != nil {
 		t.Fatalf("Write: %v", err)
 	}
 
@@ -77,7 +94,7 @@ func TestHTTPSink_MaxRetriesExceeded(t *testing.T) {
 	defer server.Close()
 
 	ctx := context.Background()
-	hs, err := NewHTTPSink(ctx, server.URL, 2, 10*time.Millisecond)
+	hs, err := NewHTTPSink(ctx, server.URL, 2, 10*time.Millisecond, HTTPOptions{})
 	if err != nil {
 		t.Fatalf("NewHTTPSink: %v", err)
 	}
@@ -89,3 +106,223 @@ func TestHTTPSink_MaxRetriesExceeded(t *testing.T) {
 	}
 }
 
+// writeConcurrently has each of workers goroutines write writes records
+// through hs, pausing for pause after each.
+func writeConcurrently(tb testing.TB, hs *HTTPSink, workers, writes int, pause time.Duration) {
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < writes; j++ {
+				if err := hs.Write(map[string]any{"msg": "hello"}); err != nil {
+					tb.Error(err)
+					return
+				}
+				time.Sleep(pause)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestHTTPSink_PoolReusesConnections(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		name    string
+		pool    HTTPOptions
+		maxConn int
+	}{
+		// Every worker keeps its connection: at most one dial each.
+		{"default", HTTPOptions{}, 8},
+		{"capped", HTTPOptions{MaxConnsPerHost: 2}, 2},
+	} {
+		hs, err := NewHTTPSink(context.Background(), server.URL, 0, time.Millisecond, tc.pool)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeConcurrently(t, hs, 8, 50, 0)
+		hs.Close()
+		got := hs.ConnStats()
+		if got.Requests != 400 || got.New+got.Reused != got.Requests || got.New > tc.maxConn || got.TLSHandshakes != 0 {
+			t.Errorf("%s: %+v, want 400 requests on at most %d connections", tc.name, got, tc.maxConn)
+		}
+	}
+}
+
+func TestHTTPConnections_FindsWrappedSink(t *testing.T) {
+	hs, err := NewHTTPSink(context.Background(), "http://127.0.0.1:1", 0, time.Millisecond, HTTPOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := HTTPConnections(NewProjectSink(hs, nil)); !ok {
+		t.Error("HTTPConnections did not find the sink behind a wrapper")
+	}
+	if _, ok := HTTPConnections(NewMemorySink()); ok {
+		t.Error("HTTPConnections found an http sink in a memory sink")
+	}
+}
+
+// BenchmarkHTTPSink_Pool compares the connections 8 workers dial with
+// http.DefaultTransport's 2 idle connections per host against the sink's
+// default pool. The workers pause between writes, as pipeline workers do
+// while waiting for records, so connections go idle together and all but
+// two are closed when the pool is small.
+func BenchmarkHTTPSink_Pool(b *testing.B) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	for _, bc := range []struct {
+		name string
+		pool HTTPOptions
+	}{
+		{"idle2", HTTPOptions{MaxIdleConns: 2}},
+		{"default", HTTPOptions{}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			hs, err := NewHTTPSink(context.Background(), server.URL, 0, time.Millisecond, bc.pool)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer hs.Close()
+			b.ResetTimer()
+			writeConcurrently(b, hs, 8, b.N/8+1, 100*time.Microsecond)
+			b.StopTimer()
+			s := hs.ConnStats()
+			b.ReportMetric(float64(s.New)/float64(s.Requests), "new_conns/op")
+		})
+	}
+}
+
+func TestHTTPSink_GzipBody(t *testing.T) {
+	var got map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "gzip" {
+			t.Errorf("Content-Encoding %q", r.Header.Get("Content-Encoding"))
+		}
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Errorf("gzip reader: %v", err)
+			return
+		}
+		if err := json.NewDecoder(zr).Decode(&got); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	hs, err := NewHTTPSink(context.Background(), server.URL, 0, time.Millisecond, HTTPOptions{Gzip: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hs.Close()
+	msg := strings.Repeat("compressible ", 100)
+	for i := 0; i < 3; i++ {
+		// Pooled writers and buffers must not carry one body into the next.
+		if err := hs.Write(map[string]any{"msg": msg, "n": i}); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		if got["msg"] != msg || got["n"] != float64(i) {
+			t.Fatalf("server decoded %v", got)
+		}
+	}
+}
+
+func TestHTTPSink_GzipFallbackOn415(t *testing.T) {
+	var logs bytes.Buffer
+	prev := logger.Logger()
+	logger.SetLogger(slog.New(slog.NewJSONHandler(&logs, nil)))
+	defer logger.SetLogger(prev)
+
+	var mu sync.Mutex
+	var gzipped, plain int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("Content-Encoding") != "" {
+			gzipped++
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		var record map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&record); err != nil {
+			t.Errorf("decode uncompressed body: %v", err)
+		}
+		plain++
+	}))
+	defer server.Close()
+
+	// No retries: the uncompressed resend must not use one up.
+	hs, err := NewHTTPSink(context.Background(), server.URL, 0, time.Millisecond, HTTPOptions{Gzip: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hs.Close()
+	for i := 0; i < 3; i++ {
+		if err := hs.Write(map[string]any{"n": i}); err != nil {
+			t.Fatalf("Write %d: %v", i, err)
+		}
+	}
+	if gzipped != 1 || plain != 3 {
+		t.Errorf("%d gzipped and %d plain requests, want 1 and 3", gzipped, plain)
+	}
+	if n := strings.Count(logs.String(), "rejected a gzipped body"); n != 1 {
+		t.Errorf("fallback logged %d times, want once: %s", n, logs.String())
+	}
+}
+
+func TestHTTPSink_BodyMatchesJSONLLine(t *testing.T) {
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+	hs, err := NewHTTPSink(context.Background(), server.URL, 0, time.Millisecond, HTTPOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hs.Close()
+
+	record := model.Normalized{Message: "bad \xff byte\x00 <b>& \n\t", Fields: map[string]any{"k": "\x1b[31m"}}
+	if err := hs.Write(record); err != nil {
+		t.Fatal(err)
+	}
+	var line bufferCloser
+	if err := NewJSONLSink(&line).Write(record); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSuffix(line.String(), "\n"); got != string(body) {
+		t.Errorf("jsonl line %s\nhttp body  %s", got, body)
+	}
+	if !json.Valid(body) || bytes.ContainsAny(body, "\x00\x1b\n\t<") {
+		t.Errorf("body not escaped: %q", body)
+	}
+}
+
+func TestHTTPSink_AuthToken(t *testing.T) {
+	var auth []string
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		auth = append(auth, r.Header.Get("Authorization"))
+		mu.Unlock()
+		if len(auth) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	hs, err := NewHTTPSink(context.Background(), server.URL, 1, time.Millisecond, HTTPOptions{AuthToken: "t0ken"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hs.Close()
+	if err := hs.Write(map[string]any{"msg": "hi"}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	// Retries carry the token too.
+	if len(auth) != 2 || auth[0] != "Bearer t0ken" || auth[1] != "Bearer t0ken" {
+		t.Errorf("Authorization headers %q", auth)
+	}
+}
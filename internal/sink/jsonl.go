@@ -1,9 +1,13 @@
 package sink
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
+	"strings"
+
+	"k8s-log-etl/internal/config"
 )
 
 // Writer writes normalized records.
@@ -12,27 +16,186 @@ type Writer interface {
 	Close() error
 }
 
+// JSONEncoding sets how the JSON sinks encode a record. Whatever it says,
+// invalid UTF-8 is written as U+FFFD and control characters, U+2028, and
+// U+2029 are escaped.
+type JSONEncoding struct {
+	// EscapeHTML escapes <, >, and & as \u003c, \u003e, and \u0026.
+	EscapeHTML bool
+	// SortKeys orders the keys of every object by name, the record's own
+	// included; without it only those of maps such as Fields are sorted.
+	SortKeys bool
+	// Indent puts each value on its own line, indented by Indent spaces a
+	// level. 0 keeps a record on one line.
+	Indent int
+}
+
+// DefaultJSONEncoding is the encoding of json.Marshal, which the JSON sinks
+// use unless told otherwise.
+var DefaultJSONEncoding = JSONEncoding{EscapeHTML: true}
+
+// JSONEncodingOf returns the encoding cfg's output_escape_html,
+// output_sort_keys, and output_indent ask for.
+func JSONEncodingOf(cfg config.Config) JSONEncoding {
+	return JSONEncoding{
+		EscapeHTML: cfg.OutputEscapeHTMLEnabled(),
+		SortKeys:   cfg.OutputSortKeys,
+		Indent:     cfg.OutputIndent,
+	}
+}
+
+// Marshal encodes record, without a trailing newline. The options are
+// applied to json.Marshal's output rather than through a json.Encoder:
+// records such as model.Legacy encode themselves with json.Marshal, and an
+// encoder's settings do not reach inside a MarshalJSON.
+func (e JSONEncoding) Marshal(record any) ([]byte, error) {
+	data, err := json.Marshal(record)
+	if err != nil || e == DefaultJSONEncoding {
+		return data, err
+	}
+	if e.SortKeys {
+		if data, err = sortKeys(data); err != nil {
+			return nil, err
+		}
+	}
+	if !e.EscapeHTML {
+		data = unescapeHTML(data)
+	}
+	if e.Indent > 0 {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, data, "", strings.Repeat(" ", e.Indent)); err != nil {
+			return nil, err
+		}
+		data = buf.Bytes()
+	}
+	return data, nil
+}
+
+// sortKeys encodes data again with every object's keys in order: decoded
+// into maps, which json.Marshal writes sorted. UseNumber keeps numbers as
+// they were written.
+func sortKeys(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// unescapeHTML turns the \u003c, \u003e, and \u0026 escapes json.Marshal
+// writes back into <, >, and &. A backslash in JSON only ever starts an
+// escape inside a string, so escapes are skipped pair by pair and an
+// escaped backslash followed by u003c is left alone.
+func unescapeHTML(data []byte) []byte {
+	if !bytes.Contains(data, []byte(`\u00`)) {
+		return data
+	}
+	out := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		if data[i] != '\\' || i+1 == len(data) {
+			out = append(out, data[i])
+			continue
+		}
+		if data[i+1] == 'u' && i+6 <= len(data) {
+			var c byte
+			switch string(data[i+2 : i+6]) {
+			case "003c":
+				c = '<'
+			case "003e":
+				c = '>'
+			case "0026":
+				c = '&'
+			}
+			if c != 0 {
+				out = append(out, c)
+				i += 5
+				continue
+			}
+		}
+		out = append(out, data[i], data[i+1])
+		i++
+	}
+	return out
+}
+
+// Line is the LineFormat of e: the record's encoding and a newline.
+func (e JSONEncoding) Line(record any) ([]byte, error) {
+	data, err := e.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrWriteSink, err)
+	}
+	return append(data, '\n'), nil
+}
+
+// marshalRecord encodes a record with DefaultJSONEncoding. The http sink
+// uses it, as do the other JSON sinks unless given another encoding, so by
+// default a record comes out byte for byte the same whichever writes it.
+func marshalRecord(record any) ([]byte, error) {
+	return DefaultJSONEncoding.Marshal(record)
+}
+
 // JSONLSink writes records as JSON lines.
 type JSONLSink struct {
-	enc    *json.Encoder
+	w      io.Writer
 	closer io.Closer
+	enc    JSONEncoding
+	file   *trackedFile // nil unless writing a local file
+	index  *traceIndex  // nil without output_index
 }
 
 // NewJSONLSink wraps a WriteCloser into a JSONL writer.
 func NewJSONLSink(w io.WriteCloser) *JSONLSink {
+	return NewEncodedJSONLSink(w, DefaultJSONEncoding)
+}
+
+// NewEncodedJSONLSink is NewJSONLSink with records encoded by enc.
+func NewEncodedJSONLSink(w io.WriteCloser, enc JSONEncoding) *JSONLSink {
 	return &JSONLSink{
-		enc:    json.NewEncoder(w),
+		w:      w,
 		closer: w,
+		enc:    enc,
 	}
 }
 
+// newFileJSONLSink writes to a local file, tracking what it writes for
+// Files. path is the name the file is published under.
+func newFileJSONLSink(w io.WriteCloser, path string, enc JSONEncoding) *JSONLSink {
+	tf := newTrackedFile(w, path)
+	s := NewEncodedJSONLSink(tf, enc)
+	s.file = tf
+	return s
+}
+
 func (s *JSONLSink) Write(record any) error {
-	if err := s.enc.Encode(record); err != nil {
+	data, err := s.enc.Marshal(record)
+	if err != nil {
 		return fmt.Errorf("%w: %v", ErrWriteSink, err)
 	}
+	line := append(data, '\n')
+	if _, err := s.w.Write(line); err != nil {
+		return fileError(ErrWriteSink, err)
+	}
+	if s.file != nil {
+		s.file.records++
+		if s.index != nil {
+			s.index.add(record, s.file.path, s.file.bytes-int64(len(line)), int64(len(line)))
+		}
+	}
 	return nil
 }
 
+// Files implements FileReporter. It is empty unless the sink writes a file.
+func (s *JSONLSink) Files() []FileInfo {
+	if s.file == nil {
+		return nil
+	}
+	return []FileInfo{s.file.info()}
+}
+
 func (s *JSONLSink) Close() error {
-	return s.closer.Close()
+	err := closeError(s.closer.Close())
+	closeTraceIndex(s.index)
+	return err
 }
@@ -0,0 +1,91 @@
+package sink
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"k8s-log-etl/internal/model"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files")
+
+func TestJSONEncodingGolden(t *testing.T) {
+	rec := model.Normalized{
+		TS:      "2024-01-02T15:04:05Z",
+		Level:   "ERROR",
+		Service: "payments",
+		Message: `<b>card & cvv</b> rejected`,
+		Fields: map[string]any{
+			"url":     "/pay?a=1&b=<2>",
+			"literal": `\u003c stays as written`,
+			"http":    map[string]any{"status": json.Number("502"), "latency": json.Number("1.50")},
+			"tags":    []any{"a>b", "c"},
+		},
+	}
+	tests := []struct {
+		name string
+		enc  JSONEncoding
+	}{
+		{"escaped", DefaultJSONEncoding},
+		{"unescaped", JSONEncoding{}},
+		{"sorted", JSONEncoding{SortKeys: true}},
+		{"indented", JSONEncoding{EscapeHTML: true, SortKeys: true, Indent: 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bufferCloser
+			s := NewEncodedJSONLSink(&buf, tt.enc)
+			// The legacy schema encodes itself, so the options must reach
+			// past its MarshalJSON.
+			for _, r := range []any{rec, model.Legacy(rec)} {
+				if err := s.Write(r); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got := buf.String()
+			golden := filepath.Join("testdata", "encoding_"+tt.name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+					t.Fatalf("update golden: %v", err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("read golden (run with -update to create): %v", err)
+			}
+			if got != string(want) {
+				t.Errorf("output differs from %s (run with -update to accept):\n%s", golden, got)
+			}
+		})
+	}
+}
+
+func TestJSONEncoding_SameRecordWhateverTheOptions(t *testing.T) {
+	rec := model.Normalized{Message: "a < b && c > d", Fields: map[string]any{"n": json.Number("1e3"), "x": `\u003c`}}
+	want, err := json.Marshal(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wantV any
+	if err := json.Unmarshal(want, &wantV); err != nil {
+		t.Fatal(err)
+	}
+	// Each option changes the bytes but not the record they decode to.
+	for _, enc := range []JSONEncoding{{}, {SortKeys: true}, {Indent: 4}, {EscapeHTML: true, SortKeys: true, Indent: 1}} {
+		data, err := enc.Marshal(rec)
+		if err != nil {
+			t.Fatalf("%+v: %v", enc, err)
+		}
+		var got any
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatalf("%+v: invalid JSON %s: %v", enc, data, err)
+		}
+		if !reflect.DeepEqual(got, wantV) {
+			t.Errorf("%+v: %s decodes to %v, want %v", enc, data, got, wantV)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package sink
+
+import (
+	"context"
+
+	"k8s-log-etl/internal/model"
+)
+
+// LegacySink passes each model.Normalized record on as a model.Legacy, so
+// it encodes with the legacy field names. Other record types pass through
+// unchanged.
+type LegacySink struct {
+	wrapped Writer
+}
+
+// NewLegacySink wraps w so its records keep the legacy output schema.
+func NewLegacySink(w Writer) *LegacySink {
+	return &LegacySink{wrapped: w}
+}
+
+func (s *LegacySink) Write(record any) error {
+	return s.wrapped.Write(legacy(record))
+}
+
+// WriteContext implements ContextWriter.
+func (s *LegacySink) WriteContext(ctx context.Context, record any) error {
+	return WriteContext(ctx, s.wrapped, legacy(record))
+}
+
+func legacy(record any) any {
+	if n, ok := record.(model.Normalized); ok {
+		return model.Legacy(n)
+	}
+	return record
+}
+
+// Unwrap implements Unwrapper.
+func (s *LegacySink) Unwrap() Writer {
+	return s.wrapped
+}
+
+// Close closes the wrapped sink.
+func (s *LegacySink) Close() error {
+	return s.wrapped.Close()
+}
@@ -0,0 +1,35 @@
+package sink
+
+import (
+	"testing"
+
+	"k8s-log-etl/internal/model"
+)
+
+func TestLegacySink(t *testing.T) {
+	mem := NewMemorySink()
+	s := NewLegacySink(mem)
+	rec := model.Normalized{TS: "2024-01-01T12:00:00Z", Level: "ERROR", Service: "api", Message: "boom", TraceID: "t1", Error: "timeout"}
+	if err := s.Write(rec); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Write(map[string]any{"window": "w1"}); err != nil {
+		t.Fatal(err)
+	}
+	got := mem.Records()
+	want := []string{
+		`{"TS":"2024-01-01T12:00:00Z","Level":"ERROR","Service":"api","Namespace":"","Pod":"","Node":"","Message":"boom","TraceID":"t1","Error":"timeout","Fields":null}`,
+		`{"window":"w1"}`,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d records, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if string(got[i]) != want[i] {
+			t.Errorf("record %d = %s, want %s", i, got[i], want[i])
+		}
+	}
+	if s.Unwrap() != mem {
+		t.Error("Unwrap should return the wrapped sink")
+	}
+}
@@ -0,0 +1,72 @@
+package sink
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+)
+
+// FileInfo describes one output file: how many records it holds, its size,
+// and the SHA-256 of its contents.
+type FileInfo struct {
+	Path    string `json:"path"`
+	Records int    `json:"records"`
+	Bytes   int64  `json:"bytes"`
+	SHA256  string `json:"sha256"`
+}
+
+// FileReporter is implemented by sinks that write local files.
+type FileReporter interface {
+	Files() []FileInfo
+}
+
+// Unwrapper is implemented by sinks that wrap another sink.
+type Unwrapper interface {
+	Unwrap() Writer
+}
+
+// Files returns the files written by w, looking through wrapping sinks. The
+// second result is false when no sink in the chain writes local files.
+func Files(w Writer) ([]FileInfo, bool) {
+	for w != nil {
+		if fr, ok := w.(FileReporter); ok {
+			return fr.Files(), true
+		}
+		u, ok := w.(Unwrapper)
+		if !ok {
+			break
+		}
+		w = u.Unwrap()
+	}
+	return nil, false
+}
+
+// trackedFile counts and hashes bytes as they are written, so the manifest
+// never needs to re-read the file.
+type trackedFile struct {
+	w       io.WriteCloser
+	path    string
+	records int
+	bytes   int64
+	hash    hash.Hash
+}
+
+func newTrackedFile(w io.WriteCloser, path string) *trackedFile {
+	return &trackedFile{w: w, path: path, hash: sha256.New()}
+}
+
+func (t *trackedFile) Write(p []byte) (int, error) {
+	n, err := t.w.Write(p)
+	t.hash.Write(p[:n])
+	t.bytes += int64(n)
+	return n, err
+}
+
+func (t *trackedFile) Close() error {
+	return t.w.Close()
+}
+
+func (t *trackedFile) info() FileInfo {
+	return FileInfo{Path: t.path, Records: t.records, Bytes: t.bytes, SHA256: hex.EncodeToString(t.hash.Sum(nil))}
+}
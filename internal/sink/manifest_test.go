@@ -0,0 +1,61 @@
+package sink
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func checkFileInfo(t *testing.T, info FileInfo, records int) {
+	t.Helper()
+	data, err := os.ReadFile(info.Path)
+	if err != nil {
+		t.Fatalf("read %s: %v", info.Path, err)
+	}
+	sum := sha256.Sum256(data)
+	if info.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("%s: checksum mismatch", info.Path)
+	}
+	if info.Bytes != int64(len(data)) {
+		t.Errorf("%s: expected %d bytes, got %d", info.Path, len(data), info.Bytes)
+	}
+	if info.Records != records {
+		t.Errorf("%s: expected %d records, got %d", info.Path, records, info.Records)
+	}
+}
+
+func TestFilesThroughBatchedSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.jsonl")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	bs, err := NewBatchedSink(newFileJSONLSink(f, path, DefaultJSONEncoding), 2, time.Hour)
+	if err != nil {
+		t.Fatalf("NewBatchedSink: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		bs.Write(map[string]any{"i": i})
+	}
+	if err := bs.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	files, ok := Files(bs)
+	if !ok || len(files) != 1 {
+		t.Fatalf("expected one file through the batched sink, got %+v (ok=%v)", files, ok)
+	}
+	checkFileInfo(t, files[0], 3)
+}
+
+func TestFilesStdoutSink(t *testing.T) {
+	if files, ok := Files(NewJSONLSink(nopCloser{os.Stdout})); !ok || len(files) != 0 {
+		t.Fatalf("expected no files for a non-file sink, got %+v", files)
+	}
+	if _, ok := Files(&testWriter{}); ok {
+		t.Fatal("expected a sink without FileReporter to report ok=false")
+	}
+}
@@ -0,0 +1,49 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// MemorySink keeps each record as the JSON the JSONL sink would write for
+// it, without the newline, in write order. It is safe for concurrent use.
+type MemorySink struct {
+	mu      sync.Mutex
+	records [][]byte
+}
+
+// NewMemorySink returns an empty MemorySink.
+func NewMemorySink() *MemorySink {
+	return &MemorySink{}
+}
+
+func (s *MemorySink) Write(record any) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	s.mu.Lock()
+	s.records = append(s.records, b)
+	s.mu.Unlock()
+	return nil
+}
+
+// WriteContext implements ContextWriter. Writes never block, so ctx is only
+// checked up front.
+func (s *MemorySink) WriteContext(ctx context.Context, record any) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.Write(record)
+}
+
+// Records returns the records written so far.
+func (s *MemorySink) Records() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]byte(nil), s.records...)
+}
+
+func (s *MemorySink) Close() error { return nil }
@@ -0,0 +1,549 @@
+package sink
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s-log-etl/internal/logger"
+	"k8s-log-etl/internal/model"
+	"k8s-log-etl/internal/natsconn"
+)
+
+// NATS sink defaults.
+const (
+	DefaultNATSMaxInFlight  = 256
+	DefaultNATSAckTimeout   = 5 * time.Second
+	DefaultNATSCloseTimeout = 30 * time.Second
+)
+
+// NATSOptions configures NewNATSSink.
+type NATSOptions struct {
+	// Servers are tried in turn: nats://host:port, or tls://host:port to
+	// require TLS. User and password may be given in the URL.
+	Servers []string
+	// Subject is published to, with {service}, {namespace}, and {level}
+	// replaced by the record's values; see ParseNATSSubject.
+	Subject  string
+	User     string
+	Password string
+	Token    string
+	// CredsFile holds a user JWT and NKey seed, as nsc writes them.
+	CredsFile string
+	// MaxInFlight caps the records published and not yet acknowledged;
+	// a write waits for an ack when it is reached.
+	MaxInFlight int
+	// AckTimeout is how long a publish waits for its ack before it is sent
+	// again.
+	AckTimeout  time.Duration
+	MaxRetries  int
+	BackoffBase time.Duration
+	// CloseTimeout bounds how long Close waits for outstanding acks.
+	CloseTimeout time.Duration
+}
+
+// NATSStats counts the publishes of a NATSSink.
+type NATSStats struct {
+	Published int // records, each counted once however often it was sent
+	Acked     int
+	Retried   int // publishes sent again after a nack, timeout, or lost connection
+	Failed    int // records given up on after MaxRetries
+	InFlight  int
+}
+
+// NATSSink publishes records as JSON to NATS JetStream. Publishes are
+// asynchronous: a write returns once its record is sent, and up to
+// MaxInFlight records wait for their JetStream acks at a time. A nack, a
+// missing ack, or a lost connection is retried by the sink itself, up to
+// MaxRetries times with backoff; the Nats-Msg-Id header lets the stream
+// drop the copies it already has. Records that still fail are logged and
+// make Close return an error, since their writes have already returned.
+type NATSSink struct {
+	opts    NATSOptions
+	subject NATSSubject
+	inbox   string // reply subject prefix, ending in "."
+	session string // Nats-Msg-Id prefix
+	auth    natsconn.Auth
+	slots   chan struct{}
+
+	connMu sync.Mutex // held while connecting
+
+	mu       sync.Mutex
+	conn     *natsconn.Conn // nil when not connected
+	next     int            // servers index to try first
+	seq      uint64
+	inflight map[string]*natsMsg
+	stats    NATSStats
+	closed   bool
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// natsMsg is a record published and not yet acknowledged.
+type natsMsg struct {
+	subject  string
+	id       string // its Nats-Msg-Id and the last token of its reply subject
+	payload  []byte
+	attempts int       // publishes so far
+	due      time.Time // when to send it again: its ack deadline, or the end of a backoff
+	failed   bool      // the last attempt failed and due ends its backoff
+	cause    error     // why the last attempt failed
+}
+
+// NewNATSSink creates a NATS sink and connects to the first server that
+// answers.
+func NewNATSSink(opts NATSOptions) (*NATSSink, error) {
+	if len(opts.Servers) == 0 {
+		return nil, fmt.Errorf("%w: nats server URL required", ErrOpenSink)
+	}
+	subject, err := ParseNATSSubject(opts.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOpenSink, err)
+	}
+	if opts.MaxInFlight <= 0 {
+		opts.MaxInFlight = DefaultNATSMaxInFlight
+	}
+	if opts.AckTimeout <= 0 {
+		opts.AckTimeout = DefaultNATSAckTimeout
+	}
+	if opts.CloseTimeout <= 0 {
+		opts.CloseTimeout = DefaultNATSCloseTimeout
+	}
+	var id [12]byte
+	rand.Read(id[:])
+	s := &NATSSink{
+		opts:     opts,
+		subject:  subject,
+		inbox:    "_INBOX." + hex.EncodeToString(id[:]) + ".",
+		session:  hex.EncodeToString(id[:]),
+		slots:    make(chan struct{}, opts.MaxInFlight),
+		inflight: make(map[string]*natsMsg),
+		stop:     make(chan struct{}),
+		auth:     natsconn.Auth{User: opts.User, Password: opts.Password, Token: opts.Token},
+	}
+	if opts.CredsFile != "" {
+		if s.auth.JWT, s.auth.Seed, err = natsconn.ReadCreds(opts.CredsFile); err != nil {
+			return nil, fmt.Errorf("%w: nats creds file: %v", ErrOpenSink, err)
+		}
+	}
+	if _, err := s.connect(ErrOpenSink); err != nil {
+		return nil, err
+	}
+	s.wg.Add(1)
+	go s.resendLoop()
+	return s, nil
+}
+
+func (s *NATSSink) Write(record any) error {
+	return s.WriteContext(context.Background(), record)
+}
+
+// WriteContext implements ContextWriter. It waits for room in the
+// in-flight window, bounded by ctx, then publishes the record without
+// waiting for its ack.
+func (s *NATSSink) WriteContext(ctx context.Context, record any) error {
+	payload, err := marshalRecord(record)
+	if err != nil {
+		return fmt.Errorf("%w: nats: %v", ErrFormat, err)
+	}
+	select {
+	case s.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		<-s.slots
+		return fmt.Errorf("%w: nats sink is closed", ErrWriteSink)
+	}
+	s.seq++
+	m := &natsMsg{subject: s.subject.Render(record), id: s.session + "-" + strconv.FormatUint(s.seq, 10), payload: payload}
+	s.mu.Unlock()
+
+	c, err := s.connection()
+	if err == nil && c.MaxPayload > 0 && len(payload) > c.MaxPayload {
+		err = fmt.Errorf("%w: %w: record of %d bytes is over the nats server's max_payload of %d", ErrWriteSink, ErrRejected, len(payload), c.MaxPayload)
+	}
+	if err == nil {
+		err = s.publish(c, m)
+	}
+	if err != nil {
+		<-s.slots
+		return err
+	}
+	s.mu.Lock()
+	s.stats.Published++
+	s.mu.Unlock()
+	return nil
+}
+
+// publish sends m on c and tracks it until its ack.
+func (s *NATSSink) publish(c *natsconn.Conn, m *natsMsg) error {
+	s.mu.Lock()
+	m.attempts++
+	m.due = time.Now().Add(s.opts.AckTimeout)
+	m.failed = false
+	s.inflight[m.id] = m
+	s.mu.Unlock()
+
+	if err := c.Publish(m.subject, s.inbox+m.id, m.id, m.payload); err != nil {
+		err = fmt.Errorf("%w: nats publish: %v", ErrWriteSink, err)
+		s.lost(c, err)
+		if m.attempts == 1 {
+			// The write reports it; the record is not the sink's to resend.
+			s.mu.Lock()
+			delete(s.inflight, m.id)
+			s.mu.Unlock()
+		}
+		return err
+	}
+	return nil
+}
+
+// connection returns the connection, connecting to the servers in turn
+// when there is none.
+func (s *NATSSink) connection() (*natsconn.Conn, error) {
+	return s.connect(ErrWriteSink)
+}
+
+// connect is connection with the errors of the servers wrapping kind.
+func (s *NATSSink) connect(kind error) (*natsconn.Conn, error) {
+	current := func() (*natsconn.Conn, int, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.closed {
+			return nil, 0, fmt.Errorf("%w: nats sink is closed", ErrWriteSink)
+		}
+		return s.conn, s.next, nil
+	}
+	if c, _, err := current(); c != nil || err != nil {
+		return c, err
+	}
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	c, start, err := current()
+	if c != nil || err != nil {
+		return c, err
+	}
+	var errs []error
+	for i := range s.opts.Servers {
+		n := (start + i) % len(s.opts.Servers)
+		c, err := s.dial(s.opts.Servers[n])
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			c.Close()
+			return nil, fmt.Errorf("%w: nats sink is closed", ErrWriteSink)
+		}
+		s.conn, s.next = c, n
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.readLoop(c)
+		return c, nil
+	}
+	return nil, fmt.Errorf("%w: %w", kind, errors.Join(errs...))
+}
+
+// dial connects to server and subscribes to the sink's inbox.
+func (s *NATSSink) dial(server string) (*natsconn.Conn, error) {
+	c, err := natsconn.Dial(server, s.auth)
+	if err != nil {
+		if errors.Is(err, natsconn.ErrAuthorization) {
+			return nil, fmt.Errorf("%w: %w", ErrRejected, err)
+		}
+		return nil, err
+	}
+	if err := c.Subscribe(s.inbox+"*", 1); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("nats %s: %v", server, err)
+	}
+	return c, nil
+}
+
+// readLoop reads the acks of published records on c until the connection
+// fails.
+func (s *NATSSink) readLoop(c *natsconn.Conn) {
+	defer s.wg.Done()
+	for {
+		m, err := c.ReadMsg()
+		if err != nil {
+			s.lost(c, fmt.Errorf("%w: nats connection: %v", ErrWriteSink, err))
+			return
+		}
+		s.acked(strings.TrimPrefix(m.Subject, s.inbox), m.Status(), m.Data)
+	}
+}
+
+// acked settles the record with id from its JetStream reply: a PubAck,
+// an API error, or a 503 status when no stream takes the subject.
+func (s *NATSSink) acked(id, status string, body []byte) {
+	var err error
+	switch {
+	case strings.HasPrefix(status, "503"):
+		err = errors.New("no JetStream stream takes the subject (503 no responders)")
+	case status != "":
+		err = fmt.Errorf("status %s", status)
+	default:
+		var ack struct {
+			Stream string `json:"stream"`
+			Error  *struct {
+				Code        int    `json:"code"`
+				Description string `json:"description"`
+			} `json:"error"`
+		}
+		switch {
+		case json.Unmarshal(body, &ack) != nil:
+			err = fmt.Errorf("unreadable ack %q", body)
+		case ack.Error != nil:
+			err = fmt.Errorf("jetstream error %d: %s", ack.Error.Code, ack.Error.Description)
+		case ack.Stream == "":
+			err = fmt.Errorf("ack without a stream: %q", body)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.inflight[id]
+	if m == nil || m.failed {
+		return // a late reply to an attempt already given up on
+	}
+	if err == nil {
+		delete(s.inflight, id)
+		s.stats.Acked++
+		<-s.slots
+		return
+	}
+	m.failed = true
+	m.cause = fmt.Errorf("%w: nats publish to %s nacked: %v", ErrWriteSink, m.subject, err)
+	m.due = time.Now().Add(s.backoff(m.attempts))
+}
+
+// lost drops c after it failed, and has every record waiting for an ack
+// on it sent again, on a new connection, after a backoff.
+func (s *NATSSink) lost(c *natsconn.Conn, err error) {
+	c.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != c {
+		return
+	}
+	s.conn = nil
+	s.next++
+	if s.closed {
+		return
+	}
+	logger.Warn("nats connection lost, resending unacknowledged records", "error", err, "in_flight", len(s.inflight))
+	for _, m := range s.inflight {
+		if !m.failed {
+			m.failed, m.cause, m.due = true, err, time.Now().Add(s.backoff(m.attempts))
+		}
+	}
+}
+
+func (s *NATSSink) backoff(attempts int) time.Duration {
+	return s.opts.BackoffBase * time.Duration(1<<max(attempts-1, 0))
+}
+
+// resendLoop sends records again once their ack is overdue or their
+// backoff has passed, and gives up on those out of retries.
+func (s *NATSSink) resendLoop() {
+	defer s.wg.Done()
+	tick := time.NewTicker(min(max(s.opts.AckTimeout/10, 10*time.Millisecond), 100*time.Millisecond))
+	defer tick.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-tick.C:
+		}
+		now := time.Now()
+		var resend []*natsMsg
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			return
+		}
+		for id, m := range s.inflight {
+			if now.Before(m.due) {
+				continue
+			}
+			if !m.failed {
+				m.cause = fmt.Errorf("%w: %w: no nats ack within %s", ErrWriteSink, ErrWriteTimeout, s.opts.AckTimeout)
+			}
+			if m.attempts > s.opts.MaxRetries {
+				delete(s.inflight, id)
+				s.stats.Failed++
+				<-s.slots
+				logger.Error("nats publish failed after retries, record dropped", "subject", m.subject, "msg_id", m.id, "attempts", m.attempts, "error", m.cause)
+				continue
+			}
+			// Hold it back from the next tick until it is sent.
+			m.due = now.Add(s.opts.AckTimeout)
+			resend = append(resend, m)
+		}
+		s.mu.Unlock()
+		for _, m := range resend {
+			c, err := s.connection()
+			if err != nil {
+				s.mu.Lock()
+				m.attempts++
+				m.failed, m.cause, m.due = true, err, time.Now().Add(s.backoff(m.attempts))
+				s.mu.Unlock()
+				continue
+			}
+			s.mu.Lock()
+			s.stats.Retried++
+			s.mu.Unlock()
+			s.publish(c, m)
+		}
+	}
+}
+
+// Stats returns the publish counts so far.
+func (s *NATSSink) Stats() NATSStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.stats
+	st.InFlight = len(s.inflight)
+	return st
+}
+
+// Close waits up to CloseTimeout for the outstanding acks, then closes
+// the connection. It reports records given up on during the run and those
+// still unacknowledged.
+func (s *NATSSink) Close() error {
+	deadline := time.Now().Add(s.opts.CloseTimeout)
+	for s.Stats().InFlight > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.mu.Lock()
+	s.closed = true
+	c := s.conn
+	s.mu.Unlock()
+	close(s.stop)
+	if c != nil {
+		s.lost(c, fmt.Errorf("%w: nats sink is closed", ErrWriteSink))
+	}
+	s.wg.Wait()
+
+	st := s.Stats()
+	var errs []error
+	if st.Failed > 0 {
+		errs = append(errs, fmt.Errorf("%w: %d records published to nats were not acknowledged after %d attempts; see the log", ErrWriteSink, st.Failed, s.opts.MaxRetries+1))
+	}
+	if st.InFlight > 0 {
+		errs = append(errs, fmt.Errorf("%w: %d records published to nats were still unacknowledged after %s", ErrWriteSink, st.InFlight, s.opts.CloseTimeout))
+	}
+	return errors.Join(errs...)
+}
+
+// NATSSubject is a subject template: literal text with {service},
+// {namespace}, and {level} placeholders.
+type NATSSubject struct {
+	parts []string // literal text, before each placeholder and after the last
+	keys  []string // placeholder names
+}
+
+// natsSubjectKeys are the placeholders a subject may hold.
+var natsSubjectKeys = []string{"service", "namespace", "level"}
+
+// ParseNATSSubject parses a subject template such as
+// "logs.{namespace}.{service}". Placeholders stand for whole tokens or
+// parts of one; the subject may not hold wildcards or spaces.
+func ParseNATSSubject(tmpl string) (NATSSubject, error) {
+	var t NATSSubject
+	rest := tmpl
+	for {
+		i := strings.IndexByte(rest, '{')
+		if i < 0 {
+			t.parts = append(t.parts, rest)
+			break
+		}
+		j := strings.IndexByte(rest[i:], '}')
+		if j < 0 {
+			return t, fmt.Errorf("nats subject %q: unclosed {", tmpl)
+		}
+		key := rest[i+1 : i+j]
+		if !slices.Contains(natsSubjectKeys, key) {
+			return t, fmt.Errorf("nats subject %q: unknown placeholder {%s}; use {service}, {namespace}, or {level}", tmpl, key)
+		}
+		t.parts = append(t.parts, rest[:i])
+		t.keys = append(t.keys, key)
+		rest = rest[i+j+1:]
+	}
+	sample := strings.Join(t.parts, "x")
+	if sample == "" || strings.ContainsAny(sample, "*>{} \t\r\n") || strings.HasPrefix(sample, ".") || strings.HasSuffix(sample, ".") || strings.Contains(sample, "..") {
+		return t, fmt.Errorf("nats subject %q: must be dot-separated tokens without wildcards or spaces", tmpl)
+	}
+	return t, nil
+}
+
+// Render returns the subject for record. A placeholder's value has dots,
+// wildcards, and whitespace replaced by "_", so it stays within its token;
+// an empty value is "unknown".
+func (t NATSSubject) Render(record any) string {
+	if len(t.keys) == 0 {
+		return t.parts[0]
+	}
+	var b strings.Builder
+	for i, key := range t.keys {
+		b.WriteString(t.parts[i])
+		v := recordString(record, key)
+		if v == "" {
+			v = "unknown"
+		}
+		for _, r := range v {
+			if r == '.' || r == '*' || r == '>' || r <= ' ' || r == 0x7f {
+				r = '_'
+			}
+			b.WriteRune(r)
+		}
+	}
+	b.WriteString(t.parts[len(t.keys)])
+	return b.String()
+}
+
+// recordString returns the string value of the v1 key of record, for the
+// record types sinks are given.
+func recordString(record any, key string) string {
+	var n model.Normalized
+	switch r := record.(type) {
+	case model.Normalized:
+		n = r
+	case model.Legacy:
+		n = model.Normalized(r)
+	case Projection:
+		for i, k := range r.keys {
+			if k == key {
+				s, _ := r.values[i].(string)
+				return s
+			}
+		}
+		return ""
+	default:
+		return ""
+	}
+	switch key {
+	case "service":
+		return n.Service
+	case "namespace":
+		return n.Namespace
+	case "level":
+		return n.Level
+	}
+	return ""
+}
@@ -0,0 +1,277 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s-log-etl/internal/model"
+)
+
+// fakeNATS is an in-process NATS server with a JetStream-like stream
+// behind every subject: it stores each Nats-Msg-Id once and acks it.
+// reply decides what happens to publish number n: "ack", "nack", "drop"
+// (no reply), or "kill" (stored, then the connection is closed unacked).
+type fakeNATS struct {
+	ln    net.Listener
+	reply func(n int) string
+	// pub, when set, is the NKey a creds file must sign the nonce with.
+	pub ed25519.PublicKey
+
+	mu        sync.Mutex
+	conns     int
+	pubs      int
+	ids       map[string]bool
+	bySubject map[string]int
+}
+
+func newFakeNATS(t *testing.T, reply func(n int) string) *fakeNATS {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeNATS{ln: ln, reply: reply, ids: map[string]bool{}, bySubject: map[string]int{}}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeNATS) url() string { return "nats://" + f.ln.Addr().String() }
+
+func (f *fakeNATS) serve(conn net.Conn) {
+	defer conn.Close()
+	f.mu.Lock()
+	f.conns++
+	f.mu.Unlock()
+	fmt.Fprintf(conn, "INFO {\"server_id\":\"fake\",\"headers\":true,\"max_payload\":1048576,\"nonce\":\"n0nce\"}\r\n")
+	br := bufio.NewReader(conn)
+	var wmu sync.Mutex
+	send := func(s string) {
+		wmu.Lock()
+		io.WriteString(conn, s)
+		wmu.Unlock()
+	}
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return
+		}
+		op, args, _ := strings.Cut(strings.TrimSpace(line), " ")
+		switch op {
+		case "CONNECT":
+			var hello struct{ JWT, Sig string }
+			json.Unmarshal([]byte(args), &hello)
+			sig, _ := base64.RawURLEncoding.DecodeString(hello.Sig)
+			if f.pub != nil && (hello.JWT != "test-jwt" || !ed25519.Verify(f.pub, []byte("n0nce"), sig)) {
+				send("-ERR 'Authorization Violation'\r\n")
+				return
+			}
+		case "PING":
+			send("PONG\r\n")
+		case "HPUB":
+			a := strings.Fields(args)
+			hdrLen, _ := strconv.Atoi(a[2])
+			total, _ := strconv.Atoi(a[3])
+			buf := make([]byte, total+2)
+			if _, err := io.ReadFull(br, buf); err != nil {
+				return
+			}
+			_, id, _ := strings.Cut(string(buf[:hdrLen]), "Nats-Msg-Id: ")
+			id, _, _ = strings.Cut(id, "\r\n")
+			f.mu.Lock()
+			f.pubs++
+			action := f.reply(f.pubs)
+			if action == "ack" || action == "kill" {
+				if !f.ids[id] {
+					f.ids[id] = true
+					f.bySubject[a[0]]++
+				}
+			}
+			f.mu.Unlock()
+			body := `{"stream":"LOGS","seq":1}`
+			switch action {
+			case "kill":
+				return
+			case "drop":
+				continue
+			case "nack":
+				body = `{"error":{"code":503,"description":"stream offline"}}`
+			}
+			send(fmt.Sprintf("MSG %s 1 %d\r\n%s\r\n", a[1], len(body), body))
+		}
+	}
+}
+
+func TestNATSSinkSubjectsAndAcks(t *testing.T) {
+	srv := newFakeNATS(t, func(n int) string {
+		switch n {
+		case 5:
+			return "nack"
+		case 9:
+			return "drop"
+		case 20:
+			return "kill"
+		}
+		return "ack"
+	})
+	s, err := NewNATSSink(NATSOptions{
+		Servers: []string{srv.url()}, Subject: "logs.{namespace}.{service}",
+		MaxInFlight: 8, AckTimeout: 200 * time.Millisecond, MaxRetries: 3, BackoffBase: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	services := []string{"api", "web.v2", ""}
+	for i := range 30 {
+		rec := model.Normalized{Message: fmt.Sprint("m", i), Namespace: "prod", Service: services[i%3]}
+		if err := s.Write(rec); err != nil {
+			t.Fatalf("write %d: %v", i, err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	st := s.Stats()
+	if st.Published != 30 || st.Acked != 30 || st.Failed != 0 || st.InFlight != 0 || st.Retried < 3 {
+		t.Errorf("stats = %+v, want 30 published and acked after at least 3 retries", st)
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	want := map[string]int{"logs.prod.api": 10, "logs.prod.web_v2": 10, "logs.prod.unknown": 10}
+	if fmt.Sprint(srv.bySubject) != fmt.Sprint(want) {
+		t.Errorf("stored by subject = %v, want %v", srv.bySubject, want)
+	}
+	if srv.conns < 2 {
+		t.Errorf("%d connections, want a reconnect after the server closed one", srv.conns)
+	}
+}
+
+func TestNATSSinkInFlightWindow(t *testing.T) {
+	srv := newFakeNATS(t, func(int) string { return "drop" })
+	s, err := NewNATSSink(NATSOptions{Servers: []string{srv.url()}, Subject: "logs", MaxInFlight: 2, AckTimeout: time.Minute, CloseTimeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if err := s.Write(model.Normalized{Message: "m"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.WriteContext(ctx, model.Normalized{Message: "m"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("write with a full window = %v, want it to wait out ctx", err)
+	}
+	if err := s.Close(); err == nil || !strings.Contains(err.Error(), "2 records published to nats were still unacknowledged") {
+		t.Errorf("Close = %v, want the two unacked records reported", err)
+	}
+}
+
+func TestNATSSinkGivesUpAfterRetries(t *testing.T) {
+	srv := newFakeNATS(t, func(int) string { return "nack" })
+	s, err := NewNATSSink(NATSOptions{Servers: []string{srv.url()}, Subject: "logs", MaxRetries: 1, BackoffBase: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Write(model.Normalized{Message: "m"}); err != nil {
+		t.Fatal(err)
+	}
+	err = s.Close()
+	if !errors.Is(err, ErrWriteSink) || !strings.Contains(err.Error(), "1 records published to nats were not acknowledged after 2 attempts") {
+		t.Errorf("Close = %v", err)
+	}
+	if st := s.Stats(); st.Failed != 1 || st.Retried != 1 || srv.pubs != 2 {
+		t.Errorf("stats = %+v after %d publishes", st, srv.pubs)
+	}
+}
+
+func TestNATSSinkCredsFile(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An NKey user seed: the seed prefix and user type in two bytes, the
+	// ed25519 seed, and a CRC-16.
+	const seedPrefix, userPrefix = 18 << 3, 20 << 3
+	raw := append([]byte{seedPrefix | userPrefix>>5, (userPrefix & 31) << 3}, priv.Seed()...)
+	raw = binary.LittleEndian.AppendUint16(raw, xmodem(raw))
+	seed := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw)
+	creds := filepath.Join(t.TempDir(), "user.creds")
+	os.WriteFile(creds, []byte("-----BEGIN NATS USER JWT-----\ntest-jwt\n------END NATS USER JWT------\n\n"+
+		"-----BEGIN USER NKEY SEED-----\n"+seed+"\n------END USER NKEY SEED------\n"), 0o600)
+
+	srv := newFakeNATS(t, func(int) string { return "ack" })
+	srv.pub = pub
+	s, err := NewNATSSink(NATSOptions{Servers: []string{srv.url()}, Subject: "logs", CredsFile: creds})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Write(model.Normalized{Message: "m"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, other, _ := ed25519.GenerateKey(nil)
+	srv.pub = other.Public().(ed25519.PublicKey)
+	if _, err := NewNATSSink(NATSOptions{Servers: []string{srv.url()}, Subject: "logs", CredsFile: creds}); !errors.Is(err, ErrRejected) {
+		t.Errorf("connect with the wrong key = %v, want a rejection", err)
+	}
+}
+
+// xmodem is the CRC-16/XMODEM checksum an NKey seed ends with.
+func xmodem(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for range 8 {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func TestParseNATSSubject(t *testing.T) {
+	for _, bad := range []string{"", "logs.>", "logs.*", "logs..x", "logs.{pod}", "logs.{service", "logs .x", ".logs"} {
+		if _, err := ParseNATSSubject(bad); err == nil {
+			t.Errorf("ParseNATSSubject(%q) = nil error", bad)
+		}
+	}
+	subj, err := ParseNATSSubject("logs.{level}.svc-{service}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := subj.Render(model.Legacy{Level: "ERROR", Service: "a b>c"}); got != "logs.ERROR.svc-a_b_c" {
+		t.Errorf("Render = %q", got)
+	}
+}
@@ -0,0 +1,274 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s-log-etl/internal/clock"
+)
+
+// ObjectBackend stores finished objects for an ObjectStoreSink.
+type ObjectBackend interface {
+	// Put stores the object read from r under key, replacing any object
+	// already there.
+	Put(ctx context.Context, key string, r io.Reader) error
+}
+
+// ObjectStoreOptions configures NewObjectStoreSink.
+type ObjectStoreOptions struct {
+	Prefix string // prepended to every key; a trailing "/" is added
+	Ext    string // appended to every key, e.g. ".jsonl"
+	// MaxBytes finalizes an object before a record would take it past this
+	// size; an object always holds at least one record. 0 = no limit.
+	MaxBytes int64
+	// MaxAge finalizes an object once its first record is this old, even
+	// when no more records arrive. 0 = no limit.
+	MaxAge time.Duration
+	// RunID is put in every key, so runs writing under the same prefix
+	// never replace each other's objects. Build takes it from WithRunID.
+	RunID  string
+	Format LineFormat // nil for JSONL
+	Clock  clock.Clock
+}
+
+type runIDKey struct{}
+
+// WithRunID returns ctx carrying the run's id for the sinks Build opens.
+func WithRunID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, runIDKey{}, id)
+}
+
+// runIDFrom returns the run id attached to ctx, or "".
+func runIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(runIDKey{}).(string)
+	return id
+}
+
+// ObjectStoreSink buffers records in memory and stores them as one object
+// per MaxBytes or MaxAge, and a last object on Close. Keys are named after
+// the time of the object's first record, the run id, and a sequence number:
+//
+//	<prefix>/2024/01/01/15/20240101T150405.000Z-<run id>-000001.jsonl
+//
+// When an object cannot be stored, the record that triggered it is not
+// written and the object stays buffered, so the next write or Close stores
+// it again under the same key. An object stored for its age is uploaded
+// without holding up writes; if that fails, it is stored again by the next
+// write or Close, before anything newer.
+type ObjectStoreSink struct {
+	backend ObjectBackend
+	opts    ObjectStoreOptions
+	format  LineFormat
+	clock   clock.Clock
+
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	records int
+	started time.Time // first record of the buffered object
+	seq     int
+	// unstored holds objects the age loop took but could not store, oldest
+	// first.
+	unstored []object
+
+	ticker clock.Ticker
+	cancel context.CancelFunc // ends the age loop and its upload
+	wg     sync.WaitGroup
+}
+
+// object is a finished object taken from the buffer.
+type object struct {
+	key  string
+	data []byte
+}
+
+// NewObjectStoreSink returns a sink storing objects in backend.
+func NewObjectStoreSink(backend ObjectBackend, opts ObjectStoreOptions) *ObjectStoreSink {
+	s := &ObjectStoreSink{
+		backend: backend,
+		opts:    opts,
+		format:  opts.Format,
+		clock:   opts.Clock,
+		seq:     1,
+	}
+	if s.format == nil {
+		s.format = jsonLine
+	}
+	if s.clock == nil {
+		s.clock = clock.Real
+	}
+	if opts.Prefix != "" && !strings.HasSuffix(opts.Prefix, "/") {
+		s.opts.Prefix += "/"
+	}
+	if opts.MaxAge > 0 {
+		interval := min(opts.MaxAge, time.Second)
+		s.ticker = s.clock.NewTicker(interval)
+		ctx, cancel := context.WithCancel(context.Background())
+		s.cancel = cancel
+		s.wg.Add(1)
+		go s.ageLoop(ctx)
+	}
+	return s
+}
+
+func (s *ObjectStoreSink) Write(record any) error {
+	return s.WriteContext(context.Background(), record)
+}
+
+// WriteContext implements ContextWriter; ctx bounds the upload of an object
+// the record finalizes.
+func (s *ObjectStoreSink) WriteContext(ctx context.Context, record any) error {
+	data, err := s.format(record)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.storeUnstored(ctx); err != nil {
+		return err
+	}
+	if s.records > 0 && (s.full(len(data)) || s.expired()) {
+		if err := s.finalize(ctx); err != nil {
+			return err
+		}
+	}
+	if s.records == 0 {
+		s.started = s.clock.Now()
+	}
+	s.buf.Write(data)
+	s.records++
+	return nil
+}
+
+func (s *ObjectStoreSink) full(next int) bool {
+	return s.opts.MaxBytes > 0 && int64(s.buf.Len()+next) > s.opts.MaxBytes
+}
+
+func (s *ObjectStoreSink) expired() bool {
+	return s.opts.MaxAge > 0 && s.clock.Now().Sub(s.started) >= s.opts.MaxAge
+}
+
+// ageLoop stores objects that reach MaxAge while no records arrive. The
+// object is taken from the buffer and uploaded outside the lock, so writes
+// go on meanwhile; one it cannot store, or Close cuts short, is left for
+// the next write or Close to report.
+func (s *ObjectStoreSink) ageLoop(ctx context.Context) {
+	defer s.wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.ticker.C():
+			s.mu.Lock()
+			if s.records == 0 || !s.expired() || len(s.unstored) > 0 {
+				s.mu.Unlock()
+				continue
+			}
+			obj := s.take()
+			s.mu.Unlock()
+			if err := s.put(ctx, obj); err != nil {
+				s.mu.Lock()
+				s.unstored = append(s.unstored, obj)
+				s.mu.Unlock()
+			}
+		}
+	}
+}
+
+// key names the buffered object.
+func (s *ObjectStoreSink) key() string {
+	ts := s.started.UTC()
+	run := ""
+	if s.opts.RunID != "" {
+		run = s.opts.RunID + "-"
+	}
+	return fmt.Sprintf("%s%s/%s-%s%06d%s", s.opts.Prefix, ts.Format("2006/01/02/15"), ts.Format("20060102T150405.000Z"), run, s.seq, s.opts.Ext)
+}
+
+// take removes the buffered object from the buffer. s.mu must be held.
+func (s *ObjectStoreSink) take() object {
+	obj := object{key: s.key(), data: bytes.Clone(s.buf.Bytes())}
+	s.buf.Reset()
+	s.records = 0
+	s.seq++
+	return obj
+}
+
+func (s *ObjectStoreSink) put(ctx context.Context, obj object) error {
+	if err := s.backend.Put(ctx, obj.key, bytes.NewReader(obj.data)); err != nil {
+		return fmt.Errorf("%w: store object %s: %v", ErrWriteSink, obj.key, err)
+	}
+	return nil
+}
+
+// storeUnstored stores the objects the age loop could not, in order. s.mu
+// must be held.
+func (s *ObjectStoreSink) storeUnstored(ctx context.Context) error {
+	for len(s.unstored) > 0 {
+		if err := s.put(ctx, s.unstored[0]); err != nil {
+			return err
+		}
+		s.unstored = s.unstored[1:]
+	}
+	return nil
+}
+
+// finalize stores the buffered object. s.mu must be held.
+func (s *ObjectStoreSink) finalize(ctx context.Context) error {
+	if err := s.put(ctx, object{key: s.key(), data: s.buf.Bytes()}); err != nil {
+		return err
+	}
+	s.buf.Reset()
+	s.records = 0
+	s.seq++
+	return nil
+}
+
+// Close stores the buffered object, if any, after those the age loop could
+// not.
+func (s *ObjectStoreSink) Close() error {
+	if s.ticker != nil {
+		s.ticker.Stop()
+		s.cancel()
+		s.wg.Wait()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.storeUnstored(context.Background()); err != nil {
+		return err
+	}
+	if s.records == 0 {
+		return nil
+	}
+	return s.finalize(context.Background())
+}
+
+// ParseObjectURL splits an object store URL such as gs://bucket/prefix into
+// its scheme, bucket (or container), and key prefix.
+func ParseObjectURL(raw string) (scheme, bucket, prefix string, err error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return "", "", "", fmt.Errorf("%w: invalid object store URL %q: want scheme://bucket/prefix", ErrOpenSink, raw)
+	}
+	return u.Scheme, u.Host, strings.Trim(u.Path, "/"), nil
+}
+
+// NewObjectBackend returns the backend for an object store URL scheme,
+// with credentials from that store's usual environment variables.
+func NewObjectBackend(scheme, bucket string) (ObjectBackend, error) {
+	switch scheme {
+	case "gs":
+		return NewGCSBackend(bucket)
+	case "azblob":
+		return NewAzureBlobBackend(bucket)
+	case "s3":
+		return nil, fmt.Errorf("%w: s3 object store not yet implemented", ErrOpenSink)
+	default:
+		return nil, fmt.Errorf("%w: unsupported object store scheme %q: want gs or azblob", ErrOpenSink, scheme)
+	}
+}
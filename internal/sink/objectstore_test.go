@@ -0,0 +1,219 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s-log-etl/internal/clock"
+)
+
+// memoryBackend keeps stored objects by key and fails Puts while failing is
+// set. With block set, a Put first waits for it to close or its ctx to end,
+// and reports its start on putting.
+type memoryBackend struct {
+	mu      sync.Mutex
+	objects map[string]string
+	puts    []string // keys, in order
+	failing bool
+	stored  chan string
+	block   chan struct{}
+	putting chan struct{}
+}
+
+func newMemoryBackend() *memoryBackend {
+	return &memoryBackend{objects: map[string]string{}, stored: make(chan string, 16)}
+}
+
+func (m *memoryBackend) Put(ctx context.Context, key string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if m.block != nil {
+		m.putting <- struct{}{}
+		select {
+		case <-m.block:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.puts = append(m.puts, key)
+	if m.failing {
+		return errors.New("service unavailable")
+	}
+	m.objects[key] = string(data)
+	m.stored <- key
+	return nil
+}
+
+func (m *memoryBackend) keys() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var keys []string
+	for k := range m.objects {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func TestObjectStoreSink_SplitsBySizeAndNamesByTime(t *testing.T) {
+	backend := newMemoryBackend()
+	clk := clock.NewFake(time.Date(2024, 1, 1, 15, 4, 5, 0, time.UTC))
+	s := NewObjectStoreSink(backend, ObjectStoreOptions{Prefix: "logs/app", Ext: ".jsonl", MaxBytes: 20, Clock: clk})
+
+	// Each record is 9 bytes with its quotes and newline, so two fit in an
+	// object.
+	for _, r := range []string{"aaaaaa", "bbbbbb", "cccccc", "dddddd", "eeeeee"} {
+		if err := s.Write(r); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		clk.Advance(time.Hour)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	want := map[string]string{
+		"logs/app/2024/01/01/15/20240101T150405.000Z-000001.jsonl": "\"aaaaaa\"\n\"bbbbbb\"\n",
+		"logs/app/2024/01/01/17/20240101T170405.000Z-000002.jsonl": "\"cccccc\"\n\"dddddd\"\n",
+		"logs/app/2024/01/01/19/20240101T190405.000Z-000003.jsonl": "\"eeeeee\"\n",
+	}
+	if !reflect.DeepEqual(backend.objects, want) {
+		t.Errorf("objects = %v, want %v", backend.objects, want)
+	}
+}
+
+func TestObjectStoreSink_MaxAgeStoresIdleObject(t *testing.T) {
+	backend := newMemoryBackend()
+	clk := clock.NewFake(time.Unix(0, 0))
+	s := NewObjectStoreSink(backend, ObjectStoreOptions{MaxAge: 10 * time.Second, Clock: clk})
+	defer s.Close()
+
+	if err := s.Write("one"); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(9 * time.Second)
+	select {
+	case key := <-backend.stored:
+		t.Fatalf("stored %s before MaxAge", key)
+	default:
+	}
+	clk.Advance(time.Second)
+	select {
+	case <-backend.stored:
+	case <-time.After(5 * time.Second):
+		t.Fatal("object not stored after MaxAge without further writes")
+	}
+	if got := backend.keys(); len(got) != 1 || !strings.HasPrefix(got[0], "1970/01/01/00/19700101T000000.000Z-000001") {
+		t.Errorf("keys = %v", got)
+	}
+}
+
+func TestObjectStoreSink_MaxAgeUploadDoesNotHoldUpWrites(t *testing.T) {
+	backend := newMemoryBackend()
+	backend.block = make(chan struct{})
+	backend.putting = make(chan struct{}, 4)
+	clk := clock.NewFake(time.Unix(0, 0))
+	s := NewObjectStoreSink(backend, ObjectStoreOptions{MaxAge: 10 * time.Second, RunID: "run1", Clock: clk})
+
+	if err := s.Write("one"); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(10 * time.Second)
+	select {
+	case <-backend.putting:
+	case <-time.After(5 * time.Second):
+		t.Fatal("object not uploaded after MaxAge")
+	}
+	// The upload hangs; writes still go through.
+	done := make(chan error, 1)
+	go func() { done <- s.Write("two") }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Write blocked behind the age loop's upload")
+	}
+	// Close cuts the hung upload short and stores it again, then the rest.
+	go func() {
+		<-backend.putting
+		close(backend.block)
+	}()
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	want := map[string]string{
+		"1970/01/01/00/19700101T000000.000Z-run1-000001": "\"one\"\n",
+		"1970/01/01/00/19700101T000010.000Z-run1-000002": "\"two\"\n",
+	}
+	if !reflect.DeepEqual(backend.objects, want) {
+		t.Errorf("objects = %v, want %v", backend.objects, want)
+	}
+}
+
+func TestObjectStoreSink_FailedPutKeepsObjectBuffered(t *testing.T) {
+	backend := newMemoryBackend()
+	s := NewObjectStoreSink(backend, ObjectStoreOptions{MaxBytes: 10, Clock: clock.NewFake(time.Unix(0, 0))})
+
+	if err := s.Write("first"); err != nil {
+		t.Fatal(err)
+	}
+	backend.failing = true
+	err := s.Write("second")
+	if !errors.Is(err, ErrWriteSink) {
+		t.Fatalf("err = %v, want ErrWriteSink", err)
+	}
+	// The record that hit the failure was not buffered; writing it again
+	// stores the first object under the same key and starts the next.
+	backend.failing = false
+	if err := s.Write("second"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(backend.puts) != 3 || backend.puts[0] != backend.puts[1] {
+		t.Errorf("puts = %v, want the first key twice, then the second", backend.puts)
+	}
+	var all []string
+	for _, k := range backend.keys() {
+		all = append(all, backend.objects[k])
+	}
+	if got := strings.Join(all, ""); got != "\"first\"\n\"second\"\n" {
+		t.Errorf("stored %q", got)
+	}
+}
+
+func TestParseObjectURL(t *testing.T) {
+	tests := []struct {
+		raw, scheme, bucket, prefix string
+		ok                          bool
+	}{
+		{"gs://logs-bucket/k8s/prod/", "gs", "logs-bucket", "k8s/prod", true},
+		{"azblob://container", "azblob", "container", "", true},
+		{"gs:///prefix", "", "", "", false},
+		{"logs/prefix", "", "", "", false},
+		{"gs://bucket/prefix?x=1", "", "", "", false},
+	}
+	for _, tc := range tests {
+		scheme, bucket, prefix, err := ParseObjectURL(tc.raw)
+		if (err == nil) != tc.ok || scheme != tc.scheme || bucket != tc.bucket || prefix != tc.prefix {
+			t.Errorf("ParseObjectURL(%q) = %q, %q, %q, %v", tc.raw, scheme, bucket, prefix, err)
+		}
+	}
+	if _, err := NewObjectBackend("s3", "bucket"); !errors.Is(err, ErrOpenSink) {
+		t.Errorf("s3: err = %v, want ErrOpenSink", err)
+	}
+}
@@ -0,0 +1,263 @@
+package sink
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"k8s-log-etl/internal/fsutil"
+	"k8s-log-etl/internal/model"
+)
+
+// OutputPath is an output path template: literal text with {date} and
+// field placeholders, e.g. out/{namespace}/{service}/{date}.jsonl.
+type OutputPath struct {
+	text       string
+	parts      []string // literal text, before each placeholder and after the last
+	keys       []outputPathKey
+	dateLayout string
+}
+
+// outputPathKey is one placeholder: the record's date, or a field as
+// accepted by NewProjectSink.
+type outputPathKey struct {
+	date  bool
+	field projectedField
+}
+
+// ParseOutputPath parses an output path template. A placeholder is {date},
+// the record's timestamp in UTC formatted with dateLayout, an output name
+// such as {namespace}, or a dotted path into fields such as
+// {fields.team}.
+func ParseOutputPath(text, dateLayout string) (OutputPath, error) {
+	t := OutputPath{text: text, dateLayout: dateLayout}
+	rest := text
+	for {
+		i := strings.IndexByte(rest, '{')
+		if i < 0 {
+			t.parts = append(t.parts, rest)
+			break
+		}
+		j := strings.IndexByte(rest[i:], '}')
+		if j < 0 {
+			return t, fmt.Errorf("%w: output path %q: unclosed {", ErrOpenSink, text)
+		}
+		name := rest[i+1 : i+j]
+		var key outputPathKey
+		switch lower := strings.ToLower(name); {
+		case lower == "date":
+			key.date = true
+		case slices.Contains(model.FieldNames, lower) || strings.HasPrefix(lower, "fields.") && len(name) > len("fields."):
+			key.field = parseProjectedField(name)
+		default:
+			return t, fmt.Errorf("%w: output path %q: unknown placeholder {%s}; use {date}, an output name such as {namespace}, or {fields.name}", ErrOpenSink, text, name)
+		}
+		t.parts = append(t.parts, rest[:i])
+		t.keys = append(t.keys, key)
+		rest = rest[i+j+1:]
+	}
+	if strings.Contains(strings.Join(t.parts, ""), "}") {
+		return t, fmt.Errorf("%w: output path %q: } without {", ErrOpenSink, text)
+	}
+	return t, nil
+}
+
+// Resolve returns the path of record. A field value passes through
+// fsutil.SafeName, so it stays within its path element and cannot climb
+// out of the template's directory; a missing or empty value, or a record
+// without a timestamp, is "unknown". Records other than
+// model.Normalized and model.Legacy have every placeholder unknown.
+func (t OutputPath) Resolve(record any) string {
+	var n model.Normalized
+	known := true
+	switch r := record.(type) {
+	case model.Normalized:
+		n = r
+	case model.Legacy:
+		n = model.Normalized(r)
+	default:
+		known = false
+	}
+	var b strings.Builder
+	for i, key := range t.keys {
+		b.WriteString(t.parts[i])
+		v := ""
+		switch {
+		case !known:
+		case key.date:
+			if ts, ok := n.EventTime(); ok {
+				v = ts.UTC().Format(t.dateLayout)
+			}
+		default:
+			if fv, ok := key.field.value(n); ok {
+				v = fsutil.SafeName(fmt.Sprint(fv))
+			}
+		}
+		if v == "" {
+			v = "unknown"
+		}
+		b.WriteString(v)
+	}
+	b.WriteString(t.parts[len(t.keys)])
+	return b.String()
+}
+
+// PathTemplateStats counts what a PathTemplateSink did.
+type PathTemplateStats struct {
+	Template string
+	MaxOpen  int
+	// Created lists the resolved paths, sorted.
+	Created []string
+	// Opens counts files opened or reopened; Evictions counts files closed
+	// to stay within MaxOpen.
+	Opens     int64
+	Evictions int64
+}
+
+// PathTemplateSink writes each record to the file its resolved path names,
+// creating directories as needed. Each path is a sink of its own that
+// rotates on its own at maxBytes, unless maxBytes is 0. At most maxOpen
+// files are open at once: the least recently written is closed to make
+// room and reopened for append when a record for it comes again.
+type PathTemplateSink struct {
+	path     OutputPath
+	maxBytes int64
+	maxFiles int
+	format   LineFormat
+	perm     fsutil.Perm
+	maxOpen  int
+
+	mu        sync.Mutex
+	sinks     map[string]*pathSink
+	open      *list.List // of *pathSink, most recently written first
+	opens     int64
+	evictions int64
+}
+
+type pathSink struct {
+	path string
+	sink *RotatingJSONLSink
+	elem *list.Element // in open; nil while suspended
+}
+
+// NewPathTemplateSink writes records rendered by format to the paths
+// path resolves to. maxBytes and maxFiles are as for NewRotatingSink.
+func NewPathTemplateSink(path OutputPath, maxBytes int64, maxFiles int, format LineFormat, perm fsutil.Perm, maxOpen int) *PathTemplateSink {
+	if maxOpen <= 0 {
+		maxOpen = 1
+	}
+	return &PathTemplateSink{
+		path:     path,
+		maxBytes: maxBytes,
+		maxFiles: maxFiles,
+		format:   format,
+		perm:     perm,
+		maxOpen:  maxOpen,
+		sinks:    make(map[string]*pathSink),
+		open:     list.New(),
+	}
+}
+
+// Write writes record to the file of its path.
+func (s *PathTemplateSink) Write(record any) error {
+	path := s.path.Resolve(record)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ps, err := s.use(path)
+	if err != nil {
+		return err
+	}
+	return ps.sink.Write(record)
+}
+
+// use returns the sink of path, opened and marked most recently used,
+// closing the least recently used one if that makes too many open.
+func (s *PathTemplateSink) use(path string) (*pathSink, error) {
+	ps, ok := s.sinks[path]
+	if ok && ps.elem != nil {
+		s.open.MoveToFront(ps.elem)
+		return ps, nil
+	}
+	if s.open.Len() >= s.maxOpen {
+		oldest := s.open.Remove(s.open.Back()).(*pathSink)
+		oldest.elem = nil
+		s.evictions++
+		if err := oldest.sink.suspend(); err != nil {
+			return nil, fmt.Errorf("close %s: %w", oldest.path, err)
+		}
+	}
+	if !ok {
+		rs, err := NewRotatingSink(path, s.maxBytes, s.maxFiles, s.format, s.perm)
+		if err != nil {
+			return nil, err
+		}
+		ps = &pathSink{path: path, sink: rs}
+		s.sinks[path] = ps
+	}
+	// A suspended sink reopens its file on its next write.
+	ps.elem = s.open.PushFront(ps)
+	s.opens++
+	return ps, nil
+}
+
+// Files implements FileReporter, listing every file of every path.
+func (s *PathTemplateSink) Files() []FileInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []FileInfo
+	for _, path := range s.created() {
+		out = append(out, s.sinks[path].sink.Files()...)
+	}
+	return out
+}
+
+// created returns the resolved paths, sorted.
+func (s *PathTemplateSink) created() []string {
+	paths := make([]string, 0, len(s.sinks))
+	for path := range s.sinks {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Stats returns the sink's counts so far.
+func (s *PathTemplateSink) Stats() PathTemplateStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return PathTemplateStats{Template: s.path.text, MaxOpen: s.maxOpen, Created: s.created(), Opens: s.opens, Evictions: s.evictions}
+}
+
+// Close flushes and closes the file of every path.
+func (s *PathTemplateSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	for _, path := range s.created() {
+		if err := s.sinks[path].sink.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("close %s: %w", path, err))
+		}
+	}
+	s.open.Init()
+	return errors.Join(errs...)
+}
+
+// PathTemplate returns the stats of the PathTemplateSink in w's chain. The
+// second result is false when there is none.
+func PathTemplate(w Writer) (PathTemplateStats, bool) {
+	for w != nil {
+		if ps, ok := w.(*PathTemplateSink); ok {
+			return ps.Stats(), true
+		}
+		u, ok := w.(Unwrapper)
+		if !ok {
+			break
+		}
+		w = u.Unwrap()
+	}
+	return PathTemplateStats{}, false
+}
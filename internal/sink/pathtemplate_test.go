@@ -0,0 +1,103 @@
+package sink
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s-log-etl/internal/fsutil"
+	"k8s-log-etl/internal/model"
+)
+
+func TestOutputPathResolve(t *testing.T) {
+	path, err := ParseOutputPath("out/{namespace}/{service}/{date}-{fields.team}.jsonl", "2006/01/02")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2024, 3, 9, 23, 30, 0, 0, time.FixedZone("", -2*3600))
+	for _, tc := range []struct {
+		record any
+		want   string
+	}{
+		{model.Normalized{Namespace: "payments", Service: "api", Time: at, Fields: map[string]any{"team": "core"}}, "out/payments/api/2024/03/10-core.jsonl"},
+		{model.Normalized{Namespace: "../../etc", Service: `a\b/c`, TS: "2024-03-09T00:00:00Z"}, "out/____etc/a_b_c/2024/03/09-unknown.jsonl"},
+		{model.Normalized{Namespace: "..", Service: "."}, "out/_/_/unknown-unknown.jsonl"},
+		{model.Legacy{Service: "legacy"}, "out/unknown/legacy/unknown-unknown.jsonl"},
+		{map[string]any{"service": "api"}, "out/unknown/unknown/unknown-unknown.jsonl"},
+	} {
+		if got := path.Resolve(tc.record); got != tc.want {
+			t.Errorf("Resolve(%+v) = %s, want %s", tc.record, got, tc.want)
+		}
+	}
+
+	for _, bad := range []string{"out/{team}.jsonl", "out/{service.jsonl", "out/service}.jsonl", "out/{fields.}.jsonl"} {
+		if _, err := ParseOutputPath(bad, time.DateOnly); err == nil {
+			t.Errorf("ParseOutputPath(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestPathTemplateSinkEvictsAndAppends(t *testing.T) {
+	dir := t.TempDir()
+	path, err := ParseOutputPath(filepath.Join(dir, "{service}", "out.jsonl"), time.DateOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewPathTemplateSink(path, 0, 5, jsonLine, fsutil.Perm{}, 2)
+	for _, svc := range []string{"a", "b", "a", "c", "b", "a", "a"} {
+		if err := s.Write(model.Normalized{Service: svc, Message: svc}); err != nil {
+			t.Fatalf("write %s: %v", svc, err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for svc, want := range map[string]int{"a": 4, "b": 2, "c": 1} {
+		data, err := os.ReadFile(filepath.Join(dir, svc, "out.jsonl"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := bytes.Count(data, []byte("\n")); n != want {
+			t.Errorf("%s has %d records, want %d: a reopened file must be appended to", svc, n, want)
+		}
+	}
+	st := s.Stats()
+	wantCreated := []string{filepath.Join(dir, "a", "out.jsonl"), filepath.Join(dir, "b", "out.jsonl"), filepath.Join(dir, "c", "out.jsonl")}
+	// c evicts a, b evicts c... a is reopened twice and b once.
+	if !slices.Equal(st.Created, wantCreated) || st.Opens != 5 || st.Evictions != 3 || st.MaxOpen != 2 {
+		t.Errorf("stats = %+v", st)
+	}
+	files := s.Files()
+	if len(files) != 3 {
+		t.Fatalf("files = %+v", files)
+	}
+	checkFileInfo(t, files[0], 4)
+}
+
+func TestPathTemplateSinkRotatesPerPath(t *testing.T) {
+	dir := t.TempDir()
+	path, err := ParseOutputPath(filepath.Join(dir, "{level}.log"), time.DateOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewPathTemplateSink(path, 150, 5, jsonLine, fsutil.Perm{}, 8)
+	for i := 0; i < 3; i++ {
+		s.Write(model.Normalized{Level: "ERROR", Message: strings.Repeat("x", 50)})
+	}
+	s.Write(model.Normalized{Level: "INFO", Message: "m"})
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range s.Files() {
+		names = append(names, filepath.Base(f.Path))
+	}
+	if strings.Join(names, ",") != "ERROR.log,ERROR.log.1,ERROR.log.2,INFO.log" {
+		t.Errorf("files %v, want ERROR.log rotated on its own", names)
+	}
+}
@@ -0,0 +1,199 @@
+package sink
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"k8s-log-etl/internal/model"
+)
+
+// PrettyOptions configures a PrettySink.
+type PrettyOptions struct {
+	Color    bool           // color-code the level with ANSI escapes
+	Width    int            // terminal columns; longer messages are truncated (0 = never)
+	Fields   []string       // Fields keys to append as key=value; empty means all, sorted
+	Location *time.Location // zone for timestamps (nil = time.Local)
+}
+
+// PrettySink writes one aligned, human-readable line per record for a
+// terminal:
+//
+//	15:04:05.000 ERROR payments  card declined  http.status=502 user=u1
+type PrettySink struct {
+	w            io.WriteCloser
+	opts         PrettyOptions
+	serviceWidth int // widest service seen so far, for alignment
+	buf          []byte
+}
+
+// maxServiceWidth caps the service column so one long name does not push
+// every later message to the right.
+const maxServiceWidth = 24
+
+// minMessageWidth is the least a message is truncated to, however narrow
+// the terminal.
+const minMessageWidth = 20
+
+// NewPrettySink renders records into w.
+func NewPrettySink(w io.WriteCloser, opts PrettyOptions) *PrettySink {
+	if opts.Location == nil {
+		opts.Location = time.Local
+	}
+	return &PrettySink{w: w, opts: opts}
+}
+
+// Write renders a model.Normalized record. Other record types are an
+// ErrFormat error.
+func (s *PrettySink) Write(record any) error {
+	n, ok := record.(model.Normalized)
+	if !ok {
+		return fmt.Errorf("%w: pretty output needs a normalized record, got %T", ErrFormat, record)
+	}
+	if _, err := s.w.Write(s.format(n)); err != nil {
+		return fmt.Errorf("%w: %v", ErrWriteSink, err)
+	}
+	return nil
+}
+
+func (s *PrettySink) format(n model.Normalized) []byte {
+	ts := n.TS
+	if t, ok := n.EventTime(); ok {
+		ts = t.In(s.opts.Location).Format("15:04:05.000")
+	}
+	level := strings.ToUpper(n.Level)
+	service := n.Service
+	if w := utf8.RuneCountInString(service); w > s.serviceWidth {
+		s.serviceWidth = min(w, maxServiceWidth)
+	}
+	suffix := s.fieldSuffix(n.Fields)
+
+	b := s.buf[:0]
+	b = append(b, ts...)
+	b = append(b, ' ')
+	if code := levelColor(level); s.opts.Color && code != "" {
+		b = append(b, "\x1b["+code+"m"...)
+		b = appendPadded(b, level, 5)
+		b = append(b, "\x1b[0m"...)
+	} else {
+		b = appendPadded(b, level, 5)
+	}
+	b = append(b, ' ')
+	b = appendPadded(b, service, s.serviceWidth)
+	b = append(b, "  "...)
+	// Visible width so far: the color escapes take no columns.
+	used := utf8.RuneCountInString(ts) + 1 + max(utf8.RuneCountInString(level), 5) + 1 + max(utf8.RuneCountInString(service), s.serviceWidth) + 2
+	if suffix != "" {
+		used += 2 + utf8.RuneCountInString(suffix)
+	}
+	msg := strings.NewReplacer("\r\n", `\n`, "\n", `\n`, "\t", " ").Replace(n.Message)
+	if s.opts.Width > 0 {
+		msg = truncate(msg, max(s.opts.Width-used, minMessageWidth))
+	}
+	b = append(b, msg...)
+	if suffix != "" {
+		b = append(b, "  "...)
+		b = append(b, suffix...)
+	}
+	b = append(b, '\n')
+	s.buf = b
+	return b
+}
+
+// fieldSuffix renders the configured Fields as key=value pairs, quoting
+// values that contain spaces, quotes, or '='. Keys the record lacks are
+// skipped.
+func (s *PrettySink) fieldSuffix(fields map[string]any) string {
+	keys := s.opts.Fields
+	if len(keys) == 0 {
+		keys = make([]string, 0, len(fields))
+		for k := range fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+	}
+	var sb strings.Builder
+	for _, k := range keys {
+		v, ok := lookupPath(fields, strings.Split(k, "."))
+		if !ok {
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteByte(' ')
+		}
+		text := fmt.Sprint(v)
+		if strings.ContainsAny(text, " \t\n\"=") || text == "" {
+			text = strconv.Quote(text)
+		}
+		sb.WriteString(k)
+		sb.WriteByte('=')
+		sb.WriteString(text)
+	}
+	return sb.String()
+}
+
+func (s *PrettySink) Close() error {
+	return s.w.Close()
+}
+
+// levelColor is the ANSI SGR code for a level, or "" for unknown levels.
+func levelColor(level string) string {
+	switch level {
+	case "TRACE", "DEBUG":
+		return "90" // gray
+	case "INFO":
+		return "36" // cyan
+	case "WARN", "WARNING":
+		return "33" // yellow
+	case "ERROR":
+		return "31" // red
+	case "FATAL", "PANIC", "CRITICAL":
+		return "1;31" // bold red
+	}
+	return ""
+}
+
+func appendPadded(b []byte, s string, width int) []byte {
+	b = append(b, s...)
+	for i := utf8.RuneCountInString(s); i < width; i++ {
+		b = append(b, ' ')
+	}
+	return b
+}
+
+// truncate shortens s to at most width runes, marking the cut with "…".
+func truncate(s string, width int) string {
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:width-1]) + "…"
+}
+
+// ColorEnabled reports whether f should get ANSI colors: it must be a
+// terminal and NO_COLOR (https://no-color.org) must be unset or empty.
+func ColorEnabled(f *os.File) bool {
+	return os.Getenv("NO_COLOR") == "" && isTerminal(f)
+}
+
+// TerminalWidth is the column count of the terminal f, from COLUMNS when
+// set, or 0 when f is not a terminal or its size is unknown.
+func TerminalWidth(f *os.File) int {
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		return n
+	}
+	if !isTerminal(f) {
+		return 0
+	}
+	return terminalColumns(f)
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,93 @@
+package sink
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s-log-etl/internal/model"
+)
+
+func TestPrettySink(t *testing.T) {
+	est := time.FixedZone("EST", -5*3600)
+	rec := model.Normalized{
+		TS:      "2024-01-02T15:04:05.123Z",
+		Level:   "error",
+		Service: "payments",
+		Message: "card declined\nretrying",
+		Fields:  map[string]any{"user": "u1", "http": map[string]any{"status": float64(502)}, "note": "two words"},
+	}
+	tests := []struct {
+		name string
+		opts PrettyOptions
+		want string
+	}{
+		{"all fields sorted", PrettyOptions{Location: est},
+			"10:04:05.123 ERROR payments  card declined\\nretrying  http=map[status:502] note=\"two words\" user=u1\n"},
+		{"selected fields", PrettyOptions{Location: est, Fields: []string{"http.status", "missing", "user"}},
+			"10:04:05.123 ERROR payments  card declined\\nretrying  http.status=502 user=u1\n"},
+		{"colored level", PrettyOptions{Location: est, Color: true, Fields: []string{"user"}},
+			"10:04:05.123 \x1b[31mERROR\x1b[0m payments  card declined\\nretrying  user=u1\n"},
+		{"truncated to width", PrettyOptions{Location: est, Width: 60, Fields: []string{"user"}},
+			"10:04:05.123 ERROR payments  card declined\\nretryi…  user=u1\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bufferCloser
+			if err := NewPrettySink(&buf, tt.opts).Write(rec); err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("got  %q\nwant %q", got, tt.want)
+			}
+			if tt.opts.Width > 0 {
+				if n := len([]rune(strings.TrimSuffix(buf.String(), "\n"))); n != tt.opts.Width {
+					t.Errorf("line is %d columns, want %d", n, tt.opts.Width)
+				}
+			}
+		})
+	}
+}
+
+func TestPrettySink_AlignsServices(t *testing.T) {
+	var buf bufferCloser
+	s := NewPrettySink(&buf, PrettyOptions{Location: time.UTC})
+	for _, svc := range []string{"payments", "api"} {
+		if err := s.Write(model.Normalized{TS: "2024-01-02T15:04:05Z", Level: "INFO", Service: svc, Message: "m"}); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	want := "15:04:05.000 INFO  payments  m\n15:04:05.000 INFO  api       m\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestPrettySink_RejectsOtherRecords(t *testing.T) {
+	var buf bufferCloser
+	if err := NewPrettySink(&buf, PrettyOptions{}).Write(AggregateRow{}); !errors.Is(err, ErrFormat) {
+		t.Errorf("expected ErrFormat, got %v", err)
+	}
+}
+
+func TestTerminalDetection(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	// A regular file is not a terminal: no colors and no width.
+	if ColorEnabled(f) {
+		t.Error("expected no colors for a regular file")
+	}
+	t.Setenv("COLUMNS", "")
+	if w := TerminalWidth(f); w != 0 {
+		t.Errorf("TerminalWidth = %d, want 0", w)
+	}
+	t.Setenv("COLUMNS", "132")
+	if w := TerminalWidth(f); w != 132 {
+		t.Errorf("TerminalWidth with COLUMNS = %d, want 132", w)
+	}
+}
@@ -0,0 +1,157 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+
+	"k8s-log-etl/internal/model"
+)
+
+// ProjectSink reduces each model.Normalized record to a fixed list of output
+// fields before passing it on. Other record types, such as aggregate rows,
+// pass through unchanged.
+type ProjectSink struct {
+	wrapped Writer
+	fields  []projectedField
+}
+
+// projectedField is one output field: a normalized value when name is one of
+// model.FieldNames, otherwise a path into Fields (nil path: all of Fields).
+type projectedField struct {
+	key  string
+	name string
+	path []string
+}
+
+// NewProjectSink wraps w so it receives only fields of each record. A field
+// is a normalized value by its output name (see model.FieldNames), "fields"
+// for the whole Fields map, or a dotted path into Fields with an optional
+// "fields." prefix, e.g. http.status or fields.http.status. The output keys
+// are the fields as given, in order; fields a record lacks are omitted.
+func NewProjectSink(w Writer, fields []string) *ProjectSink {
+	s := &ProjectSink{wrapped: w}
+	for _, f := range fields {
+		s.fields = append(s.fields, parseProjectedField(f))
+	}
+	return s
+}
+
+func parseProjectedField(f string) projectedField {
+	pf := projectedField{key: f}
+	lower := strings.ToLower(f)
+	switch {
+	case slices.Contains(model.FieldNames, lower):
+		pf.name = lower
+	case lower == "fields":
+	case strings.HasPrefix(lower, "fields."):
+		pf.path = strings.Split(f[len("fields."):], ".")
+	default:
+		pf.path = strings.Split(f, ".")
+	}
+	return pf
+}
+
+// value looks the field up in n.
+func (f projectedField) value(n model.Normalized) (any, bool) {
+	switch {
+	case f.name != "":
+		return n.Field(f.name)
+	case f.path == nil:
+		return n.Fields, len(n.Fields) > 0
+	default:
+		return lookupPath(n.Fields, f.path)
+	}
+}
+
+// Write projects record and writes it to the wrapped sink.
+func (s *ProjectSink) Write(record any) error {
+	return s.wrapped.Write(s.project(record))
+}
+
+// WriteContext implements ContextWriter.
+func (s *ProjectSink) WriteContext(ctx context.Context, record any) error {
+	return WriteContext(ctx, s.wrapped, s.project(record))
+}
+
+func (s *ProjectSink) project(record any) any {
+	n, ok := record.(model.Normalized)
+	if !ok {
+		return record
+	}
+	p := Projection{keys: make([]string, 0, len(s.fields)), values: make([]any, 0, len(s.fields))}
+	for _, f := range s.fields {
+		if v, ok := f.value(n); ok {
+			p.keys = append(p.keys, f.key)
+			p.values = append(p.values, v)
+		}
+	}
+	return p
+}
+
+// lookupPath walks path through nested maps. At each level a key that
+// contains the dots of the remaining path is preferred, so a flat
+// "http.status" key is found as readily as a nested one.
+func lookupPath(m map[string]any, path []string) (any, bool) {
+	for i := range path {
+		if i < len(path)-1 {
+			if v, ok := m[strings.Join(path[i:], ".")]; ok {
+				return v, v != nil
+			}
+		}
+		v, ok := m[path[i]]
+		if !ok || v == nil {
+			return nil, false
+		}
+		if i == len(path)-1 {
+			return v, true
+		}
+		if m, ok = v.(map[string]any); !ok {
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// Unwrap implements Unwrapper.
+func (s *ProjectSink) Unwrap() Writer {
+	return s.wrapped
+}
+
+// Close closes the wrapped sink.
+func (s *ProjectSink) Close() error {
+	return s.wrapped.Close()
+}
+
+// Projection is a record reduced by a ProjectSink. It encodes as a JSON
+// object with its keys in the configured order.
+type Projection struct {
+	keys   []string
+	values []any
+}
+
+// MarshalJSON implements json.Marshaler.
+func (p Projection) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range p.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+		val, err := json.Marshal(p.values[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(val)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,64 @@
+package sink
+
+import (
+	"encoding/json"
+	"testing"
+
+	"k8s-log-etl/internal/model"
+)
+
+func TestProjectSink(t *testing.T) {
+	rec := model.Normalized{
+		TS:      "2024-01-01T12:00:00Z",
+		Level:   "ERROR",
+		Service: "payments",
+		Message: "boom",
+		Pod:     "payments-7d9f8b6c4-xk2lp",
+		Fields: map[string]any{
+			"http":        map[string]any{"status": float64(500), "path": "/pay"},
+			"user.id":     "u1",
+			"region":      "eu-west-1",
+			"unrequested": true,
+		},
+	}
+	tests := []struct {
+		name   string
+		fields []string
+		want   string
+	}{
+		{"normalized values in order", []string{"message", "ts", "level", "service"}, `{"message":"boom","ts":"2024-01-01T12:00:00Z","level":"ERROR","service":"payments"}`},
+		{"nested field path", []string{"level", "fields.http.status"}, `{"level":"ERROR","fields.http.status":500}`},
+		{"bare path without prefix", []string{"http.path", "region"}, `{"http.path":"/pay","region":"eu-west-1"}`},
+		{"flat key containing dots", []string{"fields.user.id"}, `{"fields.user.id":"u1"}`},
+		{"missing fields are omitted", []string{"level", "trace_id", "fields.http.nope", "fields.region.x"}, `{"level":"ERROR"}`},
+		{"case-insensitive names", []string{"Level"}, `{"Level":"ERROR"}`},
+		{"whole fields map", []string{"fields"}, `{"fields":{"http":{"path":"/pay","status":500},"region":"eu-west-1","unrequested":true,"user.id":"u1"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tw := &testWriter{}
+			s := NewProjectSink(tw, tt.fields)
+			if err := s.Write(rec); err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+			got, err := json.Marshal(tw.records[0])
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProjectSink_PassesOtherRecords(t *testing.T) {
+	tw := &testWriter{}
+	row := AggregateRow{Count: 3}
+	if err := NewProjectSink(tw, []string{"level"}).Write(row); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if got, ok := tw.records[0].(AggregateRow); !ok || got.Count != 3 {
+		t.Errorf("expected the row unchanged, got %#v", tw.records[0])
+	}
+}
@@ -0,0 +1,82 @@
+package sink
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"k8s-log-etl/internal/fsutil"
+)
+
+// resumeFile is the file sink's file under output_resume. A rerun of an
+// interrupted run writes the same bytes again, so while the output is
+// still within what the file held when opened, it is compared with the
+// file rather than written; past that it is appended. A rerun over the
+// same input and config thus leaves the file as one uninterrupted run
+// would, without duplicating what the first run wrote.
+type resumeFile struct {
+	f    *os.File
+	path string
+	have int64 // the file's size when opened
+	off  int64 // bytes of output so far, compared or appended
+	buf  []byte
+	err  error // set once the output differs from the file
+}
+
+// openResume opens path for a resumed run, creating it if needed.
+func openResume(path string, perm fsutil.Perm) (*resumeFile, error) {
+	f, err := perm.OpenFile(path, os.O_RDWR|os.O_CREATE)
+	if err != nil {
+		return nil, fileError(ErrOpenSink, err)
+	}
+	have, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		f.Close()
+		return nil, fileError(ErrOpenSink, err)
+	}
+	return &resumeFile{f: f, path: path, have: have}, nil
+}
+
+// Write compares the part of p the file already holds and appends the
+// rest. Once the two differ, this run is not repeating the one that wrote
+// the file, and every write fails with ErrResumeMismatch; the file is left
+// as it was.
+func (r *resumeFile) Write(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n := 0
+	if r.off < r.have {
+		n = int(min(int64(len(p)), r.have-r.off))
+		if cap(r.buf) < n {
+			r.buf = make([]byte, n)
+		}
+		old := r.buf[:n]
+		if _, err := r.f.ReadAt(old, r.off); err != nil {
+			return 0, err
+		}
+		if !bytes.Equal(old, p[:n]) {
+			at := r.off
+			for i := range old {
+				if old[i] != p[i] {
+					at += int64(i)
+					break
+				}
+			}
+			r.err = fmt.Errorf("%w: %w: %w: %s differs from this run's output at byte %d", ErrWriteSink, ErrRejected, ErrResumeMismatch, r.path, at)
+			return 0, r.err
+		}
+		r.off += int64(n)
+		if n == len(p) {
+			return n, nil
+		}
+	}
+	m, err := r.f.Write(p[n:])
+	r.off += int64(m)
+	return n + m, err
+}
+
+func (r *resumeFile) Close() error {
+	return r.f.Close()
+}
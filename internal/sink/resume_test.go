@@ -0,0 +1,93 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s-log-etl/internal/config"
+)
+
+func resumeConfig(path string) config.Config {
+	return config.Config{OutputType: "file", OutputPath: path, OutputResume: true}
+}
+
+func TestResumedFileSinkCompletesInterruptedOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.jsonl")
+	records := []map[string]any{{"i": 1}, {"i": 2}, {"i": 3}}
+	want := "{\"i\":1}\n{\"i\":2}\n{\"i\":3}\n"
+	// The interrupted run got one record and part of the next out.
+	if err := os.WriteFile(path, []byte("{\"i\":1}\n{\"i"), 0o644); err != nil {
+		t.Fatalf("seed output: %v", err)
+	}
+
+	s, err := Build(context.Background(), resumeConfig(path))
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	for _, rec := range records {
+		if err := s.Write(rec); err != nil {
+			t.Fatalf("write %v: %v", rec, err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	if string(data) != want {
+		t.Fatalf("output = %q, want %q", data, want)
+	}
+	files, _ := Files(s)
+	if len(files) != 1 || files[0].Records != 3 || files[0].Bytes != int64(len(want)) {
+		t.Fatalf("files = %+v, want 3 records in %d bytes", files, len(want))
+	}
+}
+
+func TestResumedFileSinkCreatesMissingOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.jsonl")
+	s, err := Build(context.Background(), resumeConfig(path))
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if err := s.Write(map[string]any{"i": 1}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	s.Close()
+	if data, _ := os.ReadFile(path); string(data) != "{\"i\":1}\n" {
+		t.Fatalf("output = %q", data)
+	}
+}
+
+func TestResumedFileSinkRejectsDifferentOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.jsonl")
+	seed := "{\"i\":1}\n{\"i\":9}\n"
+	if err := os.WriteFile(path, []byte(seed), 0o644); err != nil {
+		t.Fatalf("seed output: %v", err)
+	}
+
+	s, err := Build(context.Background(), resumeConfig(path))
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if err := s.Write(map[string]any{"i": 1}); err != nil {
+		t.Fatalf("matching write: %v", err)
+	}
+	err = s.Write(map[string]any{"i": 2})
+	if !errors.Is(err, ErrResumeMismatch) || !errors.Is(err, ErrRejected) {
+		t.Fatalf("expected ErrResumeMismatch and ErrRejected, got %v", err)
+	}
+	// The file is not trusted past a difference, even for output that
+	// would append.
+	if err := s.Write(map[string]any{"i": 3}); !errors.Is(err, ErrResumeMismatch) {
+		t.Fatalf("expected later writes to fail too, got %v", err)
+	}
+	s.Close()
+	if data, _ := os.ReadFile(path); string(data) != seed {
+		t.Fatalf("output = %q, want it left as %q", data, seed)
+	}
+}
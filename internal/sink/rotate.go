@@ -1,29 +1,53 @@
 package sink
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+
+	"k8s-log-etl/internal/fsutil"
 )
 
-// RotatingJSONLSink writes JSONL and rotates files when maxBytes is exceeded.
+// RotatingJSONLSink writes JSONL, or lines in another LineFormat, and
+// rotates files when maxBytes is exceeded.
 type RotatingJSONLSink struct {
 	basePath string
 	maxBytes int64
 	maxFiles int
+	format   LineFormat
 
-	current     *os.File
+	current     *trackedFile
 	currentSize int64
 	index       int
+	files       []*trackedFile // every file still on disk, oldest first
+	// expired lists files past retention that could not be removed yet,
+	// e.g. because a reader on Windows still had them open. They stay in
+	// files and are retried on each rotation and on Close.
+	expired []string
+	remove  func(path string) error
+	perm    fsutil.Perm
+	traces  *traceIndex // nil without output_index
+	// suspended is set while the current file is closed to save a file
+	// descriptor; the next write reopens it for append.
+	suspended bool
 }
 
 func NewRotatingJSONLSink(path string, maxBytes int64, maxFiles int) (*RotatingJSONLSink, error) {
+	return NewRotatingSink(path, maxBytes, maxFiles, jsonLine, fsutil.Perm{})
+}
+
+// NewRotatingSink is NewRotatingJSONLSink with lines rendered by format and
+// each file, rotated ones included, created with perm. A maxBytes of 0
+// never rotates.
+func NewRotatingSink(path string, maxBytes int64, maxFiles int, format LineFormat, perm fsutil.Perm) (*RotatingJSONLSink, error) {
 	s := &RotatingJSONLSink{
 		basePath: path,
 		maxBytes: maxBytes,
 		maxFiles: maxFiles,
+		format:   format,
 		index:    0,
+		remove:   fsutil.Remove,
+		perm:     perm,
 	}
 	if err := s.openNew(); err != nil {
 		return nil, err
@@ -32,13 +56,19 @@ func NewRotatingJSONLSink(path string, maxBytes int64, maxFiles int) (*RotatingJ
 }
 
 func (s *RotatingJSONLSink) Write(record any) error {
-	data, err := json.Marshal(record)
+	data, err := s.format(record)
 	if err != nil {
-		return fmt.Errorf("%w: %v", ErrWriteSink, err)
+		return err
 	}
-	data = append(data, '\n')
 
-	if s.currentSize+int64(len(data)) > s.maxBytes {
+	if s.suspended {
+		f, err := s.perm.OpenFile(s.current.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE)
+		if err != nil {
+			return fileError(ErrOpenSink, err)
+		}
+		s.current.w, s.suspended = f, false
+	}
+	if s.maxBytes > 0 && s.currentSize+int64(len(data)) > s.maxBytes {
 		if err := s.rotate(); err != nil {
 			return err
 		}
@@ -46,48 +76,107 @@ func (s *RotatingJSONLSink) Write(record any) error {
 
 	n, err := s.current.Write(data)
 	if err != nil {
-		return fmt.Errorf("%w: %v", ErrWriteSink, err)
+		return fileError(ErrWriteSink, err)
+	}
+	if s.traces != nil {
+		s.traces.add(record, s.current.path, s.currentSize, int64(n))
 	}
 	s.currentSize += int64(n)
+	s.current.records++
 	return nil
 }
 
+// Files implements FileReporter, listing the base file and every rotated
+// file that retention has not removed.
+func (s *RotatingJSONLSink) Files() []FileInfo {
+	out := make([]FileInfo, 0, len(s.files))
+	for _, f := range s.files {
+		out = append(out, f.info())
+	}
+	return out
+}
+
+// suspend closes the current file, keeping its place, so a sink holding
+// many of these can bound its open files.
+func (s *RotatingJSONLSink) suspend() error {
+	if s.current == nil || s.suspended {
+		return nil
+	}
+	s.suspended = true
+	return closeError(s.current.Close())
+}
+
 func (s *RotatingJSONLSink) Close() error {
-	if s.current != nil {
-		return s.current.Close()
+	var err error
+	if s.current != nil && !s.suspended {
+		err = s.current.Close()
 	}
-	return nil
+	s.removeExpired()
+	closeTraceIndex(s.traces)
+	return closeError(err)
 }
 
 func (s *RotatingJSONLSink) rotate() error {
 	if err := s.current.Close(); err != nil {
-		return fmt.Errorf("%w: %v", ErrRotateSink, err)
+		return fileError(ErrRotateSink, err)
 	}
 	s.index++
 	if s.maxFiles > 0 && s.index > s.maxFiles {
-		oldIdx := s.index - s.maxFiles
-		os.Remove(s.rotatedPath(oldIdx))
+		s.expired = append(s.expired, s.rotatedPath(s.index-s.maxFiles))
 	}
+	s.removeExpired()
 	return s.openNew()
 }
 
+// removeExpired deletes the files past retention. The current file is
+// never among them, so none is open here; a file another process holds
+// open stays listed and is tried again later.
+func (s *RotatingJSONLSink) removeExpired() {
+	kept := s.expired[:0]
+	for _, path := range s.expired {
+		if err := s.remove(path); err != nil {
+			kept = append(kept, path)
+			continue
+		}
+		if s.traces != nil {
+			s.traces.remove(path)
+		}
+		for i, f := range s.files {
+			if f.path == path {
+				s.files = append(s.files[:i], s.files[i+1:]...)
+				break
+			}
+		}
+	}
+	s.expired = kept
+}
+
 func (s *RotatingJSONLSink) openNew() error {
 	target := s.basePath
 	if s.index > 0 {
 		target = s.rotatedPath(s.index)
 	}
-	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+	if err := s.perm.MkdirAll(filepath.Dir(target)); err != nil {
 		return fmt.Errorf("%w: %v", ErrOpenSink, err)
 	}
-	f, err := os.Create(target)
+	f, err := s.perm.Create(target)
 	if err != nil {
-		return fmt.Errorf("%w: %v", ErrOpenSink, err)
+		return fileError(ErrOpenSink, err)
 	}
-	s.current = f
+	s.current = newTrackedFile(f, target)
+	s.files = append(s.files, s.current)
 	s.currentSize = 0
 	return nil
 }
 
+// rotatedPath appends the rotation number to the whole base path, so
+// out.jsonl rotates to out.jsonl.1. Only the last path element changes,
+// whatever the platform's separator.
 func (s *RotatingJSONLSink) rotatedPath(idx int) string {
 	return fmt.Sprintf("%s.%d", s.basePath, idx)
 }
+
+// jsonLine is the JSONL LineFormat.
+func jsonLine(record any) ([]byte, error) {
+	return DefaultJSONEncoding.Line(record)
+}
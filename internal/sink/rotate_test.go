@@ -7,6 +7,36 @@ import (
 	"testing"
 )
 
+func TestRotatingSinkFilesTracksRetention(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "out.log")
+
+	sink, err := NewRotatingJSONLSink(base, 20, 2)
+	if err != nil {
+		t.Fatalf("init sink: %v", err)
+	}
+	// Each record is 16 bytes, so every write after the first rotates.
+	for i := 0; i < 4; i++ {
+		if err := sink.Write(map[string]any{"record": i}); err != nil {
+			t.Fatalf("write %d: %v", i, err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	files := sink.Files()
+	var onDisk []string
+	for _, f := range files {
+		onDisk = append(onDisk, filepath.Base(f.Path))
+		checkFileInfo(t, f, 1)
+	}
+	// Retention removed out.log.1; the manifest must not list it.
+	if strings.Join(onDisk, ",") != "out.log,out.log.2,out.log.3" {
+		t.Fatalf("unexpected files %v", onDisk)
+	}
+}
+
 func TestRotatingSinkRotatesAndKeepsMaxFiles(t *testing.T) {
 	dir := t.TempDir()
 	base := filepath.Join(dir, "out.log")
@@ -37,3 +67,69 @@ func TestRotatingSinkRotatesAndKeepsMaxFiles(t *testing.T) {
 		}
 	}
 }
+
+func TestRotatedPath(t *testing.T) {
+	tests := []struct {
+		base string
+		idx  int
+		want string
+	}{
+		{"out.jsonl", 1, "out.jsonl.1"},
+		{"/var/log/etl/out.jsonl", 12, "/var/log/etl/out.jsonl.12"},
+		{`C:\logs\etl\out.jsonl`, 3, `C:\logs\etl\out.jsonl.3`},
+		{`\\share\logs\out`, 2, `\\share\logs\out.2`},
+		{"logs.d/out", 1, "logs.d/out.1"},
+	}
+	for _, tt := range tests {
+		s := &RotatingJSONLSink{basePath: tt.base}
+		if got := s.rotatedPath(tt.idx); got != tt.want {
+			t.Errorf("rotatedPath(%q, %d) = %q, want %q", tt.base, tt.idx, got, tt.want)
+		}
+	}
+}
+
+func TestRotatingSinkRetriesFilesItCannotRemove(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "out.log")
+	sink, err := NewRotatingJSONLSink(base, 20, 1)
+	if err != nil {
+		t.Fatalf("init sink: %v", err)
+	}
+	// A reader holds out.log.1 open, as a tailing agent would on Windows.
+	held := base + ".1"
+	realRemove := sink.remove
+	sink.remove = func(path string) error {
+		if path == held {
+			return &os.PathError{Op: "remove", Path: path, Err: os.ErrPermission}
+		}
+		return realRemove(path)
+	}
+	for i := 0; i < 3; i++ {
+		if err := sink.Write(map[string]any{"record": i}); err != nil {
+			t.Fatalf("write %d: %v", i, err)
+		}
+	}
+	names := func() string {
+		var out []string
+		for _, f := range sink.Files() {
+			out = append(out, filepath.Base(f.Path))
+		}
+		return strings.Join(out, ",")
+	}
+	// out.log.1 is past retention but still on disk, so it stays listed.
+	if got := names(); got != "out.log,out.log.1,out.log.2" {
+		t.Fatalf("files = %s", got)
+	}
+
+	// Once the reader lets go, Close removes it.
+	sink.remove = realRemove
+	if err := sink.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if got := names(); got != "out.log,out.log.2" {
+		t.Errorf("files after close = %s", got)
+	}
+	if _, err := os.Stat(held); !os.IsNotExist(err) {
+		t.Errorf("%s should be removed, stat err = %v", held, err)
+	}
+}
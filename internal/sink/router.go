@@ -0,0 +1,254 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/model"
+)
+
+// Route is a named destination of a RouterSink.
+type Route struct {
+	Name string
+	// Output describes the destination for the report, e.g. http:https://...
+	Output string
+	Sink   Writer
+}
+
+// RouteStats counts what a RouterSink sent to one route.
+type RouteStats struct {
+	Name    string
+	Output  string
+	Records int64 // taken by the route's sink
+	Failed  int64 // failed write attempts
+}
+
+// RouterStats counts what a RouterSink did with its records. Routes keep
+// the order they were configured in.
+type RouterStats struct {
+	Routes     []RouteStats
+	Default    string
+	Unroutable int64
+}
+
+// RouterSink sends each record to the route of the first rule it matches,
+// or to the default route when it matches none. Without a default route
+// such records fail with ErrUnroutable. Rules match model.Normalized
+// records on their fields and AggregateRow records on their group; any
+// other record goes to the default route.
+type RouterSink struct {
+	routes     []*routeDest
+	rules      []routerRule
+	fallback   *routeDest
+	unroutable atomic.Int64
+}
+
+type routeDest struct {
+	Route
+	records atomic.Int64
+	failed  atomic.Int64
+}
+
+type routerRule struct {
+	conditions []routerCondition
+	dest       *routeDest
+}
+
+type routerCondition struct {
+	field  projectedField
+	values []string
+	level  bool // compared case-insensitively
+}
+
+// NewRouterSink routes to routes by rules, first match wins. defaultRoute
+// names the route for records no rule matches; empty means none. It takes
+// ownership of the routes' sinks, closing them on Close. When it returns
+// an error the sinks are left open for the caller, which opened them, to
+// close.
+func NewRouterSink(routes []Route, rules []config.RouterRule, defaultRoute string) (*RouterSink, error) {
+	r := &RouterSink{}
+	byName := make(map[string]*routeDest, len(routes))
+	for _, route := range routes {
+		d := &routeDest{Route: route}
+		r.routes = append(r.routes, d)
+		byName[route.Name] = d
+	}
+	for _, rule := range rules {
+		d, ok := byName[rule.Route]
+		if !ok {
+			return nil, fmt.Errorf("%w: router rule names unknown route %q", ErrOpenSink, rule.Route)
+		}
+		compiled := routerRule{dest: d}
+		for _, c := range rule.Conditions {
+			f := parseProjectedField(c.Field)
+			compiled.conditions = append(compiled.conditions, routerCondition{field: f, values: c.Values, level: f.name == "level"})
+		}
+		r.rules = append(r.rules, compiled)
+	}
+	if defaultRoute != "" {
+		d, ok := byName[defaultRoute]
+		if !ok {
+			return nil, fmt.Errorf("%w: router default names unknown route %q", ErrOpenSink, defaultRoute)
+		}
+		r.fallback = d
+	}
+	return r, nil
+}
+
+// route picks the destination of record, or nil when there is none.
+func (r *RouterSink) route(record any) *routeDest {
+	var lookup func(routerCondition) (any, bool)
+	switch rec := record.(type) {
+	case model.Normalized:
+		lookup = func(c routerCondition) (any, bool) { return c.field.value(rec) }
+	case AggregateRow:
+		lookup = func(c routerCondition) (any, bool) {
+			v, ok := rec.Group[c.field.key]
+			return v, ok
+		}
+	default:
+		return r.fallback
+	}
+	for _, rule := range r.rules {
+		if rule.matches(lookup) {
+			return rule.dest
+		}
+	}
+	return r.fallback
+}
+
+func (rule routerRule) matches(lookup func(routerCondition) (any, bool)) bool {
+	for _, c := range rule.conditions {
+		v, ok := lookup(c)
+		if !ok || !c.matches(v) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c routerCondition) matches(v any) bool {
+	s, ok := v.(string)
+	if !ok {
+		s = fmt.Sprint(v)
+	}
+	for _, want := range c.values {
+		if s == want || c.level && strings.EqualFold(s, want) {
+			return true
+		}
+	}
+	return false
+}
+
+// Write sends record to its route.
+func (r *RouterSink) Write(record any) error {
+	return r.WriteContext(context.Background(), record)
+}
+
+// WriteContext implements ContextWriter.
+func (r *RouterSink) WriteContext(ctx context.Context, record any) error {
+	d := r.route(record)
+	if d == nil {
+		r.unroutable.Add(1)
+		return fmt.Errorf("%w: %w", ErrUnroutable, ErrRejected)
+	}
+	return d.count(WriteContext(ctx, d.Sink, record))
+}
+
+// RouteError is a write a route's sink failed.
+type RouteError struct {
+	Route string
+	Err   error
+}
+
+func (e *RouteError) Error() string {
+	return fmt.Sprintf("route %s: %v", e.Route, e.Err)
+}
+
+func (e *RouteError) Unwrap() error {
+	return e.Err
+}
+
+// count records the outcome of a write to d and returns err as a
+// *RouteError.
+func (d *routeDest) count(err error) error {
+	if err != nil {
+		d.failed.Add(1)
+		return &RouteError{Route: d.Name, Err: err}
+	}
+	d.records.Add(1)
+	return nil
+}
+
+// RouteOf returns the name of the route record would be written to. The
+// second result is false when it is unroutable.
+func (r *RouterSink) RouteOf(record any) (string, bool) {
+	if d := r.route(record); d != nil {
+		return d.Name, true
+	}
+	return "", false
+}
+
+// Stats returns the router's counts so far.
+func (r *RouterSink) Stats() RouterStats {
+	s := RouterStats{Unroutable: r.unroutable.Load(), Routes: make([]RouteStats, 0, len(r.routes))}
+	if r.fallback != nil {
+		s.Default = r.fallback.Name
+	}
+	for _, d := range r.routes {
+		s.Routes = append(s.Routes, RouteStats{Name: d.Name, Output: d.Output, Records: d.records.Load(), Failed: d.failed.Load()})
+	}
+	return s
+}
+
+// Close closes every route's sink.
+func (r *RouterSink) Close() error {
+	var errs []error
+	for _, d := range r.routes {
+		if err := d.Sink.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("route %s: %w", d.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Flush writes out what every route's sink buffers. It implements
+// Flusher.
+func (r *RouterSink) Flush(ctx context.Context) error {
+	var errs []error
+	for _, d := range r.routes {
+		if err := Flush(ctx, d.Sink); err != nil {
+			errs = append(errs, fmt.Errorf("route %s: %w", d.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Routing returns the stats of the RouterSink in w's chain. The second
+// result is false when there is none.
+func Routing(w Writer) (RouterStats, bool) {
+	if rs, ok := Router(w); ok {
+		return rs.Stats(), true
+	}
+	return RouterStats{}, false
+}
+
+// Router returns the RouterSink in w's chain. The second result is false
+// when there is none.
+func Router(w Writer) (*RouterSink, bool) {
+	for w != nil {
+		if rs, ok := w.(*RouterSink); ok {
+			return rs, true
+		}
+		u, ok := w.(Unwrapper)
+		if !ok {
+			break
+		}
+		w = u.Unwrap()
+	}
+	return nil, false
+}
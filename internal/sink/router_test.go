@@ -0,0 +1,106 @@
+package sink
+
+import (
+	"errors"
+	"testing"
+
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/model"
+)
+
+// closeCountingWriter is a testWriter that counts its Close calls.
+type closeCountingWriter struct {
+	testWriter
+	closed int
+}
+
+func (w *closeCountingWriter) Close() error {
+	w.closed++
+	return nil
+}
+
+func routerRules(t *testing.T, entries ...string) []config.RouterRule {
+	t.Helper()
+	var rules []config.RouterRule
+	for _, e := range entries {
+		rule, err := config.ParseRouterRule(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+func TestRouterSink_FirstMatchWins(t *testing.T) {
+	payments, errs, other := &closeCountingWriter{}, &closeCountingWriter{}, &closeCountingWriter{}
+	r, err := NewRouterSink([]Route{
+		{Name: "payments", Sink: payments},
+		{Name: "errors", Sink: errs},
+		{Name: "other", Sink: other},
+	}, routerRules(t,
+		"namespace=payments -> payments",
+		"level=error|fatal -> errors",
+		"fields.team=search -> other",
+	), "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := []model.Normalized{
+		{Namespace: "payments", Level: "ERROR"}, // both of the first rules match
+		{Namespace: "search", Level: "ERROR"},
+		{Namespace: "search", Level: "WARN", Fields: map[string]any{"team": "search"}},
+		{Namespace: "checkout", Level: "INFO"}, // the default route
+	}
+	for _, rec := range records {
+		if err := r.Write(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if payments.count() != 1 || errs.count() != 1 || other.count() != 2 {
+		t.Errorf("routed %d, %d, %d; want 1, 1, 2", payments.count(), errs.count(), other.count())
+	}
+	stats := r.Stats()
+	if stats.Default != "other" || stats.Routes[0].Records != 1 || stats.Routes[2].Records != 2 || stats.Unroutable != 0 {
+		t.Errorf("stats %+v", stats)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if payments.closed != 1 || errs.closed != 1 || other.closed != 1 {
+		t.Errorf("closed %d, %d, %d; want every route once", payments.closed, errs.closed, other.closed)
+	}
+}
+
+func TestRouterSink_UnroutableWithoutDefault(t *testing.T) {
+	w := &testWriter{}
+	r, err := NewRouterSink([]Route{{Name: "payments", Sink: w}}, routerRules(t, "namespace=payments -> payments"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Write(model.Normalized{Namespace: "search"})
+	if !errors.Is(err, ErrUnroutable) || !errors.Is(err, ErrRejected) {
+		t.Errorf("err %v, want unroutable and rejected", err)
+	}
+	// Records that are not normalized, and aggregate rows by their group.
+	if err := r.Write(AggregateRow{Group: map[string]string{"namespace": "payments"}}); err != nil {
+		t.Errorf("aggregate row: %v", err)
+	}
+	if err := r.Write("not a record"); !errors.Is(err, ErrUnroutable) {
+		t.Errorf("err %v, want unroutable", err)
+	}
+	if s := r.Stats(); s.Unroutable != 2 || s.Routes[0].Records != 1 {
+		t.Errorf("stats %+v", s)
+	}
+}
+
+func TestNewRouterSink_UnknownRouteLeavesRoutesToCaller(t *testing.T) {
+	w := &closeCountingWriter{}
+	_, err := NewRouterSink([]Route{{Name: "a", Sink: w}}, routerRules(t, "level=ERROR -> b"), "")
+	if !errors.Is(err, ErrOpenSink) {
+		t.Fatalf("err %v, want ErrOpenSink", err)
+	}
+	if w.closed != 0 {
+		t.Errorf("route closed %d times, want it left to the caller", w.closed)
+	}
+}
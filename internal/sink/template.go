@@ -0,0 +1,121 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"text/template"
+
+	"k8s-log-etl/internal/model"
+)
+
+// ParseTemplate parses a per-record output template. The template is
+// executed with a model.Normalized record, e.g.
+//
+//	{{.TS}} {{.Level}} {{.Service}} pod={{.Pod}} msg={{quote .Message}}
+//
+// and may call these helpers besides the text/template builtins:
+//
+//	quote v         v formatted and double-quoted with Go escapes
+//	field . "name"  a value by output name or dotted path into Fields, as
+//	                accepted by NewProjectSink; "" when the record lacks it
+//	json v          v encoded as JSON
+func ParseTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("output").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("%w: parse output template: %v", ErrOpenSink, err)
+	}
+	return tmpl, nil
+}
+
+var templateFuncs = template.FuncMap{
+	"quote": func(v any) string {
+		return strconv.Quote(fmt.Sprint(v))
+	},
+	"field": func(n model.Normalized, name string) any {
+		if v, ok := parseProjectedField(name).value(n); ok {
+			return v
+		}
+		return ""
+	},
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// LineFormat renders one record as a line of output, newline included, or
+// for binary formats as its encoded bytes.
+type LineFormat func(record any) ([]byte, error)
+
+// TemplateFormat renders records with tmpl, adding a newline unless the
+// template ends with one. Execution errors wrap ErrFormat.
+func TemplateFormat(tmpl *template.Template) LineFormat {
+	return func(record any) ([]byte, error) {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, record); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrFormat, err)
+		}
+		if b := buf.Bytes(); len(b) == 0 || b[len(b)-1] != '\n' {
+			buf.WriteByte('\n')
+		}
+		return buf.Bytes(), nil
+	}
+}
+
+// TemplateSink writes each record as a line of text rendered from a
+// template, or as the bytes of another LineFormat such as AvroFormat.
+type TemplateSink struct {
+	w      io.WriteCloser
+	format LineFormat
+	file   *trackedFile // nil unless writing a local file
+	index  *traceIndex  // nil without output_index
+}
+
+// NewTemplateSink renders records with tmpl into w.
+func NewTemplateSink(w io.WriteCloser, tmpl *template.Template) *TemplateSink {
+	return &TemplateSink{w: w, format: TemplateFormat(tmpl)}
+}
+
+// newFileTemplateSink writes records rendered by format to a local file,
+// tracking what it writes for Files. path is the name the file is
+// published under.
+func newFileTemplateSink(w io.WriteCloser, path string, format LineFormat) *TemplateSink {
+	tf := newTrackedFile(w, path)
+	return &TemplateSink{w: tf, format: format, file: tf}
+}
+
+// Write renders record and writes the line. A record the template cannot
+// render is an ErrFormat error and nothing is written for it.
+func (s *TemplateSink) Write(record any) error {
+	line, err := s.format(record)
+	if err != nil {
+		return err
+	}
+	if _, err := s.w.Write(line); err != nil {
+		return fileError(ErrWriteSink, err)
+	}
+	if s.file != nil {
+		s.file.records++
+		if s.index != nil {
+			s.index.add(record, s.file.path, s.file.bytes-int64(len(line)), int64(len(line)))
+		}
+	}
+	return nil
+}
+
+// Files implements FileReporter. It is empty unless the sink writes a file.
+func (s *TemplateSink) Files() []FileInfo {
+	if s.file == nil {
+		return nil
+	}
+	return []FileInfo{s.file.info()}
+}
+
+func (s *TemplateSink) Close() error {
+	err := closeError(s.w.Close())
+	closeTraceIndex(s.index)
+	return err
+}
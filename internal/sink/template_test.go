@@ -0,0 +1,98 @@
+package sink
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s-log-etl/internal/fsutil"
+	"k8s-log-etl/internal/model"
+)
+
+type bufferCloser struct {
+	bytes.Buffer
+}
+
+func (b *bufferCloser) Close() error { return nil }
+
+func TestTemplateSink(t *testing.T) {
+	rec := model.Normalized{
+		TS:      "2024-01-02T15:04:05Z",
+		Level:   "ERROR",
+		Service: "payments",
+		Pod:     "xyz",
+		Message: `boom "here"`,
+		Fields:  map[string]any{"http": map[string]any{"status": float64(502)}, "tags": []any{"a"}},
+	}
+	tests := []struct {
+		name, text, want string
+	}{
+		{"classic line", `{{.TS}} {{.Level}} {{.Service}} pod={{.Pod}} msg={{quote .Message}}`, "2024-01-02T15:04:05Z ERROR payments pod=xyz msg=\"boom \\\"here\\\"\"\n"},
+		{"field paths", `{{field . "level"}} status={{field . "fields.http.status"}} missing={{quote (field . "nope")}}`, "ERROR status=502 missing=\"\"\n"},
+		{"json helper", `{{json .Fields.tags}}`, "[\"a\"]\n"},
+		{"trailing newline kept", "{{.Level}}\n", "ERROR\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := ParseTemplate(tt.text)
+			if err != nil {
+				t.Fatalf("ParseTemplate: %v", err)
+			}
+			var buf bufferCloser
+			if err := NewTemplateSink(&buf, tmpl).Write(rec); err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTemplateSink_Errors(t *testing.T) {
+	if _, err := ParseTemplate("{{.TS"); !errors.Is(err, ErrOpenSink) {
+		t.Errorf("expected ErrOpenSink for a bad template, got %v", err)
+	}
+
+	tmpl, err := ParseTemplate(`{{index .Fields.tags 3}}`)
+	if err != nil {
+		t.Fatalf("ParseTemplate: %v", err)
+	}
+	var buf bufferCloser
+	err = NewTemplateSink(&buf, tmpl).Write(model.Normalized{Fields: map[string]any{"tags": []any{"a"}}})
+	if !errors.Is(err, ErrFormat) {
+		t.Errorf("expected ErrFormat, got %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("expected nothing written for a failed record, got %q", buf.String())
+	}
+}
+
+func TestRotatingSink_Template(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "out.log")
+	tmpl, err := ParseTemplate(`{{.Level}} {{.Message}}`)
+	if err != nil {
+		t.Fatalf("ParseTemplate: %v", err)
+	}
+	// Each line is 12 bytes, so the second write rotates.
+	s, err := NewRotatingSink(base, 20, 2, TemplateFormat(tmpl), fsutil.Perm{})
+	if err != nil {
+		t.Fatalf("init sink: %v", err)
+	}
+	for _, msg := range []string{"first", "secnd"} {
+		if err := s.Write(model.Normalized{Level: "ERROR", Message: msg}); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	for path, want := range map[string]string{base: "ERROR first\n", base + ".1": "ERROR secnd\n"} {
+		got, err := os.ReadFile(path)
+		if err != nil || string(got) != want {
+			t.Errorf("%s = %q (err %v), want %q", filepath.Base(path), got, err, want)
+		}
+	}
+}
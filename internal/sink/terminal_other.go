@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package sink
+
+import "os"
+
+// terminalColumns is unknown on this platform; set COLUMNS instead.
+func terminalColumns(*os.File) int {
+	return 0
+}
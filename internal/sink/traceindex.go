@@ -0,0 +1,482 @@
+package sink
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"k8s-log-etl/internal/fsutil"
+	"k8s-log-etl/internal/logger"
+	"k8s-log-etl/internal/model"
+)
+
+// The trace index of an output is a text file: a header line, then
+// tab-separated lines of two kinds. "\t<n>\t<path>" names output file n,
+// its path relative to the index's directory, and
+// "<trace_id>\t<n>\t<offset>\t<length>" places a record in file n. File
+// lines start with a tab, so in a sorted index they all come before the
+// entries, which are ordered by trace ID.
+const (
+	traceIndexMagic  = "etl-trace-index v1"
+	traceIndexLog    = traceIndexMagic + " log"
+	traceIndexSorted = traceIndexMagic + " sorted"
+	// maxIndexedTraceID is the longest trace ID indexed; longer ones are
+	// not trace IDs worth a lookup.
+	maxIndexedTraceID = 256
+)
+
+// TraceIndexPath is the path of the trace index kept for the output file
+// at path with output_index trace_id.
+func TraceIndexPath(path string) string {
+	return path + ".idx"
+}
+
+// TraceLocation is where a record of a trace was written.
+type TraceLocation struct {
+	Path   string
+	Offset int64
+	Length int64
+}
+
+// traceIndex records the file, byte offset, and length of each record with
+// a trace ID that a file or rotating sink writes. While the run goes on
+// the entries are appended to the index as a log, which a lookup scans
+// whole and which survives a crash up to the last buffered entries. Close
+// sorts them by trace ID, holding them all in memory once, so a lookup
+// can binary-search the file.
+type traceIndex struct {
+	path    string
+	dir     string
+	perm    fsutil.Perm
+	f       *os.File
+	w       *bufio.Writer
+	files   map[string]int
+	removed map[int]bool // files retention deleted
+}
+
+// openTraceIndex creates the index at path, replacing any earlier one.
+func openTraceIndex(path string, perm fsutil.Perm) (*traceIndex, error) {
+	if err := perm.MkdirAll(filepath.Dir(path)); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOpenSink, err)
+	}
+	f, err := perm.Create(path)
+	if err != nil {
+		return nil, fileError(ErrOpenSink, err)
+	}
+	x := &traceIndex{
+		path:    path,
+		dir:     filepath.Dir(path),
+		perm:    perm,
+		f:       f,
+		w:       bufio.NewWriter(f),
+		files:   make(map[string]int),
+		removed: make(map[int]bool),
+	}
+	x.w.WriteString(traceIndexLog + "\n")
+	return x, nil
+}
+
+// add indexes record, written to file at offset, if it has a trace ID. A
+// failed index write surfaces in Close; the records are written anyway.
+func (x *traceIndex) add(record any, file string, offset, length int64) {
+	id := recordTraceID(record)
+	if !indexableTraceID(id) {
+		return
+	}
+	n, ok := x.files[file]
+	if !ok {
+		n = len(x.files)
+		x.files[file] = n
+		rel, err := filepath.Rel(x.dir, file)
+		if err != nil {
+			// One of the paths is absolute and the other not.
+			if rel, err = filepath.Abs(file); err != nil {
+				rel = file
+			}
+		}
+		fmt.Fprintf(x.w, "\t%d\t%s\n", n, filepath.ToSlash(rel))
+	}
+	fmt.Fprintf(x.w, "%s\t%d\t%d\t%d\n", id, n, offset, length)
+}
+
+// remove drops the entries of file, which retention deleted, when the
+// index is sorted.
+func (x *traceIndex) remove(file string) {
+	if n, ok := x.files[file]; ok {
+		x.removed[n] = true
+	}
+}
+
+// Close writes out the log and replaces it with the sorted index.
+func (x *traceIndex) Close() error {
+	err := x.w.Flush()
+	if cerr := x.f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("trace index %s: %w", x.path, err)
+	}
+	if err := x.sort(); err != nil {
+		return fmt.Errorf("sort trace index %s: %w", x.path, err)
+	}
+	return nil
+}
+
+// sort rewrites the log as a sorted index, through a temp file so a
+// failure leaves the log, which lookups still read.
+func (x *traceIndex) sort() error {
+	f, err := os.Open(x.path)
+	if err != nil {
+		return err
+	}
+	files, entries, err := readTraceIndex(bufio.NewReader(f), "")
+	f.Close()
+	if err != nil {
+		return err
+	}
+	entries = slices.DeleteFunc(entries, func(e traceEntry) bool { return x.removed[e.file] })
+	slices.SortFunc(entries, func(a, b traceEntry) int {
+		return cmp.Or(strings.Compare(a.id, b.id), cmp.Compare(a.file, b.file), cmp.Compare(a.offset, b.offset))
+	})
+
+	tmp := x.path + ".tmp"
+	out, err := x.perm.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+	w.WriteString(traceIndexSorted + "\n")
+	for n := range len(files) {
+		if !x.removed[n] {
+			fmt.Fprintf(w, "\t%d\t%s\n", n, files[n])
+		}
+	}
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", e.id, e.file, e.offset, e.length)
+	}
+	err = w.Flush()
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = fsutil.Rename(tmp, x.path)
+	}
+	if err != nil {
+		fsutil.Remove(tmp)
+	}
+	return err
+}
+
+// closeTraceIndex closes x, if the sink has one. The output is complete
+// without it and the index can be rebuilt from the output, so a failure
+// is logged rather than failing the run.
+func closeTraceIndex(x *traceIndex) {
+	if x == nil {
+		return
+	}
+	if err := x.Close(); err != nil {
+		logger.Error("trace index not written; rebuild it with etl lookup --rebuild", "index", x.path, "error", err)
+	}
+}
+
+// recordTraceID returns the trace ID of a record as the file sinks get
+// it: normalized, in the legacy schema, or projected.
+func recordTraceID(record any) string {
+	switch r := record.(type) {
+	case model.Normalized:
+		return r.TraceID
+	case model.Legacy:
+		return r.TraceID
+	case Projection:
+		for i, k := range r.keys {
+			if k == "trace_id" {
+				s, _ := r.values[i].(string)
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// indexableTraceID reports whether id can be written to an index line.
+func indexableTraceID(id string) bool {
+	return id != "" && len(id) <= maxIndexedTraceID && !strings.ContainsAny(id, "\t\r\n")
+}
+
+// traceEntry is one record in an index.
+type traceEntry struct {
+	id             string
+	file           int
+	offset, length int64
+}
+
+// readTraceIndex reads the files and entries of an index, of either kind,
+// after the header. With id set only its entries are kept.
+func readTraceIndex(r *bufio.Reader, id string) (map[int]string, []traceEntry, error) {
+	if _, err := readIndexHeader(r); err != nil {
+		return nil, nil, err
+	}
+	files := make(map[int]string)
+	var entries []traceEntry
+	for {
+		line, err := r.ReadString('\n')
+		if line != "" && strings.HasSuffix(line, "\n") {
+			if err := parseIndexLine(line[:len(line)-1], id, files, &entries); err != nil {
+				return nil, nil, err
+			}
+		}
+		// A line cut short by a crash is left out.
+		if err == io.EOF {
+			return files, entries, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+// readIndexHeader reads the header line and reports whether the index is
+// sorted.
+func readIndexHeader(r *bufio.Reader) (sorted bool, err error) {
+	header, err := r.ReadString('\n')
+	switch strings.TrimSuffix(header, "\n") {
+	case traceIndexSorted:
+		return true, nil
+	case traceIndexLog:
+		return false, nil
+	}
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	return false, errors.New("not a trace index")
+}
+
+// parseIndexLine adds a file line to files, or an entry line for id, or
+// any entry when id is "", to entries.
+func parseIndexLine(line, id string, files map[int]string, entries *[]traceEntry) error {
+	fields := strings.Split(line, "\t")
+	if fields[0] == "" {
+		if len(fields) != 3 {
+			return fmt.Errorf("malformed trace index file line %q", line)
+		}
+		n, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return fmt.Errorf("malformed trace index file line %q", line)
+		}
+		files[n] = fields[2]
+		return nil
+	}
+	if id != "" && fields[0] != id {
+		return nil
+	}
+	e, err := parseIndexEntry(fields)
+	if err != nil {
+		return fmt.Errorf("malformed trace index entry %q", line)
+	}
+	*entries = append(*entries, e)
+	return nil
+}
+
+func parseIndexEntry(fields []string) (traceEntry, error) {
+	if len(fields) != 4 {
+		return traceEntry{}, errors.New("want 4 fields")
+	}
+	file, err1 := strconv.Atoi(fields[1])
+	offset, err2 := strconv.ParseInt(fields[2], 10, 64)
+	length, err3 := strconv.ParseInt(fields[3], 10, 64)
+	if err := errors.Join(err1, err2, err3); err != nil {
+		return traceEntry{}, err
+	}
+	return traceEntry{id: fields[0], file: file, offset: offset, length: length}, nil
+}
+
+// LookupTrace returns where the records of trace id were written, as the
+// index at path records them, in file and offset order. A sorted index is
+// binary-searched; the log of an unfinished run is read whole.
+func LookupTrace(path, id string) ([]TraceLocation, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	sorted, err := readIndexHeader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var files map[int]string
+	var entries []traceEntry
+	if sorted {
+		files, entries, err = searchSortedIndex(f, r, id)
+	} else {
+		f.Seek(0, io.SeekStart)
+		files, entries, err = readTraceIndex(bufio.NewReader(f), id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	dir := filepath.Dir(path)
+	locs := make([]TraceLocation, 0, len(entries))
+	for _, e := range entries {
+		name, ok := files[e.file]
+		if !ok {
+			continue // its file was deleted by retention
+		}
+		p := filepath.FromSlash(name)
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(dir, p)
+		}
+		locs = append(locs, TraceLocation{Path: p, Offset: e.offset, Length: e.length})
+	}
+	return locs, nil
+}
+
+// searchSortedIndex reads the file lines that follow the header in r and
+// binary-searches the entries after them for id.
+func searchSortedIndex(f *os.File, r *bufio.Reader, id string) (map[int]string, []traceEntry, error) {
+	files := make(map[int]string)
+	start := int64(len(traceIndexSorted) + 1)
+	for {
+		b, err := r.Peek(1)
+		if err != nil || b[0] != '\t' {
+			break
+		}
+		line, err := r.ReadString('\n')
+		if err != nil {
+			break
+		}
+		start += int64(len(line))
+		if err := parseIndexLine(strings.TrimSuffix(line, "\n"), id, files, nil); err != nil {
+			return nil, nil, err
+		}
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// lo is always the start of a line, and every line before it has a
+	// smaller ID; the first with id, if any, starts in [lo, hi].
+	lo, hi := start, info.Size()
+	for hi-lo > 4096 {
+		mid := lo + (hi-lo)/2
+		p, line, err := lineAfter(f, mid)
+		if err != nil {
+			return nil, nil, err
+		}
+		if p >= hi || line == nil {
+			break
+		}
+		if key, _, _ := bytes.Cut(line, []byte{'\t'}); string(key) < id {
+			lo = p + int64(len(line))
+		} else {
+			hi = p
+		}
+	}
+	sr := bufio.NewReader(io.NewSectionReader(f, lo, info.Size()-lo))
+	var entries []traceEntry
+	for {
+		line, err := sr.ReadString('\n')
+		if !strings.HasSuffix(line, "\n") {
+			break
+		}
+		fields := strings.Split(line[:len(line)-1], "\t")
+		if fields[0] > id {
+			break
+		}
+		if fields[0] == id {
+			e, perr := parseIndexEntry(fields)
+			if perr != nil {
+				return nil, nil, fmt.Errorf("malformed trace index entry %q", line)
+			}
+			entries = append(entries, e)
+		}
+		if err != nil {
+			break
+		}
+	}
+	return files, entries, nil
+}
+
+// lineAfter returns the first whole line starting at or after off, with
+// its newline, and where it starts; line is nil at the end of the file.
+func lineAfter(f *os.File, off int64) (int64, []byte, error) {
+	r := bufio.NewReader(io.NewSectionReader(f, off-1, 1<<62))
+	skipped, err := r.ReadBytes('\n') // the end of the line off-1 is in
+	if err != nil {
+		return 0, nil, ignoreEOF(err)
+	}
+	p := off - 1 + int64(len(skipped))
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return p, nil, ignoreEOF(err)
+	}
+	return p, line, nil
+}
+
+func ignoreEOF(err error) error {
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// RebuildTraceIndex writes the trace index at path again from the output
+// files it covers, such as after a crash left only part of the log. The
+// files must hold one JSON record per line, as output_format json writes
+// them; other lines are skipped. It returns the number of records indexed.
+func RebuildTraceIndex(path string, outputs []string, perm fsutil.Perm) (int, error) {
+	x, err := openTraceIndex(path, perm)
+	if err != nil {
+		return 0, err
+	}
+	indexed := 0
+	for _, out := range outputs {
+		n, err := x.addFile(out)
+		indexed += n
+		if err != nil {
+			x.w.Flush()
+			x.f.Close()
+			return indexed, fmt.Errorf("index %s: %w", out, err)
+		}
+	}
+	return indexed, x.Close()
+}
+
+// addFile indexes the JSON lines of the output file at path.
+func (x *traceIndex) addFile(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	var offset int64
+	indexed := 0
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			var rec model.Normalized
+			if json.Unmarshal(line, &rec) == nil && indexableTraceID(rec.TraceID) {
+				x.add(rec, path, offset, int64(len(line)))
+				indexed++
+			}
+			offset += int64(len(line))
+		}
+		if err == io.EOF {
+			return indexed, nil
+		}
+		if err != nil {
+			return indexed, err
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package sink
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s-log-etl/internal/config"
+	"k8s-log-etl/internal/fsutil"
+	"k8s-log-etl/internal/model"
+)
+
+// readAt returns the bytes a location points at.
+func readAt(t *testing.T, loc TraceLocation) string {
+	t.Helper()
+	f, err := os.Open(loc.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	buf := make([]byte, loc.Length)
+	if _, err := f.ReadAt(buf, loc.Offset); err != nil {
+		t.Fatal(err)
+	}
+	return string(buf)
+}
+
+func lookupMessages(t *testing.T, index, id string) []string {
+	t.Helper()
+	locs, err := LookupTrace(index, id)
+	if err != nil {
+		t.Fatalf("LookupTrace(%s): %v", id, err)
+	}
+	var msgs []string
+	for _, loc := range locs {
+		rec := readAt(t, loc)
+		if !strings.HasSuffix(rec, "\n") || strings.Count(rec, "\n") != 1 {
+			t.Errorf("location %+v is not one whole line: %q", loc, rec)
+		}
+		msgs = append(msgs, rec[strings.Index(rec, `"message":"`)+11:strings.Index(rec, `","trace_id"`)])
+	}
+	return msgs
+}
+
+func TestTraceIndexFileSink(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out.jsonl")
+	s, err := Build(t.Context(), config.Config{OutputType: "file", OutputPath: out, OutputIndex: config.IndexTraceID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, id := range []string{"b", "a", "", "b", "has\ttab", "c"} {
+		if err := s.Write(model.Normalized{Message: fmt.Sprint("m", i), TraceID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	index := TraceIndexPath(out)
+	data, err := os.ReadFile(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := traceIndexSorted + "\n\t0\tout.jsonl\n" + "a\t0\t"
+	if !strings.HasPrefix(string(data), want) || strings.Count(string(data), "\n") != 6 {
+		t.Errorf("index =\n%s", data)
+	}
+	for id, want := range map[string]string{"a": "m1", "b": "m0,m3", "c": "m5", "d": ""} {
+		if got := strings.Join(lookupMessages(t, index, id), ","); got != want {
+			t.Errorf("lookup %q = %s, want %s", id, got, want)
+		}
+	}
+}
+
+func TestTraceIndexRotationDropsDeletedFiles(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out.jsonl")
+	// One record per file; retention keeps the base file and the last two.
+	s, err := Build(t.Context(), config.Config{OutputType: "rotate", OutputPath: out, OutputMaxB: 70, OutputMaxFiles: 2, OutputIndex: config.IndexTraceID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 4 {
+		if err := s.Write(model.Normalized{Message: fmt.Sprint("m", i), TraceID: "t"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	locs, err := LookupTrace(TraceIndexPath(out), "t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, loc := range locs {
+		got = append(got, filepath.Base(loc.Path)+"="+strings.TrimSpace(readAt(t, loc)))
+	}
+	if len(got) != 3 || !strings.HasPrefix(got[0], "out.jsonl=") || !strings.HasPrefix(got[1], "out.jsonl.2=") || !strings.Contains(got[2], `"m3"`) {
+		t.Errorf("locations = %v, want out.jsonl, out.jsonl.2, and out.jsonl.3", got)
+	}
+}
+
+func TestTraceIndexBinarySearch(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out.jsonl")
+	x, err := openTraceIndex(TraceIndexPath(out), fsutil.Perm{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Enough entries that the search bisects, each trace in a few places
+	// and some IDs sharing prefixes.
+	for i := range 6000 {
+		x.add(model.Normalized{TraceID: fmt.Sprintf("trace-%d", i%2000)}, out, int64(i*100), 100)
+	}
+	if err := x.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"trace-0", "trace-1", "trace-1000", "trace-999", "trace-1999", "trace-2000", "a", "z"} {
+		locs, err := LookupTrace(x.path, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var n int
+		fmt.Sscanf(id, "trace-%d", &n)
+		want := 3
+		if !strings.HasPrefix(id, "trace-") || n >= 2000 {
+			want = 0
+		}
+		if len(locs) != want {
+			t.Errorf("lookup %s: %d locations, want %d", id, len(locs), want)
+			continue
+		}
+		for j, loc := range locs {
+			if loc.Offset != int64((n+2000*j)*100) {
+				t.Errorf("lookup %s: location %d at %d", id, j, loc.Offset)
+			}
+		}
+	}
+}
+
+func TestTraceIndexLogAfterCrashAndRebuild(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out.jsonl")
+	s, err := Build(t.Context(), config.Config{OutputType: "file", OutputPath: out, OutputIndex: config.IndexTraceID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 3 {
+		if err := s.Write(model.Normalized{Message: fmt.Sprint("m", i), TraceID: fmt.Sprint("t", i%2)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A crash: the log is on disk, unsorted, with its last line cut short.
+	js := s.(*JSONLSink)
+	js.index.w.Flush()
+	js.index.f.WriteString("t1\t0\t9")
+	js.index.f.Close()
+	js.closer.Close()
+
+	index := TraceIndexPath(out)
+	if got := strings.Join(lookupMessages(t, index, "t0"), ","); got != "m0,m2" {
+		t.Errorf("lookup in the log = %s, want m0,m2", got)
+	}
+	n, err := RebuildTraceIndex(index, []string{out}, fsutil.Perm{})
+	if err != nil || n != 3 {
+		t.Fatalf("rebuild = %d, %v; want 3 records", n, err)
+	}
+	f, err := os.Open(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if sorted, err := readIndexHeader(bufio.NewReader(f)); !sorted || err != nil {
+		t.Errorf("rebuilt index is not sorted: %v", err)
+	}
+	if got := strings.Join(lookupMessages(t, index, "t1"), ","); got != "m1" {
+		t.Errorf("lookup in the rebuilt index = %s, want m1", got)
+	}
+}
+
+func TestTraceIDOfProjectedRecords(t *testing.T) {
+	rec := model.Normalized{TraceID: "abc", Message: "m"}
+	p := NewProjectSink(NewMemorySink(), []string{"message", "trace_id"}).project(rec)
+	for _, r := range []any{rec, model.Legacy(rec), p} {
+		if got := recordTraceID(r); got != "abc" {
+			t.Errorf("recordTraceID(%T) = %q", r, got)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package sink
+
+// TypeInfo describes an output type Build opens, for `etl sinks` and the
+// docs generated from it.
+type TypeInfo struct {
+	Name        string   `json:"name"`
+	Aliases     []string `json:"aliases,omitempty"`
+	Description string   `json:"description"`
+	// ConfigKeys are the config keys the sink reads besides output_type
+	// and the output_format settings every local sink shares.
+	ConfigKeys []string `json:"config_keys"`
+}
+
+// Types describes every output type Build opens. A new case in Build is
+// listed here too.
+var Types = []TypeInfo{
+	{
+		Name:        "stdout",
+		Description: "write records to standard output; the default",
+		ConfigKeys:  []string{"pretty_fields"},
+	},
+	{
+		Name:        "file",
+		Description: "write records to a single file, truncated at the start of the run",
+		ConfigKeys:  []string{"output", "output_atomic", "output_done_marker", "output_manifest", "output_index", "output_resume", "output_file_mode", "output_dir_mode", "output_owner", "output_base_dir", "output_path_date_layout", "output_path_max_open"},
+	},
+	{
+		Name:        "rotate",
+		Aliases:     []string{"rotating"},
+		Description: "write records to a file that is rotated by size, keeping the newest files",
+		ConfigKeys:  []string{"output", "output_max_bytes", "output_max_files", "output_manifest", "output_index", "output_file_mode", "output_dir_mode", "output_owner", "output_base_dir", "output_path_date_layout", "output_path_max_open"},
+	},
+	{
+		Name:        "http",
+		Aliases:     []string{"webhook"},
+		Description: "POST records as JSON lines to a URL",
+		ConfigKeys:  []string{"output", "http_compress", "http_max_idle_conns", "http_max_conns_per_host", "http_idle_conn_timeout", "http_auth_token", "http_auth_token_file", "sink_max_retries", "sink_backoff_base_ms"},
+	},
+	{
+		Name:        "clickhouse",
+		Description: "insert records into a ClickHouse table over HTTP",
+		ConfigKeys:  []string{"output", "clickhouse_database", "clickhouse_table", "clickhouse_gzip", "clickhouse_password_file", "sink_max_retries", "sink_backoff_base_ms"},
+	},
+	{
+		Name:        "grpc",
+		Description: "stream records to a gRPC LogSink service (docs/logsink.proto), waiting for its acks",
+		ConfigKeys:  []string{"output", "grpc_tls", "grpc_ca_file", "grpc_auth_token", "grpc_auth_token_file", "sink_max_retries", "sink_backoff_base_ms"},
+	},
+	{
+		Name:        "nats",
+		Description: "publish records as JSON to NATS JetStream, on subjects templated by service and namespace",
+		ConfigKeys:  []string{"output", "nats_subject", "nats_creds_file", "nats_max_in_flight", "nats_ack_timeout", "sink_max_retries", "sink_backoff_base_ms", "shutdown_timeout_seconds"},
+	},
+	{
+		Name:        "object",
+		Description: "store records as objects in Google Cloud Storage (gs://) or Azure Blob Storage (azblob://)",
+		ConfigKeys:  []string{"output", "output_max_bytes", "object_max_age_seconds"},
+	},
+	{
+		Name:        "faulty",
+		Description: "wrap another sink and fail or delay writes on purpose, for testing",
+		ConfigKeys:  []string{"faulty_inner", "faulty_fail_rate", "faulty_fail_every", "faulty_fail_after", "faulty_latency_ms", "faulty_seed"},
+	},
+	{
+		Name:        "router",
+		Description: "send each record to one of several sinks by its fields",
+		ConfigKeys:  []string{"router_routes", "router_rules", "router_default", "route_by_level", "router_route_options"},
+	},
+}
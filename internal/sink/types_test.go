@@ -0,0 +1,21 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"k8s-log-etl/internal/config"
+)
+
+func TestTypesAreOpenedByBuild(t *testing.T) {
+	for _, typ := range Types {
+		for _, name := range append([]string{typ.Name}, typ.Aliases...) {
+			err := Check(context.Background(), config.Config{OutputType: name}, false)
+			if err != nil && (!errors.Is(err, ErrOpenSink) || strings.Contains(err.Error(), "unknown output type")) {
+				t.Errorf("output type %s: %v", name, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package source
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"k8s-log-etl/internal/logger"
+	"k8s-log-etl/internal/report"
+	"k8s-log-etl/internal/stages"
+)
+
+// fileLines counts the non-empty lines read from each input file for the
+// report, which may be taken while the files are read.
+type fileLines struct {
+	mode  string
+	mu    sync.Mutex
+	files []report.InputFile
+}
+
+func (f *fileLines) add(i int) {
+	f.mu.Lock()
+	f.files[i].Lines++
+	f.mu.Unlock()
+}
+
+// Report implements Reporter with the inputs section.
+func (f *fileLines) Report(rep *report.Report) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	rep.SetInputs(f.mode, append([]report.InputFile(nil), f.files...))
+}
+
+// concat reads several inputs one after another, detecting the format of
+// each.
+type concat struct {
+	fileLines
+	format  string
+	limits  LineLimits
+	readers []io.Reader
+	i       int
+	cur     Source
+	err     error
+}
+
+// Concat reads readers one after another, detecting the format of each as
+// NewReader does. paths name them for the records' origin and the report.
+// An input error ends the whole input.
+func Concat(paths []string, readers []io.Reader, format string, limits LineLimits) Source {
+	s := &concat{fileLines: fileLines{mode: report.InputConcat}, format: format, limits: limits, readers: readers}
+	for _, p := range paths {
+		s.files = append(s.files, report.InputFile{Path: p})
+	}
+	return s
+}
+
+// Next returns the next record, moving on to the next input at the end
+// of each one.
+func (s *concat) Next(ctx context.Context) (Record, error) {
+	for s.err == nil && s.i < len(s.readers) {
+		if s.cur == nil {
+			s.cur = NewReader(s.readers[s.i], s.format, s.files[s.i].Path, s.limits)
+		}
+		rec, err := s.cur.Next(ctx)
+		if err == nil {
+			if len(bytes.TrimSpace(rec.Data)) != 0 || rec.Large != nil {
+				s.add(s.i)
+			}
+			return rec, nil
+		}
+		if err != io.EOF {
+			s.err = fmt.Errorf("%s: %w", s.files[s.i].Path, err)
+		}
+		s.cur = nil
+		s.i++
+	}
+	if s.err != nil {
+		return Record{}, s.err
+	}
+	return Record{}, io.EOF
+}
+
+// merge k-way merges JSONL inputs that are each in timestamp order,
+// always yielding the line with the earliest timestamp among the inputs'
+// next lines. Only one line per input is held. An input whose first line
+// has no usable timestamp is read after the merge instead; a later line
+// without one keeps its input's previous timestamp.
+type merge struct {
+	fileLines
+	heap     mergeHeap
+	fallback []*mergeInput
+	parser   stages.Parser
+	err      error
+}
+
+type mergeInput struct {
+	idx     int
+	r       *lineReader
+	bufs    [2][]byte // the head alternates between them, see advance
+	next    int
+	larges  [2]LargeLine // alongside bufs
+	head    []byte       // nil once the input is exhausted
+	headSrc Origin
+	headBig *LargeLine // of head, if it is a large line
+	ts      time.Time
+}
+
+// Merge merges readers, JSONL inputs each in timestamp order, by
+// timestamp. paths name them for the records' origin and the report. An
+// input error ends the whole input. limits apply to every input's lines.
+func Merge(paths []string, readers []io.Reader, limits LineLimits) Source {
+	s := &merge{fileLines: fileLines{mode: report.InputMergeSorted, files: make([]report.InputFile, len(paths))}}
+	for i, p := range paths {
+		s.files[i] = report.InputFile{Path: p, Merged: true}
+		in := &mergeInput{idx: i, r: newLineReader(readers[i], p, limits)}
+		if !s.advance(in) {
+			continue
+		}
+		ts, ok := s.timestamp(in.head)
+		if !ok {
+			logger.Warn("input has no timestamp on its first line; it is read after the merged inputs", "input", p)
+			s.files[i].Merged = false
+			s.fallback = append(s.fallback, in)
+			continue
+		}
+		in.ts = ts
+		s.heap = append(s.heap, in)
+	}
+	heap.Init(&s.heap)
+	return s
+}
+
+// advance reads in's next non-empty line into the buffer that is not
+// holding the line Next last returned, reporting whether there was one.
+func (s *merge) advance(in *mergeInput) bool {
+	for {
+		rec, err := in.r.Next(context.Background())
+		if err != nil {
+			if err != io.EOF && s.err == nil {
+				s.err = fmt.Errorf("%s: %w", s.files[in.idx].Path, err)
+			}
+			in.head = nil
+			return false
+		}
+		if len(bytes.TrimSpace(rec.Data)) == 0 && rec.Large == nil {
+			continue
+		}
+		buf := append(in.bufs[in.next][:0], rec.Data...)
+		if buf == nil {
+			buf = []byte{} // a line too long to keep has no data; head must not be nil
+		}
+		in.bufs[in.next] = buf
+		in.head = in.bufs[in.next]
+		in.headSrc = rec.Origin
+		in.headBig = nil
+		if rec.Large != nil {
+			in.larges[in.next] = *rec.Large
+			in.headBig = &in.larges[in.next]
+		}
+		in.next = 1 - in.next
+		return true
+	}
+}
+
+// timestamp is the event time of the first record on line.
+func (s *merge) timestamp(line []byte) (time.Time, bool) {
+	records, err := s.parser.Parse(line)
+	if err != nil || len(records) == 0 {
+		return time.Time{}, false
+	}
+	ts, err := stages.Timestamp(records[0])
+	return ts, err == nil
+}
+
+// Next returns the next line in merge order, then the lines of the inputs
+// read after the merge.
+func (s *merge) Next(context.Context) (Record, error) {
+	if s.err != nil {
+		return Record{}, s.err
+	}
+	if len(s.heap) > 0 {
+		in := s.heap[0]
+		rec := Record{Data: in.head, Origin: in.headSrc, Large: in.headBig}
+		s.add(in.idx)
+		if s.advance(in) {
+			if ts, ok := s.timestamp(in.head); ok {
+				in.ts = ts
+			}
+			heap.Fix(&s.heap, 0)
+		} else {
+			heap.Pop(&s.heap)
+		}
+		return rec, nil
+	}
+	for len(s.fallback) > 0 {
+		in := s.fallback[0]
+		if in.head != nil {
+			rec := Record{Data: in.head, Origin: in.headSrc, Large: in.headBig}
+			s.add(in.idx)
+			s.advance(in)
+			return rec, nil
+		}
+		if s.err != nil {
+			return Record{}, s.err
+		}
+		s.fallback = s.fallback[1:]
+	}
+	return Record{}, io.EOF
+}
+
+// mergeHeap orders inputs by their next line's timestamp, then by their
+// position in the input list.
+type mergeHeap []*mergeInput
+
+func (h mergeHeap) Len() int { return len(h) }
+func (h mergeHeap) Less(i, j int) bool {
+	if !h[i].ts.Equal(h[j].ts) {
+		return h[i].ts.Before(h[j].ts)
+	}
+	return h[i].idx < h[j].idx
+}
+func (h mergeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x any)   { *h = append(*h, x.(*mergeInput)) }
+func (h *mergeHeap) Pop() any {
+	old := *h
+	in := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return in
+}
@@ -0,0 +1,105 @@
+package source
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"k8s-log-etl/internal/report"
+)
+
+func jsonlAt(secs ...int) string {
+	var b strings.Builder
+	for _, s := range secs {
+		fmt.Fprintf(&b, `{"ts":"2024-01-01T12:00:%02dZ","level":"ERROR","msg":"m%d"}`+"\n", s, s)
+	}
+	return b.String()
+}
+
+func readers(inputs ...string) []io.Reader {
+	rs := make([]io.Reader, len(inputs))
+	for i, in := range inputs {
+		rs[i] = strings.NewReader(in)
+	}
+	return rs
+}
+
+func TestMerge(t *testing.T) {
+	paths := []string{"a", "b", "c", "d", "e"}
+	s := Merge(paths, readers(
+		jsonlAt(1, 4, 7),
+		"\n"+jsonlAt(2, 2, 8)+"\n",
+		`{"msg":"no time"}`+"\n"+jsonlAt(0),
+		jsonlAt(3)+`{"msg":"no time"}`+"\n"+jsonlAt(9),
+		"",
+	), LineLimits{})
+	data, _, err := drain(t, s)
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	var got []string
+	for _, d := range data {
+		var rec struct{ Msg string }
+		if err := json.Unmarshal([]byte(d), &rec); err != nil {
+			t.Fatalf("unmarshal %q: %v", d, err)
+		}
+		if rec.Msg == "no time" {
+			rec.Msg = "x"
+		}
+		got = append(got, rec.Msg)
+	}
+	// d's untimed line keeps d's previous timestamp (3s); c is read last.
+	want := "m1,m2,m2,m3,x,m4,m7,m8,m9,x,m0"
+	if strings.Join(got, ",") != want {
+		t.Errorf("order = %s, want %s", strings.Join(got, ","), want)
+	}
+
+	rep := report.NewReport()
+	s.(Reporter).Report(rep)
+	if rep.InputMode != report.InputMergeSorted {
+		t.Errorf("mode = %q", rep.InputMode)
+	}
+	wantFiles := []report.InputFile{
+		{Path: "a", Lines: 3, Merged: true},
+		{Path: "b", Lines: 3, Merged: true},
+		{Path: "c", Lines: 2, Merged: false},
+		{Path: "d", Lines: 3, Merged: true},
+		{Path: "e", Lines: 0, Merged: true},
+	}
+	for i, f := range rep.InputFiles {
+		if f != wantFiles[i] {
+			t.Errorf("files[%d] = %+v, want %+v", i, f, wantFiles[i])
+		}
+	}
+}
+
+func TestConcat(t *testing.T) {
+	boom := errors.New("boom")
+	s := Concat([]string{"a", "b", "c"}, []io.Reader{
+		strings.NewReader("{\"n\":1}\n\n"),
+		strings.NewReader(`[{"n":2},{"n":3}]`),
+		iotest.ErrReader(boom),
+	}, "", LineLimits{})
+	data, origins, err := drain(t, s)
+	if !errors.Is(err, boom) || !strings.HasPrefix(err.Error(), "c: ") {
+		t.Errorf("err = %v, want c's read error", err)
+	}
+	if got := strings.Join(data, ""); got != `{"n":1}{"n":2}{"n":3}` {
+		t.Errorf("records = %q", data)
+	}
+	want := []Origin{{File: "a", Line: 1}, {File: "a", Line: 2, Offset: 8}, {File: "b", Line: 1, Offset: 1}, {File: "b", Line: 2, Offset: 9}}
+	if fmt.Sprint(origins) != fmt.Sprint(want) {
+		t.Errorf("origins = %v, want %v", origins, want)
+	}
+
+	rep := report.NewReport()
+	s.(Reporter).Report(rep)
+	wantFiles := []report.InputFile{{Path: "a", Lines: 1}, {Path: "b", Lines: 2}, {Path: "c"}}
+	if rep.InputMode != report.InputConcat || fmt.Sprint(rep.InputFiles) != fmt.Sprint(wantFiles) {
+		t.Errorf("inputs = %s %v, want %v", rep.InputMode, rep.InputFiles, wantFiles)
+	}
+}
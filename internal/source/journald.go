@@ -0,0 +1,481 @@
+package source
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"k8s-log-etl/internal/fsutil"
+	"k8s-log-etl/internal/logger"
+	"k8s-log-etl/internal/report"
+)
+
+// JournaldFile is the Origin.File of the records of a journald source.
+const JournaldFile = "journald"
+
+// Timings of the journalctl child; variables so tests can shorten them.
+var (
+	// journaldStopWait is how long Close waits for journalctl to exit
+	// after SIGTERM before it kills it.
+	journaldStopWait = 5 * time.Second
+	// journaldSaveEvery is how often the cursor file is rewritten while
+	// entries are acknowledged.
+	journaldSaveEvery = time.Second
+)
+
+// journaldBuffer is how many entries are read ahead of Next.
+const journaldBuffer = 256
+
+// maxStderrTail bounds how much of journalctl's stderr is kept for error
+// messages.
+const maxStderrTail = 4096
+
+// JournaldOptions configures a journald source.
+type JournaldOptions struct {
+	// Command runs journalctl, with its own arguments first; "journalctl"
+	// when empty. The source adds --output=json, --all, and --no-pager,
+	// then --follow, --unit, and --after-cursor as set.
+	Command []string
+	Units   []string
+	Follow  bool
+	// CursorFile, when set, is read at the start to resume after the
+	// cursor it holds, and rewritten with the cursor of the last entry
+	// acknowledged, every journaldSaveEvery and on Close.
+	CursorFile string
+	Perm       fsutil.Perm
+	// MaxLine skips entries longer than it in bytes; 0 means no limit.
+	MaxLine int
+}
+
+// Journald reads the systemd journal as records through a journalctl
+// child printing one JSON entry per line. Each entry is mapped onto the
+// keys Normalize reads; see journalRecord. Entries are read ahead of
+// Next on a goroutine, so Next gives up when its ctx is done. A record's
+// Ack settles its entry: the cursor saved is that of the last entry
+// which, with every entry before it, was acknowledged, so a later run
+// never skips one. Once an entry fails, the cursor stays before it for
+// the rest of the run, and the next run reads it again.
+type Journald struct {
+	cmd        *exec.Cmd
+	stderr     *stderrTail
+	cursorFile string
+	perm       fsutil.Perm
+
+	entries chan Record
+	stop    chan struct{} // closed by Close
+	done    chan struct{} // closed once journalctl exited and readLoop ended
+	err     error         // why reading ended; set before done is closed
+	wg      sync.WaitGroup
+
+	mu      sync.Mutex
+	next    uint64            // the oldest entry not yet acknowledged
+	acked   map[uint64]string // entries acknowledged past next, with their cursors
+	stuck   bool              // an entry failed; the cursor no longer moves
+	cursor  string            // last entry acknowledged with all before it
+	saved   string            // cursor last written to cursorFile
+	stats   report.JournaldInputStats
+	closed  bool
+	lastErr string // last cursor file error logged, to log each once
+}
+
+// NewJournald starts journalctl. A cursor file that does not exist yet
+// is not an error: journalctl starts where its options say.
+func NewJournald(opts JournaldOptions) (*Journald, error) {
+	command := opts.Command
+	if len(command) == 0 {
+		command = []string{"journalctl"}
+	}
+	args := append(slices.Clone(command[1:]), "--output=json", "--all", "--no-pager")
+	if opts.Follow {
+		args = append(args, "--follow")
+	}
+	for _, unit := range opts.Units {
+		args = append(args, "--unit="+unit)
+	}
+	j := &Journald{
+		stderr:     &stderrTail{},
+		cursorFile: opts.CursorFile,
+		perm:       opts.Perm,
+		entries:    make(chan Record, journaldBuffer),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+		next:       1,
+		acked:      make(map[uint64]string),
+	}
+	j.stats.Units = slices.Clone(opts.Units)
+	if opts.CursorFile != "" {
+		data, err := os.ReadFile(opts.CursorFile)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("journald cursor file: %w", err)
+		}
+		if cursor := strings.TrimSpace(string(data)); cursor != "" {
+			args = append(args, "--after-cursor="+cursor)
+			j.stats.ResumedFrom, j.cursor, j.saved = cursor, cursor, cursor
+		}
+	}
+	j.cmd = exec.Command(command[0], args...)
+	j.cmd.Stderr = j.stderr
+	out, err := j.cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("journalctl stdout pipe: %w", err)
+	}
+	if err := j.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start %s: %w", command[0], err)
+	}
+	logger.Info("reading the systemd journal", "command", command[0], "units", strings.Join(opts.Units, ","), "follow", opts.Follow, "after_cursor", j.cursor)
+	j.wg.Add(1)
+	go j.readLoop(out, opts.MaxLine)
+	if j.cursorFile != "" {
+		j.wg.Add(1)
+		go j.saveLoop()
+	}
+	return j, nil
+}
+
+// readLoop hands the entries journalctl prints to Next until it exits.
+// Once the source is closed it reads on without keeping them, so
+// journalctl is never blocked writing and can act on its SIGTERM.
+func (j *Journald) readLoop(out io.Reader, maxLine int) {
+	defer j.wg.Done()
+	lines := newLineReader(bufio.NewReader(out), JournaldFile, LineLimits{Max: maxLine})
+	stopped := false
+	var seq uint64
+	var err error
+	for {
+		var rec Record
+		if rec, err = lines.Next(context.Background()); err != nil {
+			break
+		}
+		if stopped || (rec.Large == nil && len(bytes.TrimSpace(rec.Data)) == 0) {
+			continue
+		}
+		var cursor string
+		if rec.Large == nil {
+			rec.Data, cursor = journalRecord(rec.Data)
+		}
+		seq++
+		n := seq
+		rec.Ack = func(ok bool) { j.settle(n, cursor, ok) }
+		j.mu.Lock()
+		j.stats.Read++
+		j.mu.Unlock()
+		select {
+		case j.entries <- rec:
+		case <-j.stop:
+			stopped = true
+		}
+	}
+	waitErr := j.cmd.Wait()
+	switch {
+	case err != io.EOF:
+		j.err = fmt.Errorf("read journalctl output: %w", err)
+	case waitErr != nil && !stopped && !j.isClosed():
+		j.err = fmt.Errorf("journalctl failed: %w%s", waitErr, j.stderr.suffix())
+	default:
+		j.err = io.EOF
+		if tail := j.stderr.suffix(); tail != "" && !stopped {
+			logger.Warn("journalctl wrote to stderr", "stderr", strings.TrimPrefix(tail, ": "))
+		}
+	}
+	close(j.done)
+}
+
+// settle records the acknowledgement of entry seq, moving the cursor
+// past every entry acknowledged in order.
+func (j *Journald) settle(seq uint64, cursor string, ok bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if !ok {
+		if !j.stuck {
+			j.stuck = true
+			clear(j.acked)
+			logger.Warn("journal entry not written, the saved cursor stays before it", "line", seq)
+		}
+		return
+	}
+	j.stats.Acked++
+	if j.stuck {
+		return
+	}
+	j.acked[seq] = cursor
+	for {
+		c, found := j.acked[j.next]
+		if !found {
+			return
+		}
+		delete(j.acked, j.next)
+		j.next++
+		if c != "" { // an entry too long to read has no cursor
+			j.cursor = c
+		}
+	}
+}
+
+// saveLoop rewrites the cursor file as the cursor moves, until Close.
+func (j *Journald) saveLoop() {
+	defer j.wg.Done()
+	t := time.NewTicker(journaldSaveEvery)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			j.save()
+		case <-j.stop:
+			return
+		}
+	}
+}
+
+// save writes the cursor to a temp file and renames it into place, so a
+// run killed while writing leaves the previous cursor. A failure is
+// logged and tried again on the next save.
+func (j *Journald) save() {
+	j.mu.Lock()
+	cursor := j.cursor
+	j.mu.Unlock()
+	if cursor == j.saved {
+		return
+	}
+	if err := j.writeCursor(cursor); err != nil {
+		if msg := err.Error(); msg != j.lastErr {
+			j.lastErr = msg
+			logger.Warn("cannot save the journald cursor", "path", j.cursorFile, "error", err)
+		}
+		return
+	}
+	j.saved, j.lastErr = cursor, ""
+	j.mu.Lock()
+	j.stats.Cursor = cursor
+	j.mu.Unlock()
+}
+
+func (j *Journald) writeCursor(cursor string) error {
+	tmp := j.cursorFile + ".tmp"
+	f, err := j.perm.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(cursor + "\n")
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = fsutil.Rename(tmp, j.cursorFile)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// Next returns the next entry, waiting for one. It returns io.EOF once
+// journalctl reached the end of the journal without --follow, the error
+// journalctl failed with, or ctx's error once it is done.
+func (j *Journald) Next(ctx context.Context) (Record, error) {
+	if err := ctx.Err(); err != nil {
+		return Record{}, err
+	}
+	select {
+	case rec := <-j.entries:
+		return rec, nil
+	case <-ctx.Done():
+		return Record{}, ctx.Err()
+	case <-j.done:
+		// Entries read before journalctl exited come first.
+		select {
+		case rec := <-j.entries:
+			return rec, nil
+		default:
+		}
+		return Record{}, j.err
+	}
+}
+
+// Report implements Reporter with the journald_input section.
+func (j *Journald) Report(rep *report.Report) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	st := j.stats
+	st.Units = slices.Clone(st.Units)
+	st.Unacked = st.Read - st.Acked
+	rep.SetJournaldInput(st)
+}
+
+// Close stops journalctl: SIGTERM, then a kill if it has not exited
+// within journaldStopWait. It then saves the cursor one last time.
+// Entries read and not yet returned by Next are left for the next run.
+func (j *Journald) Close() {
+	j.mu.Lock()
+	if j.closed {
+		j.mu.Unlock()
+		return
+	}
+	j.closed = true
+	j.mu.Unlock()
+	close(j.stop)
+	select {
+	case <-j.done:
+	default:
+		if err := j.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+			j.cmd.Process.Kill()
+		}
+		select {
+		case <-j.done:
+		case <-time.After(journaldStopWait):
+			logger.Warn("journalctl did not exit after SIGTERM, killing it", "wait", journaldStopWait.String())
+			j.cmd.Process.Kill()
+		}
+	}
+	j.wg.Wait()
+	if j.cursorFile != "" {
+		j.save()
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.stats.Cursor = j.saved
+}
+
+func (j *Journald) isClosed() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.closed
+}
+
+// priorityLevels maps syslog priorities, journal field PRIORITY, onto
+// levels.
+var priorityLevels = [...]string{"FATAL", "FATAL", "FATAL", "ERROR", "WARN", "INFO", "INFO", "DEBUG"}
+
+// journalRecord maps a journalctl -o json entry onto the keys Normalize
+// reads and returns it with the entry's cursor:
+//   - ts is __REALTIME_TIMESTAMP, microseconds since the epoch, in RFC 3339;
+//   - level is PRIORITY as a level: 0 to 2 FATAL, 3 ERROR, 4 WARN, 5 and 6
+//     INFO, 7 DEBUG, and INFO when it is missing;
+//   - msg is MESSAGE;
+//   - service is _SYSTEMD_UNIT without a .service suffix, or
+//     SYSLOG_IDENTIFIER for an entry from outside a unit, such as the
+//     kernel's;
+//   - hostname is _HOSTNAME.
+//
+// Those fields and the journal's own, whose names start with "__", are
+// left out; every other field is kept under its journal name. A binary
+// value, which journalctl prints as an array of bytes, is kept as a
+// string, and a field with several values keeps its first. A line that
+// is not a JSON object is returned as it is, to fail parsing.
+func journalRecord(line []byte) ([]byte, string) {
+	var entry map[string]json.RawMessage
+	if err := json.Unmarshal(line, &entry); err != nil {
+		return bytes.Clone(line), ""
+	}
+	out := make(map[string]any, len(entry))
+	cursor, _ := journalValue(entry["__CURSOR"])
+	if us, ok := journalValue(entry["__REALTIME_TIMESTAMP"]); ok {
+		if n, err := strconv.ParseInt(us, 10, 64); err == nil {
+			out["ts"] = time.UnixMicro(n).UTC().Format(time.RFC3339Nano)
+		}
+	}
+	out["level"] = "INFO"
+	if p, ok := journalValue(entry["PRIORITY"]); ok {
+		if n, err := strconv.Atoi(p); err == nil && n >= 0 && n < len(priorityLevels) {
+			out["level"] = priorityLevels[n]
+		}
+	}
+	if msg, ok := journalValue(entry["MESSAGE"]); ok {
+		out["msg"] = msg
+	}
+	if unit, ok := journalValue(entry["_SYSTEMD_UNIT"]); ok && unit != "" {
+		out["service"] = strings.TrimSuffix(unit, ".service")
+		delete(entry, "_SYSTEMD_UNIT")
+	} else if id, ok := journalValue(entry["SYSLOG_IDENTIFIER"]); ok {
+		out["service"] = id
+		delete(entry, "SYSLOG_IDENTIFIER")
+	}
+	if host, ok := journalValue(entry["_HOSTNAME"]); ok {
+		out["hostname"] = host
+	}
+	for _, k := range []string{"MESSAGE", "PRIORITY", "_HOSTNAME"} {
+		delete(entry, k)
+	}
+	for k, raw := range entry {
+		if strings.HasPrefix(k, "__") {
+			continue
+		}
+		if v, ok := journalValue(raw); ok {
+			out[k] = v
+		}
+	}
+	data, err := json.Marshal(out)
+	if err != nil {
+		return bytes.Clone(line), cursor
+	}
+	return data, cursor
+}
+
+// journalValue returns a field's value as journalctl -o json prints it: a
+// string, an array of bytes for a binary value, or an array of either
+// for a field with several values, of which it takes the first. It
+// reports false for null, which journalctl prints for a value it cannot
+// show, and for a missing field.
+func journalValue(raw json.RawMessage) (string, bool) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", false
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s, true
+	}
+	var b []byte
+	var nums []int
+	if json.Unmarshal(raw, &nums) == nil && len(nums) > 0 {
+		for _, n := range nums {
+			b = append(b, byte(n))
+		}
+		return string(b), true
+	}
+	var values []json.RawMessage
+	if json.Unmarshal(raw, &values) == nil && len(values) > 0 {
+		return journalValue(values[0])
+	}
+	return "", false
+}
+
+// stderrTail keeps the end of what a child writes to stderr.
+type stderrTail struct {
+	mu sync.Mutex
+	b  []byte
+}
+
+func (t *stderrTail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.b = append(t.b, p...)
+	if len(t.b) > maxStderrTail {
+		t.b = t.b[len(t.b)-maxStderrTail:]
+	}
+	return len(p), nil
+}
+
+// suffix returns the tail as ": <text>" for an error message, or "" when
+// nothing was written.
+func (t *stderrTail) suffix() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if s := strings.TrimSpace(string(t.b)); s != "" {
+		return ": " + s
+	}
+	return ""
+}
@@ -0,0 +1,233 @@
+package source
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s-log-etl/internal/report"
+)
+
+// TestJournalctlHelper stands in for journalctl in the journald tests,
+// printing testdata/journal.json. It is a no-op unless invoked by
+// journaldHelper.
+func TestJournalctlHelper(t *testing.T) {
+	mode := os.Getenv("ETL_JOURNALCTL_HELPER")
+	if mode == "" {
+		return
+	}
+	args := os.Args[slices.Index(os.Args, "--")+1:]
+	os.WriteFile(os.Getenv("ETL_JOURNALCTL_ARGS"), []byte(strings.Join(args, "\n")), 0o644)
+	if mode == "fail" {
+		fmt.Fprintln(os.Stderr, "Failed to add filter for units: No data available")
+		os.Exit(1)
+	}
+	f, err := os.Open(filepath.Join("testdata", "journal.json"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	var after string
+	for _, a := range args {
+		if c, ok := strings.CutPrefix(a, "--after-cursor="); ok {
+			after = c
+		}
+	}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if after != "" {
+			if strings.Contains(sc.Text(), `"__CURSOR":"`+after+`"`) {
+				after = ""
+			}
+			continue
+		}
+		fmt.Println(sc.Text())
+	}
+	if slices.Contains(args, "--follow") {
+		time.Sleep(time.Hour) // until SIGTERM
+	}
+	os.Exit(0)
+}
+
+// journaldHelper returns options running TestJournalctlHelper in mode,
+// and the file it writes its arguments to.
+func journaldHelper(t *testing.T, mode string) (JournaldOptions, string) {
+	t.Helper()
+	args := filepath.Join(t.TempDir(), "args")
+	t.Setenv("ETL_JOURNALCTL_HELPER", mode)
+	t.Setenv("ETL_JOURNALCTL_ARGS", args)
+	return JournaldOptions{Command: []string{os.Args[0], "-test.run=^TestJournalctlHelper$", "--"}}, args
+}
+
+func TestJournalRecord(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "journal.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(data), "\n")
+	for i, want := range []struct {
+		cursor string
+		record map[string]any
+	}{
+		{"s=8c2f;i=1a01;b=3e1d;m=15a2;t=5f0c1;x=a1", map[string]any{
+			"ts": "2024-01-01T12:00:00.123456Z", "level": "INFO", "msg": "GET /healthz 200", "service": "nginx", "hostname": "node-1",
+			"_BOOT_ID": "3e1d", "_TRANSPORT": "stdout", "SYSLOG_FACILITY": "3", "SYSLOG_IDENTIFIER": "nginx", "_PID": "812", "_COMM": "nginx",
+		}},
+		{"s=8c2f;i=1a02;b=3e1d;m=15a3;t=5f0c2;x=a2", map[string]any{
+			"ts": "2024-01-01T12:00:01Z", "level": "ERROR", "msg": "charge failed: card declined", "service": "payments", "hostname": "node-1",
+			"_BOOT_ID": "3e1d", "_TRANSPORT": "stdout", "SYSLOG_IDENTIFIER": "payments", "_PID": "933", "CODE_FILE": "a.go",
+		}},
+		{"s=8c2f;i=1a03;b=3e1d;m=15a4;t=5f0c3;x=a3", map[string]any{
+			"ts": "2024-01-01T12:00:02.5Z", "level": "FATAL", "msg": "Out of memory\x1b", "service": "kernel", "hostname": "node-1",
+			"_BOOT_ID": "3e1d", "_TRANSPORT": "kernel",
+		}},
+		{"", nil}, // the blank line
+		{"s=8c2f;i=1a04;b=3e1d;m=15a5;t=5f0c4;x=a4", map[string]any{
+			"ts": "2024-01-01T12:00:03Z", "level": "INFO", "msg": "backup done", "service": "backup.timer", "hostname": "node-1",
+			"_BOOT_ID": "3e1d", "_TRANSPORT": "journal", "SYSLOG_IDENTIFIER": "backup.sh",
+		}},
+	} {
+		if want.record == nil {
+			continue
+		}
+		out, cursor := journalRecord([]byte(lines[i]))
+		var got map[string]any
+		if err := json.Unmarshal(out, &got); err != nil {
+			t.Fatalf("entry %d: %v: %s", i+1, err, out)
+		}
+		if cursor != want.cursor || !reflect.DeepEqual(got, want.record) {
+			t.Errorf("entry %d = %v at %q\nwant %v at %q", i+1, got, cursor, want.record, want.cursor)
+		}
+	}
+
+	if out, cursor := journalRecord([]byte(`not json`)); string(out) != "not json" || cursor != "" {
+		t.Errorf("a line that is not JSON = %s, %q", out, cursor)
+	}
+}
+
+// readJournal reads n records from j, failing the test on an error.
+func readJournal(t *testing.T, j *Journald, n int) []Record {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var recs []Record
+	for len(recs) < n {
+		rec, err := j.Next(ctx)
+		if err != nil {
+			t.Fatalf("record %d: %v", len(recs)+1, err)
+		}
+		rec.Data = append([]byte(nil), rec.Data...)
+		recs = append(recs, rec)
+	}
+	return recs
+}
+
+func TestJournaldResumesAfterTheAckedCursor(t *testing.T) {
+	opts, argsFile := journaldHelper(t, "batch")
+	opts.Units = []string{"nginx.service", "payments.service"}
+	opts.CursorFile = filepath.Join(t.TempDir(), "journal.cursor")
+	j, err := NewJournald(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recs := readJournal(t, j, 4)
+	if _, err := j.Next(context.Background()); err != io.EOF {
+		t.Fatalf("after the last entry: %v", err)
+	}
+	if recs[0].Origin != (Origin{File: JournaldFile, Line: 1}) || recs[3].Origin.Line != 5 {
+		t.Errorf("origins %+v, %+v", recs[0].Origin, recs[3].Origin)
+	}
+	// Acked out of order, the cursor only passes entries acked with all
+	// before them: the third entry's, as the fourth is never acked.
+	recs[2].Ack(true)
+	recs[0].Ack(true)
+	recs[1].Ack(true)
+	j.Close()
+	if data, err := os.ReadFile(opts.CursorFile); err != nil || string(data) != "s=8c2f;i=1a03;b=3e1d;m=15a4;t=5f0c3;x=a3\n" {
+		t.Fatalf("cursor file = %q, %v", data, err)
+	}
+	args, _ := os.ReadFile(argsFile)
+	if want := "--output=json\n--all\n--no-pager\n--unit=nginx.service\n--unit=payments.service"; string(args) != want {
+		t.Errorf("journalctl args:\n%s\nwant:\n%s", args, want)
+	}
+	rep := report.NewReport()
+	j.Report(rep)
+	want := report.JournaldInputStats{Units: opts.Units, Read: 4, Acked: 3, Unacked: 1, Cursor: "s=8c2f;i=1a03;b=3e1d;m=15a4;t=5f0c3;x=a3"}
+	if rep.JournaldInput == nil || !reflect.DeepEqual(*rep.JournaldInput, want) {
+		t.Errorf("journald_input = %+v, want %+v", rep.JournaldInput, want)
+	}
+
+	// The next run starts after the cursor; a failed entry keeps it there.
+	j, err = NewJournald(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recs = readJournal(t, j, 1)
+	if !strings.Contains(string(recs[0].Data), `"msg":"backup done"`) {
+		t.Errorf("resumed at %s", recs[0].Data)
+	}
+	recs[0].Ack(false)
+	j.Close()
+	if args, _ := os.ReadFile(argsFile); !strings.HasSuffix(string(args), "\n--after-cursor=s=8c2f;i=1a03;b=3e1d;m=15a4;t=5f0c3;x=a3") {
+		t.Errorf("journalctl args:\n%s", args)
+	}
+	if data, _ := os.ReadFile(opts.CursorFile); string(data) != "s=8c2f;i=1a03;b=3e1d;m=15a4;t=5f0c3;x=a3\n" {
+		t.Errorf("cursor moved past a failed entry: %q", data)
+	}
+}
+
+func TestJournaldFollowStopsOnClose(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("journalctl is stopped with SIGTERM")
+	}
+	opts, argsFile := journaldHelper(t, "follow")
+	opts.Follow = true
+	j, err := NewJournald(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range readJournal(t, j, 4) {
+		rec.Ack(true)
+	}
+	// journalctl waits for new entries, and so does Next until ctx ends.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := j.Next(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Next = %v, want the context's error", err)
+	}
+	start := time.Now()
+	j.Close()
+	if d := time.Since(start); d >= journaldStopWait {
+		t.Errorf("Close took %v: journalctl was killed, not stopped", d)
+	}
+	if args, _ := os.ReadFile(argsFile); !strings.HasSuffix(string(args), "\n--follow") {
+		t.Errorf("journalctl args:\n%s", args)
+	}
+	if _, err := j.Next(context.Background()); err != io.EOF {
+		t.Errorf("Next after Close = %v, want io.EOF", err)
+	}
+}
+
+func TestJournaldReportsJournalctlFailure(t *testing.T) {
+	opts, _ := journaldHelper(t, "fail")
+	j, err := NewJournald(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	_, err = j.Next(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "journalctl failed: exit status 1: Failed to add filter for units") {
+		t.Errorf("Next = %v", err)
+	}
+}
@@ -0,0 +1,490 @@
+package source
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s-log-etl/internal/logger"
+	"k8s-log-etl/internal/natsconn"
+	"k8s-log-etl/internal/report"
+)
+
+// NATS input defaults, those of a JetStream consumer.
+const (
+	DefaultNATSMaxAckPending = 1000
+	DefaultNATSAckWait       = 30 * time.Second
+)
+
+// Timings of the requests to JetStream; variables so tests can shorten
+// them.
+var (
+	// natsPullExpires is how long a pull request waits for messages
+	// before the server ends it.
+	natsPullExpires = 5 * time.Second
+	// natsAPITimeout bounds creating the consumer.
+	natsAPITimeout = 10 * time.Second
+	// natsReconnectWait is the first wait between reconnect attempts; it
+	// doubles up to natsReconnectMax.
+	natsReconnectWait = time.Second
+	natsReconnectMax  = 30 * time.Second
+	// natsFlushTimeout bounds how long closing waits for the server to
+	// confirm it has the last acks.
+	natsFlushTimeout = 5 * time.Second
+)
+
+// errNATSClosed is returned by Next once the source is closed.
+var errNATSClosed = errors.New("nats input is closed")
+
+// NATSOptions configures a NATS source.
+type NATSOptions struct {
+	// Servers are tried in turn: nats://host:port, or tls://host:port to
+	// require TLS. User and password may be given in the URL.
+	Servers  []string
+	User     string
+	Password string
+	Token    string
+	// CredsFile holds a user JWT and NKey seed, as nsc writes them.
+	CredsFile string
+	Stream    string
+	// Consumer is the durable pull consumer, created or updated.
+	Consumer      string
+	FilterSubject string
+	// MaxAckPending caps the messages read and not yet acknowledged.
+	MaxAckPending int
+	// AckWait is how long the server waits for an ack before it delivers
+	// a message again.
+	AckWait time.Duration
+}
+
+// NATS reads the messages of a JetStream pull consumer as records.
+// Pulling starts with the first Next and asks for as many messages as msgs
+// has room for, which is MaxAckPending: the server delivers no more until
+// some are acknowledged, so the messages read and not yet written never
+// exceed it. A record's Ack acknowledges its message; a message that is
+// never acknowledged is redelivered after AckWait. A lost connection is
+// reconnected, and acks that could not be sent are redelivered too.
+type NATS struct {
+	servers  []string
+	auth     natsconn.Auth
+	stream   string
+	consumer string
+	inbox    string // reply subject prefix, ending in "."
+
+	msgs      chan Record
+	halt      chan struct{} // closed when reading stops
+	haltOnce  sync.Once
+	startOnce sync.Once
+	err       error // returned by every Next once reading stopped
+
+	mu     sync.Mutex
+	conn   *natsconn.Conn // nil when not connected
+	next   int            // servers index to try first
+	api    chan natsconn.Msg
+	pull   *natsPull // the pull request waiting for messages
+	stats  report.NATSInputStats
+	closed bool
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// natsPull is an outstanding pull request.
+type natsPull struct {
+	want, got int
+	done      chan struct{} // closed when the server ends the request
+}
+
+// NewNATS connects to the first of the servers that answers and creates
+// or updates the durable consumer.
+func NewNATS(opts NATSOptions) (*NATS, error) {
+	if opts.MaxAckPending <= 0 {
+		opts.MaxAckPending = DefaultNATSMaxAckPending
+	}
+	if opts.AckWait <= 0 {
+		opts.AckWait = DefaultNATSAckWait
+	}
+	var id [12]byte
+	rand.Read(id[:])
+	s := &NATS{
+		servers:  opts.Servers,
+		auth:     natsconn.Auth{User: opts.User, Password: opts.Password, Token: opts.Token},
+		stream:   opts.Stream,
+		consumer: opts.Consumer,
+		inbox:    "_INBOX." + hex.EncodeToString(id[:]) + ".",
+		msgs:     make(chan Record, opts.MaxAckPending),
+		halt:     make(chan struct{}),
+		api:      make(chan natsconn.Msg, 1),
+		stop:     make(chan struct{}),
+	}
+	s.stats.Stream, s.stats.Consumer = s.stream, s.consumer
+	if opts.CredsFile != "" {
+		var err error
+		if s.auth.JWT, s.auth.Seed, err = natsconn.ReadCreds(opts.CredsFile); err != nil {
+			return nil, fmt.Errorf("nats creds file: %w", err)
+		}
+	}
+	c, err := s.connection()
+	if err != nil {
+		return nil, err
+	}
+	consumer := map[string]any{
+		"durable_name":    s.consumer,
+		"ack_policy":      "explicit",
+		"ack_wait":        opts.AckWait.Nanoseconds(),
+		"max_ack_pending": opts.MaxAckPending,
+		"deliver_policy":  "all",
+	}
+	if opts.FilterSubject != "" {
+		consumer["filter_subject"] = opts.FilterSubject
+	}
+	subject := fmt.Sprintf("$JS.API.CONSUMER.DURABLE.CREATE.%s.%s", s.stream, s.consumer)
+	if err := s.request(c, subject, map[string]any{"stream_name": s.stream, "config": consumer}); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("create nats consumer %s on stream %s: %w", s.consumer, s.stream, err)
+	}
+	logger.Info("reading nats consumer", "stream", s.stream, "consumer", s.consumer, "max_ack_pending", opts.MaxAckPending, "ack_wait", opts.AckWait.String())
+	return s, nil
+}
+
+// connection returns the connection, connecting to the servers in turn
+// when there is none. Only NewNATS, then the pull loop, call it.
+func (s *NATS) connection() (*natsconn.Conn, error) {
+	s.mu.Lock()
+	c, start := s.conn, s.next
+	s.mu.Unlock()
+	if c != nil {
+		return c, nil
+	}
+	var errs []error
+	for i := range s.servers {
+		n := (start + i) % len(s.servers)
+		c, err := natsconn.Dial(s.servers[n], s.auth)
+		if err == nil {
+			err = c.Subscribe(s.inbox+"*", 1)
+			if err != nil {
+				c.Close()
+			}
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			c.Close()
+			return nil, errNATSClosed
+		}
+		s.conn, s.next = c, n
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.readLoop(c)
+		return c, nil
+	}
+	if len(errs) == 0 {
+		return nil, errors.New("no nats server URL")
+	}
+	return nil, errors.Join(errs...)
+}
+
+// request sends a JetStream API request and checks its reply for an
+// error. Only one request may be outstanding at a time.
+func (s *NATS) request(c *natsconn.Conn, subject string, body any) error {
+	select {
+	case <-s.api: // a late reply to an earlier request
+	default:
+	}
+	data, _ := json.Marshal(body)
+	if err := c.Publish(subject, s.inbox+"api", "", data); err != nil {
+		return err
+	}
+	select {
+	case m := <-s.api:
+		if status := m.Status(); strings.HasPrefix(status, "503") {
+			return errors.New("JetStream is not enabled on the server (503 no responders)")
+		} else if status != "" {
+			return fmt.Errorf("status %s", status)
+		}
+		var reply struct {
+			Error *struct {
+				Code        int    `json:"code"`
+				Description string `json:"description"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(m.Data, &reply); err != nil {
+			return fmt.Errorf("unreadable reply %q", m.Data)
+		}
+		if reply.Error != nil {
+			return fmt.Errorf("jetstream error %d: %s", reply.Error.Code, reply.Error.Description)
+		}
+		return nil
+	case <-time.After(natsAPITimeout):
+		return fmt.Errorf("no reply within %s", natsAPITimeout)
+	}
+}
+
+// readLoop reads what the server sends on c until the connection fails:
+// API replies and the messages of pull requests.
+func (s *NATS) readLoop(c *natsconn.Conn) {
+	defer s.wg.Done()
+	for {
+		m, err := c.ReadMsg()
+		if err != nil {
+			s.lost(c, err)
+			return
+		}
+		switch strings.TrimPrefix(m.Subject, s.inbox) {
+		case "api":
+			select {
+			case s.api <- m:
+			default:
+			}
+		case "pull":
+			s.delivered(m)
+		}
+	}
+}
+
+// delivered hands a message of a pull request to Next, or ends the
+// request on a status: 404 or 408 when it got fewer messages than it
+// asked for, 409 when the server cut it short.
+func (s *NATS) delivered(m natsconn.Msg) {
+	if status := m.Status(); status != "" {
+		if strings.HasPrefix(status, "100") {
+			return // an idle heartbeat
+		}
+		if strings.HasPrefix(status, "409") {
+			logger.Warn("nats pull request ended by the server", "status", status)
+		}
+		s.mu.Lock()
+		if s.pull != nil {
+			close(s.pull.done)
+			s.pull = nil
+		}
+		s.mu.Unlock()
+		return
+	}
+	delivered, seq := parseJSAck(m.Reply)
+	reply := m.Reply
+	rec := Record{
+		Data:   m.Data,
+		Origin: Origin{File: "nats:" + s.stream, Line: int(seq)},
+		Ack: func(ok bool) {
+			if ok {
+				s.acknowledge(reply)
+			}
+		},
+	}
+	s.mu.Lock()
+	s.stats.Received++
+	if delivered > 1 {
+		s.stats.Redelivered++
+	}
+	s.stats.MaxDeliveries = max(s.stats.MaxDeliveries, delivered)
+	if p := s.pull; p != nil {
+		p.got++
+		if p.got >= p.want {
+			close(p.done)
+			s.pull = nil
+		}
+	}
+	s.mu.Unlock()
+	// The server delivers no more than max_ack_pending unacknowledged
+	// messages, which is what msgs holds, so this only waits for a
+	// message left over from a pull request that timed out.
+	select {
+	case s.msgs <- rec:
+	case <-s.stop:
+	}
+}
+
+// acknowledge acks the message with the ack subject reply. A failed ack
+// leaves the message to be redelivered.
+func (s *NATS) acknowledge(reply string) {
+	s.mu.Lock()
+	c := s.conn
+	s.mu.Unlock()
+	if c == nil || reply == "" {
+		return
+	}
+	if err := c.Publish(reply, "", "", []byte("+ACK")); err != nil {
+		logger.Warn("nats ack failed, the message will be redelivered", "error", err)
+		return
+	}
+	s.mu.Lock()
+	s.stats.Acked++
+	s.mu.Unlock()
+}
+
+// lost drops c after it failed; the pull loop reconnects.
+func (s *NATS) lost(c *natsconn.Conn, err error) {
+	c.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != c {
+		return
+	}
+	s.conn = nil
+	s.next++
+	if s.pull != nil {
+		close(s.pull.done)
+		s.pull = nil
+	}
+	if !s.closed {
+		logger.Warn("nats input connection lost, reconnecting", "error", err)
+	}
+}
+
+// pullLoop keeps a pull request outstanding for the room left in msgs,
+// reconnecting with backoff when the connection is lost, until reading
+// stops.
+func (s *NATS) pullLoop() {
+	defer s.wg.Done()
+	wait := natsReconnectWait
+	for {
+		c, err := s.connection()
+		if err != nil {
+			logger.Warn("nats input cannot reconnect, retrying", "error", err, "wait", wait.String())
+			if !s.sleep(wait) {
+				return
+			}
+			wait = min(wait*2, natsReconnectMax)
+			continue
+		}
+		wait = natsReconnectWait
+		room := cap(s.msgs) - len(s.msgs)
+		if room == 0 {
+			if !s.sleep(10 * time.Millisecond) {
+				return
+			}
+			continue
+		}
+		p := &natsPull{want: room, done: make(chan struct{})}
+		s.mu.Lock()
+		s.pull = p
+		s.mu.Unlock()
+		req, _ := json.Marshal(map[string]any{"batch": room, "expires": natsPullExpires.Nanoseconds()})
+		subject := fmt.Sprintf("$JS.API.CONSUMER.MSG.NEXT.%s.%s", s.stream, s.consumer)
+		if err := c.Publish(subject, s.inbox+"pull", "", req); err != nil {
+			s.lost(c, err)
+			continue
+		}
+		select {
+		case <-p.done:
+		case <-time.After(natsPullExpires + natsAPITimeout):
+			// The server should have ended it by now; ask again.
+			s.mu.Lock()
+			if s.pull == p {
+				s.pull = nil
+			}
+			s.mu.Unlock()
+		case <-s.stop:
+			return
+		case <-s.halt:
+			return
+		}
+	}
+}
+
+// sleep waits d, reporting false if reading stops first.
+func (s *NATS) sleep(d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-s.stop:
+	case <-s.halt:
+	}
+	return false
+}
+
+// Next returns the next message, waiting for one. Once ctx is done it
+// stops pulling and returns ctx's error, leaving the messages it holds
+// unacknowledged for redelivery.
+func (s *NATS) Next(ctx context.Context) (Record, error) {
+	if s.err != nil {
+		return Record{}, s.err
+	}
+	s.startOnce.Do(func() {
+		s.wg.Add(1)
+		go s.pullLoop()
+	})
+	// Check for a stop first, so a full buffer cannot keep the run going.
+	if err := ctx.Err(); err != nil {
+		return s.fail(err)
+	}
+	select {
+	case rec := <-s.msgs:
+		return rec, nil
+	case <-ctx.Done():
+		return s.fail(ctx.Err())
+	case <-s.stop:
+		return s.fail(errNATSClosed)
+	}
+}
+
+// fail stops reading with err.
+func (s *NATS) fail(err error) (Record, error) {
+	s.err = err
+	s.haltOnce.Do(func() { close(s.halt) })
+	return Record{}, err
+}
+
+// Report implements Reporter with the nats_input section.
+func (s *NATS) Report(rep *report.Report) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.stats
+	st.Unacked = st.Received - st.Acked
+	rep.SetNATSInput(st)
+}
+
+// Close stops pulling, makes sure the server has the acks sent so far,
+// and closes the connection. Messages acknowledged after Close are left
+// for redelivery.
+func (s *NATS) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	c := s.conn
+	s.mu.Unlock()
+	close(s.stop)
+	if c != nil {
+		if err := c.Ping(natsFlushTimeout); err != nil {
+			logger.Warn("nats input closed without confirming its last acks", "error", err)
+		}
+		s.lost(c, errNATSClosed)
+	}
+	s.wg.Wait()
+}
+
+// parseJSAck returns the delivery count and stream sequence from the ack
+// subject of a JetStream message: $JS.ACK.<stream>.<consumer>.<delivered>.
+// <stream seq>..., with a domain and account hash after ACK on newer
+// servers. It returns 1 and 0 when reply is not one.
+func parseJSAck(reply string) (int, uint64) {
+	t := strings.Split(reply, ".")
+	switch {
+	case len(t) < 9 || t[0] != "$JS" || t[1] != "ACK":
+		return 1, 0
+	case len(t) == 9:
+		t = t[2:]
+	default:
+		t = t[4:]
+	}
+	delivered, err := strconv.Atoi(t[2])
+	if err != nil || delivered < 1 {
+		delivered = 1
+	}
+	seq, _ := strconv.ParseUint(t[3], 10, 64)
+	return delivered, seq
+}
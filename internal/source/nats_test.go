@@ -0,0 +1,234 @@
+package source
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s-log-etl/internal/report"
+)
+
+// fakeJetStream is an in-process NATS server with one stream, LOGS,
+// behind a pull consumer. A message delivered and not acked within the
+// consumer's ack_wait is delivered again.
+type fakeJetStream struct {
+	ln net.Listener
+
+	mu        sync.Mutex
+	msgs      []string
+	delivered []int       // delivery count by stream sequence - 1
+	due       []time.Time // when an unacked delivery is redelivered
+	acked     []bool
+	ackWait   time.Duration
+	consumer  string
+}
+
+func newFakeJetStream(t *testing.T, msgs ...string) *fakeJetStream {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeJetStream{ln: ln, msgs: msgs, delivered: make([]int, len(msgs)), due: make([]time.Time, len(msgs)), acked: make([]bool, len(msgs))}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeJetStream) serve(conn net.Conn) {
+	defer conn.Close()
+	io.WriteString(conn, "INFO {\"server_id\":\"fake\",\"headers\":true,\"max_payload\":1048576}\r\n")
+	br := bufio.NewReader(conn)
+	var wmu sync.Mutex
+	send := func(s string) {
+		wmu.Lock()
+		io.WriteString(conn, s)
+		wmu.Unlock()
+	}
+	sid := "1"
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return
+		}
+		op, args, _ := strings.Cut(strings.TrimSpace(line), " ")
+		switch op {
+		case "PING":
+			send("PONG\r\n")
+		case "SUB":
+			sid = strings.Fields(args)[1]
+		case "PUB":
+			a := strings.Fields(args)
+			size, _ := strconv.Atoi(a[len(a)-1])
+			buf := make([]byte, size+2)
+			if _, err := io.ReadFull(br, buf); err != nil {
+				return
+			}
+			data := buf[:size]
+			reply := ""
+			if len(a) == 3 {
+				reply = a[1]
+			}
+			switch subject := a[0]; {
+			case strings.HasPrefix(subject, "$JS.API.CONSUMER.DURABLE.CREATE.LOGS."):
+				var req struct {
+					Config struct {
+						AckWait int64 `json:"ack_wait"`
+					} `json:"config"`
+				}
+				json.Unmarshal(data, &req)
+				f.mu.Lock()
+				f.ackWait = time.Duration(req.Config.AckWait)
+				f.consumer = strings.TrimPrefix(subject, "$JS.API.CONSUMER.DURABLE.CREATE.LOGS.")
+				f.mu.Unlock()
+				body := `{"type":"io.nats.jetstream.api.v1.consumer_create_response","name":"` + f.consumer + `"}`
+				send(fmt.Sprintf("MSG %s %s %d\r\n%s\r\n", reply, sid, len(body), body))
+			case strings.HasPrefix(subject, "$JS.API.CONSUMER.MSG.NEXT.LOGS."):
+				var req struct {
+					Batch   int   `json:"batch"`
+					Expires int64 `json:"expires"`
+				}
+				json.Unmarshal(data, &req)
+				go f.pull(send, sid, reply, req.Batch, time.Duration(req.Expires))
+			case strings.HasPrefix(subject, "$JS.ACK.LOGS."):
+				if string(data) != "+ACK" {
+					continue
+				}
+				_, seq := parseJSAck(subject)
+				f.mu.Lock()
+				f.acked[seq-1] = true
+				f.mu.Unlock()
+			}
+		}
+	}
+}
+
+// pull delivers up to batch messages to reply until expires passes, then
+// ends the request with a 408 as JetStream does.
+func (f *fakeJetStream) pull(send func(string), sid, reply string, batch int, expires time.Duration) {
+	deadline := time.Now().Add(expires)
+	for time.Now().Before(deadline) {
+		f.mu.Lock()
+		for i, msg := range f.msgs {
+			if batch == 0 {
+				break
+			}
+			if f.acked[i] || (f.delivered[i] > 0 && time.Now().Before(f.due[i])) {
+				continue
+			}
+			f.delivered[i]++
+			f.due[i] = time.Now().Add(f.ackWait)
+			batch--
+			ack := fmt.Sprintf("$JS.ACK.LOGS.%s.%d.%d.%d.%d.0", f.consumer, f.delivered[i], i+1, i+1, time.Now().UnixNano())
+			send(fmt.Sprintf("MSG %s %s %s %d\r\n%s\r\n", reply, sid, ack, len(msg), msg))
+		}
+		f.mu.Unlock()
+		if batch == 0 {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	hdr := "NATS/1.0 408 Request Timeout\r\n\r\n"
+	send(fmt.Sprintf("HMSG %s %s %d %d\r\n%s\r\n", reply, sid, len(hdr), len(hdr), hdr))
+}
+
+func (f *fakeJetStream) allAcked() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return !slices.Contains(f.acked, false)
+}
+
+func TestNATS_AcksAndRedelivers(t *testing.T) {
+	defer func(d time.Duration) { natsPullExpires = d }(natsPullExpires)
+	natsPullExpires = 100 * time.Millisecond
+	srv := newFakeJetStream(t, `{"n":1}`, `{"n":2}`, `{"n":3}`)
+	s, err := NewNATS(NATSOptions{
+		Servers:  []string{"nats://" + srv.ln.Addr().String()},
+		Stream:   "LOGS",
+		Consumer: "etl",
+		AckWait:  200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewNATS: %v", err)
+	}
+	defer s.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// The last message is failed, so the server delivers it again.
+	for i := 1; i <= 3; i++ {
+		rec, err := s.Next(ctx)
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		if want := (Origin{File: "nats:LOGS", Line: i}); rec.Origin != want || string(rec.Data) != fmt.Sprintf(`{"n":%d}`, i) {
+			t.Fatalf("record %d = %s from %+v", i, rec.Data, rec.Origin)
+		}
+		rec.Ack(i < 3)
+	}
+	rec, err := s.Next(ctx)
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	if rec.Origin.Line != 3 {
+		t.Fatalf("redelivered %+v, want stream sequence 3", rec.Origin)
+	}
+	rec.Ack(true)
+	for !srv.allAcked() && ctx.Err() == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !srv.allAcked() {
+		t.Fatalf("acked %v", srv.acked)
+	}
+
+	rep := report.NewReport()
+	s.Report(rep)
+	want := report.NATSInputStats{Stream: "LOGS", Consumer: "etl", Received: 4, Acked: 3, Unacked: 1, Redelivered: 1, MaxDeliveries: 2}
+	if rep.NATSInput == nil || *rep.NATSInput != want {
+		t.Errorf("nats_input = %+v, want %+v", rep.NATSInput, want)
+	}
+
+	// With nothing left, Next waits until ctx is done, and stays stopped.
+	short, stop := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer stop()
+	if _, err := s.Next(short); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Next = %v, want the context's error", err)
+	}
+	if _, err := s.Next(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Next after stopping = %v, want the same error", err)
+	}
+}
+
+func TestParseJSAck(t *testing.T) {
+	for _, tc := range []struct {
+		reply     string
+		delivered int
+		seq       uint64
+	}{
+		{"$JS.ACK.LOGS.etl.3.42.7.1700000000000000000.0", 3, 42},
+		{"$JS.ACK.hub.acchash.LOGS.etl.2.9.5.1700000000000000000.4.tok", 2, 9},
+		{"_INBOX.x", 1, 0},
+	} {
+		if d, seq := parseJSAck(tc.reply); d != tc.delivered || seq != tc.seq {
+			t.Errorf("parseJSAck(%q) = %d, %d; want %d, %d", tc.reply, d, seq, tc.delivered, tc.seq)
+		}
+	}
+}
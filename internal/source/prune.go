@@ -0,0 +1,356 @@
+package source
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"k8s-log-etl/internal/stages"
+)
+
+// LineLimits bound the memory one JSONL line can take. A line longer than
+// Prune bytes is not held: it is scanned as it is read, keeping only the
+// top-level keys normalization reads, and the Keep keys. A line longer
+// than Max bytes is skipped. Zero turns a limit off.
+type LineLimits struct {
+	Prune int
+	Max   int
+	// Keep names more top-level keys to keep, such as unwrap_keys whose
+	// string value holds the record normalization reads. Their values are
+	// kept whole, bounded by Max only, as pruning cannot reach into them.
+	Keep []string
+}
+
+// LargeLine describes a line longer than LineLimits.Prune or
+// LineLimits.Max.
+type LargeLine struct {
+	// Size is how many bytes the line takes in the input, its line ending
+	// included.
+	Size int64
+	// TooLong is set for a line longer than LineLimits.Max; its Data is
+	// empty.
+	TooLong bool
+	// Dropped counts the top-level keys pruning left out of Data, kept keys
+	// whose value was longer than LineLimits.Prune among them.
+	Dropped int
+}
+
+// TruncatedKey is set to true in a pruned line, so the record's fields
+// show that some of them are missing.
+const TruncatedKey = "fields_truncated"
+
+// maxPrunedKey bounds how much of a key the pruner holds; no input key is
+// anywhere near it.
+const maxPrunedKey = 64
+
+var (
+	errLineTooLong = errors.New("line too long")
+	errValueTooBig = errors.New("value too big")
+)
+
+// lineRest reads one line byte by byte: head, the part already read, then
+// br up to the next '\n', which it consumes and reports as io.EOF. Past
+// max bytes, when max is set, it fails with errLineTooLong.
+type lineRest struct {
+	head   []byte
+	br     *bufio.Reader
+	max    int64
+	n      int64 // bytes handed out
+	done   bool  // the line ending was read, or reading failed
+	err    error // why reading failed
+	unread bool
+	last   byte
+}
+
+func (l *lineRest) ReadByte() (byte, error) {
+	if l.unread {
+		l.unread = false
+		return l.last, nil
+	}
+	if l.done {
+		return 0, io.EOF
+	}
+	var c byte
+	if len(l.head) > 0 {
+		c, l.head = l.head[0], l.head[1:]
+	} else {
+		var err error
+		if c, err = l.br.ReadByte(); err != nil {
+			l.done = true
+			if err != io.EOF {
+				l.err = err
+			}
+			return 0, err
+		}
+	}
+	if c == '\n' {
+		l.done = true
+		return 0, io.EOF
+	}
+	if l.n++; l.max > 0 && l.n > l.max {
+		return 0, errLineTooLong
+	}
+	l.last = c
+	return c, nil
+}
+
+// unreadByte gives back the byte ReadByte last returned.
+func (l *lineRest) unreadByte() {
+	l.unread = true
+}
+
+// drain reads what is left of the line without keeping it. It returns
+// the error that ended reading the line, if any.
+func (l *lineRest) drain() error {
+	if l.done {
+		return l.err
+	}
+	l.done = true
+	if bytes.IndexByte(l.head, '\n') >= 0 {
+		return nil // br is already past the line
+	}
+	for {
+		_, err := l.br.ReadSlice('\n')
+		switch err {
+		case bufio.ErrBufferFull:
+			continue
+		case io.EOF:
+			return nil
+		}
+		return err
+	}
+}
+
+// pruneLine scans the JSON object rest reads and appends to out an object
+// with only its stages.InputKeys and limits.Keep keys, each value copied
+// as is, and TruncatedKey. An input key's value longer than limits.Prune
+// is left out too. It returns how many keys it left out. An error means
+// the line is not an object or rest failed; the object is not checked
+// beyond finding where its values end, which the parser does with what
+// was kept.
+func pruneLine(rest *lineRest, limits LineLimits, out []byte) ([]byte, int, error) {
+	s := pruner{r: rest}
+	dropped := 0
+	c, err := s.skipSpace()
+	if err != nil {
+		return out, 0, err
+	}
+	if c != '{' {
+		return out, 0, fmt.Errorf("line is not a JSON object")
+	}
+	out = append(out, '{')
+	if c, err = s.skipSpace(); err != nil {
+		return out, 0, err
+	}
+	for c != '}' {
+		if c != '"' {
+			return out, 0, fmt.Errorf("expected a key, got %q", c)
+		}
+		key, err := s.key()
+		if err != nil {
+			return out, 0, err
+		}
+		if c, err = s.skipSpace(); err != nil {
+			return out, 0, err
+		}
+		if c != ':' {
+			return out, 0, fmt.Errorf("expected ':' after key %q, got %q", key, c)
+		}
+		if keep := slices.Contains(limits.Keep, key); keep || slices.Contains(stages.InputKeys, key) {
+			s.limit = limits.Prune
+			if keep {
+				s.limit = 0
+			}
+			mark := len(out)
+			out = append(out, '"')
+			out = append(out, key...)
+			out = append(out, '"', ':')
+			out, err = s.value(out)
+			switch {
+			case errors.Is(err, errValueTooBig):
+				out, err = out[:mark], nil
+				dropped++
+			case err == nil:
+				out = append(out, ',')
+			}
+		} else {
+			_, err = s.value(nil)
+			dropped++
+		}
+		if err != nil {
+			return out, 0, err
+		}
+		if c, err = s.skipSpace(); err != nil {
+			return out, 0, err
+		}
+		switch c {
+		case ',':
+			if c, err = s.skipSpace(); err != nil {
+				return out, 0, err
+			}
+		case '}':
+		default:
+			return out, 0, fmt.Errorf("expected ',' or '}', got %q", c)
+		}
+	}
+	out = append(out, `"`+TruncatedKey+`":true}`...)
+	return out, dropped, nil
+}
+
+// pruner copies or skips the JSON values of a line.
+type pruner struct {
+	r     *lineRest
+	limit int // bytes of a value copied at most, 0 for no limit
+}
+
+// skipSpace returns the next byte that is not JSON whitespace.
+func (s *pruner) skipSpace() (byte, error) {
+	for {
+		c, err := s.r.ReadByte()
+		if err != nil {
+			return 0, unexpectedEOF(err)
+		}
+		if c != ' ' && c != '\t' && c != '\r' {
+			return c, nil
+		}
+	}
+}
+
+// key reads a key after its opening quote, keeping at most maxPrunedKey
+// bytes of it. An escaped character is kept as a zero byte: no input key
+// has one.
+func (s *pruner) key() (string, error) {
+	var key []byte
+	for {
+		c, err := s.r.ReadByte()
+		if err != nil {
+			return "", unexpectedEOF(err)
+		}
+		switch c {
+		case '"':
+			return string(key), nil
+		case '\\':
+			if _, err := s.r.ReadByte(); err != nil {
+				return "", unexpectedEOF(err)
+			}
+			c = 0
+		}
+		if len(key) < maxPrunedKey {
+			key = append(key, c)
+		}
+	}
+}
+
+// value reads the next value, appending it to out unless out is nil. A
+// value longer than limit is read to its end and then cut from out, with
+// errValueTooBig.
+func (s *pruner) value(out []byte) ([]byte, error) {
+	keep := out != nil
+	start := len(out)
+	put := func(c byte) {
+		if !keep {
+			return
+		}
+		if s.limit > 0 && len(out)-start >= s.limit {
+			keep = false
+			return
+		}
+		out = append(out, c)
+	}
+	c, err := s.skipSpace()
+	if err != nil {
+		return out, err
+	}
+	switch c {
+	case '"':
+		put(c)
+		err = s.str(put)
+	case '{', '[':
+		err = s.nested(c, put)
+	default:
+		put(c)
+		err = s.scalar(put)
+	}
+	if err != nil {
+		return out, err
+	}
+	if out != nil && !keep {
+		return out[:start], errValueTooBig
+	}
+	return out, nil
+}
+
+// str reads the rest of a string after its opening quote.
+func (s *pruner) str(put func(byte)) error {
+	for {
+		c, err := s.r.ReadByte()
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		put(c)
+		switch c {
+		case '"':
+			return nil
+		case '\\':
+			if c, err = s.r.ReadByte(); err != nil {
+				return unexpectedEOF(err)
+			}
+			put(c)
+		}
+	}
+}
+
+// nested reads an object or array from its opening c to its matching
+// close.
+func (s *pruner) nested(c byte, put func(byte)) error {
+	depth := 0
+	for {
+		put(c)
+		switch c {
+		case '"':
+			if err := s.str(put); err != nil {
+				return err
+			}
+		case '{', '[':
+			depth++
+		case '}', ']':
+			if depth--; depth == 0 {
+				return nil
+			}
+		}
+		var err error
+		if c, err = s.r.ReadByte(); err != nil {
+			return unexpectedEOF(err)
+		}
+	}
+}
+
+// scalar reads the rest of a number, true, false, or null, leaving the
+// delimiter after it unread.
+func (s *pruner) scalar(put func(byte)) error {
+	for {
+		c, err := s.r.ReadByte()
+		if err == io.EOF {
+			return nil // the line ends with a bare scalar, which the parser rejects
+		}
+		if err != nil {
+			return err
+		}
+		switch c {
+		case ',', '}', ']', ' ', '\t', '\r':
+			s.r.unreadByte()
+			return nil
+		}
+		put(c)
+	}
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,166 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestLineReaderPrunesLargeLines(t *testing.T) {
+	heap := strings.Repeat("x", 200)
+	input := strings.Join([]string{
+		`{"ts":"2024-01-01T12:00:00Z","msg":"small"}`,
+		`{"ts":"2024-01-01T12:00:00Z", "level" : "ERROR","heap":"` + heap + `","kubernetes":{"pod_name":"p","labels":{"a":"}]\"{"}},"n":[1,{"b":2}],"msg":"oom","ok":true}`,
+		`{"msg":"` + heap + `","service":"api","x\"y":1,"big":-1.5e3}` + "\r",
+		`{"skipped":"` + heap + heap + `"}`,
+		`["an array of ` + heap + `"]`,
+		`{"service":"last","pad":"` + heap + `"}`,
+	}, "\n")
+	r := NewReader(strings.NewReader(input), "", "in", LineLimits{Prune: 100, Max: 400})
+	type want struct {
+		data  string
+		large *LargeLine
+	}
+	for i, w := range []want{
+		{data: `{"ts":"2024-01-01T12:00:00Z","msg":"small"}`},
+		{data: `{"ts":"2024-01-01T12:00:00Z","level":"ERROR","kubernetes":{"pod_name":"p","labels":{"a":"}]\"{"}},"msg":"oom","fields_truncated":true}`, large: &LargeLine{Dropped: 3}},
+		{data: `{"service":"api","fields_truncated":true}`, large: &LargeLine{Dropped: 3}},
+		{large: &LargeLine{TooLong: true}},
+		{data: `["an array of ` + heap[:100-len(`["an array of `)]},
+		{data: `{"service":"last","fields_truncated":true}`, large: &LargeLine{Dropped: 1}},
+	} {
+		rec, err := r.Next(context.Background())
+		if err != nil {
+			t.Fatalf("line %d: %v", i+1, err)
+		}
+		if string(rec.Data) != w.data {
+			t.Errorf("line %d = %s, want %s", i+1, rec.Data, w.data)
+		}
+		if w.data != "" && w.data[0] == '{' && !json.Valid(rec.Data) {
+			t.Errorf("line %d is not valid JSON: %s", i+1, rec.Data)
+		}
+		if (rec.Large == nil) != (w.large == nil) {
+			t.Fatalf("line %d large = %+v, want %+v", i+1, rec.Large, w.large)
+		}
+		if rec.Large != nil && (rec.Large.TooLong != w.large.TooLong || rec.Large.Dropped != w.large.Dropped) {
+			t.Errorf("line %d large = %+v, want %+v", i+1, rec.Large, w.large)
+		}
+		if rec.Origin.Line != i+1 {
+			t.Errorf("line %d origin %+v", i+1, rec.Origin)
+		}
+	}
+	if _, err := r.Next(context.Background()); err != io.EOF {
+		t.Errorf("after the last line: %v", err)
+	}
+}
+
+func TestLineReaderPruneKeepsKeepKeys(t *testing.T) {
+	heap := strings.Repeat("x", 200)
+	input := `{"log":"{\"msg\":\"` + heap + `\"}","heap":"` + heap + `","msg":"` + heap + `"}`
+	r := NewReader(strings.NewReader(input), "", "", LineLimits{Prune: 100, Keep: []string{"log"}})
+	rec, err := r.Next(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The kept key's value is longer than Prune and stays whole; an input
+	// key's is cut as before.
+	if want := `{"log":"{\"msg\":\"` + heap + `\"}","fields_truncated":true}`; string(rec.Data) != want || rec.Large == nil || rec.Large.Dropped != 2 {
+		t.Errorf("line = %s, large %+v", rec.Data, rec.Large)
+	}
+}
+
+func TestLineReaderKeepsOffsetsPastLargeLines(t *testing.T) {
+	big := `{"msg":"` + strings.Repeat("y", 10000) + `"}`
+	input := big + "\n" + `{"msg":"after"}` + "\n"
+	r := NewReader(strings.NewReader(input), "", "", LineLimits{Prune: 64})
+	first, err := r.Next(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Large == nil || first.Large.Size != int64(len(big)+1) || first.Large.Dropped != 1 {
+		t.Errorf("large line = %+v", first.Large)
+	}
+	rec, err := r.Next(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rec.Data) != `{"msg":"after"}` || rec.Origin.Offset != int64(len(big)+1) || rec.Origin.Line != 2 {
+		t.Errorf("next line = %s at %+v", rec.Data, rec.Origin)
+	}
+}
+
+// hugeLine is one JSONL line with a heap_dump field of n bytes, generated
+// as it is read.
+type hugeLine struct {
+	head, tail string
+	n, pos     int64
+}
+
+func newHugeLine(n int64) *hugeLine {
+	return &hugeLine{
+		head: `{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"oom","service":"api","heap_dump":"`,
+		tail: `","kubernetes":{"pod_name":"api-1"}}` + "\n",
+		n:    n,
+	}
+}
+
+func (h *hugeLine) Read(p []byte) (int, error) {
+	total := int64(len(h.head)) + h.n + int64(len(h.tail))
+	if h.pos >= total {
+		return 0, io.EOF
+	}
+	i := 0
+	for ; i < len(p) && h.pos < total; i++ {
+		switch {
+		case h.pos < int64(len(h.head)):
+			p[i] = h.head[h.pos]
+		case h.pos < int64(len(h.head))+h.n:
+			p[i] = 'A' + byte(h.pos%26)
+		default:
+			p[i] = h.tail[h.pos-int64(len(h.head))-h.n]
+		}
+		h.pos++
+	}
+	return i, nil
+}
+
+func readHugeLine(tb testing.TB, n int64) Record {
+	r := NewReader(newHugeLine(n), "", "", LineLimits{Prune: 1 << 20})
+	rec, err := r.Next(context.Background())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if rec.Large == nil || rec.Large.Dropped != 1 {
+		tb.Fatalf("large = %+v", rec.Large)
+	}
+	return rec
+}
+
+func TestLineReaderHugeLineMemory(t *testing.T) {
+	const size = 32 << 20
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	rec := readHugeLine(t, size)
+	runtime.ReadMemStats(&after)
+	if got := after.TotalAlloc - before.TotalAlloc; got > 8<<20 {
+		t.Errorf("reading a %d MiB line allocated %d MiB", size>>20, got>>20)
+	}
+	want := `{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"oom","service":"api","kubernetes":{"pod_name":"api-1"},"fields_truncated":true}`
+	if string(rec.Data) != want {
+		t.Errorf("pruned line = %s", rec.Data)
+	}
+}
+
+// BenchmarkLineReaderHugeLine reads a 200 MB line; B/op stays at a few
+// times line_prune_bytes whatever the line's size.
+func BenchmarkLineReaderHugeLine(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(200 << 20)
+	for i := 0; i < b.N; i++ {
+		readHugeLine(b, 200<<20)
+	}
+}
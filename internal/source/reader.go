@@ -0,0 +1,213 @@
+package source
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"k8s-log-etl/internal/config"
+)
+
+// NewReader reads in according to format. InputAuto picks json_array when
+// the first non-whitespace byte (after any UTF-8 BOM) is '[' and JSONL
+// otherwise. file names in for the records' origin; it may be empty.
+// limits apply to JSONL lines. A read blocks until in returns, whatever
+// ctx.
+func NewReader(in io.Reader, format, file string, limits LineLimits) Source {
+	br := bufio.NewReader(in)
+	if format == "" || format == config.InputAuto {
+		format = config.InputJSONL
+		if first, ok := peekFirstByte(br); ok && first == '[' {
+			format = config.InputJSONArray
+		}
+	}
+	if format == config.InputJSONArray {
+		// json.Decoder rejects a BOM, so drop it here; JSONL lines have
+		// theirs stripped by the parser.
+		r := &arrayReader{file: file}
+		if b, err := br.Peek(len(utf8BOM)); err == nil && bytes.Equal(b, utf8BOM) {
+			br.Discard(len(utf8BOM))
+			r.base = int64(len(utf8BOM))
+		}
+		r.dec = json.NewDecoder(br)
+		return r
+	}
+	return newLineReader(br, file, limits)
+}
+
+// lineReader reads lines, tracking the position of the current one. A
+// line over its limits is pruned or skipped as it is read; see
+// LineLimits.
+type lineReader struct {
+	br     *bufio.Reader
+	in     *countingReader
+	limits LineLimits
+	file   string
+	line   int
+	buf    []byte // the current line
+	pruned []byte // the current line, pruned
+	large  LargeLine
+}
+
+func newLineReader(in io.Reader, file string, limits LineLimits) *lineReader {
+	cr := &countingReader{r: in}
+	return &lineReader{br: bufio.NewReader(cr), in: cr, limits: limits, file: file}
+}
+
+// pos is the offset of the first byte not yet read.
+func (r *lineReader) pos() int64 {
+	return r.in.n - int64(r.br.Buffered())
+}
+
+// over reports whether a line of n bytes is past a limit.
+func (r *lineReader) over(n int) bool {
+	return r.limits.Prune > 0 && n > r.limits.Prune || r.limits.Max > 0 && n > r.limits.Max
+}
+
+// Next returns the next line. Like bufio.ScanLines, it drops the line
+// ending, "\r\n" or "\n", and returns a last line without one.
+func (r *lineReader) Next(context.Context) (Record, error) {
+	offset := r.pos()
+	r.buf = r.buf[:0]
+	for {
+		chunk, err := r.br.ReadSlice('\n')
+		r.buf = append(r.buf, chunk...)
+		if err == bufio.ErrBufferFull {
+			if r.over(len(r.buf)) {
+				return r.largeLine(offset)
+			}
+			continue
+		}
+		if err == io.EOF {
+			if len(r.buf) == 0 {
+				return Record{}, io.EOF
+			}
+		} else if err != nil {
+			return Record{}, err
+		}
+		break
+	}
+	line := bytes.TrimSuffix(bytes.TrimSuffix(r.buf, []byte("\n")), []byte("\r"))
+	if r.over(len(line)) {
+		return r.largeLine(offset)
+	}
+	r.line++
+	return Record{Data: line, Origin: Origin{File: r.file, Line: r.line, Offset: offset}}, nil
+}
+
+// largeLine reads the rest of a line found to be past a limit, the start
+// of which is in r.buf. A line past Max is skipped. Otherwise it is
+// pruned; a line that is not a JSON object is cut at Prune bytes instead,
+// so it fails to parse as it would have whole.
+func (r *lineReader) largeLine(offset int64) (Record, error) {
+	rest := &lineRest{head: r.buf, br: r.br, max: int64(r.limits.Max)}
+	r.large = LargeLine{}
+	var data []byte
+	err := errLineTooLong
+	if r.limits.Prune > 0 {
+		r.pruned, r.large.Dropped, err = pruneLine(rest, r.limits, r.pruned[:0])
+		data = r.pruned
+	}
+	large := &r.large
+	switch {
+	case errors.Is(err, errLineTooLong):
+		data, large.TooLong, large.Dropped = nil, true, 0
+	case err != nil:
+		data, large = r.buf[:min(len(r.buf), r.limits.Prune)], nil
+	}
+	if err := rest.drain(); err != nil {
+		return Record{}, err
+	}
+	r.line++
+	rec := Record{Data: data, Origin: Origin{File: r.file, Line: r.line, Offset: offset}}
+	if large != nil {
+		large.Size = r.pos() - offset
+		rec.Large = large
+	}
+	return rec, nil
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// peekFirstByte returns the first byte of br that is not whitespace or part
+// of a leading BOM, without consuming anything. It peeks one byte at a time
+// so a slow stdin producer is never waited on for more than that.
+func peekFirstByte(br *bufio.Reader) (byte, bool) {
+	for n := 1; n <= br.Size(); n++ {
+		b, err := br.Peek(n)
+		if err != nil {
+			return 0, false
+		}
+		if len(b) < len(utf8BOM) && bytes.HasPrefix(utf8BOM, b) {
+			continue // possibly a partial BOM
+		}
+		if rest := bytes.TrimLeft(bytes.TrimPrefix(b, utf8BOM), " \t\r\n"); len(rest) > 0 {
+			return rest[0], true
+		}
+	}
+	return 0, false
+}
+
+// arrayReader streams the elements of a top-level JSON array, holding only
+// the current element in memory.
+type arrayReader struct {
+	dec     *json.Decoder
+	file    string
+	base    int64 // bytes dropped before dec, a BOM
+	started bool
+	err     error // returned by every Next once set
+	raw     json.RawMessage
+	n       int
+}
+
+// Next returns the next array element. The decoder cannot resynchronize
+// after a syntax error, so one ends the input.
+func (r *arrayReader) Next(context.Context) (Record, error) {
+	if r.err != nil {
+		return Record{}, r.err
+	}
+	if !r.started {
+		r.started = true
+		tok, err := r.dec.Token()
+		if err != nil {
+			return r.fail(fmt.Errorf("read json array: %w", err))
+		}
+		if d, ok := tok.(json.Delim); !ok || d != '[' {
+			return r.fail(fmt.Errorf("read json array: expected '[', got %v", tok))
+		}
+	}
+	if !r.dec.More() {
+		if _, err := r.dec.Token(); err != nil {
+			return r.fail(fmt.Errorf("read json array: %w", err))
+		}
+		return r.fail(io.EOF)
+	}
+	if err := r.dec.Decode(&r.raw); err != nil {
+		return r.fail(fmt.Errorf("read json array element: %w", err))
+	}
+	r.n++
+	// The decoder has just read past the element.
+	offset := r.base + r.dec.InputOffset() - int64(len(r.raw))
+	return Record{Data: r.raw, Origin: Origin{File: r.file, Line: r.n, Offset: offset}}, nil
+}
+
+func (r *arrayReader) fail(err error) (Record, error) {
+	r.err = err
+	return Record{}, err
+}
@@ -0,0 +1,134 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"k8s-log-etl/internal/config"
+)
+
+// drain reads s to its end, returning the records' data and the error
+// that ended it, nil for io.EOF.
+func drain(t *testing.T, s Source) ([]string, []Origin, error) {
+	t.Helper()
+	var data []string
+	var origins []Origin
+	for {
+		rec, err := s.Next(context.Background())
+		if err == io.EOF {
+			return data, origins, nil
+		}
+		if err != nil {
+			return data, origins, err
+		}
+		data = append(data, string(rec.Data))
+		origins = append(origins, rec.Origin)
+	}
+}
+
+func TestNewReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		input   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "auto jsonl", input: "{\"a\":1}\n{\"a\":2}\n", want: []string{`{"a":1}`, `{"a":2}`}},
+		{name: "auto array", input: " \n[{\"a\":1}, {\"a\":2}]\n", want: []string{`{"a":1}`, `{"a":2}`}},
+		{name: "auto array after bom", input: "\xEF\xBB\xBF[{\"a\":1}]", want: []string{`{"a":1}`}},
+		{name: "explicit array", format: config.InputJSONArray, input: `[{"a":1},5]`, want: []string{`{"a":1}`, `5`}},
+		{name: "explicit jsonl", format: config.InputJSONL, input: "[1]\n", want: []string{`[1]`}},
+		{name: "empty array", input: "[]", want: nil},
+		{name: "not an array", format: config.InputJSONArray, input: `{"a":1}`, wantErr: true},
+		{name: "malformed element", input: `[{"a":1},{"a":]`, want: []string{`{"a":1}`}, wantErr: true},
+		{name: "unterminated array", input: `[{"a":1}`, want: []string{`{"a":1}`}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewReader(strings.NewReader(tt.input), tt.format, "", LineLimits{})
+			got, _, err := drain(t, s)
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("records = %q, want %q", got, tt.want)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if _, again := s.Next(context.Background()); again != err {
+					t.Errorf("Next after error = %v, want %v", again, err)
+				}
+			}
+		})
+	}
+}
+
+// endlessArray is an infinite JSON array of identical elements.
+type endlessArray struct {
+	elem []byte
+	read int64
+	pos  int
+	open bool
+}
+
+func (e *endlessArray) Read(p []byte) (int, error) {
+	n := 0
+	if !e.open {
+		p[0] = '['
+		e.open = true
+		n = 1
+	}
+	for n < len(p) {
+		c := copy(p[n:], e.elem[e.pos:])
+		n += c
+		e.pos = (e.pos + c) % len(e.elem)
+	}
+	e.read += int64(n)
+	return n, nil
+}
+
+func TestArrayReader_Streams(t *testing.T) {
+	elem := `{"ts":"2024-01-01T12:00:00Z","level":"ERROR","msg":"streamed","service":"api"},`
+	src := &endlessArray{elem: []byte(elem)}
+	s := NewReader(src, config.InputAuto, "", LineLimits{})
+
+	const records = 20000
+	for i := 0; i < records; i++ {
+		if _, err := s.Next(context.Background()); err != nil {
+			t.Fatalf("read stopped after %d records: %v", i, err)
+		}
+	}
+	// The document never ends, so reaching here already means it was not
+	// buffered whole; also check read-ahead stays small.
+	consumed := int64(records * len(elem))
+	if ahead := src.read - consumed; ahead > 256*1024 {
+		t.Errorf("read %d bytes ahead of the decoded records", ahead)
+	}
+}
+
+func TestNewReader_Origin(t *testing.T) {
+	for _, tt := range []struct {
+		name, input string
+		want        []Origin
+	}{
+		{name: "jsonl", input: "{\"a\":1}\r\n\n  {\"a\":2}\n{\"a\":3}", want: []Origin{
+			{File: "in", Line: 1, Offset: 0}, {File: "in", Line: 2, Offset: 9}, {File: "in", Line: 3, Offset: 10}, {File: "in", Line: 4, Offset: 20},
+		}},
+		{name: "array after bom", input: "\xEF\xBB\xBF[{\"a\":1},\n {\"a\":2}]", want: []Origin{
+			{File: "in", Line: 1, Offset: 4}, {File: "in", Line: 2, Offset: 14},
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, got, err := drain(t, NewReader(strings.NewReader(tt.input), config.InputAuto, "in", LineLimits{}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("origins %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
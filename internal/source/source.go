@@ -0,0 +1,58 @@
+// Package source reads the input of a run as raw records: the lines of a
+// JSONL file or stdin, the elements of a JSON array, several files one
+// after another or merged by timestamp, the messages of a NATS JetStream
+// consumer, or the entries of the systemd journal. The pipeline only sees Source, so an input type is
+// added here without touching it.
+package source
+
+import (
+	"context"
+
+	"k8s-log-etl/internal/report"
+)
+
+// Record is one raw input record.
+type Record struct {
+	// Data is the record as read: a line without its line ending, an
+	// array element, or a message body. It is only valid until the next
+	// call to Next.
+	Data []byte
+	// Origin is where the record was read from.
+	Origin Origin
+	// Large is set for a JSONL line past its LineLimits, which Data holds
+	// pruned, or not at all.
+	Large *LargeLine
+	// Ack, when set, must be called once the pipeline is done with the
+	// record: ok is true when everything read from it was written,
+	// dead-lettered, or dropped on purpose. A record the pipeline leaves
+	// behind, as a stopped run does, is never acknowledged, so an input
+	// that redelivers unacknowledged records reads it again.
+	Ack func(ok bool)
+}
+
+// Origin is where a record was read from. Line is the line number within
+// File for JSONL, counting empty lines, and the element number for a JSON
+// array; Offset is the byte offset of its first byte. File is empty for
+// an input without a name. A message of a NATS stream has "nats:<stream>"
+// as File and its stream sequence as Line; a journal entry has
+// JournaldFile and its line in journalctl's output.
+type Origin struct {
+	File   string `json:"file,omitempty"`
+	Line   int    `json:"line"`
+	Offset int64  `json:"offset"`
+}
+
+// Source yields the records of an input in order.
+type Source interface {
+	// Next returns the next record. It returns io.EOF at the end of the
+	// input and any other error that ends it early. A source that waits
+	// for records, rather than blocking in a read, gives up when ctx is
+	// done and returns its error.
+	Next(ctx context.Context) (Record, error)
+}
+
+// Reporter is implemented by sources that add a section to the report.
+// Report may be called while Next runs on another goroutine.
+type Reporter interface {
+	Report(rep *report.Report)
+}
@@ -0,0 +1,218 @@
+package stages
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// ReasonDropRule is the drop reason for records matched by DropRules.
+const ReasonDropRule = "drop_rule"
+
+// DropRules drops known-noise records by message. Each line of a drop rules
+// file is one pattern: a pattern starting with ^ matches a message prefix,
+// any other pattern a substring. Blank lines and lines starting with # are
+// ignored, and a leading \ is removed, so \# and \^ start a pattern with a
+// literal # or ^. Leading and trailing spaces are trimmed.
+//
+// All patterns are searched in a single pass over the message with an
+// Aho-Corasick automaton, so the cost of Match does not grow with the
+// number of rules. Match is safe for concurrent use.
+type DropRules struct {
+	rules []*dropRule
+	nodes []dropNode
+}
+
+type dropRule struct {
+	line    int
+	pattern string
+	prefix  bool
+	hits    atomic.Int64
+}
+
+// dropNode is an automaton state. out lists the rules, by index, whose
+// pattern ends here, including those reached through fail links.
+type dropNode struct {
+	next map[byte]int32
+	fail int32
+	out  []int32
+}
+
+// DropRuleStat is one rule and how many records it dropped.
+type DropRuleStat struct {
+	Line    int
+	Pattern string
+	Hits    int64
+}
+
+// LoadDropRules reads and compiles the drop rules file at path.
+func LoadDropRules(path string) (*DropRules, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("read drop rules: %w", err)
+	}
+	defer f.Close()
+	d, err := ParseDropRules(f)
+	if err != nil {
+		return nil, fmt.Errorf("drop rules %s: %w", path, err)
+	}
+	return d, nil
+}
+
+// ParseDropRules compiles drop rules from r.
+func ParseDropRules(r io.Reader) (*DropRules, error) {
+	d := &DropRules{nodes: []dropNode{{}}}
+	sc := bufio.NewScanner(r)
+	line := 0
+	for sc.Scan() {
+		line++
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		rule := &dropRule{line: line}
+		switch {
+		case strings.HasPrefix(text, `\`):
+			text = text[1:]
+		case strings.HasPrefix(text, "^"):
+			rule.prefix = true
+			text = text[1:]
+		}
+		if text == "" {
+			return nil, fmt.Errorf("line %d: empty pattern", line)
+		}
+		rule.pattern = text
+		d.add(rule)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	d.link()
+	return d, nil
+}
+
+// add inserts rule's pattern into the trie.
+func (d *DropRules) add(rule *dropRule) {
+	state := int32(0)
+	for i := 0; i < len(rule.pattern); i++ {
+		c := rule.pattern[i]
+		next, ok := d.nodes[state].next[c]
+		if !ok {
+			next = int32(len(d.nodes))
+			d.nodes = append(d.nodes, dropNode{})
+			if d.nodes[state].next == nil {
+				d.nodes[state].next = make(map[byte]int32)
+			}
+			d.nodes[state].next[c] = next
+		}
+		state = next
+	}
+	d.nodes[state].out = append(d.nodes[state].out, int32(len(d.rules)))
+	d.rules = append(d.rules, rule)
+}
+
+// link sets the fail links breadth first, merging each state's output with
+// that of its fail state.
+func (d *DropRules) link() {
+	queue := []int32{}
+	for _, child := range d.nodes[0].next {
+		queue = append(queue, child)
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		for c, child := range d.nodes[state].next {
+			fail := d.nodes[state].fail
+			for {
+				if next, ok := d.nodes[fail].next[c]; ok {
+					d.nodes[child].fail = next
+					break
+				}
+				if fail == 0 {
+					break
+				}
+				fail = d.nodes[fail].fail
+			}
+			d.nodes[child].out = append(d.nodes[child].out, d.nodes[d.nodes[child].fail].out...)
+			queue = append(queue, child)
+		}
+	}
+}
+
+// Len returns the number of rules.
+func (d *DropRules) Len() int { return len(d.rules) }
+
+// Match reports whether msg matches a rule and, if so, the file line of the
+// first matching rule in file order, counting a hit for it.
+func (d *DropRules) Match(msg string) (line int, ok bool) {
+	best := -1
+	state := int32(0)
+	for i := 0; i < len(msg) && best != 0; i++ {
+		c := msg[i]
+		for {
+			if next, found := d.nodes[state].next[c]; found {
+				state = next
+				break
+			}
+			if state == 0 {
+				break
+			}
+			state = d.nodes[state].fail
+		}
+		for _, idx := range d.nodes[state].out {
+			rule := d.rules[idx]
+			if rule.prefix && len(rule.pattern) != i+1 {
+				continue
+			}
+			if best < 0 || int(idx) < best {
+				best = int(idx)
+			}
+		}
+	}
+	if best < 0 {
+		return 0, false
+	}
+	rule := d.rules[best]
+	rule.hits.Add(1)
+	return rule.line, true
+}
+
+// Inherit carries the hits of rules in old over to rules of d with the same
+// pattern, so counts survive a reload of an edited file.
+func (d *DropRules) Inherit(old *DropRules) {
+	if old == nil {
+		return
+	}
+	hits := make(map[string]int64, len(old.rules))
+	for _, r := range old.rules {
+		hits[r.key()] += r.hits.Load()
+	}
+	for _, r := range d.rules {
+		r.hits.Add(hits[r.key()])
+		delete(hits, r.key())
+	}
+}
+
+// key is the rule as written in the file, without surrounding spaces.
+func (r *dropRule) key() string {
+	switch {
+	case r.prefix:
+		return "^" + r.pattern
+	case strings.ContainsAny(r.pattern[:1], `^#\`):
+		return `\` + r.pattern
+	}
+	return r.pattern
+}
+
+// Stats returns every rule in file order with its hits, including rules
+// that never matched.
+func (d *DropRules) Stats() []DropRuleStat {
+	stats := make([]DropRuleStat, len(d.rules))
+	for i, r := range d.rules {
+		stats[i] = DropRuleStat{Line: r.line, Pattern: r.key(), Hits: r.hits.Load()}
+	}
+	return stats
+}
@@ -0,0 +1,83 @@
+package stages
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDropRules_Match(t *testing.T) {
+	d, err := ParseDropRules(strings.NewReader(`# known noise
+^GET /healthz
+connection reset by peer
+
+  \^caret
+reset
+^conn
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		msg  string
+		line int
+	}{
+		{"GET /healthz 200", 2},
+		{"served GET /healthz", 0},            // a prefix rule is anchored
+		{"read: connection reset by peer", 3}, // earliest rule wins over line 6
+		{"stream reset", 6},                   // substring anywhere
+		{"connection refused", 7},             // prefix of the message
+		{"value ^caret here", 5},              // escaped ^ is literal
+		{"ok", 0},
+		{"", 0},
+	}
+	for _, tc := range tests {
+		line, ok := d.Match(tc.msg)
+		if ok != (tc.line != 0) || line != tc.line {
+			t.Errorf("Match(%q) = %d, %v; want line %d", tc.msg, line, ok, tc.line)
+		}
+	}
+	want := []DropRuleStat{
+		{2, "^GET /healthz", 1},
+		{3, "connection reset by peer", 1},
+		{5, `\^caret`, 1},
+		{6, "reset", 1},
+		{7, "^conn", 1},
+	}
+	if got := d.Stats(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Stats = %v, want %v", got, want)
+	}
+}
+
+func TestDropRules_InheritKeepsHitsOfUnchangedRules(t *testing.T) {
+	old, _ := ParseDropRules(strings.NewReader("alpha\nbeta\n"))
+	old.Match("alpha")
+	old.Match("beta")
+	old.Match("beta")
+	d, _ := ParseDropRules(strings.NewReader("# reordered\nbeta\ngamma\n"))
+	d.Inherit(old)
+	want := []DropRuleStat{{2, "beta", 2}, {3, "gamma", 0}}
+	if got := d.Stats(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Stats = %v, want %v", got, want)
+	}
+}
+
+func TestLoadDropRules(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := LoadDropRules(filepath.Join(dir, "missing.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing file: err = %v", err)
+	}
+	empty := filepath.Join(dir, "empty.txt")
+	os.WriteFile(empty, nil, 0o644)
+	if d, err := LoadDropRules(empty); err != nil || d.Len() != 0 {
+		t.Errorf("empty file: %v, %v", d, err)
+	}
+	bad := filepath.Join(dir, "bad.txt")
+	os.WriteFile(bad, []byte("ok\n^\n"), 0o644)
+	if _, err := LoadDropRules(bad); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("empty pattern: err = %v", err)
+	}
+}
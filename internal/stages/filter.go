@@ -1,47 +1,95 @@
 package stages
 
 import (
+	"slices"
 	"strings"
+	"sync/atomic"
 
 	"k8s-log-etl/internal/config"
 	"k8s-log-etl/internal/model"
 )
 
+// Drop reasons returned by FilterStage.Apply.
+const (
+	ReasonLevel   = "level"
+	ReasonService = "service"
+)
+
+// RedactedKeysField is the field redaction_audit adds to a redacted
+// record: the names of the keys removed from it, never their values.
+const RedactedKeysField = "_redacted_keys"
+
 // FilterStage applies level/service allowlists and redacts PII fields.
 type FilterStage struct {
 	levels   map[string]struct{}
 	services map[string]struct{}
-	redact   map[string]struct{}
+	// redact lists the keys to remove, sorted, and redactions counts the
+	// records each was removed from.
+	redact     []string
+	redactions map[string]*atomic.Int64
+	audit      bool
 }
 
 // NewFilterStage constructs a FilterStage from config.
 func NewFilterStage(cfg config.Config) *FilterStage {
+	redact := buildExactSet(cfg.RedactKeys)
 	fs := &FilterStage{
-		levels:   buildUpperSet(cfg.FilterLevels),
-		services: buildLowerSet(cfg.FilterSvcs),
-		redact:   buildExactSet(cfg.RedactKeys),
+		levels:     buildUpperSet(cfg.FilterLevels),
+		services:   buildLowerSet(cfg.FilterSvcs),
+		redactions: make(map[string]*atomic.Int64, len(redact)),
+		audit:      cfg.RedactionAudit,
 	}
+	for key := range redact {
+		fs.redact = append(fs.redact, key)
+		fs.redactions[key] = new(atomic.Int64)
+	}
+	slices.Sort(fs.redact)
 	return fs
 }
 
-// Apply returns true when the record should be written, mutating Fields for redaction.
-// If false, the reason is returned (e.g., "level" or "service").
-func (f *FilterStage) Apply(n *model.Normalized) (bool, string) {
+// Apply reports whether the record should be written, mutating Fields for
+// redaction. When keep is false, reason names the rule that rejected it
+// (ReasonLevel or ReasonService). Every key removed is counted in
+// Redactions and, with redaction_audit, listed in RedactedKeysField.
+func (f *FilterStage) Apply(n *model.Normalized) (keep bool, reason string) {
 	if len(f.levels) > 0 && !containsUpper(f.levels, n.Level) {
-		return false, "level"
+		return false, ReasonLevel
 	}
 	if len(f.services) > 0 && !containsLower(f.services, n.Service) {
-		return false, "service"
+		return false, ReasonService
 	}
 
 	if len(f.redact) > 0 && len(n.Fields) > 0 {
-		for key := range f.redact {
+		var removed []any
+		for _, key := range f.redact {
+			if _, ok := n.Fields[key]; !ok {
+				continue
+			}
 			delete(n.Fields, key)
+			f.redactions[key].Add(1)
+			if f.audit {
+				removed = append(removed, key)
+			}
+		}
+		if len(removed) > 0 {
+			n.Fields[RedactedKeysField] = removed
 		}
 	}
 	return true, ""
 }
 
+// Redactions returns how many records each redact key was removed from,
+// leaving out the keys never found.
+func (f *FilterStage) Redactions() map[string]int {
+	counts := make(map[string]int)
+	for key, n := range f.redactions {
+		if c := n.Load(); c > 0 {
+			counts[key] = int(c)
+		}
+	}
+	return counts
+}
+
 func buildUpperSet(values []string) map[string]struct{} {
 	set := make(map[string]struct{}, len(values))
 	for _, v := range values {
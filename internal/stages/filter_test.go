@@ -1,6 +1,7 @@
 package stages
 
 import (
+	"reflect"
 	"testing"
 
 	"k8s-log-etl/internal/config"
@@ -68,6 +69,31 @@ func TestFilterByServiceCaseInsensitive(t *testing.T) {
 	}
 }
 
+func TestFilterReasons(t *testing.T) {
+	stage := NewFilterStage(config.Config{
+		FilterLevels: []string{"ERROR"},
+		FilterSvcs:   []string{"payments"},
+	})
+
+	cases := []struct {
+		name   string
+		rec    model.Normalized
+		keep   bool
+		reason string
+	}{
+		{"level rejected", model.Normalized{Level: "INFO", Service: "payments"}, false, ReasonLevel},
+		{"service rejected", model.Normalized{Level: "ERROR", Service: "orders"}, false, ReasonService},
+		{"level checked first", model.Normalized{Level: "INFO", Service: "orders"}, false, ReasonLevel},
+		{"kept", model.Normalized{Level: "ERROR", Service: "payments"}, true, ""},
+	}
+	for _, tc := range cases {
+		keep, reason := stage.Apply(&tc.rec)
+		if keep != tc.keep || reason != tc.reason {
+			t.Errorf("%s: got keep=%v reason=%q, want keep=%v reason=%q", tc.name, keep, reason, tc.keep, tc.reason)
+		}
+	}
+}
+
 func TestFilterAllowsWhenNoRules(t *testing.T) {
 	stage := NewFilterStage(config.Config{})
 	rec := model.Normalized{Level: "debug", Service: "any"}
@@ -75,3 +101,41 @@ func TestFilterAllowsWhenNoRules(t *testing.T) {
 		t.Fatalf("expected record to pass when no filters configured, reason=%s", reason)
 	}
 }
+
+func TestFilterRedactionAudit(t *testing.T) {
+	for _, audit := range []bool{false, true} {
+		stage := NewFilterStage(config.Config{RedactKeys: []string{"user_email", "token", "phone"}, RedactionAudit: audit})
+		recs := []model.Normalized{
+			{Fields: map[string]any{"user_email": "alice@example.com", "token": "Bearer abc", "keep": "ok"}},
+			{Fields: map[string]any{"token": "Bearer def"}},
+			{Fields: map[string]any{"keep": "ok"}},
+		}
+		for i := range recs {
+			if ok, _ := stage.Apply(&recs[i]); !ok {
+				t.Fatalf("record %d dropped", i)
+			}
+		}
+		want := [][]any{{"token", "user_email"}, {"token"}, nil}
+		for i, rec := range recs {
+			got, ok := rec.Fields[RedactedKeysField]
+			if !audit {
+				if ok {
+					t.Errorf("record %d has %s without redaction_audit", i, RedactedKeysField)
+				}
+				continue
+			}
+			if want[i] == nil {
+				if ok {
+					t.Errorf("record %d, with nothing redacted, has %s = %v", i, RedactedKeysField, got)
+				}
+				continue
+			}
+			if !reflect.DeepEqual(got, want[i]) {
+				t.Errorf("record %d %s = %v, want %v", i, RedactedKeysField, got, want[i])
+			}
+		}
+		if got := stage.Redactions(); !reflect.DeepEqual(got, map[string]int{"token": 2, "user_email": 1}) {
+			t.Errorf("audit=%v: Redactions = %v", audit, got)
+		}
+	}
+}
@@ -1,34 +1,120 @@
 package stages
 
 import (
-	"errors"
+	"encoding/json"
 	"fmt"
 	"k8s-log-etl/internal/model"
+	"slices"
 	"strings"
 	"time"
 )
 
-func Normalize(raw map[string]any) (model.Normalized, error) {
-	//output of formatted normalized log
-	var output model.Normalized
+// Normalize failure reasons.
+const (
+	ReasonMissing = "missing"
+	ReasonInvalid = "invalid"
+)
 
-	// extract timestamp
+// InputKeys are the top-level input keys Normalize reads; everything else
+// ends up in Fields.
+var InputKeys = []string{
+	"ts", "time", "level", "severity", "msg", "message",
+	"service", "app", "component", "kubernetes",
+	"namespace", "pod", "node", "hostname", "trace_id", "trace",
+	"error", "err", "exception", "stacktrace", "stack", "stack_trace",
+	"caller", "source",
+}
 
-	// try raw["ts"]
-	if v, ok := raw["ts"]; ok {
-		if s, ok := v.(string); ok {
-			output.TS = strings.TrimSpace(s)
-		}
+var inputKeySet = func() map[string]bool {
+	set := make(map[string]bool, len(InputKeys))
+	for _, k := range InputKeys {
+		set[k] = true
 	}
+	return set
+}()
 
-	// fallback to raw["time"] only if TS not set yet
-	if output.TS == "" {
-		if v, ok := raw["time"]; ok {
-			if s, ok := v.(string); ok {
-				output.TS = strings.TrimSpace(s)
-			}
+// maxErrorValue bounds how much of an offending value NormalizeError quotes.
+const maxErrorValue = 64
+
+// NormalizeError describes why Normalize rejected a record.
+type NormalizeError struct {
+	Field  string // output field that failed: "ts", "message", or "level"
+	Reason string // ReasonMissing or ReasonInvalid
+	Value  any    // offending input value; nil when missing
+}
+
+func (e *NormalizeError) Error() string {
+	switch {
+	case e.Field == "ts" && e.Reason == ReasonInvalid:
+		v := fmt.Sprint(e.Value)
+		if len(v) > maxErrorValue {
+			v = v[:maxErrorValue] + "..."
 		}
+		return fmt.Sprintf("invalid timestamp %q: expected RFC3339", v)
+	case e.Field == "ts":
+		return "missing timestamp: expected ts/time in RFC3339"
+	case e.Field == "message":
+		return "missing message: expected msg/message"
+	case e.Field == "level":
+		return "missing level: expected level/severity"
 	}
+	return fmt.Sprintf("%s %s", e.Reason, e.Field)
+}
+
+// Code returns a stable identifier such as "missing_ts" or "invalid_ts"
+// for report breakdowns and DLQ reasons.
+func (e *NormalizeError) Code() string {
+	return e.Reason + "_" + e.Field
+}
+
+// NormalizeOptions are the optional behaviors of NormalizeWith.
+type NormalizeOptions struct {
+	// DeriveServiceFromPod fills an empty Service from the pod name, see
+	// ServiceFromPod, and sets Fields["service_derived"] = true.
+	DeriveServiceFromPod bool
+	// Redact lists the keys redact_keys removes. An error detail key in
+	// it is not promoted into Error, Stacktrace, or Caller but left in
+	// Fields, so FilterStage removes it like any other redacted key.
+	Redact []string
+}
+
+// errorKeys, stackKeys, and callerKeys are the input keys promoted, the
+// first one set winning, into Error, Stacktrace, and Caller.
+var (
+	errorKeys  = []string{"error", "err", "exception"}
+	stackKeys  = []string{"stacktrace", "stack", "stack_trace"}
+	callerKeys = []string{"caller", "source"}
+)
+
+// promote returns keys without those opts.Redact lists.
+func (opts NormalizeOptions) promote(keys []string) []string {
+	if len(opts.Redact) == 0 {
+		return keys
+	}
+	return slices.DeleteFunc(slices.Clone(keys), func(k string) bool { return slices.Contains(opts.Redact, k) })
+}
+
+// redactedDetail reports whether key is an error detail key opts.Redact
+// lists, which stays in Fields.
+func (opts NormalizeOptions) redactedDetail(key string) bool {
+	if !slices.Contains(opts.Redact, key) {
+		return false
+	}
+	return slices.Contains(errorKeys, key) || slices.Contains(stackKeys, key) || slices.Contains(callerKeys, key)
+}
+
+// Normalize maps a parsed log line onto model.Normalized. Failures are
+// returned as *NormalizeError.
+func Normalize(raw map[string]any) (model.Normalized, error) {
+	return NormalizeWith(raw, NormalizeOptions{})
+}
+
+// NormalizeWith is Normalize with options.
+func NormalizeWith(raw map[string]any, opts NormalizeOptions) (model.Normalized, error) {
+	//output of formatted normalized log
+	var output model.Normalized
+
+	output.TS = timestampText(raw)
 	// extract level
 
 	if v, ok := raw["level"]; ok {
@@ -147,51 +233,65 @@ func Normalize(raw map[string]any) (model.Normalized, error) {
 			}
 		}
 	}
+	// extract error details
+	output.Error = firstText(raw, opts.promote(errorKeys)...)
+	output.Stacktrace = firstText(raw, opts.promote(stackKeys)...)
+	output.Caller = firstText(raw, opts.promote(callerKeys)...)
+
 	// collect remaining fields
 	output.Fields = make(map[string]any)
 
 	for k, v := range raw {
-		if k != "ts" &&
-			k != "time" &&
-			k != "hostname" &&
-			k != "level" &&
-			k != "severity" &&
-			k != "msg" &&
-			k != "message" &&
-			k != "service" &&
-			k != "app" &&
-			k != "component" &&
-			k != "kubernetes" &&
-			k != "trace_id" &&
-			k != "trace" &&
-			k != "namespace" &&
-			k != "pod" &&
-			k != "node" {
+		if !inputKeySet[k] || opts.redactedDetail(k) {
 			output.Fields[k] = v
 		}
 	}
 
+	if opts.DeriveServiceFromPod && output.Service == "" && output.Pod != "" {
+		output.Service = ServiceFromPod(output.Pod)
+		output.Fields["service_derived"] = true
+	}
+
 	parsedTime, err := parseTimestamp(output.TS)
 	if err != nil {
 		return output, err
 	}
 	output.TS = parsedTime.Format(time.RFC3339Nano)
+	output.Time = parsedTime
 
 	if output.Message == "" {
-		return output, errors.New("missing message: expected msg/message")
+		return output, &NormalizeError{Field: "message", Reason: ReasonMissing}
 	}
 
 	if output.Level == "" {
-		return output, errors.New("missing level: expected level/severity")
+		return output, &NormalizeError{Field: "level", Reason: ReasonMissing}
 	}
 	output.Level = strings.ToUpper(output.Level)
 
 	return output, nil
 }
 
+// Timestamp returns the event time of a parsed record, read the way
+// Normalize reads it. A missing or invalid time is a *NormalizeError.
+func Timestamp(raw map[string]any) (time.Time, error) {
+	return parseTimestamp(timestampText(raw))
+}
+
+// timestampText is the trimmed ts value, falling back to time.
+func timestampText(raw map[string]any) string {
+	for _, k := range []string{"ts", "time"} {
+		if s, ok := raw[k].(string); ok {
+			if s = strings.TrimSpace(s); s != "" {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
 func parseTimestamp(ts string) (time.Time, error) {
 	if ts == "" {
-		return time.Time{}, errors.New("missing timestamp: expected ts/time in RFC3339")
+		return time.Time{}, &NormalizeError{Field: "ts", Reason: ReasonMissing}
 	}
 
 	parsed, err := time.Parse(time.RFC3339Nano, ts)
@@ -200,7 +300,105 @@ func parseTimestamp(ts string) (time.Time, error) {
 		if parsed2, err2 := time.Parse(time.RFC3339, ts); err2 == nil {
 			return parsed2, nil
 		}
-		return time.Time{}, fmt.Errorf("invalid timestamp %q: expected RFC3339", ts)
+		return time.Time{}, &NormalizeError{Field: "ts", Reason: ReasonInvalid, Value: ts}
 	}
 	return parsed, nil
 }
+
+// firstText returns the first of keys present in raw as text. Strings are
+// trimmed, lists (e.g. stack frames) are joined one element per line, and
+// other values such as error objects are JSON-encoded so nothing is lost.
+func firstText(raw map[string]any, keys ...string) string {
+	for _, k := range keys {
+		v, ok := raw[k]
+		if !ok || v == nil {
+			continue
+		}
+		var s string
+		switch x := v.(type) {
+		case string:
+			s = strings.TrimSpace(x)
+		case []any:
+			lines := make([]string, len(x))
+			for i, e := range x {
+				if es, ok := e.(string); ok {
+					lines[i] = es
+				} else {
+					lines[i] = encodeText(e)
+				}
+			}
+			s = strings.Join(lines, "\n")
+		default:
+			s = encodeText(x)
+		}
+		if s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+func encodeText(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// podSuffixAlphabet is the character set Kubernetes uses for generated name
+// suffixes and pod-template hashes (no vowels, no 0/1/3).
+const podSuffixAlphabet = "bcdfghjklmnpqrstvwxz2456789"
+
+// ServiceFromPod strips the controller-generated parts of a pod name:
+//
+//	payments-api-7d9f8b6c4-xk2lp  Deployment (pod-template hash + suffix) -> payments-api
+//	log-agent-x7k2p               DaemonSet, Job, or bare ReplicaSet      -> log-agent
+//	backup-28472910-x7k2p         CronJob (schedule minute + suffix)      -> backup
+//	postgres-0                    StatefulSet ordinal                     -> postgres
+//
+// Names that match none of these, such as bare pods, are returned unchanged.
+func ServiceFromPod(pod string) string {
+	parts := strings.Split(pod, "-")
+	last := len(parts) - 1
+	switch {
+	case last < 1:
+		return pod
+	case isDigits(parts[last]):
+		// StatefulSet ordinal.
+		return strings.Join(parts[:last], "-")
+	case len(parts[last]) == 5 && isSuffixChars(parts[last]):
+		last--
+		if last >= 1 && (isTemplateHash(parts[last]) || (len(parts[last]) >= 8 && isDigits(parts[last]))) {
+			last--
+		}
+		return strings.Join(parts[:last+1], "-")
+	}
+	return pod
+}
+
+// isTemplateHash reports whether s looks like a pod-template-hash.
+func isTemplateHash(s string) bool {
+	return len(s) >= 6 && len(s) <= 10 && isSuffixChars(s)
+}
+
+func isSuffixChars(s string) bool {
+	for _, c := range s {
+		if !strings.ContainsRune(podSuffixAlphabet, c) {
+			return false
+		}
+	}
+	return true
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
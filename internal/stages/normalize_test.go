@@ -1,8 +1,13 @@
 package stages
 
 import (
+	"encoding/json"
+	"errors"
+	"strings"
 	"testing"
 	"time"
+
+	"k8s-log-etl/internal/model"
 )
 
 func TestNormalize_CompleteRecord(t *testing.T) {
@@ -59,21 +64,31 @@ func TestNormalize_MissingRequiredFields(t *testing.T) {
 		name string
 		raw  map[string]interface{}
 		want string
+		code string
 	}{
 		{
 			name: "missing message",
-			raw:  map[string]interface{}{"level": "ERROR"},
+			raw:  map[string]interface{}{"ts": "2024-01-01T12:00:00Z", "level": "ERROR"},
 			want: "missing message",
+			code: "missing_message",
 		},
 		{
 			name: "missing level",
-			raw:  map[string]interface{}{"msg": "test"},
+			raw:  map[string]interface{}{"ts": "2024-01-01T12:00:00Z", "msg": "test"},
 			want: "missing level",
+			code: "missing_level",
 		},
 		{
 			name: "missing timestamp",
 			raw:  map[string]interface{}{"msg": "test", "level": "ERROR"},
 			want: "missing timestamp",
+			code: "missing_ts",
+		},
+		{
+			name: "invalid timestamp",
+			raw:  map[string]interface{}{"ts": "yesterday", "msg": "test", "level": "ERROR"},
+			want: `invalid timestamp "yesterday"`,
+			code: "invalid_ts",
 		},
 	}
 
@@ -83,8 +98,15 @@ func TestNormalize_MissingRequiredFields(t *testing.T) {
 			if err == nil {
 				t.Fatal("expected error")
 			}
-			if err.Error() == "" {
-				t.Error("expected non-empty error message")
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected error containing %q, got %q", tt.want, err.Error())
+			}
+			var nerr *NormalizeError
+			if !errors.As(err, &nerr) {
+				t.Fatalf("expected *NormalizeError, got %T", err)
+			}
+			if nerr.Code() != tt.code {
+				t.Errorf("expected code %q, got %q", tt.code, nerr.Code())
 			}
 		})
 	}
@@ -117,9 +139,9 @@ func TestNormalize_FieldAliases(t *testing.T) {
 		{
 			name: "message alias",
 			raw: map[string]interface{}{
-				"ts":       "2024-01-01T12:00:00Z",
-				"level":    "ERROR",
-				"message":  "test message",
+				"ts":      "2024-01-01T12:00:00Z",
+				"level":   "ERROR",
+				"message": "test message",
 			},
 			want: "test message",
 		},
@@ -168,14 +190,54 @@ func TestNormalize_TimestampFormats(t *testing.T) {
 				"level": "ERROR",
 				"msg":   "test",
 			}
-			_, err := Normalize(raw)
+			n, err := Normalize(raw)
 			if (err == nil) != tt.want {
 				t.Errorf("expected success=%v, got error=%v", tt.want, err)
 			}
+			if err == nil {
+				if parsed, _ := time.Parse(time.RFC3339Nano, tt.ts); !n.Time.Equal(parsed) {
+					t.Errorf("Time %v, want %v", n.Time, parsed)
+				}
+			}
 		})
 	}
 }
 
+func TestNormalize_TimeMatchesTS(t *testing.T) {
+	for _, ts := range []string{
+		"2024-01-01T12:00:00Z",
+		"2024-01-01T12:00:00.5+02:00",
+		"2024-01-01T12:00:00.123456789-07:30",
+		" 2024-02-29T23:59:59.000000001Z ",
+	} {
+		n, err := Normalize(map[string]any{"ts": ts, "level": "info", "msg": "m"})
+		if err != nil {
+			t.Fatalf("%q: %v", ts, err)
+		}
+		parsed, err := time.Parse(time.RFC3339Nano, n.TS)
+		if err != nil || !parsed.Equal(n.Time) || parsed.Format(time.RFC3339Nano) != n.Time.Format(time.RFC3339Nano) {
+			t.Errorf("%q: TS %q and Time %v disagree", ts, n.TS, n.Time)
+		}
+
+		// A record decoded from JSON, e.g. from the DLQ, has no Time but
+		// the same event time.
+		data, err := json.Marshal(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded model.Normalized
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if got, ok := decoded.EventTime(); !decoded.Time.IsZero() || !ok || !got.Equal(n.Time) {
+			t.Errorf("%q: decoded event time %v, %v; want %v", ts, got, ok, n.Time)
+		}
+	}
+	if _, ok := (model.Normalized{TS: "yesterday"}).EventTime(); ok {
+		t.Error("EventTime reported an unparseable TS")
+	}
+}
+
 func BenchmarkNormalize(b *testing.B) {
 	raw := map[string]interface{}{
 		"ts":      "2024-01-01T12:00:00Z",
@@ -196,3 +258,102 @@ func BenchmarkNormalize(b *testing.B) {
 		_, _ = Normalize(raw)
 	}
 }
+
+func TestServiceFromPod(t *testing.T) {
+	tests := []struct {
+		pod  string
+		want string
+	}{
+		{"payments-api-7d9f8b6c4-xk2lp", "payments-api"},    // Deployment
+		{"coredns-5d78c9869d-8rwqv", "coredns"},             // Deployment, single-word name
+		{"orders-api-6f4c9b7c8d-xp9k2", "orders-api"},       // Deployment, 10-char hash
+		{"fluent-bit-x7k2p", "fluent-bit"},                  // DaemonSet
+		{"db-migrate-4zq8n", "db-migrate"},                  // Job
+		{"nightly-backup-28472910-x7k2p", "nightly-backup"}, // CronJob
+		{"postgres-0", "postgres"},                          // StatefulSet
+		{"kafka-broker-12", "kafka-broker"},                 // StatefulSet, two-digit ordinal
+		{"debug-shell", "debug-shell"},                      // bare pod
+		{"nginx", "nginx"},                                  // bare pod, no dashes
+		{"my-redis", "my-redis"},                            // vowels: not a generated suffix
+		{"web-aeiou", "web-aeiou"},                          // five chars outside the alphabet
+		{"x7k2p", "x7k2p"},                                  // nothing left to strip to
+	}
+	for _, tt := range tests {
+		if got := ServiceFromPod(tt.pod); got != tt.want {
+			t.Errorf("ServiceFromPod(%q) = %q, want %q", tt.pod, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeWith_DeriveServiceFromPod(t *testing.T) {
+	base := func() map[string]any {
+		return map[string]any{"ts": "2024-01-01T12:00:00Z", "level": "INFO", "msg": "m", "pod": "payments-api-7d9f8b6c4-xk2lp"}
+	}
+	opts := NormalizeOptions{DeriveServiceFromPod: true}
+
+	n, err := NormalizeWith(base(), opts)
+	if err != nil {
+		t.Fatalf("NormalizeWith: %v", err)
+	}
+	if n.Service != "payments-api" || n.Fields["service_derived"] != true {
+		t.Errorf("expected derived service, got %q fields=%v", n.Service, n.Fields)
+	}
+
+	raw := base()
+	raw["app"] = "payments"
+	n, _ = NormalizeWith(raw, opts)
+	if n.Service != "payments" || n.Fields["service_derived"] != nil {
+		t.Errorf("explicit service must win, got %q fields=%v", n.Service, n.Fields)
+	}
+
+	n, _ = Normalize(base())
+	if n.Service != "" {
+		t.Errorf("derivation must be opt-in, got %q", n.Service)
+	}
+}
+
+func TestNormalize_ErrorDetails(t *testing.T) {
+	base := func(extra map[string]any) map[string]any {
+		raw := map[string]any{"ts": "2024-01-01T12:00:00Z", "level": "ERROR", "msg": "m"}
+		for k, v := range extra {
+			raw[k] = v
+		}
+		return raw
+	}
+	tests := []struct {
+		name                           string
+		extra                          map[string]any
+		wantErr, wantStack, wantCaller string
+	}{
+		{name: "none", extra: nil},
+		{name: "canonical keys", extra: map[string]any{"error": " boom ", "stacktrace": "at main()", "caller": "main.go:12"},
+			wantErr: "boom", wantStack: "at main()", wantCaller: "main.go:12"},
+		{name: "aliases", extra: map[string]any{"err": "boom", "stack_trace": "at a()", "source": "a.go:1"},
+			wantErr: "boom", wantStack: "at a()", wantCaller: "a.go:1"},
+		{name: "exception alias", extra: map[string]any{"exception": "NullPointerException", "stack": "at A.b"},
+			wantErr: "NullPointerException", wantStack: "at A.b"},
+		{name: "first alias wins", extra: map[string]any{"error": "first", "err": "second"}, wantErr: "first"},
+		{name: "empty falls through", extra: map[string]any{"error": "", "err": "second"}, wantErr: "second"},
+		{name: "error object encoded", extra: map[string]any{"error": map[string]any{"code": 3.0}}, wantErr: `{"code":3}`},
+		{name: "frame list joined", extra: map[string]any{"stack": []any{"at a()", "at b()"}}, wantStack: "at a()\nat b()"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := Normalize(base(tt.extra))
+			if err != nil {
+				t.Fatalf("Normalize: %v", err)
+			}
+			if n.Error != tt.wantErr || n.Stacktrace != tt.wantStack || n.Caller != tt.wantCaller {
+				t.Errorf("got error=%q stack=%q caller=%q", n.Error, n.Stacktrace, n.Caller)
+			}
+			for k := range tt.extra {
+				if _, ok := n.Fields[k]; ok {
+					t.Errorf("expected %q to be removed from Fields", k)
+				}
+			}
+			if v, ok := n.Field("stacktrace"); ok != (tt.wantStack != "") || (ok && v != tt.wantStack) {
+				t.Errorf("Field(stacktrace) = %v, %v", v, ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package stages
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// utf8BOM is stripped from the start of a line before decoding.
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// Parser decodes input lines into raw records. It reuses its maps between
+// calls, so the records returned by Parse are only valid until the next call.
+// A Parser is not safe for concurrent use.
+type Parser struct {
+	maps []map[string]any
+	out  []map[string]any
+}
+
+// Parse decodes one input line. Besides a plain JSON object it accepts a
+// leading UTF-8 BOM, trailing commas before a closing bracket or brace, and
+// several objects concatenated on one line (`{...}{...}`), which come back
+// as separate records. If any part of the line is malformed, no records are
+// returned and err is the error from decoding the line as a single object.
+func (p *Parser) Parse(line []byte) ([]map[string]any, error) {
+	line = bytes.TrimPrefix(line, utf8BOM)
+	p.scratch(0)
+	err := json.Unmarshal(line, &p.maps[0])
+	if err == nil {
+		p.out = append(p.out[:0], p.maps[0])
+		return p.out, nil
+	}
+	var syntaxErr *json.SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		return nil, err
+	}
+	if records, ok := p.decodeAll(line); ok {
+		return records, nil
+	}
+	if fixed, changed := stripTrailingCommas(line); changed {
+		if records, ok := p.decodeAll(fixed); ok {
+			return records, nil
+		}
+	}
+	return nil, err
+}
+
+// decodeAll decodes every top-level object in line.
+func (p *Parser) decodeAll(line []byte) ([]map[string]any, bool) {
+	dec := json.NewDecoder(bytes.NewReader(line))
+	p.out = p.out[:0]
+	for i := 0; ; i++ {
+		p.scratch(i)
+		if err := dec.Decode(&p.maps[i]); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, false
+		}
+		p.out = append(p.out, p.maps[i])
+	}
+	return p.out, len(p.out) > 0
+}
+
+// scratch makes sure p.maps[i] exists and is empty.
+func (p *Parser) scratch(i int) {
+	for len(p.maps) <= i {
+		p.maps = append(p.maps, nil)
+	}
+	// Decoding `null` leaves a nil map behind; start over in that case.
+	if p.maps[i] == nil {
+		p.maps[i] = make(map[string]any)
+	}
+	clear(p.maps[i])
+}
+
+// stripTrailingCommas removes commas that are followed only by whitespace
+// and a closing ] or }. Commas inside strings are left alone.
+func stripTrailingCommas(line []byte) ([]byte, bool) {
+	var out []byte
+	inString, escaped := false, false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case inString:
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == ',':
+			j := i + 1
+			for j < len(line) && (line[j] == ' ' || line[j] == '\t' || line[j] == '\r' || line[j] == '\n') {
+				j++
+			}
+			if j < len(line) && (line[j] == ']' || line[j] == '}') {
+				if out == nil {
+					out = append(make([]byte, 0, len(line)), line[:i]...)
+				}
+				continue
+			}
+		}
+		if out != nil {
+			out = append(out, c)
+		}
+	}
+	if out == nil {
+		return line, false
+	}
+	return out, true
+}
+
+// DuplicateKey reports the first key that appears twice in one object of
+// line, which encoding/json would silently resolve to the last value. The
+// key is given as a dotted path from its top-level object, e.g. "level" or
+// "kubernetes.pod"; array elements do not add to the path. It walks the
+// line token by token, so it is much slower than Parse and only meant for
+// lines Parse accepted.
+func DuplicateKey(line []byte) (string, bool) {
+	line = bytes.TrimPrefix(line, utf8BOM)
+	key, found, err := duplicateKey(line)
+	if err != nil {
+		if fixed, changed := stripTrailingCommas(line); changed {
+			key, found, _ = duplicateKey(fixed)
+		}
+	}
+	return key, found
+}
+
+// scanFrame is an object or array DuplicateKey is inside of.
+type scanFrame struct {
+	object  bool
+	path    string // of the object or array itself
+	keys    map[string]struct{}
+	key     string // the key whose value comes next
+	wantKey bool
+}
+
+// child is the path of the value that comes next in f.
+func (f *scanFrame) child() string {
+	if f == nil {
+		return ""
+	}
+	if !f.object {
+		return f.path
+	}
+	if f.path == "" {
+		return f.key
+	}
+	return f.path + "." + f.key
+}
+
+func duplicateKey(line []byte) (string, bool, error) {
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	var stack []*scanFrame
+	top := func() *scanFrame {
+		if len(stack) == 0 {
+			return nil
+		}
+		return stack[len(stack)-1]
+	}
+	// valueDone moves the enclosing object on to its next key.
+	valueDone := func() {
+		if f := top(); f != nil && f.object {
+			f.wantKey = true
+		}
+	}
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return "", false, nil
+		}
+		if err != nil {
+			return "", false, err
+		}
+		if f := top(); f != nil && f.object && f.wantKey {
+			if key, ok := tok.(string); ok {
+				if _, dup := f.keys[key]; dup {
+					f.key = key
+					return f.child(), true, nil
+				}
+				f.keys[key] = struct{}{}
+				f.key, f.wantKey = key, false
+				continue
+			}
+		}
+		switch tok {
+		case json.Delim('{'):
+			stack = append(stack, &scanFrame{object: true, path: top().child(), keys: make(map[string]struct{}), wantKey: true})
+		case json.Delim('['):
+			stack = append(stack, &scanFrame{path: top().child()})
+		case json.Delim('}'), json.Delim(']'):
+			stack = stack[:len(stack)-1]
+			valueDone()
+		default:
+			valueDone()
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package stages
+
+import (
+	"testing"
+)
+
+func TestParser_Parse(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    []string // msg of each record
+		wantErr bool
+	}{
+		{name: "plain object", line: `{"msg":"a"}`, want: []string{"a"}},
+		{name: "utf-8 bom", line: "\xEF\xBB\xBF" + `{"msg":"a"}`, want: []string{"a"}},
+		{name: "concatenated objects", line: `{"msg":"a"}{"msg":"b"} {"msg":"c"}`, want: []string{"a", "b", "c"}},
+		{name: "trailing commas", line: `{"msg":"a","tags":["x","y",],}`, want: []string{"a"}},
+		{name: "comma in string kept", line: `{"msg":"a,}",}`, want: []string{"a,}"}},
+		{name: "concatenated with trailing comma", line: `{"msg":"a",}{"msg":"b"}`, want: []string{"a", "b"}},
+		{name: "garbage", line: `not json`, wantErr: true},
+		{name: "object then garbage", line: `{"msg":"a"} oops`, wantErr: true},
+		{name: "truncated second object", line: `{"msg":"a"}{"msg":`, wantErr: true},
+		{name: "array", line: `[{"msg":"a"}]`, wantErr: true},
+	}
+	var p Parser
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := p.Parse([]byte(tt.line))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", records)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if len(records) != len(tt.want) {
+				t.Fatalf("got %d records, want %d: %v", len(records), len(tt.want), records)
+			}
+			for i, r := range records {
+				if r["msg"] != tt.want[i] {
+					t.Errorf("record %d msg = %v, want %q", i, r["msg"], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParser_ReusedMapsAreCleared(t *testing.T) {
+	var p Parser
+	if _, err := p.Parse([]byte(`{"msg":"a","extra":1}{"msg":"b","extra":2}`)); err != nil {
+		t.Fatal(err)
+	}
+	records, err := p.Parse([]byte(`{"msg":"c"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || len(records[0]) != 1 {
+		t.Errorf("expected a single clean record, got %v", records)
+	}
+}
+
+func TestDuplicateKey(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want string // empty for none
+	}{
+		{name: "none", line: `{"level":"INFO","msg":"a","n":1.5,"ok":true,"x":null}`},
+		{name: "top level", line: `{"level":"ERROR","msg":"a","level":"INFO"}`, want: "level"},
+		{name: "nested", line: `{"kubernetes":{"pod":"a","ns":"b","pod":"c"},"level":"INFO"}`, want: "kubernetes.pod"},
+		{name: "same key in sibling objects", line: `{"a":{"k":1},"b":{"k":2},"items":[{"k":1},{"k":2}]}`},
+		{name: "inside array element", line: `{"items":[{"k":1,"k":2}]}`, want: "items.k"},
+		{name: "key after nested object", line: `{"a":{"b":[1,{}]},"a":2}`, want: "a"},
+		{name: "second concatenated object", line: `{"msg":"a"}{"msg":"b","msg":"c"}`, want: "msg"},
+		{name: "repeated across concatenated objects", line: `{"msg":"a"}{"msg":"b"}`},
+		{name: "trailing comma", line: "\xEF\xBB\xBF" + `{"msg":"a","tags":["x",],"msg":"b",}`, want: "msg"},
+		{name: "key as string value", line: `{"a":"b","c":"a"}`},
+		{name: "malformed", line: `{"a":1,"b":}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found := DuplicateKey([]byte(tt.line))
+			if got != tt.want || found != (tt.want != "") {
+				t.Errorf("DuplicateKey = %q, %v; want %q", got, found, tt.want)
+			}
+		})
+	}
+}
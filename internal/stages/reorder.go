@@ -0,0 +1,111 @@
+package stages
+
+import (
+	"container/heap"
+	"time"
+)
+
+// Reorderer restores timestamp order to records that arrive up to a window
+// out of order. Records are held in a min-heap on their timestamp and
+// released once the newest timestamp seen is more than the window past
+// them, so output is in order whenever input is no more than the window
+// out of order. Records with equal timestamps keep their input order.
+// A Reorderer is not safe for concurrent use.
+type Reorderer[T any] struct {
+	window     time.Duration
+	maxRecords int
+	heap       reorderHeap[T]
+	seq        uint64
+	newest     time.Time
+	out        []T
+	stats      ReorderStats
+}
+
+// ReorderStats counts what a Reorderer did with its records.
+type ReorderStats struct {
+	// Late counts records already more than the window older than the
+	// newest timestamp when they arrived. They are released at once, out
+	// of order.
+	Late int
+	// Overflow counts records released early because the buffer was full.
+	Overflow int
+	// MaxBuffered is the most records held at once.
+	MaxBuffered int
+}
+
+// NewReorderer buffers records for window. At most maxRecords are held
+// (0 or negative means no limit); past that the oldest is released early.
+func NewReorderer[T any](window time.Duration, maxRecords int) *Reorderer[T] {
+	return &Reorderer[T]{window: window, maxRecords: maxRecords}
+}
+
+// Push adds v, stamped ts, and returns the records it makes ready, oldest
+// first. The returned slice is reused by the next call.
+func (r *Reorderer[T]) Push(ts time.Time, v T) []T {
+	r.out = r.out[:0]
+	if !r.newest.IsZero() && ts.Before(r.newest.Add(-r.window)) {
+		r.stats.Late++
+		return append(r.out, v)
+	}
+	if ts.After(r.newest) {
+		r.newest = ts
+	}
+	r.seq++
+	heap.Push(&r.heap, reorderItem[T]{ts: ts, seq: r.seq, v: v})
+	if r.maxRecords > 0 && r.heap.Len() > r.maxRecords {
+		r.stats.Overflow++
+		r.out = append(r.out, heap.Pop(&r.heap).(reorderItem[T]).v)
+	}
+	cutoff := r.newest.Add(-r.window)
+	for r.heap.Len() > 0 && !r.heap[0].ts.After(cutoff) {
+		r.out = append(r.out, heap.Pop(&r.heap).(reorderItem[T]).v)
+	}
+	if n := r.heap.Len(); n > r.stats.MaxBuffered {
+		r.stats.MaxBuffered = n
+	}
+	return r.out
+}
+
+// Flush returns every buffered record, oldest first, leaving the buffer
+// empty. The returned slice is reused by the next call.
+func (r *Reorderer[T]) Flush() []T {
+	r.out = r.out[:0]
+	for r.heap.Len() > 0 {
+		r.out = append(r.out, heap.Pop(&r.heap).(reorderItem[T]).v)
+	}
+	return r.out
+}
+
+// Len is the number of buffered records.
+func (r *Reorderer[T]) Len() int { return r.heap.Len() }
+
+// Stats returns the counts so far.
+func (r *Reorderer[T]) Stats() ReorderStats { return r.stats }
+
+type reorderItem[T any] struct {
+	ts  time.Time
+	seq uint64
+	v   T
+}
+
+// reorderHeap implements heap.Interface, ordered by timestamp and then by
+// arrival.
+type reorderHeap[T any] []reorderItem[T]
+
+func (h reorderHeap[T]) Len() int { return len(h) }
+func (h reorderHeap[T]) Less(i, j int) bool {
+	if !h[i].ts.Equal(h[j].ts) {
+		return h[i].ts.Before(h[j].ts)
+	}
+	return h[i].seq < h[j].seq
+}
+func (h reorderHeap[T]) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *reorderHeap[T]) Push(x any)   { *h = append(*h, x.(reorderItem[T])) }
+func (h *reorderHeap[T]) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	var zero reorderItem[T]
+	old[len(old)-1] = zero // drop the reference for the GC
+	*h = old[:len(old)-1]
+	return item
+}
@@ -0,0 +1,77 @@
+package stages
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReorderer(t *testing.T) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(sec int) time.Time { return base.Add(time.Duration(sec) * time.Second) }
+
+	tests := []struct {
+		name        string
+		maxRecords  int
+		arrivals    []int // seconds past base; the value is its own label
+		wantOrder   []int
+		wantStats   ReorderStats
+		wantPending int // still buffered before Flush
+	}{
+		{
+			name:        "interleaved within window",
+			arrivals:    []int{3, 1, 2, 6, 4, 5, 20},
+			wantOrder:   []int{1, 2, 3, 4, 5, 6, 20},
+			wantStats:   ReorderStats{MaxBuffered: 6},
+			wantPending: 1,
+		},
+		{
+			name:        "late record passes straight through",
+			arrivals:    []int{20, 21, 5, 22},
+			wantOrder:   []int{5, 20, 21, 22},
+			wantStats:   ReorderStats{Late: 1, MaxBuffered: 3},
+			wantPending: 3,
+		},
+		{
+			name:        "overflow releases the oldest",
+			maxRecords:  2,
+			arrivals:    []int{3, 1, 2, 4},
+			wantOrder:   []int{1, 2, 3, 4},
+			wantStats:   ReorderStats{Overflow: 2, MaxBuffered: 2},
+			wantPending: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReorderer[int](10*time.Second, tt.maxRecords)
+			var got []int
+			for _, sec := range tt.arrivals {
+				got = append(got, r.Push(at(sec), sec)...)
+			}
+			if r.Len() != tt.wantPending {
+				t.Errorf("Len before Flush = %d, want %d", r.Len(), tt.wantPending)
+			}
+			got = append(got, r.Flush()...)
+			if !reflect.DeepEqual(got, tt.wantOrder) {
+				t.Errorf("order = %v, want %v", got, tt.wantOrder)
+			}
+			if r.Stats() != tt.wantStats {
+				t.Errorf("stats = %+v, want %+v", r.Stats(), tt.wantStats)
+			}
+			if r.Len() != 0 {
+				t.Errorf("Len after Flush = %d, want 0", r.Len())
+			}
+		})
+	}
+}
+
+func TestReorderer_EqualTimestampsKeepArrivalOrder(t *testing.T) {
+	ts := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	r := NewReorderer[string](time.Second, 0)
+	for _, v := range []string{"a", "b", "c", "d"} {
+		r.Push(ts, v)
+	}
+	if got := r.Flush(); !reflect.DeepEqual(got, []string{"a", "b", "c", "d"}) {
+		t.Errorf("order = %v", got)
+	}
+}
@@ -0,0 +1,149 @@
+package stages
+
+import (
+	"maps"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"k8s-log-etl/internal/model"
+)
+
+// MaxSpaceRun is the longest run of whitespace SanitizeText leaves in place.
+const MaxSpaceRun = 4
+
+// SanitizeText makes s safe for terminals and line-oriented consumers:
+// invalid UTF-8 becomes U+FFFD, newlines and tabs are escaped as \n and \t,
+// other C0 control characters and DEL are removed, and runs of more than
+// MaxSpaceRun whitespace characters are cut to MaxSpaceRun. It reports
+// whether s changed; text that needs none of this is returned as is,
+// without allocating.
+func SanitizeText(s string) (string, bool) {
+	if clean(s) {
+		return s, false
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	spaces := 0
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		switch {
+		case r == utf8.RuneError && size == 1:
+			b.WriteRune(utf8.RuneError)
+			spaces = 0
+		case r == '\n':
+			b.WriteString(`\n`)
+			spaces = 0
+		case r == '\t':
+			b.WriteString(`\t`)
+			spaces = 0
+		case r < 0x20 || r == 0x7f:
+			// Removed; the spaces around it stay one run.
+		case unicode.IsSpace(r):
+			if spaces++; spaces <= MaxSpaceRun {
+				b.WriteRune(r)
+			}
+		default:
+			b.WriteRune(r)
+			spaces = 0
+		}
+	}
+	return b.String(), true
+}
+
+// clean reports whether SanitizeText leaves s alone.
+func clean(s string) bool {
+	spaces := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= utf8.RuneSelf {
+			// Non-ASCII text is checked rune by rune.
+			return cleanUnicode(s)
+		}
+		if c < 0x20 || c == 0x7f {
+			return false
+		}
+		if c == ' ' {
+			if spaces++; spaces > MaxSpaceRun {
+				return false
+			}
+			continue
+		}
+		spaces = 0
+	}
+	return true
+}
+
+func cleanUnicode(s string) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+	spaces := 0
+	for _, r := range s {
+		if r < 0x20 || r == 0x7f {
+			return false
+		}
+		if unicode.IsSpace(r) {
+			if spaces++; spaces > MaxSpaceRun {
+				return false
+			}
+			continue
+		}
+		spaces = 0
+	}
+	return true
+}
+
+// SanitizeRecord applies SanitizeText to n's message and to the strings in
+// its fields, nested ones included, and reports whether any changed. Maps
+// and slices holding a changed string are copied rather than modified, so
+// values shared with the parsed input are left alone.
+func SanitizeRecord(n *model.Normalized) bool {
+	changed := false
+	if msg, ok := SanitizeText(n.Message); ok {
+		n.Message, changed = msg, true
+	}
+	for k, v := range n.Fields {
+		if sv, ok := sanitizeValue(v); ok {
+			n.Fields[k], changed = sv, true
+		}
+	}
+	return changed
+}
+
+func sanitizeValue(v any) (any, bool) {
+	switch x := v.(type) {
+	case string:
+		return SanitizeText(x)
+	case map[string]any:
+		var out map[string]any
+		for k, e := range x {
+			if se, ok := sanitizeValue(e); ok {
+				if out == nil {
+					out = maps.Clone(x)
+				}
+				out[k] = se
+			}
+		}
+		if out == nil {
+			return v, false
+		}
+		return out, true
+	case []any:
+		var out []any
+		for i, e := range x {
+			if se, ok := sanitizeValue(e); ok {
+				if out == nil {
+					out = append([]any(nil), x...)
+				}
+				out[i] = se
+			}
+		}
+		if out == nil {
+			return v, false
+		}
+		return out, true
+	}
+	return v, false
+}
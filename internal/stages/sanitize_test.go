@@ -0,0 +1,57 @@
+package stages
+
+import (
+	"testing"
+
+	"k8s-log-etl/internal/model"
+)
+
+func TestSanitizeText(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{name: "clean ascii", in: "payment failed: card declined", want: "payment failed: card declined"},
+		{name: "clean unicode", in: "zahlung fehlgeschlagen – ümlaut", want: "zahlung fehlgeschlagen – ümlaut"},
+		{name: "newline and tab escaped", in: "line one\nline two\tcol", want: `line one\nline two\tcol`},
+		{name: "controls removed", in: "\x1b[31mred\x1b[0m\x00\r\x7f", want: "[31mred[0m"},
+		{name: "invalid utf-8", in: "bad \xff\xfe byte", want: "bad �� byte"},
+		{name: "space run capped", in: "a" + "          " + "b", want: "a    b"},
+		{name: "run across removed control", in: "a   \x00   b", want: "a    b"},
+		{name: "escape ends a run", in: "a    \n    b", want: `a    \n    b`},
+		{name: "unicode spaces count", in: "a      b", want: "a    b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, changed := SanitizeText(tt.in)
+			if got != tt.want {
+				t.Errorf("SanitizeText(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+			if changed != (tt.in != tt.want) {
+				t.Errorf("changed = %v", changed)
+			}
+		})
+	}
+}
+
+func TestSanitizeRecord_CopiesNestedValues(t *testing.T) {
+	nested := map[string]any{"path": "/tmp/\x00x", "n": 1.0}
+	list := []any{"ok", "tab\there"}
+	n := model.Normalized{Message: "clean", Fields: map[string]any{"req": nested, "tags": list, "plain": "fine", "n": 2.0}}
+	if !SanitizeRecord(&n) {
+		t.Fatal("SanitizeRecord reported no change")
+	}
+	if got := n.Fields["req"].(map[string]any)["path"]; got != "/tmp/x" {
+		t.Errorf("nested path = %q", got)
+	}
+	if got := n.Fields["tags"].([]any)[1]; got != `tab\there` {
+		t.Errorf("list element = %q", got)
+	}
+	// The parsed input's values are not modified.
+	if nested["path"] != "/tmp/\x00x" || list[1] != "tab\there" {
+		t.Errorf("input modified: %v %v", nested, list)
+	}
+	clean := model.Normalized{Message: "clean", Fields: map[string]any{"k": "v"}}
+	if SanitizeRecord(&clean) {
+		t.Error("clean record reported as changed")
+	}
+}
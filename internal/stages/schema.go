@@ -0,0 +1,151 @@
+package stages
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"github.com/santhosh-tekuri/jsonschema/v6/kind"
+)
+
+// ReasonSchema is the DLQ and drop reason for records failing
+// json_schema_file.
+const ReasonSchema = "schema"
+
+// SchemaValidator validates parsed records against a JSON Schema, compiled
+// once when it is loaded. Validate is safe for concurrent use.
+type SchemaValidator struct {
+	schema *jsonschema.Schema
+}
+
+// schemaCache keeps the schemas LoadSchema compiled, so the preflight
+// check and the run compile a schema once.
+var schemaCache = struct {
+	sync.Mutex
+	byPath map[string]cachedSchema
+}{byPath: make(map[string]cachedSchema)}
+
+// cachedSchema is a compiled schema and the file it was compiled from.
+type cachedSchema struct {
+	modTime   time.Time
+	size      int64
+	validator *SchemaValidator
+}
+
+// LoadSchema reads and compiles the JSON Schema at path. Schemas it
+// refers to by relative $ref are loaded from next to it. A compiled
+// schema is reused until the file at path changes; changes to the
+// schemas it refers to alone are not noticed.
+func LoadSchema(path string) (*SchemaValidator, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("compile json schema %s: %w", path, err)
+	}
+	schemaCache.Lock()
+	defer schemaCache.Unlock()
+	if c, ok := schemaCache.byPath[path]; ok && c.modTime.Equal(info.ModTime()) && c.size == info.Size() {
+		return c.validator, nil
+	}
+	schema, err := jsonschema.NewCompiler().Compile(path)
+	if err != nil {
+		return nil, fmt.Errorf("compile json schema %s: %w", path, err)
+	}
+	v := &SchemaValidator{schema: schema}
+	schemaCache.byPath[path] = cachedSchema{modTime: info.ModTime(), size: info.Size(), validator: v}
+	return v, nil
+}
+
+// SchemaViolation is one way a record failed its schema: the keyword that
+// failed, such as type or required, at Path, a JSON pointer into the
+// record. A missing required property is reported at its own path.
+type SchemaViolation struct {
+	Keyword string
+	Path    string
+	Message string
+}
+
+// Key identifies the violation for counting: "keyword at path", with "/"
+// for the record itself.
+func (v SchemaViolation) Key() string {
+	path := v.Path
+	if path == "" {
+		path = "/"
+	}
+	return v.Keyword + " at " + path
+}
+
+// SchemaError is a record's failure to validate.
+type SchemaError struct {
+	Violations []SchemaViolation
+}
+
+// Error joins the messages of the violations.
+func (e *SchemaError) Error() string {
+	var msgs []string
+	for _, v := range e.Violations {
+		// The properties missing from one object share a message.
+		if len(msgs) == 0 || msgs[len(msgs)-1] != v.Message {
+			msgs = append(msgs, v.Message)
+		}
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Validate checks rec against the schema. It returns nil for a valid
+// record and otherwise a *SchemaError listing the innermost failures.
+func (v *SchemaValidator) Validate(rec map[string]any) error {
+	err := v.schema.Validate(rec)
+	if err == nil {
+		return nil
+	}
+	var verr *jsonschema.ValidationError
+	if !errors.As(err, &verr) {
+		return &SchemaError{Violations: []SchemaViolation{{Keyword: "schema", Message: err.Error()}}}
+	}
+	serr := &SchemaError{}
+	collectViolations(verr, &serr.Violations)
+	return serr
+}
+
+// collectViolations appends the leaves of the error tree, the failures the
+// branches above them only group.
+func collectViolations(e *jsonschema.ValidationError, out *[]SchemaViolation) {
+	if len(e.Causes) > 0 {
+		for _, c := range e.Causes {
+			collectViolations(c, out)
+		}
+		return
+	}
+	path := pointer(e.InstanceLocation)
+	// Error of a causeless copy is just "at '<path>': <message>".
+	msg := (&jsonschema.ValidationError{SchemaURL: e.SchemaURL, InstanceLocation: e.InstanceLocation, ErrorKind: e.ErrorKind}).Error()
+	if req, ok := e.ErrorKind.(*kind.Required); ok {
+		for _, prop := range req.Missing {
+			*out = append(*out, SchemaViolation{Keyword: "required", Path: path + "/" + escapePointer(prop), Message: msg})
+		}
+		return
+	}
+	keyword := "false"
+	if kp := e.ErrorKind.KeywordPath(); len(kp) > 0 {
+		keyword = kp[len(kp)-1]
+	}
+	*out = append(*out, SchemaViolation{Keyword: keyword, Path: path, Message: msg})
+}
+
+// pointer makes a JSON pointer of the tokens of an instance location.
+func pointer(tokens []string) string {
+	var sb strings.Builder
+	for _, tok := range tokens {
+		sb.WriteByte('/')
+		sb.WriteString(escapePointer(tok))
+	}
+	return sb.String()
+}
+
+func escapePointer(tok string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(tok)
+}
@@ -0,0 +1,164 @@
+package stages
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const exampleSchema = "../../examples/k8s_logs.schema.json"
+
+func TestSchemaValidator_Validate(t *testing.T) {
+	v, err := LoadSchema(exampleSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		rec  string
+		want []string
+	}{
+		{"valid", `{"ts":"2024-01-01T12:00:00Z","level":"INFO","msg":"ok","status":200}`, nil},
+		{"wrong type", `{"level":"INFO","msg":"ok","service":7}`, []string{"type at /service"}},
+		{"not in enum", `{"severity":"LOUD","message":"ok"}`, []string{"enum at /severity"}},
+		{"not an integer", `{"msg":"ok","status":200.5}`, []string{"type at /status"}},
+		{"out of range", `{"msg":"ok","status":700}`, []string{"maximum at /status"}},
+		{"missing one of", `{"level":"INFO"}`, []string{"required at /msg", "required at /message"}},
+		{"not an object field", `{"msg":"ok","pod":{"name":"a/b"}}`, []string{"type at /pod"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var p Parser
+			recs, err := p.Parse([]byte(tc.rec))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = v.Validate(recs[0])
+			if tc.want == nil {
+				if err != nil {
+					t.Fatalf("Validate = %v, want nil", err)
+				}
+				return
+			}
+			var serr *SchemaError
+			if !errors.As(err, &serr) {
+				t.Fatalf("Validate = %v, want a *SchemaError", err)
+			}
+			var got []string
+			for _, viol := range serr.Violations {
+				got = append(got, viol.Key())
+				if viol.Message == "" {
+					t.Errorf("violation %s has no message", viol.Key())
+				}
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("violations = %q, want %q (%v)", got, tc.want, err)
+			}
+		})
+	}
+}
+
+func TestSchemaViolation_KeyEscapesPath(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "schema.json")
+	if err := os.WriteFile(path, []byte(`{"required":["a/b"],"properties":{"m~n":{"type":"string"}}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	v, err := LoadSchema(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var serr *SchemaError
+	if !errors.As(v.Validate(map[string]any{"m~n": 1.0}), &serr) {
+		t.Fatal("want a *SchemaError")
+	}
+	var got []string
+	for _, viol := range serr.Violations {
+		got = append(got, viol.Key())
+	}
+	want := []string{"required at /a~1b", "type at /m~0n"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("violations = %q, want %q", got, want)
+	}
+	if msg := serr.Error(); !strings.Contains(msg, "a/b") || !strings.Contains(msg, "; ") {
+		t.Errorf("Error() = %q, want both messages", msg)
+	}
+}
+
+func TestLoadSchemaErrors(t *testing.T) {
+	dir := t.TempDir()
+	bad := filepath.Join(dir, "bad.json")
+	if err := os.WriteFile(bad, []byte(`{"type":"nonsense"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	broken := filepath.Join(dir, "broken.json")
+	if err := os.WriteFile(broken, []byte(`{"type":`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{bad, broken, filepath.Join(dir, "missing.json")} {
+		if _, err := LoadSchema(path); err == nil || !strings.Contains(err.Error(), path) {
+			t.Errorf("LoadSchema(%s) = %v, want an error naming the file", path, err)
+		}
+	}
+}
+
+func TestLoadSchemaCachesUntilChanged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schema.json")
+	if err := os.WriteFile(path, []byte(`{"type":"object"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	first, err := LoadSchema(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := LoadSchema(path); err != nil || again != first {
+		t.Errorf("LoadSchema compiled an unchanged schema again")
+	}
+	if err := os.WriteFile(path, []byte(`{"type":"array"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := LoadSchema(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed == first || changed.Validate(map[string]any{}) == nil {
+		t.Errorf("LoadSchema kept the schema from before the file changed")
+	}
+}
+
+// BenchmarkSchemaValidate measures the validation each record pays with
+// json_schema_file set, over the example fixture.
+func BenchmarkSchemaValidate(b *testing.B) {
+	v, err := LoadSchema(exampleSchema)
+	if err != nil {
+		b.Fatal(err)
+	}
+	f, err := os.Open("../../examples/k8s_logs.jsonl")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+	var records []map[string]any
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var p Parser
+		recs, err := p.Parse(scanner.Bytes())
+		if err != nil {
+			b.Fatal(err)
+		}
+		records = append(records, recs...)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, rec := range records {
+			if err := v.Validate(rec); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package stages
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// UnwrapOptions configures Unwrap.
+type UnwrapOptions struct {
+	// Keys are the top-level keys that may hold a wrapped payload, such as
+	// fluentd's "log". They are tried in order.
+	Keys []string
+	// OuterWins keeps the outer value when the payload has the same key;
+	// by default the payload's value replaces it.
+	OuterWins bool
+}
+
+// UnwrapResult is what Unwrap did with a record.
+type UnwrapResult int
+
+const (
+	UnwrapNone   UnwrapResult = iota // no key held a string payload
+	UnwrapJSON                       // a JSON object payload was merged in
+	UnwrapText                       // a plain text payload became msg
+	UnwrapFailed                     // a payload looked like JSON but did not parse
+)
+
+// Unwrap lifts a payload that a log shipper stored as a string under one
+// of opts.Keys, e.g.
+//
+//	{"log":"{\"level\":\"error\",\"msg\":\"boom\"}\n","stream":"stderr"}
+//
+// into raw before Normalize runs. A JSON object payload is merged into raw
+// and its key removed. Any other payload is plain text: its trailing
+// newline is stripped and it becomes msg. A payload that starts with '{'
+// but does not parse is left alone, as is everything else in raw. The
+// first key holding a string is used. raw is modified in place.
+func Unwrap(raw map[string]any, opts UnwrapOptions) UnwrapResult {
+	for _, key := range opts.Keys {
+		payload, ok := raw[key].(string)
+		if !ok {
+			continue
+		}
+		payload = strings.TrimRight(payload, "\r\n")
+		if strings.HasPrefix(strings.TrimSpace(payload), "{") {
+			var inner map[string]any
+			if err := json.Unmarshal([]byte(payload), &inner); err != nil || inner == nil {
+				return UnwrapFailed
+			}
+			delete(raw, key)
+			for k, v := range inner {
+				if _, exists := raw[k]; exists && opts.OuterWins {
+					continue
+				}
+				raw[k] = v
+			}
+			return UnwrapJSON
+		}
+		if opts.OuterWins && (raw["msg"] != nil || raw["message"] != nil) {
+			return UnwrapNone
+		}
+		delete(raw, key)
+		raw["msg"] = payload
+		return UnwrapText
+	}
+	return UnwrapNone
+}
@@ -0,0 +1,103 @@
+package stages
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fluentdRecords reads testdata/fluentd.jsonl, hand-written records in the
+// shape fluentd's docker parser and kubernetes_metadata filter produce: a
+// JSON payload, a plain text line, a payload with a key shared with the
+// envelope, and a payload the runtime cut short.
+func fluentdRecords(t *testing.T) []map[string]any {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", "fluentd.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var records []map[string]any
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var raw map[string]any
+		if err := json.Unmarshal(sc.Bytes(), &raw); err != nil {
+			t.Fatalf("fixture: %v", err)
+		}
+		records = append(records, raw)
+	}
+	return records
+}
+
+func TestUnwrap_Fluentd(t *testing.T) {
+	records := fluentdRecords(t)
+	opts := UnwrapOptions{Keys: []string{"log"}}
+	want := []UnwrapResult{UnwrapJSON, UnwrapText, UnwrapJSON, UnwrapFailed}
+	for i, raw := range records {
+		if got := Unwrap(raw, opts); got != want[i] {
+			t.Errorf("record %d: Unwrap = %v, want %v", i, got, want[i])
+		}
+	}
+
+	n, err := Normalize(records[0])
+	if err != nil {
+		t.Fatalf("Normalize wrapped JSON: %v", err)
+	}
+	if n.Level != "ERROR" || n.Message != "card declined" || n.TS != "2024-03-05T10:15:30.123Z" || n.TraceID != "4bf92f3577b34da6" {
+		t.Errorf("unexpected record %+v", n)
+	}
+	if n.Namespace != "shop" || n.Pod != "payments-7d9f8b6c4-xk2lp" || n.Caller != "charge/handler.go:88" {
+		t.Errorf("outer metadata lost: %+v", n)
+	}
+	if _, ok := n.Fields["log"]; ok {
+		t.Error("log key should be removed once unwrapped")
+	}
+	if n.Fields["stream"] != "stderr" {
+		t.Errorf("stream = %v, want stderr", n.Fields["stream"])
+	}
+
+	if got := records[1]["msg"]; got != "Listening on :8080" {
+		t.Errorf("plain text msg = %q, want the line without its newline", got)
+	}
+	if _, ok := records[1]["log"]; ok {
+		t.Error("log key should be removed once used as msg")
+	}
+	if got := records[2]["stream"]; got != "db" {
+		t.Errorf("stream = %v, want the inner value", got)
+	}
+	if _, ok := records[3]["log"].(string); !ok || records[3]["msg"] != nil {
+		t.Errorf("a payload that fails to parse should be left alone: %v", records[3])
+	}
+}
+
+func TestUnwrap_OuterWins(t *testing.T) {
+	records := fluentdRecords(t)
+	opts := UnwrapOptions{Keys: []string{"log"}, OuterWins: true}
+	Unwrap(records[2], opts)
+	if got := records[2]["stream"]; got != "stdout" {
+		t.Errorf("stream = %v, want the outer value", got)
+	}
+	if records[2]["msg"] != "slow query" {
+		t.Errorf("keys only in the payload should still be merged: %v", records[2])
+	}
+
+	raw := map[string]any{"log": "plain\n", "message": "outer"}
+	if got := Unwrap(raw, opts); got != UnwrapNone || raw["log"] != "plain\n" {
+		t.Errorf("Unwrap = %v, record %v; want the outer message kept", got, raw)
+	}
+}
+
+func TestUnwrap_KeysInOrder(t *testing.T) {
+	raw := map[string]any{"log": 3, "payload": `{"msg":"inner"}`, "other": `{"msg":"no"}`}
+	if got := Unwrap(raw, UnwrapOptions{Keys: []string{"log", "payload", "other"}}); got != UnwrapJSON {
+		t.Fatalf("Unwrap = %v, want UnwrapJSON", got)
+	}
+	if raw["msg"] != "inner" || raw["log"] != 3 || raw["other"] == nil {
+		t.Errorf("unexpected record %v", raw)
+	}
+	if got := Unwrap(map[string]any{"msg": "x"}, UnwrapOptions{Keys: []string{"log"}}); got != UnwrapNone {
+		t.Errorf("Unwrap without the key = %v, want UnwrapNone", got)
+	}
+}